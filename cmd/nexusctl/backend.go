@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+
+	"github.com/rickKoch/nexus/internal/segments/port"
+)
+
+// backend performs segment operations either through the REST API or
+// directly against the database.
+type backend interface {
+	ListSegments(ctx context.Context, page, pageSize int, filter listFilter) (port.ListSegmentsResponse, error)
+	GetSegment(ctx context.Context, id int) (port.SegmentResponse, error)
+	CreateSegment(ctx context.Context, req port.CreateSegmentRequest) (port.SegmentResponse, error)
+	UpdateSegment(ctx context.Context, id int, req port.UpdateSegmentRequest) (port.SegmentResponse, error)
+	DeleteSegment(ctx context.Context, id int) error
+	ApplySegments(ctx context.Context, req port.ApplySegmentsRequest) (port.ApplySegmentsResponse, error)
+	TransitionSegment(ctx context.Context, id int, action transition) (port.SegmentResponse, error)
+}
+
+// listFilter narrows down the segments returned by ListSegments.
+type listFilter struct {
+	// Labels are key:value selector expressions that must all match.
+	Labels []string
+	// States are the states a segment may be in; empty allows all.
+	States []string
+	// Live, if set, is the live flag listed segments must have.
+	Live *bool
+}
+
+// transition is a lifecycle action, named after its REST custom method.
+type transition string
+
+const (
+	transitionActivate transition = "activate"
+	transitionPause    transition = "pause"
+	transitionArchive  transition = "archive"
+)
+
+// listAllSegments fetches every page of segments matching filter by
+// following total_pages.
+func listAllSegments(ctx context.Context, b backend, pageSize int, filter listFilter) ([]port.SegmentResponse, error) {
+	var items []port.SegmentResponse
+	for page := 1; ; page++ {
+		resp, err := b.ListSegments(ctx, page, pageSize, filter)
+		if err != nil {
+			return nil, err
+		}
+
+		items = append(items, resp.Items...)
+		if page >= resp.TotalPages || len(resp.Items) == 0 {
+			return items, nil
+		}
+	}
+}
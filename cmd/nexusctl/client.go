@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rickKoch/nexus/internal/segments/port"
+)
+
+// client is a backend talking to the segments REST API.
+type client struct {
+	baseURL    string
+	apiKey     string
+	httpClient *http.Client
+}
+
+func newClient(baseURL, apiKey string, timeout time.Duration) *client {
+	return &client{
+		baseURL:    strings.TrimRight(baseURL, "/") + "/api",
+		apiKey:     apiKey,
+		httpClient: &http.Client{Timeout: timeout},
+	}
+}
+
+// ListSegments fetches a single page of segments matching filter.
+func (c *client) ListSegments(ctx context.Context, page, pageSize int, filter listFilter) (port.ListSegmentsResponse, error) {
+	query := url.Values{}
+	query.Set("page", strconv.Itoa(page))
+	if pageSize > 0 {
+		query.Set("page_size", strconv.Itoa(pageSize))
+	}
+	for _, label := range filter.Labels {
+		query.Add("label", label)
+	}
+	for _, state := range filter.States {
+		query.Add("state", state)
+	}
+	if filter.Live != nil {
+		query.Set("live", strconv.FormatBool(*filter.Live))
+	}
+
+	var resp port.ListSegmentsResponse
+	err := c.do(ctx, http.MethodGet, "/segment?"+query.Encode(), nil, http.StatusOK, &resp)
+	return resp, err
+}
+
+// GetSegment fetches a segment by ID.
+func (c *client) GetSegment(ctx context.Context, id int) (port.SegmentResponse, error) {
+	var resp port.SegmentResponse
+	err := c.do(ctx, http.MethodGet, "/segment/"+strconv.Itoa(id), nil, http.StatusOK, &resp)
+	return resp, err
+}
+
+// CreateSegment creates a new segment.
+func (c *client) CreateSegment(ctx context.Context, req port.CreateSegmentRequest) (port.SegmentResponse, error) {
+	var resp port.SegmentResponse
+	err := c.do(ctx, http.MethodPost, "/segment", req, http.StatusCreated, &resp)
+	return resp, err
+}
+
+// UpdateSegment replaces the mutable fields of a segment.
+func (c *client) UpdateSegment(ctx context.Context, id int, req port.UpdateSegmentRequest) (port.SegmentResponse, error) {
+	var resp port.SegmentResponse
+	err := c.do(ctx, http.MethodPut, "/segment/"+strconv.Itoa(id), req, http.StatusOK, &resp)
+	return resp, err
+}
+
+// DeleteSegment soft-deletes a segment.
+func (c *client) DeleteSegment(ctx context.Context, id int) error {
+	return c.do(ctx, http.MethodDelete, "/segment/"+strconv.Itoa(id), nil, http.StatusNoContent, nil)
+}
+
+// ApplySegments plans and, unless req.DryRun is set, applies a manifest.
+func (c *client) ApplySegments(ctx context.Context, req port.ApplySegmentsRequest) (port.ApplySegmentsResponse, error) {
+	var resp port.ApplySegmentsResponse
+	err := c.do(ctx, http.MethodPost, "/segment:apply", req, http.StatusOK, &resp)
+	return resp, err
+}
+
+// TransitionSegment changes the lifecycle state of a segment.
+func (c *client) TransitionSegment(ctx context.Context, id int, action transition) (port.SegmentResponse, error) {
+	var resp port.SegmentResponse
+	err := c.do(ctx, http.MethodPost, "/segment/"+strconv.Itoa(id)+":"+string(action), nil, http.StatusOK, &resp)
+	return resp, err
+}
+
+func (c *client) do(ctx context.Context, method, path string, body interface{}, expectedStatus int, out interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+		reqBody = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reqBody)
+	if err != nil {
+		return fmt.Errorf("failed to build request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != expectedStatus {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("%s %s: server returned %s: %s", method, path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// stringList is a repeatable string flag.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// labelsFlag is a repeatable flag collecting key=value labels. A value of
+// the form key- removes the label on update and is stored as nil.
+type labelsFlag map[string]*string
+
+func (l labelsFlag) String() string {
+	pairs := make([]string, 0, len(l))
+	for key, value := range l {
+		if value == nil {
+			pairs = append(pairs, key+"-")
+			continue
+		}
+		pairs = append(pairs, key+"="+*value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (l labelsFlag) Set(value string) error {
+	if key, ok := strings.CutSuffix(value, "-"); ok && !strings.Contains(value, "=") {
+		l[key] = nil
+		return nil
+	}
+
+	key, v, ok := strings.Cut(value, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("label must have the form key=value or key-, got '%s'", value)
+	}
+	l[key] = &v
+	return nil
+}
+
+// applyTo returns current with the labels of l set or removed.
+func (l labelsFlag) applyTo(current map[string]string) map[string]string {
+	labels := make(map[string]string, len(current)+len(l))
+	for key, value := range current {
+		labels[key] = value
+	}
+	for key, value := range l {
+		if value == nil {
+			delete(labels, key)
+			continue
+		}
+		labels[key] = *value
+	}
+	return labels
+}
+
+// boolFlag is a boolean flag that is nil until set.
+type boolFlag struct {
+	b *bool
+}
+
+func (f *boolFlag) String() string {
+	if f.b == nil {
+		return ""
+	}
+	return strconv.FormatBool(*f.b)
+}
+
+func (f *boolFlag) Set(value string) error {
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("value must be true or false, got '%s'", value)
+	}
+	f.b = &b
+	return nil
+}
+
+// IsBoolFlag lets the flag be given without a value, meaning true.
+func (f *boolFlag) IsBoolFlag() bool { return true }
+
+// timeFlag is an RFC 3339 timestamp flag. It is nil until set.
+type timeFlag struct {
+	t *time.Time
+}
+
+func (f *timeFlag) String() string {
+	if f.t == nil {
+		return ""
+	}
+	return f.t.Format(time.RFC3339)
+}
+
+func (f *timeFlag) Set(value string) error {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return fmt.Errorf("timestamp must be in RFC 3339 format, e.g. 2026-11-01T00:00:00Z, got '%s'", value)
+	}
+	f.t = &t
+	return nil
+}
+
+// parseTime parses an optional RFC 3339 timestamp returned by the API.
+func parseTime(value *string) (*time.Time, error) {
+	if value == nil {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, *value)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
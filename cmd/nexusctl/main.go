@@ -0,0 +1,379 @@
+// Command nexusctl is an admin CLI for the segments service.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/rickKoch/nexus/internal/segments/port"
+	"github.com/rickKoch/nexus/pkg/config"
+	"github.com/rickKoch/nexus/pkg/signals"
+)
+
+const usage = `usage: nexusctl [flags] <command> [command flags]
+
+Commands:
+  list                  list segments, following all pages unless -page is set;
+                        filter with -label key:value and -state
+  get <id>              show a segment
+  create -name <name>   create a segment
+  update <id>           update a segment's name and/or TTL
+  delete <id>           delete a segment
+  activate <id>         move a draft or paused segment to active
+  pause <id>            pause an active segment
+  archive <id>          archive a segment, making it read-only
+  apply -f <manifest>   sync segments with a YAML or JSON manifest
+
+Flags:
+`
+
+// options holds the global flags shared by all commands.
+type options struct {
+	server  string
+	apiKey  string
+	output  string
+	timeout time.Duration
+	offline bool
+}
+
+func main() {
+	if err := run(signals.Context(), os.Args[1:], os.Stdout, os.Stderr); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("nexusctl", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		_, _ = fmt.Fprint(stderr, usage)
+		fs.PrintDefaults()
+	}
+
+	var opts options
+	fs.StringVar(&opts.server, "server", envOr("NEXUS_SERVER", "http://localhost:8080"), "segments service base URL (env NEXUS_SERVER)")
+	fs.StringVar(&opts.apiKey, "api-key", os.Getenv("NEXUS_API_KEY"), "API key sent as a bearer token (env NEXUS_API_KEY)")
+	fs.StringVar(&opts.output, "o", outputTable, "output format: table, json or yaml")
+	fs.DurationVar(&opts.timeout, "timeout", 30*time.Second, "HTTP request timeout")
+	fs.BoolVar(&opts.offline, "offline", false, "connect directly to the database using the service configuration instead of the REST API")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if err := validateOutput(opts.output); err != nil {
+		return err
+	}
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("no command given")
+	}
+
+	b, err := newBackend(opts)
+	if err != nil {
+		return err
+	}
+
+	cmd, cmdArgs := fs.Arg(0), fs.Args()[1:]
+	switch cmd {
+	case "list":
+		return runList(ctx, b, opts, cmdArgs, stdout)
+	case "get":
+		return runGet(ctx, b, opts, cmdArgs, stdout)
+	case "create":
+		return runCreate(ctx, b, opts, cmdArgs, stdout)
+	case "update":
+		return runUpdate(ctx, b, opts, cmdArgs, stdout)
+	case "delete":
+		return runDelete(ctx, b, cmdArgs, stdout)
+	case "apply":
+		return runApply(ctx, b, opts, cmdArgs, stdout)
+	case "activate":
+		return runTransition(ctx, b, opts, transitionActivate, cmdArgs, stdout)
+	case "pause":
+		return runTransition(ctx, b, opts, transitionPause, cmdArgs, stdout)
+	case "archive":
+		return runTransition(ctx, b, opts, transitionArchive, cmdArgs, stdout)
+	default:
+		fs.Usage()
+		return fmt.Errorf("unknown command '%s'", cmd)
+	}
+}
+
+func newBackend(opts options) (backend, error) {
+	if !opts.offline {
+		return newClient(opts.server, opts.apiKey, opts.timeout), nil
+	}
+
+	cfg, _, err := config.Load(nil)
+	if err != nil {
+		return nil, err
+	}
+	return newOfflineBackend(cfg)
+}
+
+func runList(ctx context.Context, b backend, opts options, args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	page := fs.Int("page", 0, "fetch only this page instead of all pages")
+	pageSize := fs.Int("page-size", 0, "number of segments per request")
+	var filter listFilter
+	fs.Var((*stringList)(&filter.Labels), "label", "only list segments with this key:value label (repeatable)")
+	fs.Var((*stringList)(&filter.States), "state", "only list segments in this state (repeatable)")
+	var live boolFlag
+	fs.Var(&live, "live", "only list live segments, or with -live=false the others")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	filter.Live = live.b
+
+	if *page > 0 {
+		resp, err := b.ListSegments(ctx, *page, *pageSize, filter)
+		if err != nil {
+			return err
+		}
+		if opts.output == outputTable {
+			return printSegments(stdout, opts.output, resp.Items)
+		}
+		return printSegmentPage(stdout, opts.output, resp)
+	}
+
+	items, err := listAllSegments(ctx, b, *pageSize, filter)
+	if err != nil {
+		return err
+	}
+	return printSegments(stdout, opts.output, items)
+}
+
+func runGet(ctx context.Context, b backend, opts options, args []string, stdout io.Writer) error {
+	id, err := parseID(args)
+	if err != nil {
+		return err
+	}
+
+	seg, err := b.GetSegment(ctx, id)
+	if err != nil {
+		return err
+	}
+	return printSegment(stdout, opts.output, seg)
+}
+
+func runCreate(ctx context.Context, b backend, opts options, args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("create", flag.ContinueOnError)
+	name := fs.String("name", "", "segment name")
+	description := fs.String("description", "", "segment description")
+	ttl := fs.Int("ttl", 0, "segment TTL in seconds")
+	state := fs.String("state", "", "initial state, draft or active (default draft)")
+	labels := labelsFlag{}
+	fs.Var(labels, "label", "segment label as key=value (repeatable)")
+	var activeFrom, activeUntil timeFlag
+	fs.Var(&activeFrom, "active-from", "start of the activation window (RFC 3339)")
+	fs.Var(&activeUntil, "active-until", "end of the activation window (RFC 3339)")
+	expression := fs.String("expression", "", "make a composite segment from an expression over segment IDs, e.g. '1 AND NOT 2'")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	req := port.CreateSegmentRequest{
+		Name:        *name,
+		Description: *description,
+		Labels:      labels.applyTo(nil),
+		ActiveFrom:  activeFrom.t,
+		ActiveUntil: activeUntil.t,
+		Expression:  *expression,
+		State:       *state,
+	}
+	if isFlagSet(fs, "ttl") {
+		req.TTLSeconds = ttl
+	}
+
+	seg, err := b.CreateSegment(ctx, req)
+	if err != nil {
+		return err
+	}
+	return printSegment(stdout, opts.output, seg)
+}
+
+func runUpdate(ctx context.Context, b backend, opts options, args []string, stdout io.Writer) error {
+	id, err := parseID(args)
+	if err != nil {
+		return err
+	}
+
+	fs := flag.NewFlagSet("update", flag.ContinueOnError)
+	name := fs.String("name", "", "new segment name")
+	description := fs.String("description", "", "new segment description")
+	ttl := fs.Int("ttl", 0, "new segment TTL in seconds")
+	noTTL := fs.Bool("no-ttl", false, "remove the segment TTL")
+	labels := labelsFlag{}
+	fs.Var(labels, "label", "set a label as key=value, or remove it with key- (repeatable)")
+	var activeFrom, activeUntil timeFlag
+	fs.Var(&activeFrom, "active-from", "new start of the activation window (RFC 3339)")
+	fs.Var(&activeUntil, "active-until", "new end of the activation window (RFC 3339)")
+	noWindow := fs.Bool("no-window", false, "remove the activation window")
+	expression := fs.String("expression", "", "new expression over segment IDs")
+	noExpression := fs.Bool("no-expression", false, "remove the expression, making the segment a regular segment")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if *noTTL && isFlagSet(fs, "ttl") {
+		return errors.New("-ttl and -no-ttl are mutually exclusive")
+	}
+	if *noWindow && (activeFrom.t != nil || activeUntil.t != nil) {
+		return errors.New("-active-from and -active-until cannot be combined with -no-window")
+	}
+	if *noExpression && isFlagSet(fs, "expression") {
+		return errors.New("-expression and -no-expression are mutually exclusive")
+	}
+
+	// PUT replaces the segment, so start from its current state and only
+	// change what was asked for.
+	current, err := b.GetSegment(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	req := port.UpdateSegmentRequest{
+		Name:        current.Name,
+		Description: current.Description,
+		Labels:      labels.applyTo(current.Labels),
+		TTLSeconds:  current.TTLSeconds,
+		Expression:  current.Expression,
+	}
+	if req.ActiveFrom, err = parseTime(current.ActiveFrom); err != nil {
+		return fmt.Errorf("invalid active_from in segment %d: %w", id, err)
+	}
+	if req.ActiveUntil, err = parseTime(current.ActiveUntil); err != nil {
+		return fmt.Errorf("invalid active_until in segment %d: %w", id, err)
+	}
+	if isFlagSet(fs, "name") {
+		req.Name = *name
+	}
+	if isFlagSet(fs, "description") {
+		req.Description = *description
+	}
+	if isFlagSet(fs, "ttl") {
+		req.TTLSeconds = ttl
+	}
+	if *noTTL {
+		req.TTLSeconds = nil
+	}
+	if activeFrom.t != nil {
+		req.ActiveFrom = activeFrom.t
+	}
+	if activeUntil.t != nil {
+		req.ActiveUntil = activeUntil.t
+	}
+	if *noWindow {
+		req.ActiveFrom, req.ActiveUntil = nil, nil
+	}
+	if isFlagSet(fs, "expression") {
+		req.Expression = *expression
+	}
+	if *noExpression {
+		req.Expression = ""
+	}
+
+	seg, err := b.UpdateSegment(ctx, id, req)
+	if err != nil {
+		return err
+	}
+	return printSegment(stdout, opts.output, seg)
+}
+
+func runDelete(ctx context.Context, b backend, args []string, stdout io.Writer) error {
+	id, err := parseID(args)
+	if err != nil {
+		return err
+	}
+
+	if err := b.DeleteSegment(ctx, id); err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(stdout, "segment %d deleted\n", id)
+	return nil
+}
+
+func runTransition(ctx context.Context, b backend, opts options, action transition, args []string, stdout io.Writer) error {
+	id, err := parseID(args)
+	if err != nil {
+		return err
+	}
+
+	seg, err := b.TransitionSegment(ctx, id, action)
+	if err != nil {
+		return err
+	}
+	return printSegment(stdout, opts.output, seg)
+}
+
+func runApply(ctx context.Context, b backend, opts options, args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("apply", flag.ContinueOnError)
+	file := fs.String("f", "", "manifest file (.yaml, .yml or .json)")
+	prune := fs.Bool("prune", false, "delete segments that are not in the manifest")
+	dryRun := fs.Bool("dry-run", false, "print the plan without applying it")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *file == "" {
+		return errors.New("manifest file is required (-f)")
+	}
+
+	req, err := readManifest(*file)
+	if err != nil {
+		return err
+	}
+	// Flags only ever turn these on, so a manifest can opt into pruning by
+	// itself but a reviewer can always force a dry run.
+	req.Prune = req.Prune || *prune
+	req.DryRun = req.DryRun || *dryRun
+
+	resp, err := b.ApplySegments(ctx, req)
+	if err != nil {
+		return err
+	}
+	return printApply(stdout, opts.output, resp)
+}
+
+func printSegmentPage(w io.Writer, format string, resp port.ListSegmentsResponse) error {
+	if format == outputJSON {
+		return printJSON(w, resp)
+	}
+	return printYAML(w, resp)
+}
+
+func parseID(args []string) (int, error) {
+	if len(args) == 0 {
+		return 0, errors.New("segment ID is required")
+	}
+
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		return 0, fmt.Errorf("invalid segment ID '%s'", args[0])
+	}
+	return id, nil
+}
+
+func isFlagSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+func envOr(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
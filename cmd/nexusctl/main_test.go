@@ -0,0 +1,450 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rickKoch/nexus/internal/segments/adapters"
+	"github.com/rickKoch/nexus/internal/segments/app"
+	"github.com/rickKoch/nexus/internal/segments/app/segments"
+	"github.com/rickKoch/nexus/internal/segments/port"
+	"github.com/rickKoch/nexus/internal/segments/service"
+	"github.com/rickKoch/nexus/pkg/clock"
+	"github.com/rickKoch/nexus/pkg/config"
+	"github.com/rickKoch/nexus/pkg/server"
+)
+
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	repo := adapters.NewInMemorySegmentRepository()
+	seg, err := app.NewSegments(repo, segments.DefaultPagination(), clock.System, repo)
+	if err != nil {
+		t.Fatalf("failed to create application: %v", err)
+	}
+
+	handler, err := port.NewHandler(app.Application{Segments: seg}, server.OpenAPIValidatorOptions{ValidateResponses: true})
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+
+	root := chi.NewRouter()
+	root.Mount("/api", handler)
+
+	srv := httptest.NewServer(root)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func runCommand(t *testing.T, srv *httptest.Server, args ...string) (string, error) {
+	t.Helper()
+
+	var stdout, stderr strings.Builder
+	err := run(context.Background(), append([]string{"-server", srv.URL}, args...), &stdout, &stderr)
+	return stdout.String(), err
+}
+
+func TestRun(t *testing.T) {
+	srv := newTestServer(t)
+
+	t.Run("creates a segment", func(t *testing.T) {
+		out, err := runCommand(t, srv, "-o", "json", "create", "-name", "premium-users", "-ttl", "3600")
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		var seg port.SegmentResponse
+		if err := json.Unmarshal([]byte(out), &seg); err != nil {
+			t.Fatalf("failed to decode output: %v", err)
+		}
+		if seg.Name != "premium-users" || seg.TTLSeconds == nil || *seg.TTLSeconds != 3600 {
+			t.Errorf("unexpected segment %+v", seg)
+		}
+	})
+
+	t.Run("update keeps unspecified fields", func(t *testing.T) {
+		out, err := runCommand(t, srv, "-o", "json", "update", "1", "-name", "vip-users")
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		var seg port.SegmentResponse
+		if err := json.Unmarshal([]byte(out), &seg); err != nil {
+			t.Fatalf("failed to decode output: %v", err)
+		}
+		if seg.Name != "vip-users" {
+			t.Errorf("expected name 'vip-users', got '%s'", seg.Name)
+		}
+		if seg.TTLSeconds == nil || *seg.TTLSeconds != 3600 {
+			t.Errorf("expected TTL to be kept, got %v", seg.TTLSeconds)
+		}
+	})
+
+	t.Run("list follows all pages", func(t *testing.T) {
+		for i := 0; i < 4; i++ {
+			if _, err := runCommand(t, srv, "create", "-name", "paged"); err != nil {
+				t.Fatalf("failed to create segment: %v", err)
+			}
+		}
+
+		out, err := runCommand(t, srv, "-o", "json", "list", "-page-size", "2")
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		var items []port.SegmentResponse
+		if err := json.Unmarshal([]byte(out), &items); err != nil {
+			t.Fatalf("failed to decode output: %v", err)
+		}
+		if len(items) != 5 {
+			t.Errorf("expected 5 segments, got %d", len(items))
+		}
+	})
+
+	t.Run("list fetches a single page", func(t *testing.T) {
+		out, err := runCommand(t, srv, "-o", "json", "list", "-page", "2", "-page-size", "2")
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		var resp port.ListSegmentsResponse
+		if err := json.Unmarshal([]byte(out), &resp); err != nil {
+			t.Fatalf("failed to decode output: %v", err)
+		}
+		if resp.Page != 2 || len(resp.Items) != 2 || resp.TotalPages != 3 {
+			t.Errorf("unexpected page %+v", resp)
+		}
+	})
+
+	t.Run("renders YAML with API field names", func(t *testing.T) {
+		out, err := runCommand(t, srv, "-o", "yaml", "get", "1")
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if !strings.Contains(out, "name: vip-users") || !strings.Contains(out, "ttl_seconds: 3600") {
+			t.Errorf("unexpected YAML output:\n%s", out)
+		}
+	})
+
+	t.Run("manages labels", func(t *testing.T) {
+		if _, err := runCommand(t, srv, "create", "-name", "growth", "-label", "team=growth", "-label", "tier=gold"); err != nil {
+			t.Fatalf("failed to create segment: %v", err)
+		}
+
+		out, err := runCommand(t, srv, "-o", "json", "list", "-label", "team:growth")
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		var items []port.SegmentResponse
+		if err := json.Unmarshal([]byte(out), &items); err != nil {
+			t.Fatalf("failed to decode output: %v", err)
+		}
+		if len(items) != 1 || items[0].Name != "growth" {
+			t.Fatalf("expected only the labelled segment, got %+v", items)
+		}
+
+		out, err = runCommand(t, srv, "-o", "json", "update", strconv.Itoa(items[0].ID), "-label", "tier-", "-label", "region=eu")
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		var seg port.SegmentResponse
+		if err := json.Unmarshal([]byte(out), &seg); err != nil {
+			t.Fatalf("failed to decode output: %v", err)
+		}
+		if len(seg.Labels) != 2 || seg.Labels["team"] != "growth" || seg.Labels["region"] != "eu" {
+			t.Errorf("expected labels to be merged, got %v", seg.Labels)
+		}
+	})
+
+	t.Run("manages lifecycle states", func(t *testing.T) {
+		out, err := runCommand(t, srv, "-o", "json", "create", "-name", "seasonal")
+		if err != nil {
+			t.Fatalf("failed to create segment: %v", err)
+		}
+		var seg port.SegmentResponse
+		if err := json.Unmarshal([]byte(out), &seg); err != nil {
+			t.Fatalf("failed to decode output: %v", err)
+		}
+		if seg.State != "draft" {
+			t.Errorf("expected draft segment, got %s", seg.State)
+		}
+		id := strconv.Itoa(seg.ID)
+
+		if _, err := runCommand(t, srv, "pause", id); err == nil {
+			t.Error("expected error when pausing a draft segment")
+		}
+
+		for _, step := range []struct{ command, state string }{{"activate", "active"}, {"pause", "paused"}} {
+			out, err := runCommand(t, srv, "-o", "json", step.command, id)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if err := json.Unmarshal([]byte(out), &seg); err != nil {
+				t.Fatalf("failed to decode output: %v", err)
+			}
+			if seg.State != step.state {
+				t.Errorf("expected state %s after %s, got %s", step.state, step.command, seg.State)
+			}
+		}
+
+		out, err = runCommand(t, srv, "-o", "json", "list", "-state", "paused")
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		var items []port.SegmentResponse
+		if err := json.Unmarshal([]byte(out), &items); err != nil {
+			t.Fatalf("failed to decode output: %v", err)
+		}
+		if len(items) != 1 || items[0].ID != seg.ID {
+			t.Errorf("expected only the paused segment, got %+v", items)
+		}
+
+		out, err = runCommand(t, srv, "-o", "json", "list", "-live")
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		items = nil
+		if err := json.Unmarshal([]byte(out), &items); err != nil {
+			t.Fatalf("failed to decode output: %v", err)
+		}
+		for _, item := range items {
+			if item.ID == seg.ID || !item.Live {
+				t.Errorf("expected only live segments, got %+v", item)
+			}
+		}
+
+		if _, err := runCommand(t, srv, "archive", id); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if _, err := runCommand(t, srv, "update", id, "-name", "renamed"); err == nil {
+			t.Error("expected error when updating an archived segment")
+		}
+	})
+
+	t.Run("manages activation windows", func(t *testing.T) {
+		out, err := runCommand(t, srv, "-o", "json", "create", "-name", "campaign",
+			"-active-from", "2026-11-01T00:00:00Z", "-active-until", "2026-12-01T00:00:00Z")
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		var seg port.SegmentResponse
+		if err := json.Unmarshal([]byte(out), &seg); err != nil {
+			t.Fatalf("failed to decode output: %v", err)
+		}
+		id := strconv.Itoa(seg.ID)
+
+		out, err = runCommand(t, srv, "-o", "json", "update", id, "-active-until", "2026-11-15T00:00:00Z")
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if err := json.Unmarshal([]byte(out), &seg); err != nil {
+			t.Fatalf("failed to decode output: %v", err)
+		}
+		if seg.ActiveFrom == nil || *seg.ActiveFrom != "2026-11-01T00:00:00Z" || seg.ActiveUntil == nil || *seg.ActiveUntil != "2026-11-15T00:00:00Z" {
+			t.Errorf("expected window to be merged, got %v..%v", seg.ActiveFrom, seg.ActiveUntil)
+		}
+
+		out, err = runCommand(t, srv, "-o", "json", "update", id, "-no-window")
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		seg = port.SegmentResponse{}
+		if err := json.Unmarshal([]byte(out), &seg); err != nil {
+			t.Fatalf("failed to decode output: %v", err)
+		}
+		if seg.ActiveFrom != nil || seg.ActiveUntil != nil {
+			t.Errorf("expected window to be removed, got %v..%v", seg.ActiveFrom, seg.ActiveUntil)
+		}
+
+		if _, err := runCommand(t, srv, "create", "-name", "x", "-active-from", "next week"); err == nil {
+			t.Error("expected error for malformed timestamp")
+		}
+	})
+
+	t.Run("deletes a segment", func(t *testing.T) {
+		if _, err := runCommand(t, srv, "delete", "1"); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if _, err := runCommand(t, srv, "get", "1"); err == nil {
+			t.Error("expected error when getting deleted segment")
+		}
+	})
+
+	t.Run("fails with unknown command", func(t *testing.T) {
+		if _, err := runCommand(t, srv, "explode"); err == nil {
+			t.Error("expected error for unknown command")
+		}
+	})
+}
+
+func TestApply(t *testing.T) {
+	srv := newTestServer(t)
+
+	if _, err := runCommand(t, srv, "create", "-name", "legacy"); err != nil {
+		t.Fatalf("failed to create segment: %v", err)
+	}
+
+	dir := t.TempDir()
+	manifest := filepath.Join(dir, "segments.yaml")
+	content := "segments:\n  - name: premium-users\n    ttl_seconds: 3600\n  - name: all-users\n"
+	if err := os.WriteFile(manifest, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write manifest: %v", err)
+	}
+
+	t.Run("prints the plan without applying it", func(t *testing.T) {
+		out, err := runCommand(t, srv, "apply", "-f", manifest, "-prune", "-dry-run")
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if !strings.Contains(out, "Plan (dry run): 2 to create, 0 to update, 1 to delete") {
+			t.Errorf("unexpected plan:\n%s", out)
+		}
+
+		list, _ := runCommand(t, srv, "-o", "json", "list")
+		var items []port.SegmentResponse
+		_ = json.Unmarshal([]byte(list), &items)
+		if len(items) != 1 {
+			t.Errorf("expected dry run to leave 1 segment, got %d", len(items))
+		}
+	})
+
+	t.Run("applies the manifest", func(t *testing.T) {
+		out, err := runCommand(t, srv, "-o", "json", "apply", "-f", manifest, "-prune")
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		var resp port.ApplySegmentsResponse
+		if err := json.Unmarshal([]byte(out), &resp); err != nil {
+			t.Fatalf("failed to decode output: %v", err)
+		}
+		if resp.DryRun || resp.Summary.Create != 2 || resp.Summary.Delete != 1 {
+			t.Errorf("unexpected result %+v", resp.Summary)
+		}
+	})
+
+	t.Run("is idempotent", func(t *testing.T) {
+		out, err := runCommand(t, srv, "apply", "-f", manifest, "-prune")
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if !strings.Contains(out, "Applied: 0 to create, 0 to update, 0 to delete, 2 unchanged") {
+			t.Errorf("unexpected output:\n%s", out)
+		}
+	})
+
+	t.Run("rejects unknown manifest fields", func(t *testing.T) {
+		bad := filepath.Join(dir, "bad.json")
+		if err := os.WriteFile(bad, []byte(`{"segments": [{"name": "x", "ttl": 10}]}`), 0o600); err != nil {
+			t.Fatalf("failed to write manifest: %v", err)
+		}
+
+		if _, err := runCommand(t, srv, "apply", "-f", bad); err == nil {
+			t.Error("expected error for unknown field")
+		}
+	})
+}
+
+func TestOffline(t *testing.T) {
+	runOffline := func(t *testing.T, args ...string) (string, error) {
+		t.Helper()
+
+		var stdout, stderr strings.Builder
+		err := run(context.Background(), append([]string{"-offline", "-o", "json"}, args...), &stdout, &stderr)
+		return stdout.String(), err
+	}
+
+	// migrateSQLite configures a new SQLite database and applies the
+	// migrations to it.
+	migrateSQLite := func(t *testing.T) config.Config {
+		t.Helper()
+
+		t.Setenv("STORAGE_DRIVER", "sqlite")
+		t.Setenv("SQLITE_PATH", filepath.Join(t.TempDir(), "nexus.db"))
+		cfg, _, err := config.Load(nil)
+		if err != nil {
+			t.Fatalf("failed to load config: %v", err)
+		}
+		m, db, err := service.NewMigrator(cfg)
+		if err != nil {
+			t.Fatalf("failed to create migrator: %v", err)
+		}
+		defer func() { _ = db.Close() }()
+		if _, err := m.Up(context.Background()); err != nil {
+			t.Fatalf("failed to apply migrations: %v", err)
+		}
+		return cfg
+	}
+
+	t.Run("uses the SQLite database", func(t *testing.T) {
+		migrateSQLite(t)
+
+		if _, err := runOffline(t, "create", "-name", "premium-users"); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		out, err := runOffline(t, "list")
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		var items []port.SegmentResponse
+		if err := json.Unmarshal([]byte(out), &items); err != nil {
+			t.Fatalf("failed to decode output: %v", err)
+		}
+		if len(items) != 1 || items[0].Name != "premium-users" {
+			t.Errorf("expected the created segment, got %+v", items)
+		}
+	})
+
+	t.Run("records events with event sourcing", func(t *testing.T) {
+		t.Setenv("SEGMENTS_EVENT_SOURCING", "true")
+		cfg := migrateSQLite(t)
+
+		out, err := runOffline(t, "create", "-name", "premium-users")
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		var seg port.SegmentResponse
+		if err := json.Unmarshal([]byte(out), &seg); err != nil {
+			t.Fatalf("failed to decode output: %v", err)
+		}
+		id := strconv.Itoa(seg.ID)
+		if _, err := runOffline(t, "update", id, "-name", "vip-users"); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if _, err := runOffline(t, "delete", id); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		repo, db, err := service.OpenDatabaseRepository(cfg)
+		if err != nil {
+			t.Fatalf("failed to open database: %v", err)
+		}
+		defer func() { _ = db.Close() }()
+		events, err := repo.LoadEvents(context.Background(), seg.ID, 0, nil)
+		if err != nil {
+			t.Fatalf("failed to load events: %v", err)
+		}
+		if len(events) != 3 {
+			t.Errorf("expected the creation, update and deletion as events, got %+v", events)
+		}
+	})
+
+	t.Run("rejects the memory driver", func(t *testing.T) {
+		t.Setenv("STORAGE_DRIVER", "memory")
+
+		if _, err := runOffline(t, "list"); err == nil || !strings.Contains(err.Error(), "memory storage driver") {
+			t.Errorf("expected the memory driver to be rejected, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/rickKoch/nexus/internal/segments/port"
+	"gopkg.in/yaml.v3"
+)
+
+// readManifest reads a segment manifest from path. JSON is a subset of YAML,
+// so both formats go through the YAML decoder. Unknown fields are rejected so
+// that typos do not silently drop settings.
+func readManifest(path string) (port.ApplySegmentsRequest, error) {
+	var req port.ApplySegmentsRequest
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return req, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&req); err != nil {
+		if errors.Is(err, io.EOF) {
+			return req, fmt.Errorf("manifest '%s' is empty", path)
+		}
+		return req, fmt.Errorf("failed to parse manifest '%s': %w", path, err)
+	}
+
+	// The API rejects a missing list; an empty one means "no segments".
+	if req.Segments == nil {
+		req.Segments = []port.ManifestSegment{}
+	}
+
+	return req, nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/rickKoch/nexus/internal/segments/app"
+	"github.com/rickKoch/nexus/internal/segments/app/segments"
+	"github.com/rickKoch/nexus/internal/segments/domain/segment"
+	"github.com/rickKoch/nexus/internal/segments/port"
+	"github.com/rickKoch/nexus/internal/segments/service"
+	"github.com/rickKoch/nexus/pkg/clock"
+	"github.com/rickKoch/nexus/pkg/config"
+)
+
+// offlineBackend bypasses the REST API and runs the application handlers
+// directly on top of the database of the configured storage driver, for
+// break-glass operations when the service is unavailable.
+type offlineBackend struct {
+	app app.Application
+}
+
+func newOfflineBackend(cfg config.Config) (*offlineBackend, error) {
+	if cfg.Storage.Driver == config.StorageDriverMemory {
+		return nil, errors.New("offline mode needs a database, but the memory storage driver keeps segments in the service process")
+	}
+
+	// One command at a time needs a single connection, and it reads its own
+	// writes, so it skips the replicas.
+	cfg.Postgres.MaxOpenConns = 1
+	cfg.Postgres.MaxIdleConns = 1
+	cfg.Postgres.Replicas = nil
+	repo, _, err := service.OpenDatabaseRepository(cfg)
+	if err != nil {
+		return nil, err
+	}
+	// Changes are recorded as events like the service does, so that the
+	// history of segments has no gaps.
+	projection, _ := service.WithEventSourcing(cfg, repo)
+
+	seg, err := app.NewSegments(projection, segments.Pagination{
+		DefaultPageSize: cfg.Segments.DefaultPageSize,
+		MaxPageSize:     cfg.Segments.MaxPageSize,
+	}, clock.System, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	return &offlineBackend{app: app.Application{Segments: seg}}, nil
+}
+
+// ListSegments fetches a single page of segments matching filter.
+func (b *offlineBackend) ListSegments(ctx context.Context, page, pageSize int, filter listFilter) (port.ListSegmentsResponse, error) {
+	cmd := segments.ListSegments{Page: page, PageSize: pageSize, Labels: filter.Labels, Live: filter.Live}
+	for _, state := range filter.States {
+		cmd.States = append(cmd.States, segment.State(state))
+	}
+
+	result, err := b.app.Segments.ListSegments.Handle(ctx, cmd)
+	if err != nil {
+		return port.ListSegmentsResponse{}, err
+	}
+
+	items := make([]port.SegmentResponse, 0, len(result.Segments))
+	for i := range result.Segments {
+		items = append(items, port.ToSegmentResponse(&result.Segments[i], b.app.Segments.Clock.Now()))
+	}
+
+	return port.ListSegmentsResponse{
+		Items:      items,
+		TotalCount: result.TotalCount,
+		Page:       result.Page,
+		PageSize:   result.PageSize,
+		TotalPages: result.TotalPages,
+	}, nil
+}
+
+// GetSegment fetches a segment by ID.
+func (b *offlineBackend) GetSegment(ctx context.Context, id int) (port.SegmentResponse, error) {
+	seg, err := b.app.Segments.GetSegment.Handle(ctx, segments.GetSegment{ID: id})
+	if err != nil {
+		return port.SegmentResponse{}, err
+	}
+	return port.ToSegmentResponse(seg, b.app.Segments.Clock.Now()), nil
+}
+
+// CreateSegment creates a new segment.
+func (b *offlineBackend) CreateSegment(ctx context.Context, req port.CreateSegmentRequest) (port.SegmentResponse, error) {
+	seg, err := b.app.Segments.CreateSegment.Handle(ctx, segments.CreateSegment{
+		Name:        req.Name,
+		Description: req.Description,
+		Labels:      req.Labels,
+		TTLSeconds:  req.TTLSeconds,
+		ActiveFrom:  req.ActiveFrom,
+		ActiveUntil: req.ActiveUntil,
+		Expression:  req.Expression,
+		State:       segment.State(req.State),
+	})
+	if err != nil {
+		return port.SegmentResponse{}, err
+	}
+	return port.ToSegmentResponse(seg, b.app.Segments.Clock.Now()), nil
+}
+
+// UpdateSegment replaces the mutable fields of a segment.
+func (b *offlineBackend) UpdateSegment(ctx context.Context, id int, req port.UpdateSegmentRequest) (port.SegmentResponse, error) {
+	seg, err := b.app.Segments.UpdateSegment.Handle(ctx, segments.UpdateSegment{
+		ID:          id,
+		Name:        req.Name,
+		Description: req.Description,
+		Labels:      req.Labels,
+		TTLSeconds:  req.TTLSeconds,
+		ActiveFrom:  req.ActiveFrom,
+		ActiveUntil: req.ActiveUntil,
+		Expression:  req.Expression,
+	})
+	if err != nil {
+		return port.SegmentResponse{}, err
+	}
+	return port.ToSegmentResponse(seg, b.app.Segments.Clock.Now()), nil
+}
+
+// DeleteSegment soft-deletes a segment.
+func (b *offlineBackend) DeleteSegment(ctx context.Context, id int) error {
+	return b.app.Segments.DeleteSegment.Handle(ctx, segments.DeleteSegment{ID: id})
+}
+
+// TransitionSegment changes the lifecycle state of a segment.
+func (b *offlineBackend) TransitionSegment(ctx context.Context, id int, action transition) (port.SegmentResponse, error) {
+	states := map[transition]segment.State{
+		transitionActivate: segment.StateActive,
+		transitionPause:    segment.StatePaused,
+		transitionArchive:  segment.StateArchived,
+	}
+
+	seg, err := b.app.Segments.TransitionSegment.Handle(ctx, segments.TransitionSegment{ID: id, State: states[action]})
+	if err != nil {
+		return port.SegmentResponse{}, err
+	}
+	return port.ToSegmentResponse(seg, b.app.Segments.Clock.Now()), nil
+}
+
+// ApplySegments plans and, unless req.DryRun is set, applies a manifest.
+func (b *offlineBackend) ApplySegments(ctx context.Context, req port.ApplySegmentsRequest) (port.ApplySegmentsResponse, error) {
+	cmd := segments.ApplySegments{Prune: req.Prune, DryRun: req.DryRun}
+	for _, s := range req.Segments {
+		cmd.Segments = append(cmd.Segments, segments.DesiredSegment{
+			Name:        s.Name,
+			Description: s.Description,
+			Labels:      s.Labels,
+			TTLSeconds:  s.TTLSeconds,
+			ActiveFrom:  s.ActiveFrom,
+			ActiveUntil: s.ActiveUntil,
+			Expression:  s.Expression,
+		})
+	}
+
+	result, err := b.app.Segments.ApplySegments.Handle(ctx, cmd)
+	if err != nil {
+		return port.ApplySegmentsResponse{}, err
+	}
+	return port.ToApplySegmentsResponse(result, b.app.Segments.Clock.Now()), nil
+}
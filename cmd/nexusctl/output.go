@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/rickKoch/nexus/internal/segments/port"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+)
+
+func validateOutput(format string) error {
+	switch format {
+	case outputTable, outputJSON, outputYAML:
+		return nil
+	default:
+		return fmt.Errorf("unknown output format '%s', expected table, json or yaml", format)
+	}
+}
+
+// printSegments writes segments to w in the given format.
+func printSegments(w io.Writer, format string, items []port.SegmentResponse) error {
+	switch format {
+	case outputJSON:
+		return printJSON(w, items)
+	case outputYAML:
+		return printYAML(w, items)
+	default:
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		_, _ = fmt.Fprintln(tw, "ID\tNAME\tSTATE\tLIVE\tWINDOW\tEXPRESSION\tTTL\tLABELS\tCREATED AT\tUPDATED AT")
+		for _, s := range items {
+			_, _ = fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", s.ID, s.Name, s.State, formatLive(s.Live), formatWindow(s.ActiveFrom, s.ActiveUntil),
+				formatExpression(s.Expression), formatTTL(s.TTLSeconds), formatLabels(s.Labels), s.CreatedAt, s.UpdatedAt)
+		}
+		return tw.Flush()
+	}
+}
+
+// printSegment writes a single segment to w in the given format.
+func printSegment(w io.Writer, format string, s port.SegmentResponse) error {
+	switch format {
+	case outputJSON:
+		return printJSON(w, s)
+	case outputYAML:
+		return printYAML(w, s)
+	default:
+		return printSegments(w, format, []port.SegmentResponse{s})
+	}
+}
+
+func printJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// printYAML goes through JSON so the output uses the API field names and order.
+func printYAML(w io.Writer, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	var node yaml.Node
+	if err := yaml.Unmarshal(data, &node); err != nil {
+		return err
+	}
+
+	clearStyle(&node)
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(&node); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// clearStyle drops the flow and quoting styles inherited from the JSON input
+// so the document is rendered in block style.
+func clearStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		clearStyle(child)
+	}
+}
+
+// printApply writes an apply plan to w in the given format.
+func printApply(w io.Writer, format string, resp port.ApplySegmentsResponse) error {
+	switch format {
+	case outputJSON:
+		return printJSON(w, resp)
+	case outputYAML:
+		return printYAML(w, resp)
+	}
+
+	if len(resp.Changes) > 0 {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		_, _ = fmt.Fprintln(tw, "ACTION\tID\tNAME\tTTL")
+		for _, c := range resp.Changes {
+			id, ttl := "-", "-"
+			if c.Desired != nil {
+				ttl = formatTTL(c.Desired.TTLSeconds)
+			}
+			if c.Current != nil {
+				id = strconv.Itoa(c.Current.ID)
+			}
+			if c.Segment != nil {
+				id = strconv.Itoa(c.Segment.ID)
+			}
+			if c.Action == "update" {
+				ttl = formatTTL(c.Current.TTLSeconds) + " -> " + ttl
+			}
+			_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", c.Action, id, c.Name, ttl)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	s := resp.Summary
+	verb := "Applied"
+	if resp.DryRun {
+		verb = "Plan (dry run)"
+	}
+	_, err := fmt.Fprintf(w, "%s: %d to create, %d to update, %d to delete, %d unchanged, %d unmanaged.\n",
+		verb, s.Create, s.Update, s.Delete, s.Unchanged, s.Unmanaged)
+	return err
+}
+
+func formatLive(live bool) string {
+	if live {
+		return "yes"
+	}
+	return "no"
+}
+
+// formatWindow renders an activation window as from..until, leaving open
+// ends blank, or - when there is no window.
+func formatWindow(from, until *string) string {
+	if from == nil && until == nil {
+		return "-"
+	}
+	var b strings.Builder
+	if from != nil {
+		b.WriteString(*from)
+	}
+	b.WriteString("..")
+	if until != nil {
+		b.WriteString(*until)
+	}
+	return b.String()
+}
+
+// formatExpression renders the expression of a composite segment, or - for
+// a regular segment.
+func formatExpression(expr string) string {
+	if expr == "" {
+		return "-"
+	}
+	return expr
+}
+
+// formatLabels renders labels as sorted key=value pairs.
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return "-"
+	}
+
+	pairs := make([]string, 0, len(labels))
+	for key, value := range labels {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func formatTTL(ttl *int) string {
+	if ttl == nil {
+		return "-"
+	}
+	return strconv.Itoa(*ttl)
+}
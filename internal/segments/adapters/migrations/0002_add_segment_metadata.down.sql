@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS segments_labels_idx;
+
+ALTER TABLE segments
+  DROP COLUMN labels,
+  DROP COLUMN description;
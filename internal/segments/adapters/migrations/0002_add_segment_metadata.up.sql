@@ -0,0 +1,6 @@
+ALTER TABLE segments
+  ADD COLUMN description TEXT NOT NULL DEFAULT '',
+  ADD COLUMN labels JSONB NOT NULL DEFAULT '{}';
+
+-- Supports label selectors (labels @> '{"team": "growth"}').
+CREATE INDEX segments_labels_idx ON segments USING GIN (labels);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS segments_state_idx;
+
+ALTER TABLE segments DROP COLUMN state;
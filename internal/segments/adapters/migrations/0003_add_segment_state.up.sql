@@ -0,0 +1,8 @@
+-- Segments that existed before lifecycle states were introduced are live.
+ALTER TABLE segments
+  ADD COLUMN state TEXT NOT NULL DEFAULT 'active'
+    CONSTRAINT segments_state_check CHECK (state IN ('draft', 'active', 'paused', 'archived'));
+
+ALTER TABLE segments ALTER COLUMN state SET DEFAULT 'draft';
+
+CREATE INDEX segments_state_idx ON segments (state) WHERE deleted_at IS NULL;
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS segments_active_until_idx;
+DROP INDEX IF EXISTS segments_active_from_idx;
+
+ALTER TABLE segments
+  DROP CONSTRAINT segments_activation_window_check,
+  DROP COLUMN active_until,
+  DROP COLUMN active_from;
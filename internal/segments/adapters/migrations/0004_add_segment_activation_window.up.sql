@@ -0,0 +1,8 @@
+ALTER TABLE segments
+  ADD COLUMN active_from TIMESTAMP,
+  ADD COLUMN active_until TIMESTAMP,
+  ADD CONSTRAINT segments_activation_window_check CHECK (active_from < active_until);
+
+-- The scheduler looks up segments by the window boundaries it has crossed.
+CREATE INDEX segments_active_from_idx ON segments (active_from) WHERE deleted_at IS NULL AND active_from IS NOT NULL;
+CREATE INDEX segments_active_until_idx ON segments (active_until) WHERE deleted_at IS NULL AND active_until IS NOT NULL;
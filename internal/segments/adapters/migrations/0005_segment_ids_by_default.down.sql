@@ -0,0 +1 @@
+ALTER TABLE segments ALTER COLUMN id SET GENERATED ALWAYS;
@@ -0,0 +1,3 @@
+-- Segment IDs are reserved by the application (see segment.IDGenerator)
+-- and inserted explicitly.
+ALTER TABLE segments ALTER COLUMN id SET GENERATED BY DEFAULT;
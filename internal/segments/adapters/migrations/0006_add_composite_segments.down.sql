@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS segments_referenced_ids_idx;
+
+ALTER TABLE segments
+  DROP COLUMN referenced_ids,
+  DROP COLUMN expression;
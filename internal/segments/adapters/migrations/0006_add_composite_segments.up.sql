@@ -0,0 +1,8 @@
+-- Composite segments are defined by an expression over other segment IDs.
+-- referenced_ids lists those IDs so that referencing segments can be found
+-- before one of them is deleted.
+ALTER TABLE segments
+  ADD COLUMN expression TEXT,
+  ADD COLUMN referenced_ids INT[] NOT NULL DEFAULT '{}';
+
+CREATE INDEX segments_referenced_ids_idx ON segments USING GIN (referenced_ids);
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS segment_members;
@@ -0,0 +1,10 @@
+-- The primary key doubles as the index exports read members in order from.
+-- Member IDs compare bytewise so that the order, and with it the cursor an
+-- export resumes from, does not depend on the database locale.
+CREATE TABLE segment_members (
+  segment_id INT NOT NULL REFERENCES segments (id),
+  member_id TEXT COLLATE "C" NOT NULL,
+  added_at TIMESTAMP NOT NULL,
+  expires_at TIMESTAMP,
+  PRIMARY KEY (segment_id, member_id)
+);
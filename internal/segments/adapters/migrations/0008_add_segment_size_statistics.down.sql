@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS segment_size_history;
+
+ALTER TABLE segments DROP COLUMN member_count;
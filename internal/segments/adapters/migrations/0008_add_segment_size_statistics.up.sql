@@ -0,0 +1,14 @@
+-- member_count is maintained as memberships are added, removed and purged so
+-- that reading a segment's size does not count its members.
+ALTER TABLE segments ADD COLUMN member_count INT NOT NULL DEFAULT 0;
+
+UPDATE segments
+SET member_count = (SELECT count(*) FROM segment_members WHERE segment_id = segments.id);
+
+-- One row per regular segment and UTC day, written by the size history job.
+CREATE TABLE segment_size_history (
+  segment_id INT NOT NULL REFERENCES segments (id),
+  day DATE NOT NULL,
+  member_count INT NOT NULL,
+  PRIMARY KEY (segment_id, day)
+);
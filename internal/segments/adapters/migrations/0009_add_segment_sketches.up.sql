@@ -0,0 +1,4 @@
+-- HyperLogLog sketch of the members of a regular segment. NULL when it must
+-- be rebuilt from segment_members, as for every segment existing before this
+-- migration.
+ALTER TABLE segments ADD COLUMN sketch BYTEA;
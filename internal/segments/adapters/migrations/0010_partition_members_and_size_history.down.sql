@@ -0,0 +1,51 @@
+-- Size history already archived to files is not restored.
+CREATE TABLE segment_members_unpartitioned (
+  segment_id INT NOT NULL REFERENCES segments (id),
+  member_id TEXT COLLATE "C" NOT NULL,
+  added_at TIMESTAMP NOT NULL,
+  expires_at TIMESTAMP,
+  CONSTRAINT segment_members_unpartitioned_pkey PRIMARY KEY (segment_id, member_id)
+);
+
+INSERT INTO segment_members_unpartitioned (segment_id, member_id, added_at, expires_at)
+SELECT segment_id, member_id, added_at, expires_at FROM segment_members;
+
+DROP TABLE segment_members;
+ALTER TABLE segment_members_unpartitioned RENAME TO segment_members;
+ALTER TABLE segment_members RENAME CONSTRAINT segment_members_unpartitioned_pkey TO segment_members_pkey;
+
+CREATE TABLE segment_size_history_unpartitioned (
+  segment_id INT NOT NULL REFERENCES segments (id),
+  day DATE NOT NULL,
+  member_count INT NOT NULL,
+  CONSTRAINT segment_size_history_unpartitioned_pkey PRIMARY KEY (segment_id, day)
+);
+
+INSERT INTO segment_size_history_unpartitioned (segment_id, day, member_count)
+SELECT segment_id, day, member_count FROM segment_size_history;
+
+DROP TABLE segment_size_history;
+
+-- Partitions detached by the maintenance job but not archived yet are
+-- merged back.
+DO $$
+DECLARE
+  partition_name TEXT;
+BEGIN
+  FOR partition_name IN
+    SELECT relname FROM pg_class
+    WHERE relnamespace = current_schema()::regnamespace
+      AND relkind = 'r'
+      AND relname ~ '^segment_size_history_y[0-9]{4}m[0-9]{2}$'
+  LOOP
+    EXECUTE format(
+      'INSERT INTO segment_size_history_unpartitioned (segment_id, day, member_count) SELECT segment_id, day, member_count FROM %I ON CONFLICT DO NOTHING',
+      partition_name
+    );
+    EXECUTE format('DROP TABLE %I', partition_name);
+  END LOOP;
+END
+$$;
+
+ALTER TABLE segment_size_history_unpartitioned RENAME TO segment_size_history;
+ALTER TABLE segment_size_history RENAME CONSTRAINT segment_size_history_unpartitioned_pkey TO segment_size_history_pkey;
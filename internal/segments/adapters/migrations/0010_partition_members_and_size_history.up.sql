@@ -0,0 +1,70 @@
+-- Memberships are hash partitioned by segment, which every membership query
+-- filters on, so that each only reads one partition per segment involved.
+-- The number of partitions cannot change without rewriting the table.
+ALTER TABLE segment_members RENAME TO segment_members_unpartitioned;
+ALTER TABLE segment_members_unpartitioned RENAME CONSTRAINT segment_members_pkey TO segment_members_unpartitioned_pkey;
+
+CREATE TABLE segment_members (
+  segment_id INT NOT NULL REFERENCES segments (id),
+  member_id TEXT COLLATE "C" NOT NULL,
+  added_at TIMESTAMP NOT NULL,
+  expires_at TIMESTAMP,
+  PRIMARY KEY (segment_id, member_id)
+) PARTITION BY HASH (segment_id);
+
+DO $$
+BEGIN
+  FOR i IN 0..15 LOOP
+    EXECUTE format(
+      'CREATE TABLE segment_members_p%s PARTITION OF segment_members FOR VALUES WITH (MODULUS 16, REMAINDER %s)',
+      lpad(i::text, 2, '0'), i
+    );
+  END LOOP;
+END
+$$;
+
+-- Purging expired memberships cannot prune partitions, so each partition
+-- gets an index to find them with.
+CREATE INDEX segment_members_expires_at_idx ON segment_members (expires_at) WHERE expires_at IS NOT NULL;
+
+INSERT INTO segment_members (segment_id, member_id, added_at, expires_at)
+SELECT segment_id, member_id, added_at, expires_at FROM segment_members_unpartitioned;
+
+DROP TABLE segment_members_unpartitioned;
+
+-- Size history is range partitioned by month, named
+-- segment_size_history_y<YYYY>m<MM>, so that old months can be detached and
+-- archived as a whole. The partition maintenance job creates upcoming months;
+-- this creates the months with history and the next three.
+ALTER TABLE segment_size_history RENAME TO segment_size_history_unpartitioned;
+ALTER TABLE segment_size_history_unpartitioned RENAME CONSTRAINT segment_size_history_pkey TO segment_size_history_unpartitioned_pkey;
+
+CREATE TABLE segment_size_history (
+  segment_id INT NOT NULL REFERENCES segments (id),
+  day DATE NOT NULL,
+  member_count INT NOT NULL,
+  PRIMARY KEY (segment_id, day)
+) PARTITION BY RANGE (day);
+
+DO $$
+DECLARE
+  first_day DATE;
+BEGIN
+  SELECT date_trunc('month', least(coalesce(min(day), current_date), current_date))::date
+  INTO first_day
+  FROM segment_size_history_unpartitioned;
+
+  WHILE first_day <= date_trunc('month', current_date) + INTERVAL '3 months' LOOP
+    EXECUTE format(
+      'CREATE TABLE %I PARTITION OF segment_size_history FOR VALUES FROM (%L) TO (%L)',
+      to_char(first_day, '"segment_size_history_y"YYYY"m"MM'), first_day, (first_day + INTERVAL '1 month')::date
+    );
+    first_day := (first_day + INTERVAL '1 month')::date;
+  END LOOP;
+END
+$$;
+
+INSERT INTO segment_size_history (segment_id, day, member_count)
+SELECT segment_id, day, member_count FROM segment_size_history_unpartitioned;
+
+DROP TABLE segment_size_history_unpartitioned;
@@ -0,0 +1,22 @@
+-- History of every segment for the event-sourced repository. Versions number
+-- the events of a segment from 1, so the primary key rejects concurrent
+-- appends of the same version. The payload holds the fields an event sets.
+CREATE TABLE segment_events (
+  segment_id INT NOT NULL REFERENCES segments (id),
+  version INT NOT NULL,
+  type TEXT NOT NULL
+    CONSTRAINT segment_events_type_check CHECK (type IN ('created', 'updated', 'transitioned', 'deleted')),
+  occurred_at TIMESTAMP NOT NULL,
+  payload JSONB NOT NULL DEFAULT '{}',
+  PRIMARY KEY (segment_id, version)
+);
+
+-- Segments folded from their events up to version, so that reads replay only
+-- the events after the latest snapshot.
+CREATE TABLE segment_snapshots (
+  segment_id INT NOT NULL REFERENCES segments (id),
+  version INT NOT NULL,
+  occurred_at TIMESTAMP NOT NULL,
+  segment JSONB NOT NULL,
+  PRIMARY KEY (segment_id, version)
+);
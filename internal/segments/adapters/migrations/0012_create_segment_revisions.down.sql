@@ -0,0 +1,2 @@
+DROP TABLE segment_revisions;
+ALTER TABLE segments DROP COLUMN version;
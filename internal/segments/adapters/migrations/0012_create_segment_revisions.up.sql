@@ -0,0 +1,24 @@
+-- Every revision of a segment, as saved by a create or an update. The
+-- segment's version counts its revisions, so an update numbers the revision
+-- it saves while holding the segment's row lock.
+ALTER TABLE segments ADD COLUMN version INT NOT NULL DEFAULT 1;
+
+CREATE TABLE segment_revisions (
+  segment_id INT NOT NULL REFERENCES segments (id),
+  version INT NOT NULL,
+  name TEXT NOT NULL,
+  description TEXT NOT NULL,
+  labels JSONB NOT NULL,
+  ttl_seconds INT,
+  active_from TIMESTAMP,
+  active_until TIMESTAMP,
+  expression TEXT,
+  state TEXT NOT NULL,
+  saved_at TIMESTAMP NOT NULL,
+  PRIMARY KEY (segment_id, version)
+);
+
+-- The revisions of existing segments start from their current state.
+INSERT INTO segment_revisions (segment_id, version, name, description, labels, ttl_seconds, active_from, active_until, expression, state, saved_at)
+SELECT id, version, name, description, labels, ttl_seconds, active_from, active_until, expression, state, updated_at
+FROM segments;
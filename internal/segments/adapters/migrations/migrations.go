@@ -0,0 +1,28 @@
+// Package migrations contains the versioned PostgreSQL schema of the
+// segments service, and the SQLite equivalent.
+package migrations
+
+import (
+	"embed"
+	"io/fs"
+)
+
+// FS holds the migration files, named <version>_<name>.<up|down>.sql.
+//
+//go:embed *.sql
+var FS embed.FS
+
+//go:embed sqlite/*.sql
+var sqliteFiles embed.FS
+
+// SQLiteFS holds the migration files of the SQLite schema, which are
+// versioned separately from the PostgreSQL ones.
+var SQLiteFS = mustSub(sqliteFiles, "sqlite")
+
+func mustSub(fsys fs.FS, dir string) fs.FS {
+	sub, err := fs.Sub(fsys, dir)
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
@@ -0,0 +1,34 @@
+package migrations_test
+
+import (
+	"io/fs"
+	"testing"
+
+	"github.com/rickKoch/nexus/internal/segments/adapters/migrations"
+	"github.com/rickKoch/nexus/pkg/migrate"
+)
+
+func TestMigrations(t *testing.T) {
+	sources := map[string]fs.FS{
+		"postgres": migrations.FS,
+		"sqlite":   migrations.SQLiteFS,
+	}
+	for name, fsys := range sources {
+		t.Run(name, func(t *testing.T) {
+			got, err := migrate.Load(fsys)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			if len(got) == 0 {
+				t.Fatal("expected at least one migration")
+			}
+
+			for _, m := range got {
+				if m.Down == "" {
+					t.Errorf("expected migration %d_%s to be reversible", m.Version, m.Name)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,4 @@
+DROP TABLE segment_size_history;
+DROP TABLE segment_members;
+DROP TABLE segment_id_sequence;
+DROP TABLE segments;
@@ -0,0 +1,51 @@
+-- The SQLite schema matches the PostgreSQL one as of its migration 0009.
+-- Timestamps are stored as fixed-width UTC text, which sorts chronologically,
+-- and arrays and labels as JSON text.
+CREATE TABLE segments (
+  id INTEGER PRIMARY KEY,
+  name TEXT NOT NULL,
+  description TEXT NOT NULL DEFAULT '',
+  labels TEXT NOT NULL DEFAULT '{}',
+  ttl_seconds INTEGER,
+  active_from TEXT,
+  active_until TEXT,
+  expression TEXT,
+  referenced_ids TEXT NOT NULL DEFAULT '[]',
+  state TEXT NOT NULL DEFAULT 'draft'
+    CONSTRAINT segments_state_check CHECK (state IN ('draft', 'active', 'paused', 'archived')),
+  member_count INTEGER NOT NULL DEFAULT 0,
+  sketch BLOB,
+  created_at TEXT NOT NULL,
+  updated_at TEXT NOT NULL,
+  deleted_at TEXT,
+  CONSTRAINT segments_activation_window_check CHECK (active_from < active_until)
+);
+
+CREATE INDEX segments_state_idx ON segments (state) WHERE deleted_at IS NULL;
+CREATE INDEX segments_active_from_idx ON segments (active_from) WHERE deleted_at IS NULL AND active_from IS NOT NULL;
+CREATE INDEX segments_active_until_idx ON segments (active_until) WHERE deleted_at IS NULL AND active_until IS NOT NULL;
+
+-- Segment IDs are reserved by the application (see segment.IDGenerator) from
+-- this single-row counter and inserted explicitly.
+CREATE TABLE segment_id_sequence (
+  last_value INTEGER NOT NULL
+);
+
+INSERT INTO segment_id_sequence (last_value) VALUES (0);
+
+-- SQLite compares text bytewise by default, so exports order members like
+-- PostgreSQL's "C" collation does.
+CREATE TABLE segment_members (
+  segment_id INTEGER NOT NULL REFERENCES segments (id),
+  member_id TEXT NOT NULL,
+  added_at TEXT NOT NULL,
+  expires_at TEXT,
+  PRIMARY KEY (segment_id, member_id)
+) WITHOUT ROWID;
+
+CREATE TABLE segment_size_history (
+  segment_id INTEGER NOT NULL REFERENCES segments (id),
+  day TEXT NOT NULL,
+  member_count INTEGER NOT NULL,
+  PRIMARY KEY (segment_id, day)
+) WITHOUT ROWID;
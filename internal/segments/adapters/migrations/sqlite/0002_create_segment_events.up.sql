@@ -0,0 +1,18 @@
+-- The SQLite equivalent of PostgreSQL migration 0011.
+CREATE TABLE segment_events (
+  segment_id INTEGER NOT NULL REFERENCES segments (id),
+  version INTEGER NOT NULL,
+  type TEXT NOT NULL
+    CONSTRAINT segment_events_type_check CHECK (type IN ('created', 'updated', 'transitioned', 'deleted')),
+  occurred_at TEXT NOT NULL,
+  payload TEXT NOT NULL DEFAULT '{}',
+  PRIMARY KEY (segment_id, version)
+) WITHOUT ROWID;
+
+CREATE TABLE segment_snapshots (
+  segment_id INTEGER NOT NULL REFERENCES segments (id),
+  version INTEGER NOT NULL,
+  occurred_at TEXT NOT NULL,
+  segment TEXT NOT NULL,
+  PRIMARY KEY (segment_id, version)
+) WITHOUT ROWID;
@@ -0,0 +1,21 @@
+-- The SQLite equivalent of PostgreSQL migration 0012.
+ALTER TABLE segments ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+
+CREATE TABLE segment_revisions (
+  segment_id INTEGER NOT NULL REFERENCES segments (id),
+  version INTEGER NOT NULL,
+  name TEXT NOT NULL,
+  description TEXT NOT NULL,
+  labels TEXT NOT NULL,
+  ttl_seconds INTEGER,
+  active_from TEXT,
+  active_until TEXT,
+  expression TEXT,
+  state TEXT NOT NULL,
+  saved_at TEXT NOT NULL,
+  PRIMARY KEY (segment_id, version)
+) WITHOUT ROWID;
+
+INSERT INTO segment_revisions (segment_id, version, name, description, labels, ttl_seconds, active_from, active_until, expression, state, saved_at)
+SELECT id, version, name, description, labels, ttl_seconds, active_from, active_until, expression, state, updated_at
+FROM segments;
@@ -1,13 +1,16 @@
 package adapters
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
-	"os"
-	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq" // PostgreSQL driver
+	"github.com/rickKoch/nexus/pkg/clock"
 )
 
 // PostgreSQLConfig holds the configuration for a PostgreSQL connection.
@@ -22,38 +25,12 @@ type PostgreSQLConfig struct {
 	MaxIdleConns    int
 	ConnMaxLifetime time.Duration
 	ConnMaxIdleTime time.Duration
-}
-
-// DefaultPostgreSQLConfig returns a PostgreSQLConfig with sensible defaults.
-func DefaultPostgreSQLConfig() PostgreSQLConfig {
-	return PostgreSQLConfig{
-		Host:            getEnv("POSTGRES_HOST", "localhost"),
-		Port:            getEnvInt("POSTGRES_PORT", 5432),
-		User:            getEnv("POSTGRES_USER", "nexus"),
-		Password:        getEnv("POSTGRES_PASSWORD", "nexus"),
-		Database:        getEnv("POSTGRES_DB", "nexus"),
-		SSLMode:         getEnv("POSTGRES_SSLMODE", "disable"),
-		MaxOpenConns:    25,
-		MaxIdleConns:    5,
-		ConnMaxLifetime: 5 * time.Minute,
-		ConnMaxIdleTime: 1 * time.Minute,
-	}
-}
-
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
-	}
-	return defaultValue
-}
-
-func getEnvInt(key string, defaultValue int) int {
-	if value := os.Getenv(key); value != "" {
-		if intValue, err := strconv.Atoi(value); err == nil {
-			return intValue
-		}
-	}
-	return defaultValue
+	// Replicas are the data source names of read replicas of the database.
+	// Reads are spread over them and fall back to the primary.
+	Replicas []string
+	// ReplicaRetryInterval is how long a failed replica is left out before
+	// reads are sent to it again.
+	ReplicaRetryInterval time.Duration
 }
 
 // DSN returns the data source name for the PostgreSQL connection.
@@ -78,11 +55,16 @@ func (c PostgreSQLConfig) Validate() error {
 	if c.Database == "" {
 		return fmt.Errorf("database is required")
 	}
+	if len(c.Replicas) > 0 && c.ReplicaRetryInterval <= 0 {
+		return fmt.Errorf("replica retry interval must be positive")
+	}
 	return nil
 }
 
-// NewPostgreSQLConnection creates a new PostgreSQL connection with the given configuration.
-func NewPostgreSQLConnection(cfg PostgreSQLConfig) (*sqlx.DB, error) {
+// NewPostgreSQLConnection creates a new PostgreSQL connection with the given
+// configuration. The primary must be reachable; replicas are connected to
+// lazily, so one that is down only fails over its first reads.
+func NewPostgreSQLConnection(cfg PostgreSQLConfig) (*PostgreSQLDB, error) {
 	if err := cfg.Validate(); err != nil {
 		return nil, fmt.Errorf("invalid config: %w", err)
 	}
@@ -91,12 +73,139 @@ func NewPostgreSQLConnection(cfg PostgreSQLConfig) (*sqlx.DB, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
+	configurePool(db, cfg)
 
-	// Configure connection pool
+	replicas := make([]*sqlx.DB, 0, len(cfg.Replicas))
+	for i, dsn := range cfg.Replicas {
+		replica, err := sqlx.Open("postgres", dsn)
+		if err != nil {
+			for _, r := range replicas {
+				_ = r.Close()
+			}
+			_ = db.Close()
+			return nil, fmt.Errorf("failed to open replica %d: %w", i+1, err)
+		}
+		configurePool(replica, cfg)
+		replicas = append(replicas, replica)
+	}
+
+	pg := NewPostgreSQLDB(db, replicas...)
+	pg.retryInterval = cfg.ReplicaRetryInterval
+	return pg, nil
+}
+
+func configurePool(db *sqlx.DB, cfg PostgreSQLConfig) {
 	db.SetMaxOpenConns(cfg.MaxOpenConns)
 	db.SetMaxIdleConns(cfg.MaxIdleConns)
 	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
 	db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+}
+
+// defaultReplicaRetryInterval is how long NewPostgreSQLDB leaves a failed
+// replica out.
+const defaultReplicaRetryInterval = 30 * time.Second
+
+// PostgreSQLDB is a connection pool to the primary database, which it
+// embeds, and to its read replicas.
+//
+// Reads are spread round-robin over the healthy replicas. A replica whose
+// read fails is left out for the retry interval, and the read is retried on
+// the primary, so a replica going down costs latency rather than errors.
+// Writes and transactions always run on the primary.
+type PostgreSQLDB struct {
+	*sqlx.DB
+	replicas      []*postgresReplica
+	next          atomic.Uint64
+	retryInterval time.Duration
+	clock         clock.Clock
+}
+
+type postgresReplica struct {
+	db *sqlx.DB
+	// downUntil is when a failed replica is read from again, in Unix
+	// nanoseconds. It is zero while the replica is healthy.
+	downUntil atomic.Int64
+}
+
+// NewPostgreSQLDB combines existing connection pools to a primary and its
+// read replicas.
+func NewPostgreSQLDB(primary *sqlx.DB, replicas ...*sqlx.DB) *PostgreSQLDB {
+	db := &PostgreSQLDB{
+		DB:            primary,
+		retryInterval: defaultReplicaRetryInterval,
+		clock:         clock.System,
+	}
+	for _, r := range replicas {
+		db.replicas = append(db.replicas, &postgresReplica{db: r})
+	}
+	return db
+}
+
+// Close closes the connections to the replicas and to the primary.
+func (db *PostgreSQLDB) Close() error {
+	var errs []error
+	for _, r := range db.replicas {
+		errs = append(errs, r.db.Close())
+	}
+	errs = append(errs, db.DB.Close())
+	return errors.Join(errs...)
+}
+
+// read runs fn on a healthy replica, or on the primary if there is none, if
+// the replica fails or if ctx pinned its reads to the primary.
+func (db *PostgreSQLDB) read(ctx context.Context, fn func(q sqlx.ExtContext) error) error {
+	if readsPinned(ctx) {
+		return fn(db.DB)
+	}
+
+	replica := db.replica()
+	if replica == nil {
+		return fn(db.DB)
+	}
+
+	err := fn(replica.db)
+	if err == nil || errors.Is(err, sql.ErrNoRows) || ctx.Err() != nil {
+		return err
+	}
+	replica.downUntil.Store(db.clock.Now().Add(db.retryInterval).UnixNano())
+	return fn(db.DB)
+}
+
+// replica returns the next healthy replica, or nil if there is none.
+func (db *PostgreSQLDB) replica() *postgresReplica {
+	now := db.clock.Now().UnixNano()
+	for range db.replicas {
+		r := db.replicas[(db.next.Add(1)-1)%uint64(len(db.replicas))]
+		if r.downUntil.Load() <= now {
+			return r
+		}
+	}
+	return nil
+}
+
+type readYourWritesKey struct{}
+
+// readYourWrites records whether a write was made through a context.
+type readYourWrites struct {
+	wrote atomic.Bool
+}
+
+// WithReadYourWrites returns a context whose reads are served by the
+// primary once a write was made through it, so that a request sees its own
+// writes despite replication lag.
+func WithReadYourWrites(ctx context.Context) context.Context {
+	return context.WithValue(ctx, readYourWritesKey{}, &readYourWrites{})
+}
+
+// markWritten pins the reads of ctx to the primary if it was created by
+// WithReadYourWrites.
+func markWritten(ctx context.Context) {
+	if rw, ok := ctx.Value(readYourWritesKey{}).(*readYourWrites); ok {
+		rw.wrote.Store(true)
+	}
+}
 
-	return db, nil
+func readsPinned(ctx context.Context) bool {
+	rw, ok := ctx.Value(readYourWritesKey{}).(*readYourWrites)
+	return ok && rw.wrote.Load()
 }
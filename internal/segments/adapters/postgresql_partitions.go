@@ -0,0 +1,372 @@
+package adapters
+
+import (
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rickKoch/nexus/pkg/clock"
+)
+
+// partitionLockID is the advisory lock serializing partition maintenance
+// across instances sharing a database.
+const partitionLockID int64 = 7_362_514_002
+
+// archiveFetchSize is the number of rows fetched from a detached partition at
+// a time while it is archived.
+const archiveFetchSize = 10000
+
+// sizeHistoryPartition matches the names of the monthly size history
+// partitions, segment_size_history_y<YYYY>m<MM>.
+var sizeHistoryPartition = regexp.MustCompile(`^segment_size_history_y(\d{4})m(\d{2})$`)
+
+// PartitionConfig configures a PostgreSQLPartitionMaintainer.
+type PartitionConfig struct {
+	// Interval is how often partitions are maintained.
+	Interval time.Duration
+	// Ahead is the number of months after the current one that have a size
+	// history partition.
+	Ahead int
+	// Retention is the number of months of size history, the current one
+	// included, kept in the database. Zero keeps every month.
+	Retention int
+	// ArchiveDir receives the months beyond Retention as gzip compressed
+	// NDJSON files. It is required when Retention is set.
+	ArchiveDir string
+}
+
+// Validate checks if the configuration is valid.
+func (c PartitionConfig) Validate() error {
+	if c.Interval <= 0 {
+		return errors.New("partition maintenance interval must be positive")
+	}
+	if c.Ahead < 1 {
+		return errors.New("at least one partition must be created ahead")
+	}
+	if c.Retention < 0 {
+		return errors.New("size history retention must not be negative")
+	}
+	if c.Retention > 0 && c.ArchiveDir == "" {
+		return errors.New("archive directory is required with a size history retention")
+	}
+	return nil
+}
+
+// PostgreSQLPartitionMaintainer keeps the monthly partitions of the size
+// history table: it creates partitions for the months ahead, so that size
+// snapshots always have one to go to, and detaches months beyond the
+// retention, archives their rows to files and drops them.
+//
+// Memberships are hash partitioned by segment in a fixed number of
+// partitions, so they need no maintenance.
+type PostgreSQLPartitionMaintainer struct {
+	db    *sqlx.DB
+	clock clock.Clock
+	cfg   PartitionConfig
+}
+
+// NewPostgreSQLPartitionMaintainer creates a PostgreSQLPartitionMaintainer
+// for the partitions in db.
+func NewPostgreSQLPartitionMaintainer(db *sqlx.DB, clk clock.Clock, cfg PartitionConfig) (*PostgreSQLPartitionMaintainer, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	return &PostgreSQLPartitionMaintainer{
+		db:    db,
+		clock: clk,
+		cfg:   cfg,
+	}, nil
+}
+
+// Run calls Maintain immediately and then every interval until ctx is
+// cancelled. Errors are passed to onError.
+func (m *PostgreSQLPartitionMaintainer) Run(ctx context.Context, onError func(err error)) {
+	ticker := time.NewTicker(m.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := m.Maintain(ctx); err != nil {
+			onError(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Maintain creates the partitions of the current month and the months ahead,
+// detaches the partitions beyond the retention and archives every detached
+// partition. Partitions detached by an earlier run that failed to archive
+// them are archived as well.
+func (m *PostgreSQLPartitionMaintainer) Maintain(ctx context.Context) error {
+	current := monthOf(m.clock.Now())
+
+	err := m.inLockedTx(ctx, func(tx *sqlx.Tx) error {
+		for i := 0; i <= m.cfg.Ahead; i++ {
+			if err := createSizeHistoryPartition(ctx, tx, current.AddDate(0, i, 0)); err != nil {
+				return err
+			}
+		}
+		if m.cfg.Retention == 0 {
+			return nil
+		}
+
+		attached, err := sizeHistoryPartitions(ctx, tx, true)
+		if err != nil {
+			return err
+		}
+		for _, p := range expiredPartitions(attached, current, m.cfg.Retention) {
+			query := fmt.Sprintf(`ALTER TABLE segment_size_history DETACH PARTITION %s`, p.name)
+			if _, err := tx.ExecContext(ctx, query); err != nil {
+				return fmt.Errorf("failed to detach partition %s: %w", p.name, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var detached []historyPartition
+	err = m.inLockedTx(ctx, func(tx *sqlx.Tx) (err error) {
+		detached, err = sizeHistoryPartitions(ctx, tx, false)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	// Every partition is archived in a transaction of its own, so that a
+	// failure does not lose the archives written before it.
+	for _, p := range detached {
+		if m.cfg.ArchiveDir == "" {
+			return fmt.Errorf("partition %s is detached but no archive directory is configured", p.name)
+		}
+		err := m.inLockedTx(ctx, func(tx *sqlx.Tx) error {
+			return m.archive(ctx, tx, p)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// inLockedTx runs fn in a transaction holding the partition maintenance lock.
+func (m *PostgreSQLPartitionMaintainer) inLockedTx(ctx context.Context, fn func(tx *sqlx.Tx) error) error {
+	tx, err := m.db.BeginTxx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, partitionLockID); err != nil {
+		return fmt.Errorf("failed to acquire partition lock: %w", err)
+	}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// archive writes the rows of a detached partition to its archive file and
+// drops it. The file is complete once it has its final name; a partition
+// whose drop fails is archived again by the next run.
+func (m *PostgreSQLPartitionMaintainer) archive(ctx context.Context, tx *sqlx.Tx, p historyPartition) error {
+	path := filepath.Join(m.cfg.ArchiveDir, p.name+".ndjson.gz")
+	if err := os.MkdirAll(m.cfg.ArchiveDir, 0o755); err != nil {
+		return fmt.Errorf("failed to create archive directory: %w", err)
+	}
+
+	err := writeArchive(path, func(enc *json.Encoder) error {
+		return exportSizeHistory(ctx, tx, p.name, func(row archivedSizeSnapshot) error {
+			return enc.Encode(row)
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to archive partition %s: %w", p.name, err)
+	}
+
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(`DROP TABLE %s`, p.name)); err != nil {
+		return fmt.Errorf("failed to drop partition %s: %w", p.name, err)
+	}
+	return nil
+}
+
+// archivedSizeSnapshot is a line of a size history archive.
+type archivedSizeSnapshot struct {
+	SegmentID   int    `json:"segment_id" db:"segment_id"`
+	Day         string `json:"day" db:"day"`
+	MemberCount int    `json:"member_count" db:"member_count"`
+}
+
+// exportSizeHistory reads a partition through a server-side cursor, like
+// streamMemberships.
+func exportSizeHistory(ctx context.Context, tx *sqlx.Tx, table string, fn func(row archivedSizeSnapshot) error) error {
+	query := fmt.Sprintf(`
+		DECLARE history_archive NO SCROLL CURSOR FOR
+		SELECT segment_id, to_char(day, 'YYYY-MM-DD') AS day, member_count FROM %s
+		ORDER BY day, segment_id
+	`, table)
+	if _, err := tx.ExecContext(ctx, query); err != nil {
+		return err
+	}
+
+	fetch := fmt.Sprintf("FETCH FORWARD %d FROM history_archive", archiveFetchSize)
+	for {
+		var rows []archivedSizeSnapshot
+		if err := sqlx.SelectContext(ctx, tx, &rows, fetch); err != nil {
+			return err
+		}
+		for _, row := range rows {
+			if err := fn(row); err != nil {
+				return err
+			}
+		}
+		if len(rows) < archiveFetchSize {
+			break
+		}
+	}
+
+	_, err := tx.ExecContext(ctx, "CLOSE history_archive")
+	return err
+}
+
+// writeArchive writes a gzip compressed NDJSON file through a temporary file
+// that only replaces path once it is complete and flushed.
+func writeArchive(path string, write func(enc *json.Encoder) error) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp) }()
+
+	gz := gzip.NewWriter(f)
+	if err := write(json.NewEncoder(gz)); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+// historyPartition is a monthly size history partition.
+type historyPartition struct {
+	name  string
+	month time.Time
+}
+
+// sizeHistoryPartitions returns the size history partitions attached to the
+// table, or those detached from it, ordered by month.
+func sizeHistoryPartitions(ctx context.Context, tx *sqlx.Tx, attached bool) ([]historyPartition, error) {
+	query := `
+		SELECT c.relname FROM pg_class c
+		WHERE c.relnamespace = current_schema()::regnamespace
+		  AND c.relkind = 'r'
+		  AND c.relname LIKE 'segment\_size\_history\_y%'
+		  AND EXISTS (
+		    SELECT 1 FROM pg_inherits i
+		    WHERE i.inhrelid = c.oid AND i.inhparent = 'segment_size_history'::regclass
+		  ) = $1
+	`
+
+	var names []string
+	if err := sqlx.SelectContext(ctx, tx, &names, query, attached); err != nil {
+		return nil, fmt.Errorf("failed to list partitions: %w", err)
+	}
+
+	partitions := make([]historyPartition, 0, len(names))
+	for _, name := range names {
+		if month, ok := parsePartitionMonth(name); ok {
+			partitions = append(partitions, historyPartition{name: name, month: month})
+		}
+	}
+	sort.Slice(partitions, func(i, j int) bool { return partitions[i].month.Before(partitions[j].month) })
+	return partitions, nil
+}
+
+// createSizeHistoryPartition creates the partition of month unless it exists.
+func createSizeHistoryPartition(ctx context.Context, tx *sqlx.Tx, month time.Time) error {
+	name := partitionName(month)
+	query := fmt.Sprintf(
+		`CREATE TABLE IF NOT EXISTS %s PARTITION OF segment_size_history FOR VALUES FROM ('%s') TO ('%s')`,
+		name, month.Format(time.DateOnly), month.AddDate(0, 1, 0).Format(time.DateOnly),
+	)
+	if _, err := tx.ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("failed to create partition %s: %w", name, err)
+	}
+	return nil
+}
+
+// expiredPartitions returns the partitions of months before the retention of
+// months ending with current.
+func expiredPartitions(partitions []historyPartition, current time.Time, retention int) []historyPartition {
+	oldest := current.AddDate(0, 1-retention, 0)
+	var expired []historyPartition
+	for _, p := range partitions {
+		if p.month.Before(oldest) {
+			expired = append(expired, p)
+		}
+	}
+	return expired
+}
+
+// monthOf returns the first day of the UTC month of t.
+func monthOf(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// partitionName returns the name of the size history partition of month.
+func partitionName(month time.Time) string {
+	return fmt.Sprintf("segment_size_history_y%04dm%02d", month.Year(), int(month.Month()))
+}
+
+// parsePartitionMonth returns the month of a size history partition name.
+func parsePartitionMonth(name string) (time.Time, bool) {
+	match := sizeHistoryPartition.FindStringSubmatch(name)
+	if match == nil {
+		return time.Time{}, false
+	}
+	month, err := time.Parse("2006-01", match[1]+"-"+match[2])
+	if err != nil {
+		return time.Time{}, false
+	}
+	return month, true
+}
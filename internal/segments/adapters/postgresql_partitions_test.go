@@ -0,0 +1,126 @@
+package adapters
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSizeHistoryPartitions(t *testing.T) {
+	month := func(year int, m time.Month) time.Time {
+		return time.Date(year, m, 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	t.Run("names partitions by month", func(t *testing.T) {
+		name := partitionName(month(2026, time.March))
+		if name != "segment_size_history_y2026m03" {
+			t.Errorf("expected segment_size_history_y2026m03, got %s", name)
+		}
+		if got, ok := parsePartitionMonth(name); !ok || !got.Equal(month(2026, time.March)) {
+			t.Errorf("expected March 2026, got %s", got)
+		}
+
+		for _, name := range []string{"segment_size_history", "segment_size_history_y2026m13", "segment_size_history_y26m03", "segment_size_history_y2026m03_old"} {
+			if _, ok := parsePartitionMonth(name); ok {
+				t.Errorf("expected %s not to be a partition", name)
+			}
+		}
+	})
+
+	t.Run("takes the month in UTC", func(t *testing.T) {
+		local := time.Date(2026, time.December, 1, 0, 30, 0, 0, time.FixedZone("UTC+1", 3600))
+		if got := monthOf(local); !got.Equal(month(2026, time.November)) {
+			t.Errorf("expected November 2026, got %s", got)
+		}
+	})
+
+	t.Run("expires the months before the retention", func(t *testing.T) {
+		var partitions []historyPartition
+		for m := month(2025, time.November); !m.After(month(2026, time.May)); m = m.AddDate(0, 1, 0) {
+			partitions = append(partitions, historyPartition{name: partitionName(m), month: m})
+		}
+
+		expired := expiredPartitions(partitions, month(2026, time.February), 2)
+		var names []string
+		for _, p := range expired {
+			names = append(names, p.name)
+		}
+		want := []string{"segment_size_history_y2025m11", "segment_size_history_y2025m12"}
+		if len(names) != len(want) || names[0] != want[0] || names[1] != want[1] {
+			t.Errorf("expected %v, got %v", want, names)
+		}
+	})
+
+	t.Run("writes archives atomically", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "segment_size_history_y2026m01.ndjson.gz")
+		rows := []archivedSizeSnapshot{
+			{SegmentID: 1, Day: "2026-01-01", MemberCount: 3},
+			{SegmentID: 2, Day: "2026-01-01", MemberCount: 5},
+		}
+
+		errWrite := errors.New("connection lost")
+		err := writeArchive(path, func(enc *json.Encoder) error {
+			_ = enc.Encode(rows[0])
+			return errWrite
+		})
+		if !errors.Is(err, errWrite) {
+			t.Fatalf("expected the write error, got %v", err)
+		}
+		if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 0 {
+			t.Fatalf("expected a failed archive to leave no files, got %v", entries)
+		}
+
+		err = writeArchive(path, func(enc *json.Encoder) error {
+			for _, row := range rows {
+				if err := enc.Encode(row); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		defer f.Close()
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		var got []archivedSizeSnapshot
+		scanner := bufio.NewScanner(gz)
+		for scanner.Scan() {
+			var row archivedSizeSnapshot
+			if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+				t.Fatalf("expected NDJSON, got %q: %v", scanner.Text(), err)
+			}
+			got = append(got, row)
+		}
+		if len(got) != 2 || got[0] != rows[0] || got[1] != rows[1] {
+			t.Errorf("expected %v, got %v", rows, got)
+		}
+	})
+
+	t.Run("rejects invalid configurations", func(t *testing.T) {
+		tests := map[string]PartitionConfig{
+			"missing interval":      {Ahead: 3},
+			"nothing ahead":         {Interval: time.Hour},
+			"negative retention":    {Interval: time.Hour, Ahead: 3, Retention: -1},
+			"retention without dir": {Interval: time.Hour, Ahead: 3, Retention: 12},
+		}
+		for name, cfg := range tests {
+			if err := cfg.Validate(); err == nil {
+				t.Errorf("%s: expected an error", name)
+			}
+		}
+	})
+}
@@ -0,0 +1,201 @@
+package adapters
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rickKoch/nexus/internal/segments/domain/segment"
+	"github.com/rickKoch/nexus/pkg/clock"
+)
+
+func TestPostgreSQLDB_read(t *testing.T) {
+	ctx := context.Background()
+	errReplica := errors.New("connection refused")
+
+	// The pools are never connected; reads only record which one they got.
+	open := func(t *testing.T) *sqlx.DB {
+		t.Helper()
+
+		db, err := sqlx.Open("postgres", "host=localhost")
+		if err != nil {
+			t.Fatalf("failed to open database: %v", err)
+		}
+		t.Cleanup(func() { _ = db.Close() })
+		return db
+	}
+	setup := func(t *testing.T, replicas int) (*PostgreSQLDB, *clock.Fake) {
+		t.Helper()
+
+		pools := make([]*sqlx.DB, 0, replicas)
+		for range replicas {
+			pools = append(pools, open(t))
+		}
+		db := NewPostgreSQLDB(open(t), pools...)
+		clk := clock.NewFake(time.Date(2026, 11, 1, 12, 30, 0, 0, time.UTC))
+		db.clock = clk
+		return db, clk
+	}
+	// read returns the pool a read ran on last, failing the reads on down.
+	read := func(t *testing.T, ctx context.Context, db *PostgreSQLDB, down ...*sqlx.DB) (sqlx.ExtContext, error) {
+		t.Helper()
+
+		var last sqlx.ExtContext
+		err := db.read(ctx, func(q sqlx.ExtContext) error {
+			last = q
+			for _, d := range down {
+				if q == d {
+					return errReplica
+				}
+			}
+			return nil
+		})
+		return last, err
+	}
+
+	t.Run("reads from the primary without replicas", func(t *testing.T) {
+		db, _ := setup(t, 0)
+
+		if q, _ := read(t, ctx, db); q != db.DB {
+			t.Errorf("expected the primary, got %v", q)
+		}
+	})
+
+	t.Run("spreads reads over the replicas", func(t *testing.T) {
+		db, _ := setup(t, 2)
+
+		for i := range 4 {
+			want := db.replicas[i%2].db
+			if q, _ := read(t, ctx, db); q != want {
+				t.Errorf("read %d: expected replica %d, got %v", i, i%2+1, q)
+			}
+		}
+	})
+
+	t.Run("fails over to the primary", func(t *testing.T) {
+		db, clk := setup(t, 1)
+		replica := db.replicas[0].db
+
+		q, err := read(t, ctx, db, replica)
+		if err != nil || q != db.DB {
+			t.Fatalf("expected the read to be retried on the primary, got %v on %v", err, q)
+		}
+		if q, _ := read(t, ctx, db); q != db.DB {
+			t.Errorf("expected the failed replica to be left out, got %v", q)
+		}
+
+		clk.Advance(defaultReplicaRetryInterval)
+		if q, _ := read(t, ctx, db); q != replica {
+			t.Errorf("expected the replica to be tried again after the retry interval, got %v", q)
+		}
+	})
+
+	t.Run("skips failed replicas", func(t *testing.T) {
+		db, _ := setup(t, 2)
+		first, second := db.replicas[0].db, db.replicas[1].db
+
+		if _, err := read(t, ctx, db, first); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		for range 2 {
+			if q, _ := read(t, ctx, db); q != second {
+				t.Errorf("expected the healthy replica, got %v", q)
+			}
+		}
+	})
+
+	t.Run("keeps replicas returning no rows", func(t *testing.T) {
+		db, _ := setup(t, 1)
+
+		err := db.read(ctx, func(q sqlx.ExtContext) error { return sql.ErrNoRows })
+		if !errors.Is(err, sql.ErrNoRows) {
+			t.Fatalf("expected no rows, got %v", err)
+		}
+		if q, _ := read(t, ctx, db); q != db.replicas[0].db {
+			t.Errorf("expected the replica, got %v", q)
+		}
+	})
+
+	t.Run("pins reads to the primary after a write", func(t *testing.T) {
+		db, _ := setup(t, 1)
+		ctx := WithReadYourWrites(ctx)
+
+		if q, _ := read(t, ctx, db); q != db.replicas[0].db {
+			t.Errorf("expected the replica before writing, got %v", q)
+		}
+		markWritten(ctx)
+		if q, _ := read(t, ctx, db); q != db.DB {
+			t.Errorf("expected the primary after writing, got %v", q)
+		}
+		if q, _ := read(t, context.Background(), db); q != db.replicas[0].db {
+			t.Errorf("expected other requests to read from the replica, got %v", q)
+		}
+	})
+}
+
+// laggingReplicaRepository serves the segments of snapshot to reads that are
+// not pinned to the primary, like a replica that has not caught up.
+type laggingReplicaRepository struct {
+	*InMemorySegmentRepository
+	snapshot map[int]segment.Segment
+}
+
+func (r *laggingReplicaRepository) Get(ctx context.Context, id int) (*segment.Segment, error) {
+	if s, ok := r.snapshot[id]; ok && !readsPinned(ctx) {
+		return &s, nil
+	}
+	return r.InMemorySegmentRepository.Get(ctx, id)
+}
+
+func (r *laggingReplicaRepository) Update(ctx context.Context, s *segment.Segment) (*segment.Segment, error) {
+	markWritten(ctx)
+	return r.InMemorySegmentRepository.Update(ctx, s)
+}
+
+func TestCachedSegmentRepository_LaggingReplica(t *testing.T) {
+	now := time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)
+	factory, _ := segment.NewFactory(segment.SegmentConfig{Name: "premium-users"})
+
+	inner := &laggingReplicaRepository{InMemorySegmentRepository: NewInMemorySegmentRepository()}
+	created, err := inner.Create(context.Background(), factory.NewSegment(1, now))
+	if err != nil {
+		t.Fatalf("failed to create segment: %v", err)
+	}
+	inner.snapshot = map[int]segment.Segment{1: *created}
+	cached := NewCachedSegmentRepository(inner, clock.NewFake(now), CacheConfig{Size: 10, TTL: time.Minute})
+
+	ctx := WithReadYourWrites(context.Background())
+	s, err := cached.Get(ctx, 1)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := s.Update(segment.SegmentConfig{Name: "vip-users"}, now); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := cached.Update(ctx, s); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// An unpinned read after the write caches the replica's stale segment.
+	if got, err := cached.Get(context.Background(), 1); err != nil || got.Name() != "premium-users" {
+		t.Fatalf("expected the stale segment from the replica, got %v, %v", got, err)
+	}
+
+	got, err := cached.Get(ctx, 1)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if got.Name() != "vip-users" {
+		t.Errorf("expected the pinned read to see its write, got name %q", got.Name())
+	}
+	result, err := cached.List(ctx, segment.ListParams{Page: 1, PageSize: 10})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(result.Segments) != 1 || result.Segments[0].Name() != "vip-users" {
+		t.Errorf("expected the pinned list to see its write, got %v", result.Segments)
+	}
+}
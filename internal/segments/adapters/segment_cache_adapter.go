@@ -0,0 +1,305 @@
+package adapters
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rickKoch/nexus/internal/segments/domain/segment"
+	"github.com/rickKoch/nexus/pkg/cache"
+	"github.com/rickKoch/nexus/pkg/clock"
+	"golang.org/x/sync/singleflight"
+)
+
+// CacheConfig configures a CachedSegmentRepository.
+type CacheConfig struct {
+	// Size is the number of segments, and separately of list pages, cached.
+	Size int
+	// TTL is how long a cached segment or list page is served.
+	TTL time.Duration
+}
+
+// CachedSegmentRepository is a segment.Repository decorator caching the
+// segments and list pages read with Get and List. Writes made through it
+// invalidate the cached segments they change and every cached list page, so
+// they are visible to its next reads. Writes made elsewhere, such as by other
+// instances, are only visible once the cached entries expire. Reads pinned
+// to the primary with WithReadYourWrites bypass the cache, and the cache
+// must not wrap a repository reading from replicas otherwise, since it would
+// keep a lagging replica's values after the writes they miss.
+//
+// Concurrent misses of the same segment or list page share a single read of
+// the wrapped repository. Methods without caching are passed through.
+type CachedSegmentRepository struct {
+	segment.Repository
+
+	segments *cache.LRU[int, segment.Segment]
+	lists    *cache.LRU[string, segment.ListResult]
+	loads    singleflight.Group
+
+	// mu guards generation, which is incremented by every invalidation so
+	// that reads started before it are not cached after it.
+	mu         sync.Mutex
+	generation uint64
+}
+
+// NewCachedSegmentRepository wraps repo with a cache. The configured size
+// must be positive.
+func NewCachedSegmentRepository(repo segment.Repository, clk clock.Clock, cfg CacheConfig) *CachedSegmentRepository {
+	return &CachedSegmentRepository{
+		Repository: repo,
+		segments:   cache.NewLRU[int, segment.Segment](cfg.Size, cfg.TTL, clk),
+		lists:      cache.NewLRU[string, segment.ListResult](cfg.Size, cfg.TTL, clk),
+	}
+}
+
+// Stats returns the combined statistics of the segment and list page caches.
+func (r *CachedSegmentRepository) Stats() cache.Stats {
+	return r.segments.Stats().Add(r.lists.Stats())
+}
+
+// List returns a page of segments, from the cache if present. Pages filtered
+// by liveness change with time rather than with writes, so they are not
+// cached.
+func (r *CachedSegmentRepository) List(ctx context.Context, params segment.ListParams) (*segment.ListResult, error) {
+	if params.Live != nil || readsPinned(ctx) {
+		return r.Repository.List(ctx, params)
+	}
+
+	key := listCacheKey(params)
+	if result, ok := r.lists.Get(key); ok {
+		return copyListResult(result), nil
+	}
+
+	v, err := r.load(ctx, "list:"+key, func(ctx context.Context) (any, error) {
+		result, err := r.Repository.List(ctx, params)
+		if err != nil {
+			return nil, err
+		}
+		return *copyListResult(*result), nil
+	}, func(v any) {
+		r.lists.Add(key, v.(segment.ListResult))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return copyListResult(v.(segment.ListResult)), nil
+}
+
+// Get returns a segment by ID, from the cache if present. Missing segments
+// are not cached.
+func (r *CachedSegmentRepository) Get(ctx context.Context, id int) (*segment.Segment, error) {
+	if readsPinned(ctx) {
+		return r.Repository.Get(ctx, id)
+	}
+
+	if s, ok := r.segments.Get(id); ok {
+		return &s, nil
+	}
+
+	v, err := r.load(ctx, fmt.Sprintf("segment:%d", id), func(ctx context.Context) (any, error) {
+		s, err := r.Repository.Get(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		return *s, nil
+	}, func(v any) {
+		r.segments.Add(id, v.(segment.Segment))
+	})
+	if err != nil {
+		return nil, err
+	}
+	s := v.(segment.Segment)
+	return &s, nil
+}
+
+// Create stores a new segment and invalidates the cached list pages.
+func (r *CachedSegmentRepository) Create(ctx context.Context, s *segment.Segment) (*segment.Segment, error) {
+	defer r.invalidate(s.ID())
+	return r.Repository.Create(ctx, s)
+}
+
+// Update updates a segment and invalidates it and the cached list pages.
+func (r *CachedSegmentRepository) Update(ctx context.Context, s *segment.Segment) (*segment.Segment, error) {
+	defer r.invalidate(s.ID())
+	return r.Repository.Update(ctx, s)
+}
+
+// Delete deletes a segment and invalidates it and the cached list pages.
+func (r *CachedSegmentRepository) Delete(ctx context.Context, s *segment.Segment) error {
+	defer r.invalidate(s.ID())
+	return r.Repository.Delete(ctx, s)
+}
+
+// AddMembers adds members and invalidates the segment, whose member count
+// changes, and the cached list pages.
+func (r *CachedSegmentRepository) AddMembers(ctx context.Context, segmentID int, members []segment.Member) error {
+	defer r.invalidate(segmentID)
+	return r.Repository.AddMembers(ctx, segmentID, members)
+}
+
+// RemoveMembers removes members and invalidates the segment, whose member
+// count changes, and the cached list pages.
+func (r *CachedSegmentRepository) RemoveMembers(ctx context.Context, segmentID int, memberIDs []string) error {
+	defer r.invalidate(segmentID)
+	return r.Repository.RemoveMembers(ctx, segmentID, memberIDs)
+}
+
+// PurgeExpiredMembers removes expired memberships and invalidates the whole
+// cache, since any segment's member count may change.
+func (r *CachedSegmentRepository) PurgeExpiredMembers(ctx context.Context, at time.Time) (int, error) {
+	defer r.invalidateAll()
+	return r.Repository.PurgeExpiredMembers(ctx, at)
+}
+
+// RunInTransaction runs fn on the wrapped repository's transaction, without
+// caching, and invalidates the entries changed by fn once it ends. Entries
+// are invalidated even if the transaction fails, since its outcome may be
+// unknown.
+func (r *CachedSegmentRepository) RunInTransaction(ctx context.Context, fn func(ctx context.Context, repo segment.Repository) error) error {
+	changes := &cacheChanges{}
+	defer func() {
+		if changes.all {
+			r.invalidateAll()
+		} else if len(changes.ids) > 0 {
+			r.invalidate(changes.ids...)
+		}
+	}()
+
+	return r.Repository.RunInTransaction(ctx, func(ctx context.Context, repo segment.Repository) error {
+		return fn(ctx, cachedSegmentTx{Repository: repo, changes: changes})
+	})
+}
+
+// load reads a missing cache entry with fetch and caches it with store.
+// Concurrent loads of the same key share one fetch, unless an invalidation
+// happened in between: a caller never receives a read started before its own
+// writes. The fetch is not cancelled with the caller that started it, since
+// others may be waiting for it; a cancelled caller stops waiting instead.
+func (r *CachedSegmentRepository) load(ctx context.Context, key string, fetch func(ctx context.Context) (any, error), store func(v any)) (any, error) {
+	r.mu.Lock()
+	generation := r.generation
+	r.mu.Unlock()
+
+	ch := r.loads.DoChan(fmt.Sprintf("%d/%s", generation, key), func() (any, error) {
+		v, err := fetch(context.WithoutCancel(ctx))
+		if err != nil {
+			return nil, err
+		}
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.generation == generation {
+			store(v)
+		}
+		return v, nil
+	})
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-ch:
+		return res.Val, res.Err
+	}
+}
+
+// invalidate drops the cached segments with the given IDs and every cached
+// list page.
+func (r *CachedSegmentRepository) invalidate(ids ...int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.generation++
+	for _, id := range ids {
+		r.segments.Remove(id)
+	}
+	r.lists.Purge()
+}
+
+// invalidateAll drops every cached entry.
+func (r *CachedSegmentRepository) invalidateAll() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.generation++
+	r.segments.Purge()
+	r.lists.Purge()
+}
+
+// listCacheKey identifies the page selected by params.
+func listCacheKey(params segment.ListParams) string {
+	labels := make([]string, 0, len(params.Labels))
+	for key, value := range params.Labels {
+		labels = append(labels, key+":"+value)
+	}
+	slices.Sort(labels)
+
+	states := make([]string, 0, len(params.States))
+	for _, state := range params.States {
+		states = append(states, string(state))
+	}
+	slices.Sort(states)
+
+	return fmt.Sprintf("%d/%d/%s/%s", params.Page, params.PageSize, strings.Join(labels, ","), strings.Join(states, ","))
+}
+
+// copyListResult returns a copy of result whose segments can be changed
+// without affecting the cache.
+func copyListResult(result segment.ListResult) *segment.ListResult {
+	result.Segments = slices.Clone(result.Segments)
+	return &result
+}
+
+// cacheChanges collects the segments changed within a transaction.
+type cacheChanges struct {
+	ids []int
+	// all is set when any segment may have changed.
+	all bool
+}
+
+// cachedSegmentTx is the view of a CachedSegmentRepository handed to a
+// transaction. It reads from the wrapped transaction, so that reads see the
+// transaction's own writes, and records the segments it changes.
+type cachedSegmentTx struct {
+	segment.Repository
+	changes *cacheChanges
+}
+
+func (t cachedSegmentTx) Create(ctx context.Context, s *segment.Segment) (*segment.Segment, error) {
+	t.changes.ids = append(t.changes.ids, s.ID())
+	return t.Repository.Create(ctx, s)
+}
+
+func (t cachedSegmentTx) Update(ctx context.Context, s *segment.Segment) (*segment.Segment, error) {
+	t.changes.ids = append(t.changes.ids, s.ID())
+	return t.Repository.Update(ctx, s)
+}
+
+func (t cachedSegmentTx) Delete(ctx context.Context, s *segment.Segment) error {
+	t.changes.ids = append(t.changes.ids, s.ID())
+	return t.Repository.Delete(ctx, s)
+}
+
+func (t cachedSegmentTx) AddMembers(ctx context.Context, segmentID int, members []segment.Member) error {
+	t.changes.ids = append(t.changes.ids, segmentID)
+	return t.Repository.AddMembers(ctx, segmentID, members)
+}
+
+func (t cachedSegmentTx) RemoveMembers(ctx context.Context, segmentID int, memberIDs []string) error {
+	t.changes.ids = append(t.changes.ids, segmentID)
+	return t.Repository.RemoveMembers(ctx, segmentID, memberIDs)
+}
+
+func (t cachedSegmentTx) PurgeExpiredMembers(ctx context.Context, at time.Time) (int, error) {
+	t.changes.all = true
+	return t.Repository.PurgeExpiredMembers(ctx, at)
+}
+
+func (t cachedSegmentTx) RunInTransaction(ctx context.Context, fn func(ctx context.Context, repo segment.Repository) error) error {
+	return t.Repository.RunInTransaction(ctx, func(ctx context.Context, repo segment.Repository) error {
+		return fn(ctx, cachedSegmentTx{Repository: repo, changes: t.changes})
+	})
+}
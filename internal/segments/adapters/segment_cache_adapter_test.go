@@ -0,0 +1,217 @@
+package adapters_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rickKoch/nexus/internal/segments/adapters"
+	"github.com/rickKoch/nexus/internal/segments/domain/segment"
+	"github.com/rickKoch/nexus/internal/segments/domain/segment/segmenttest"
+	"github.com/rickKoch/nexus/pkg/clock"
+)
+
+// countingRepository counts the reads reaching the wrapped repository. Reads
+// block while gate is set, until it is closed.
+type countingRepository struct {
+	segment.Repository
+	gets  atomic.Int32
+	lists atomic.Int32
+	gate  chan struct{}
+}
+
+func (r *countingRepository) Get(ctx context.Context, id int) (*segment.Segment, error) {
+	r.gets.Add(1)
+	if r.gate != nil {
+		<-r.gate
+	}
+	return r.Repository.Get(ctx, id)
+}
+
+func (r *countingRepository) List(ctx context.Context, params segment.ListParams) (*segment.ListResult, error) {
+	r.lists.Add(1)
+	return r.Repository.List(ctx, params)
+}
+
+// decoratedRepository hands out IDs from the repository a decorator wraps.
+type decoratedRepository struct {
+	segment.Repository
+	segment.IDGenerator
+}
+
+func TestCachedSegmentRepository(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)
+	factory, _ := segment.NewFactory(segment.SegmentConfig{Name: "premium-users"})
+	listParams := segment.ListParams{Page: 1, PageSize: 10}
+
+	segmenttest.RunRepositoryTests(t, func(t *testing.T) segmenttest.Repository {
+		inner := adapters.NewInMemorySegmentRepository()
+		cached := adapters.NewCachedSegmentRepository(inner, clock.System, adapters.CacheConfig{Size: 10, TTL: time.Minute})
+		return decoratedRepository{cached, inner}
+	})
+
+	setup := func(t *testing.T) (*adapters.InMemorySegmentRepository, *countingRepository, *adapters.CachedSegmentRepository, *clock.Fake) {
+		t.Helper()
+
+		inner := adapters.NewInMemorySegmentRepository()
+		if _, err := inner.Create(ctx, factory.NewSegment(1, now)); err != nil {
+			t.Fatalf("failed to create segment: %v", err)
+		}
+		counting := &countingRepository{Repository: inner}
+		clk := clock.NewFake(now)
+		cached := adapters.NewCachedSegmentRepository(counting, clk, adapters.CacheConfig{Size: 10, TTL: time.Minute})
+		return inner, counting, cached, clk
+	}
+	rename := func(t *testing.T, repo segment.Repository, name string) {
+		t.Helper()
+
+		s, err := repo.Get(ctx, 1)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if err := s.Update(segment.SegmentConfig{Name: name}, now); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if _, err := repo.Update(ctx, s); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	getName := func(t *testing.T, repo segment.Repository) string {
+		t.Helper()
+
+		s, err := repo.Get(ctx, 1)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		return s.Name()
+	}
+
+	t.Run("serves repeated reads from the cache", func(t *testing.T) {
+		_, counting, cached, _ := setup(t)
+
+		for range 3 {
+			_ = getName(t, cached)
+			if _, err := cached.List(ctx, listParams); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+		}
+		if gets, lists := counting.gets.Load(), counting.lists.Load(); gets != 1 || lists != 1 {
+			t.Errorf("expected one read of each, got %d gets and %d lists", gets, lists)
+		}
+		if stats := cached.Stats(); stats.Hits != 4 || stats.Misses != 2 || stats.Entries != 2 {
+			t.Errorf("expected 4 hits, 2 misses and 2 entries, got %+v", stats)
+		}
+
+		// Missing segments are not cached.
+		for range 2 {
+			if _, err := cached.Get(ctx, 999); !errors.Is(err, segment.ErrSegmentNotFound) {
+				t.Errorf("expected %v, got %v", segment.ErrSegmentNotFound, err)
+			}
+		}
+		if gets := counting.gets.Load(); gets != 3 {
+			t.Errorf("expected missing segments to be read every time, got %d gets", gets)
+		}
+	})
+
+	t.Run("invalidates on writes", func(t *testing.T) {
+		_, _, cached, _ := setup(t)
+		_, _ = cached.List(ctx, listParams)
+
+		rename(t, cached, "renamed")
+		if name := getName(t, cached); name != "renamed" {
+			t.Errorf("expected the update to be read, got '%s'", name)
+		}
+
+		if _, err := cached.Create(ctx, factory.NewSegment(2, now)); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		result, _ := cached.List(ctx, listParams)
+		if result.TotalCount != 2 {
+			t.Errorf("expected the new segment to be listed, got %d segments", result.TotalCount)
+		}
+
+		s, _ := cached.Get(ctx, 2)
+		if err := s.Delete(now); err != nil {
+			t.Fatalf("failed to mark segment as deleted: %v", err)
+		}
+		if err := cached.Delete(ctx, s); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if _, err := cached.Get(ctx, 2); !errors.Is(err, segment.ErrSegmentNotFound) {
+			t.Errorf("expected the deleted segment to be gone, got %v", err)
+		}
+	})
+
+	t.Run("invalidates after transactions", func(t *testing.T) {
+		_, _, cached, _ := setup(t)
+		_ = getName(t, cached)
+
+		err := cached.RunInTransaction(ctx, func(ctx context.Context, repo segment.Repository) error {
+			rename(t, repo, "renamed")
+			member, _ := factory.NewSegment(1, now).NewMember("user-1", now)
+			return repo.AddMembers(ctx, 1, []segment.Member{member})
+		})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		s, _ := cached.Get(ctx, 1)
+		if s.Name() != "renamed" || s.MemberCount() != 1 {
+			t.Errorf("expected the transaction's changes to be read, got '%s' with %d members", s.Name(), s.MemberCount())
+		}
+	})
+
+	t.Run("expires writes made elsewhere", func(t *testing.T) {
+		inner, _, cached, clk := setup(t)
+		_ = getName(t, cached)
+
+		rename(t, inner, "renamed")
+		if name := getName(t, cached); name != "premium-users" {
+			t.Errorf("expected the cached segment until it expires, got '%s'", name)
+		}
+		clk.Advance(time.Minute)
+		if name := getName(t, cached); name != "renamed" {
+			t.Errorf("expected the update to be read once expired, got '%s'", name)
+		}
+	})
+
+	t.Run("collapses concurrent misses", func(t *testing.T) {
+		_, counting, cached, _ := setup(t)
+		counting.gate = make(chan struct{})
+
+		var wg sync.WaitGroup
+		for range 10 {
+			wg.Go(func() {
+				_ = getName(t, cached)
+			})
+		}
+		// Callers arriving after the read completes hit the cache instead.
+		time.Sleep(10 * time.Millisecond)
+		close(counting.gate)
+		wg.Wait()
+
+		if gets := counting.gets.Load(); gets != 1 {
+			t.Errorf("expected one read, got %d", gets)
+		}
+	})
+
+	t.Run("stops waiting when cancelled", func(t *testing.T) {
+		_, counting, cached, _ := setup(t)
+		counting.gate = make(chan struct{})
+
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+		if _, err := cached.Get(ctx, 1); !errors.Is(err, context.Canceled) {
+			t.Errorf("expected %v, got %v", context.Canceled, err)
+		}
+
+		close(counting.gate)
+		if name := getName(t, cached); name != "premium-users" {
+			t.Errorf("expected the segment, got '%s'", name)
+		}
+	})
+}
@@ -0,0 +1,318 @@
+package adapters
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rickKoch/nexus/internal/segments/domain/segment"
+)
+
+// EventSourcedStore is a repository that also stores the history of its
+// segments, in the same transactions.
+type EventSourcedStore interface {
+	segment.Repository
+	segment.EventStore
+}
+
+// EventSourcedSegmentRepository records every change to a segment as events
+// appended to the segment's history, in the same transaction as the change
+// to the wrapped repository. The segments table of the wrapped repository
+// becomes a projection of the histories, which every read but GetAsOf is
+// served from.
+//
+// Segments saved before their history was recorded get an EventCreated of
+// their state at the time on their next change.
+type EventSourcedSegmentRepository struct {
+	segment.Repository
+	snapshotEvery int
+}
+
+// NewEventSourcedSegmentRepository returns an EventSourcedSegmentRepository
+// wrapping repo, which snapshots a segment every snapshotEvery events.
+func NewEventSourcedSegmentRepository(repo EventSourcedStore, snapshotEvery int) *EventSourcedSegmentRepository {
+	return &EventSourcedSegmentRepository{Repository: repo, snapshotEvery: snapshotEvery}
+}
+
+// Create stores a new segment and starts its history.
+func (r *EventSourcedSegmentRepository) Create(ctx context.Context, s *segment.Segment) (*segment.Segment, error) {
+	var created *segment.Segment
+	err := r.Repository.RunInTransaction(ctx, func(ctx context.Context, tx segment.Repository) error {
+		store, err := eventStore(tx)
+		if err != nil {
+			return err
+		}
+
+		if created, err = tx.Create(ctx, s); err != nil {
+			return err
+		}
+		return r.append(ctx, store, nil, 0, segment.Changes(nil, created))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// Update stores the changes to a segment and records them as events.
+func (r *EventSourcedSegmentRepository) Update(ctx context.Context, s *segment.Segment) (*segment.Segment, error) {
+	var updated *segment.Segment
+	err := r.change(ctx, s.ID(), func(tx segment.Repository) (*segment.Segment, error) {
+		var err error
+		updated, err = tx.Update(ctx, s)
+		return updated, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// Delete stores the deletion of a segment and ends its history.
+func (r *EventSourcedSegmentRepository) Delete(ctx context.Context, s *segment.Segment) error {
+	return r.change(ctx, s.ID(), func(tx segment.Repository) (*segment.Segment, error) {
+		return s, tx.Delete(ctx, s)
+	})
+}
+
+// RunInTransaction runs fn with a repository recording the changes made in
+// the transaction as events.
+func (r *EventSourcedSegmentRepository) RunInTransaction(ctx context.Context, fn func(ctx context.Context, repo segment.Repository) error) error {
+	return r.Repository.RunInTransaction(ctx, func(ctx context.Context, tx segment.Repository) error {
+		return fn(ctx, &EventSourcedSegmentRepository{Repository: tx, snapshotEvery: r.snapshotEvery})
+	})
+}
+
+// GetAsOf folds the history of a segment up to at. The member count is the
+// one recorded in the size history on the day of at, or zero if there is
+// none, as memberships are not part of the history.
+func (r *EventSourcedSegmentRepository) GetAsOf(ctx context.Context, id int, at time.Time) (*segment.Segment, error) {
+	store, err := eventStore(r.Repository)
+	if err != nil {
+		return nil, err
+	}
+
+	s, _, err := fold(ctx, store, id, &at)
+	if err != nil {
+		return nil, fmt.Errorf("failed to replay segment '%d': %w", id, err)
+	}
+	if s == nil || s.IsDeleted() {
+		return nil, ErrSegmentNotFound
+	}
+
+	var memberCount int
+	sizes, err := r.ListSizeHistory(ctx, id, at, at)
+	if err != nil {
+		return nil, err
+	}
+	if len(sizes) > 0 {
+		memberCount = sizes[0].MemberCount
+	}
+	return withMemberCount(s, memberCount), nil
+}
+
+// change runs write in a transaction and records how the segment it returns
+// differs from the segment with the given ID folded from its history.
+func (r *EventSourcedSegmentRepository) change(ctx context.Context, id int, write func(tx segment.Repository) (*segment.Segment, error)) error {
+	return r.Repository.RunInTransaction(ctx, func(ctx context.Context, tx segment.Repository) error {
+		store, err := eventStore(tx)
+		if err != nil {
+			return err
+		}
+
+		current, version, err := fold(ctx, store, id, nil)
+		if err != nil {
+			return fmt.Errorf("failed to replay segment '%d': %w", id, err)
+		}
+		var events []segment.Event
+		before := current
+		if current == nil {
+			// The segment predates its history, which starts from the
+			// segment as stored.
+			if before, err = tx.Get(ctx, id); err != nil {
+				return err
+			}
+			events = segment.Changes(nil, before)
+		}
+
+		after, err := write(tx)
+		if err != nil {
+			return err
+		}
+		return r.append(ctx, store, current, version, append(events, segment.Changes(before, after)...))
+	})
+}
+
+// append appends events to the history of the segment folded into current
+// at version, snapshotting the result when it reaches the next multiple of
+// snapshotEvery events.
+func (r *EventSourcedSegmentRepository) append(ctx context.Context, store segment.EventStore, current *segment.Segment, version int, events []segment.Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	id := events[0].SegmentID
+	for i := range events {
+		events[i].Version = version + i + 1
+	}
+	if err := store.AppendEvents(ctx, id, version, events); err != nil {
+		return fmt.Errorf("failed to append events of segment '%d': %w", id, err)
+	}
+
+	last := events[len(events)-1]
+	if last.Version/r.snapshotEvery == version/r.snapshotEvery {
+		return nil
+	}
+	s, err := segment.Replay(current, events)
+	if err != nil {
+		return fmt.Errorf("failed to replay segment '%d': %w", id, err)
+	}
+	if err := store.SaveSnapshot(ctx, id, segment.Snapshot{Version: last.Version, At: last.At, Segment: s}); err != nil {
+		return fmt.Errorf("failed to save snapshot of segment '%d': %w", id, err)
+	}
+	return nil
+}
+
+// fold rebuilds the segment with the given ID from its latest snapshot and
+// the events after it, up to until, and returns it with the version of the
+// last event. It returns a nil segment if the segment has no history.
+func fold(ctx context.Context, store segment.EventStore, id int, until *time.Time) (*segment.Segment, int, error) {
+	snapshot, err := store.LoadSnapshot(ctx, id, until)
+	if err != nil {
+		return nil, 0, err
+	}
+	var from *segment.Segment
+	var version int
+	if snapshot != nil {
+		from, version = snapshot.Segment, snapshot.Version
+	}
+
+	events, err := store.LoadEvents(ctx, id, version, until)
+	if err != nil {
+		return nil, 0, err
+	}
+	if from == nil && len(events) == 0 {
+		return nil, 0, nil
+	}
+	if len(events) > 0 {
+		version = events[len(events)-1].Version
+	}
+
+	s, err := segment.Replay(from, events)
+	if err != nil {
+		return nil, 0, err
+	}
+	return s, version, nil
+}
+
+func eventStore(repo segment.Repository) (segment.EventStore, error) {
+	store, ok := repo.(segment.EventStore)
+	if !ok {
+		return nil, errors.New("repository does not store segment events")
+	}
+	return store, nil
+}
+
+func withMemberCount(s *segment.Segment, memberCount int) *segment.Segment {
+	return segment.UnmarshalSegmentFromDatabase(
+		s.ID(), s.Name(), s.Description(), s.Labels(), s.TTLSeconds(),
+		s.ActiveFrom(), s.ActiveUntil(), s.Expression(), s.State(), memberCount,
+		s.CreatedAt(), s.UpdatedAt(), s.DeletedAt(),
+	)
+}
+
+// eventPayload is the encoded form of the fields an event sets. Composite
+// expressions are stored in their canonical textual form.
+type eventPayload struct {
+	Name        string            `json:"name,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	TTLSeconds  *int              `json:"ttl_seconds,omitempty"`
+	ActiveFrom  *time.Time        `json:"active_from,omitempty"`
+	ActiveUntil *time.Time        `json:"active_until,omitempty"`
+	Expression  string            `json:"expression,omitempty"`
+	State       string            `json:"state,omitempty"`
+}
+
+func encodeEventPayload(e segment.Event) (string, error) {
+	var p eventPayload
+	switch e.Type {
+	case segment.EventCreated, segment.EventUpdated:
+		c := e.Config
+		p = eventPayload{
+			Name:        c.Name,
+			Description: c.Description,
+			Labels:      c.Labels,
+			TTLSeconds:  c.TTLSeconds,
+			ActiveFrom:  c.ActiveFrom,
+			ActiveUntil: c.ActiveUntil,
+			State:       string(c.State),
+		}
+		if c.Expression != nil {
+			p.Expression = c.Expression.String()
+		}
+	case segment.EventTransitioned:
+		p.State = string(e.State)
+	}
+
+	data, err := json.Marshal(p)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func decodeEvent(segmentID, version int, eventType string, at time.Time, payload []byte) (segment.Event, error) {
+	e := segment.Event{SegmentID: segmentID, Version: version, Type: segment.EventType(eventType), At: at}
+
+	var p eventPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return e, fmt.Errorf("failed to decode event %d of segment '%d': %w", version, segmentID, err)
+	}
+	var expr *segment.Expression
+	if p.Expression != "" {
+		var err error
+		if expr, err = segment.ParseExpression(p.Expression); err != nil {
+			return e, fmt.Errorf("failed to parse expression of event %d of segment '%d': %w", version, segmentID, err)
+		}
+	}
+
+	switch e.Type {
+	case segment.EventCreated, segment.EventUpdated:
+		e.Config = segment.SegmentConfig{
+			Name:        p.Name,
+			Description: p.Description,
+			Labels:      segment.Labels(p.Labels),
+			TTLSeconds:  p.TTLSeconds,
+			ActiveFrom:  p.ActiveFrom,
+			ActiveUntil: p.ActiveUntil,
+			Expression:  expr,
+			State:       segment.State(p.State),
+		}
+	case segment.EventTransitioned:
+		e.State = segment.State(p.State)
+	}
+	return e, nil
+}
+
+func encodeSnapshot(s *segment.Segment) (string, error) {
+	data, err := json.Marshal(newJournalSegment(s))
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func decodeSnapshot(version int, at time.Time, data []byte) (*segment.Snapshot, error) {
+	var js journalSegment
+	if err := json.Unmarshal(data, &js); err != nil {
+		return nil, fmt.Errorf("failed to decode snapshot %d: %w", version, err)
+	}
+	s, err := js.toSegment()
+	if err != nil {
+		return nil, err
+	}
+	return &segment.Snapshot{Version: version, At: at, Segment: s}, nil
+}
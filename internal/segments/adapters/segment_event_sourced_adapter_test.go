@@ -0,0 +1,216 @@
+package adapters_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rickKoch/nexus/internal/segments/adapters"
+	"github.com/rickKoch/nexus/internal/segments/adapters/migrations"
+	"github.com/rickKoch/nexus/internal/segments/domain/segment"
+	"github.com/rickKoch/nexus/internal/segments/domain/segment/segmenttest"
+	"github.com/rickKoch/nexus/pkg/migrate"
+)
+
+// eventSourcedRepository hands out IDs from the repository the event-sourced
+// one wraps.
+type eventSourcedRepository struct {
+	*adapters.EventSourcedSegmentRepository
+	segment.IDGenerator
+}
+
+func TestEventSourcedSegmentRepository(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 11, 1, 12, 30, 0, 0, time.UTC)
+
+	open := func(t *testing.T) *adapters.SQLiteSegmentRepository {
+		t.Helper()
+
+		db, err := adapters.NewSQLiteConnection(adapters.SQLiteConfig{Path: filepath.Join(t.TempDir(), "nexus.db")})
+		if err != nil {
+			t.Fatalf("failed to open database: %v", err)
+		}
+		t.Cleanup(func() { _ = db.Close() })
+
+		m, err := migrate.New(db, migrations.SQLiteFS)
+		if err != nil {
+			t.Fatalf("failed to load migrations: %v", err)
+		}
+		if _, err := m.Up(ctx); err != nil {
+			t.Fatalf("failed to apply migrations: %v", err)
+		}
+		return adapters.NewSQLiteSegmentRepository(db)
+	}
+	create := func(t *testing.T, repo segment.Repository, ids segment.IDGenerator, name string, at time.Time) *segment.Segment {
+		t.Helper()
+
+		f, _ := segment.NewFactory(segment.SegmentConfig{Name: name})
+		id, _ := ids.NextID(ctx)
+		s, err := repo.Create(ctx, f.NewSegment(id, at))
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		return s
+	}
+	rename := func(t *testing.T, repo segment.Repository, s *segment.Segment, name string, at time.Time) *segment.Segment {
+		t.Helper()
+
+		if err := s.Update(segment.SegmentConfig{Name: name, Labels: s.Labels()}, at); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		updated, err := repo.Update(ctx, s)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		return updated
+	}
+
+	segmenttest.RunRepositoryTests(t, func(t *testing.T) segmenttest.Repository {
+		inner := open(t)
+		return eventSourcedRepository{adapters.NewEventSourcedSegmentRepository(inner, 2), inner}
+	})
+
+	t.Run("reads segments as they were", func(t *testing.T) {
+		inner := open(t)
+		repo := adapters.NewEventSourcedSegmentRepository(inner, 100)
+
+		s := create(t, repo, inner, "premium-users", now)
+		s = rename(t, repo, s, "vip-users", now.Add(time.Hour))
+		if err := s.TransitionTo(segment.StateActive, now.Add(2*time.Hour)); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if _, err := repo.Update(ctx, s); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if err := s.Delete(now.Add(3 * time.Hour)); err != nil {
+			t.Fatalf("failed to mark segment as deleted: %v", err)
+		}
+		if err := repo.Delete(ctx, s); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		tests := []struct {
+			at      time.Time
+			name    string
+			state   segment.State
+			updated time.Time
+		}{
+			{now, "premium-users", segment.StateDraft, now},
+			{now.Add(90 * time.Minute), "vip-users", segment.StateDraft, now.Add(time.Hour)},
+			{now.Add(2 * time.Hour), "vip-users", segment.StateActive, now.Add(2 * time.Hour)},
+		}
+		for _, tt := range tests {
+			got, err := repo.GetAsOf(ctx, s.ID(), tt.at)
+			if err != nil {
+				t.Fatalf("expected no error at %s, got %v", tt.at, err)
+			}
+			if got.Name() != tt.name || got.State() != tt.state || !got.UpdatedAt().Equal(tt.updated) {
+				t.Errorf("expected %s %s at %s, got %s %s updated at %s", tt.state, tt.name, tt.at, got.State(), got.Name(), got.UpdatedAt())
+			}
+		}
+
+		for _, at := range []time.Time{now.Add(-time.Second), now.Add(3 * time.Hour)} {
+			if _, err := repo.GetAsOf(ctx, s.ID(), at); !errors.Is(err, segment.ErrSegmentNotFound) {
+				t.Errorf("expected %v at %s, got %v", segment.ErrSegmentNotFound, at, err)
+			}
+		}
+	})
+
+	t.Run("replays from snapshots", func(t *testing.T) {
+		inner := open(t)
+		repo := adapters.NewEventSourcedSegmentRepository(inner, 3)
+
+		s := create(t, repo, inner, "name-0", now)
+		for i := 1; i <= 7; i++ {
+			s = rename(t, repo, s, fmt.Sprintf("name-%d", i), now.Add(time.Duration(i)*time.Hour))
+		}
+
+		snapshot, err := inner.LoadSnapshot(ctx, s.ID(), nil)
+		if err != nil || snapshot == nil || snapshot.Version != 6 || snapshot.Segment.Name() != "name-5" {
+			t.Fatalf("expected a snapshot of version 6, got %+v (%v)", snapshot, err)
+		}
+		for i := 0; i <= 7; i++ {
+			got, err := repo.GetAsOf(ctx, s.ID(), now.Add(time.Duration(i)*time.Hour))
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if want := fmt.Sprintf("name-%d", i); got.Name() != want {
+				t.Errorf("expected %s, got %s", want, got.Name())
+			}
+		}
+	})
+
+	t.Run("starts the history of existing segments from their stored state", func(t *testing.T) {
+		inner := open(t)
+		repo := adapters.NewEventSourcedSegmentRepository(inner, 100)
+
+		s := create(t, inner, inner, "premium-users", now)
+		rename(t, repo, s, "vip-users", now.Add(time.Hour))
+
+		events, err := inner.LoadEvents(ctx, s.ID(), 0, nil)
+		if err != nil || len(events) != 2 || events[0].Type != segment.EventCreated || events[1].Type != segment.EventUpdated {
+			t.Fatalf("expected created and updated events, got %+v (%v)", events, err)
+		}
+		got, err := repo.GetAsOf(ctx, s.ID(), now)
+		if err != nil || got.Name() != "premium-users" {
+			t.Errorf("expected premium-users, got %v (%v)", got, err)
+		}
+	})
+
+	t.Run("discards the events of rolled back transactions", func(t *testing.T) {
+		inner := open(t)
+		repo := adapters.NewEventSourcedSegmentRepository(inner, 100)
+		s := create(t, repo, inner, "premium-users", now)
+
+		errRollback := errors.New("rollback")
+		err := repo.RunInTransaction(ctx, func(ctx context.Context, tx segment.Repository) error {
+			rename(t, tx, s, "vip-users", now.Add(time.Hour))
+			return errRollback
+		})
+		if !errors.Is(err, errRollback) {
+			t.Fatalf("expected the rollback, got %v", err)
+		}
+
+		events, err := inner.LoadEvents(ctx, s.ID(), 0, nil)
+		if err != nil || len(events) != 1 {
+			t.Errorf("expected only the created event, got %+v (%v)", events, err)
+		}
+	})
+
+	t.Run("rejects events appended concurrently", func(t *testing.T) {
+		inner := open(t)
+		repo := adapters.NewEventSourcedSegmentRepository(inner, 100)
+		s := create(t, repo, inner, "premium-users", now)
+
+		err := inner.AppendEvents(ctx, s.ID(), 0, []segment.Event{{SegmentID: s.ID(), Version: 1, Type: segment.EventDeleted, At: now}})
+		if !errors.Is(err, segment.ErrVersionConflict) {
+			t.Errorf("expected %v, got %v", segment.ErrVersionConflict, err)
+		}
+	})
+
+	t.Run("reports the recorded member count", func(t *testing.T) {
+		inner := open(t)
+		repo := adapters.NewEventSourcedSegmentRepository(inner, 100)
+		s := create(t, repo, inner, "premium-users", now)
+
+		m, _ := s.NewMember("user-1", now)
+		if err := repo.AddMembers(ctx, s.ID(), []segment.Member{m}); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if err := repo.RecordSizeSnapshots(ctx, now); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		got, err := repo.GetAsOf(ctx, s.ID(), now.Add(time.Hour))
+		if err != nil || got.MemberCount() != 1 {
+			t.Errorf("expected 1 member, got %v (%v)", got, err)
+		}
+		got, err = repo.GetAsOf(ctx, s.ID(), now.AddDate(0, 0, 1))
+		if err != nil || got.MemberCount() != 0 {
+			t.Errorf("expected no recorded members, got %v (%v)", got, err)
+		}
+	})
+}
@@ -2,40 +2,297 @@ package adapters
 
 import (
 	"context"
-	"errors"
+	"fmt"
+	"slices"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/rickKoch/nexus/internal/segments/domain/segment"
 )
 
 // ErrSegmentNotFound is returned when a segment is not found.
-var ErrSegmentNotFound = errors.New("segment not found")
+var ErrSegmentNotFound = segment.ErrSegmentNotFound
 
 // InMemorySegmentRepository is an in-memory implementation of segment.Repository.
+// It is also a segment.IDGenerator handing out sequential IDs.
 type InMemorySegmentRepository struct {
 	mu       sync.RWMutex
 	segments map[int]*segment.Segment
-	nextID   int
+	// members holds the memberships of each segment by member ID.
+	members map[int]map[string]segment.Member
+	// sizeHistory holds the member count of each segment by day.
+	sizeHistory map[int]map[time.Time]int
+	// sketches holds the sketch of each segment's members. Stored sketches
+	// are never modified, only replaced.
+	sketches map[int]*segment.Sketch
+	// revisions holds the revisions of each segment, the segment of version
+	// n at index n-1. Stored revisions are never modified, only appended.
+	revisions map[int][]*segment.Segment
+	// lastID is the last ID handed out. Like a database sequence, it is not
+	// rolled back with a failed transaction.
+	lastID atomic.Int64
+	// journal makes the repository durable. It is nil for a volatile
+	// repository.
+	journal *segmentJournal
+	// undo records what the running transaction changed. It is nil outside
+	// of RunInTransaction.
+	undo *inMemoryUndo
 }
 
-// NewInMemorySegmentRepository creates a new in-memory segment repository.
+// NewInMemorySegmentRepository creates a new volatile in-memory segment
+// repository. Use OpenInMemorySegmentRepository for a durable one.
 func NewInMemorySegmentRepository() *InMemorySegmentRepository {
 	return &InMemorySegmentRepository{
-		segments: make(map[int]*segment.Segment),
-		nextID:   1,
+		segments:    make(map[int]*segment.Segment),
+		members:     make(map[int]map[string]segment.Member),
+		sizeHistory: make(map[int]map[time.Time]int),
+		sketches:    make(map[int]*segment.Sketch),
+		revisions:   make(map[int][]*segment.Segment),
 	}
 }
 
+// NextID returns the next sequential segment ID, starting at 1.
+func (r *InMemorySegmentRepository) NextID(ctx context.Context) (int, error) {
+	return int(r.lastID.Add(1)), nil
+}
+
 // List returns paginated non-deleted segments.
 func (r *InMemorySegmentRepository) List(ctx context.Context, params segment.ListParams) (*segment.ListResult, error) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
-	// Collect all non-deleted segments
+	return r.list(params), nil
+}
+
+// Get returns a segment by ID.
+func (r *InMemorySegmentRepository) Get(ctx context.Context, id int) (*segment.Segment, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.get(id)
+}
+
+// GetShared returns a segment by ID, like Get.
+func (r *InMemorySegmentRepository) GetShared(ctx context.Context, id int) (*segment.Segment, error) {
+	return r.Get(ctx, id)
+}
+
+// Create stores a new segment.
+func (r *InMemorySegmentRepository) Create(ctx context.Context, s *segment.Segment) (*segment.Segment, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.record(journalOp{kind: opCreate, segment: s}); err != nil {
+		return nil, err
+	}
+	return r.create(s)
+}
+
+// Update updates an existing segment.
+func (r *InMemorySegmentRepository) Update(ctx context.Context, s *segment.Segment) (*segment.Segment, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.record(journalOp{kind: opUpdate, segment: s}); err != nil {
+		return nil, err
+	}
+	return r.update(s)
+}
+
+// Delete stores the deletion of a segment.
+func (r *InMemorySegmentRepository) Delete(ctx context.Context, s *segment.Segment) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.record(journalOp{kind: opDelete, segment: s}); err != nil {
+		return err
+	}
+	return r.delete(s)
+}
+
+// ListRevisions returns the revisions of a segment, oldest first.
+func (r *InMemorySegmentRepository) ListRevisions(ctx context.Context, segmentID int) ([]segment.Revision, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.listRevisions(segmentID)
+}
+
+// GetRevision returns a revision of a segment.
+func (r *InMemorySegmentRepository) GetRevision(ctx context.Context, segmentID, version int) (*segment.Revision, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.getRevision(segmentID, version)
+}
+
+// ListReferencing returns the composite segments referencing id.
+func (r *InMemorySegmentRepository) ListReferencing(ctx context.Context, id int) ([]segment.Segment, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.listReferencing(id), nil
+}
+
+// ListWindowBoundaries returns the segments whose activation window opens or
+// closes in (after, until].
+func (r *InMemorySegmentRepository) ListWindowBoundaries(ctx context.Context, after, until time.Time) ([]segment.Segment, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.listWindowBoundaries(after, until), nil
+}
+
+// AddMembers stores memberships of a segment.
+func (r *InMemorySegmentRepository) AddMembers(ctx context.Context, segmentID int, members []segment.Member) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.record(journalOp{kind: opAddMembers, segmentID: segmentID, members: members}); err != nil {
+		return err
+	}
+	return r.addMembers(segmentID, members)
+}
+
+// RemoveMembers removes memberships of a segment.
+func (r *InMemorySegmentRepository) RemoveMembers(ctx context.Context, segmentID int, memberIDs []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.record(journalOp{kind: opRemoveMembers, segmentID: segmentID, memberIDs: memberIDs}); err != nil {
+		return err
+	}
+	return r.removeMembers(segmentID, memberIDs)
+}
+
+// StreamMembers calls fn with the subjects selected by q. The matching IDs
+// are collected under the read lock, so a slow fn does not block writers.
+func (r *InMemorySegmentRepository) StreamMembers(ctx context.Context, q segment.MemberQuery, fn func(memberID string) error) error {
+	r.mu.RLock()
+	ids := r.selectMembers(q)
+	r.mu.RUnlock()
+
+	return streamIDs(ctx, ids, fn)
+}
+
+// StreamMemberships calls fn with the memberships of a segment, collected
+// under the read lock like StreamMembers.
+func (r *InMemorySegmentRepository) StreamMemberships(ctx context.Context, segmentID int, fn func(m segment.Member) error) error {
+	r.mu.RLock()
+	members := r.memberships(segmentID)
+	r.mu.RUnlock()
+
+	return streamMemberList(ctx, members, fn)
+}
+
+// ListMemberships returns the segments among segmentIDs the subject is a
+// live member of.
+func (r *InMemorySegmentRepository) ListMemberships(ctx context.Context, memberID string, segmentIDs []int, at time.Time) ([]int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.listMemberships(memberID, segmentIDs, at), nil
+}
+
+// PurgeExpiredMembers removes the memberships that expired at or before at.
+func (r *InMemorySegmentRepository) PurgeExpiredMembers(ctx context.Context, at time.Time) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.record(journalOp{kind: opPurgeExpired, at: at}); err != nil {
+		return 0, err
+	}
+	return r.purgeExpiredMembers(at), nil
+}
+
+// RecordSizeSnapshots stores the member count of every regular segment as
+// its size on day.
+func (r *InMemorySegmentRepository) RecordSizeSnapshots(ctx context.Context, day time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.record(journalOp{kind: opRecordSizes, at: day}); err != nil {
+		return err
+	}
+	r.recordSizeSnapshots(day)
+	return nil
+}
+
+// ListSizeHistory returns the size snapshots of a segment between from and
+// to inclusive.
+func (r *InMemorySegmentRepository) ListSizeHistory(ctx context.Context, segmentID int, from, to time.Time) ([]segment.SizeSnapshot, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.listSizeHistory(segmentID, from, to), nil
+}
+
+// GetSketch returns a copy of the sketch of a segment's members.
+func (r *InMemorySegmentRepository) GetSketch(ctx context.Context, segmentID int) (*segment.Sketch, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.getSketch(segmentID)
+}
+
+// SaveSketch stores a copy of the sketch of a segment's members.
+func (r *InMemorySegmentRepository) SaveSketch(ctx context.Context, segmentID int, sketch *segment.Sketch) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.record(journalOp{kind: opSaveSketch, segmentID: segmentID, sketch: sketch}); err != nil {
+		return err
+	}
+	return r.saveSketch(segmentID, sketch)
+}
+
+// ListUnsketchedSegments returns the regular segments without a sketch.
+func (r *InMemorySegmentRepository) ListUnsketchedSegments(ctx context.Context) ([]int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.listUnsketchedSegments(), nil
+}
+
+// RunInTransaction runs fn while holding the write lock, and restores the
+// segments, members, size history, sketches and revisions fn changed if it
+// fails. A durable repository journals the transaction when fn succeeds, and
+// rolls it back as well if that fails.
+func (r *InMemorySegmentRepository) RunInTransaction(ctx context.Context, fn func(ctx context.Context, repo segment.Repository) error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.undo = newInMemoryUndo()
+	defer func() { r.undo = nil }()
+
+	if r.journal != nil {
+		r.journal.pending = []journalRecord{}
+		defer func() { r.journal.pending = nil }()
+	}
+
+	if err := fn(ctx, inMemorySegmentTx{r}); err != nil {
+		r.rollback()
+		return err
+	}
+
+	if r.journal != nil && len(r.journal.pending) > 0 {
+		if err := r.journal.append(r.journal.pending, r.lastID.Load()); err != nil {
+			r.rollback()
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *InMemorySegmentRepository) list(params segment.ListParams) *segment.ListResult {
+	// Collect all non-deleted segments matching the filters
 	all := make([]segment.Segment, 0, len(r.segments))
 	for _, s := range r.segments {
-		if !s.IsDeleted() {
+		if !s.IsDeleted() && params.Labels.Matches(s.Labels()) && hasState(params.States, s.State()) &&
+			(params.Live == nil || s.IsMatchable(params.At) == *params.Live) {
 			all = append(all, *s)
 		}
 	}
@@ -59,7 +316,7 @@ func (r *InMemorySegmentRepository) List(ctx context.Context, params segment.Lis
 			TotalCount: totalCount,
 			Page:       params.Page,
 			PageSize:   params.PageSize,
-		}, nil
+		}
 	}
 
 	end := start + params.PageSize
@@ -72,50 +329,290 @@ func (r *InMemorySegmentRepository) List(ctx context.Context, params segment.Lis
 		TotalCount: totalCount,
 		Page:       params.Page,
 		PageSize:   params.PageSize,
-	}, nil
+	}
 }
 
-// Get returns a segment by ID.
-func (r *InMemorySegmentRepository) Get(ctx context.Context, id int) (*segment.Segment, error) {
-	r.mu.RLock()
-	defer r.mu.RUnlock()
+func (r *InMemorySegmentRepository) addMembers(segmentID int, members []segment.Member) error {
+	if s, ok := r.segments[segmentID]; !ok || s.IsDeleted() {
+		return ErrSegmentNotFound
+	}
+
+	if r.members[segmentID] == nil {
+		r.members[segmentID] = make(map[string]segment.Member, len(members))
+	}
+	for _, m := range members {
+		r.backupMember(segmentID, m.ID)
+		r.members[segmentID][m.ID] = m
+	}
+	r.countMembers(segmentID)
+	return nil
+}
+
+func (r *InMemorySegmentRepository) removeMembers(segmentID int, memberIDs []string) error {
+	if s, ok := r.segments[segmentID]; !ok || s.IsDeleted() {
+		return ErrSegmentNotFound
+	}
+
+	before := len(r.members[segmentID])
+	for _, id := range memberIDs {
+		r.backupMember(segmentID, id)
+		delete(r.members[segmentID], id)
+	}
+	if len(r.members[segmentID]) != before {
+		r.backupSketch(segmentID)
+		delete(r.sketches, segmentID)
+	}
+	r.countMembers(segmentID)
+	return nil
+}
+
+// countMembers stores the number of memberships of a segment on the segment.
+func (r *InMemorySegmentRepository) countMembers(segmentID int) {
+	s := r.segments[segmentID]
+	r.backupSegment(segmentID)
+	r.segments[segmentID] = segment.UnmarshalSegmentFromDatabase(
+		s.ID(),
+		s.Name(),
+		s.Description(),
+		s.Labels(),
+		s.TTLSeconds(),
+		s.ActiveFrom(),
+		s.ActiveUntil(),
+		s.Expression(),
+		s.State(),
+		len(r.members[segmentID]),
+		s.CreatedAt(),
+		s.UpdatedAt(),
+		s.DeletedAt(),
+	)
+}
+
+func (r *InMemorySegmentRepository) purgeExpiredMembers(at time.Time) int {
+	purged := 0
+	for segmentID, members := range r.members {
+		before := len(members)
+		for id, m := range members {
+			if !m.IsLive(at) {
+				r.backupMember(segmentID, id)
+				delete(members, id)
+			}
+		}
+		if len(members) != before {
+			purged += before - len(members)
+			r.backupSketch(segmentID)
+			delete(r.sketches, segmentID)
+			r.countMembers(segmentID)
+		}
+	}
+	return purged
+}
+
+func (r *InMemorySegmentRepository) recordSizeSnapshots(day time.Time) {
+	day = segment.Day(day)
+	for id, s := range r.segments {
+		if s.IsDeleted() || s.IsComposite() {
+			continue
+		}
+		if r.sizeHistory[id] == nil {
+			r.sizeHistory[id] = make(map[time.Time]int)
+		}
+		r.backupSize(id, day)
+		r.sizeHistory[id][day] = s.MemberCount()
+	}
+}
+
+func (r *InMemorySegmentRepository) listSizeHistory(segmentID int, from, to time.Time) []segment.SizeSnapshot {
+	from, to = segment.Day(from), segment.Day(to)
+
+	snapshots := make([]segment.SizeSnapshot, 0)
+	for day, count := range r.sizeHistory[segmentID] {
+		if !day.Before(from) && !day.After(to) {
+			snapshots = append(snapshots, segment.SizeSnapshot{SegmentID: segmentID, Day: day, MemberCount: count})
+		}
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].Day.Before(snapshots[j].Day) })
+	return snapshots
+}
+
+// selectMembers returns the sorted IDs of the subjects selected by q.
+func (r *InMemorySegmentRepository) selectMembers(q segment.MemberQuery) []string {
+	refs := q.Expression.SegmentIDs()
+
+	candidates := make(map[string]bool)
+	for _, id := range refs {
+		for memberID, m := range r.members[id] {
+			if memberID > q.After && m.IsLive(q.At) {
+				candidates[memberID] = true
+			}
+		}
+	}
+
+	ids := make([]string, 0, len(candidates))
+	for memberID := range candidates {
+		isMember := func(segmentID int) bool {
+			m, ok := r.members[segmentID][memberID]
+			return ok && m.IsLive(q.At)
+		}
+		if q.Expression.Evaluate(isMember) {
+			ids = append(ids, memberID)
+		}
+	}
+
+	sort.Strings(ids)
+	return ids
+}
 
+// memberships returns the memberships of a segment sorted by member ID.
+func (r *InMemorySegmentRepository) memberships(segmentID int) []segment.Member {
+	members := make([]segment.Member, 0, len(r.members[segmentID]))
+	for _, m := range r.members[segmentID] {
+		members = append(members, m)
+	}
+
+	sort.Slice(members, func(i, j int) bool { return members[i].ID < members[j].ID })
+	return members
+}
+
+func (r *InMemorySegmentRepository) listMemberships(memberID string, segmentIDs []int, at time.Time) []int {
+	ids := []int{}
+	for _, id := range segmentIDs {
+		if m, ok := r.members[id][memberID]; ok && m.IsLive(at) {
+			ids = append(ids, id)
+		}
+	}
+
+	slices.Sort(ids)
+	return slices.Compact(ids)
+}
+
+func (r *InMemorySegmentRepository) getSketch(segmentID int) (*segment.Sketch, error) {
+	if s, ok := r.segments[segmentID]; !ok || s.IsDeleted() {
+		return nil, ErrSegmentNotFound
+	}
+
+	sketch, ok := r.sketches[segmentID]
+	if !ok {
+		return nil, nil
+	}
+	return sketch.Clone(), nil
+}
+
+func (r *InMemorySegmentRepository) saveSketch(segmentID int, sketch *segment.Sketch) error {
+	if s, ok := r.segments[segmentID]; !ok || s.IsDeleted() {
+		return ErrSegmentNotFound
+	}
+
+	r.backupSketch(segmentID)
+	r.sketches[segmentID] = sketch.Clone()
+	return nil
+}
+
+func (r *InMemorySegmentRepository) listUnsketchedSegments() []int {
+	var ids []int
+	for id, s := range r.segments {
+		if _, ok := r.sketches[id]; !ok && !s.IsDeleted() && !s.IsComposite() {
+			ids = append(ids, id)
+		}
+	}
+
+	sort.Ints(ids)
+	return ids
+}
+
+// streamIDs calls fn with every ID until fn fails or ctx is done.
+func streamIDs(ctx context.Context, ids []string, fn func(memberID string) error) error {
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// streamMemberList calls fn with every membership until fn fails or ctx is
+// done.
+func streamMemberList(ctx context.Context, members []segment.Member, fn func(m segment.Member) error) error {
+	for _, m := range members {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *InMemorySegmentRepository) listReferencing(id int) []segment.Segment {
+	var segments []segment.Segment
+	for _, s := range r.segments {
+		if !s.IsDeleted() && s.References(id) {
+			segments = append(segments, *s)
+		}
+	}
+
+	sort.Slice(segments, func(i, j int) bool { return segments[i].ID() < segments[j].ID() })
+	return segments
+}
+
+func (r *InMemorySegmentRepository) listWindowBoundaries(after, until time.Time) []segment.Segment {
+	var segments []segment.Segment
+	for _, s := range r.segments {
+		if !s.IsDeleted() && len(s.WindowEvents(after, until)) > 0 {
+			segments = append(segments, *s)
+		}
+	}
+
+	sort.Slice(segments, func(i, j int) bool { return segments[i].ID() < segments[j].ID() })
+	return segments
+}
+
+func (r *InMemorySegmentRepository) get(id int) (*segment.Segment, error) {
 	s, ok := r.segments[id]
 	if !ok || s.IsDeleted() {
 		return nil, ErrSegmentNotFound
 	}
 
-	return s, nil
+	// Callers get their own copy, so changes only land through Update and Delete.
+	cp := *s
+	return &cp, nil
 }
 
-// Create stores a new segment and returns it with an assigned ID.
-func (r *InMemorySegmentRepository) Create(ctx context.Context, s *segment.Segment) (*segment.Segment, error) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-
-	id := r.nextID
-	r.nextID++
+func (r *InMemorySegmentRepository) create(s *segment.Segment) (*segment.Segment, error) {
+	if _, ok := r.segments[s.ID()]; ok {
+		return nil, fmt.Errorf("segment '%d' already exists", s.ID())
+	}
 
-	now := time.Now()
 	newSegment := segment.UnmarshalSegmentFromDatabase(
-		id,
+		s.ID(),
 		s.Name(),
+		s.Description(),
+		s.Labels(),
 		s.TTLSeconds(),
-		now,
-		now,
+		s.ActiveFrom(),
+		s.ActiveUntil(),
+		s.Expression(),
+		s.State(),
+		0,
+		s.CreatedAt(),
+		s.UpdatedAt(),
 		nil,
 	)
 
-	r.segments[id] = newSegment
+	r.backupSegment(s.ID())
+	r.backupRevisions(s.ID())
+	r.segments[s.ID()] = newSegment
+	r.revisions[s.ID()] = []*segment.Segment{newSegment}
 
-	return newSegment, nil
+	cp := *newSegment
+	return &cp, nil
 }
 
-// Update updates an existing segment.
-func (r *InMemorySegmentRepository) Update(ctx context.Context, s *segment.Segment) (*segment.Segment, error) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-
+func (r *InMemorySegmentRepository) update(s *segment.Segment) (*segment.Segment, error) {
 	existing, ok := r.segments[s.ID()]
 	if !ok || existing.IsDeleted() {
 		return nil, ErrSegmentNotFound
@@ -124,28 +621,322 @@ func (r *InMemorySegmentRepository) Update(ctx context.Context, s *segment.Segme
 	updatedSegment := segment.UnmarshalSegmentFromDatabase(
 		s.ID(),
 		s.Name(),
+		s.Description(),
+		s.Labels(),
 		s.TTLSeconds(),
+		s.ActiveFrom(),
+		s.ActiveUntil(),
+		s.Expression(),
+		s.State(),
+		existing.MemberCount(),
 		existing.CreatedAt(),
-		time.Now(),
+		s.UpdatedAt(),
 		nil,
 	)
 
+	r.backupSegment(s.ID())
+	r.backupRevisions(s.ID())
 	r.segments[s.ID()] = updatedSegment
+	r.revisions[s.ID()] = append(r.revisions[s.ID()], withMemberCount(updatedSegment, 0))
 
-	return updatedSegment, nil
+	cp := *updatedSegment
+	return &cp, nil
 }
 
-// Delete soft-deletes a segment by ID.
-func (r *InMemorySegmentRepository) Delete(ctx context.Context, id int) error {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+func (r *InMemorySegmentRepository) delete(s *segment.Segment) error {
+	if !s.IsDeleted() {
+		return fmt.Errorf("segment '%d' is not marked as deleted", s.ID())
+	}
 
-	s, ok := r.segments[id]
-	if !ok || s.IsDeleted() {
+	existing, ok := r.segments[s.ID()]
+	if !ok || existing.IsDeleted() {
 		return ErrSegmentNotFound
 	}
 
-	s.Delete(time.Now())
+	deleted := *existing
+	if err := deleted.Delete(*s.DeletedAt()); err != nil {
+		return err
+	}
+	r.backupSegment(s.ID())
+	r.segments[s.ID()] = &deleted
+
+	return nil
+}
+
+func (r *InMemorySegmentRepository) listRevisions(segmentID int) ([]segment.Revision, error) {
+	if s, ok := r.segments[segmentID]; !ok || s.IsDeleted() {
+		return nil, ErrSegmentNotFound
+	}
+
+	revisions := make([]segment.Revision, 0, len(r.revisions[segmentID]))
+	for i, s := range r.revisions[segmentID] {
+		cp := *s
+		revisions = append(revisions, segment.Revision{Version: i + 1, Segment: &cp})
+	}
+	return revisions, nil
+}
+
+func (r *InMemorySegmentRepository) getRevision(segmentID, version int) (*segment.Revision, error) {
+	if s, ok := r.segments[segmentID]; !ok || s.IsDeleted() {
+		return nil, ErrSegmentNotFound
+	}
+
+	revisions := r.revisions[segmentID]
+	if version < 1 || version > len(revisions) {
+		return nil, segment.ErrRevisionNotFound
+	}
+	cp := *revisions[version-1]
+	return &segment.Revision{Version: version, Segment: &cp}, nil
+}
+
+// inMemoryUndo holds the values a transaction replaced, saved the first time
+// it replaces them, so that a failed transaction costs as much to roll back
+// as it changed.
+type inMemoryUndo struct {
+	segments map[int]undoValue[*segment.Segment]
+	members  map[memberKey]undoValue[segment.Member]
+	sizes    map[sizeKey]undoValue[int]
+	sketches map[int]undoValue[*segment.Sketch]
+	// Revisions are only appended, so the saved slices exclude the
+	// revisions added by the transaction.
+	revisions map[int]undoValue[[]*segment.Segment]
+}
+
+// undoValue is a saved map entry, ok reporting whether it existed.
+type undoValue[V any] struct {
+	v  V
+	ok bool
+}
+
+type memberKey struct {
+	segmentID int
+	memberID  string
+}
+
+type sizeKey struct {
+	segmentID int
+	day       time.Time
+}
+
+func newInMemoryUndo() *inMemoryUndo {
+	return &inMemoryUndo{
+		segments:  make(map[int]undoValue[*segment.Segment]),
+		members:   make(map[memberKey]undoValue[segment.Member]),
+		sizes:     make(map[sizeKey]undoValue[int]),
+		sketches:  make(map[int]undoValue[*segment.Sketch]),
+		revisions: make(map[int]undoValue[[]*segment.Segment]),
+	}
+}
+
+// save stores the entry of m under key in undo, unless it was saved already.
+func save[K comparable, V any](undo map[K]undoValue[V], key K, m map[K]V) {
+	if _, saved := undo[key]; saved {
+		return
+	}
+	v, ok := m[key]
+	undo[key] = undoValue[V]{v, ok}
+}
+
+// restore puts the entries saved in undo back into m.
+func restore[K comparable, V any](m map[K]V, undo map[K]undoValue[V]) {
+	for key, u := range undo {
+		if u.ok {
+			m[key] = u.v
+		} else {
+			delete(m, key)
+		}
+	}
+}
+
+func (r *InMemorySegmentRepository) backupSegment(id int) {
+	if r.undo != nil {
+		save(r.undo.segments, id, r.segments)
+	}
+}
+
+func (r *InMemorySegmentRepository) backupMember(segmentID int, memberID string) {
+	if r.undo == nil {
+		return
+	}
+	key := memberKey{segmentID, memberID}
+	if _, saved := r.undo.members[key]; !saved {
+		m, ok := r.members[segmentID][memberID]
+		r.undo.members[key] = undoValue[segment.Member]{m, ok}
+	}
+}
+
+func (r *InMemorySegmentRepository) backupSize(segmentID int, day time.Time) {
+	if r.undo == nil {
+		return
+	}
+	key := sizeKey{segmentID, day}
+	if _, saved := r.undo.sizes[key]; !saved {
+		count, ok := r.sizeHistory[segmentID][day]
+		r.undo.sizes[key] = undoValue[int]{count, ok}
+	}
+}
+
+func (r *InMemorySegmentRepository) backupSketch(segmentID int) {
+	if r.undo != nil {
+		save(r.undo.sketches, segmentID, r.sketches)
+	}
+}
+
+func (r *InMemorySegmentRepository) backupRevisions(segmentID int) {
+	if r.undo != nil {
+		save(r.undo.revisions, segmentID, r.revisions)
+	}
+}
+
+// rollback restores the values saved by the running transaction.
+func (r *InMemorySegmentRepository) rollback() {
+	restore(r.segments, r.undo.segments)
+	restore(r.sketches, r.undo.sketches)
+	restore(r.revisions, r.undo.revisions)
+	for key, u := range r.undo.members {
+		if u.ok {
+			r.members[key.segmentID][key.memberID] = u.v
+		} else {
+			delete(r.members[key.segmentID], key.memberID)
+		}
+	}
+	for key, u := range r.undo.sizes {
+		if u.ok {
+			r.sizeHistory[key.segmentID][key.day] = u.v
+		} else {
+			delete(r.sizeHistory[key.segmentID], key.day)
+		}
+	}
+}
 
+// hasState reports whether state is one of states. No states match all.
+func hasState(states []segment.State, state segment.State) bool {
+	if len(states) == 0 {
+		return true
+	}
+	for _, s := range states {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
+
+// inMemorySegmentTx is the view of an InMemorySegmentRepository handed to a
+// transaction. The repository lock is already held, so it must not be taken again.
+type inMemorySegmentTx struct {
+	repo *InMemorySegmentRepository
+}
+
+func (t inMemorySegmentTx) List(ctx context.Context, params segment.ListParams) (*segment.ListResult, error) {
+	return t.repo.list(params), nil
+}
+
+func (t inMemorySegmentTx) Get(ctx context.Context, id int) (*segment.Segment, error) {
+	return t.repo.get(id)
+}
+
+func (t inMemorySegmentTx) GetShared(ctx context.Context, id int) (*segment.Segment, error) {
+	return t.repo.get(id)
+}
+
+func (t inMemorySegmentTx) Create(ctx context.Context, s *segment.Segment) (*segment.Segment, error) {
+	if err := t.repo.record(journalOp{kind: opCreate, segment: s}); err != nil {
+		return nil, err
+	}
+	return t.repo.create(s)
+}
+
+func (t inMemorySegmentTx) Update(ctx context.Context, s *segment.Segment) (*segment.Segment, error) {
+	if err := t.repo.record(journalOp{kind: opUpdate, segment: s}); err != nil {
+		return nil, err
+	}
+	return t.repo.update(s)
+}
+
+func (t inMemorySegmentTx) Delete(ctx context.Context, s *segment.Segment) error {
+	if err := t.repo.record(journalOp{kind: opDelete, segment: s}); err != nil {
+		return err
+	}
+	return t.repo.delete(s)
+}
+
+func (t inMemorySegmentTx) ListRevisions(ctx context.Context, segmentID int) ([]segment.Revision, error) {
+	return t.repo.listRevisions(segmentID)
+}
+
+func (t inMemorySegmentTx) GetRevision(ctx context.Context, segmentID, version int) (*segment.Revision, error) {
+	return t.repo.getRevision(segmentID, version)
+}
+
+func (t inMemorySegmentTx) AddMembers(ctx context.Context, segmentID int, members []segment.Member) error {
+	if err := t.repo.record(journalOp{kind: opAddMembers, segmentID: segmentID, members: members}); err != nil {
+		return err
+	}
+	return t.repo.addMembers(segmentID, members)
+}
+
+func (t inMemorySegmentTx) RemoveMembers(ctx context.Context, segmentID int, memberIDs []string) error {
+	if err := t.repo.record(journalOp{kind: opRemoveMembers, segmentID: segmentID, memberIDs: memberIDs}); err != nil {
+		return err
+	}
+	return t.repo.removeMembers(segmentID, memberIDs)
+}
+
+func (t inMemorySegmentTx) StreamMembers(ctx context.Context, q segment.MemberQuery, fn func(memberID string) error) error {
+	return streamIDs(ctx, t.repo.selectMembers(q), fn)
+}
+
+func (t inMemorySegmentTx) StreamMemberships(ctx context.Context, segmentID int, fn func(m segment.Member) error) error {
+	return streamMemberList(ctx, t.repo.memberships(segmentID), fn)
+}
+
+func (t inMemorySegmentTx) ListMemberships(ctx context.Context, memberID string, segmentIDs []int, at time.Time) ([]int, error) {
+	return t.repo.listMemberships(memberID, segmentIDs, at), nil
+}
+
+func (t inMemorySegmentTx) PurgeExpiredMembers(ctx context.Context, at time.Time) (int, error) {
+	if err := t.repo.record(journalOp{kind: opPurgeExpired, at: at}); err != nil {
+		return 0, err
+	}
+	return t.repo.purgeExpiredMembers(at), nil
+}
+
+func (t inMemorySegmentTx) RecordSizeSnapshots(ctx context.Context, day time.Time) error {
+	if err := t.repo.record(journalOp{kind: opRecordSizes, at: day}); err != nil {
+		return err
+	}
+	t.repo.recordSizeSnapshots(day)
 	return nil
 }
+
+func (t inMemorySegmentTx) ListSizeHistory(ctx context.Context, segmentID int, from, to time.Time) ([]segment.SizeSnapshot, error) {
+	return t.repo.listSizeHistory(segmentID, from, to), nil
+}
+
+func (t inMemorySegmentTx) GetSketch(ctx context.Context, segmentID int) (*segment.Sketch, error) {
+	return t.repo.getSketch(segmentID)
+}
+
+func (t inMemorySegmentTx) SaveSketch(ctx context.Context, segmentID int, sketch *segment.Sketch) error {
+	if err := t.repo.record(journalOp{kind: opSaveSketch, segmentID: segmentID, sketch: sketch}); err != nil {
+		return err
+	}
+	return t.repo.saveSketch(segmentID, sketch)
+}
+
+func (t inMemorySegmentTx) ListUnsketchedSegments(ctx context.Context) ([]int, error) {
+	return t.repo.listUnsketchedSegments(), nil
+}
+
+func (t inMemorySegmentTx) ListReferencing(ctx context.Context, id int) ([]segment.Segment, error) {
+	return t.repo.listReferencing(id), nil
+}
+
+func (t inMemorySegmentTx) ListWindowBoundaries(ctx context.Context, after, until time.Time) ([]segment.Segment, error) {
+	return t.repo.listWindowBoundaries(after, until), nil
+}
+
+func (t inMemorySegmentTx) RunInTransaction(ctx context.Context, fn func(ctx context.Context, repo segment.Repository) error) error {
+	return fn(ctx, t)
+}
@@ -0,0 +1,67 @@
+package adapters_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rickKoch/nexus/internal/segments/adapters"
+	"github.com/rickKoch/nexus/internal/segments/domain/segment"
+	"github.com/rickKoch/nexus/internal/segments/domain/segment/segmenttest"
+)
+
+func TestInMemorySegmentRepository(t *testing.T) {
+	segmenttest.RunRepositoryTests(t, func(t *testing.T) segmenttest.Repository {
+		return adapters.NewInMemorySegmentRepository()
+	})
+
+	t.Run("rolls back purges and size snapshots", func(t *testing.T) {
+		ctx := context.Background()
+		now := time.Date(2026, 11, 1, 12, 30, 0, 0, time.UTC)
+		repo := adapters.NewInMemorySegmentRepository()
+
+		ttl := 60
+		f, _ := segment.NewFactory(segment.SegmentConfig{Name: "trial-users", TTLSeconds: &ttl})
+		id, _ := repo.NextID(ctx)
+		s, err := repo.Create(ctx, f.NewSegment(id, now))
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		m, _ := s.NewMember("user-1", now)
+		if err := repo.AddMembers(ctx, id, []segment.Member{m}); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if err := repo.RecordSizeSnapshots(ctx, now); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		errRollback := errors.New("rollback")
+		err = repo.RunInTransaction(ctx, func(ctx context.Context, tx segment.Repository) error {
+			if _, err := tx.PurgeExpiredMembers(ctx, now.Add(time.Hour)); err != nil {
+				return err
+			}
+			if err := tx.RecordSizeSnapshots(ctx, now); err != nil {
+				return err
+			}
+			if err := tx.RecordSizeSnapshots(ctx, now.Add(24*time.Hour)); err != nil {
+				return err
+			}
+			return errRollback
+		})
+		if !errors.Is(err, errRollback) {
+			t.Fatalf("expected %v, got %v", errRollback, err)
+		}
+
+		if got, _ := repo.Get(ctx, id); got.MemberCount() != 1 {
+			t.Errorf("expected the purge to be rolled back, got %d members", got.MemberCount())
+		}
+		history, err := repo.ListSizeHistory(ctx, id, now, now.Add(48*time.Hour))
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if len(history) != 1 || history[0].MemberCount != 1 {
+			t.Errorf("expected the size snapshots to be rolled back, got %+v", history)
+		}
+	})
+}
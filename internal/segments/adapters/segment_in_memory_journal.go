@@ -0,0 +1,714 @@
+package adapters
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rickKoch/nexus/internal/segments/domain/segment"
+)
+
+// FsyncPolicy is when the journal of a durable in-memory repository is
+// flushed to disk.
+type FsyncPolicy string
+
+const (
+	// FsyncAlways flushes every mutation before it is acknowledged.
+	FsyncAlways FsyncPolicy = "always"
+	// FsyncInterval flushes the journal every FsyncInterval, so a crash of
+	// the machine loses at most that much of the latest mutations.
+	FsyncInterval FsyncPolicy = "interval"
+	// FsyncNever leaves flushing to the operating system.
+	FsyncNever FsyncPolicy = "never"
+)
+
+const (
+	journalFileName  = "journal.log"
+	snapshotFileName = "snapshot.json"
+)
+
+// InMemoryJournalConfig configures the durability of an in-memory
+// repository.
+type InMemoryJournalConfig struct {
+	// Dir holds the journal and the snapshot. It is created if missing and
+	// must not be shared between processes.
+	Dir           string
+	Fsync         FsyncPolicy
+	FsyncInterval time.Duration
+	// SnapshotInterval is how often the journal is compacted into a
+	// snapshot. Zero only takes a snapshot on shutdown.
+	SnapshotInterval time.Duration
+}
+
+// Validate checks that the configuration is usable.
+func (c InMemoryJournalConfig) Validate() error {
+	if c.Dir == "" {
+		return errors.New("journal directory is required")
+	}
+	switch c.Fsync {
+	case FsyncAlways, FsyncNever:
+	case FsyncInterval:
+		if c.FsyncInterval <= 0 {
+			return errors.New("fsync interval must be positive")
+		}
+	default:
+		return fmt.Errorf("unknown fsync policy '%s'", c.Fsync)
+	}
+	if c.SnapshotInterval < 0 {
+		return errors.New("snapshot interval must not be negative")
+	}
+	return nil
+}
+
+// OpenInMemorySegmentRepository opens a durable in-memory repository. The
+// segments, their revisions, memberships, size history, sketches and the ID
+// sequence are
+// recovered from the latest snapshot and the journal entries written after
+// it. A record torn by a crash at the end of the journal is discarded.
+//
+// Every mutation is appended to the journal before it is applied, and a
+// transaction is appended as a single entry when it commits, so a crash
+// never recovers half of one.
+func OpenInMemorySegmentRepository(cfg InMemoryJournalConfig) (*InMemorySegmentRepository, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create journal directory: %w", err)
+	}
+
+	r := NewInMemorySegmentRepository()
+	j := &segmentJournal{cfg: cfg}
+
+	lastID, err := r.loadSnapshot(j)
+	if err != nil {
+		return nil, err
+	}
+
+	j.log, err = os.OpenFile(filepath.Join(cfg.Dir, journalFileName), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal: %w", err)
+	}
+	replayedID, err := r.replayJournal(j)
+	if err != nil {
+		_ = j.log.Close()
+		return nil, err
+	}
+	if _, err := j.log.Seek(j.size, io.SeekStart); err != nil {
+		_ = j.log.Close()
+		return nil, fmt.Errorf("failed to open journal: %w", err)
+	}
+
+	lastID = max(lastID, replayedID)
+	for id := range r.segments {
+		lastID = max(lastID, int64(id))
+	}
+	r.lastID.Store(lastID)
+	r.journal = j
+
+	return r, nil
+}
+
+// Sync flushes the journal to disk. It does nothing if the repository is
+// not durable.
+func (r *InMemorySegmentRepository) Sync() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.journal == nil {
+		return nil
+	}
+	return r.journal.sync()
+}
+
+// Snapshot compacts the journal: the whole repository is written to a new
+// snapshot, which replaces the previous one, and the journal is emptied.
+// Writers wait while the snapshot is taken. It does nothing if the
+// repository is not durable.
+func (r *InMemorySegmentRepository) Snapshot() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.journal == nil {
+		return nil
+	}
+	return r.journal.snapshot(r.snapshotState())
+}
+
+// Close flushes and closes the journal. The repository must not be modified
+// afterwards. It does nothing if the repository is not durable.
+func (r *InMemorySegmentRepository) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.journal == nil {
+		return nil
+	}
+	err := r.journal.sync()
+	if closeErr := r.journal.log.Close(); err == nil {
+		err = closeErr
+	}
+	r.journal.err = errors.New("journal is closed")
+	return err
+}
+
+// RunJournal flushes the journal every FsyncInterval under the interval
+// policy and takes a snapshot every SnapshotInterval until ctx is
+// cancelled, and then takes a final snapshot so that the next start does
+// not replay the journal. Errors are passed to onError.
+func (r *InMemorySegmentRepository) RunJournal(ctx context.Context, onError func(err error)) {
+	if r.journal == nil {
+		<-ctx.Done()
+		return
+	}
+
+	var syncC, snapshotC <-chan time.Time
+	if cfg := r.journal.cfg; cfg.Fsync == FsyncInterval {
+		ticker := time.NewTicker(cfg.FsyncInterval)
+		defer ticker.Stop()
+		syncC = ticker.C
+	}
+	if cfg := r.journal.cfg; cfg.SnapshotInterval > 0 {
+		ticker := time.NewTicker(cfg.SnapshotInterval)
+		defer ticker.Stop()
+		snapshotC = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := r.Snapshot(); err != nil {
+				onError(err)
+			}
+			return
+		case <-syncC:
+			if err := r.Sync(); err != nil {
+				onError(err)
+			}
+		case <-snapshotC:
+			if err := r.Snapshot(); err != nil {
+				onError(err)
+			}
+		}
+	}
+}
+
+// record journals a mutation before it is applied. Inside a transaction the
+// record is held back until the transaction commits. It does nothing if the
+// repository is not durable.
+func (r *InMemorySegmentRepository) record(op journalOp) error {
+	if r.journal == nil {
+		return nil
+	}
+
+	rec, err := op.encode()
+	if err != nil {
+		return err
+	}
+	if r.journal.pending != nil {
+		r.journal.pending = append(r.journal.pending, rec)
+		return nil
+	}
+	return r.journal.append([]journalRecord{rec}, r.lastID.Load())
+}
+
+// segmentJournal is the append-only log of the mutations of a durable
+// in-memory repository. It is only used under the repository's write lock.
+type segmentJournal struct {
+	cfg InMemoryJournalConfig
+	log *os.File
+	// size is the length of the journal up to the last complete entry.
+	size int64
+	// seq is the sequence number of the last entry, which continues across
+	// snapshots.
+	seq uint64
+	// dirty reports whether entries were written since the last flush.
+	dirty bool
+	// pending holds the records of the running transaction. It is nil
+	// outside of transactions.
+	pending []journalRecord
+	// err is set once the journal can no longer be trusted, after which
+	// every mutation fails.
+	err error
+}
+
+// journalEntry is a line of the journal. Its records are applied together.
+type journalEntry struct {
+	Seq uint64 `json:"seq"`
+	// LastID is the last ID handed out when the entry was written, so that
+	// IDs taken without creating a segment are not handed out again.
+	LastID  int64           `json:"last_id"`
+	Records []journalRecord `json:"records"`
+}
+
+// append writes an entry of records to the journal, and flushes it under
+// the always policy.
+func (j *segmentJournal) append(records []journalRecord, lastID int64) error {
+	if j.err != nil {
+		return fmt.Errorf("failed to append to journal: %w", j.err)
+	}
+
+	data, err := json.Marshal(journalEntry{Seq: j.seq + 1, LastID: lastID, Records: records})
+	if err != nil {
+		return fmt.Errorf("failed to encode journal entry: %w", err)
+	}
+	data = append(data, '\n')
+
+	if _, err := j.log.Write(data); err != nil {
+		// A partial entry would hide every later one from recovery.
+		if truncErr := j.truncate(j.size); truncErr != nil {
+			j.err = truncErr
+		}
+		return fmt.Errorf("failed to append to journal: %w", err)
+	}
+	j.seq++
+	j.size += int64(len(data))
+	j.dirty = true
+
+	if j.cfg.Fsync == FsyncAlways {
+		return j.sync()
+	}
+	return nil
+}
+
+// sync flushes the entries written since the last flush. A failed flush
+// leaves it unknown which entries reached the disk, so the journal is not
+// written to again.
+func (j *segmentJournal) sync() error {
+	if j.err != nil {
+		return fmt.Errorf("failed to sync journal: %w", j.err)
+	}
+	if !j.dirty {
+		return nil
+	}
+
+	if err := j.log.Sync(); err != nil {
+		j.err = err
+		return fmt.Errorf("failed to sync journal: %w", err)
+	}
+	j.dirty = false
+	return nil
+}
+
+// truncate cuts the journal to size and continues writing there.
+func (j *segmentJournal) truncate(size int64) error {
+	if err := j.log.Truncate(size); err != nil {
+		return err
+	}
+	_, err := j.log.Seek(size, io.SeekStart)
+	return err
+}
+
+// snapshot atomically replaces the snapshot with state and empties the
+// journal. Entries that survive a crash before the journal is emptied are
+// skipped by recovery, as their sequence numbers are covered by the
+// snapshot.
+func (j *segmentJournal) snapshot(state journalSnapshot) error {
+	if j.err != nil {
+		return fmt.Errorf("failed to take snapshot: %w", j.err)
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+	if err := writeFileAtomic(filepath.Join(j.cfg.Dir, snapshotFileName), data); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+
+	if err := j.truncate(0); err != nil {
+		j.err = err
+		return fmt.Errorf("failed to truncate journal: %w", err)
+	}
+	j.size = 0
+	j.dirty = true
+	return j.sync()
+}
+
+// writeFileAtomic writes data to a temporary file and renames it to path, so
+// that path holds either the old or the new content after a crash.
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+// replayJournal applies the entries written after the snapshot and returns
+// the last ID they recorded. Records fail on replay exactly as they did when
+// they were written, so their errors are ignored.
+func (r *InMemorySegmentRepository) replayJournal(j *segmentJournal) (int64, error) {
+	var lastID int64
+	reader := bufio.NewReader(j.log)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			// A line without its newline was torn by a crash while it was
+			// written, so it was never acknowledged.
+			if len(line) > 0 {
+				if err := j.truncate(j.size); err != nil {
+					return 0, fmt.Errorf("failed to discard torn journal entry: %w", err)
+				}
+			}
+			return lastID, nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to read journal: %w", err)
+		}
+
+		var entry journalEntry
+		if err := json.Unmarshal(bytes.TrimSpace(line), &entry); err != nil {
+			return 0, fmt.Errorf("journal is corrupt at offset %d: %w", j.size, err)
+		}
+		j.size += int64(len(line))
+		if entry.Seq <= j.seq {
+			continue
+		}
+
+		for _, rec := range entry.Records {
+			op, err := rec.decode()
+			if err != nil {
+				return 0, fmt.Errorf("journal is corrupt at entry %d: %w", entry.Seq, err)
+			}
+			_ = r.apply(op)
+		}
+		j.seq = entry.Seq
+		lastID = max(lastID, entry.LastID)
+	}
+}
+
+// Journaled mutations.
+const (
+	opCreate        = "create"
+	opUpdate        = "update"
+	opDelete        = "delete"
+	opAddMembers    = "add_members"
+	opRemoveMembers = "remove_members"
+	opPurgeExpired  = "purge_expired"
+	opRecordSizes   = "record_sizes"
+	opSaveSketch    = "save_sketch"
+)
+
+// journalOp is a mutation of the repository.
+type journalOp struct {
+	kind      string
+	segment   *segment.Segment
+	segmentID int
+	members   []segment.Member
+	memberIDs []string
+	at        time.Time
+	sketch    *segment.Sketch
+}
+
+// apply runs op against the repository.
+func (r *InMemorySegmentRepository) apply(op journalOp) error {
+	switch op.kind {
+	case opCreate:
+		_, err := r.create(op.segment)
+		return err
+	case opUpdate:
+		_, err := r.update(op.segment)
+		return err
+	case opDelete:
+		return r.delete(op.segment)
+	case opAddMembers:
+		return r.addMembers(op.segmentID, op.members)
+	case opRemoveMembers:
+		return r.removeMembers(op.segmentID, op.memberIDs)
+	case opPurgeExpired:
+		r.purgeExpiredMembers(op.at)
+		return nil
+	case opRecordSizes:
+		r.recordSizeSnapshots(op.at)
+		return nil
+	case opSaveSketch:
+		return r.saveSketch(op.segmentID, op.sketch)
+	default:
+		return fmt.Errorf("unknown journal operation '%s'", op.kind)
+	}
+}
+
+// journalRecord is the encoded form of a journalOp.
+type journalRecord struct {
+	Op        string          `json:"op"`
+	Segment   *journalSegment `json:"segment,omitempty"`
+	SegmentID int             `json:"segment_id,omitempty"`
+	Members   []journalMember `json:"members,omitempty"`
+	MemberIDs []string        `json:"member_ids,omitempty"`
+	At        time.Time       `json:"at,omitzero"`
+	Sketch    []byte          `json:"sketch,omitempty"`
+}
+
+func (op journalOp) encode() (journalRecord, error) {
+	rec := journalRecord{
+		Op:        op.kind,
+		SegmentID: op.segmentID,
+		MemberIDs: op.memberIDs,
+		At:        op.at,
+	}
+	if op.segment != nil {
+		s := newJournalSegment(op.segment)
+		rec.Segment = &s
+	}
+	if op.members != nil {
+		rec.Members = newJournalMembers(op.members)
+	}
+	if op.sketch != nil {
+		data, err := op.sketch.MarshalBinary()
+		if err != nil {
+			return journalRecord{}, fmt.Errorf("failed to encode sketch: %w", err)
+		}
+		rec.Sketch = data
+	}
+	return rec, nil
+}
+
+func (rec journalRecord) decode() (journalOp, error) {
+	op := journalOp{
+		kind:      rec.Op,
+		segmentID: rec.SegmentID,
+		memberIDs: rec.MemberIDs,
+		at:        rec.At,
+	}
+	if rec.Segment != nil {
+		s, err := rec.Segment.toSegment()
+		if err != nil {
+			return journalOp{}, err
+		}
+		op.segment = s
+	}
+	for _, m := range rec.Members {
+		op.members = append(op.members, m.toMember())
+	}
+	if rec.Sketch != nil {
+		op.sketch = &segment.Sketch{}
+		if err := op.sketch.UnmarshalBinary(rec.Sketch); err != nil {
+			return journalOp{}, fmt.Errorf("failed to decode sketch: %w", err)
+		}
+	}
+	return op, nil
+}
+
+// journalSegment is the encoded form of a segment. Composite expressions are
+// stored in their canonical textual form.
+type journalSegment struct {
+	ID          int               `json:"id"`
+	Name        string            `json:"name"`
+	Description string            `json:"description,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	TTLSeconds  *int              `json:"ttl_seconds,omitempty"`
+	ActiveFrom  *time.Time        `json:"active_from,omitempty"`
+	ActiveUntil *time.Time        `json:"active_until,omitempty"`
+	Expression  string            `json:"expression,omitempty"`
+	State       string            `json:"state"`
+	MemberCount int               `json:"member_count,omitempty"`
+	CreatedAt   time.Time         `json:"created_at"`
+	UpdatedAt   time.Time         `json:"updated_at"`
+	DeletedAt   *time.Time        `json:"deleted_at,omitempty"`
+}
+
+func newJournalSegment(s *segment.Segment) journalSegment {
+	js := journalSegment{
+		ID:          s.ID(),
+		Name:        s.Name(),
+		Description: s.Description(),
+		Labels:      s.Labels(),
+		TTLSeconds:  s.TTLSeconds(),
+		ActiveFrom:  s.ActiveFrom(),
+		ActiveUntil: s.ActiveUntil(),
+		State:       string(s.State()),
+		MemberCount: s.MemberCount(),
+		CreatedAt:   s.CreatedAt(),
+		UpdatedAt:   s.UpdatedAt(),
+		DeletedAt:   s.DeletedAt(),
+	}
+	if s.Expression() != nil {
+		js.Expression = s.Expression().String()
+	}
+	return js
+}
+
+func (js journalSegment) toSegment() (*segment.Segment, error) {
+	var expr *segment.Expression
+	if js.Expression != "" {
+		var err error
+		if expr, err = segment.ParseExpression(js.Expression); err != nil {
+			return nil, fmt.Errorf("failed to parse expression of segment '%d': %w", js.ID, err)
+		}
+	}
+
+	return segment.UnmarshalSegmentFromDatabase(
+		js.ID, js.Name, js.Description, segment.Labels(js.Labels), js.TTLSeconds,
+		js.ActiveFrom, js.ActiveUntil, expr, segment.State(js.State), js.MemberCount,
+		js.CreatedAt, js.UpdatedAt, js.DeletedAt,
+	), nil
+}
+
+// journalMember is the encoded form of a membership.
+type journalMember struct {
+	ID        string     `json:"id"`
+	AddedAt   time.Time  `json:"added_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+func newJournalMembers(members []segment.Member) []journalMember {
+	encoded := make([]journalMember, 0, len(members))
+	for _, m := range members {
+		encoded = append(encoded, journalMember{ID: m.ID, AddedAt: m.AddedAt, ExpiresAt: m.ExpiresAt})
+	}
+	return encoded
+}
+
+func (m journalMember) toMember() segment.Member {
+	return segment.Member{ID: m.ID, AddedAt: m.AddedAt, ExpiresAt: m.ExpiresAt}
+}
+
+// journalSnapshot is the content of the snapshot file.
+type journalSnapshot struct {
+	// Seq is the sequence number of the last journal entry the snapshot
+	// includes.
+	Seq         uint64                  `json:"seq"`
+	LastID      int64                   `json:"last_id"`
+	Segments    []journalSegment        `json:"segments"`
+	Members     map[int][]journalMember `json:"members"`
+	SizeHistory []journalSize           `json:"size_history"`
+	Sketches    map[int][]byte          `json:"sketches"`
+	// Revisions holds the revisions of each segment, oldest first.
+	// Snapshots taken before revisions were recorded have none.
+	Revisions map[int][]journalSegment `json:"revisions,omitempty"`
+}
+
+// journalSize is the encoded form of a size snapshot.
+type journalSize struct {
+	SegmentID   int       `json:"segment_id"`
+	Day         time.Time `json:"day"`
+	MemberCount int       `json:"member_count"`
+}
+
+// snapshotState captures the whole repository.
+func (r *InMemorySegmentRepository) snapshotState() journalSnapshot {
+	state := journalSnapshot{
+		Seq:         r.journal.seq,
+		LastID:      r.lastID.Load(),
+		Segments:    make([]journalSegment, 0, len(r.segments)),
+		SizeHistory: make([]journalSize, 0),
+		Members:     make(map[int][]journalMember, len(r.members)),
+		Sketches:    make(map[int][]byte, len(r.sketches)),
+		Revisions:   make(map[int][]journalSegment, len(r.revisions)),
+	}
+	for _, s := range r.segments {
+		state.Segments = append(state.Segments, newJournalSegment(s))
+	}
+	for id := range r.members {
+		if members := r.memberships(id); len(members) > 0 {
+			state.Members[id] = newJournalMembers(members)
+		}
+	}
+	for id, history := range r.sizeHistory {
+		for day, count := range history {
+			state.SizeHistory = append(state.SizeHistory, journalSize{SegmentID: id, Day: day, MemberCount: count})
+		}
+	}
+	for id, sketch := range r.sketches {
+		// Stored sketches always encode.
+		state.Sketches[id], _ = sketch.MarshalBinary()
+	}
+	for id, revisions := range r.revisions {
+		for _, s := range revisions {
+			state.Revisions[id] = append(state.Revisions[id], newJournalSegment(s))
+		}
+	}
+	return state
+}
+
+// loadSnapshot restores the repository from the snapshot, if there is one,
+// and returns the last ID it recorded.
+func (r *InMemorySegmentRepository) loadSnapshot(j *segmentJournal) (int64, error) {
+	data, err := os.ReadFile(filepath.Join(j.cfg.Dir, snapshotFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read snapshot: %w", err)
+	}
+
+	var state journalSnapshot
+	if err := json.Unmarshal(data, &state); err != nil {
+		return 0, fmt.Errorf("failed to decode snapshot: %w", err)
+	}
+
+	for _, js := range state.Segments {
+		s, err := js.toSegment()
+		if err != nil {
+			return 0, fmt.Errorf("failed to decode snapshot: %w", err)
+		}
+		r.segments[s.ID()] = s
+	}
+	for id, members := range state.Members {
+		r.members[id] = make(map[string]segment.Member, len(members))
+		for _, m := range members {
+			r.members[id][m.ID] = m.toMember()
+		}
+	}
+	for _, size := range state.SizeHistory {
+		if r.sizeHistory[size.SegmentID] == nil {
+			r.sizeHistory[size.SegmentID] = make(map[time.Time]int)
+		}
+		r.sizeHistory[size.SegmentID][segment.Day(size.Day)] = size.MemberCount
+	}
+	for id, data := range state.Sketches {
+		sketch := &segment.Sketch{}
+		if err := sketch.UnmarshalBinary(data); err != nil {
+			return 0, fmt.Errorf("failed to decode snapshot: %w", err)
+		}
+		r.sketches[id] = sketch
+	}
+	for id, revisions := range state.Revisions {
+		for _, js := range revisions {
+			s, err := js.toSegment()
+			if err != nil {
+				return 0, fmt.Errorf("failed to decode snapshot: %w", err)
+			}
+			r.revisions[id] = append(r.revisions[id], s)
+		}
+	}
+	// The revisions of segments snapshotted before revisions were recorded
+	// start from the segment as stored.
+	for id, s := range r.segments {
+		if len(r.revisions[id]) == 0 {
+			r.revisions[id] = []*segment.Segment{withMemberCount(s, 0)}
+		}
+	}
+
+	j.seq = state.Seq
+	return state.LastID, nil
+}
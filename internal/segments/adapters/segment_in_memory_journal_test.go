@@ -0,0 +1,328 @@
+package adapters_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rickKoch/nexus/internal/segments/adapters"
+	"github.com/rickKoch/nexus/internal/segments/domain/segment"
+	"github.com/rickKoch/nexus/internal/segments/domain/segment/segmenttest"
+)
+
+func TestDurableInMemorySegmentRepository(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 11, 1, 12, 30, 0, 0, time.UTC)
+
+	open := func(t *testing.T, dir string) *adapters.InMemorySegmentRepository {
+		t.Helper()
+
+		repo, err := adapters.OpenInMemorySegmentRepository(adapters.InMemoryJournalConfig{
+			Dir:   dir,
+			Fsync: adapters.FsyncAlways,
+		})
+		if err != nil {
+			t.Fatalf("failed to open repository: %v", err)
+		}
+		t.Cleanup(func() { _ = repo.Close() })
+		return repo
+	}
+	reopen := func(t *testing.T, repo *adapters.InMemorySegmentRepository, dir string) *adapters.InMemorySegmentRepository {
+		t.Helper()
+
+		if err := repo.Close(); err != nil {
+			t.Fatalf("failed to close repository: %v", err)
+		}
+		return open(t, dir)
+	}
+	create := func(t *testing.T, repo segment.Repository, ids segment.IDGenerator, sc segment.SegmentConfig) *segment.Segment {
+		t.Helper()
+
+		f, err := segment.NewFactory(sc)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		id, err := ids.NextID(ctx)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		s, err := repo.Create(ctx, f.NewSegment(id, now))
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		return s
+	}
+	addMembers := func(t *testing.T, repo segment.Repository, s *segment.Segment, ids ...string) {
+		t.Helper()
+
+		members := make([]segment.Member, 0, len(ids))
+		for _, id := range ids {
+			m, err := s.NewMember(id, now)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			members = append(members, m)
+		}
+		if err := repo.AddMembers(ctx, s.ID(), members); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	// populate stores segments, revisions, memberships, size history and a
+	// sketch.
+	populate := func(t *testing.T, repo *adapters.InMemorySegmentRepository) {
+		t.Helper()
+
+		ttl := 3600
+		premium := create(t, repo, repo, segment.SegmentConfig{Name: "premium-users", Labels: segment.Labels{"team": "growth"}})
+		trial := create(t, repo, repo, segment.SegmentConfig{Name: "trial-users", TTLSeconds: &ttl})
+		expr, _ := segment.ParseExpression(fmt.Sprintf("segment(%d) AND NOT segment(%d)", premium.ID(), trial.ID()))
+		create(t, repo, repo, segment.SegmentConfig{Name: "premium-only", Expression: expr})
+
+		if err := premium.Update(segment.SegmentConfig{Name: "paying-users", Labels: premium.Labels()}, now.Add(time.Minute)); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if _, err := repo.Update(ctx, premium); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		addMembers(t, repo, premium, "user-1", "user-2", "user-3")
+		addMembers(t, repo, trial, "user-2")
+		if err := repo.RemoveMembers(ctx, premium.ID(), []string{"user-3"}); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if err := repo.RecordSizeSnapshots(ctx, now); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		sketch := segment.NewSketch()
+		sketch.Add("user-1")
+		sketch.Add("user-2")
+		if err := repo.SaveSketch(ctx, premium.ID(), sketch); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		deleted := create(t, repo, repo, segment.SegmentConfig{Name: "retired"})
+		if err := deleted.Delete(now.Add(time.Minute)); err != nil {
+			t.Fatalf("failed to mark segment as deleted: %v", err)
+		}
+		if err := repo.Delete(ctx, deleted); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	// dump describes everything a reader can observe in repo.
+	dump := func(t *testing.T, repo *adapters.InMemorySegmentRepository) string {
+		t.Helper()
+
+		var b strings.Builder
+		result, err := repo.List(ctx, segment.ListParams{Page: 1, PageSize: 100})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		for _, s := range result.Segments {
+			fmt.Fprintf(&b, "%d %s %v %v %v %d %s\n", s.ID(), s.Name(), s.Labels(), s.Expression(), s.State(), s.MemberCount(), s.CreatedAt())
+			err := repo.StreamMemberships(ctx, s.ID(), func(m segment.Member) error {
+				fmt.Fprintf(&b, "  member %s %s %v\n", m.ID, m.AddedAt, m.ExpiresAt)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			history, err := repo.ListSizeHistory(ctx, s.ID(), now, now)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			fmt.Fprintf(&b, "  history %v\n", history)
+			revisions, err := repo.ListRevisions(ctx, s.ID())
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			for _, rev := range revisions {
+				fmt.Fprintf(&b, "  revision %d %s %s\n", rev.Version, rev.Segment.Name(), rev.Segment.UpdatedAt())
+			}
+			sketch, err := repo.GetSketch(ctx, s.ID())
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if sketch != nil {
+				fmt.Fprintf(&b, "  sketch %d\n", sketch.Estimate())
+			}
+		}
+		return b.String()
+	}
+
+	segmenttest.RunRepositoryTests(t, func(t *testing.T) segmenttest.Repository {
+		return open(t, t.TempDir())
+	})
+
+	t.Run("recovers from the journal", func(t *testing.T) {
+		dir := t.TempDir()
+		repo := open(t, dir)
+		populate(t, repo)
+		want := dump(t, repo)
+
+		if got := dump(t, reopen(t, repo, dir)); got != want {
+			t.Errorf("expected\n%s\ngot\n%s", want, got)
+		}
+	})
+
+	t.Run("recovers from a snapshot and the journal after it", func(t *testing.T) {
+		dir := t.TempDir()
+		repo := open(t, dir)
+		populate(t, repo)
+		if err := repo.Snapshot(); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		s := create(t, repo, repo, segment.SegmentConfig{Name: "after-snapshot"})
+		addMembers(t, repo, s, "user-9")
+		want := dump(t, repo)
+
+		if got := dump(t, reopen(t, repo, dir)); got != want {
+			t.Errorf("expected\n%s\ngot\n%s", want, got)
+		}
+	})
+
+	t.Run("skips journal entries included in the snapshot", func(t *testing.T) {
+		dir := t.TempDir()
+		repo := open(t, dir)
+		populate(t, repo)
+		journal, err := os.ReadFile(filepath.Join(dir, "journal.log"))
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if err := repo.Snapshot(); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		want := dump(t, repo)
+		if err := repo.Close(); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		// A crash after the snapshot was written leaves the journal behind.
+		if err := os.WriteFile(filepath.Join(dir, "journal.log"), journal, 0o644); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if got := dump(t, open(t, dir)); got != want {
+			t.Errorf("expected\n%s\ngot\n%s", want, got)
+		}
+	})
+
+	t.Run("does not hand out IDs again", func(t *testing.T) {
+		dir := t.TempDir()
+		repo := open(t, dir)
+		s := create(t, repo, repo, segment.SegmentConfig{Name: "premium-users"})
+		taken, err := repo.NextID(ctx)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		addMembers(t, repo, s, "user-1")
+
+		id, err := reopen(t, repo, dir).NextID(ctx)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if id <= taken {
+			t.Errorf("expected an ID after %d, got %d", taken, id)
+		}
+	})
+
+	t.Run("journals committed transactions only", func(t *testing.T) {
+		dir := t.TempDir()
+		repo := open(t, dir)
+		s := create(t, repo, repo, segment.SegmentConfig{Name: "premium-users"})
+
+		errRollback := errors.New("rollback")
+		err := repo.RunInTransaction(ctx, func(ctx context.Context, tx segment.Repository) error {
+			addMembers(t, tx, s, "user-1")
+			return errRollback
+		})
+		if !errors.Is(err, errRollback) {
+			t.Fatalf("expected the rollback error, got %v", err)
+		}
+		err = repo.RunInTransaction(ctx, func(ctx context.Context, tx segment.Repository) error {
+			addMembers(t, tx, s, "user-2")
+			create(t, tx, repo, segment.SegmentConfig{Name: "trial-users"})
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		want := dump(t, repo)
+
+		if got := dump(t, reopen(t, repo, dir)); got != want {
+			t.Errorf("expected\n%s\ngot\n%s", want, got)
+		}
+		if strings.Contains(want, "user-1") {
+			t.Errorf("expected the rolled back member to be missing, got\n%s", want)
+		}
+	})
+
+	t.Run("discards a torn final entry", func(t *testing.T) {
+		dir := t.TempDir()
+		repo := open(t, dir)
+		populate(t, repo)
+		want := dump(t, repo)
+		if err := repo.Close(); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		f, err := os.OpenFile(filepath.Join(dir, "journal.log"), os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if _, err := f.WriteString(`{"seq":99,"records":[{"op":"cre`); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		_ = f.Close()
+
+		repo = open(t, dir)
+		if got := dump(t, repo); got != want {
+			t.Errorf("expected\n%s\ngot\n%s", want, got)
+		}
+		// Entries written after recovery must not follow the torn one.
+		s := create(t, repo, repo, segment.SegmentConfig{Name: "after-recovery"})
+		if got := dump(t, reopen(t, repo, dir)); !strings.Contains(got, s.Name()) {
+			t.Errorf("expected %s to be recovered, got\n%s", s.Name(), got)
+		}
+	})
+
+	t.Run("rejects a corrupt journal", func(t *testing.T) {
+		dir := t.TempDir()
+		repo := open(t, dir)
+		populate(t, repo)
+		if err := repo.Close(); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		path := filepath.Join(dir, "journal.log")
+		journal, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if err := os.WriteFile(path, append([]byte("not json\n"), journal...), 0o644); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		_, err = adapters.OpenInMemorySegmentRepository(adapters.InMemoryJournalConfig{Dir: dir, Fsync: adapters.FsyncAlways})
+		if err == nil || !strings.Contains(err.Error(), "corrupt") {
+			t.Errorf("expected a corrupt journal error, got %v", err)
+		}
+	})
+
+	t.Run("rejects invalid configurations", func(t *testing.T) {
+		tests := map[string]adapters.InMemoryJournalConfig{
+			"missing directory":       {Fsync: adapters.FsyncAlways},
+			"unknown fsync policy":    {Dir: t.TempDir(), Fsync: "sometimes"},
+			"missing fsync interval":  {Dir: t.TempDir(), Fsync: adapters.FsyncInterval},
+			"negative snapshot every": {Dir: t.TempDir(), Fsync: adapters.FsyncNever, SnapshotInterval: -time.Second},
+		}
+		for name, cfg := range tests {
+			if err := cfg.Validate(); err == nil {
+				t.Errorf("%s: expected an error", name)
+			}
+		}
+	})
+}
@@ -3,21 +3,123 @@ package adapters
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	"github.com/rickKoch/nexus/internal/segments/domain/segment"
 )
 
 // segmentRow represents a database row for a segment.
 type segmentRow struct {
-	ID         int        `db:"id"`
-	Name       string     `db:"name"`
-	TTLSeconds *int       `db:"ttl_seconds"`
-	CreatedAt  time.Time  `db:"created_at"`
-	UpdatedAt  time.Time  `db:"updated_at"`
-	DeletedAt  *time.Time `db:"deleted_at"`
+	ID          int            `db:"id"`
+	Name        string         `db:"name"`
+	Description string         `db:"description"`
+	Labels      jsonLabels     `db:"labels"`
+	TTLSeconds  *int           `db:"ttl_seconds"`
+	ActiveFrom  *time.Time     `db:"active_from"`
+	ActiveUntil *time.Time     `db:"active_until"`
+	Expression  textExpression `db:"expression"`
+	// ReferencedIDs denormalizes the segment IDs in Expression so that
+	// referencing segments can be looked up through an index.
+	ReferencedIDs pq.Int64Array `db:"referenced_ids"`
+	State         string        `db:"state"`
+	MemberCount   int           `db:"member_count"`
+	CreatedAt     time.Time     `db:"created_at"`
+	UpdatedAt     time.Time     `db:"updated_at"`
+	DeletedAt     *time.Time    `db:"deleted_at"`
+}
+
+func (row segmentRow) toSegment() *segment.Segment {
+	return segment.UnmarshalSegmentFromDatabase(
+		row.ID, row.Name, row.Description, segment.Labels(row.Labels), row.TTLSeconds,
+		row.ActiveFrom, row.ActiveUntil, row.Expression.Expression, segment.State(row.State), row.MemberCount, row.CreatedAt, row.UpdatedAt, row.DeletedAt,
+	)
+}
+
+// jsonLabels stores segment labels in a JSONB column.
+type jsonLabels map[string]string
+
+// Value implements driver.Valuer.
+func (l jsonLabels) Value() (driver.Value, error) {
+	if l == nil {
+		return "{}", nil
+	}
+	data, err := json.Marshal(l)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Scan implements sql.Scanner.
+func (l *jsonLabels) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+		*l = nil
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("cannot scan %T into labels", src)
+	}
+	return json.Unmarshal(data, l)
+}
+
+// textExpression stores a composite segment expression in its canonical
+// textual form. Regular segments store NULL.
+type textExpression struct {
+	*segment.Expression
+}
+
+// Value implements driver.Valuer.
+func (e textExpression) Value() (driver.Value, error) {
+	if e.Expression == nil {
+		return nil, nil
+	}
+	return e.String(), nil
+}
+
+// Scan implements sql.Scanner.
+func (e *textExpression) Scan(src interface{}) error {
+	var text string
+	switch v := src.(type) {
+	case nil:
+		e.Expression = nil
+		return nil
+	case []byte:
+		text = string(v)
+	case string:
+		text = v
+	default:
+		return fmt.Errorf("cannot scan %T into expression", src)
+	}
+
+	expr, err := segment.ParseExpression(text)
+	if err != nil {
+		return err
+	}
+	e.Expression = expr
+	return nil
+}
+
+// referencedIDs returns the IDs referenced by s's expression.
+func referencedIDs(s *segment.Segment) pq.Int64Array {
+	ids := pq.Int64Array{}
+	if s.Expression() != nil {
+		for _, id := range s.Expression().SegmentIDs() {
+			ids = append(ids, int64(id))
+		}
+	}
+	return ids
 }
 
 // segmentRowWithCount includes total count for paginated queries.
@@ -28,44 +130,86 @@ type segmentRowWithCount struct {
 
 // PostgreSQLSegmentRepository is a PostgreSQL implementation of segment.Repository.
 type PostgreSQLSegmentRepository struct {
-	db *sqlx.DB
+	db *PostgreSQLDB
+	// q runs the queries: db itself, or the transaction the repository is bound to.
+	q  sqlx.ExtContext
+	tx *sqlx.Tx
 }
 
 // NewPostgreSQLSegmentRepository creates a new PostgreSQL segment repository.
-func NewPostgreSQLSegmentRepository(db *sqlx.DB) *PostgreSQLSegmentRepository {
+// List and Get are served by the read replicas of db, if it has any.
+func NewPostgreSQLSegmentRepository(db *PostgreSQLDB) *PostgreSQLSegmentRepository {
 	return &PostgreSQLSegmentRepository{
 		db: db,
+		q:  db.DB,
+	}
+}
+
+// read runs fn on the transaction the repository is bound to, or else on a
+// read replica.
+func (r *PostgreSQLSegmentRepository) read(ctx context.Context, fn func(q sqlx.ExtContext) error) error {
+	if r.tx != nil {
+		return fn(r.q)
 	}
+	return r.db.read(ctx, fn)
+}
+
+// NextID reserves the next value of the segments ID sequence.
+func (r *PostgreSQLSegmentRepository) NextID(ctx context.Context) (int, error) {
+	var id int
+	if err := sqlx.GetContext(ctx, r.q, &id, `SELECT nextval(pg_get_serial_sequence('segments', 'id'))`); err != nil {
+		return 0, err
+	}
+	return id, nil
 }
 
 // List returns paginated non-deleted segments.
 func (r *PostgreSQLSegmentRepository) List(ctx context.Context, params segment.ListParams) (*segment.ListResult, error) {
+	filter := `
+		FROM segments
+		WHERE deleted_at IS NULL AND labels @> $1
+		  AND (cardinality($2::text[]) = 0 OR state = ANY($2))
+		  AND ($3::boolean IS NULL OR (state = 'active'
+		       AND (active_from IS NULL OR active_from <= $4)
+		       AND (active_until IS NULL OR active_until > $4)) = $3)
+	`
 	query := `
-		SELECT id, name, ttl_seconds, created_at, updated_at, deleted_at,
+		SELECT id, name, description, labels, ttl_seconds, active_from, active_until, expression, state, member_count, created_at, updated_at, deleted_at,
 		       COUNT(*) OVER() AS total_count
-		FROM segments
-		WHERE deleted_at IS NULL
+	` + filter + `
 		ORDER BY id
-		LIMIT $1 OFFSET $2
+		LIMIT $5 OFFSET $6
 	`
 
 	offset := (params.Page - 1) * params.PageSize
-	var rows []segmentRowWithCount
-	if err := r.db.SelectContext(ctx, &rows, query, params.PageSize, offset); err != nil {
-		return nil, err
+	selector := jsonLabels(params.Labels)
+	states := make([]string, 0, len(params.States))
+	for _, state := range params.States {
+		states = append(states, string(state))
 	}
-
+	var rows []segmentRowWithCount
 	var totalCount int
-	if len(rows) > 0 {
-		totalCount = rows[0].TotalCount
+	err := r.read(ctx, func(q sqlx.ExtContext) error {
+		rows = nil
+		if err := sqlx.SelectContext(ctx, q, &rows, query, selector, pq.Array(states), params.Live, params.At, params.PageSize, offset); err != nil {
+			return err
+		}
+
+		if len(rows) > 0 {
+			totalCount = rows[0].TotalCount
+		} else if offset > 0 {
+			// Past the last page, no row carries the total.
+			return sqlx.GetContext(ctx, q, &totalCount, `SELECT count(*) `+filter, selector, pq.Array(states), params.Live, params.At)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	segments := make([]segment.Segment, 0, len(rows))
 	for _, row := range rows {
-		s := segment.UnmarshalSegmentFromDatabase(
-			row.ID, row.Name, row.TTLSeconds, row.CreatedAt, row.UpdatedAt, row.DeletedAt,
-		)
-		segments = append(segments, *s)
+		segments = append(segments, *row.segmentRow.toSegment())
 	}
 
 	return &segment.ListResult{
@@ -76,78 +220,132 @@ func (r *PostgreSQLSegmentRepository) List(ctx context.Context, params segment.L
 	}, nil
 }
 
-// Get returns a segment by ID.
+// Get returns a segment by ID. Within a transaction the row is locked so
+// read-modify-write callers cannot lose updates.
 func (r *PostgreSQLSegmentRepository) Get(ctx context.Context, id int) (*segment.Segment, error) {
+	return r.get(ctx, id, `FOR UPDATE`)
+}
+
+// GetShared returns a segment by ID. Within a transaction the row is locked
+// against changes while other transactions can still share the lock.
+func (r *PostgreSQLSegmentRepository) GetShared(ctx context.Context, id int) (*segment.Segment, error) {
+	return r.get(ctx, id, `FOR SHARE`)
+}
+
+func (r *PostgreSQLSegmentRepository) get(ctx context.Context, id int, lock string) (*segment.Segment, error) {
 	query := `
-		SELECT id, name, ttl_seconds, created_at, updated_at, deleted_at
+		SELECT id, name, description, labels, ttl_seconds, active_from, active_until, expression, state, member_count, created_at, updated_at, deleted_at
 		FROM segments
 		WHERE id = $1 AND deleted_at IS NULL
 	`
+	if r.tx != nil {
+		query += lock
+	}
 
 	var row segmentRow
-	if err := r.db.GetContext(ctx, &row, query, id); err != nil {
+	err := r.read(ctx, func(q sqlx.ExtContext) error {
+		return sqlx.GetContext(ctx, q, &row, query, id)
+	})
+	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrSegmentNotFound
 		}
 		return nil, err
 	}
 
-	return segment.UnmarshalSegmentFromDatabase(
-		row.ID, row.Name, row.TTLSeconds, row.CreatedAt, row.UpdatedAt, row.DeletedAt,
-	), nil
+	return row.toSegment(), nil
 }
 
-// Create stores a new segment and returns it with an assigned ID.
+// Create stores a new segment and its first revision.
 func (r *PostgreSQLSegmentRepository) Create(ctx context.Context, s *segment.Segment) (*segment.Segment, error) {
+	markWritten(ctx)
+
 	query := `
-		INSERT INTO segments (name, ttl_seconds, created_at, updated_at)
-		VALUES (:name, :ttl_seconds, :created_at, :updated_at)
-		RETURNING id, name, ttl_seconds, created_at, updated_at, deleted_at
+		WITH created AS (
+			INSERT INTO segments (id, name, description, labels, ttl_seconds, active_from, active_until, expression, referenced_ids, state, created_at, updated_at)
+			VALUES (:id, :name, :description, :labels, :ttl_seconds, :active_from, :active_until, :expression, :referenced_ids, :state, :created_at, :updated_at)
+			RETURNING id, name, description, labels, ttl_seconds, active_from, active_until, expression, state, member_count, created_at, updated_at, deleted_at, version
+		), revision AS (
+			` + insertRevisionQuery + ` FROM created
+		)
+		SELECT id, name, description, labels, ttl_seconds, active_from, active_until, expression, state, member_count, created_at, updated_at, deleted_at
+		FROM created
 	`
 
-	now := time.Now()
 	params := segmentRow{
-		Name:       s.Name(),
-		TTLSeconds: s.TTLSeconds(),
-		CreatedAt:  now,
-		UpdatedAt:  now,
+		ID:            s.ID(),
+		Name:          s.Name(),
+		Description:   s.Description(),
+		Labels:        jsonLabels(s.Labels()),
+		TTLSeconds:    s.TTLSeconds(),
+		ActiveFrom:    s.ActiveFrom(),
+		ActiveUntil:   s.ActiveUntil(),
+		Expression:    textExpression{s.Expression()},
+		ReferencedIDs: referencedIDs(s),
+		State:         string(s.State()),
+		CreatedAt:     s.CreatedAt(),
+		UpdatedAt:     s.UpdatedAt(),
 	}
 
-	rows, err := r.db.NamedQueryContext(ctx, query, params)
+	rows, err := sqlx.NamedQueryContext(ctx, r.q, query, params)
 	if err != nil {
 		return nil, err
 	}
 	defer func() { _ = rows.Close() }()
 
-	var row segmentRow
-	if rows.Next() {
-		if err := rows.StructScan(&row); err != nil {
+	// A failed insert, such as of a duplicate ID, surfaces as no row.
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
 			return nil, err
 		}
+		return nil, fmt.Errorf("segment '%d' was not created", s.ID())
 	}
 
-	return segment.UnmarshalSegmentFromDatabase(
-		row.ID, row.Name, row.TTLSeconds, row.CreatedAt, row.UpdatedAt, row.DeletedAt,
-	), nil
+	var row segmentRow
+	if err := rows.StructScan(&row); err != nil {
+		return nil, err
+	}
+
+	return row.toSegment(), nil
 }
 
-// Update updates an existing segment.
+// Update updates an existing segment and saves it as its next revision. The
+// version is incremented under the row lock, so concurrent updates number
+// their revisions in the order they apply.
 func (r *PostgreSQLSegmentRepository) Update(ctx context.Context, s *segment.Segment) (*segment.Segment, error) {
+	markWritten(ctx)
+
 	query := `
-		UPDATE segments
-		SET name = :name, ttl_seconds = :ttl_seconds, updated_at = :updated_at
-		WHERE id = :id AND deleted_at IS NULL
-		RETURNING id, name, ttl_seconds, created_at, updated_at, deleted_at
+		WITH updated AS (
+			UPDATE segments
+			SET name = :name, description = :description, labels = :labels,
+			    ttl_seconds = :ttl_seconds, active_from = :active_from, active_until = :active_until,
+			    expression = :expression, referenced_ids = :referenced_ids, state = :state, updated_at = :updated_at,
+			    version = version + 1
+			WHERE id = :id AND deleted_at IS NULL
+			RETURNING id, name, description, labels, ttl_seconds, active_from, active_until, expression, state, member_count, created_at, updated_at, deleted_at, version
+		), revision AS (
+			` + insertRevisionQuery + ` FROM updated
+		)
+		SELECT id, name, description, labels, ttl_seconds, active_from, active_until, expression, state, member_count, created_at, updated_at, deleted_at
+		FROM updated
 	`
 
 	params := segmentRow{
-		ID:         s.ID(),
-		Name:       s.Name(),
-		TTLSeconds: s.TTLSeconds(),
-		UpdatedAt:  time.Now(),
+		ID:            s.ID(),
+		Name:          s.Name(),
+		Description:   s.Description(),
+		Labels:        jsonLabels(s.Labels()),
+		TTLSeconds:    s.TTLSeconds(),
+		ActiveFrom:    s.ActiveFrom(),
+		ActiveUntil:   s.ActiveUntil(),
+		Expression:    textExpression{s.Expression()},
+		ReferencedIDs: referencedIDs(s),
+		State:         string(s.State()),
+		UpdatedAt:     s.UpdatedAt(),
 	}
 
-	rows, err := r.db.NamedQueryContext(ctx, query, params)
+	rows, err := sqlx.NamedQueryContext(ctx, r.q, query, params)
 	if err != nil {
 		return nil, err
 	}
@@ -155,6 +353,9 @@ func (r *PostgreSQLSegmentRepository) Update(ctx context.Context, s *segment.Seg
 
 	var row segmentRow
 	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
 		return nil, ErrSegmentNotFound
 	}
 
@@ -162,27 +363,101 @@ func (r *PostgreSQLSegmentRepository) Update(ctx context.Context, s *segment.Seg
 		return nil, err
 	}
 
-	return segment.UnmarshalSegmentFromDatabase(
-		row.ID, row.Name, row.TTLSeconds, row.CreatedAt, row.UpdatedAt, row.DeletedAt,
-	), nil
+	return row.toSegment(), nil
+}
+
+// insertRevisionQuery saves the segments selected by the FROM clause it is
+// completed with as their revisions.
+const insertRevisionQuery = `
+	INSERT INTO segment_revisions (segment_id, version, name, description, labels, ttl_seconds, active_from, active_until, expression, state, saved_at)
+	SELECT id, version, name, description, labels, ttl_seconds, active_from, active_until, expression, state, updated_at`
+
+// revisionColumns are the columns revisions are read from, joined with the
+// segments they belong to.
+const revisionColumns = `r.segment_id AS id, r.version, r.name, r.description, r.labels, r.ttl_seconds, r.active_from, r.active_until, r.expression, r.state, s.created_at, r.saved_at AS updated_at`
+
+// revisionRow represents a database row for a revision.
+type revisionRow struct {
+	Version int `db:"version"`
+	segmentRow
+}
+
+// ListRevisions returns the revisions of a segment, oldest first.
+func (r *PostgreSQLSegmentRepository) ListRevisions(ctx context.Context, segmentID int) ([]segment.Revision, error) {
+	query := `
+		SELECT ` + revisionColumns + `
+		FROM segment_revisions r
+		JOIN segments s ON s.id = r.segment_id
+		WHERE r.segment_id = $1 AND s.deleted_at IS NULL
+		ORDER BY r.version
+	`
+
+	var rows []revisionRow
+	err := r.read(ctx, func(q sqlx.ExtContext) error {
+		rows = nil
+		return sqlx.SelectContext(ctx, q, &rows, query, segmentID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Every stored segment has a revision.
+	if len(rows) == 0 {
+		return nil, ErrSegmentNotFound
+	}
+
+	revisions := make([]segment.Revision, 0, len(rows))
+	for _, row := range rows {
+		revisions = append(revisions, segment.Revision{Version: row.Version, Segment: row.toSegment()})
+	}
+	return revisions, nil
+}
+
+// GetRevision returns a revision of a segment.
+func (r *PostgreSQLSegmentRepository) GetRevision(ctx context.Context, segmentID, version int) (*segment.Revision, error) {
+	query := `
+		SELECT ` + revisionColumns + `
+		FROM segment_revisions r
+		JOIN segments s ON s.id = r.segment_id
+		WHERE r.segment_id = $1 AND r.version = $2 AND s.deleted_at IS NULL
+	`
+
+	var row revisionRow
+	err := r.read(ctx, func(q sqlx.ExtContext) error {
+		return sqlx.GetContext(ctx, q, &row, query, segmentID, version)
+	})
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		if _, err := r.Get(ctx, segmentID); err != nil {
+			return nil, err
+		}
+		return nil, segment.ErrRevisionNotFound
+	}
+	return &segment.Revision{Version: row.Version, Segment: row.toSegment()}, nil
 }
 
-// Delete soft-deletes a segment by ID.
-func (r *PostgreSQLSegmentRepository) Delete(ctx context.Context, id int) error {
+// Delete stores the deletion of a segment.
+func (r *PostgreSQLSegmentRepository) Delete(ctx context.Context, s *segment.Segment) error {
+	markWritten(ctx)
+
+	if !s.IsDeleted() {
+		return fmt.Errorf("segment '%d' is not marked as deleted", s.ID())
+	}
+
 	query := `
 		UPDATE segments
 		SET deleted_at = :deleted_at, updated_at = :updated_at
 		WHERE id = :id AND deleted_at IS NULL
 	`
 
-	now := time.Now()
 	params := map[string]interface{}{
-		"id":         id,
-		"deleted_at": now,
-		"updated_at": now,
+		"id":         s.ID(),
+		"deleted_at": s.DeletedAt(),
+		"updated_at": s.UpdatedAt(),
 	}
 
-	result, err := r.db.NamedExecContext(ctx, query, params)
+	result, err := sqlx.NamedExecContext(ctx, r.q, query, params)
 	if err != nil {
 		return err
 	}
@@ -198,3 +473,571 @@ func (r *PostgreSQLSegmentRepository) Delete(ctx context.Context, id int) error
 
 	return nil
 }
+
+// AddMembers upserts memberships of a segment and counts the new ones in a
+// single statement. Rows inserted rather than updated by the upsert have no
+// xmax.
+func (r *PostgreSQLSegmentRepository) AddMembers(ctx context.Context, segmentID int, members []segment.Member) error {
+	markWritten(ctx)
+
+	query := `
+		WITH upserted AS (
+			INSERT INTO segment_members (segment_id, member_id, added_at, expires_at)
+			SELECT $1, m.member_id, m.added_at, m.expires_at
+			FROM unnest($2::text[], $3::timestamp[], $4::timestamp[]) AS m (member_id, added_at, expires_at)
+			ON CONFLICT (segment_id, member_id)
+			DO UPDATE SET added_at = EXCLUDED.added_at, expires_at = EXCLUDED.expires_at
+			RETURNING xmax = 0 AS inserted
+		)
+		UPDATE segments
+		SET member_count = member_count + (SELECT count(*) FROM upserted WHERE inserted)
+		WHERE id = $1
+	`
+
+	ids := make([]string, 0, len(members))
+	addedAt := make([]time.Time, 0, len(members))
+	expiresAt := make([]*time.Time, 0, len(members))
+	for _, m := range members {
+		ids = append(ids, m.ID)
+		addedAt = append(addedAt, m.AddedAt)
+		expiresAt = append(expiresAt, m.ExpiresAt)
+	}
+
+	_, err := r.q.ExecContext(ctx, query, segmentID, pq.Array(ids), pq.Array(addedAt), pq.Array(expiresAt))
+	return err
+}
+
+// RemoveMembers deletes memberships of a segment, uncounts them and
+// discards the segment's sketch if any was removed.
+func (r *PostgreSQLSegmentRepository) RemoveMembers(ctx context.Context, segmentID int, memberIDs []string) error {
+	markWritten(ctx)
+
+	query := `
+		WITH removed AS (
+			DELETE FROM segment_members WHERE segment_id = $1 AND member_id = ANY($2)
+			RETURNING 1
+		)
+		UPDATE segments
+		SET member_count = member_count - (SELECT count(*) FROM removed),
+		    sketch = CASE WHEN EXISTS (SELECT 1 FROM removed) THEN NULL ELSE sketch END
+		WHERE id = $1
+	`
+
+	_, err := r.q.ExecContext(ctx, query, segmentID, pq.Array(memberIDs))
+	return err
+}
+
+// PurgeExpiredMembers deletes expired memberships, uncounts them per segment
+// and discards the sketches of the affected segments. It is the only
+// membership query not filtering on the segment, so it visits every
+// partition, through its expires_at index.
+func (r *PostgreSQLSegmentRepository) PurgeExpiredMembers(ctx context.Context, at time.Time) (int, error) {
+	markWritten(ctx)
+
+	query := `
+		WITH purged AS (
+			DELETE FROM segment_members WHERE expires_at <= $1
+			RETURNING segment_id
+		), counts AS (
+			SELECT segment_id, count(*) AS n FROM purged GROUP BY segment_id
+		), updated AS (
+			UPDATE segments SET member_count = member_count - counts.n, sketch = NULL
+			FROM counts WHERE segments.id = counts.segment_id
+		)
+		SELECT coalesce(sum(n), 0)::int FROM counts
+	`
+
+	var purged int
+	if err := sqlx.GetContext(ctx, r.q, &purged, query, at); err != nil {
+		return 0, err
+	}
+	return purged, nil
+}
+
+// RecordSizeSnapshots copies the member counts of all regular segments into
+// the size history.
+func (r *PostgreSQLSegmentRepository) RecordSizeSnapshots(ctx context.Context, day time.Time) error {
+	markWritten(ctx)
+
+	query := `
+		INSERT INTO segment_size_history (segment_id, day, member_count)
+		SELECT id, $1::date, member_count
+		FROM segments
+		WHERE deleted_at IS NULL AND expression IS NULL
+		ON CONFLICT (segment_id, day) DO UPDATE SET member_count = EXCLUDED.member_count
+	`
+
+	_, err := r.q.ExecContext(ctx, query, segment.Day(day))
+	return err
+}
+
+// ListSizeHistory returns the size snapshots of a segment between from and
+// to inclusive. The day range limits the query to the monthly partitions it
+// spans.
+func (r *PostgreSQLSegmentRepository) ListSizeHistory(ctx context.Context, segmentID int, from, to time.Time) ([]segment.SizeSnapshot, error) {
+	query := `
+		SELECT segment_id, day, member_count
+		FROM segment_size_history
+		WHERE segment_id = $1 AND day BETWEEN $2::date AND $3::date
+		ORDER BY day
+	`
+
+	var rows []struct {
+		SegmentID   int       `db:"segment_id"`
+		Day         time.Time `db:"day"`
+		MemberCount int       `db:"member_count"`
+	}
+	if err := sqlx.SelectContext(ctx, r.q, &rows, query, segmentID, segment.Day(from), segment.Day(to)); err != nil {
+		return nil, err
+	}
+
+	snapshots := make([]segment.SizeSnapshot, 0, len(rows))
+	for _, row := range rows {
+		snapshots = append(snapshots, segment.SizeSnapshot{
+			SegmentID:   row.SegmentID,
+			Day:         segment.Day(row.Day),
+			MemberCount: row.MemberCount,
+		})
+	}
+	return snapshots, nil
+}
+
+// GetSketch reads the sketch of a segment's members, locking the segment row
+// so that concurrent membership writes wait for the sketch to be saved.
+func (r *PostgreSQLSegmentRepository) GetSketch(ctx context.Context, segmentID int) (*segment.Sketch, error) {
+	query := `SELECT sketch FROM segments WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`
+
+	var data []byte
+	if err := sqlx.GetContext(ctx, r.q, &data, query, segmentID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrSegmentNotFound
+		}
+		return nil, err
+	}
+	if data == nil {
+		return nil, nil
+	}
+
+	sketch := &segment.Sketch{}
+	if err := sketch.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	return sketch, nil
+}
+
+// SaveSketch stores the sketch of a segment's members.
+func (r *PostgreSQLSegmentRepository) SaveSketch(ctx context.Context, segmentID int, sketch *segment.Sketch) error {
+	markWritten(ctx)
+
+	data, err := sketch.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	result, err := r.q.ExecContext(ctx, `UPDATE segments SET sketch = $2 WHERE id = $1 AND deleted_at IS NULL`, segmentID, data)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrSegmentNotFound
+	}
+
+	return nil
+}
+
+// ListUnsketchedSegments returns the regular segments without a sketch.
+func (r *PostgreSQLSegmentRepository) ListUnsketchedSegments(ctx context.Context) ([]int, error) {
+	query := `
+		SELECT id FROM segments
+		WHERE deleted_at IS NULL AND expression IS NULL AND sketch IS NULL
+		ORDER BY id
+	`
+
+	var ids []int
+	if err := sqlx.SelectContext(ctx, r.q, &ids, query); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// memberFetchSize is the number of rows fetched from the export cursor at a time.
+const memberFetchSize = 1000
+
+// StreamMembers reads the selected subjects through a server-side cursor,
+// so memory use does not grow with the size of the segment. Outside a
+// transaction the cursor lives in a read-only transaction of its own.
+func (r *PostgreSQLSegmentRepository) StreamMembers(ctx context.Context, q segment.MemberQuery, fn func(memberID string) error) error {
+	if r.tx != nil {
+		return streamMembers(ctx, r.tx, q, fn)
+	}
+
+	tx, err := r.db.BeginTxx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	// The transaction only reads, so it is always rolled back.
+	defer func() { _ = tx.Rollback() }()
+
+	return streamMembers(ctx, tx, q, fn)
+}
+
+func streamMembers(ctx context.Context, tx *sqlx.Tx, q segment.MemberQuery, fn func(memberID string) error) (err error) {
+	query, args := memberSelectQuery(q)
+	if _, err := tx.ExecContext(ctx, "DECLARE member_export NO SCROLL CURSOR FOR "+query, args...); err != nil {
+		return err
+	}
+	defer closeCursor(ctx, tx, "member_export", &err)
+
+	fetch := fmt.Sprintf("FETCH FORWARD %d FROM member_export", memberFetchSize)
+	for {
+		var ids []string
+		if err := sqlx.SelectContext(ctx, tx, &ids, fetch); err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if err := fn(id); err != nil {
+				return err
+			}
+		}
+		if len(ids) < memberFetchSize {
+			return nil
+		}
+	}
+}
+
+// closeCursor closes the named cursor, so that the transaction can declare
+// it again, even if streaming failed. The error of closing it is only
+// reported into err if streaming succeeded.
+func closeCursor(ctx context.Context, tx *sqlx.Tx, name string, err *error) {
+	_, closeErr := tx.ExecContext(context.WithoutCancel(ctx), "CLOSE "+name)
+	if *err == nil {
+		*err = closeErr
+	}
+}
+
+// StreamMemberships reads the memberships of a segment through a server-side
+// cursor, like StreamMembers.
+func (r *PostgreSQLSegmentRepository) StreamMemberships(ctx context.Context, segmentID int, fn func(m segment.Member) error) error {
+	if r.tx != nil {
+		return streamMemberships(ctx, r.tx, segmentID, fn)
+	}
+
+	tx, err := r.db.BeginTxx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	// The transaction only reads, so it is always rolled back.
+	defer func() { _ = tx.Rollback() }()
+
+	return streamMemberships(ctx, tx, segmentID, fn)
+}
+
+type membershipRow struct {
+	MemberID  string     `db:"member_id"`
+	AddedAt   time.Time  `db:"added_at"`
+	ExpiresAt *time.Time `db:"expires_at"`
+}
+
+func streamMemberships(ctx context.Context, tx *sqlx.Tx, segmentID int, fn func(m segment.Member) error) (err error) {
+	query := `
+		DECLARE membership_export NO SCROLL CURSOR FOR
+		SELECT member_id, added_at, expires_at FROM segment_members
+		WHERE segment_id = $1
+		ORDER BY member_id
+	`
+	if _, err := tx.ExecContext(ctx, query, segmentID); err != nil {
+		return err
+	}
+	defer closeCursor(ctx, tx, "membership_export", &err)
+
+	fetch := fmt.Sprintf("FETCH FORWARD %d FROM membership_export", memberFetchSize)
+	for {
+		var rows []membershipRow
+		if err := sqlx.SelectContext(ctx, tx, &rows, fetch); err != nil {
+			return err
+		}
+		for _, row := range rows {
+			m := segment.Member{ID: row.MemberID, AddedAt: row.AddedAt, ExpiresAt: row.ExpiresAt}
+			if err := fn(m); err != nil {
+				return err
+			}
+		}
+		if len(rows) < memberFetchSize {
+			return nil
+		}
+	}
+}
+
+// ListMemberships looks the subject up in the member index of each segment,
+// in the partitions of the given segments only.
+func (r *PostgreSQLSegmentRepository) ListMemberships(ctx context.Context, memberID string, segmentIDs []int, at time.Time) ([]int, error) {
+	query := `
+		SELECT segment_id FROM segment_members
+		WHERE member_id = $1 AND segment_id = ANY($2) AND (expires_at IS NULL OR expires_at > $3)
+		ORDER BY segment_id
+	`
+
+	ids := []int{}
+	if err := sqlx.SelectContext(ctx, r.q, &ids, query, memberID, pq.Array(segmentIDs), at); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// memberSelectQuery builds the query selecting the subjects of q. A single
+// segment is read straight from the primary key index; expressions group the
+// live memberships of the referenced segments by subject and evaluate the
+// expression over them.
+func memberSelectQuery(q segment.MemberQuery) (string, []interface{}) {
+	if q.Expression.Op == segment.OpSegment {
+		query := `
+			SELECT member_id FROM segment_members
+			WHERE segment_id = $1 AND (expires_at IS NULL OR expires_at > $2) AND member_id > $3
+			ORDER BY member_id
+		`
+		return query, []interface{}{q.Expression.SegmentID, q.At, q.After}
+	}
+
+	refs := q.Expression.SegmentIDs()
+	query := `
+		SELECT member_id FROM segment_members
+		WHERE segment_id = ANY($1) AND (expires_at IS NULL OR expires_at > $2) AND member_id > $3
+		GROUP BY member_id
+		HAVING ` + memberPredicate(q.Expression) + `
+		ORDER BY member_id
+	`
+	return query, []interface{}{pq.Array(refs), q.At, q.After}
+}
+
+// memberPredicate compiles an expression to an aggregate condition over the
+// memberships of one subject. Segment IDs are integers, so they are inlined.
+func memberPredicate(e *segment.Expression) string {
+	switch e.Op {
+	case segment.OpSegment:
+		return fmt.Sprintf("bool_or(segment_id = %d)", e.SegmentID)
+	case segment.OpNot:
+		return "NOT " + memberPredicate(e.Operands[0])
+	}
+
+	parts := make([]string, 0, len(e.Operands))
+	for _, operand := range e.Operands {
+		parts = append(parts, memberPredicate(operand))
+	}
+	return "(" + strings.Join(parts, " "+strings.ToUpper(string(e.Op))+" ") + ")"
+}
+
+// ListReferencing returns the composite segments referencing id.
+func (r *PostgreSQLSegmentRepository) ListReferencing(ctx context.Context, id int) ([]segment.Segment, error) {
+	query := `
+		SELECT id, name, description, labels, ttl_seconds, active_from, active_until, expression, state, member_count, created_at, updated_at, deleted_at
+		FROM segments
+		WHERE deleted_at IS NULL AND referenced_ids @> ARRAY[$1]::int[]
+		ORDER BY id
+	`
+
+	var rows []segmentRow
+	if err := sqlx.SelectContext(ctx, r.q, &rows, query, id); err != nil {
+		return nil, err
+	}
+
+	segments := make([]segment.Segment, 0, len(rows))
+	for _, row := range rows {
+		segments = append(segments, *row.toSegment())
+	}
+
+	return segments, nil
+}
+
+// ListWindowBoundaries returns the segments whose activation window opens or
+// closes in (after, until].
+func (r *PostgreSQLSegmentRepository) ListWindowBoundaries(ctx context.Context, after, until time.Time) ([]segment.Segment, error) {
+	query := `
+		SELECT id, name, description, labels, ttl_seconds, active_from, active_until, expression, state, member_count, created_at, updated_at, deleted_at
+		FROM segments
+		WHERE deleted_at IS NULL
+		  AND ((active_from > $1 AND active_from <= $2) OR (active_until > $1 AND active_until <= $2))
+		ORDER BY id
+	`
+
+	var rows []segmentRow
+	if err := sqlx.SelectContext(ctx, r.q, &rows, query, after, until); err != nil {
+		return nil, err
+	}
+
+	segments := make([]segment.Segment, 0, len(rows))
+	for _, row := range rows {
+		segments = append(segments, *row.toSegment())
+	}
+
+	return segments, nil
+}
+
+// RunInTransaction runs fn inside a database transaction, committing it if fn
+// succeeds and rolling it back otherwise.
+func (r *PostgreSQLSegmentRepository) RunInTransaction(ctx context.Context, fn func(ctx context.Context, repo segment.Repository) error) error {
+	markWritten(ctx)
+
+	if r.tx != nil {
+		return fn(ctx, r)
+	}
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return &BeginError{Err: err}
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	txRepo := &PostgreSQLSegmentRepository{db: r.db, q: tx, tx: tx}
+	if err := fn(ctx, txRepo); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return errors.Join(err, fmt.Errorf("failed to roll back transaction: %w", rbErr))
+		}
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// eventRow represents a database row of a segment event.
+type eventRow struct {
+	Version    int       `db:"version"`
+	Type       string    `db:"type"`
+	OccurredAt time.Time `db:"occurred_at"`
+	Payload    []byte    `db:"payload"`
+}
+
+// AppendEvents stores events after expectedVersion. The primary key rejects
+// events appended concurrently after the version is checked.
+func (r *PostgreSQLSegmentRepository) AppendEvents(ctx context.Context, segmentID int, expectedVersion int, events []segment.Event) error {
+	markWritten(ctx)
+
+	var version int
+	err := sqlx.GetContext(ctx, r.q, &version, `SELECT COALESCE(MAX(version), 0) FROM segment_events WHERE segment_id = $1`, segmentID)
+	if err != nil {
+		return err
+	}
+	if version != expectedVersion {
+		return fmt.Errorf("%w: expected version %d, got %d", segment.ErrVersionConflict, expectedVersion, version)
+	}
+
+	// A single statement appends all events or none.
+	values := make([]string, 0, len(events))
+	args := make([]interface{}, 0, 1+4*len(events))
+	args = append(args, segmentID)
+	for _, e := range events {
+		payload, err := encodeEventPayload(e)
+		if err != nil {
+			return err
+		}
+		n := len(args)
+		values = append(values, fmt.Sprintf("($1, $%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4))
+		args = append(args, e.Version, string(e.Type), e.At, payload)
+	}
+
+	query := `INSERT INTO segment_events (segment_id, version, type, occurred_at, payload) VALUES ` + strings.Join(values, ", ")
+	if _, err := r.q.ExecContext(ctx, query, args...); err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return fmt.Errorf("%w: events after version %d exist", segment.ErrVersionConflict, expectedVersion)
+		}
+		return err
+	}
+	return nil
+}
+
+// LoadEvents returns the events of a segment after afterVersion up to the
+// last one that happened at or before until.
+func (r *PostgreSQLSegmentRepository) LoadEvents(ctx context.Context, segmentID int, afterVersion int, until *time.Time) ([]segment.Event, error) {
+	query := `
+		SELECT version, type, occurred_at, payload
+		FROM segment_events
+		WHERE segment_id = $1 AND version > $2
+		  AND ($3::timestamp IS NULL OR version <= (
+		    SELECT MAX(version) FROM segment_events WHERE segment_id = $1 AND occurred_at <= $3
+		  ))
+		ORDER BY version
+	`
+
+	var rows []eventRow
+	err := r.read(ctx, func(q sqlx.ExtContext) error {
+		rows = nil
+		return sqlx.SelectContext(ctx, q, &rows, query, segmentID, afterVersion, until)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]segment.Event, 0, len(rows))
+	for _, row := range rows {
+		e, err := decodeEvent(segmentID, row.Version, row.Type, row.OccurredAt, row.Payload)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+// LoadSnapshot returns the latest snapshot of a segment up to the last event
+// that happened at or before until.
+func (r *PostgreSQLSegmentRepository) LoadSnapshot(ctx context.Context, segmentID int, until *time.Time) (*segment.Snapshot, error) {
+	query := `
+		SELECT version, occurred_at, segment
+		FROM segment_snapshots
+		WHERE segment_id = $1
+		  AND ($2::timestamp IS NULL OR version <= (
+		    SELECT MAX(version) FROM segment_events WHERE segment_id = $1 AND occurred_at <= $2
+		  ))
+		ORDER BY version DESC
+		LIMIT 1
+	`
+
+	var row struct {
+		Version    int       `db:"version"`
+		OccurredAt time.Time `db:"occurred_at"`
+		Segment    []byte    `db:"segment"`
+	}
+	err := r.read(ctx, func(q sqlx.ExtContext) error {
+		return sqlx.GetContext(ctx, q, &row, query, segmentID, until)
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return decodeSnapshot(row.Version, row.OccurredAt, row.Segment)
+}
+
+// SaveSnapshot stores a snapshot of a segment.
+func (r *PostgreSQLSegmentRepository) SaveSnapshot(ctx context.Context, segmentID int, snapshot segment.Snapshot) error {
+	markWritten(ctx)
+
+	data, err := encodeSnapshot(snapshot.Segment)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO segment_snapshots (segment_id, version, occurred_at, segment)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (segment_id, version) DO UPDATE SET occurred_at = EXCLUDED.occurred_at, segment = EXCLUDED.segment
+	`
+	_, err = r.q.ExecContext(ctx, query, segmentID, snapshot.Version, snapshot.At, data)
+	return err
+}
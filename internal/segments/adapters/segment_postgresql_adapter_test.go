@@ -0,0 +1,155 @@
+package adapters_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rickKoch/nexus/internal/segments/adapters"
+	"github.com/rickKoch/nexus/internal/segments/adapters/migrations"
+	"github.com/rickKoch/nexus/internal/segments/domain/segment"
+	"github.com/rickKoch/nexus/internal/segments/domain/segment/segmenttest"
+	"github.com/rickKoch/nexus/pkg/clock"
+	"github.com/rickKoch/nexus/pkg/migrate"
+)
+
+// postgresDSNEnv names the environment variable holding the data source name
+// of a PostgreSQL database to run the repository tests against. The tests
+// empty the database's segment tables.
+const postgresDSNEnv = "NEXUS_TEST_POSTGRES_DSN"
+
+func TestPostgreSQLSegmentRepository(t *testing.T) {
+	dsn := os.Getenv(postgresDSNEnv)
+	if dsn == "" {
+		t.Skipf("%s is not set", postgresDSNEnv)
+	}
+
+	ctx := context.Background()
+	db, err := sqlx.Connect("postgres", dsn)
+	if err != nil {
+		t.Fatalf("failed to connect to database: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	m, err := migrate.New(db, migrations.FS)
+	if err != nil {
+		t.Fatalf("failed to load migrations: %v", err)
+	}
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatalf("failed to apply migrations: %v", err)
+	}
+
+	segmenttest.RunRepositoryTests(t, func(t *testing.T) segmenttest.Repository {
+		if _, err := db.ExecContext(ctx, `TRUNCATE segments, segment_members, segment_size_history, segment_events, segment_snapshots, segment_revisions`); err != nil {
+			t.Fatalf("failed to empty database: %v", err)
+		}
+		return adapters.NewPostgreSQLSegmentRepository(adapters.NewPostgreSQLDB(db))
+	})
+
+	t.Run("event sourced", func(t *testing.T) {
+		segmenttest.RunRepositoryTests(t, func(t *testing.T) segmenttest.Repository {
+			if _, err := db.ExecContext(ctx, `TRUNCATE segments, segment_members, segment_size_history, segment_events, segment_snapshots, segment_revisions`); err != nil {
+				t.Fatalf("failed to empty database: %v", err)
+			}
+			repo := adapters.NewPostgreSQLSegmentRepository(adapters.NewPostgreSQLDB(db))
+			return eventSourcedRepository{adapters.NewEventSourcedSegmentRepository(repo, 2), repo}
+		})
+	})
+
+	t.Run("locks segments read in a transaction", func(t *testing.T) {
+		if _, err := db.ExecContext(ctx, `TRUNCATE segments, segment_members, segment_size_history, segment_events, segment_snapshots, segment_revisions`); err != nil {
+			t.Fatalf("failed to empty database: %v", err)
+		}
+		repo := adapters.NewPostgreSQLSegmentRepository(adapters.NewPostgreSQLDB(db))
+		f, _ := segment.NewFactory(segment.SegmentConfig{Name: "premium-users"})
+		id, _ := repo.NextID(ctx)
+		if _, err := repo.Create(ctx, f.NewSegment(id, time.Now())); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		err := repo.RunInTransaction(ctx, func(ctx context.Context, tx segment.Repository) error {
+			if _, err := tx.Get(ctx, id); err != nil {
+				return err
+			}
+
+			waitCtx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+			defer cancel()
+			err := repo.RunInTransaction(waitCtx, func(ctx context.Context, other segment.Repository) error {
+				_, err := other.Get(ctx, id)
+				return err
+			})
+			if err == nil {
+				t.Error("expected a concurrent transaction to wait for the lock")
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	})
+
+	t.Run("archives size history beyond the retention", func(t *testing.T) {
+		if _, err := db.ExecContext(ctx, `TRUNCATE segments, segment_members, segment_size_history, segment_events, segment_snapshots, segment_revisions`); err != nil {
+			t.Fatalf("failed to empty database: %v", err)
+		}
+		repo := adapters.NewPostgreSQLSegmentRepository(adapters.NewPostgreSQLDB(db))
+		archiveDir := t.TempDir()
+		clk := clock.NewFake(time.Date(2020, time.January, 15, 12, 0, 0, 0, time.UTC))
+		maintainer, err := adapters.NewPostgreSQLPartitionMaintainer(db, clk, adapters.PartitionConfig{
+			Interval:   time.Hour,
+			Ahead:      2,
+			Retention:  2,
+			ArchiveDir: archiveDir,
+		})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		t.Cleanup(func() {
+			for m := 1; m <= 12; m++ {
+				_, _ = db.ExecContext(ctx, fmt.Sprintf(`DROP TABLE IF EXISTS segment_size_history_y2020m%02d`, m))
+			}
+		})
+
+		f, _ := segment.NewFactory(segment.SegmentConfig{Name: "premium-users"})
+		id, _ := repo.NextID(ctx)
+		if _, err := repo.Create(ctx, f.NewSegment(id, clk.Now())); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if err := maintainer.Maintain(ctx); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		for _, day := range []time.Time{clk.Now(), clk.Now().AddDate(0, 2, 0)} {
+			if err := repo.RecordSizeSnapshots(ctx, day); err != nil {
+				t.Fatalf("expected a partition for %s, got %v", day, err)
+			}
+		}
+
+		clk.Set(time.Date(2020, time.April, 1, 0, 0, 0, 0, time.UTC))
+		if err := maintainer.Maintain(ctx); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		history, err := repo.ListSizeHistory(ctx, id, time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC), clk.Now())
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if len(history) != 1 || history[0].Day.Month() != time.March {
+			t.Errorf("expected March to be kept, got %v", history)
+		}
+		for _, month := range []string{"01", "02"} {
+			path := filepath.Join(archiveDir, "segment_size_history_y2020m"+month+".ndjson.gz")
+			if _, err := os.Stat(path); err != nil {
+				t.Errorf("expected an archive of 2020-%s, got %v", month, err)
+			}
+		}
+		var partitions int
+		err = db.GetContext(ctx, &partitions, `SELECT count(*) FROM pg_class WHERE relname IN ('segment_size_history_y2020m01', 'segment_size_history_y2020m02')`)
+		if err != nil || partitions != 0 {
+			t.Errorf("expected archived partitions to be dropped, got %d (%v)", partitions, err)
+		}
+	})
+}
@@ -0,0 +1,394 @@
+package adapters
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/rickKoch/nexus/internal/segments/domain/segment"
+	"github.com/rickKoch/nexus/pkg/resp"
+	"github.com/sirupsen/logrus"
+)
+
+// redisSyncPageSize is the number of segments listed at a time, and
+// redisSyncBatchSize the number of memberships copied per command, when
+// copying memberships into the store.
+const (
+	redisSyncPageSize  = 100
+	redisSyncBatchSize = 1000
+)
+
+// RedisMembershipRepository is a segment.Repository decorator keeping a copy
+// of every membership in a Redis-compatible store, from which ListMemberships
+// answers membership checks. The wrapped repository remains the source of
+// truth: memberships are written to it first, along with member counts and
+// sketches, and copied to the store once the write has committed. Exports
+// still stream from the wrapped repository.
+//
+// A store missing a committed write is out of step. When a copy fails, the
+// "members-synced" key is deleted and ListMemberships reads the wrapped
+// repository, on every instance, until Sync copies every membership again.
+//
+// The memberships of a segment are kept in a sorted set scored by the
+// membership's expiry in Unix milliseconds, or +inf for memberships that do
+// not expire, so that a check compares the score with the current time and
+// purges remove a range of scores.
+type RedisMembershipRepository struct {
+	segment.Repository
+
+	client    *resp.Client
+	keyPrefix string
+
+	// stale is set while this instance knows the store to be out of step,
+	// and failures counts failed copies, so that a Sync racing with one does
+	// not mark the store as in step.
+	stale    atomic.Bool
+	failures atomic.Int64
+}
+
+// NewRedisMembershipRepository wraps repo with a membership store reached
+// through client. Keys are prefixed with keyPrefix.
+func NewRedisMembershipRepository(repo segment.Repository, client *resp.Client, keyPrefix string) *RedisMembershipRepository {
+	return &RedisMembershipRepository{
+		Repository: repo,
+		client:     client,
+		keyPrefix:  keyPrefix,
+	}
+}
+
+// AddMembers adds memberships to the wrapped repository and then to the store.
+func (r *RedisMembershipRepository) AddMembers(ctx context.Context, segmentID int, members []segment.Member) error {
+	if err := r.Repository.AddMembers(ctx, segmentID, members); err != nil {
+		return err
+	}
+	return r.copyChanges(ctx, &membershipChanges{commands: r.addCommands(segmentID, members)})
+}
+
+// RemoveMembers removes memberships from the wrapped repository and then
+// from the store.
+func (r *RedisMembershipRepository) RemoveMembers(ctx context.Context, segmentID int, memberIDs []string) error {
+	if err := r.Repository.RemoveMembers(ctx, segmentID, memberIDs); err != nil {
+		return err
+	}
+	return r.copyChanges(ctx, &membershipChanges{commands: r.removeCommands(segmentID, memberIDs)})
+}
+
+// PurgeExpiredMembers purges the wrapped repository and then the store.
+func (r *RedisMembershipRepository) PurgeExpiredMembers(ctx context.Context, at time.Time) (int, error) {
+	n, err := r.Repository.PurgeExpiredMembers(ctx, at)
+	if err != nil {
+		return 0, err
+	}
+	return n, r.copyChanges(ctx, &membershipChanges{purgeAt: &at})
+}
+
+// ListMemberships checks the subject's membership of every segment in one
+// round trip to the store, or reads the wrapped repository while the store is
+// out of step.
+func (r *RedisMembershipRepository) ListMemberships(ctx context.Context, memberID string, segmentIDs []int, at time.Time) ([]int, error) {
+	if r.stale.Load() {
+		return r.Repository.ListMemberships(ctx, memberID, segmentIDs, at)
+	}
+
+	ids := slices.Clone(segmentIDs)
+	slices.Sort(ids)
+	ids = slices.Compact(ids)
+	if len(ids) == 0 {
+		return []int{}, nil
+	}
+
+	// The synced key is read along with the memberships, as other
+	// instances delete it when their copies fail.
+	commands := make([][]string, 0, 1+len(ids))
+	commands = append(commands, []string{"EXISTS", r.syncedKey()})
+	for _, id := range ids {
+		commands = append(commands, []string{"ZSCORE", r.membersKey(id), memberID})
+	}
+	replies, err := r.client.Pipeline(ctx, commands)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read membership store: %w", err)
+	}
+	if replies[0] != int64(1) {
+		return r.Repository.ListMemberships(ctx, memberID, segmentIDs, at)
+	}
+
+	live := []int{}
+	now := float64(at.UnixMilli())
+	for i, reply := range replies[1:] {
+		switch v := reply.(type) {
+		case nil:
+		case string:
+			expiresAt, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse membership expiry '%s': %w", v, err)
+			}
+			if now < expiresAt {
+				live = append(live, ids[i])
+			}
+		case resp.Error:
+			return nil, fmt.Errorf("failed to read membership store: %w", v)
+		default:
+			return nil, fmt.Errorf("unexpected membership store reply %T", reply)
+		}
+	}
+	return live, nil
+}
+
+// RunInTransaction runs fn on the wrapped repository's transaction and
+// copies the membership changes made by fn to the store once it commits.
+// Until then, ListMemberships within the transaction reads the wrapped
+// repository, which sees the transaction's own writes.
+func (r *RedisMembershipRepository) RunInTransaction(ctx context.Context, fn func(ctx context.Context, repo segment.Repository) error) error {
+	changes := &membershipChanges{}
+	err := r.Repository.RunInTransaction(ctx, func(ctx context.Context, repo segment.Repository) error {
+		return fn(ctx, redisMembershipTx{Repository: repo, store: r, changes: changes})
+	})
+	if err != nil {
+		return err
+	}
+	return r.copyChanges(ctx, changes)
+}
+
+// Sync copies the memberships of every regular segment from the wrapped
+// repository into the store, replacing what the store holds for them, unless
+// the store is in step: a previous Sync completed and no copy failed since.
+// Deleting the "members-synced" key forces a new copy, such as after running
+// without the store for a while. Memberships written by other instances while
+// Sync runs may be overwritten.
+func (r *RedisMembershipRepository) Sync(ctx context.Context) error {
+	failures := r.failures.Load()
+	synced, err := r.client.Do(ctx, "EXISTS", r.syncedKey())
+	if err != nil {
+		return fmt.Errorf("failed to read membership store: %w", err)
+	}
+	if synced == int64(1) && !r.stale.Load() {
+		return nil
+	}
+
+	for page := 1; ; page++ {
+		result, err := r.Repository.List(ctx, segment.ListParams{Page: page, PageSize: redisSyncPageSize})
+		if err != nil {
+			return fmt.Errorf("failed to list segments: %w", err)
+		}
+		for _, s := range result.Segments {
+			if s.IsComposite() {
+				continue
+			}
+			if err := r.syncSegment(ctx, s.ID()); err != nil {
+				return fmt.Errorf("failed to copy members of segment '%d': %w", s.ID(), err)
+			}
+		}
+		if page*redisSyncPageSize >= result.TotalCount {
+			break
+		}
+	}
+
+	if r.failures.Load() != failures {
+		return errors.New("failed to copy memberships: a membership change failed to copy meanwhile")
+	}
+	if _, err := r.client.Do(ctx, "SET", r.syncedKey(), time.Now().UTC().Format(time.RFC3339)); err != nil {
+		return fmt.Errorf("failed to update membership store: %w", err)
+	}
+	r.stale.Store(false)
+	return nil
+}
+
+// Run calls Sync every interval until ctx is cancelled, so that a store out
+// of step is copied again once it accepts writes.
+func (r *RedisMembershipRepository) Run(ctx context.Context, interval time.Duration, onError func(err error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := r.Sync(ctx); err != nil && ctx.Err() == nil {
+			onError(err)
+		}
+	}
+}
+
+func (r *RedisMembershipRepository) syncSegment(ctx context.Context, segmentID int) error {
+	if _, err := r.client.Do(ctx, "DEL", r.membersKey(segmentID)); err != nil {
+		return err
+	}
+
+	batch := make([]segment.Member, 0, redisSyncBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := r.apply(ctx, &membershipChanges{commands: r.addCommands(segmentID, batch)})
+		batch = batch[:0]
+		return err
+	}
+
+	err := r.Repository.StreamMemberships(ctx, segmentID, func(m segment.Member) error {
+		batch = append(batch, m)
+		if len(batch) == redisSyncBatchSize {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return flush()
+}
+
+// copyChanges applies changes committed to the wrapped repository to the
+// store. If that fails, the store is marked as out of step, and the change is
+// only reported as failed if other instances cannot be told.
+func (r *RedisMembershipRepository) copyChanges(ctx context.Context, changes *membershipChanges) error {
+	err := r.apply(ctx, changes)
+	if err == nil {
+		return nil
+	}
+
+	r.failures.Add(1)
+	r.stale.Store(true)
+	if _, delErr := r.client.Do(ctx, "DEL", r.syncedKey()); delErr != nil {
+		return errors.Join(err, fmt.Errorf("failed to mark membership store as out of step: %w", delErr))
+	}
+	logrus.WithError(err).Warn("Membership store is out of step, reading memberships from the database until it is synced")
+	return nil
+}
+
+// membershipChanges collects the store commands for membership writes.
+type membershipChanges struct {
+	commands [][]string
+	// purgeAt is set when expired memberships were purged.
+	purgeAt *time.Time
+}
+
+// apply sends the commands of changes to the store in one round trip and
+// then purges it if requested. Memberships are replaced and removed by ID, so
+// a failed apply can be repaired by repeating the write.
+func (r *RedisMembershipRepository) apply(ctx context.Context, changes *membershipChanges) error {
+	if len(changes.commands) > 0 {
+		if err := r.exec(ctx, changes.commands); err != nil {
+			return err
+		}
+	}
+	if changes.purgeAt == nil {
+		return nil
+	}
+
+	reply, err := r.client.Do(ctx, "SMEMBERS", r.segmentsKey())
+	if err != nil {
+		return fmt.Errorf("failed to read membership store: %w", err)
+	}
+	ids, _ := reply.([]any)
+	commands := make([][]string, 0, len(ids))
+	// Memberships expiring exactly at purgeAt are expired, like in the
+	// wrapped repository.
+	upTo := strconv.FormatInt(changes.purgeAt.UnixMilli(), 10)
+	for _, id := range ids {
+		commands = append(commands, []string{"ZREMRANGEBYSCORE", r.keyPrefix + "segment:" + fmt.Sprint(id) + ":members", "-inf", upTo})
+	}
+	if len(commands) == 0 {
+		return nil
+	}
+	return r.exec(ctx, commands)
+}
+
+func (r *RedisMembershipRepository) exec(ctx context.Context, commands [][]string) error {
+	replies, err := r.client.Pipeline(ctx, commands)
+	if err != nil {
+		return fmt.Errorf("failed to update membership store: %w", err)
+	}
+	for _, reply := range replies {
+		if err, ok := reply.(resp.Error); ok {
+			return fmt.Errorf("failed to update membership store: %w", err)
+		}
+	}
+	return nil
+}
+
+func (r *RedisMembershipRepository) addCommands(segmentID int, members []segment.Member) [][]string {
+	zadd := make([]string, 0, 2+2*len(members))
+	zadd = append(zadd, "ZADD", r.membersKey(segmentID))
+	for _, m := range members {
+		score := "+inf"
+		if m.ExpiresAt != nil {
+			score = strconv.FormatInt(m.ExpiresAt.UnixMilli(), 10)
+		}
+		zadd = append(zadd, score, m.ID)
+	}
+
+	return [][]string{
+		zadd,
+		{"SADD", r.segmentsKey(), strconv.Itoa(segmentID)},
+	}
+}
+
+func (r *RedisMembershipRepository) removeCommands(segmentID int, memberIDs []string) [][]string {
+	zrem := make([]string, 0, 2+len(memberIDs))
+	zrem = append(zrem, "ZREM", r.membersKey(segmentID))
+	zrem = append(zrem, memberIDs...)
+	return [][]string{zrem}
+}
+
+// membersKey is the sorted set holding the memberships of a segment.
+func (r *RedisMembershipRepository) membersKey(segmentID int) string {
+	return r.keyPrefix + "segment:" + strconv.Itoa(segmentID) + ":members"
+}
+
+// segmentsKey is the set of the IDs of the segments with memberships in the
+// store, which purges go through.
+func (r *RedisMembershipRepository) segmentsKey() string {
+	return r.keyPrefix + "segments"
+}
+
+// syncedKey marks the store as holding a copy of every membership.
+func (r *RedisMembershipRepository) syncedKey() string {
+	return r.keyPrefix + "members-synced"
+}
+
+// redisMembershipTx is the view of a RedisMembershipRepository handed to a
+// transaction. Membership writes go to the wrapped transaction and are
+// collected for the store.
+type redisMembershipTx struct {
+	segment.Repository
+	store   *RedisMembershipRepository
+	changes *membershipChanges
+}
+
+func (t redisMembershipTx) AddMembers(ctx context.Context, segmentID int, members []segment.Member) error {
+	if err := t.Repository.AddMembers(ctx, segmentID, members); err != nil {
+		return err
+	}
+	t.changes.commands = append(t.changes.commands, t.store.addCommands(segmentID, members)...)
+	return nil
+}
+
+func (t redisMembershipTx) RemoveMembers(ctx context.Context, segmentID int, memberIDs []string) error {
+	if err := t.Repository.RemoveMembers(ctx, segmentID, memberIDs); err != nil {
+		return err
+	}
+	t.changes.commands = append(t.changes.commands, t.store.removeCommands(segmentID, memberIDs)...)
+	return nil
+}
+
+func (t redisMembershipTx) PurgeExpiredMembers(ctx context.Context, at time.Time) (int, error) {
+	n, err := t.Repository.PurgeExpiredMembers(ctx, at)
+	if err != nil {
+		return 0, err
+	}
+	t.changes.purgeAt = &at
+	return n, nil
+}
+
+func (t redisMembershipTx) RunInTransaction(ctx context.Context, fn func(ctx context.Context, repo segment.Repository) error) error {
+	return t.Repository.RunInTransaction(ctx, func(ctx context.Context, repo segment.Repository) error {
+		return fn(ctx, redisMembershipTx{Repository: repo, store: t.store, changes: t.changes})
+	})
+}
@@ -0,0 +1,267 @@
+package adapters_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rickKoch/nexus/internal/segments/adapters"
+	"github.com/rickKoch/nexus/internal/segments/domain/segment"
+	"github.com/rickKoch/nexus/internal/segments/domain/segment/segmenttest"
+	"github.com/rickKoch/nexus/pkg/resp"
+	"github.com/rickKoch/nexus/pkg/resp/resptest"
+)
+
+func TestRedisMembershipRepository(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)
+	ttl := 3600
+	permanent, _ := segment.NewFactory(segment.SegmentConfig{Name: "premium-users"})
+	expiring, _ := segment.NewFactory(segment.SegmentConfig{Name: "trial-users", TTLSeconds: &ttl})
+
+	setup := func(t *testing.T) (*adapters.InMemorySegmentRepository, *adapters.RedisMembershipRepository, *resptest.Server) {
+		t.Helper()
+
+		srv, err := resptest.NewServer("")
+		if err != nil {
+			t.Fatalf("failed to start server: %v", err)
+		}
+		t.Cleanup(func() { _ = srv.Close() })
+		client := resp.NewClient(resp.Config{Addr: srv.Addr, PoolSize: 2})
+		t.Cleanup(func() { _ = client.Close() })
+
+		inner := adapters.NewInMemorySegmentRepository()
+		for _, s := range []*segment.Segment{permanent.NewSegment(1, now), expiring.NewSegment(2, now)} {
+			if _, err := inner.Create(ctx, s); err != nil {
+				t.Fatalf("failed to create segment: %v", err)
+			}
+		}
+		return inner, adapters.NewRedisMembershipRepository(inner, client, "test:"), srv
+	}
+
+	segmenttest.RunRepositoryTests(t, func(t *testing.T) segmenttest.Repository {
+		srv, err := resptest.NewServer("")
+		if err != nil {
+			t.Fatalf("failed to start server: %v", err)
+		}
+		t.Cleanup(func() { _ = srv.Close() })
+		client := resp.NewClient(resp.Config{Addr: srv.Addr, PoolSize: 2})
+		t.Cleanup(func() { _ = client.Close() })
+
+		inner := adapters.NewInMemorySegmentRepository()
+		store := adapters.NewRedisMembershipRepository(inner, client, "test:")
+		if err := store.Sync(ctx); err != nil {
+			t.Fatalf("failed to sync store: %v", err)
+		}
+		return decoratedRepository{store, inner}
+	})
+
+	addMembers := func(t *testing.T, repo segment.Repository, segmentID int, ids ...string) {
+		t.Helper()
+
+		s, err := repo.Get(ctx, segmentID)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		members := make([]segment.Member, 0, len(ids))
+		for _, id := range ids {
+			m, _ := s.NewMember(id, now)
+			members = append(members, m)
+		}
+		if err := repo.AddMembers(ctx, segmentID, members); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	sync := func(t *testing.T, repo *adapters.RedisMembershipRepository) {
+		t.Helper()
+
+		if err := repo.Sync(ctx); err != nil {
+			t.Fatalf("failed to sync store: %v", err)
+		}
+	}
+	memberships := func(t *testing.T, repo segment.Repository, memberID string, at time.Time) []int {
+		t.Helper()
+
+		ids, err := repo.ListMemberships(ctx, memberID, []int{2, 1, 3, 2}, at)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		return ids
+	}
+
+	t.Run("copies membership writes", func(t *testing.T) {
+		_, repo, srv := setup(t)
+		sync(t, repo)
+
+		addMembers(t, repo, 1, "user-1", "user-2")
+		addMembers(t, repo, 2, "user-1")
+		if ids := memberships(t, repo, "user-1", now); !reflect.DeepEqual(ids, []int{1, 2}) {
+			t.Errorf("expected memberships [1 2], got %v", ids)
+		}
+
+		if err := repo.RemoveMembers(ctx, 1, []string{"user-1"}); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if ids := memberships(t, repo, "user-1", now); !reflect.DeepEqual(ids, []int{2}) {
+			t.Errorf("expected memberships [2], got %v", ids)
+		}
+		if ids := memberships(t, repo, "user-3", now); len(ids) != 0 {
+			t.Errorf("expected no memberships, got %v", ids)
+		}
+		if n := srv.Commands("ZSCORE"); n != 9 {
+			t.Errorf("expected checks to read the store, got %d reads", n)
+		}
+	})
+
+	t.Run("expires and purges memberships", func(t *testing.T) {
+		_, repo, srv := setup(t)
+		sync(t, repo)
+		addMembers(t, repo, 1, "user-1")
+		addMembers(t, repo, 2, "user-1")
+
+		expiry := now.Add(time.Duration(ttl) * time.Second)
+		if ids := memberships(t, repo, "user-1", expiry.Add(-time.Millisecond)); !reflect.DeepEqual(ids, []int{1, 2}) {
+			t.Errorf("expected memberships [1 2] before the expiry, got %v", ids)
+		}
+		if ids := memberships(t, repo, "user-1", expiry); !reflect.DeepEqual(ids, []int{1}) {
+			t.Errorf("expected memberships [1] at the expiry, got %v", ids)
+		}
+
+		n, err := repo.PurgeExpiredMembers(ctx, expiry)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if n != 1 {
+			t.Errorf("expected one purged membership, got %d", n)
+		}
+		if n := srv.Commands("ZREMRANGEBYSCORE"); n != 2 {
+			t.Errorf("expected both segments to be purged, got %d purges", n)
+		}
+		if ids := memberships(t, repo, "user-1", now); !reflect.DeepEqual(ids, []int{1}) {
+			t.Errorf("expected the purged membership to be gone, got %v", ids)
+		}
+	})
+
+	t.Run("copies transaction writes once committed", func(t *testing.T) {
+		_, repo, srv := setup(t)
+		sync(t, repo)
+		errRollback := errors.New("rollback")
+
+		err := repo.RunInTransaction(ctx, func(ctx context.Context, tx segment.Repository) error {
+			addMembers(t, tx, 1, "user-1")
+			if n := srv.Commands("ZADD"); n != 0 {
+				t.Errorf("expected no writes before the commit, got %d", n)
+			}
+			if ids := memberships(t, tx, "user-1", now); !reflect.DeepEqual(ids, []int{1}) {
+				t.Errorf("expected the transaction to see its writes, got %v", ids)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if ids := memberships(t, repo, "user-1", now); !reflect.DeepEqual(ids, []int{1}) {
+			t.Errorf("expected memberships [1], got %v", ids)
+		}
+
+		err = repo.RunInTransaction(ctx, func(ctx context.Context, tx segment.Repository) error {
+			addMembers(t, tx, 2, "user-1")
+			return errRollback
+		})
+		if !errors.Is(err, errRollback) {
+			t.Fatalf("expected %v, got %v", errRollback, err)
+		}
+		if ids := memberships(t, repo, "user-1", now); !reflect.DeepEqual(ids, []int{1}) {
+			t.Errorf("expected the rolled back write not to be copied, got %v", ids)
+		}
+	})
+
+	t.Run("syncs existing memberships once", func(t *testing.T) {
+		inner, repo, srv := setup(t)
+		addMembers(t, inner, 1, "user-1")
+		addMembers(t, inner, 2, "user-1")
+		if ids := memberships(t, repo, "user-1", now); !reflect.DeepEqual(ids, []int{1, 2}) {
+			t.Errorf("expected memberships [1 2] from the wrapped repository before syncing, got %v", ids)
+		}
+
+		if err := repo.Sync(ctx); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		// The store is read once synced, so it still holds the membership.
+		if err := inner.RemoveMembers(ctx, 2, []string{"user-1"}); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if ids := memberships(t, repo, "user-1", now); !reflect.DeepEqual(ids, []int{1, 2}) {
+			t.Errorf("expected memberships [1 2] from the store after syncing, got %v", ids)
+		}
+
+		if err := repo.Sync(ctx); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if n := srv.Commands("DEL"); n != 2 {
+			t.Errorf("expected the second sync to be skipped, got %d segment copies", n)
+		}
+	})
+	t.Run("reads the wrapped repository while out of step", func(t *testing.T) {
+		inner, repo, srv := setup(t)
+		sync(t, repo)
+		client := resp.NewClient(resp.Config{Addr: srv.Addr})
+		t.Cleanup(func() { _ = client.Close() })
+		other := adapters.NewRedisMembershipRepository(inner, client, "test:")
+		addMembers(t, repo, 1, "user-1")
+
+		srv.Fail("ZADD", true)
+		addMembers(t, repo, 2, "user-1")
+		for name, r := range map[string]segment.Repository{"this instance": repo, "another instance": other} {
+			if ids := memberships(t, r, "user-1", now); !reflect.DeepEqual(ids, []int{1, 2}) {
+				t.Errorf("expected %s to read memberships [1 2] from the wrapped repository, got %v", name, ids)
+			}
+		}
+		if err := repo.Sync(ctx); err == nil {
+			t.Error("expected syncing to fail while the store fails")
+		}
+
+		srv.Fail("ZADD", false)
+		runCtx, cancel := context.WithCancel(ctx)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			repo.Run(runCtx, 10*time.Millisecond, func(err error) { t.Errorf("expected no error, got %v", err) })
+		}()
+		deadline := time.Now().Add(5 * time.Second)
+		for srv.Commands("SET") < 2 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		cancel()
+		<-done
+
+		// Once synced again, both instances read the store, which still
+		// holds the membership.
+		if err := inner.RemoveMembers(ctx, 2, []string{"user-1"}); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		for name, r := range map[string]segment.Repository{"this instance": repo, "another instance": other} {
+			if ids := memberships(t, r, "user-1", now); !reflect.DeepEqual(ids, []int{1, 2}) {
+				t.Errorf("expected %s to read memberships [1 2] from the store, got %v", name, ids)
+			}
+		}
+	})
+
+	t.Run("reports copies that cannot be marked as out of step", func(t *testing.T) {
+		_, repo, srv := setup(t)
+		sync(t, repo)
+
+		srv.Fail("ZADD", true)
+		srv.Fail("DEL", true)
+		s, _ := repo.Get(ctx, 1)
+		m, _ := s.NewMember("user-1", now)
+		if err := repo.AddMembers(ctx, 1, []segment.Member{m}); err == nil {
+			t.Error("expected an error")
+		}
+		if ids := memberships(t, repo, "user-1", now); !reflect.DeepEqual(ids, []int{1}) {
+			t.Errorf("expected memberships [1] from the wrapped repository, got %v", ids)
+		}
+	})
+}
@@ -0,0 +1,512 @@
+package adapters
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/rickKoch/nexus/internal/segments/domain/segment"
+	"github.com/rickKoch/nexus/pkg/clock"
+)
+
+// ErrCircuitOpen is returned without calling the database while the circuit
+// breaker of a ResilientSegmentRepository is open.
+var ErrCircuitOpen = fmt.Errorf("%w: circuit breaker is open", segment.ErrUnavailable)
+
+// BeginError is returned by the RunInTransaction of the database adapters
+// when the transaction could not be started, so its function never ran.
+type BeginError struct {
+	Err error
+}
+
+func (e *BeginError) Error() string { return "failed to begin transaction: " + e.Err.Error() }
+
+func (e *BeginError) Unwrap() error { return e.Err }
+
+// ResilienceConfig configures a ResilientSegmentRepository.
+type ResilienceConfig struct {
+	// MaxAttempts is how often an idempotent operation is attempted in
+	// total. One disables retries.
+	MaxAttempts int
+	// Backoff is the delay before the first retry, doubled for every
+	// further retry up to MaxBackoff. Delays are jittered by up to half.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// BreakerThreshold is the number of consecutive transient failures
+	// that opens the circuit breaker. Zero disables the breaker.
+	BreakerThreshold int
+	// BreakerTimeout is how long the breaker stays open before a single
+	// call is let through to probe the database.
+	BreakerTimeout time.Duration
+}
+
+// ResilientSegmentRepository is a segment.Repository decorator riding out
+// brief database outages such as a failover.
+//
+// Transient errors, like connection resets, server shutdowns and
+// serialization failures, are retried with jittered exponential backoff for
+// idempotent operations, as long as the context allows. Create, Update and
+// Delete are attempted once, as they may have taken effect before the error.
+// Transactions are only retried when the database did not start them or
+// rolled them back. Errors still transient after the last attempt wrap
+// segment.ErrUnavailable.
+//
+// Consecutive transient failures open a circuit breaker, after which calls
+// fail fast with ErrCircuitOpen until BreakerTimeout has passed and a probe
+// call succeeds. Ready reports whether the breaker is open.
+type ResilientSegmentRepository struct {
+	segment.Repository
+
+	ids     segment.IDGenerator
+	cfg     ResilienceConfig
+	breaker *circuitBreaker
+}
+
+// NewResilientSegmentRepository wraps repo, and ids which hands out the IDs of
+// its segments, with retries and a circuit breaker.
+func NewResilientSegmentRepository(repo segment.Repository, ids segment.IDGenerator, clk clock.Clock, cfg ResilienceConfig) *ResilientSegmentRepository {
+	return &ResilientSegmentRepository{
+		Repository: repo,
+		ids:        ids,
+		cfg:        cfg,
+		breaker: &circuitBreaker{
+			clock:     clk,
+			threshold: cfg.BreakerThreshold,
+			timeout:   cfg.BreakerTimeout,
+		},
+	}
+}
+
+// Ready returns ErrCircuitOpen while the circuit breaker is open.
+func (r *ResilientSegmentRepository) Ready() error {
+	return r.breaker.ready()
+}
+
+// NextID reserves the next segment ID. IDs are not reused, so a retry only
+// skips one.
+func (r *ResilientSegmentRepository) NextID(ctx context.Context) (id int, err error) {
+	err = r.do(ctx, retryAlways, func() (err error) {
+		id, err = r.ids.NextID(ctx)
+		return err
+	})
+	return id, err
+}
+
+// List returns a page of segments.
+func (r *ResilientSegmentRepository) List(ctx context.Context, params segment.ListParams) (result *segment.ListResult, err error) {
+	err = r.do(ctx, retryAlways, func() (err error) {
+		result, err = r.Repository.List(ctx, params)
+		return err
+	})
+	return result, err
+}
+
+// Get returns a segment by ID.
+func (r *ResilientSegmentRepository) Get(ctx context.Context, id int) (s *segment.Segment, err error) {
+	err = r.do(ctx, retryAlways, func() (err error) {
+		s, err = r.Repository.Get(ctx, id)
+		return err
+	})
+	return s, err
+}
+
+// GetShared returns a segment by ID.
+func (r *ResilientSegmentRepository) GetShared(ctx context.Context, id int) (s *segment.Segment, err error) {
+	err = r.do(ctx, retryAlways, func() (err error) {
+		s, err = r.Repository.GetShared(ctx, id)
+		return err
+	})
+	return s, err
+}
+
+// Create stores a new segment. It is not retried, as a retry would fail
+// with a duplicate ID if the first attempt was committed.
+func (r *ResilientSegmentRepository) Create(ctx context.Context, s *segment.Segment) (created *segment.Segment, err error) {
+	err = r.do(ctx, retryNever, func() (err error) {
+		created, err = r.Repository.Create(ctx, s)
+		return err
+	})
+	return created, err
+}
+
+// Update updates a segment. It is not retried, as a retry would save a
+// second revision if the first attempt was committed.
+func (r *ResilientSegmentRepository) Update(ctx context.Context, s *segment.Segment) (updated *segment.Segment, err error) {
+	err = r.do(ctx, retryNever, func() (err error) {
+		updated, err = r.Repository.Update(ctx, s)
+		return err
+	})
+	return updated, err
+}
+
+// Delete stores the deletion of a segment. It is not retried, as a retry
+// would not find the segment if the first attempt was committed.
+func (r *ResilientSegmentRepository) Delete(ctx context.Context, s *segment.Segment) error {
+	return r.do(ctx, retryNever, func() error {
+		return r.Repository.Delete(ctx, s)
+	})
+}
+
+// ListRevisions returns the revisions of a segment.
+func (r *ResilientSegmentRepository) ListRevisions(ctx context.Context, segmentID int) (revisions []segment.Revision, err error) {
+	err = r.do(ctx, retryAlways, func() (err error) {
+		revisions, err = r.Repository.ListRevisions(ctx, segmentID)
+		return err
+	})
+	return revisions, err
+}
+
+// GetRevision returns a revision of a segment.
+func (r *ResilientSegmentRepository) GetRevision(ctx context.Context, segmentID, version int) (revision *segment.Revision, err error) {
+	err = r.do(ctx, retryAlways, func() (err error) {
+		revision, err = r.Repository.GetRevision(ctx, segmentID, version)
+		return err
+	})
+	return revision, err
+}
+
+// ListReferencing returns the composite segments referencing id.
+func (r *ResilientSegmentRepository) ListReferencing(ctx context.Context, id int) (segments []segment.Segment, err error) {
+	err = r.do(ctx, retryAlways, func() (err error) {
+		segments, err = r.Repository.ListReferencing(ctx, id)
+		return err
+	})
+	return segments, err
+}
+
+// AddMembers stores memberships of a segment.
+func (r *ResilientSegmentRepository) AddMembers(ctx context.Context, segmentID int, members []segment.Member) error {
+	return r.do(ctx, retryAlways, func() error {
+		return r.Repository.AddMembers(ctx, segmentID, members)
+	})
+}
+
+// RemoveMembers removes memberships of a segment.
+func (r *ResilientSegmentRepository) RemoveMembers(ctx context.Context, segmentID int, memberIDs []string) error {
+	return r.do(ctx, retryAlways, func() error {
+		return r.Repository.RemoveMembers(ctx, segmentID, memberIDs)
+	})
+}
+
+// StreamMembers calls fn with the subjects selected by q. It is only
+// retried while fn has not been called.
+func (r *ResilientSegmentRepository) StreamMembers(ctx context.Context, q segment.MemberQuery, fn func(memberID string) error) error {
+	var s stream
+	return r.do(ctx, s.unstarted, func() error {
+		return r.Repository.StreamMembers(ctx, q, func(memberID string) error {
+			return s.call(func() error { return fn(memberID) })
+		})
+	})
+}
+
+// StreamMemberships calls fn with the memberships of a segment. It is only
+// retried while fn has not been called.
+func (r *ResilientSegmentRepository) StreamMemberships(ctx context.Context, segmentID int, fn func(m segment.Member) error) error {
+	var s stream
+	return r.do(ctx, s.unstarted, func() error {
+		return r.Repository.StreamMemberships(ctx, segmentID, func(m segment.Member) error {
+			return s.call(func() error { return fn(m) })
+		})
+	})
+}
+
+// ListMemberships returns the segments among segmentIDs the subject is a
+// live member of.
+func (r *ResilientSegmentRepository) ListMemberships(ctx context.Context, memberID string, segmentIDs []int, at time.Time) (ids []int, err error) {
+	err = r.do(ctx, retryAlways, func() (err error) {
+		ids, err = r.Repository.ListMemberships(ctx, memberID, segmentIDs, at)
+		return err
+	})
+	return ids, err
+}
+
+// PurgeExpiredMembers removes the memberships that expired at or before at.
+// A retry only purges what the failed attempt did not.
+func (r *ResilientSegmentRepository) PurgeExpiredMembers(ctx context.Context, at time.Time) (purged int, err error) {
+	err = r.do(ctx, retryAlways, func() (err error) {
+		purged, err = r.Repository.PurgeExpiredMembers(ctx, at)
+		return err
+	})
+	return purged, err
+}
+
+// RecordSizeSnapshots stores the member count of every regular segment as
+// its size on day.
+func (r *ResilientSegmentRepository) RecordSizeSnapshots(ctx context.Context, day time.Time) error {
+	return r.do(ctx, retryAlways, func() error {
+		return r.Repository.RecordSizeSnapshots(ctx, day)
+	})
+}
+
+// ListSizeHistory returns the size snapshots of a segment between from and
+// to inclusive.
+func (r *ResilientSegmentRepository) ListSizeHistory(ctx context.Context, segmentID int, from, to time.Time) (history []segment.SizeSnapshot, err error) {
+	err = r.do(ctx, retryAlways, func() (err error) {
+		history, err = r.Repository.ListSizeHistory(ctx, segmentID, from, to)
+		return err
+	})
+	return history, err
+}
+
+// GetSketch returns the sketch of a segment's members.
+func (r *ResilientSegmentRepository) GetSketch(ctx context.Context, segmentID int) (sketch *segment.Sketch, err error) {
+	err = r.do(ctx, retryAlways, func() (err error) {
+		sketch, err = r.Repository.GetSketch(ctx, segmentID)
+		return err
+	})
+	return sketch, err
+}
+
+// SaveSketch stores the sketch of a segment's members.
+func (r *ResilientSegmentRepository) SaveSketch(ctx context.Context, segmentID int, sketch *segment.Sketch) error {
+	return r.do(ctx, retryAlways, func() error {
+		return r.Repository.SaveSketch(ctx, segmentID, sketch)
+	})
+}
+
+// ListUnsketchedSegments returns the regular segments without a sketch.
+func (r *ResilientSegmentRepository) ListUnsketchedSegments(ctx context.Context) (ids []int, err error) {
+	err = r.do(ctx, retryAlways, func() (err error) {
+		ids, err = r.Repository.ListUnsketchedSegments(ctx)
+		return err
+	})
+	return ids, err
+}
+
+// ListWindowBoundaries returns the segments whose activation window opens or
+// closes in (after, until].
+func (r *ResilientSegmentRepository) ListWindowBoundaries(ctx context.Context, after, until time.Time) (segments []segment.Segment, err error) {
+	err = r.do(ctx, retryAlways, func() (err error) {
+		segments, err = r.Repository.ListWindowBoundaries(ctx, after, until)
+		return err
+	})
+	return segments, err
+}
+
+// RunInTransaction runs fn in a transaction of the wrapped repository. The
+// whole transaction is retried when it failed to begin, or when the database
+// rolled it back as a serialization failure or deadlock; other errors, such
+// as a connection lost while committing, leave its outcome unknown. fn works
+// on the wrapped repository directly, since a failed transaction cannot be
+// continued, and must be safe to run again.
+func (r *ResilientSegmentRepository) RunInTransaction(ctx context.Context, fn func(ctx context.Context, repo segment.Repository) error) error {
+	var err error
+	return r.do(ctx, func() bool { return isRolledBack(err) }, func() error {
+		err = r.Repository.RunInTransaction(ctx, fn)
+		return err
+	})
+}
+
+// do runs op unless the circuit breaker is open, and retries it on transient
+// errors while canRetry allows.
+func (r *ResilientSegmentRepository) do(ctx context.Context, canRetry func() bool, op func() error) error {
+	for attempt := 1; ; attempt++ {
+		probe, err := r.breaker.allow()
+		if err != nil {
+			return err
+		}
+
+		err = op()
+		var callerErr *callbackError
+		if errors.As(err, &callerErr) {
+			// The caller failed, not the database.
+			r.breaker.record(probe, nil)
+			return callerErr.err
+		}
+		r.breaker.record(probe, err)
+		if err == nil || !isTransient(err) {
+			return err
+		}
+
+		if attempt >= r.cfg.MaxAttempts || !canRetry() || !r.wait(ctx, attempt) {
+			return fmt.Errorf("%w: %w", segment.ErrUnavailable, err)
+		}
+	}
+}
+
+// wait sleeps before retry number attempt and reports whether ctx leaves
+// time to retry.
+func (r *ResilientSegmentRepository) wait(ctx context.Context, attempt int) bool {
+	delay := r.cfg.Backoff << (attempt - 1)
+	if delay <= 0 || delay > r.cfg.MaxBackoff {
+		delay = r.cfg.MaxBackoff
+	}
+	if delay > 1 {
+		delay = delay/2 + rand.N(delay/2)
+	}
+
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+		return false
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+func retryAlways() bool { return true }
+
+func retryNever() bool { return false }
+
+// stream tracks whether a streaming call reached its callback, after which
+// it cannot be retried without repeating items.
+type stream struct {
+	started bool
+}
+
+func (s *stream) unstarted() bool { return !s.started }
+
+// call runs the callback, marking errors it returns as the caller's.
+func (s *stream) call(fn func() error) error {
+	s.started = true
+	if err := fn(); err != nil {
+		return &callbackError{err: err}
+	}
+	return nil
+}
+
+// callbackError is an error returned by a caller's callback, which says
+// nothing about the health of the database.
+type callbackError struct {
+	err error
+}
+
+func (e *callbackError) Error() string { return e.err.Error() }
+
+func (e *callbackError) Unwrap() error { return e.err }
+
+// isTransient reports whether err is a failure of the database connection or
+// server that the same call may not run into again.
+func isTransient(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code.Class() {
+		case "08": // connection_exception
+			return true
+		}
+		switch pqErr.Code.Name() {
+		case "serialization_failure", "deadlock_detected",
+			"admin_shutdown", "crash_shutdown", "cannot_connect_now",
+			"too_many_connections":
+			return true
+		}
+		return false
+	}
+
+	var netErr net.Error
+	return errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.As(err, &netErr)
+}
+
+// isRolledBack reports whether err leaves a transaction without effect,
+// because it never started or the database rolled it back.
+func isRolledBack(err error) bool {
+	var beginErr *BeginError
+	if errors.As(err, &beginErr) {
+		return true
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code.Name() {
+		case "serialization_failure", "deadlock_detected":
+			return true
+		}
+	}
+	return false
+}
+
+// circuitBreaker counts consecutive transient failures. Once they reach the
+// threshold it is open for the timeout, after which a single probe call is
+// let through: its success closes the breaker, its failure opens it again.
+type circuitBreaker struct {
+	clock     clock.Clock
+	threshold int
+	timeout   time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+// allow returns ErrCircuitOpen if a call must fail fast, and otherwise
+// whether the call is the probe of an open breaker.
+func (b *circuitBreaker) allow() (probe bool, err error) {
+	if b.threshold <= 0 {
+		return false, nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.threshold {
+		return false, nil
+	}
+	if b.probing || b.clock.Now().Before(b.openUntil) {
+		return false, ErrCircuitOpen
+	}
+	b.probing = true
+	return true, nil
+}
+
+// record counts the outcome of a call allowed by allow.
+func (b *circuitBreaker) record(probe bool, err error) {
+	if b.threshold <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if probe {
+		b.probing = false
+	}
+	if err == nil || !isTransient(err) {
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.failures >= b.threshold && (probe || b.failures == b.threshold) {
+		b.openUntil = b.clock.Now().Add(b.timeout)
+	}
+}
+
+// ready returns ErrCircuitOpen while the breaker is open and not yet due
+// for a probe.
+func (b *circuitBreaker) ready() error {
+	if b.threshold <= 0 {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures >= b.threshold && b.clock.Now().Before(b.openUntil) {
+		return ErrCircuitOpen
+	}
+	return nil
+}
@@ -0,0 +1,294 @@
+package adapters_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/rickKoch/nexus/internal/segments/adapters"
+	"github.com/rickKoch/nexus/internal/segments/domain/segment"
+	"github.com/rickKoch/nexus/internal/segments/domain/segment/segmenttest"
+	"github.com/rickKoch/nexus/pkg/clock"
+)
+
+// flakyRepository fails the calls reaching the wrapped repository with the
+// errors in errs, one per call, before letting them through.
+type flakyRepository struct {
+	segment.Repository
+	errs  []error
+	calls int
+}
+
+func (r *flakyRepository) fail() error {
+	r.calls++
+	if len(r.errs) == 0 {
+		return nil
+	}
+	err := r.errs[0]
+	r.errs = r.errs[1:]
+	return err
+}
+
+func (r *flakyRepository) Get(ctx context.Context, id int) (*segment.Segment, error) {
+	if err := r.fail(); err != nil {
+		return nil, err
+	}
+	return r.Repository.Get(ctx, id)
+}
+
+func (r *flakyRepository) Create(ctx context.Context, s *segment.Segment) (*segment.Segment, error) {
+	if err := r.fail(); err != nil {
+		return nil, err
+	}
+	return r.Repository.Create(ctx, s)
+}
+
+// RunInTransaction fails before running fn on a BeginError, and otherwise
+// after committing, like a failed commit.
+func (r *flakyRepository) RunInTransaction(ctx context.Context, fn func(ctx context.Context, repo segment.Repository) error) error {
+	err := r.fail()
+	var beginErr *adapters.BeginError
+	if errors.As(err, &beginErr) {
+		return err
+	}
+	if txErr := r.Repository.RunInTransaction(ctx, fn); txErr != nil {
+		return txErr
+	}
+	return err
+}
+
+// StreamMemberships fails after streaming the memberships.
+func (r *flakyRepository) StreamMemberships(ctx context.Context, segmentID int, fn func(m segment.Member) error) error {
+	if err := r.Repository.StreamMemberships(ctx, segmentID, fn); err != nil {
+		return err
+	}
+	return r.fail()
+}
+
+func TestResilientSegmentRepository(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 11, 1, 12, 30, 0, 0, time.UTC)
+	factory, _ := segment.NewFactory(segment.SegmentConfig{Name: "premium-users"})
+	cfg := adapters.ResilienceConfig{
+		MaxAttempts:      3,
+		Backoff:          time.Millisecond,
+		MaxBackoff:       time.Millisecond,
+		BreakerThreshold: 3,
+		BreakerTimeout:   10 * time.Second,
+	}
+	errReset := &pq.Error{Code: "08006", Message: "connection failure"}
+	errShutdown := &pq.Error{Code: "57P01", Message: "terminating connection due to administrator command"}
+	errSerialization := &pq.Error{Code: "40001", Message: "could not serialize access"}
+
+	setup := func(t *testing.T, cfg adapters.ResilienceConfig, errs ...error) (*flakyRepository, *adapters.ResilientSegmentRepository, *clock.Fake) {
+		t.Helper()
+
+		inner := adapters.NewInMemorySegmentRepository()
+		if _, err := inner.Create(ctx, factory.NewSegment(1, now)); err != nil {
+			t.Fatalf("failed to create segment: %v", err)
+		}
+		flaky := &flakyRepository{Repository: inner, errs: errs}
+		clk := clock.NewFake(now)
+		return flaky, adapters.NewResilientSegmentRepository(flaky, inner, clk, cfg), clk
+	}
+
+	segmenttest.RunRepositoryTests(t, func(t *testing.T) segmenttest.Repository {
+		inner := adapters.NewInMemorySegmentRepository()
+		return adapters.NewResilientSegmentRepository(inner, inner, clock.System, cfg)
+	})
+
+	t.Run("retries transient errors", func(t *testing.T) {
+		flaky, repo, _ := setup(t, cfg, errShutdown, errSerialization)
+
+		if _, err := repo.Get(ctx, 1); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if flaky.calls != 3 {
+			t.Errorf("expected 3 attempts, got %d", flaky.calls)
+		}
+	})
+
+	t.Run("reports persistent transient errors as unavailable", func(t *testing.T) {
+		flaky, repo, _ := setup(t, cfg, errReset, syscall.ECONNRESET, errReset, errReset)
+
+		_, err := repo.Get(ctx, 1)
+		if !errors.Is(err, segment.ErrUnavailable) || !errors.Is(err, errReset) {
+			t.Errorf("expected the connection error as unavailable, got %v", err)
+		}
+		if flaky.calls != cfg.MaxAttempts {
+			t.Errorf("expected %d attempts, got %d", cfg.MaxAttempts, flaky.calls)
+		}
+	})
+
+	t.Run("does not retry other errors", func(t *testing.T) {
+		errConstraint := &pq.Error{Code: "23505", Message: "duplicate key value"}
+		flaky, repo, _ := setup(t, cfg, errConstraint)
+
+		if _, err := repo.Get(ctx, 1); !errors.Is(err, errConstraint) || errors.Is(err, segment.ErrUnavailable) {
+			t.Errorf("expected the constraint error, got %v", err)
+		}
+		if _, err := repo.Get(ctx, 999); !errors.Is(err, segment.ErrSegmentNotFound) {
+			t.Errorf("expected %v, got %v", segment.ErrSegmentNotFound, err)
+		}
+		if flaky.calls != 2 {
+			t.Errorf("expected 2 attempts, got %d", flaky.calls)
+		}
+	})
+
+	t.Run("does not retry creates", func(t *testing.T) {
+		flaky, repo, _ := setup(t, cfg, errReset)
+
+		if _, err := repo.Create(ctx, factory.NewSegment(2, now)); !errors.Is(err, segment.ErrUnavailable) {
+			t.Errorf("expected unavailable, got %v", err)
+		}
+		if flaky.calls != 1 {
+			t.Errorf("expected 1 attempt, got %d", flaky.calls)
+		}
+	})
+
+	t.Run("retries transactions that did not take effect", func(t *testing.T) {
+		errDeadlock := &pq.Error{Code: "40P01", Message: "deadlock detected"}
+		flaky, repo, _ := setup(t, cfg, &adapters.BeginError{Err: errReset}, errDeadlock)
+
+		runs := 0
+		err := repo.RunInTransaction(ctx, func(ctx context.Context, tx segment.Repository) error {
+			runs++
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if flaky.calls != 3 || runs != 2 {
+			t.Errorf("expected 3 attempts running the transaction twice, got %d attempts and %d runs", flaky.calls, runs)
+		}
+	})
+
+	t.Run("does not retry transactions losing the connection while committing", func(t *testing.T) {
+		errCommit := fmt.Errorf("failed to commit transaction: %w", errReset)
+		flaky, repo, _ := setup(t, cfg, errCommit)
+
+		runs := 0
+		err := repo.RunInTransaction(ctx, func(ctx context.Context, tx segment.Repository) error {
+			runs++
+			return nil
+		})
+		if !errors.Is(err, segment.ErrUnavailable) || !errors.Is(err, errReset) {
+			t.Errorf("expected the connection error as unavailable, got %v", err)
+		}
+		if flaky.calls != 1 || runs != 1 {
+			t.Errorf("expected a single attempt, got %d attempts and %d runs", flaky.calls, runs)
+		}
+	})
+
+	t.Run("does not retry streams after the first item", func(t *testing.T) {
+		flaky, repo, _ := setup(t, cfg)
+		s, _ := repo.Get(ctx, 1)
+		m, _ := s.NewMember("user-1", now)
+		if err := repo.AddMembers(ctx, 1, []segment.Member{m}); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		flaky.errs, flaky.calls = []error{errReset}, 0
+
+		var streamed int
+		err := repo.StreamMemberships(ctx, 1, func(segment.Member) error {
+			streamed++
+			return nil
+		})
+		if !errors.Is(err, segment.ErrUnavailable) || streamed != 1 || flaky.calls != 1 {
+			t.Errorf("expected 1 member streamed once and unavailable, got %d members in %d attempts and %v", streamed, flaky.calls, err)
+		}
+	})
+
+	t.Run("returns callback errors unchanged", func(t *testing.T) {
+		_, repo, _ := setup(t, cfg)
+		s, _ := repo.Get(ctx, 1)
+		m, _ := s.NewMember("user-1", now)
+		if err := repo.AddMembers(ctx, 1, []segment.Member{m}); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		// A client hanging up is not a database failure.
+		err := repo.StreamMemberships(ctx, 1, func(segment.Member) error { return syscall.EPIPE })
+		if !errors.Is(err, syscall.EPIPE) || errors.Is(err, segment.ErrUnavailable) {
+			t.Errorf("expected the callback error, got %v", err)
+		}
+	})
+
+	t.Run("gives up when the context is done", func(t *testing.T) {
+		cfg := cfg
+		cfg.Backoff, cfg.MaxBackoff = time.Hour, time.Hour
+		flaky, repo, _ := setup(t, cfg, errReset, errReset)
+
+		ctx, cancel := context.WithTimeout(ctx, time.Second)
+		defer cancel()
+		if _, err := repo.Get(ctx, 1); !errors.Is(err, segment.ErrUnavailable) {
+			t.Errorf("expected unavailable, got %v", err)
+		}
+		if flaky.calls != 1 {
+			t.Errorf("expected no retry past the deadline, got %d attempts", flaky.calls)
+		}
+	})
+
+	t.Run("opens the circuit breaker", func(t *testing.T) {
+		cfg := cfg
+		cfg.MaxAttempts = 1
+		errs := make([]error, cfg.BreakerThreshold+1)
+		for i := range errs {
+			errs[i] = errReset
+		}
+		flaky, repo, clk := setup(t, cfg, errs...)
+
+		for range cfg.BreakerThreshold {
+			_, _ = repo.Get(ctx, 1)
+		}
+		if _, err := repo.Get(ctx, 1); !errors.Is(err, adapters.ErrCircuitOpen) || !errors.Is(err, segment.ErrUnavailable) {
+			t.Errorf("expected the open circuit, got %v", err)
+		}
+		if err := repo.Ready(); !errors.Is(err, adapters.ErrCircuitOpen) {
+			t.Errorf("expected not ready, got %v", err)
+		}
+		if flaky.calls != cfg.BreakerThreshold {
+			t.Errorf("expected the open circuit to fail fast, got %d calls", flaky.calls)
+		}
+
+		// A failed probe opens the breaker again.
+		clk.Advance(cfg.BreakerTimeout)
+		if err := repo.Ready(); err != nil {
+			t.Errorf("expected ready to probe, got %v", err)
+		}
+		if _, err := repo.Get(ctx, 1); errors.Is(err, adapters.ErrCircuitOpen) {
+			t.Errorf("expected the probe to reach the database, got %v", err)
+		}
+		if _, err := repo.Get(ctx, 1); !errors.Is(err, adapters.ErrCircuitOpen) {
+			t.Errorf("expected the open circuit after a failed probe, got %v", err)
+		}
+
+		// A successful probe closes it.
+		clk.Advance(cfg.BreakerTimeout)
+		for range 2 {
+			if _, err := repo.Get(ctx, 1); err != nil {
+				t.Errorf("expected no error, got %v", err)
+			}
+		}
+		if err := repo.Ready(); err != nil {
+			t.Errorf("expected ready, got %v", err)
+		}
+	})
+
+	t.Run("disables the circuit breaker", func(t *testing.T) {
+		cfg := cfg
+		cfg.MaxAttempts, cfg.BreakerThreshold = 1, 0
+		flaky, repo, _ := setup(t, cfg, errReset, errReset, errReset, errReset)
+
+		for range 5 {
+			_, _ = repo.Get(ctx, 1)
+		}
+		if flaky.calls != 5 || repo.Ready() != nil {
+			t.Errorf("expected every call to reach the database, got %d calls", flaky.calls)
+		}
+	})
+}
@@ -0,0 +1,959 @@
+package adapters
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rickKoch/nexus/internal/segments/domain/segment"
+)
+
+// sqliteTimeLayout stores timestamps as fixed-width UTC text, which compares
+// chronologically as a string. Like PostgreSQL, it keeps microseconds.
+const sqliteTimeLayout = "2006-01-02 15:04:05.000000"
+
+// sqliteTime is a timestamp stored as text.
+type sqliteTime struct {
+	time.Time
+}
+
+// newSQLiteTime returns the stored form of an optional timestamp.
+func newSQLiteTime(t *time.Time) *sqliteTime {
+	if t == nil {
+		return nil
+	}
+	return &sqliteTime{*t}
+}
+
+// timePtr returns the optional timestamp t stores.
+func (t *sqliteTime) timePtr() *time.Time {
+	if t == nil {
+		return nil
+	}
+	v := t.Time
+	return &v
+}
+
+// Value implements driver.Valuer.
+func (t sqliteTime) Value() (driver.Value, error) {
+	return t.UTC().Format(sqliteTimeLayout), nil
+}
+
+// Scan implements sql.Scanner.
+func (t *sqliteTime) Scan(src interface{}) error {
+	var text string
+	switch v := src.(type) {
+	case string:
+		text = v
+	case []byte:
+		text = string(v)
+	default:
+		return fmt.Errorf("cannot scan %T into timestamp", src)
+	}
+
+	parsed, err := time.Parse(sqliteTimeLayout, text)
+	if err != nil {
+		return err
+	}
+	t.Time = parsed
+	return nil
+}
+
+// sqliteSegmentColumns are the columns segments are read from.
+const sqliteSegmentColumns = `id, name, description, labels, ttl_seconds, active_from, active_until, expression, state, member_count, created_at, updated_at, deleted_at`
+
+// sqliteSegmentRow represents a database row for a segment.
+type sqliteSegmentRow struct {
+	ID          int            `db:"id"`
+	Name        string         `db:"name"`
+	Description string         `db:"description"`
+	Labels      jsonLabels     `db:"labels"`
+	TTLSeconds  *int           `db:"ttl_seconds"`
+	ActiveFrom  *sqliteTime    `db:"active_from"`
+	ActiveUntil *sqliteTime    `db:"active_until"`
+	Expression  textExpression `db:"expression"`
+	State       string         `db:"state"`
+	MemberCount int            `db:"member_count"`
+	CreatedAt   sqliteTime     `db:"created_at"`
+	UpdatedAt   sqliteTime     `db:"updated_at"`
+	DeletedAt   *sqliteTime    `db:"deleted_at"`
+}
+
+func (row sqliteSegmentRow) toSegment() *segment.Segment {
+	return segment.UnmarshalSegmentFromDatabase(
+		row.ID, row.Name, row.Description, segment.Labels(row.Labels), row.TTLSeconds,
+		row.ActiveFrom.timePtr(), row.ActiveUntil.timePtr(), row.Expression.Expression, segment.State(row.State), row.MemberCount,
+		row.CreatedAt.Time, row.UpdatedAt.Time, row.DeletedAt.timePtr(),
+	)
+}
+
+func toSQLiteSegments(rows []sqliteSegmentRow) []segment.Segment {
+	segments := make([]segment.Segment, 0, len(rows))
+	for _, row := range rows {
+		segments = append(segments, *row.toSegment())
+	}
+	return segments
+}
+
+// sqliteJSON encodes a value as JSON text. SQLite has no arrays, so lists are
+// passed as a single JSON parameter and expanded with json_each.
+func sqliteJSON(v any) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// sqliteReferencedIDs returns the IDs referenced by s's expression as a JSON
+// array.
+func sqliteReferencedIDs(s *segment.Segment) (string, error) {
+	ids := []int{}
+	if s.Expression() != nil {
+		ids = s.Expression().SegmentIDs()
+	}
+	return sqliteJSON(ids)
+}
+
+// SQLiteSegmentRepository is a SQLite implementation of segment.Repository,
+// for single-node deployments and tests. It keeps the same schema and
+// semantics as PostgreSQLSegmentRepository.
+type SQLiteSegmentRepository struct {
+	db *sqlx.DB
+	// q runs the queries: db itself, or the transaction the repository is bound to.
+	q  sqlx.ExtContext
+	tx *sqlx.Tx
+}
+
+// sqliteTxKey is the context key of the transaction RunInTransaction runs
+// on the database db.
+type sqliteTxKey struct {
+	db *sqlx.DB
+}
+
+// NewSQLiteSegmentRepository creates a new SQLite segment repository.
+func NewSQLiteSegmentRepository(db *sqlx.DB) *SQLiteSegmentRepository {
+	return &SQLiteSegmentRepository{
+		db: db,
+		q:  db,
+	}
+}
+
+// NextID increments the segment ID counter. Within RunInTransaction, the
+// counter is incremented by the transaction, since a write outside of it
+// would wait for the write lock the transaction holds.
+func (r *SQLiteSegmentRepository) NextID(ctx context.Context) (int, error) {
+	q := r.q
+	if tx, ok := ctx.Value(sqliteTxKey{r.db}).(*sqlx.Tx); ok && r.tx == nil {
+		q = tx
+	}
+
+	var id int
+	if err := sqlx.GetContext(ctx, q, &id, `UPDATE segment_id_sequence SET last_value = last_value + 1 RETURNING last_value`); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// List returns paginated non-deleted segments.
+func (r *SQLiteSegmentRepository) List(ctx context.Context, params segment.ListParams) (*segment.ListResult, error) {
+	var filter strings.Builder
+	filter.WriteString(` FROM segments WHERE deleted_at IS NULL`)
+
+	var args []interface{}
+	for _, key := range slices.Sorted(maps.Keys(params.Labels)) {
+		filter.WriteString(` AND EXISTS (SELECT 1 FROM json_each(labels) WHERE key = ? AND value = ?)`)
+		args = append(args, key, params.Labels[key])
+	}
+	if len(params.States) > 0 {
+		states, err := sqliteJSON(params.States)
+		if err != nil {
+			return nil, err
+		}
+		filter.WriteString(` AND state IN (SELECT value FROM json_each(?))`)
+		args = append(args, states)
+	}
+	if params.Live != nil {
+		filter.WriteString(` AND (state = 'active' AND (active_from IS NULL OR active_from <= ?) AND (active_until IS NULL OR active_until > ?)) = ?`)
+		args = append(args, sqliteTime{params.At}, sqliteTime{params.At}, *params.Live)
+	}
+
+	query := `SELECT ` + sqliteSegmentColumns + `, COUNT(*) OVER() AS total_count` + filter.String() + ` ORDER BY id LIMIT ? OFFSET ?`
+	offset := (params.Page - 1) * params.PageSize
+
+	var rows []struct {
+		sqliteSegmentRow
+		TotalCount int `db:"total_count"`
+	}
+	if err := sqlx.SelectContext(ctx, r.q, &rows, query, append(args, params.PageSize, offset)...); err != nil {
+		return nil, err
+	}
+
+	var totalCount int
+	if len(rows) > 0 {
+		totalCount = rows[0].TotalCount
+	} else if offset > 0 {
+		// Past the last page, no row carries the total.
+		if err := sqlx.GetContext(ctx, r.q, &totalCount, `SELECT count(*)`+filter.String(), args...); err != nil {
+			return nil, err
+		}
+	}
+
+	segments := make([]segment.Segment, 0, len(rows))
+	for _, row := range rows {
+		segments = append(segments, *row.sqliteSegmentRow.toSegment())
+	}
+
+	return &segment.ListResult{
+		Segments:   segments,
+		TotalCount: totalCount,
+		Page:       params.Page,
+		PageSize:   params.PageSize,
+	}, nil
+}
+
+// GetShared returns a segment by ID. Transactions hold the database's write
+// lock, so it locks no more than Get does.
+func (r *SQLiteSegmentRepository) GetShared(ctx context.Context, id int) (*segment.Segment, error) {
+	return r.Get(ctx, id)
+}
+
+// Get returns a segment by ID.
+func (r *SQLiteSegmentRepository) Get(ctx context.Context, id int) (*segment.Segment, error) {
+	query := `SELECT ` + sqliteSegmentColumns + ` FROM segments WHERE id = ? AND deleted_at IS NULL`
+
+	var row sqliteSegmentRow
+	if err := sqlx.GetContext(ctx, r.q, &row, query, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrSegmentNotFound
+		}
+		return nil, err
+	}
+
+	return row.toSegment(), nil
+}
+
+// Create stores a new segment and its first revision, and records its ID as
+// handed out.
+func (r *SQLiteSegmentRepository) Create(ctx context.Context, s *segment.Segment) (*segment.Segment, error) {
+	query := `
+		INSERT INTO segments (id, name, description, labels, ttl_seconds, active_from, active_until, expression, referenced_ids, state, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		RETURNING ` + sqliteSegmentColumns
+
+	refs, err := sqliteReferencedIDs(s)
+	if err != nil {
+		return nil, err
+	}
+
+	var row sqliteSegmentRow
+	err = r.inTransaction(ctx, func(tx *SQLiteSegmentRepository) error {
+		err := sqlx.GetContext(ctx, tx.q, &row, query,
+			s.ID(), s.Name(), s.Description(), jsonLabels(s.Labels()), s.TTLSeconds(),
+			newSQLiteTime(s.ActiveFrom()), newSQLiteTime(s.ActiveUntil()), textExpression{s.Expression()}, refs,
+			string(s.State()), sqliteTime{s.CreatedAt()}, sqliteTime{s.UpdatedAt()},
+		)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.q.ExecContext(ctx, `UPDATE segment_id_sequence SET last_value = max(last_value, ?)`, s.ID())
+		if err != nil {
+			return err
+		}
+		return tx.saveRevision(ctx, s.ID())
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return row.toSegment(), nil
+}
+
+// Update updates an existing segment and saves it as its next revision.
+func (r *SQLiteSegmentRepository) Update(ctx context.Context, s *segment.Segment) (*segment.Segment, error) {
+	query := `
+		UPDATE segments
+		SET name = ?, description = ?, labels = ?, ttl_seconds = ?, active_from = ?, active_until = ?,
+		    expression = ?, referenced_ids = ?, state = ?, updated_at = ?, version = version + 1
+		WHERE id = ? AND deleted_at IS NULL
+		RETURNING ` + sqliteSegmentColumns
+
+	refs, err := sqliteReferencedIDs(s)
+	if err != nil {
+		return nil, err
+	}
+
+	var row sqliteSegmentRow
+	err = r.inTransaction(ctx, func(tx *SQLiteSegmentRepository) error {
+		err := sqlx.GetContext(ctx, tx.q, &row, query,
+			s.Name(), s.Description(), jsonLabels(s.Labels()), s.TTLSeconds(),
+			newSQLiteTime(s.ActiveFrom()), newSQLiteTime(s.ActiveUntil()), textExpression{s.Expression()}, refs,
+			string(s.State()), sqliteTime{s.UpdatedAt()}, s.ID(),
+		)
+		if err != nil {
+			return err
+		}
+		return tx.saveRevision(ctx, s.ID())
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrSegmentNotFound
+		}
+		return nil, err
+	}
+
+	return row.toSegment(), nil
+}
+
+// saveRevision saves the segment with the given ID as stored as the revision
+// of its current version.
+func (r *SQLiteSegmentRepository) saveRevision(ctx context.Context, id int) error {
+	query := `
+		INSERT INTO segment_revisions (segment_id, version, name, description, labels, ttl_seconds, active_from, active_until, expression, state, saved_at)
+		SELECT id, version, name, description, labels, ttl_seconds, active_from, active_until, expression, state, updated_at
+		FROM segments
+		WHERE id = ?
+	`
+	_, err := r.q.ExecContext(ctx, query, id)
+	return err
+}
+
+// sqliteRevisionColumns are the columns revisions are read from, joined with
+// the segments they belong to.
+const sqliteRevisionColumns = `r.segment_id AS id, r.version, r.name, r.description, r.labels, r.ttl_seconds, r.active_from, r.active_until, r.expression, r.state, s.created_at, r.saved_at AS updated_at`
+
+// sqliteRevisionRow represents a database row for a revision.
+type sqliteRevisionRow struct {
+	Version int `db:"version"`
+	sqliteSegmentRow
+}
+
+// ListRevisions returns the revisions of a segment, oldest first.
+func (r *SQLiteSegmentRepository) ListRevisions(ctx context.Context, segmentID int) ([]segment.Revision, error) {
+	query := `
+		SELECT ` + sqliteRevisionColumns + `
+		FROM segment_revisions r
+		JOIN segments s ON s.id = r.segment_id
+		WHERE r.segment_id = ? AND s.deleted_at IS NULL
+		ORDER BY r.version
+	`
+
+	var rows []sqliteRevisionRow
+	if err := sqlx.SelectContext(ctx, r.q, &rows, query, segmentID); err != nil {
+		return nil, err
+	}
+	// Every stored segment has a revision.
+	if len(rows) == 0 {
+		return nil, ErrSegmentNotFound
+	}
+
+	revisions := make([]segment.Revision, 0, len(rows))
+	for _, row := range rows {
+		revisions = append(revisions, segment.Revision{Version: row.Version, Segment: row.toSegment()})
+	}
+	return revisions, nil
+}
+
+// GetRevision returns a revision of a segment.
+func (r *SQLiteSegmentRepository) GetRevision(ctx context.Context, segmentID, version int) (*segment.Revision, error) {
+	query := `
+		SELECT ` + sqliteRevisionColumns + `
+		FROM segment_revisions r
+		JOIN segments s ON s.id = r.segment_id
+		WHERE r.segment_id = ? AND r.version = ? AND s.deleted_at IS NULL
+	`
+
+	var row sqliteRevisionRow
+	if err := sqlx.GetContext(ctx, r.q, &row, query, segmentID, version); err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		if _, err := r.Get(ctx, segmentID); err != nil {
+			return nil, err
+		}
+		return nil, segment.ErrRevisionNotFound
+	}
+	return &segment.Revision{Version: row.Version, Segment: row.toSegment()}, nil
+}
+
+// Delete stores the deletion of a segment.
+func (r *SQLiteSegmentRepository) Delete(ctx context.Context, s *segment.Segment) error {
+	if !s.IsDeleted() {
+		return fmt.Errorf("segment '%d' is not marked as deleted", s.ID())
+	}
+
+	query := `
+		UPDATE segments
+		SET deleted_at = ?, updated_at = ?
+		WHERE id = ? AND deleted_at IS NULL
+	`
+
+	result, err := r.q.ExecContext(ctx, query, newSQLiteTime(s.DeletedAt()), sqliteTime{s.UpdatedAt()}, s.ID())
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrSegmentNotFound
+	}
+
+	return nil
+}
+
+// sqliteMember is a membership passed to SQLite as a JSON object.
+type sqliteMember struct {
+	ID        string      `json:"id"`
+	AddedAt   sqliteTime  `json:"added_at"`
+	ExpiresAt *sqliteTime `json:"expires_at"`
+}
+
+// MarshalJSON encodes the timestamp in its stored form.
+func (t sqliteTime) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.UTC().Format(sqliteTimeLayout))
+}
+
+// AddMembers counts the subjects that are not members yet and then upserts
+// the memberships of a segment.
+func (r *SQLiteSegmentRepository) AddMembers(ctx context.Context, segmentID int, members []segment.Member) error {
+	rows := make([]sqliteMember, 0, len(members))
+	ids := make([]string, 0, len(members))
+	for _, m := range members {
+		rows = append(rows, sqliteMember{ID: m.ID, AddedAt: sqliteTime{m.AddedAt}, ExpiresAt: newSQLiteTime(m.ExpiresAt)})
+		ids = append(ids, m.ID)
+	}
+	membersJSON, err := sqliteJSON(rows)
+	if err != nil {
+		return err
+	}
+	idsJSON, err := sqliteJSON(ids)
+	if err != nil {
+		return err
+	}
+
+	return r.inTransaction(ctx, func(tx *SQLiteSegmentRepository) error {
+		var existing int
+		query := `
+			SELECT count(*) FROM segment_members
+			WHERE segment_id = ? AND member_id IN (SELECT value FROM json_each(?))
+		`
+		if err := sqlx.GetContext(ctx, tx.q, &existing, query, segmentID, idsJSON); err != nil {
+			return err
+		}
+
+		// The WHERE clause tells the parser the ON CONFLICT belongs to the
+		// INSERT rather than to a join.
+		query = `
+			INSERT INTO segment_members (segment_id, member_id, added_at, expires_at)
+			SELECT ?, value ->> 'id', value ->> 'added_at', value ->> 'expires_at'
+			FROM json_each(?) WHERE true
+			ON CONFLICT (segment_id, member_id)
+			DO UPDATE SET added_at = excluded.added_at, expires_at = excluded.expires_at
+		`
+		if _, err := tx.q.ExecContext(ctx, query, segmentID, membersJSON); err != nil {
+			return err
+		}
+
+		_, err := tx.q.ExecContext(ctx, `UPDATE segments SET member_count = member_count + ? WHERE id = ?`, len(members)-existing, segmentID)
+		return err
+	})
+}
+
+// RemoveMembers deletes memberships of a segment, uncounts them and
+// discards the segment's sketch if any was removed.
+func (r *SQLiteSegmentRepository) RemoveMembers(ctx context.Context, segmentID int, memberIDs []string) error {
+	idsJSON, err := sqliteJSON(memberIDs)
+	if err != nil {
+		return err
+	}
+
+	return r.inTransaction(ctx, func(tx *SQLiteSegmentRepository) error {
+		query := `DELETE FROM segment_members WHERE segment_id = ? AND member_id IN (SELECT value FROM json_each(?))`
+		result, err := tx.q.ExecContext(ctx, query, segmentID, idsJSON)
+		if err != nil {
+			return err
+		}
+		removed, err := result.RowsAffected()
+		if err != nil || removed == 0 {
+			return err
+		}
+
+		_, err = tx.q.ExecContext(ctx, `UPDATE segments SET member_count = member_count - ?, sketch = NULL WHERE id = ?`, removed, segmentID)
+		return err
+	})
+}
+
+// PurgeExpiredMembers uncounts expired memberships per segment, discards the
+// sketches of the affected segments and then deletes the memberships.
+func (r *SQLiteSegmentRepository) PurgeExpiredMembers(ctx context.Context, at time.Time) (int, error) {
+	var purged int64
+	err := r.inTransaction(ctx, func(tx *SQLiteSegmentRepository) error {
+		query := `
+			UPDATE segments
+			SET member_count = member_count - (
+			      SELECT count(*) FROM segment_members
+			      WHERE segment_id = segments.id AND expires_at <= ?1
+			    ),
+			    sketch = NULL
+			WHERE id IN (SELECT segment_id FROM segment_members WHERE expires_at <= ?1)
+		`
+		if _, err := tx.q.ExecContext(ctx, query, sqliteTime{at}); err != nil {
+			return err
+		}
+
+		result, err := tx.q.ExecContext(ctx, `DELETE FROM segment_members WHERE expires_at <= ?`, sqliteTime{at})
+		if err != nil {
+			return err
+		}
+		purged, err = result.RowsAffected()
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int(purged), nil
+}
+
+// RecordSizeSnapshots copies the member counts of all regular segments into
+// the size history.
+func (r *SQLiteSegmentRepository) RecordSizeSnapshots(ctx context.Context, day time.Time) error {
+	query := `
+		INSERT INTO segment_size_history (segment_id, day, member_count)
+		SELECT id, ?, member_count
+		FROM segments
+		WHERE deleted_at IS NULL AND expression IS NULL
+		ON CONFLICT (segment_id, day) DO UPDATE SET member_count = excluded.member_count
+	`
+
+	_, err := r.q.ExecContext(ctx, query, segment.Day(day).Format(time.DateOnly))
+	return err
+}
+
+// ListSizeHistory returns the size snapshots of a segment between from and
+// to inclusive.
+func (r *SQLiteSegmentRepository) ListSizeHistory(ctx context.Context, segmentID int, from, to time.Time) ([]segment.SizeSnapshot, error) {
+	query := `
+		SELECT segment_id, day, member_count
+		FROM segment_size_history
+		WHERE segment_id = ? AND day BETWEEN ? AND ?
+		ORDER BY day
+	`
+
+	var rows []struct {
+		SegmentID   int    `db:"segment_id"`
+		Day         string `db:"day"`
+		MemberCount int    `db:"member_count"`
+	}
+	err := sqlx.SelectContext(ctx, r.q, &rows, query,
+		segmentID, segment.Day(from).Format(time.DateOnly), segment.Day(to).Format(time.DateOnly))
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := make([]segment.SizeSnapshot, 0, len(rows))
+	for _, row := range rows {
+		day, err := time.Parse(time.DateOnly, row.Day)
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, segment.SizeSnapshot{
+			SegmentID:   row.SegmentID,
+			Day:         day,
+			MemberCount: row.MemberCount,
+		})
+	}
+	return snapshots, nil
+}
+
+// GetSketch reads the sketch of a segment's members. Transactions take the
+// database's write lock when they begin, so within one the sketch is locked
+// against concurrent membership writes already.
+func (r *SQLiteSegmentRepository) GetSketch(ctx context.Context, segmentID int) (*segment.Sketch, error) {
+	query := `SELECT sketch FROM segments WHERE id = ? AND deleted_at IS NULL`
+
+	var data []byte
+	if err := sqlx.GetContext(ctx, r.q, &data, query, segmentID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrSegmentNotFound
+		}
+		return nil, err
+	}
+	if data == nil {
+		return nil, nil
+	}
+
+	sketch := &segment.Sketch{}
+	if err := sketch.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	return sketch, nil
+}
+
+// SaveSketch stores the sketch of a segment's members.
+func (r *SQLiteSegmentRepository) SaveSketch(ctx context.Context, segmentID int, sketch *segment.Sketch) error {
+	data, err := sketch.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	result, err := r.q.ExecContext(ctx, `UPDATE segments SET sketch = ? WHERE id = ? AND deleted_at IS NULL`, data, segmentID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrSegmentNotFound
+	}
+
+	return nil
+}
+
+// ListUnsketchedSegments returns the regular segments without a sketch.
+func (r *SQLiteSegmentRepository) ListUnsketchedSegments(ctx context.Context) ([]int, error) {
+	query := `
+		SELECT id FROM segments
+		WHERE deleted_at IS NULL AND expression IS NULL AND sketch IS NULL
+		ORDER BY id
+	`
+
+	ids := []int{}
+	if err := sqlx.SelectContext(ctx, r.q, &ids, query); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// StreamMembers reads the selected subjects a page at a time, each page
+// resuming after the last member ID of the previous one, so neither memory
+// use nor the time a connection is held grows with the size of the segment.
+// Outside a transaction, pages are read at different times and may observe
+// concurrent writes.
+func (r *SQLiteSegmentRepository) StreamMembers(ctx context.Context, q segment.MemberQuery, fn func(memberID string) error) error {
+	after := q.After
+	for {
+		query, args, err := sqliteMemberSelectQuery(q, after)
+		if err != nil {
+			return err
+		}
+
+		var ids []string
+		if err := sqlx.SelectContext(ctx, r.q, &ids, query, args...); err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if err := fn(id); err != nil {
+				return err
+			}
+		}
+		if len(ids) < memberFetchSize {
+			return nil
+		}
+		after = ids[len(ids)-1]
+	}
+}
+
+// StreamMemberships reads the memberships of a segment a page at a time,
+// like StreamMembers.
+func (r *SQLiteSegmentRepository) StreamMemberships(ctx context.Context, segmentID int, fn func(m segment.Member) error) error {
+	query := `
+		SELECT member_id, added_at, expires_at FROM segment_members
+		WHERE segment_id = ? AND member_id > ?
+		ORDER BY member_id
+		LIMIT ?
+	`
+
+	after := ""
+	for {
+		var rows []struct {
+			MemberID  string      `db:"member_id"`
+			AddedAt   sqliteTime  `db:"added_at"`
+			ExpiresAt *sqliteTime `db:"expires_at"`
+		}
+		if err := sqlx.SelectContext(ctx, r.q, &rows, query, segmentID, after, memberFetchSize); err != nil {
+			return err
+		}
+		for _, row := range rows {
+			m := segment.Member{ID: row.MemberID, AddedAt: row.AddedAt.Time, ExpiresAt: row.ExpiresAt.timePtr()}
+			if err := fn(m); err != nil {
+				return err
+			}
+		}
+		if len(rows) < memberFetchSize {
+			return nil
+		}
+		after = rows[len(rows)-1].MemberID
+	}
+}
+
+// ListMemberships looks the subject up in the member index of each segment.
+func (r *SQLiteSegmentRepository) ListMemberships(ctx context.Context, memberID string, segmentIDs []int, at time.Time) ([]int, error) {
+	query := `
+		SELECT segment_id FROM segment_members
+		WHERE member_id = ? AND segment_id IN (SELECT value FROM json_each(?))
+		  AND (expires_at IS NULL OR expires_at > ?)
+		ORDER BY segment_id
+	`
+
+	refs, err := sqliteJSON(segmentIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []int{}
+	if err := sqlx.SelectContext(ctx, r.q, &ids, query, memberID, refs, sqliteTime{at}); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// sqliteMemberSelectQuery builds the query selecting a page of the subjects
+// of q with an ID greater than after, like memberSelectQuery.
+func sqliteMemberSelectQuery(q segment.MemberQuery, after string) (string, []interface{}, error) {
+	if q.Expression.Op == segment.OpSegment {
+		query := `
+			SELECT member_id FROM segment_members
+			WHERE segment_id = ? AND (expires_at IS NULL OR expires_at > ?) AND member_id > ?
+			ORDER BY member_id
+			LIMIT ?
+		`
+		return query, []interface{}{q.Expression.SegmentID, sqliteTime{q.At}, after, memberFetchSize}, nil
+	}
+
+	refs, err := sqliteJSON(q.Expression.SegmentIDs())
+	if err != nil {
+		return "", nil, err
+	}
+	query := `
+		SELECT member_id FROM segment_members
+		WHERE segment_id IN (SELECT value FROM json_each(?)) AND (expires_at IS NULL OR expires_at > ?) AND member_id > ?
+		GROUP BY member_id
+		HAVING ` + sqliteMemberPredicate(q.Expression) + `
+		ORDER BY member_id
+		LIMIT ?
+	`
+	return query, []interface{}{refs, sqliteTime{q.At}, after, memberFetchSize}, nil
+}
+
+// sqliteMemberPredicate compiles an expression to an aggregate condition over
+// the memberships of one subject, like memberPredicate. SQLite has no
+// bool_or, but comparisons are 0 or 1, so max does the same.
+func sqliteMemberPredicate(e *segment.Expression) string {
+	switch e.Op {
+	case segment.OpSegment:
+		return fmt.Sprintf("max(segment_id = %d)", e.SegmentID)
+	case segment.OpNot:
+		return "NOT " + sqliteMemberPredicate(e.Operands[0])
+	}
+
+	parts := make([]string, 0, len(e.Operands))
+	for _, operand := range e.Operands {
+		parts = append(parts, sqliteMemberPredicate(operand))
+	}
+	return "(" + strings.Join(parts, " "+strings.ToUpper(string(e.Op))+" ") + ")"
+}
+
+// ListReferencing returns the composite segments referencing id.
+func (r *SQLiteSegmentRepository) ListReferencing(ctx context.Context, id int) ([]segment.Segment, error) {
+	query := `
+		SELECT ` + sqliteSegmentColumns + `
+		FROM segments
+		WHERE deleted_at IS NULL AND EXISTS (SELECT 1 FROM json_each(referenced_ids) WHERE value = ?)
+		ORDER BY id
+	`
+
+	var rows []sqliteSegmentRow
+	if err := sqlx.SelectContext(ctx, r.q, &rows, query, id); err != nil {
+		return nil, err
+	}
+
+	return toSQLiteSegments(rows), nil
+}
+
+// ListWindowBoundaries returns the segments whose activation window opens or
+// closes in (after, until].
+func (r *SQLiteSegmentRepository) ListWindowBoundaries(ctx context.Context, after, until time.Time) ([]segment.Segment, error) {
+	query := `
+		SELECT ` + sqliteSegmentColumns + `
+		FROM segments
+		WHERE deleted_at IS NULL
+		  AND ((active_from > ?1 AND active_from <= ?2) OR (active_until > ?1 AND active_until <= ?2))
+		ORDER BY id
+	`
+
+	var rows []sqliteSegmentRow
+	if err := sqlx.SelectContext(ctx, r.q, &rows, query, sqliteTime{after}, sqliteTime{until}); err != nil {
+		return nil, err
+	}
+
+	return toSQLiteSegments(rows), nil
+}
+
+// RunInTransaction runs fn inside a database transaction, committing it if fn
+// succeeds and rolling it back otherwise.
+func (r *SQLiteSegmentRepository) RunInTransaction(ctx context.Context, fn func(ctx context.Context, repo segment.Repository) error) error {
+	return r.inTransaction(ctx, func(tx *SQLiteSegmentRepository) error {
+		return fn(context.WithValue(ctx, sqliteTxKey{r.db}, tx.tx), tx)
+	})
+}
+
+// inTransaction runs fn with a repository bound to a transaction: the
+// repository's own, or a new one. Writes spanning several statements go
+// through it to stay atomic.
+func (r *SQLiteSegmentRepository) inTransaction(ctx context.Context, fn func(tx *SQLiteSegmentRepository) error) error {
+	if r.tx != nil {
+		return fn(r)
+	}
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return &BeginError{Err: err}
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(&SQLiteSegmentRepository{db: r.db, q: tx, tx: tx}); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return errors.Join(err, fmt.Errorf("failed to roll back transaction: %w", rbErr))
+		}
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// AppendEvents stores events after expectedVersion. Transactions hold the
+// database's write lock, so no events are appended between checking the
+// version and inserting.
+func (r *SQLiteSegmentRepository) AppendEvents(ctx context.Context, segmentID int, expectedVersion int, events []segment.Event) error {
+	return r.inTransaction(ctx, func(tx *SQLiteSegmentRepository) error {
+		var version int
+		err := sqlx.GetContext(ctx, tx.q, &version, `SELECT COALESCE(MAX(version), 0) FROM segment_events WHERE segment_id = ?`, segmentID)
+		if err != nil {
+			return err
+		}
+		if version != expectedVersion {
+			return fmt.Errorf("%w: expected version %d, got %d", segment.ErrVersionConflict, expectedVersion, version)
+		}
+
+		query := `
+			INSERT INTO segment_events (segment_id, version, type, occurred_at, payload)
+			VALUES (?, ?, ?, ?, ?)
+		`
+		for _, e := range events {
+			payload, err := encodeEventPayload(e)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.q.ExecContext(ctx, query, segmentID, e.Version, string(e.Type), sqliteTime{e.At}, payload); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// LoadEvents returns the events of a segment after afterVersion up to the
+// last one that happened at or before until.
+func (r *SQLiteSegmentRepository) LoadEvents(ctx context.Context, segmentID int, afterVersion int, until *time.Time) ([]segment.Event, error) {
+	query := `
+		SELECT version, type, occurred_at, payload
+		FROM segment_events
+		WHERE segment_id = ? AND version > ?
+		  AND (? IS NULL OR version <= (
+		    SELECT MAX(version) FROM segment_events WHERE segment_id = ? AND occurred_at <= ?
+		  ))
+		ORDER BY version
+	`
+
+	var rows []struct {
+		Version    int        `db:"version"`
+		Type       string     `db:"type"`
+		OccurredAt sqliteTime `db:"occurred_at"`
+		Payload    []byte     `db:"payload"`
+	}
+	bound := newSQLiteTime(until)
+	if err := sqlx.SelectContext(ctx, r.q, &rows, query, segmentID, afterVersion, bound, segmentID, bound); err != nil {
+		return nil, err
+	}
+
+	events := make([]segment.Event, 0, len(rows))
+	for _, row := range rows {
+		e, err := decodeEvent(segmentID, row.Version, row.Type, row.OccurredAt.Time, row.Payload)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+// LoadSnapshot returns the latest snapshot of a segment up to the last event
+// that happened at or before until.
+func (r *SQLiteSegmentRepository) LoadSnapshot(ctx context.Context, segmentID int, until *time.Time) (*segment.Snapshot, error) {
+	query := `
+		SELECT version, occurred_at, segment
+		FROM segment_snapshots
+		WHERE segment_id = ?
+		  AND (? IS NULL OR version <= (
+		    SELECT MAX(version) FROM segment_events WHERE segment_id = ? AND occurred_at <= ?
+		  ))
+		ORDER BY version DESC
+		LIMIT 1
+	`
+
+	var row struct {
+		Version    int        `db:"version"`
+		OccurredAt sqliteTime `db:"occurred_at"`
+		Segment    []byte     `db:"segment"`
+	}
+	bound := newSQLiteTime(until)
+	if err := sqlx.GetContext(ctx, r.q, &row, query, segmentID, bound, segmentID, bound); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return decodeSnapshot(row.Version, row.OccurredAt.Time, row.Segment)
+}
+
+// SaveSnapshot stores a snapshot of a segment.
+func (r *SQLiteSegmentRepository) SaveSnapshot(ctx context.Context, segmentID int, snapshot segment.Snapshot) error {
+	data, err := encodeSnapshot(snapshot.Segment)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO segment_snapshots (segment_id, version, occurred_at, segment)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (segment_id, version) DO UPDATE SET occurred_at = excluded.occurred_at, segment = excluded.segment
+	`
+	_, err = r.q.ExecContext(ctx, query, segmentID, snapshot.Version, sqliteTime{snapshot.At}, data)
+	return err
+}
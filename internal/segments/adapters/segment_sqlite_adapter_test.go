@@ -0,0 +1,139 @@
+package adapters_test
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rickKoch/nexus/internal/segments/adapters"
+	"github.com/rickKoch/nexus/internal/segments/adapters/migrations"
+	"github.com/rickKoch/nexus/internal/segments/domain/segment"
+	"github.com/rickKoch/nexus/internal/segments/domain/segment/segmenttest"
+	"github.com/rickKoch/nexus/pkg/migrate"
+)
+
+func TestSQLiteSegmentRepository(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 11, 1, 12, 30, 0, 0, time.UTC)
+
+	open := func(t *testing.T, path string) *adapters.SQLiteSegmentRepository {
+		t.Helper()
+
+		db, err := adapters.NewSQLiteConnection(adapters.SQLiteConfig{Path: path})
+		if err != nil {
+			t.Fatalf("failed to open database: %v", err)
+		}
+		t.Cleanup(func() { _ = db.Close() })
+
+		m, err := migrate.New(db, migrations.SQLiteFS)
+		if err != nil {
+			t.Fatalf("failed to load migrations: %v", err)
+		}
+		if _, err := m.Up(ctx); err != nil {
+			t.Fatalf("failed to apply migrations: %v", err)
+		}
+		return adapters.NewSQLiteSegmentRepository(db)
+	}
+	create := func(t *testing.T, repo *adapters.SQLiteSegmentRepository) *segment.Segment {
+		t.Helper()
+
+		f, _ := segment.NewFactory(segment.SegmentConfig{Name: "premium-users"})
+		id, err := repo.NextID(ctx)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		s, err := repo.Create(ctx, f.NewSegment(id, now))
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		return s
+	}
+
+	segmenttest.RunRepositoryTests(t, func(t *testing.T) segmenttest.Repository {
+		return open(t, filepath.Join(t.TempDir(), "nexus.db"))
+	})
+
+	t.Run("keeps handing out new IDs after reopening", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "nexus.db")
+		first := create(t, open(t, path))
+
+		if s := create(t, open(t, path)); s.ID() <= first.ID() {
+			t.Errorf("expected an ID after %d, got %d", first.ID(), s.ID())
+		}
+	})
+
+	t.Run("hands out distinct IDs to connections sharing a file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "nexus.db")
+		first, second := open(t, path), open(t, path)
+
+		seen := make(map[int]bool)
+		for range 5 {
+			for _, repo := range []*adapters.SQLiteSegmentRepository{first, second} {
+				id, err := repo.NextID(ctx)
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				if seen[id] {
+					t.Fatalf("expected distinct IDs, got %d twice", id)
+				}
+				seen[id] = true
+			}
+		}
+	})
+
+	t.Run("hands out IDs within a transaction", func(t *testing.T) {
+		repo := open(t, filepath.Join(t.TempDir(), "nexus.db"))
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+
+		var ids []int
+		err := repo.RunInTransaction(ctx, func(ctx context.Context, tx segment.Repository) error {
+			f, _ := segment.NewFactory(segment.SegmentConfig{Name: "premium-users"})
+			for range 2 {
+				id, err := repo.NextID(ctx)
+				if err != nil {
+					return err
+				}
+				if _, err := tx.Create(ctx, f.NewSegment(id, now)); err != nil {
+					return err
+				}
+				ids = append(ids, id)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if ids[0] == ids[1] {
+			t.Errorf("expected distinct IDs, got %v", ids)
+		}
+	})
+
+	t.Run("streams more members than a page", func(t *testing.T) {
+		repo := open(t, filepath.Join(t.TempDir(), "nexus.db"))
+		s := create(t, repo)
+
+		members := make([]segment.Member, 0, 2500)
+		for i := range 2500 {
+			m, _ := s.NewMember(fmt.Sprintf("user-%04d", i), now)
+			members = append(members, m)
+		}
+		if err := repo.AddMembers(ctx, s.ID(), members); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		var got []string
+		err := repo.StreamMembers(ctx, segment.MemberQuery{Expression: &segment.Expression{Op: segment.OpSegment, SegmentID: s.ID()}, At: now}, func(id string) error {
+			got = append(got, id)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if len(got) != len(members) || got[len(got)-1] != "user-2499" {
+			t.Errorf("expected %d members ending with user-2499, got %d", len(members), len(got))
+		}
+	})
+}
@@ -0,0 +1,50 @@
+package adapters
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/jmoiron/sqlx"
+	_ "modernc.org/sqlite" // SQLite driver
+)
+
+// SQLiteConfig holds the configuration for a SQLite database.
+type SQLiteConfig struct {
+	// Path is the database file, created if it does not exist.
+	Path string
+}
+
+// DSN returns the data source name for the SQLite database. Connections wait
+// for the write lock rather than failing while another one holds it, and
+// transactions take it when they begin so that they never fail to upgrade a
+// read lock. Write-ahead logging lets readers proceed during writes.
+func (c SQLiteConfig) DSN() string {
+	params := url.Values{}
+	params.Add("_pragma", "busy_timeout(5000)")
+	params.Add("_pragma", "journal_mode(WAL)")
+	params.Add("_pragma", "foreign_keys(1)")
+	params.Set("_txlock", "immediate")
+	return "file:" + c.Path + "?" + params.Encode()
+}
+
+// Validate checks if the configuration is valid.
+func (c SQLiteConfig) Validate() error {
+	if c.Path == "" {
+		return fmt.Errorf("path is required")
+	}
+	return nil
+}
+
+// NewSQLiteConnection opens the SQLite database with the given configuration.
+func NewSQLiteConnection(cfg SQLiteConfig) (*sqlx.DB, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	db, err := sqlx.Connect("sqlite", cfg.DSN())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	return db, nil
+}
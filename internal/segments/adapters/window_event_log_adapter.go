@@ -0,0 +1,29 @@
+package adapters
+
+import (
+	"context"
+
+	"github.com/rickKoch/nexus/internal/segments/domain/segment"
+	"github.com/sirupsen/logrus"
+)
+
+// LogWindowEventPublisher publishes activation window events to the log.
+type LogWindowEventPublisher struct {
+	logger logrus.FieldLogger
+}
+
+// NewLogWindowEventPublisher creates a publisher writing to logger.
+func NewLogWindowEventPublisher(logger logrus.FieldLogger) LogWindowEventPublisher {
+	return LogWindowEventPublisher{logger: logger}
+}
+
+// Publish logs event.
+func (p LogWindowEventPublisher) Publish(ctx context.Context, event segment.WindowEvent) error {
+	p.logger.WithFields(logrus.Fields{
+		"event":        event.Type,
+		"segment_id":   event.SegmentID,
+		"segment_name": event.SegmentName,
+		"at":           event.At,
+	}).Info("Segment activation window boundary crossed")
+	return nil
+}
@@ -1,54 +1,186 @@
 package app
 
 import (
+	"context"
+
 	"github.com/rickKoch/nexus/internal/segments/app/segments"
 	"github.com/rickKoch/nexus/internal/segments/domain/segment"
+	"github.com/rickKoch/nexus/pkg/cache"
+	"github.com/rickKoch/nexus/pkg/clock"
 )
 
 type Application struct {
 	Segments Segments
+	// WindowScheduler publishes activation window events. It is nil when
+	// the scheduler is disabled.
+	WindowScheduler *segments.WindowScheduler
+	// SizeHistoryRecorder records daily segment sizes. It is nil when
+	// disabled.
+	SizeHistoryRecorder *segments.SizeHistoryRecorder
+	// SegmentCacheStats reports the hits and misses of the segment cache. It
+	// is nil when the cache is disabled.
+	SegmentCacheStats func() cache.Stats
+	// RunJournal flushes and compacts the journal of the durable in-memory
+	// storage until ctx is cancelled. It is nil for other storage.
+	RunJournal func(ctx context.Context, onError func(err error))
+	// Ready returns an error while the storage is known to be unavailable. It
+	// is nil when the storage cannot be checked.
+	Ready func() error
+	// MaintainPartitions creates and archives the partitions of the
+	// PostgreSQL size history until ctx is cancelled. It is nil for other
+	// storage or when disabled.
+	MaintainPartitions func(ctx context.Context, onError func(err error))
+	// SyncMembershipStore copies the memberships into the membership store
+	// again whenever it is out of step, until ctx is cancelled. It is nil
+	// without the store or when disabled.
+	SyncMembershipStore func(ctx context.Context, onError func(err error))
 }
 
 type Segments struct {
-	GetSegment    segments.GetSegmentHandler
-	ListSegments  segments.ListSegmentsHandler
-	CreateSegment segments.CreateSegmentHandler
-	UpdateSegment segments.UpdateSegmentHandler
-	DeleteSegment segments.DeleteSegmentHandler
+	GetSegment        segments.GetSegmentHandler
+	ListSegments      segments.ListSegmentsHandler
+	CreateSegment     segments.CreateSegmentHandler
+	UpdateSegment     segments.UpdateSegmentHandler
+	DeleteSegment     segments.DeleteSegmentHandler
+	BatchSegments     segments.BatchSegmentsHandler
+	ApplySegments     segments.ApplySegmentsHandler
+	TransitionSegment segments.TransitionSegmentHandler
+	AddMembers        segments.AddMembersHandler
+	RemoveMembers     segments.RemoveMembersHandler
+	ExportMembers     segments.ExportMembersHandler
+	CheckMembership   segments.CheckMembershipHandler
+	GetSegmentStats   segments.GetSegmentStatsHandler
+	EstimateSegment   segments.EstimateSegmentHandler
+	EstimateOverlap   segments.EstimateOverlapHandler
+	ListVersions      segments.ListSegmentVersionsHandler
+	GetVersion        segments.GetSegmentVersionHandler
+	DiffVersions      segments.DiffSegmentVersionsHandler
+	RollbackSegment   segments.RollbackSegmentHandler
+
+	// Clock is the clock the use cases run on. Reads use it to evaluate
+	// activation windows.
+	Clock clock.Clock
 }
 
-func NewSegments(repo segment.Repository) (Segments, error) {
+// NewSegments wires the segment use cases. New segments get their IDs from ids
+// and all timestamps come from clk.
+func NewSegments(repo segment.Repository, pagination segments.Pagination, clk clock.Clock, ids segment.IDGenerator) (Segments, error) {
 	seg := Segments{}
 	getHandler, err := segments.NewGetSegmentHandler(repo)
 	if err != nil {
 		return seg, err
 	}
 
-	listHandler, err := segments.NewListSegmentsHandler(repo)
+	listHandler, err := segments.NewListSegmentsHandlerWithPagination(repo, clk, pagination)
+	if err != nil {
+		return seg, err
+	}
+
+	createHandler, err := segments.NewCreateSegmentHandler(repo, clk, ids)
+	if err != nil {
+		return seg, err
+	}
+
+	updateHandler, err := segments.NewUpdateSegmentHandler(repo, clk)
+	if err != nil {
+		return seg, err
+	}
+
+	deleteHandler, err := segments.NewDeleteSegmentHandler(repo, clk)
+	if err != nil {
+		return seg, err
+	}
+
+	batchHandler, err := segments.NewBatchSegmentsHandler(repo, clk, ids)
+	if err != nil {
+		return seg, err
+	}
+
+	applyHandler, err := segments.NewApplySegmentsHandler(repo, clk, ids)
+	if err != nil {
+		return seg, err
+	}
+
+	transitionHandler, err := segments.NewTransitionSegmentHandler(repo, clk)
+	if err != nil {
+		return seg, err
+	}
+
+	addMembersHandler, err := segments.NewAddMembersHandler(repo, clk)
+	if err != nil {
+		return seg, err
+	}
+
+	removeMembersHandler, err := segments.NewRemoveMembersHandler(repo)
+	if err != nil {
+		return seg, err
+	}
+
+	exportMembersHandler, err := segments.NewExportMembersHandler(repo, clk)
+	if err != nil {
+		return seg, err
+	}
+
+	checkMembershipHandler, err := segments.NewCheckMembershipHandler(repo, clk)
+	if err != nil {
+		return seg, err
+	}
+
+	statsHandler, err := segments.NewGetSegmentStatsHandler(repo, clk)
+	if err != nil {
+		return seg, err
+	}
+
+	estimateHandler, err := segments.NewEstimateSegmentHandler(repo, clk)
+	if err != nil {
+		return seg, err
+	}
+
+	overlapHandler, err := segments.NewEstimateOverlapHandler(repo, clk)
+	if err != nil {
+		return seg, err
+	}
+
+	listVersionsHandler, err := segments.NewListSegmentVersionsHandler(repo)
 	if err != nil {
 		return seg, err
 	}
 
-	createHandler, err := segments.NewCreateSegmentHandler(repo)
+	getVersionHandler, err := segments.NewGetSegmentVersionHandler(repo)
 	if err != nil {
 		return seg, err
 	}
 
-	updateHandler, err := segments.NewUpdateSegmentHandler(repo)
+	diffVersionsHandler, err := segments.NewDiffSegmentVersionsHandler(repo)
 	if err != nil {
 		return seg, err
 	}
 
-	deleteHandler, err := segments.NewDeleteSegmentHandler(repo)
+	rollbackHandler, err := segments.NewRollbackSegmentHandler(repo, updateHandler)
 	if err != nil {
 		return seg, err
 	}
 
 	return Segments{
-		GetSegment:    getHandler,
-		ListSegments:  listHandler,
-		CreateSegment: createHandler,
-		UpdateSegment: updateHandler,
-		DeleteSegment: deleteHandler,
+		GetSegment:        getHandler,
+		ListSegments:      listHandler,
+		CreateSegment:     createHandler,
+		UpdateSegment:     updateHandler,
+		DeleteSegment:     deleteHandler,
+		BatchSegments:     batchHandler,
+		ApplySegments:     applyHandler,
+		TransitionSegment: transitionHandler,
+		AddMembers:        addMembersHandler,
+		RemoveMembers:     removeMembersHandler,
+		ExportMembers:     exportMembersHandler,
+		CheckMembership:   checkMembershipHandler,
+		GetSegmentStats:   statsHandler,
+		EstimateSegment:   estimateHandler,
+		EstimateOverlap:   overlapHandler,
+		ListVersions:      listVersionsHandler,
+		GetVersion:        getVersionHandler,
+		DiffVersions:      diffVersionsHandler,
+		RollbackSegment:   rollbackHandler,
+		Clock:             clk,
 	}, nil
 }
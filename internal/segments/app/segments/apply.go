@@ -0,0 +1,306 @@
+package segments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rickKoch/nexus/internal/segments/domain/segment"
+	"github.com/rickKoch/nexus/pkg/clock"
+)
+
+// applyListPageSize is the page size used to read the current state.
+const applyListPageSize = 100
+
+// ApplyAction identifies what apply does to a segment.
+type ApplyAction string
+
+const (
+	ApplyCreate ApplyAction = "create"
+	ApplyUpdate ApplyAction = "update"
+	ApplyDelete ApplyAction = "delete"
+)
+
+var (
+	// ErrDuplicateManifestName is returned when a manifest declares the same
+	// segment name more than once.
+	ErrDuplicateManifestName = errors.New("segment is declared more than once in the manifest")
+	// ErrAmbiguousSegmentName is returned when a segment declared in the
+	// manifest matches more than one existing segment.
+	ErrAmbiguousSegmentName = errors.New("more than one existing segment has this name")
+)
+
+// DesiredSegment is a segment as declared in a manifest. Segments are
+// matched against the current state by name.
+type DesiredSegment struct {
+	Name        string
+	Description string
+	Labels      segment.Labels
+	TTLSeconds  *int
+	ActiveFrom  *time.Time
+	ActiveUntil *time.Time
+	// Expression is the textual expression of a composite segment.
+	Expression string
+}
+
+// ApplySegments holds the desired state of the segment catalog.
+type ApplySegments struct {
+	Segments []DesiredSegment
+	// Prune deletes existing segments that are not declared in the manifest.
+	// Without it they are left untouched and reported as unmanaged.
+	Prune bool
+	// DryRun computes the plan without changing anything.
+	DryRun bool
+}
+
+// PlannedChange is a single step of an apply plan. Desired is the declared
+// segment for creates and updates, Current the existing segment for updates
+// and deletes, and Segment the outcome of an applied create or update.
+type PlannedChange struct {
+	Action  ApplyAction
+	Name    string
+	Desired DesiredSegment
+	Current *segment.Segment
+	Segment *segment.Segment
+}
+
+// ApplySegmentsResult contains the plan and whether it was applied.
+type ApplySegmentsResult struct {
+	Changes   []PlannedChange
+	Unchanged int
+	Unmanaged int
+	DryRun    bool
+}
+
+// Count returns the number of planned changes with the given action.
+func (r ApplySegmentsResult) Count(action ApplyAction) int {
+	n := 0
+	for _, c := range r.Changes {
+		if c.Action == action {
+			n++
+		}
+	}
+	return n
+}
+
+// ApplySegmentsHandler defines the interface for applying a manifest.
+type ApplySegmentsHandler interface {
+	Handle(ctx context.Context, cmd ApplySegments) (*ApplySegmentsResult, error)
+}
+
+type applySegmentsHandler struct {
+	segmentRepo segment.Repository
+	clock       clock.Clock
+	ids         segment.IDGenerator
+}
+
+// NewApplySegmentsHandler creates a new ApplySegmentsHandler.
+func NewApplySegmentsHandler(segmentRepo segment.Repository, clk clock.Clock, ids segment.IDGenerator) (ApplySegmentsHandler, error) {
+	if segmentRepo == nil {
+		return applySegmentsHandler{}, errors.New("segment repository is not provided")
+	}
+	if clk == nil {
+		return applySegmentsHandler{}, errors.New("clock is not provided")
+	}
+	if ids == nil {
+		return applySegmentsHandler{}, errors.New("ID generator is not provided")
+	}
+
+	return applySegmentsHandler{segmentRepo, clk, ids}, nil
+}
+
+// Handle diffs the manifest against the current segments and, unless it is a
+// dry run, applies the resulting plan as a single unit of work.
+func (h applySegmentsHandler) Handle(ctx context.Context, cmd ApplySegments) (*ApplySegmentsResult, error) {
+	declared := make(map[string]bool, len(cmd.Segments))
+	for _, s := range cmd.Segments {
+		expr, err := parseExpression(s.Expression)
+		if err != nil {
+			return nil, fmt.Errorf("invalid segment '%s': %w", s.Name, err)
+		}
+		config := segment.SegmentConfig{
+			Name:        s.Name,
+			Description: s.Description,
+			Labels:      s.Labels,
+			TTLSeconds:  s.TTLSeconds,
+			ActiveFrom:  s.ActiveFrom,
+			ActiveUntil: s.ActiveUntil,
+			Expression:  expr,
+		}
+		if err := config.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid segment '%s': %w", s.Name, err)
+		}
+		if declared[s.Name] {
+			return nil, fmt.Errorf("%w: '%s'", ErrDuplicateManifestName, s.Name)
+		}
+		declared[s.Name] = true
+	}
+
+	var result *ApplySegmentsResult
+	err := h.segmentRepo.RunInTransaction(ctx, func(ctx context.Context, repo segment.Repository) error {
+		current, err := listAll(ctx, repo)
+		if err != nil {
+			return err
+		}
+
+		result, err = plan(cmd, current)
+		if err != nil {
+			return err
+		}
+		if cmd.DryRun {
+			return nil
+		}
+
+		for i := range result.Changes {
+			if err := h.applyChange(ctx, repo, &result.Changes[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// plan computes the changes needed to go from current to the desired state.
+// Creates and updates follow the manifest order, deletes follow ID order.
+func plan(cmd ApplySegments, current []segment.Segment) (*ApplySegmentsResult, error) {
+	byName := make(map[string][]*segment.Segment, len(current))
+	for i := range current {
+		byName[current[i].Name()] = append(byName[current[i].Name()], &current[i])
+	}
+
+	result := &ApplySegmentsResult{DryRun: cmd.DryRun}
+	for _, desired := range cmd.Segments {
+		matches := byName[desired.Name]
+		switch {
+		case len(matches) == 0:
+			result.Changes = append(result.Changes, PlannedChange{
+				Action:  ApplyCreate,
+				Name:    desired.Name,
+				Desired: desired,
+			})
+		case len(matches) > 1:
+			return nil, fmt.Errorf("%w: '%s'", ErrAmbiguousSegmentName, desired.Name)
+		case isUpToDate(matches[0], desired):
+			result.Unchanged++
+		default:
+			result.Changes = append(result.Changes, PlannedChange{
+				Action:  ApplyUpdate,
+				Name:    desired.Name,
+				Desired: desired,
+				Current: matches[0],
+			})
+		}
+	}
+
+	declared := make(map[string]bool, len(cmd.Segments))
+	for _, desired := range cmd.Segments {
+		declared[desired.Name] = true
+	}
+	for i := range current {
+		if declared[current[i].Name()] {
+			continue
+		}
+		if !cmd.Prune {
+			result.Unmanaged++
+			continue
+		}
+		result.Changes = append(result.Changes, PlannedChange{
+			Action:  ApplyDelete,
+			Name:    current[i].Name(),
+			Current: &current[i],
+		})
+	}
+
+	return result, nil
+}
+
+// applyChange runs a planned change through the regular use case handlers.
+func (h applySegmentsHandler) applyChange(ctx context.Context, repo segment.Repository, change *PlannedChange) error {
+	var err error
+	switch change.Action {
+	case ApplyCreate:
+		handler, _ := NewCreateSegmentHandler(repo, h.clock, h.ids)
+		change.Segment, err = handler.Handle(ctx, CreateSegment{
+			Name:        change.Desired.Name,
+			Description: change.Desired.Description,
+			Labels:      change.Desired.Labels,
+			TTLSeconds:  change.Desired.TTLSeconds,
+			ActiveFrom:  change.Desired.ActiveFrom,
+			ActiveUntil: change.Desired.ActiveUntil,
+			Expression:  change.Desired.Expression,
+		})
+	case ApplyUpdate:
+		handler, _ := NewUpdateSegmentHandler(repo, h.clock)
+		change.Segment, err = handler.Handle(ctx, UpdateSegment{
+			ID:          change.Current.ID(),
+			Name:        change.Desired.Name,
+			Description: change.Desired.Description,
+			Labels:      change.Desired.Labels,
+			TTLSeconds:  change.Desired.TTLSeconds,
+			ActiveFrom:  change.Desired.ActiveFrom,
+			ActiveUntil: change.Desired.ActiveUntil,
+			Expression:  change.Desired.Expression,
+		})
+	case ApplyDelete:
+		handler, _ := NewDeleteSegmentHandler(repo, h.clock)
+		err = handler.Handle(ctx, DeleteSegment{ID: change.Current.ID()})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to %s segment '%s': %w", change.Action, change.Name, err)
+	}
+
+	return nil
+}
+
+// listAll reads every segment from repo.
+func listAll(ctx context.Context, repo segment.Repository) ([]segment.Segment, error) {
+	var all []segment.Segment
+	for page := 1; ; page++ {
+		result, err := repo.List(ctx, segment.ListParams{Page: page, PageSize: applyListPageSize})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list segments: %w", err)
+		}
+
+		all = append(all, result.Segments...)
+		if len(result.Segments) == 0 || len(all) >= result.TotalCount {
+			return all, nil
+		}
+	}
+}
+
+// isUpToDate reports whether current already matches desired.
+func isUpToDate(current *segment.Segment, desired DesiredSegment) bool {
+	return current.Description() == desired.Description &&
+		current.Labels().Equal(desired.Labels) &&
+		equalTTL(current.TTLSeconds(), desired.TTLSeconds) &&
+		equalTime(current.ActiveFrom(), desired.ActiveFrom) &&
+		equalTime(current.ActiveUntil(), desired.ActiveUntil) &&
+		equalExpression(current.Expression(), desired.Expression)
+}
+
+func equalTTL(a, b *int) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// equalExpression compares expressions by their canonical form. desired has
+// already been validated.
+func equalExpression(current *segment.Expression, desired string) bool {
+	expr, _ := parseExpression(desired)
+	return current.Equal(expr)
+}
+
+func equalTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
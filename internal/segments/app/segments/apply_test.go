@@ -0,0 +1,217 @@
+package segments_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rickKoch/nexus/internal/segments/adapters"
+	"github.com/rickKoch/nexus/internal/segments/app/segments"
+	"github.com/rickKoch/nexus/internal/segments/domain/segment"
+	"github.com/rickKoch/nexus/pkg/clock"
+)
+
+func intPtr(v int) *int { return &v }
+
+func TestApplySegmentsHandler_Handle(t *testing.T) {
+	ctx := context.Background()
+
+	setup := func(t *testing.T) (*adapters.InMemorySegmentRepository, segments.ApplySegmentsHandler) {
+		t.Helper()
+
+		repo := adapters.NewInMemorySegmentRepository()
+		createHandler, _ := segments.NewCreateSegmentHandler(repo, clock.System, repo)
+		for _, cmd := range []segments.CreateSegment{
+			{Name: "unchanged", TTLSeconds: intPtr(60)},
+			{Name: "changed", TTLSeconds: intPtr(60)},
+			{Name: "unmanaged"},
+		} {
+			if _, err := createHandler.Handle(ctx, cmd); err != nil {
+				t.Fatalf("failed to create segment: %v", err)
+			}
+		}
+
+		handler, err := segments.NewApplySegmentsHandler(repo, clock.System, repo)
+		if err != nil {
+			t.Fatalf("failed to create handler: %v", err)
+		}
+		return repo, handler
+	}
+
+	manifest := []segments.DesiredSegment{
+		{Name: "unchanged", TTLSeconds: intPtr(60)},
+		{Name: "changed"},
+		{Name: "new", TTLSeconds: intPtr(30)},
+	}
+
+	names := func(t *testing.T, repo segment.Repository) map[string]*int {
+		t.Helper()
+
+		result, err := repo.List(ctx, segment.ListParams{Page: 1, PageSize: 100})
+		if err != nil {
+			t.Fatalf("failed to list segments: %v", err)
+		}
+		got := make(map[string]*int)
+		for _, s := range result.Segments {
+			got[s.Name()] = s.TTLSeconds()
+		}
+		return got
+	}
+
+	t.Run("applies creates and updates without prune", func(t *testing.T) {
+		repo, handler := setup(t)
+
+		result, err := handler.Handle(ctx, segments.ApplySegments{Segments: manifest})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if result.Count(segments.ApplyCreate) != 1 || result.Count(segments.ApplyUpdate) != 1 || result.Count(segments.ApplyDelete) != 0 {
+			t.Errorf("unexpected plan %+v", result.Changes)
+		}
+		if result.Unchanged != 1 || result.Unmanaged != 1 {
+			t.Errorf("expected 1 unchanged and 1 unmanaged, got %d and %d", result.Unchanged, result.Unmanaged)
+		}
+		for _, c := range result.Changes {
+			if c.Segment == nil {
+				t.Errorf("expected applied %s of '%s' to return the segment", c.Action, c.Name)
+			}
+		}
+
+		got := names(t, repo)
+		if len(got) != 4 {
+			t.Errorf("expected 4 segments, got %d", len(got))
+		}
+		if got["changed"] != nil {
+			t.Errorf("expected TTL of 'changed' to be removed, got %d", *got["changed"])
+		}
+		if ttl := got["new"]; ttl == nil || *ttl != 30 {
+			t.Errorf("expected 'new' to be created with TTL 30, got %v", ttl)
+		}
+	})
+
+	t.Run("deletes unmanaged segments with prune", func(t *testing.T) {
+		repo, handler := setup(t)
+
+		result, err := handler.Handle(ctx, segments.ApplySegments{Segments: manifest, Prune: true})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if result.Count(segments.ApplyDelete) != 1 || result.Unmanaged != 0 {
+			t.Errorf("expected 1 delete and no unmanaged segments, got %d and %d", result.Count(segments.ApplyDelete), result.Unmanaged)
+		}
+		if _, ok := names(t, repo)["unmanaged"]; ok {
+			t.Error("expected 'unmanaged' to be deleted")
+		}
+	})
+
+	t.Run("does not change anything in dry-run mode", func(t *testing.T) {
+		repo, handler := setup(t)
+
+		result, err := handler.Handle(ctx, segments.ApplySegments{Segments: manifest, Prune: true, DryRun: true})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if !result.DryRun || len(result.Changes) != 3 {
+			t.Errorf("expected a dry-run plan of 3 changes, got %+v", result)
+		}
+		for _, c := range result.Changes {
+			if c.Segment != nil {
+				t.Errorf("expected %s of '%s' not to be applied", c.Action, c.Name)
+			}
+		}
+
+		got := names(t, repo)
+		if _, ok := got["unmanaged"]; !ok || len(got) != 3 {
+			t.Errorf("expected segments to be untouched, got %v", got)
+		}
+		if ttl := got["changed"]; ttl == nil || *ttl != 60 {
+			t.Errorf("expected TTL of 'changed' to stay 60, got %v", ttl)
+		}
+	})
+
+	t.Run("is a no-op when the state matches", func(t *testing.T) {
+		_, handler := setup(t)
+
+		if _, err := handler.Handle(ctx, segments.ApplySegments{Segments: manifest, Prune: true}); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		result, err := handler.Handle(ctx, segments.ApplySegments{Segments: manifest, Prune: true})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if len(result.Changes) != 0 || result.Unchanged != 3 {
+			t.Errorf("expected no changes, got %+v", result)
+		}
+	})
+
+	t.Run("updates segments whose activation window changed", func(t *testing.T) {
+		_, handler := setup(t)
+		from := time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)
+		windowed := []segments.DesiredSegment{{Name: "unchanged", TTLSeconds: intPtr(60), ActiveFrom: &from}}
+
+		result, err := handler.Handle(ctx, segments.ApplySegments{Segments: windowed})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if result.Count(segments.ApplyUpdate) != 1 {
+			t.Fatalf("expected 1 update, got %+v", result)
+		}
+
+		// The same instant in another zone is not a change.
+		local := from.In(time.FixedZone("CET", 3600))
+		windowed[0].ActiveFrom = &local
+		result, err = handler.Handle(ctx, segments.ApplySegments{Segments: windowed})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if len(result.Changes) != 0 {
+			t.Errorf("expected no changes, got %+v", result.Changes)
+		}
+	})
+
+	t.Run("rejects invalid manifests", func(t *testing.T) {
+		_, handler := setup(t)
+
+		tests := []struct {
+			name     string
+			segments []segments.DesiredSegment
+			err      error
+		}{
+			{"empty name", []segments.DesiredSegment{{Name: ""}}, segment.ErrNameRequired},
+			{"invalid TTL", []segments.DesiredSegment{{Name: "x", TTLSeconds: intPtr(0)}}, segment.ErrInvalidTTL},
+			{"duplicate name", []segments.DesiredSegment{{Name: "x"}, {Name: "x"}}, segments.ErrDuplicateManifestName},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				_, err := handler.Handle(ctx, segments.ApplySegments{Segments: tt.segments})
+				if !errors.Is(err, tt.err) {
+					t.Errorf("expected %v, got %v", tt.err, err)
+				}
+			})
+		}
+	})
+
+	t.Run("rejects ambiguous existing names", func(t *testing.T) {
+		repo, handler := setup(t)
+		createHandler, _ := segments.NewCreateSegmentHandler(repo, clock.System, repo)
+		_, _ = createHandler.Handle(ctx, segments.CreateSegment{Name: "changed"})
+
+		_, err := handler.Handle(ctx, segments.ApplySegments{Segments: manifest})
+		if !errors.Is(err, segments.ErrAmbiguousSegmentName) {
+			t.Errorf("expected %v, got %v", segments.ErrAmbiguousSegmentName, err)
+		}
+	})
+}
+
+func TestNewApplySegmentsHandler_NilRepository(t *testing.T) {
+	_, err := segments.NewApplySegmentsHandler(nil, clock.System, adapters.NewInMemorySegmentRepository())
+	if err == nil {
+		t.Error("expected error for nil repository")
+	}
+}
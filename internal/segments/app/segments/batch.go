@@ -0,0 +1,247 @@
+package segments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rickKoch/nexus/internal/segments/domain/segment"
+	"github.com/rickKoch/nexus/pkg/clock"
+)
+
+// MaxBatchOperations is the maximum number of operations in a single batch.
+const MaxBatchOperations = 1000
+
+// BatchOperationType identifies the kind of a batch operation.
+type BatchOperationType string
+
+const (
+	BatchCreate BatchOperationType = "create"
+	BatchUpdate BatchOperationType = "update"
+	BatchDelete BatchOperationType = "delete"
+)
+
+// BatchMode controls what happens when an operation of a batch fails.
+type BatchMode string
+
+const (
+	// BatchModeAtomic applies either all operations or none of them.
+	BatchModeAtomic BatchMode = "atomic"
+	// BatchModeBestEffort applies every operation that succeeds on its own.
+	BatchModeBestEffort BatchMode = "best_effort"
+)
+
+var (
+	// ErrEmptyBatch is returned when a batch has no operations.
+	ErrEmptyBatch = errors.New("batch must contain at least one operation")
+	// ErrBatchTooLarge is returned when a batch exceeds MaxBatchOperations.
+	ErrBatchTooLarge = fmt.Errorf("batch must contain at most %d operations", MaxBatchOperations)
+	// ErrUnknownBatchMode is returned for an unsupported batch mode.
+	ErrUnknownBatchMode = errors.New("unknown batch mode")
+	// ErrUnknownBatchOperation is returned for an unsupported operation type.
+	ErrUnknownBatchOperation = errors.New("unknown batch operation")
+	// ErrBatchRolledBack is reported for operations of an atomic batch that
+	// succeeded but were undone because another operation failed.
+	ErrBatchRolledBack = errors.New("rolled back because another operation in the batch failed")
+	// ErrBatchSkipped is reported for operations of an atomic batch that were
+	// not attempted because an earlier operation failed.
+	ErrBatchSkipped = errors.New("skipped because another operation in the batch failed")
+)
+
+// BatchOperation is a single create, update or delete in a batch.
+// ID is ignored for creates; all other fields are ignored for deletes.
+type BatchOperation struct {
+	Type        BatchOperationType
+	ID          int
+	Name        string
+	Description string
+	Labels      segment.Labels
+	TTLSeconds  *int
+	ActiveFrom  *time.Time
+	ActiveUntil *time.Time
+	Expression  string
+}
+
+// BatchSegments holds the operations to execute and how to execute them.
+type BatchSegments struct {
+	Mode       BatchMode
+	Operations []BatchOperation
+}
+
+// BatchOperationResult is the outcome of a single operation. Segment is set
+// for successful creates and updates; Err is set for operations that did not
+// take effect.
+type BatchOperationResult struct {
+	Type    BatchOperationType
+	ID      int
+	Segment *segment.Segment
+	Err     error
+}
+
+// BatchSegmentsResult contains the outcome of every operation, in request order.
+type BatchSegmentsResult struct {
+	Mode    BatchMode
+	Results []BatchOperationResult
+}
+
+// Succeeded returns the number of operations that took effect.
+func (r BatchSegmentsResult) Succeeded() int {
+	n := 0
+	for _, res := range r.Results {
+		if res.Err == nil {
+			n++
+		}
+	}
+	return n
+}
+
+// Failed returns the number of operations that did not take effect.
+func (r BatchSegmentsResult) Failed() int {
+	return len(r.Results) - r.Succeeded()
+}
+
+// BatchSegmentsHandler defines the interface for executing batch operations.
+type BatchSegmentsHandler interface {
+	Handle(ctx context.Context, cmd BatchSegments) (*BatchSegmentsResult, error)
+}
+
+type batchSegmentsHandler struct {
+	segmentRepo segment.Repository
+	clock       clock.Clock
+	ids         segment.IDGenerator
+}
+
+// NewBatchSegmentsHandler creates a new BatchSegmentsHandler.
+func NewBatchSegmentsHandler(segmentRepo segment.Repository, clk clock.Clock, ids segment.IDGenerator) (BatchSegmentsHandler, error) {
+	if segmentRepo == nil {
+		return batchSegmentsHandler{}, errors.New("segment repository is not provided")
+	}
+	if clk == nil {
+		return batchSegmentsHandler{}, errors.New("clock is not provided")
+	}
+	if ids == nil {
+		return batchSegmentsHandler{}, errors.New("ID generator is not provided")
+	}
+
+	return batchSegmentsHandler{segmentRepo, clk, ids}, nil
+}
+
+// Handle executes the batch. The returned error is reserved for invalid
+// batches and failures outside of individual operations; per-operation
+// failures are reported in the result.
+func (h batchSegmentsHandler) Handle(ctx context.Context, cmd BatchSegments) (*BatchSegmentsResult, error) {
+	if len(cmd.Operations) == 0 {
+		return nil, ErrEmptyBatch
+	}
+	if len(cmd.Operations) > MaxBatchOperations {
+		return nil, ErrBatchTooLarge
+	}
+	for i, op := range cmd.Operations {
+		switch op.Type {
+		case BatchCreate, BatchUpdate, BatchDelete:
+		default:
+			return nil, fmt.Errorf("operation %d: %w '%s'", i, ErrUnknownBatchOperation, op.Type)
+		}
+	}
+
+	switch cmd.Mode {
+	case BatchModeAtomic:
+		return h.handleAtomic(ctx, cmd.Operations)
+	case BatchModeBestEffort:
+		return h.handleBestEffort(ctx, cmd.Operations)
+	default:
+		return nil, fmt.Errorf("%w '%s'", ErrUnknownBatchMode, cmd.Mode)
+	}
+}
+
+func (h batchSegmentsHandler) handleAtomic(ctx context.Context, ops []BatchOperation) (*BatchSegmentsResult, error) {
+	results := make([]BatchOperationResult, len(ops))
+	var opErr error
+
+	err := h.segmentRepo.RunInTransaction(ctx, func(ctx context.Context, repo segment.Repository) error {
+		// The transaction may be retried, so only its last run counts.
+		opErr = nil
+		for i, op := range ops {
+			results[i] = h.execute(ctx, repo, op)
+			if results[i].Err != nil {
+				opErr = results[i].Err
+				return opErr
+			}
+		}
+		return nil
+	})
+
+	switch {
+	case err == nil:
+		return &BatchSegmentsResult{Mode: BatchModeAtomic, Results: results}, nil
+	case opErr == nil:
+		return nil, fmt.Errorf("failed to execute batch: %w", err)
+	}
+
+	// Everything before the failed operation was rolled back and everything
+	// after it never ran.
+	failed := false
+	for i, op := range ops {
+		switch {
+		case failed:
+			results[i] = BatchOperationResult{Type: op.Type, ID: op.ID, Err: ErrBatchSkipped}
+		case results[i].Err != nil:
+			failed = true
+		default:
+			results[i] = BatchOperationResult{Type: op.Type, ID: op.ID, Err: ErrBatchRolledBack}
+		}
+	}
+
+	return &BatchSegmentsResult{Mode: BatchModeAtomic, Results: results}, nil
+}
+
+func (h batchSegmentsHandler) handleBestEffort(ctx context.Context, ops []BatchOperation) (*BatchSegmentsResult, error) {
+	results := make([]BatchOperationResult, len(ops))
+	for i, op := range ops {
+		results[i] = h.execute(ctx, h.segmentRepo, op)
+	}
+
+	return &BatchSegmentsResult{Mode: BatchModeBestEffort, Results: results}, nil
+}
+
+// execute runs a single operation through the regular use case handlers so
+// batched operations get exactly the same validation.
+func (h batchSegmentsHandler) execute(ctx context.Context, repo segment.Repository, op BatchOperation) BatchOperationResult {
+	result := BatchOperationResult{Type: op.Type, ID: op.ID}
+
+	switch op.Type {
+	case BatchCreate:
+		handler, _ := NewCreateSegmentHandler(repo, h.clock, h.ids)
+		result.Segment, result.Err = handler.Handle(ctx, CreateSegment{
+			Name:        op.Name,
+			Description: op.Description,
+			Labels:      op.Labels,
+			TTLSeconds:  op.TTLSeconds,
+			ActiveFrom:  op.ActiveFrom,
+			ActiveUntil: op.ActiveUntil,
+			Expression:  op.Expression,
+		})
+	case BatchUpdate:
+		handler, _ := NewUpdateSegmentHandler(repo, h.clock)
+		result.Segment, result.Err = handler.Handle(ctx, UpdateSegment{
+			ID:          op.ID,
+			Name:        op.Name,
+			Description: op.Description,
+			Labels:      op.Labels,
+			TTLSeconds:  op.TTLSeconds,
+			ActiveFrom:  op.ActiveFrom,
+			ActiveUntil: op.ActiveUntil,
+			Expression:  op.Expression,
+		})
+	case BatchDelete:
+		handler, _ := NewDeleteSegmentHandler(repo, h.clock)
+		result.Err = handler.Handle(ctx, DeleteSegment{ID: op.ID})
+	}
+
+	if result.Segment != nil {
+		result.ID = result.Segment.ID()
+	}
+
+	return result
+}
@@ -0,0 +1,163 @@
+package segments_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/rickKoch/nexus/internal/segments/adapters"
+	"github.com/rickKoch/nexus/internal/segments/app/segments"
+	"github.com/rickKoch/nexus/internal/segments/domain/segment"
+	"github.com/rickKoch/nexus/pkg/clock"
+)
+
+func TestBatchSegmentsHandler_Handle(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("applies all operations atomically", func(t *testing.T) {
+		repo := adapters.NewInMemorySegmentRepository()
+		createHandler, _ := segments.NewCreateSegmentHandler(repo, clock.System, repo)
+		batchHandler, err := segments.NewBatchSegmentsHandler(repo, clock.System, repo)
+		if err != nil {
+			t.Fatalf("failed to create handler: %v", err)
+		}
+
+		existing, _ := createHandler.Handle(ctx, segments.CreateSegment{Name: "existing"})
+		toDelete, _ := createHandler.Handle(ctx, segments.CreateSegment{Name: "to-delete"})
+
+		result, err := batchHandler.Handle(ctx, segments.BatchSegments{
+			Mode: segments.BatchModeAtomic,
+			Operations: []segments.BatchOperation{
+				{Type: segments.BatchCreate, Name: "new"},
+				{Type: segments.BatchUpdate, ID: existing.ID(), Name: "renamed"},
+				{Type: segments.BatchDelete, ID: toDelete.ID()},
+			},
+		})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if result.Succeeded() != 3 || result.Failed() != 0 {
+			t.Errorf("expected 3 succeeded and 0 failed, got %d and %d", result.Succeeded(), result.Failed())
+		}
+
+		got, _ := repo.Get(ctx, existing.ID())
+		if got.Name() != "renamed" {
+			t.Errorf("expected name 'renamed', got '%s'", got.Name())
+		}
+		if _, err := repo.Get(ctx, toDelete.ID()); err == nil {
+			t.Error("expected deleted segment to be gone")
+		}
+	})
+
+	t.Run("rolls back everything when an atomic operation fails", func(t *testing.T) {
+		repo := adapters.NewInMemorySegmentRepository()
+		createHandler, _ := segments.NewCreateSegmentHandler(repo, clock.System, repo)
+		batchHandler, _ := segments.NewBatchSegmentsHandler(repo, clock.System, repo)
+
+		existing, _ := createHandler.Handle(ctx, segments.CreateSegment{Name: "existing"})
+
+		result, err := batchHandler.Handle(ctx, segments.BatchSegments{
+			Mode: segments.BatchModeAtomic,
+			Operations: []segments.BatchOperation{
+				{Type: segments.BatchCreate, Name: "new"},
+				{Type: segments.BatchDelete, ID: existing.ID()},
+				{Type: segments.BatchUpdate, ID: 99999, Name: "missing"},
+				{Type: segments.BatchCreate, Name: "never-run"},
+			},
+		})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if result.Succeeded() != 0 {
+			t.Errorf("expected nothing to succeed, got %d", result.Succeeded())
+		}
+		if !errors.Is(result.Results[0].Err, segments.ErrBatchRolledBack) {
+			t.Errorf("expected first operation to be rolled back, got %v", result.Results[0].Err)
+		}
+		if result.Results[2].Err == nil || errors.Is(result.Results[2].Err, segments.ErrBatchRolledBack) {
+			t.Errorf("expected third operation to report its own error, got %v", result.Results[2].Err)
+		}
+		if !errors.Is(result.Results[3].Err, segments.ErrBatchSkipped) {
+			t.Errorf("expected last operation to be skipped, got %v", result.Results[3].Err)
+		}
+
+		list, _ := repo.List(ctx, segment.ListParams{Page: 1, PageSize: 10})
+		if list.TotalCount != 1 || list.Segments[0].Name() != "existing" {
+			t.Errorf("expected only the existing segment to remain, got %d segments", list.TotalCount)
+		}
+
+		// Like a database sequence, IDs handed out inside the rolled back
+		// batch are not reused.
+		next, _ := createHandler.Handle(ctx, segments.CreateSegment{Name: "after"})
+		if next.ID() != existing.ID()+2 {
+			t.Errorf("expected ID %d, got %d", existing.ID()+2, next.ID())
+		}
+	})
+
+	t.Run("applies successful operations in best-effort mode", func(t *testing.T) {
+		repo := adapters.NewInMemorySegmentRepository()
+		batchHandler, _ := segments.NewBatchSegmentsHandler(repo, clock.System, repo)
+
+		result, err := batchHandler.Handle(ctx, segments.BatchSegments{
+			Mode: segments.BatchModeBestEffort,
+			Operations: []segments.BatchOperation{
+				{Type: segments.BatchCreate, Name: "first"},
+				{Type: segments.BatchCreate, Name: ""},
+				{Type: segments.BatchCreate, Name: "third"},
+			},
+		})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if result.Succeeded() != 2 || result.Failed() != 1 {
+			t.Errorf("expected 2 succeeded and 1 failed, got %d and %d", result.Succeeded(), result.Failed())
+		}
+		if result.Results[1].Err == nil {
+			t.Error("expected second operation to fail")
+		}
+	})
+
+	t.Run("rejects invalid batches", func(t *testing.T) {
+		repo := adapters.NewInMemorySegmentRepository()
+		batchHandler, _ := segments.NewBatchSegmentsHandler(repo, clock.System, repo)
+
+		tests := []struct {
+			name string
+			cmd  segments.BatchSegments
+			err  error
+		}{
+			{"empty", segments.BatchSegments{Mode: segments.BatchModeAtomic}, segments.ErrEmptyBatch},
+			{"too large", segments.BatchSegments{
+				Mode:       segments.BatchModeAtomic,
+				Operations: make([]segments.BatchOperation, segments.MaxBatchOperations+1),
+			}, segments.ErrBatchTooLarge},
+			{"unknown mode", segments.BatchSegments{
+				Mode:       "sometimes",
+				Operations: []segments.BatchOperation{{Type: segments.BatchCreate, Name: "x"}},
+			}, segments.ErrUnknownBatchMode},
+			{"unknown operation", segments.BatchSegments{
+				Mode:       segments.BatchModeAtomic,
+				Operations: []segments.BatchOperation{{Type: "upsert", Name: "x"}},
+			}, segments.ErrUnknownBatchOperation},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				_, err := batchHandler.Handle(ctx, tt.cmd)
+				if !errors.Is(err, tt.err) {
+					t.Errorf("expected %v, got %v", tt.err, err)
+				}
+			})
+		}
+	})
+}
+
+func TestNewBatchSegmentsHandler_NilRepository(t *testing.T) {
+	_, err := segments.NewBatchSegmentsHandler(nil, clock.System, adapters.NewInMemorySegmentRepository())
+	if err == nil {
+		t.Error("expected error for nil repository")
+	}
+}
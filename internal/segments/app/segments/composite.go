@@ -0,0 +1,184 @@
+package segments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rickKoch/nexus/internal/segments/domain/segment"
+)
+
+// parseExpression parses the expression of a composite segment. An empty
+// expression defines a regular segment.
+func parseExpression(s string) (*segment.Expression, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	return segment.ParseExpression(s)
+}
+
+// checkReferences verifies that every segment expr references exists and that
+// defining segment id by expr does not introduce a reference cycle. Existing
+// composites are acyclic, so a cycle exists exactly when id is reachable from
+// the segments expr references. Within a transaction the referenced segments
+// stay locked against deletion until it ends.
+func checkReferences(ctx context.Context, repo segment.Repository, id int, expr *segment.Expression) error {
+	if expr == nil {
+		return nil
+	}
+
+	direct := expr.SegmentIDs()
+	pending := append([]int(nil), direct...)
+	visited := make(map[int]bool)
+	for i := 0; i < len(pending); i++ {
+		ref := pending[i]
+		if ref == id {
+			return fmt.Errorf("%w: segment '%d' depends on itself", segment.ErrReferenceCycle, id)
+		}
+		if visited[ref] {
+			continue
+		}
+		visited[ref] = true
+
+		s, err := repo.GetShared(ctx, ref)
+		if errors.Is(err, segment.ErrSegmentNotFound) && i < len(direct) {
+			return fmt.Errorf("%w: '%d'", segment.ErrUnknownReference, ref)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get referenced segment '%d': %w", ref, err)
+		}
+		if s.IsComposite() {
+			pending = append(pending, s.Expression().SegmentIDs()...)
+		}
+	}
+
+	return nil
+}
+
+// checkNotReferenced fails if a composite segment references segment id.
+func checkNotReferenced(ctx context.Context, repo segment.Repository, id int) error {
+	referencing, err := repo.ListReferencing(ctx, id)
+	if err != nil {
+		return fmt.Errorf("failed to list segments referencing '%d': %w", id, err)
+	}
+	if len(referencing) == 0 {
+		return nil
+	}
+
+	ids := make([]string, 0, len(referencing))
+	for _, s := range referencing {
+		ids = append(ids, fmt.Sprintf("'%d'", s.ID()))
+	}
+	return fmt.Errorf("%w: referenced by %s", segment.ErrSegmentReferenced, strings.Join(ids, ", "))
+}
+
+// Placeholders for the parts of an expression that select no candidate or
+// every candidate while it is expanded.
+var (
+	selectNone = &segment.Expression{}
+	selectAll  = &segment.Expression{}
+)
+
+// expandExpression returns the expression selecting the members of s at at in
+// terms of regular segments only, or nil if it selects no one. A regular
+// segment selects its own members and the references of a composite segment
+// are replaced by their expansions. Segments that are not matchable at at,
+// such as paused ones or ones outside their activation window, have no
+// members.
+func expandExpression(ctx context.Context, repo segment.Repository, s *segment.Segment, at time.Time) (*segment.Expression, error) {
+	if !s.IsMatchable(at) {
+		return nil, nil
+	}
+	if !s.IsComposite() {
+		return segment.Ref(s.ID()), nil
+	}
+
+	// candidates are the matchable regular segments the expression
+	// references, whose members the query considers even where the
+	// expression no longer needs them.
+	var candidates []int
+	var expand func(e *segment.Expression) (*segment.Expression, error)
+	expand = func(e *segment.Expression) (*segment.Expression, error) {
+		switch e.Op {
+		case segment.OpSegment:
+			ref, err := repo.Get(ctx, e.SegmentID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get referenced segment '%d': %w", e.SegmentID, err)
+			}
+			if !ref.IsMatchable(at) {
+				return selectNone, nil
+			}
+			if !ref.IsComposite() {
+				if !slices.Contains(candidates, ref.ID()) {
+					candidates = append(candidates, ref.ID())
+				}
+				return segment.Ref(ref.ID()), nil
+			}
+			return expand(ref.Expression())
+		case segment.OpNot:
+			operand, err := expand(e.Operands[0])
+			if err != nil {
+				return nil, err
+			}
+			switch operand {
+			case selectNone:
+				return selectAll, nil
+			case selectAll:
+				return selectNone, nil
+			}
+			return segment.Not(operand), nil
+		}
+
+		// An empty operand decides an AND and drops out of an OR, and an
+		// operand selecting everyone the other way around.
+		decisive, neutral := selectNone, selectAll
+		if e.Op == segment.OpOr {
+			decisive, neutral = selectAll, selectNone
+		}
+		operands := make([]*segment.Expression, 0, len(e.Operands))
+		for _, operand := range e.Operands {
+			expanded, err := expand(operand)
+			if err != nil {
+				return nil, err
+			}
+			if expanded == decisive {
+				return decisive, nil
+			}
+			if expanded != neutral {
+				operands = append(operands, expanded)
+			}
+		}
+		switch len(operands) {
+		case 0:
+			return neutral, nil
+		case 1:
+			return operands[0], nil
+		}
+		return &segment.Expression{Op: e.Op, Operands: operands}, nil
+	}
+
+	expr, err := expand(s.Expression())
+	if err != nil || expr == selectNone || len(candidates) == 0 {
+		return nil, err
+	}
+
+	anyCandidate := segment.Ref(candidates[0])
+	if len(candidates) > 1 {
+		refs := make([]*segment.Expression, 0, len(candidates))
+		for _, id := range candidates {
+			refs = append(refs, segment.Ref(id))
+		}
+		anyCandidate = segment.Or(refs...)
+	}
+	if expr == selectAll {
+		return anyCandidate, nil
+	}
+	if len(expr.SegmentIDs()) < len(candidates) {
+		// Keep the candidates of references that dropped out.
+		return segment.And(anyCandidate, expr), nil
+	}
+	return expr, nil
+}
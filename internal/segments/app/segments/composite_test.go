@@ -0,0 +1,175 @@
+package segments_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/rickKoch/nexus/internal/segments/adapters"
+	"github.com/rickKoch/nexus/internal/segments/app/segments"
+	"github.com/rickKoch/nexus/internal/segments/domain/segment"
+	"github.com/rickKoch/nexus/pkg/clock"
+)
+
+func TestParseExpression(t *testing.T) {
+	tests := []struct {
+		input     string
+		canonical string
+	}{
+		{"1", "1"},
+		{"1 AND NOT 2", "1 AND NOT 2"},
+		{"1 and not 2", "1 AND NOT 2"},
+		{"1 OR 2 AND 3", "1 OR 2 AND 3"},
+		{"(1 OR 2) AND 3", "(1 OR 2) AND 3"},
+		{"((1 AND 2) AND 3)", "1 AND 2 AND 3"},
+		{"NOT (1 OR 2)", "NOT (1 OR 2)"},
+		{"NOT NOT 1", "NOT NOT 1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			expr, err := segment.ParseExpression(tt.input)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if expr.String() != tt.canonical {
+				t.Errorf("expected '%s', got '%s'", tt.canonical, expr.String())
+			}
+
+			// The canonical form parses back to the same expression.
+			reparsed, err := segment.ParseExpression(expr.String())
+			if err != nil || !reparsed.Equal(expr) {
+				t.Errorf("expected '%s' to round-trip, got %v (%v)", expr, reparsed, err)
+			}
+		})
+	}
+
+	for _, input := range []string{"", "1 AND", "AND 1", "(1 OR 2", "1 2", "0", "-1", "premium"} {
+		t.Run("rejects "+input, func(t *testing.T) {
+			_, err := segment.ParseExpression(input)
+			if !errors.Is(err, segment.ErrInvalidExpression) {
+				t.Errorf("expected %v, got %v", segment.ErrInvalidExpression, err)
+			}
+		})
+	}
+}
+
+func TestExpression_Evaluate(t *testing.T) {
+	// premium (1) AND NOT churn-risk (2), or staff (3).
+	expr, _ := segment.ParseExpression("1 AND NOT 2 OR 3")
+
+	tests := []struct {
+		memberOf []int
+		expected bool
+	}{
+		{[]int{1}, true},
+		{[]int{1, 2}, false},
+		{[]int{1, 2, 3}, true},
+		{[]int{2}, false},
+		{[]int{3}, true},
+		{nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.memberOf), func(t *testing.T) {
+			got := expr.Evaluate(func(id int) bool {
+				for _, m := range tt.memberOf {
+					if m == id {
+						return true
+					}
+				}
+				return false
+			})
+			if got != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestCompositeSegments(t *testing.T) {
+	ctx := context.Background()
+
+	setup := func(t *testing.T) (*adapters.InMemorySegmentRepository, segments.CreateSegmentHandler, segments.UpdateSegmentHandler, segments.DeleteSegmentHandler) {
+		t.Helper()
+
+		repo := adapters.NewInMemorySegmentRepository()
+		createHandler, _ := segments.NewCreateSegmentHandler(repo, clock.System, repo)
+		updateHandler, _ := segments.NewUpdateSegmentHandler(repo, clock.System)
+		deleteHandler, _ := segments.NewDeleteSegmentHandler(repo, clock.System)
+		return repo, createHandler, updateHandler, deleteHandler
+	}
+
+	t.Run("creates composite segment", func(t *testing.T) {
+		_, create, _, _ := setup(t)
+		premium, _ := create.Handle(ctx, segments.CreateSegment{Name: "premium-users"})
+		churn, _ := create.Handle(ctx, segments.CreateSegment{Name: "churn-risk"})
+
+		composite, err := create.Handle(ctx, segments.CreateSegment{
+			Name:       "premium-retained",
+			Expression: fmt.Sprintf("%d and not %d", premium.ID(), churn.ID()),
+		})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if !composite.IsComposite() {
+			t.Fatal("expected a composite segment")
+		}
+		expected := fmt.Sprintf("%d AND NOT %d", premium.ID(), churn.ID())
+		if composite.Expression().String() != expected {
+			t.Errorf("expected expression '%s', got '%s'", expected, composite.Expression())
+		}
+	})
+
+	t.Run("rejects unknown and deleted references", func(t *testing.T) {
+		_, create, _, del := setup(t)
+		gone, _ := create.Handle(ctx, segments.CreateSegment{Name: "gone"})
+		_ = del.Handle(ctx, segments.DeleteSegment{ID: gone.ID()})
+
+		for _, expr := range []string{"999", fmt.Sprint(gone.ID())} {
+			_, err := create.Handle(ctx, segments.CreateSegment{Name: "composite", Expression: expr})
+			if !errors.Is(err, segment.ErrUnknownReference) {
+				t.Errorf("expected %v for '%s', got %v", segment.ErrUnknownReference, expr, err)
+			}
+		}
+	})
+
+	t.Run("rejects reference cycles", func(t *testing.T) {
+		_, create, update, _ := setup(t)
+		a, _ := create.Handle(ctx, segments.CreateSegment{Name: "a"})
+		b, _ := create.Handle(ctx, segments.CreateSegment{Name: "b", Expression: fmt.Sprint(a.ID())})
+		c, _ := create.Handle(ctx, segments.CreateSegment{Name: "c", Expression: fmt.Sprintf("NOT %d", b.ID())})
+
+		// a referencing itself directly, or through c -> b -> a.
+		for _, expr := range []string{fmt.Sprint(a.ID()), fmt.Sprintf("%d OR %d", b.ID(), c.ID())} {
+			_, err := update.Handle(ctx, segments.UpdateSegment{ID: a.ID(), Name: "a", Expression: expr})
+			if !errors.Is(err, segment.ErrReferenceCycle) {
+				t.Errorf("expected %v for '%s', got %v", segment.ErrReferenceCycle, expr, err)
+			}
+		}
+	})
+
+	t.Run("protects referenced segments from deletion", func(t *testing.T) {
+		repo, create, update, del := setup(t)
+		base, _ := create.Handle(ctx, segments.CreateSegment{Name: "base"})
+		composite, _ := create.Handle(ctx, segments.CreateSegment{Name: "composite", Expression: fmt.Sprintf("NOT %d", base.ID())})
+
+		err := del.Handle(ctx, segments.DeleteSegment{ID: base.ID()})
+		if !errors.Is(err, segment.ErrSegmentReferenced) {
+			t.Fatalf("expected %v, got %v", segment.ErrSegmentReferenced, err)
+		}
+		if _, err := repo.Get(ctx, base.ID()); err != nil {
+			t.Errorf("expected the segment to remain, got %v", err)
+		}
+
+		// Once no composite references it, the segment can be deleted.
+		if _, err := update.Handle(ctx, segments.UpdateSegment{ID: composite.ID(), Name: "composite"}); err != nil {
+			t.Fatalf("failed to update composite: %v", err)
+		}
+		if err := del.Handle(ctx, segments.DeleteSegment{ID: base.ID()}); err != nil {
+			t.Errorf("expected no error, got %v", err)
+		}
+	})
+}
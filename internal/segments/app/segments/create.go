@@ -4,14 +4,25 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/rickKoch/nexus/internal/segments/domain/segment"
+	"github.com/rickKoch/nexus/pkg/clock"
 )
 
 // CreateSegment holds the required parameters for creating a segment.
 type CreateSegment struct {
-	Name       string
-	TTLSeconds *int
+	Name        string
+	Description string
+	Labels      segment.Labels
+	TTLSeconds  *int
+	ActiveFrom  *time.Time
+	ActiveUntil *time.Time
+	// Expression makes the segment a composite of the segments it
+	// references, for example "1 AND NOT 2". Empty for a regular segment.
+	Expression string
+	// State is the initial state, draft or active. It defaults to draft.
+	State segment.State
 }
 
 // CreateSegmentHandler defines the interface for creating a segment.
@@ -21,22 +32,41 @@ type CreateSegmentHandler interface {
 
 type createSegmentHandler struct {
 	segmentRepo segment.Repository
+	clock       clock.Clock
+	ids         segment.IDGenerator
 }
 
 // NewCreateSegmentHandler creates a new CreateSegmentHandler.
-func NewCreateSegmentHandler(segmentRepo segment.Repository) (CreateSegmentHandler, error) {
+func NewCreateSegmentHandler(segmentRepo segment.Repository, clk clock.Clock, ids segment.IDGenerator) (CreateSegmentHandler, error) {
 	if segmentRepo == nil {
 		return createSegmentHandler{}, errors.New("segment repository is not provided")
 	}
+	if clk == nil {
+		return createSegmentHandler{}, errors.New("clock is not provided")
+	}
+	if ids == nil {
+		return createSegmentHandler{}, errors.New("ID generator is not provided")
+	}
 
-	return createSegmentHandler{segmentRepo}, nil
+	return createSegmentHandler{segmentRepo, clk, ids}, nil
 }
 
 // Handle creates a new segment.
 func (h createSegmentHandler) Handle(ctx context.Context, props CreateSegment) (*segment.Segment, error) {
+	expr, err := parseExpression(props.Expression)
+	if err != nil {
+		return nil, fmt.Errorf("invalid segment: %w", err)
+	}
+
 	config := segment.SegmentConfig{
-		Name:       props.Name,
-		TTLSeconds: props.TTLSeconds,
+		Name:        props.Name,
+		Description: props.Description,
+		Labels:      props.Labels,
+		TTLSeconds:  props.TTLSeconds,
+		ActiveFrom:  props.ActiveFrom,
+		ActiveUntil: props.ActiveUntil,
+		Expression:  expr,
+		State:       props.State,
 	}
 
 	factory, err := segment.NewFactory(config)
@@ -44,11 +74,27 @@ func (h createSegmentHandler) Handle(ctx context.Context, props CreateSegment) (
 		return nil, fmt.Errorf("failed to create segment factory: %w", err)
 	}
 
-	newSegment := factory.NewSegment()
+	id, err := h.ids.NextID(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate segment ID: %w", err)
+	}
+
+	// The referenced segments must not be deleted before the segment is
+	// stored.
+	var created *segment.Segment
+	err = h.segmentRepo.RunInTransaction(ctx, func(ctx context.Context, repo segment.Repository) error {
+		if err := checkReferences(ctx, repo, id, expr); err != nil {
+			return err
+		}
 
-	created, err := h.segmentRepo.Create(ctx, newSegment)
+		created, err = repo.Create(ctx, factory.NewSegment(id, h.clock.Now()))
+		if err != nil {
+			return fmt.Errorf("failed to create segment: %w", err)
+		}
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to create segment: %w", err)
+		return nil, err
 	}
 
 	return created, nil
@@ -2,15 +2,21 @@ package segments_test
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/rickKoch/nexus/internal/segments/adapters"
 	"github.com/rickKoch/nexus/internal/segments/app/segments"
+	"github.com/rickKoch/nexus/internal/segments/domain/segment"
+	"github.com/rickKoch/nexus/pkg/clock"
 )
 
 func TestCreateSegmentHandler_Handle(t *testing.T) {
 	repo := adapters.NewInMemorySegmentRepository()
-	handler, err := segments.NewCreateSegmentHandler(repo)
+	handler, err := segments.NewCreateSegmentHandler(repo, clock.System, repo)
 	if err != nil {
 		t.Fatalf("failed to create handler: %v", err)
 	}
@@ -54,6 +60,60 @@ func TestCreateSegmentHandler_Handle(t *testing.T) {
 		}
 	})
 
+	t.Run("creates segment with description and labels", func(t *testing.T) {
+		labels := segment.Labels{"team": "growth", "tier": "gold"}
+		created, err := handler.Handle(ctx, segments.CreateSegment{
+			Name:        "labelled-segment",
+			Description: "Customers on the gold plan",
+			Labels:      labels,
+		})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if created.Description() != "Customers on the gold plan" {
+			t.Errorf("expected description to be stored, got '%s'", created.Description())
+		}
+		if !created.Labels().Equal(labels) {
+			t.Errorf("expected labels %v, got %v", labels, created.Labels())
+		}
+
+		// The segment keeps its own copy of the labels.
+		labels["team"] = "core"
+		if created.Labels()["team"] != "growth" {
+			t.Error("expected labels to be copied")
+		}
+	})
+
+	t.Run("fails with invalid metadata", func(t *testing.T) {
+		tooMany := segment.Labels{}
+		for i := 0; i <= segment.MaxLabels; i++ {
+			tooMany[fmt.Sprintf("key-%d", i)] = "value"
+		}
+
+		tests := []struct {
+			name string
+			cmd  segments.CreateSegment
+			err  error
+		}{
+			{"description too long", segments.CreateSegment{Name: "x", Description: strings.Repeat("a", segment.MaxDescriptionLength+1)}, segment.ErrDescriptionTooLong},
+			{"too many labels", segments.CreateSegment{Name: "x", Labels: tooMany}, segment.ErrTooManyLabels},
+			{"uppercase key", segments.CreateSegment{Name: "x", Labels: segment.Labels{"Team": "growth"}}, segment.ErrInvalidLabelKey},
+			{"key with colon", segments.CreateSegment{Name: "x", Labels: segment.Labels{"team:name": "growth"}}, segment.ErrInvalidLabelKey},
+			{"empty key", segments.CreateSegment{Name: "x", Labels: segment.Labels{"": "growth"}}, segment.ErrInvalidLabelKey},
+			{"value too long", segments.CreateSegment{Name: "x", Labels: segment.Labels{"team": strings.Repeat("a", segment.MaxLabelValueLength+1)}}, segment.ErrLabelValueTooLong},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				_, err := handler.Handle(ctx, tt.cmd)
+				if !errors.Is(err, tt.err) {
+					t.Errorf("expected %v, got %v", tt.err, err)
+				}
+			})
+		}
+	})
+
 	t.Run("fails with empty name", func(t *testing.T) {
 		_, err := handler.Handle(ctx, segments.CreateSegment{
 			Name: "",
@@ -76,8 +136,54 @@ func TestCreateSegmentHandler_Handle(t *testing.T) {
 }
 
 func TestNewCreateSegmentHandler_NilRepository(t *testing.T) {
-	_, err := segments.NewCreateSegmentHandler(nil)
+	_, err := segments.NewCreateSegmentHandler(nil, clock.System, adapters.NewInMemorySegmentRepository())
 	if err == nil {
 		t.Error("expected error for nil repository")
 	}
 }
+
+// sequenceIDs hands out IDs starting at next.
+type sequenceIDs struct{ next int }
+
+func (s *sequenceIDs) NextID(ctx context.Context) (int, error) {
+	id := s.next
+	s.next++
+	return id, nil
+}
+
+func TestCreateSegmentHandler_UsesClockAndIDGenerator(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	repo := adapters.NewInMemorySegmentRepository()
+	handler, _ := segments.NewCreateSegmentHandler(repo, clock.NewFake(now), &sequenceIDs{next: 100})
+
+	created, err := handler.Handle(context.Background(), segments.CreateSegment{Name: "deterministic"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if created.ID() != 100 {
+		t.Errorf("expected ID 100, got %d", created.ID())
+	}
+	if !created.CreatedAt().Equal(now) || !created.UpdatedAt().Equal(now) {
+		t.Errorf("expected timestamps %v, got %v and %v", now, created.CreatedAt(), created.UpdatedAt())
+	}
+
+	stored, err := repo.Get(context.Background(), 100)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !stored.CreatedAt().Equal(now) {
+		t.Errorf("expected stored created_at %v, got %v", now, stored.CreatedAt())
+	}
+}
+
+func TestNewCreateSegmentHandler_NilClockOrIDGenerator(t *testing.T) {
+	repo := adapters.NewInMemorySegmentRepository()
+
+	if _, err := segments.NewCreateSegmentHandler(repo, nil, repo); err == nil {
+		t.Error("expected error for nil clock")
+	}
+	if _, err := segments.NewCreateSegmentHandler(repo, clock.System, nil); err == nil {
+		t.Error("expected error for nil ID generator")
+	}
+}
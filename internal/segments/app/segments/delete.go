@@ -6,6 +6,7 @@ import (
 	"fmt"
 
 	"github.com/rickKoch/nexus/internal/segments/domain/segment"
+	"github.com/rickKoch/nexus/pkg/clock"
 )
 
 // DeleteSegment holds the required parameters for deleting a segment.
@@ -20,22 +21,44 @@ type DeleteSegmentHandler interface {
 
 type deleteSegmentHandler struct {
 	segmentRepo segment.Repository
+	clock       clock.Clock
 }
 
 // NewDeleteSegmentHandler creates a new DeleteSegmentHandler.
-func NewDeleteSegmentHandler(segmentRepo segment.Repository) (DeleteSegmentHandler, error) {
+func NewDeleteSegmentHandler(segmentRepo segment.Repository, clk clock.Clock) (DeleteSegmentHandler, error) {
 	if segmentRepo == nil {
 		return deleteSegmentHandler{}, errors.New("segment repository is not provided")
 	}
+	if clk == nil {
+		return deleteSegmentHandler{}, errors.New("clock is not provided")
+	}
 
-	return deleteSegmentHandler{segmentRepo}, nil
+	return deleteSegmentHandler{segmentRepo, clk}, nil
 }
 
-// Handle soft-deletes a segment.
+// Handle soft-deletes a segment. Archived segments and segments referenced by
+// a composite segment cannot be deleted.
 func (h deleteSegmentHandler) Handle(ctx context.Context, props DeleteSegment) error {
-	if err := h.segmentRepo.Delete(ctx, props.ID); err != nil {
-		return fmt.Errorf("failed to delete segment '%d': %w", props.ID, err)
-	}
+	return h.segmentRepo.RunInTransaction(ctx, func(ctx context.Context, repo segment.Repository) error {
+		// Locking the segment first makes segments created concurrently
+		// with a reference to it either visible below or wait for the
+		// deletion and fail.
+		existing, err := repo.Get(ctx, props.ID)
+		if err != nil {
+			return fmt.Errorf("failed to get segment '%d': %w", props.ID, err)
+		}
+
+		if err := checkNotReferenced(ctx, repo, props.ID); err != nil {
+			return err
+		}
+
+		if err := existing.Delete(h.clock.Now()); err != nil {
+			return fmt.Errorf("failed to delete segment '%d': %w", props.ID, err)
+		}
 
-	return nil
+		if err := repo.Delete(ctx, existing); err != nil {
+			return fmt.Errorf("failed to delete segment '%d': %w", props.ID, err)
+		}
+		return nil
+	})
 }
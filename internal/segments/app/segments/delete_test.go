@@ -2,17 +2,22 @@ package segments_test
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/rickKoch/nexus/internal/segments/adapters"
 	"github.com/rickKoch/nexus/internal/segments/app/segments"
+	"github.com/rickKoch/nexus/internal/segments/domain/segment"
+	"github.com/rickKoch/nexus/pkg/clock"
 )
 
 func TestDeleteSegmentHandler_Handle(t *testing.T) {
 	repo := adapters.NewInMemorySegmentRepository()
-	createHandler, _ := segments.NewCreateSegmentHandler(repo)
+	createHandler, _ := segments.NewCreateSegmentHandler(repo, clock.System, repo)
 	getHandler, _ := segments.NewGetSegmentHandler(repo)
-	deleteHandler, err := segments.NewDeleteSegmentHandler(repo)
+	deleteHandler, err := segments.NewDeleteSegmentHandler(repo, clock.System)
 	if err != nil {
 		t.Fatalf("failed to create handler: %v", err)
 	}
@@ -64,8 +69,107 @@ func TestDeleteSegmentHandler_Handle(t *testing.T) {
 }
 
 func TestNewDeleteSegmentHandler_NilRepository(t *testing.T) {
-	_, err := segments.NewDeleteSegmentHandler(nil)
+	_, err := segments.NewDeleteSegmentHandler(nil, clock.System)
 	if err == nil {
 		t.Error("expected error for nil repository")
 	}
 }
+
+// recordingDeleteRepository records the segment passed to Delete, including
+// within transactions.
+type recordingDeleteRepository struct {
+	segment.Repository
+	deleted *segment.Segment
+}
+
+func (r *recordingDeleteRepository) Delete(ctx context.Context, s *segment.Segment) error {
+	r.deleted = s
+	return r.Repository.Delete(ctx, s)
+}
+
+func (r *recordingDeleteRepository) RunInTransaction(ctx context.Context, fn func(ctx context.Context, repo segment.Repository) error) error {
+	return r.Repository.RunInTransaction(ctx, func(ctx context.Context, tx segment.Repository) error {
+		recording := &recordingDeleteRepository{Repository: tx}
+		defer func() { r.deleted = recording.deleted }()
+		return fn(ctx, recording)
+	})
+}
+
+func TestDeleteSegmentHandler_UsesClock(t *testing.T) {
+	created := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	clk := clock.NewFake(created)
+	inMemory := adapters.NewInMemorySegmentRepository()
+	repo := &recordingDeleteRepository{Repository: inMemory}
+	createHandler, _ := segments.NewCreateSegmentHandler(repo, clk, inMemory)
+	deleteHandler, _ := segments.NewDeleteSegmentHandler(repo, clk)
+
+	ctx := context.Background()
+	s, _ := createHandler.Handle(ctx, segments.CreateSegment{Name: "to-delete"})
+
+	clk.Advance(time.Minute)
+	if err := deleteHandler.Handle(ctx, segments.DeleteSegment{ID: s.ID()}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	deletedAt := repo.deleted.DeletedAt()
+	if deletedAt == nil || !deletedAt.Equal(created.Add(time.Minute)) {
+		t.Errorf("expected deleted_at %v, got %v", created.Add(time.Minute), deletedAt)
+	}
+}
+
+// interleavingRepository calls afterListReferencing between listing the
+// segments referencing a segment and the next call, including within
+// transactions.
+type interleavingRepository struct {
+	segment.Repository
+	afterListReferencing func()
+}
+
+func (r *interleavingRepository) ListReferencing(ctx context.Context, id int) ([]segment.Segment, error) {
+	referencing, err := r.Repository.ListReferencing(ctx, id)
+	r.afterListReferencing()
+	return referencing, err
+}
+
+func (r *interleavingRepository) RunInTransaction(ctx context.Context, fn func(ctx context.Context, repo segment.Repository) error) error {
+	return r.Repository.RunInTransaction(ctx, func(ctx context.Context, tx segment.Repository) error {
+		return fn(ctx, &interleavingRepository{tx, r.afterListReferencing})
+	})
+}
+
+func TestDeleteSegmentHandler_ConcurrentReference(t *testing.T) {
+	inMemory := adapters.NewInMemorySegmentRepository()
+	createHandler, _ := segments.NewCreateSegmentHandler(inMemory, clock.System, inMemory)
+	ctx := context.Background()
+
+	referenced, err := createHandler.Handle(ctx, segments.CreateSegment{Name: "referenced"})
+	if err != nil {
+		t.Fatalf("failed to create segment: %v", err)
+	}
+
+	// Create a composite referencing the segment once the deletion checked
+	// for references, giving it a moment to complete unless it must wait.
+	var createErr error
+	created := make(chan struct{})
+	repo := &interleavingRepository{Repository: inMemory, afterListReferencing: func() {
+		go func() {
+			defer close(created)
+			_, createErr = createHandler.Handle(ctx, segments.CreateSegment{Name: "composite", Expression: fmt.Sprint(referenced.ID())})
+		}()
+		select {
+		case <-created:
+		case <-time.After(100 * time.Millisecond):
+		}
+	}}
+	deleteHandler, _ := segments.NewDeleteSegmentHandler(repo, clock.System)
+
+	deleteErr := deleteHandler.Handle(ctx, segments.DeleteSegment{ID: referenced.ID()})
+	<-created
+
+	if deleteErr != nil {
+		t.Fatalf("expected no error deleting, got %v", deleteErr)
+	}
+	if !errors.Is(createErr, segment.ErrUnknownReference) {
+		t.Errorf("expected %v creating a composite of the deleted segment, got %v", segment.ErrUnknownReference, createErr)
+	}
+}
@@ -0,0 +1,209 @@
+package segments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/rickKoch/nexus/internal/segments/domain/segment"
+	"github.com/rickKoch/nexus/pkg/clock"
+)
+
+// MaxOverlapSegments is the maximum number of segments whose overlap is
+// estimated at once. The intersection estimate combines the unions of every
+// subset of the segments, so its error grows quickly with more segments.
+const MaxOverlapSegments = 5
+
+// ErrInvalidOverlap is returned when estimating the overlap of fewer than two
+// or more than MaxOverlapSegments distinct segments.
+var ErrInvalidOverlap = fmt.Errorf("overlap needs between 2 and %d distinct segments", MaxOverlapSegments)
+
+// SegmentEstimate is the estimated number of members of a segment.
+type SegmentEstimate struct {
+	SegmentID      int
+	EstimatedCount uint64
+}
+
+// EstimateSegment holds the segment to estimate the size of.
+type EstimateSegment struct {
+	ID int
+}
+
+// EstimateSegmentHandler defines the interface for estimating the size of a
+// segment.
+type EstimateSegmentHandler interface {
+	Handle(ctx context.Context, query EstimateSegment) (*SegmentEstimate, error)
+}
+
+type estimateSegmentHandler struct {
+	segmentRepo segment.Repository
+	clock       clock.Clock
+}
+
+// NewEstimateSegmentHandler creates a new EstimateSegmentHandler.
+func NewEstimateSegmentHandler(segmentRepo segment.Repository, clk clock.Clock) (EstimateSegmentHandler, error) {
+	if segmentRepo == nil {
+		return estimateSegmentHandler{}, errors.New("segment repository is not provided")
+	}
+	if clk == nil {
+		return estimateSegmentHandler{}, errors.New("clock is not provided")
+	}
+
+	return estimateSegmentHandler{segmentRepo, clk}, nil
+}
+
+// Handle estimates the number of members of a regular segment from its
+// sketch. Members that expired but were not purged yet are still counted.
+func (h estimateSegmentHandler) Handle(ctx context.Context, query EstimateSegment) (*SegmentEstimate, error) {
+	sketch, err := loadSketch(ctx, h.segmentRepo, query.ID, h.clock.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	return &SegmentEstimate{SegmentID: query.ID, EstimatedCount: sketch.Estimate()}, nil
+}
+
+// EstimateOverlap holds the segments to estimate the overlap of.
+type EstimateOverlap struct {
+	IDs []int
+}
+
+// OverlapEstimate is the estimated overlap between segments.
+type OverlapEstimate struct {
+	// Segments are the estimated sizes, in ascending order of segment ID.
+	Segments []SegmentEstimate
+	// Union is the estimated number of members of any of the segments.
+	Union uint64
+	// Intersection is the estimated number of members of all the segments.
+	Intersection uint64
+	// Jaccard is the Jaccard similarity, Intersection divided by Union.
+	Jaccard float64
+}
+
+// EstimateOverlapHandler defines the interface for estimating the overlap
+// between segments.
+type EstimateOverlapHandler interface {
+	Handle(ctx context.Context, query EstimateOverlap) (*OverlapEstimate, error)
+}
+
+type estimateOverlapHandler struct {
+	segmentRepo segment.Repository
+	clock       clock.Clock
+}
+
+// NewEstimateOverlapHandler creates a new EstimateOverlapHandler.
+func NewEstimateOverlapHandler(segmentRepo segment.Repository, clk clock.Clock) (EstimateOverlapHandler, error) {
+	if segmentRepo == nil {
+		return estimateOverlapHandler{}, errors.New("segment repository is not provided")
+	}
+	if clk == nil {
+		return estimateOverlapHandler{}, errors.New("clock is not provided")
+	}
+
+	return estimateOverlapHandler{segmentRepo, clk}, nil
+}
+
+// Handle estimates the union, intersection and Jaccard similarity of regular
+// segments from their sketches. Duplicate IDs are ignored.
+func (h estimateOverlapHandler) Handle(ctx context.Context, query EstimateOverlap) (*OverlapEstimate, error) {
+	ids := slices.Clone(query.IDs)
+	slices.Sort(ids)
+	ids = slices.Compact(ids)
+	if len(ids) < 2 || len(ids) > MaxOverlapSegments {
+		return nil, ErrInvalidOverlap
+	}
+
+	now := h.clock.Now()
+	estimate := &OverlapEstimate{Segments: make([]SegmentEstimate, 0, len(ids))}
+	sketches := make([]*segment.Sketch, 0, len(ids))
+	union := segment.NewSketch()
+	for _, id := range ids {
+		sketch, err := loadSketch(ctx, h.segmentRepo, id, now)
+		if err != nil {
+			return nil, err
+		}
+		sketches = append(sketches, sketch)
+		union.Merge(sketch)
+		estimate.Segments = append(estimate.Segments, SegmentEstimate{SegmentID: id, EstimatedCount: sketch.Estimate()})
+	}
+
+	estimate.Union = union.Estimate()
+	estimate.Intersection = segment.EstimateIntersection(sketches)
+	if estimate.Union > 0 {
+		estimate.Jaccard = float64(estimate.Intersection) / float64(estimate.Union)
+	}
+	return estimate, nil
+}
+
+// loadSketch returns the sketch of a regular segment. A discarded sketch is
+// built from the members live at now, without storing it; storing is left to
+// rebuildSketch, which locks the sketch while rebuilding.
+func loadSketch(ctx context.Context, repo segment.Repository, id int, now time.Time) (*segment.Sketch, error) {
+	s, err := repo.Get(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get segment '%d': %w", id, err)
+	}
+	if s.IsComposite() {
+		return nil, fmt.Errorf("failed to estimate segment '%d': %w", id, segment.ErrCompositeEstimate)
+	}
+
+	sketch, err := repo.GetSketch(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sketch of segment '%d': %w", id, err)
+	}
+	if sketch != nil {
+		return sketch, nil
+	}
+	return buildSketch(ctx, repo, id, now)
+}
+
+// rebuildSketch builds and stores the sketch of a segment whose sketch was
+// discarded. Segments that were deleted, became composite or got a sketch in
+// the meantime are left alone.
+func rebuildSketch(ctx context.Context, repo segment.Repository, id int, now time.Time) error {
+	return repo.RunInTransaction(ctx, func(ctx context.Context, repo segment.Repository) error {
+		sketch, err := repo.GetSketch(ctx, id)
+		if errors.Is(err, segment.ErrSegmentNotFound) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get sketch of segment '%d': %w", id, err)
+		}
+		if sketch != nil {
+			return nil
+		}
+
+		s, err := repo.Get(ctx, id)
+		if err != nil {
+			return fmt.Errorf("failed to get segment '%d': %w", id, err)
+		}
+		if s.IsComposite() {
+			return nil
+		}
+
+		sketch, err = buildSketch(ctx, repo, id, now)
+		if err != nil {
+			return err
+		}
+		if err := repo.SaveSketch(ctx, id, sketch); err != nil {
+			return fmt.Errorf("failed to save sketch of segment '%d': %w", id, err)
+		}
+		return nil
+	})
+}
+
+// buildSketch builds a sketch from the members of a segment live at now.
+func buildSketch(ctx context.Context, repo segment.Repository, id int, now time.Time) (*segment.Sketch, error) {
+	sketch := segment.NewSketch()
+	q := segment.MemberQuery{Expression: segment.Ref(id), At: now}
+	err := repo.StreamMembers(ctx, q, func(memberID string) error {
+		sketch.Add(memberID)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to build sketch of segment '%d': %w", id, err)
+	}
+	return sketch, nil
+}
@@ -0,0 +1,178 @@
+package segments_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/rickKoch/nexus/internal/segments/adapters"
+	"github.com/rickKoch/nexus/internal/segments/app/segments"
+	"github.com/rickKoch/nexus/internal/segments/domain/segment"
+	"github.com/rickKoch/nexus/pkg/clock"
+)
+
+func TestEstimates(t *testing.T) {
+	ctx := context.Background()
+
+	type fixture struct {
+		create   segments.CreateSegmentHandler
+		add      segments.AddMembersHandler
+		remove   segments.RemoveMembersHandler
+		export   segments.ExportMembersHandler
+		estimate segments.EstimateSegmentHandler
+		overlap  segments.EstimateOverlapHandler
+		recorder *segments.SizeHistoryRecorder
+	}
+	setup := func(t *testing.T) fixture {
+		t.Helper()
+
+		clk := clock.NewFake(time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC))
+		repo := adapters.NewInMemorySegmentRepository()
+		create, _ := segments.NewCreateSegmentHandler(repo, clk, repo)
+		add, _ := segments.NewAddMembersHandler(repo, clk)
+		remove, _ := segments.NewRemoveMembersHandler(repo)
+		export, _ := segments.NewExportMembersHandler(repo, clk)
+		estimate, _ := segments.NewEstimateSegmentHandler(repo, clk)
+		overlap, _ := segments.NewEstimateOverlapHandler(repo, clk)
+		recorder, _ := segments.NewSizeHistoryRecorder(repo, clk, time.Hour)
+		return fixture{create, add, remove, export, estimate, overlap, recorder}
+	}
+	// addRange adds the members user-from to user-(to-1) in requests of at
+	// most MaxMembersPerRequest members.
+	addRange := func(t *testing.T, f fixture, segmentID, from, to int) {
+		t.Helper()
+
+		for start := from; start < to; start += segments.MaxMembersPerRequest {
+			ids := make([]string, 0, segments.MaxMembersPerRequest)
+			for i := start; i < min(to, start+segments.MaxMembersPerRequest); i++ {
+				ids = append(ids, fmt.Sprintf("user-%d", i))
+			}
+			if err := f.add.Handle(ctx, segments.AddMembers{SegmentID: segmentID, MemberIDs: ids}); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+		}
+	}
+	// exactMembers returns the exact members of a segment.
+	exactMembers := func(t *testing.T, f fixture, segmentID int) map[string]bool {
+		t.Helper()
+
+		members := map[string]bool{}
+		err := f.export.Handle(ctx, segments.ExportMembers{SegmentID: segmentID}, func(id string) error {
+			members[id] = true
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		return members
+	}
+	// assertWithin fails unless estimate is within tolerance of exact,
+	// relative to base.
+	assertWithin := func(t *testing.T, name string, estimate, exact, base, tolerance float64) {
+		t.Helper()
+
+		if math.Abs(estimate-exact) > tolerance*base {
+			t.Errorf("expected %s to be within %.0f%% of %.0f of %.4g, got %.4g", name, tolerance*100, base, exact, estimate)
+		}
+	}
+
+	t.Run("estimates size and overlap", func(t *testing.T) {
+		f := setup(t)
+		a, _ := f.create.Handle(ctx, segments.CreateSegment{Name: "premium-users", State: segment.StateActive})
+		b, _ := f.create.Handle(ctx, segments.CreateSegment{Name: "newsletter", State: segment.StateActive})
+		addRange(t, f, a.ID(), 0, 20000)
+		addRange(t, f, b.ID(), 15000, 30000)
+
+		membersA, membersB := exactMembers(t, f, a.ID()), exactMembers(t, f, b.ID())
+		var intersection int
+		for id := range membersA {
+			if membersB[id] {
+				intersection++
+			}
+		}
+		union := len(membersA) + len(membersB) - intersection
+
+		for id, exact := range map[int]int{a.ID(): len(membersA), b.ID(): len(membersB)} {
+			estimate, err := f.estimate.Handle(ctx, segments.EstimateSegment{ID: id})
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			assertWithin(t, fmt.Sprintf("size of segment %d", id), float64(estimate.EstimatedCount), float64(exact), float64(exact), 0.03)
+		}
+
+		overlap, err := f.overlap.Handle(ctx, segments.EstimateOverlap{IDs: []int{b.ID(), a.ID(), b.ID()}})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if len(overlap.Segments) != 2 || overlap.Segments[0].SegmentID != a.ID() || overlap.Segments[1].SegmentID != b.ID() {
+			t.Errorf("expected estimates of segments %d and %d, got %+v", a.ID(), b.ID(), overlap.Segments)
+		}
+		assertWithin(t, "union", float64(overlap.Union), float64(union), float64(union), 0.03)
+		assertWithin(t, "intersection", float64(overlap.Intersection), float64(intersection), float64(union), 0.05)
+		exactJaccard := float64(intersection) / float64(union)
+		if math.Abs(overlap.Jaccard-exactJaccard) > 0.02 {
+			t.Errorf("expected Jaccard similarity within 0.02 of %.4f, got %.4f", exactJaccard, overlap.Jaccard)
+		}
+	})
+
+	t.Run("rebuilds sketches after removals", func(t *testing.T) {
+		f := setup(t)
+		s, _ := f.create.Handle(ctx, segments.CreateSegment{Name: "premium-users"})
+		addRange(t, f, s.ID(), 0, 10000)
+
+		removed := make([]string, 0, 5000)
+		for i := range 5000 {
+			removed = append(removed, fmt.Sprintf("user-%d", i))
+		}
+		_ = f.remove.Handle(ctx, segments.RemoveMembers{SegmentID: s.ID(), MemberIDs: removed})
+		// Members added while the sketch awaits its rebuild are counted once
+		// it is rebuilt.
+		addRange(t, f, s.ID(), 10000, 11000)
+
+		// Until the rebuild, estimates are built from the members.
+		estimate, _ := f.estimate.Handle(ctx, segments.EstimateSegment{ID: s.ID()})
+		assertWithin(t, "size before the rebuild", float64(estimate.EstimatedCount), 6000, 6000, 0.03)
+
+		if err := f.recorder.Tick(ctx); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		addRange(t, f, s.ID(), 11000, 12000)
+
+		estimate, _ = f.estimate.Handle(ctx, segments.EstimateSegment{ID: s.ID()})
+		assertWithin(t, "size after the rebuild", float64(estimate.EstimatedCount), 7000, 7000, 0.03)
+	})
+
+	t.Run("rejects invalid estimates", func(t *testing.T) {
+		f := setup(t)
+		s, _ := f.create.Handle(ctx, segments.CreateSegment{Name: "premium-users"})
+		composite, _ := f.create.Handle(ctx, segments.CreateSegment{Name: "composite", Expression: fmt.Sprintf("NOT %d", s.ID())})
+
+		if _, err := f.estimate.Handle(ctx, segments.EstimateSegment{ID: composite.ID()}); !errors.Is(err, segment.ErrCompositeEstimate) {
+			t.Errorf("expected %v, got %v", segment.ErrCompositeEstimate, err)
+		}
+		if _, err := f.estimate.Handle(ctx, segments.EstimateSegment{ID: 999}); !errors.Is(err, segment.ErrSegmentNotFound) {
+			t.Errorf("expected %v, got %v", segment.ErrSegmentNotFound, err)
+		}
+
+		tests := []struct {
+			name     string
+			ids      []int
+			expected error
+		}{
+			{"one segment", []int{s.ID(), s.ID()}, segments.ErrInvalidOverlap},
+			{"too many segments", []int{1, 2, 3, 4, 5, 6}, segments.ErrInvalidOverlap},
+			{"composite segment", []int{s.ID(), composite.ID()}, segment.ErrCompositeEstimate},
+			{"missing segment", []int{s.ID(), 999}, segment.ErrSegmentNotFound},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				if _, err := f.overlap.Handle(ctx, segments.EstimateOverlap{IDs: tt.ids}); !errors.Is(err, tt.expected) {
+					t.Errorf("expected %v, got %v", tt.expected, err)
+				}
+			})
+		}
+	})
+}
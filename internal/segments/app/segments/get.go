@@ -4,12 +4,19 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/rickKoch/nexus/internal/segments/domain/segment"
 )
 
+// ErrHistoryNotRecorded is returned when reading a segment as of a point in
+// time while segment history is not recorded.
+var ErrHistoryNotRecorded = errors.New("segment history is not recorded, enable event sourcing to read past segments")
+
 type GetSegment struct {
 	ID int
+	// AsOf reads the segment as it was at the given time rather than now.
+	AsOf *time.Time
 }
 
 type GetSegmentHandler interface {
@@ -18,17 +25,36 @@ type GetSegmentHandler interface {
 
 type getSegmentHandler struct {
 	segmentRepo segment.Repository
+	history     segment.HistoryReader
 }
 
 func NewGetSegmentHandler(segmentRepo segment.Repository) (GetSegmentHandler, error) {
+	return NewGetSegmentHandlerWithHistory(segmentRepo, nil)
+}
+
+// NewGetSegmentHandlerWithHistory creates a new GetSegmentHandler reading
+// past segments from history. A nil history rejects such reads with
+// ErrHistoryNotRecorded.
+func NewGetSegmentHandlerWithHistory(segmentRepo segment.Repository, history segment.HistoryReader) (GetSegmentHandler, error) {
 	if segmentRepo == nil {
 		return getSegmentHandler{}, errors.New("segment repository is not provided")
 	}
 
-	return getSegmentHandler{segmentRepo}, nil
+	return getSegmentHandler{segmentRepo, history}, nil
 }
 
 func (gs getSegmentHandler) Handle(ctx context.Context, props GetSegment) (*segment.Segment, error) {
+	if props.AsOf != nil {
+		if gs.history == nil {
+			return nil, ErrHistoryNotRecorded
+		}
+		segment, err := gs.history.GetAsOf(ctx, props.ID, *props.AsOf)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get segment '%d' as of %s: %w", props.ID, props.AsOf.Format(time.RFC3339), err)
+		}
+		return segment, nil
+	}
+
 	segment, err := gs.segmentRepo.Get(ctx, props.ID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get segment '%d': %w", props.ID, err)
@@ -2,15 +2,19 @@ package segments_test
 
 import (
 	"context"
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/rickKoch/nexus/internal/segments/adapters"
 	"github.com/rickKoch/nexus/internal/segments/app/segments"
+	"github.com/rickKoch/nexus/internal/segments/domain/segment"
+	"github.com/rickKoch/nexus/pkg/clock"
 )
 
 func TestGetSegmentHandler_Handle(t *testing.T) {
 	repo := adapters.NewInMemorySegmentRepository()
-	createHandler, _ := segments.NewCreateSegmentHandler(repo)
+	createHandler, _ := segments.NewCreateSegmentHandler(repo, clock.System, repo)
 	getHandler, err := segments.NewGetSegmentHandler(repo)
 	if err != nil {
 		t.Fatalf("failed to create handler: %v", err)
@@ -57,3 +61,46 @@ func TestNewGetSegmentHandler_NilRepository(t *testing.T) {
 		t.Error("expected error for nil repository")
 	}
 }
+
+func TestGetSegmentHandler_AsOf(t *testing.T) {
+	ctx := context.Background()
+	repo := adapters.NewInMemorySegmentRepository()
+	createHandler, _ := segments.NewCreateSegmentHandler(repo, clock.System, repo)
+	created, err := createHandler.Handle(ctx, segments.CreateSegment{Name: "premium-users"})
+	if err != nil {
+		t.Fatalf("failed to create segment: %v", err)
+	}
+	asOf := created.CreatedAt().Add(-time.Hour)
+
+	t.Run("rejects past reads without history", func(t *testing.T) {
+		getHandler, _ := segments.NewGetSegmentHandler(repo)
+		_, err := getHandler.Handle(ctx, segments.GetSegment{ID: created.ID(), AsOf: &asOf})
+		if !errors.Is(err, segments.ErrHistoryNotRecorded) {
+			t.Errorf("expected %v, got %v", segments.ErrHistoryNotRecorded, err)
+		}
+	})
+
+	t.Run("reads past segments from history", func(t *testing.T) {
+		history := historyFunc(func(_ context.Context, id int, at time.Time) (*segment.Segment, error) {
+			if id != created.ID() || !at.Equal(asOf) {
+				t.Errorf("expected segment %d as of %s, got %d as of %s", created.ID(), asOf, id, at)
+			}
+			return nil, segment.ErrSegmentNotFound
+		})
+		getHandler, _ := segments.NewGetSegmentHandlerWithHistory(repo, history)
+
+		_, err := getHandler.Handle(ctx, segments.GetSegment{ID: created.ID(), AsOf: &asOf})
+		if !errors.Is(err, segment.ErrSegmentNotFound) {
+			t.Errorf("expected %v, got %v", segment.ErrSegmentNotFound, err)
+		}
+		if got, err := getHandler.Handle(ctx, segments.GetSegment{ID: created.ID()}); err != nil || got.Name() != "premium-users" {
+			t.Errorf("expected the current segment, got %v (%v)", got, err)
+		}
+	})
+}
+
+type historyFunc func(ctx context.Context, id int, at time.Time) (*segment.Segment, error)
+
+func (f historyFunc) GetAsOf(ctx context.Context, id int, at time.Time) (*segment.Segment, error) {
+	return f(ctx, id, at)
+}
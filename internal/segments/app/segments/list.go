@@ -6,6 +6,7 @@ import (
 	"fmt"
 
 	"github.com/rickKoch/nexus/internal/segments/domain/segment"
+	"github.com/rickKoch/nexus/pkg/clock"
 )
 
 const (
@@ -14,10 +15,30 @@ const (
 	MaxPageSize     = 100
 )
 
-// ListSegments contains the pagination parameters for listing segments.
+// Pagination holds the page size limits applied when listing segments.
+type Pagination struct {
+	DefaultPageSize int
+	MaxPageSize     int
+}
+
+// DefaultPagination returns the built-in page size limits.
+func DefaultPagination() Pagination {
+	return Pagination{
+		DefaultPageSize: DefaultPageSize,
+		MaxPageSize:     MaxPageSize,
+	}
+}
+
+// ListSegments contains the pagination parameters for listing segments, an
+// optional label selector made of key:value expressions and optional states
+// to filter by. If Live is set, only the segments whose live flag equals it
+// are listed.
 type ListSegments struct {
 	Page     int
 	PageSize int
+	Labels   []string
+	States   []segment.State
+	Live     *bool
 }
 
 // ListSegmentsResult contains the paginated list of segments.
@@ -36,15 +57,30 @@ type ListSegmentsHandler interface {
 
 type listSegmentsHandler struct {
 	segmentRepo segment.Repository
+	clock       clock.Clock
+	pagination  Pagination
+}
+
+// NewListSegmentsHandler creates a new ListSegmentsHandler with the default
+// pagination limits.
+func NewListSegmentsHandler(segmentRepo segment.Repository, clk clock.Clock) (ListSegmentsHandler, error) {
+	return NewListSegmentsHandlerWithPagination(segmentRepo, clk, DefaultPagination())
 }
 
-// NewListSegmentsHandler creates a new ListSegmentsHandler.
-func NewListSegmentsHandler(segmentRepo segment.Repository) (ListSegmentsHandler, error) {
+// NewListSegmentsHandlerWithPagination creates a new ListSegmentsHandler with
+// the given pagination limits.
+func NewListSegmentsHandlerWithPagination(segmentRepo segment.Repository, clk clock.Clock, pagination Pagination) (ListSegmentsHandler, error) {
 	if segmentRepo == nil {
 		return listSegmentsHandler{}, errors.New("segment repository is not provided")
 	}
+	if clk == nil {
+		return listSegmentsHandler{}, errors.New("clock is not provided")
+	}
+	if pagination.DefaultPageSize < 1 || pagination.MaxPageSize < pagination.DefaultPageSize {
+		return listSegmentsHandler{}, errors.New("invalid pagination limits")
+	}
 
-	return listSegmentsHandler{segmentRepo}, nil
+	return listSegmentsHandler{segmentRepo, clk, pagination}, nil
 }
 
 // Handle returns paginated segments.
@@ -57,15 +93,29 @@ func (h listSegmentsHandler) Handle(ctx context.Context, cmd ListSegments) (*Lis
 
 	pageSize := cmd.PageSize
 	if pageSize < 1 {
-		pageSize = DefaultPageSize
+		pageSize = h.pagination.DefaultPageSize
+	}
+	if pageSize > h.pagination.MaxPageSize {
+		pageSize = h.pagination.MaxPageSize
+	}
+
+	selector, err := segment.ParseLabelSelector(cmd.Labels)
+	if err != nil {
+		return nil, err
 	}
-	if pageSize > MaxPageSize {
-		pageSize = MaxPageSize
+	for _, state := range cmd.States {
+		if err := state.Validate(); err != nil {
+			return nil, err
+		}
 	}
 
 	result, err := h.segmentRepo.List(ctx, segment.ListParams{
 		Page:     page,
 		PageSize: pageSize,
+		Labels:   selector,
+		States:   cmd.States,
+		Live:     cmd.Live,
+		At:       h.clock.Now(),
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to list segments: %w", err)
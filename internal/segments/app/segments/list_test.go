@@ -2,16 +2,21 @@ package segments_test
 
 import (
 	"context"
+	"errors"
+	"slices"
 	"testing"
+	"time"
 
 	"github.com/rickKoch/nexus/internal/segments/adapters"
 	"github.com/rickKoch/nexus/internal/segments/app/segments"
+	"github.com/rickKoch/nexus/internal/segments/domain/segment"
+	"github.com/rickKoch/nexus/pkg/clock"
 )
 
 func TestListSegmentsHandler_Handle(t *testing.T) {
 	repo := adapters.NewInMemorySegmentRepository()
-	createHandler, _ := segments.NewCreateSegmentHandler(repo)
-	listHandler, err := segments.NewListSegmentsHandler(repo)
+	createHandler, _ := segments.NewCreateSegmentHandler(repo, clock.System, repo)
+	listHandler, err := segments.NewListSegmentsHandler(repo, clock.System)
 	if err != nil {
 		t.Fatalf("failed to create handler: %v", err)
 	}
@@ -21,7 +26,7 @@ func TestListSegmentsHandler_Handle(t *testing.T) {
 	t.Run("returns empty list when no segments", func(t *testing.T) {
 		// Use a fresh repository
 		freshRepo := adapters.NewInMemorySegmentRepository()
-		freshListHandler, _ := segments.NewListSegmentsHandler(freshRepo)
+		freshListHandler, _ := segments.NewListSegmentsHandler(freshRepo, clock.System)
 
 		result, err := freshListHandler.Handle(ctx, segments.ListSegments{})
 		if err != nil {
@@ -60,9 +65,9 @@ func TestListSegmentsHandler_Handle(t *testing.T) {
 
 	t.Run("does not return deleted segments", func(t *testing.T) {
 		freshRepo := adapters.NewInMemorySegmentRepository()
-		freshCreateHandler, _ := segments.NewCreateSegmentHandler(freshRepo)
-		freshListHandler, _ := segments.NewListSegmentsHandler(freshRepo)
-		deleteHandler, _ := segments.NewDeleteSegmentHandler(freshRepo)
+		freshCreateHandler, _ := segments.NewCreateSegmentHandler(freshRepo, clock.System, freshRepo)
+		freshListHandler, _ := segments.NewListSegmentsHandler(freshRepo, clock.System)
+		deleteHandler, _ := segments.NewDeleteSegmentHandler(freshRepo, clock.System)
 
 		// Create and delete a segment
 		created, _ := freshCreateHandler.Handle(ctx, segments.CreateSegment{Name: "to-be-deleted"})
@@ -87,8 +92,8 @@ func TestListSegmentsHandler_Handle(t *testing.T) {
 
 	t.Run("respects pagination parameters", func(t *testing.T) {
 		freshRepo := adapters.NewInMemorySegmentRepository()
-		freshCreateHandler, _ := segments.NewCreateSegmentHandler(freshRepo)
-		freshListHandler, _ := segments.NewListSegmentsHandler(freshRepo)
+		freshCreateHandler, _ := segments.NewCreateSegmentHandler(freshRepo, clock.System, freshRepo)
+		freshListHandler, _ := segments.NewListSegmentsHandler(freshRepo, clock.System)
 
 		// Create 5 segments
 		for i := 1; i <= 5; i++ {
@@ -134,7 +139,7 @@ func TestListSegmentsHandler_Handle(t *testing.T) {
 
 	t.Run("applies default pagination when not specified", func(t *testing.T) {
 		freshRepo := adapters.NewInMemorySegmentRepository()
-		freshListHandler, _ := segments.NewListSegmentsHandler(freshRepo)
+		freshListHandler, _ := segments.NewListSegmentsHandler(freshRepo, clock.System)
 
 		result, err := freshListHandler.Handle(ctx, segments.ListSegments{})
 		if err != nil {
@@ -152,7 +157,7 @@ func TestListSegmentsHandler_Handle(t *testing.T) {
 
 	t.Run("enforces max page size", func(t *testing.T) {
 		freshRepo := adapters.NewInMemorySegmentRepository()
-		freshListHandler, _ := segments.NewListSegmentsHandler(freshRepo)
+		freshListHandler, _ := segments.NewListSegmentsHandler(freshRepo, clock.System)
 
 		result, err := freshListHandler.Handle(ctx, segments.ListSegments{PageSize: 500})
 		if err != nil {
@@ -165,8 +170,157 @@ func TestListSegmentsHandler_Handle(t *testing.T) {
 	})
 }
 
+func TestListSegmentsHandler_LabelSelector(t *testing.T) {
+	repo := adapters.NewInMemorySegmentRepository()
+	createHandler, _ := segments.NewCreateSegmentHandler(repo, clock.System, repo)
+	listHandler, _ := segments.NewListSegmentsHandler(repo, clock.System)
+
+	ctx := context.Background()
+
+	for _, cmd := range []segments.CreateSegment{
+		{Name: "growth-gold", Labels: segment.Labels{"team": "growth", "tier": "gold"}},
+		{Name: "growth-free", Labels: segment.Labels{"team": "growth", "tier": "free"}},
+		{Name: "core", Labels: segment.Labels{"team": "core"}},
+		{Name: "unlabelled"},
+	} {
+		if _, err := createHandler.Handle(ctx, cmd); err != nil {
+			t.Fatalf("failed to create segment: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		selector []string
+		expected []string
+	}{
+		{"no selector", nil, []string{"growth-gold", "growth-free", "core", "unlabelled"}},
+		{"single label", []string{"team:growth"}, []string{"growth-gold", "growth-free"}},
+		{"all labels must match", []string{"team:growth", "tier:gold"}, []string{"growth-gold"}},
+		{"no match", []string{"team:sales"}, nil},
+		{"empty value", []string{"team:"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := listHandler.Handle(ctx, segments.ListSegments{Labels: tt.selector})
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			if result.TotalCount != len(tt.expected) {
+				t.Fatalf("expected %d segments, got %d", len(tt.expected), result.TotalCount)
+			}
+			for i, name := range tt.expected {
+				if result.Segments[i].Name() != name {
+					t.Errorf("expected segment %d to be '%s', got '%s'", i, name, result.Segments[i].Name())
+				}
+			}
+		})
+	}
+
+	t.Run("rejects malformed selectors", func(t *testing.T) {
+		for _, selector := range [][]string{{"team"}, {":growth"}, {"team:growth", "team:core"}} {
+			_, err := listHandler.Handle(ctx, segments.ListSegments{Labels: selector})
+			if !errors.Is(err, segment.ErrInvalidLabelSelector) {
+				t.Errorf("expected %v for %v, got %v", segment.ErrInvalidLabelSelector, selector, err)
+			}
+		}
+	})
+}
+
+func TestListSegmentsHandler_StateFilter(t *testing.T) {
+	repo := adapters.NewInMemorySegmentRepository()
+	createHandler, _ := segments.NewCreateSegmentHandler(repo, clock.System, repo)
+	transitionHandler, _ := segments.NewTransitionSegmentHandler(repo, clock.System)
+	listHandler, _ := segments.NewListSegmentsHandler(repo, clock.System)
+
+	ctx := context.Background()
+
+	_, _ = createHandler.Handle(ctx, segments.CreateSegment{Name: "draft"})
+	_, _ = createHandler.Handle(ctx, segments.CreateSegment{Name: "active", State: segment.StateActive})
+	paused, _ := createHandler.Handle(ctx, segments.CreateSegment{Name: "paused", State: segment.StateActive})
+	if _, err := transitionHandler.Handle(ctx, segments.TransitionSegment{ID: paused.ID(), State: segment.StatePaused}); err != nil {
+		t.Fatalf("failed to pause segment: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		states   []segment.State
+		expected []string
+	}{
+		{"no filter", nil, []string{"draft", "active", "paused"}},
+		{"single state", []segment.State{segment.StateActive}, []string{"active"}},
+		{"any of several states", []segment.State{segment.StateDraft, segment.StatePaused}, []string{"draft", "paused"}},
+		{"no match", []segment.State{segment.StateArchived}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := listHandler.Handle(ctx, segments.ListSegments{States: tt.states})
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			if result.TotalCount != len(tt.expected) {
+				t.Fatalf("expected %d segments, got %d", len(tt.expected), result.TotalCount)
+			}
+			for i, name := range tt.expected {
+				if result.Segments[i].Name() != name {
+					t.Errorf("expected segment %d to be '%s', got '%s'", i, name, result.Segments[i].Name())
+				}
+			}
+		})
+	}
+
+	t.Run("rejects unknown states", func(t *testing.T) {
+		_, err := listHandler.Handle(ctx, segments.ListSegments{States: []segment.State{"deleted"}})
+		if !errors.Is(err, segment.ErrInvalidState) {
+			t.Errorf("expected %v, got %v", segment.ErrInvalidState, err)
+		}
+	})
+}
+
+func TestListSegmentsHandler_LiveFilter(t *testing.T) {
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	clk := clock.NewFake(start)
+	repo := adapters.NewInMemorySegmentRepository()
+	createHandler, _ := segments.NewCreateSegmentHandler(repo, clk, repo)
+	listHandler, _ := segments.NewListSegmentsHandler(repo, clk)
+
+	ctx := context.Background()
+	until := start.Add(time.Hour)
+	_, _ = createHandler.Handle(ctx, segments.CreateSegment{Name: "draft"})
+	_, _ = createHandler.Handle(ctx, segments.CreateSegment{Name: "ending", State: segment.StateActive, ActiveUntil: &until})
+
+	names := func(t *testing.T, live bool) []string {
+		t.Helper()
+
+		result, err := listHandler.Handle(ctx, segments.ListSegments{Live: &live})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		var names []string
+		for _, s := range result.Segments {
+			names = append(names, s.Name())
+		}
+		return names
+	}
+
+	if got := names(t, true); !slices.Equal(got, []string{"ending"}) {
+		t.Errorf("expected the segment inside its window to be live, got %v", got)
+	}
+	if got := names(t, false); !slices.Equal(got, []string{"draft"}) {
+		t.Errorf("expected the draft not to be live, got %v", got)
+	}
+
+	clk.Advance(time.Hour)
+	if got := names(t, true); len(got) != 0 {
+		t.Errorf("expected no live segments once the window ended, got %v", got)
+	}
+}
+
 func TestNewListSegmentsHandler_NilRepository(t *testing.T) {
-	_, err := segments.NewListSegmentsHandler(nil)
+	_, err := segments.NewListSegmentsHandler(nil, clock.System)
 	if err == nil {
 		t.Error("expected error for nil repository")
 	}
@@ -0,0 +1,300 @@
+package segments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/rickKoch/nexus/internal/segments/domain/segment"
+	"github.com/rickKoch/nexus/pkg/clock"
+)
+
+// MaxMembersPerRequest is the maximum number of members added or removed at once.
+const MaxMembersPerRequest = 10000
+
+var (
+	// ErrEmptyMembers is returned when adding or removing no members.
+	ErrEmptyMembers = errors.New("at least one member is required")
+	// ErrTooManyMembers is returned when adding or removing more than
+	// MaxMembersPerRequest members.
+	ErrTooManyMembers = fmt.Errorf("at most %d members can be changed at once", MaxMembersPerRequest)
+)
+
+// AddMembers holds the segment to add members to and the member IDs.
+type AddMembers struct {
+	SegmentID int
+	MemberIDs []string
+}
+
+// AddMembersHandler defines the interface for adding members to a segment.
+type AddMembersHandler interface {
+	Handle(ctx context.Context, cmd AddMembers) error
+}
+
+type addMembersHandler struct {
+	segmentRepo segment.Repository
+	clock       clock.Clock
+}
+
+// NewAddMembersHandler creates a new AddMembersHandler.
+func NewAddMembersHandler(segmentRepo segment.Repository, clk clock.Clock) (AddMembersHandler, error) {
+	if segmentRepo == nil {
+		return addMembersHandler{}, errors.New("segment repository is not provided")
+	}
+	if clk == nil {
+		return addMembersHandler{}, errors.New("clock is not provided")
+	}
+
+	return addMembersHandler{segmentRepo, clk}, nil
+}
+
+// Handle adds the members to the segment and its sketch. Existing members
+// are re-added, which restarts their TTL.
+func (h addMembersHandler) Handle(ctx context.Context, cmd AddMembers) error {
+	ids, err := uniqueMemberIDs(cmd.MemberIDs)
+	if err != nil {
+		return err
+	}
+
+	return h.segmentRepo.RunInTransaction(ctx, func(ctx context.Context, repo segment.Repository) error {
+		// Reading the sketch first locks it, so the segment read next is
+		// not changed by concurrent membership writes.
+		sketch, err := repo.GetSketch(ctx, cmd.SegmentID)
+		if err != nil {
+			return fmt.Errorf("failed to get sketch of segment '%d': %w", cmd.SegmentID, err)
+		}
+
+		s, err := repo.Get(ctx, cmd.SegmentID)
+		if err != nil {
+			return fmt.Errorf("failed to get segment '%d': %w", cmd.SegmentID, err)
+		}
+
+		now := h.clock.Now()
+		members := make([]segment.Member, 0, len(ids))
+		for _, id := range ids {
+			m, err := s.NewMember(id, now)
+			if err != nil {
+				return fmt.Errorf("failed to add member to segment '%d': %w", cmd.SegmentID, err)
+			}
+			members = append(members, m)
+		}
+
+		if err := repo.AddMembers(ctx, cmd.SegmentID, members); err != nil {
+			return fmt.Errorf("failed to add members to segment '%d': %w", cmd.SegmentID, err)
+		}
+
+		// A discarded sketch is left to be rebuilt, unless the segment had no
+		// members it could be missing.
+		if sketch == nil && s.MemberCount() == 0 {
+			sketch = segment.NewSketch()
+		}
+		if sketch == nil {
+			return nil
+		}
+		for _, id := range ids {
+			sketch.Add(id)
+		}
+		if err := repo.SaveSketch(ctx, cmd.SegmentID, sketch); err != nil {
+			return fmt.Errorf("failed to save sketch of segment '%d': %w", cmd.SegmentID, err)
+		}
+		return nil
+	})
+}
+
+// RemoveMembers holds the segment to remove members from and the member IDs.
+type RemoveMembers struct {
+	SegmentID int
+	MemberIDs []string
+}
+
+// RemoveMembersHandler defines the interface for removing members from a segment.
+type RemoveMembersHandler interface {
+	Handle(ctx context.Context, cmd RemoveMembers) error
+}
+
+type removeMembersHandler struct {
+	segmentRepo segment.Repository
+}
+
+// NewRemoveMembersHandler creates a new RemoveMembersHandler.
+func NewRemoveMembersHandler(segmentRepo segment.Repository) (RemoveMembersHandler, error) {
+	if segmentRepo == nil {
+		return removeMembersHandler{}, errors.New("segment repository is not provided")
+	}
+
+	return removeMembersHandler{segmentRepo}, nil
+}
+
+// Handle removes the members from the segment. IDs that are not members are
+// ignored.
+func (h removeMembersHandler) Handle(ctx context.Context, cmd RemoveMembers) error {
+	ids, err := uniqueMemberIDs(cmd.MemberIDs)
+	if err != nil {
+		return err
+	}
+
+	return h.segmentRepo.RunInTransaction(ctx, func(ctx context.Context, repo segment.Repository) error {
+		s, err := repo.Get(ctx, cmd.SegmentID)
+		if err != nil {
+			return fmt.Errorf("failed to get segment '%d': %w", cmd.SegmentID, err)
+		}
+		if err := s.CanRemoveMembers(); err != nil {
+			return fmt.Errorf("failed to remove members from segment '%d': %w", cmd.SegmentID, err)
+		}
+
+		if err := repo.RemoveMembers(ctx, cmd.SegmentID, ids); err != nil {
+			return fmt.Errorf("failed to remove members from segment '%d': %w", cmd.SegmentID, err)
+		}
+		return nil
+	})
+}
+
+// ExportMembers holds the segment to export and the cursor to resume from.
+type ExportMembers struct {
+	SegmentID int
+	// After is the last member ID already received. Export resumes with the
+	// next member; empty starts from the beginning.
+	After string
+}
+
+// ExportMembersHandler defines the interface for streaming the members of a
+// segment.
+type ExportMembersHandler interface {
+	// Handle calls fn for every live member in ascending ID order. It stops
+	// at the first error fn returns.
+	Handle(ctx context.Context, cmd ExportMembers, fn func(memberID string) error) error
+}
+
+type exportMembersHandler struct {
+	segmentRepo segment.Repository
+	clock       clock.Clock
+}
+
+// NewExportMembersHandler creates a new ExportMembersHandler.
+func NewExportMembersHandler(segmentRepo segment.Repository, clk clock.Clock) (ExportMembersHandler, error) {
+	if segmentRepo == nil {
+		return exportMembersHandler{}, errors.New("segment repository is not provided")
+	}
+	if clk == nil {
+		return exportMembersHandler{}, errors.New("clock is not provided")
+	}
+
+	return exportMembersHandler{segmentRepo, clk}, nil
+}
+
+// Handle streams the members of the segment. The members of a composite
+// segment are resolved from the members of the segments it references. A
+// segment that is not matchable, such as a paused one, has no members.
+func (h exportMembersHandler) Handle(ctx context.Context, cmd ExportMembers, fn func(memberID string) error) error {
+	s, err := h.segmentRepo.Get(ctx, cmd.SegmentID)
+	if err != nil {
+		return fmt.Errorf("failed to get segment '%d': %w", cmd.SegmentID, err)
+	}
+
+	now := h.clock.Now()
+	expr, err := expandExpression(ctx, h.segmentRepo, s, now)
+	if err != nil {
+		return fmt.Errorf("failed to resolve segment '%d': %w", cmd.SegmentID, err)
+	}
+	if expr == nil {
+		return nil
+	}
+
+	q := segment.MemberQuery{Expression: expr, At: now, After: cmd.After}
+	return h.segmentRepo.StreamMembers(ctx, q, fn)
+}
+
+// Membership reports whether a subject is a member of a segment.
+type Membership struct {
+	SegmentID int
+	MemberID  string
+	IsMember  bool
+}
+
+// CheckMembership holds the segment and the subject to check.
+type CheckMembership struct {
+	SegmentID int
+	MemberID  string
+}
+
+// CheckMembershipHandler defines the interface for checking whether a subject
+// is a member of a segment.
+type CheckMembershipHandler interface {
+	Handle(ctx context.Context, query CheckMembership) (*Membership, error)
+}
+
+type checkMembershipHandler struct {
+	segmentRepo segment.Repository
+	clock       clock.Clock
+}
+
+// NewCheckMembershipHandler creates a new CheckMembershipHandler.
+func NewCheckMembershipHandler(segmentRepo segment.Repository, clk clock.Clock) (CheckMembershipHandler, error) {
+	if segmentRepo == nil {
+		return checkMembershipHandler{}, errors.New("segment repository is not provided")
+	}
+	if clk == nil {
+		return checkMembershipHandler{}, errors.New("clock is not provided")
+	}
+
+	return checkMembershipHandler{segmentRepo, clk}, nil
+}
+
+// Handle checks the subject's live memberships of the segments the segment's
+// expression references. Like an export, a composite segment only includes
+// subjects that are live members of at least one of them, and a segment that
+// is not matchable has no members.
+func (h checkMembershipHandler) Handle(ctx context.Context, query CheckMembership) (*Membership, error) {
+	if err := segment.ValidateMemberID(query.MemberID); err != nil {
+		return nil, err
+	}
+
+	s, err := h.segmentRepo.Get(ctx, query.SegmentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get segment '%d': %w", query.SegmentID, err)
+	}
+
+	now := h.clock.Now()
+	expr, err := expandExpression(ctx, h.segmentRepo, s, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve segment '%d': %w", query.SegmentID, err)
+	}
+	if expr == nil {
+		return &Membership{SegmentID: query.SegmentID, MemberID: query.MemberID}, nil
+	}
+
+	ids, err := h.segmentRepo.ListMemberships(ctx, query.MemberID, expr.SegmentIDs(), now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check membership of segment '%d': %w", query.SegmentID, err)
+	}
+
+	isMember := len(ids) > 0 && expr.Evaluate(func(segmentID int) bool {
+		return slices.Contains(ids, segmentID)
+	})
+	return &Membership{SegmentID: query.SegmentID, MemberID: query.MemberID, IsMember: isMember}, nil
+}
+
+// uniqueMemberIDs validates ids and drops duplicates, keeping the first
+// occurrence of each.
+func uniqueMemberIDs(ids []string) ([]string, error) {
+	if len(ids) == 0 {
+		return nil, ErrEmptyMembers
+	}
+	if len(ids) > MaxMembersPerRequest {
+		return nil, ErrTooManyMembers
+	}
+
+	seen := make(map[string]bool, len(ids))
+	unique := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if err := segment.ValidateMemberID(id); err != nil {
+			return nil, err
+		}
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique, nil
+}
@@ -0,0 +1,315 @@
+package segments_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/rickKoch/nexus/internal/segments/adapters"
+	"github.com/rickKoch/nexus/internal/segments/app/segments"
+	"github.com/rickKoch/nexus/internal/segments/domain/segment"
+	"github.com/rickKoch/nexus/pkg/clock"
+)
+
+func TestMembers(t *testing.T) {
+	ctx := context.Background()
+
+	type handlers struct {
+		create     segments.CreateSegmentHandler
+		add        segments.AddMembersHandler
+		remove     segments.RemoveMembersHandler
+		export     segments.ExportMembersHandler
+		check      segments.CheckMembershipHandler
+		transition segments.TransitionSegmentHandler
+	}
+	setup := func(t *testing.T, clk clock.Clock) handlers {
+		t.Helper()
+
+		repo := adapters.NewInMemorySegmentRepository()
+		create, _ := segments.NewCreateSegmentHandler(repo, clk, repo)
+		add, _ := segments.NewAddMembersHandler(repo, clk)
+		remove, _ := segments.NewRemoveMembersHandler(repo)
+		export, _ := segments.NewExportMembersHandler(repo, clk)
+		check, _ := segments.NewCheckMembershipHandler(repo, clk)
+		transition, _ := segments.NewTransitionSegmentHandler(repo, clk)
+		return handlers{create, add, remove, export, check, transition}
+	}
+	collect := func(t *testing.T, h handlers, cmd segments.ExportMembers) []string {
+		t.Helper()
+
+		var ids []string
+		err := h.export.Handle(ctx, cmd, func(id string) error {
+			ids = append(ids, id)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		return ids
+	}
+
+	t.Run("exports members in order", func(t *testing.T) {
+		h := setup(t, clock.System)
+		s, _ := h.create.Handle(ctx, segments.CreateSegment{Name: "premium-users", State: segment.StateActive})
+
+		if err := h.add.Handle(ctx, segments.AddMembers{SegmentID: s.ID(), MemberIDs: []string{"c", "a", "b", "a"}}); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if err := h.remove.Handle(ctx, segments.RemoveMembers{SegmentID: s.ID(), MemberIDs: []string{"b", "z"}}); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if ids := collect(t, h, segments.ExportMembers{SegmentID: s.ID()}); !reflect.DeepEqual(ids, []string{"a", "c"}) {
+			t.Errorf("expected [a c], got %v", ids)
+		}
+		if ids := collect(t, h, segments.ExportMembers{SegmentID: s.ID(), After: "a"}); !reflect.DeepEqual(ids, []string{"c"}) {
+			t.Errorf("expected [c] after 'a', got %v", ids)
+		}
+	})
+
+	t.Run("skips expired members", func(t *testing.T) {
+		clk := clock.NewFake(time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC))
+		h := setup(t, clk)
+		ttl := 60
+		s, _ := h.create.Handle(ctx, segments.CreateSegment{Name: "recent-visitors", TTLSeconds: &ttl, State: segment.StateActive})
+
+		_ = h.add.Handle(ctx, segments.AddMembers{SegmentID: s.ID(), MemberIDs: []string{"a"}})
+		clk.Advance(30 * time.Second)
+		_ = h.add.Handle(ctx, segments.AddMembers{SegmentID: s.ID(), MemberIDs: []string{"b"}})
+		clk.Advance(30 * time.Second)
+
+		if ids := collect(t, h, segments.ExportMembers{SegmentID: s.ID()}); !reflect.DeepEqual(ids, []string{"b"}) {
+			t.Errorf("expected [b], got %v", ids)
+		}
+	})
+
+	t.Run("resolves composite members", func(t *testing.T) {
+		h := setup(t, clock.System)
+		premium, _ := h.create.Handle(ctx, segments.CreateSegment{Name: "premium-users", State: segment.StateActive})
+		churn, _ := h.create.Handle(ctx, segments.CreateSegment{Name: "churn-risk", State: segment.StateActive})
+		staff, _ := h.create.Handle(ctx, segments.CreateSegment{Name: "staff", State: segment.StateActive})
+		retained, _ := h.create.Handle(ctx, segments.CreateSegment{
+			Name:       "premium-retained",
+			Expression: fmt.Sprintf("%d AND NOT %d", premium.ID(), churn.ID()),
+			State:      segment.StateActive,
+		})
+		nested, _ := h.create.Handle(ctx, segments.CreateSegment{
+			Name:       "retained-or-staff",
+			Expression: fmt.Sprintf("%d OR %d", retained.ID(), staff.ID()),
+			State:      segment.StateActive,
+		})
+
+		_ = h.add.Handle(ctx, segments.AddMembers{SegmentID: premium.ID(), MemberIDs: []string{"a", "b", "c"}})
+		_ = h.add.Handle(ctx, segments.AddMembers{SegmentID: churn.ID(), MemberIDs: []string{"b", "d"}})
+		_ = h.add.Handle(ctx, segments.AddMembers{SegmentID: staff.ID(), MemberIDs: []string{"b", "e"}})
+
+		if ids := collect(t, h, segments.ExportMembers{SegmentID: retained.ID()}); !reflect.DeepEqual(ids, []string{"a", "c"}) {
+			t.Errorf("expected [a c], got %v", ids)
+		}
+		if ids := collect(t, h, segments.ExportMembers{SegmentID: nested.ID()}); !reflect.DeepEqual(ids, []string{"a", "b", "c", "e"}) {
+			t.Errorf("expected [a b c e], got %v", ids)
+		}
+	})
+
+	t.Run("checks membership", func(t *testing.T) {
+		clk := clock.NewFake(time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC))
+		h := setup(t, clk)
+		ttl := 60
+		premium, _ := h.create.Handle(ctx, segments.CreateSegment{Name: "premium-users", State: segment.StateActive})
+		visitors, _ := h.create.Handle(ctx, segments.CreateSegment{Name: "recent-visitors", TTLSeconds: &ttl, State: segment.StateActive})
+		excluded, _ := h.create.Handle(ctx, segments.CreateSegment{
+			Name:       "premium-not-visited",
+			Expression: fmt.Sprintf("%d AND NOT %d", premium.ID(), visitors.ID()),
+			State:      segment.StateActive,
+		})
+		notVisited, _ := h.create.Handle(ctx, segments.CreateSegment{Name: "not-visited", Expression: fmt.Sprintf("NOT %d", visitors.ID()), State: segment.StateActive})
+
+		_ = h.add.Handle(ctx, segments.AddMembers{SegmentID: premium.ID(), MemberIDs: []string{"a", "b"}})
+		_ = h.add.Handle(ctx, segments.AddMembers{SegmentID: visitors.ID(), MemberIDs: []string{"b"}})
+
+		tests := []struct {
+			name      string
+			segmentID int
+			memberID  string
+			expected  bool
+		}{
+			{"member", premium.ID(), "a", true},
+			{"not a member", premium.ID(), "c", false},
+			{"composite member", excluded.ID(), "a", true},
+			{"excluded by composite", excluded.ID(), "b", false},
+			{"member of no referenced segment", notVisited.ID(), "c", false},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				m, err := h.check.Handle(ctx, segments.CheckMembership{SegmentID: tt.segmentID, MemberID: tt.memberID})
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				if m.IsMember != tt.expected {
+					t.Errorf("expected membership %v, got %v", tt.expected, m.IsMember)
+				}
+			})
+		}
+
+		clk.Advance(time.Minute)
+		m, _ := h.check.Handle(ctx, segments.CheckMembership{SegmentID: excluded.ID(), MemberID: "b"})
+		if !m.IsMember {
+			t.Error("expected the expired membership to no longer exclude the member")
+		}
+
+		if _, err := h.check.Handle(ctx, segments.CheckMembership{SegmentID: premium.ID()}); !errors.Is(err, segment.ErrInvalidMemberID) {
+			t.Errorf("expected %v, got %v", segment.ErrInvalidMemberID, err)
+		}
+		if _, err := h.check.Handle(ctx, segments.CheckMembership{SegmentID: 999, MemberID: "a"}); !errors.Is(err, segment.ErrSegmentNotFound) {
+			t.Errorf("expected %v, got %v", segment.ErrSegmentNotFound, err)
+		}
+	})
+
+	t.Run("ignores segments that are not matchable", func(t *testing.T) {
+		clk := clock.NewFake(time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC))
+		h := setup(t, clk)
+		from := clk.Now().Add(time.Hour)
+		premium, _ := h.create.Handle(ctx, segments.CreateSegment{Name: "premium-users", State: segment.StateActive})
+		draft, _ := h.create.Handle(ctx, segments.CreateSegment{Name: "draft"})
+		paused, _ := h.create.Handle(ctx, segments.CreateSegment{Name: "paused", State: segment.StateActive})
+		upcoming, _ := h.create.Handle(ctx, segments.CreateSegment{Name: "upcoming", State: segment.StateActive, ActiveFrom: &from})
+		visitors, _ := h.create.Handle(ctx, segments.CreateSegment{Name: "visitors", State: segment.StateActive})
+		for _, s := range []*segment.Segment{premium, draft, paused, upcoming, visitors} {
+			_ = h.add.Handle(ctx, segments.AddMembers{SegmentID: s.ID(), MemberIDs: []string{"a"}})
+		}
+		_ = h.add.Handle(ctx, segments.AddMembers{SegmentID: premium.ID(), MemberIDs: []string{"b"}})
+		if _, err := h.transition.Handle(ctx, segments.TransitionSegment{ID: paused.ID(), State: segment.StatePaused}); err != nil {
+			t.Fatalf("failed to pause segment: %v", err)
+		}
+
+		composite := func(expression string, state segment.State) *segment.Segment {
+			s, err := h.create.Handle(ctx, segments.CreateSegment{Name: "composite", Expression: expression, State: state})
+			if err != nil {
+				t.Fatalf("failed to create composite segment: %v", err)
+			}
+			return s
+		}
+
+		tests := []struct {
+			name     string
+			segment  *segment.Segment
+			expected []string
+		}{
+			{"active", premium, []string{"a", "b"}},
+			{"draft", draft, nil},
+			{"paused", paused, nil},
+			{"outside window", upcoming, nil},
+			{"draft composite", composite(fmt.Sprint(premium.ID()), segment.StateDraft), nil},
+			{"composite of paused", composite(fmt.Sprint(paused.ID()), segment.StateActive), nil},
+			{"union with paused", composite(fmt.Sprintf("%d OR %d", premium.ID(), paused.ID()), segment.StateActive), []string{"a", "b"}},
+			{"intersection with paused", composite(fmt.Sprintf("%d AND %d", premium.ID(), paused.ID()), segment.StateActive), nil},
+			{"excluding paused", composite(fmt.Sprintf("%d AND NOT %d", premium.ID(), paused.ID()), segment.StateActive), []string{"a", "b"}},
+			{"complement of paused", composite(fmt.Sprintf("NOT %d", paused.ID()), segment.StateActive), nil},
+			{"excluding draft or paused", composite(fmt.Sprintf("%d AND NOT (%d OR %d)", premium.ID(), draft.ID(), paused.ID()), segment.StateActive), []string{"a", "b"}},
+			{"keeps candidates of dropped references", composite(fmt.Sprintf("NOT %d OR (%d AND %d)", visitors.ID(), premium.ID(), paused.ID()), segment.StateActive), []string{"b"}},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				if ids := collect(t, h, segments.ExportMembers{SegmentID: tt.segment.ID()}); !reflect.DeepEqual(ids, tt.expected) {
+					t.Errorf("expected export %v, got %v", tt.expected, ids)
+				}
+				for _, memberID := range []string{"a", "b"} {
+					m, err := h.check.Handle(ctx, segments.CheckMembership{SegmentID: tt.segment.ID(), MemberID: memberID})
+					if err != nil {
+						t.Fatalf("expected no error, got %v", err)
+					}
+					if expected := slices.Contains(tt.expected, memberID); m.IsMember != expected {
+						t.Errorf("expected membership of %s %v, got %v", memberID, expected, m.IsMember)
+					}
+				}
+			})
+		}
+	})
+
+	t.Run("follows the activation window", func(t *testing.T) {
+		start := time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)
+		clk := clock.NewFake(start)
+		h := setup(t, clk)
+		from, until := start.Add(time.Hour), start.Add(2*time.Hour)
+		campaign, _ := h.create.Handle(ctx, segments.CreateSegment{Name: "campaign", State: segment.StateActive, ActiveFrom: &from, ActiveUntil: &until})
+		composite, _ := h.create.Handle(ctx, segments.CreateSegment{Name: "composite", Expression: fmt.Sprint(campaign.ID()), State: segment.StateActive})
+		_ = h.add.Handle(ctx, segments.AddMembers{SegmentID: campaign.ID(), MemberIDs: []string{"a"}})
+
+		tests := []struct {
+			name     string
+			at       time.Time
+			expected bool
+		}{
+			{"before active_from", from.Add(-time.Nanosecond), false},
+			{"at active_from", from, true},
+			{"before active_until", until.Add(-time.Nanosecond), true},
+			{"at active_until", until, false},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				clk.Set(tt.at)
+				for _, s := range []*segment.Segment{campaign, composite} {
+					m, err := h.check.Handle(ctx, segments.CheckMembership{SegmentID: s.ID(), MemberID: "a"})
+					if err != nil {
+						t.Fatalf("expected no error, got %v", err)
+					}
+					if m.IsMember != tt.expected {
+						t.Errorf("expected membership of segment '%d' %v, got %v", s.ID(), tt.expected, m.IsMember)
+					}
+					if ids := collect(t, h, segments.ExportMembers{SegmentID: s.ID()}); (len(ids) > 0) != tt.expected {
+						t.Errorf("expected segment '%d' to export members %v, got %v", s.ID(), tt.expected, ids)
+					}
+				}
+			})
+		}
+	})
+
+	t.Run("stops at callback error", func(t *testing.T) {
+		h := setup(t, clock.System)
+		s, _ := h.create.Handle(ctx, segments.CreateSegment{Name: "premium-users", State: segment.StateActive})
+		_ = h.add.Handle(ctx, segments.AddMembers{SegmentID: s.ID(), MemberIDs: []string{"a", "b"}})
+
+		errStop := errors.New("stop")
+		calls := 0
+		err := h.export.Handle(ctx, segments.ExportMembers{SegmentID: s.ID()}, func(string) error {
+			calls++
+			return errStop
+		})
+		if !errors.Is(err, errStop) || calls != 1 {
+			t.Errorf("expected to stop after one member with %v, got %d calls and %v", errStop, calls, err)
+		}
+	})
+
+	t.Run("rejects invalid changes", func(t *testing.T) {
+		h := setup(t, clock.System)
+		s, _ := h.create.Handle(ctx, segments.CreateSegment{Name: "premium-users", State: segment.StateActive})
+		composite, _ := h.create.Handle(ctx, segments.CreateSegment{Name: "composite", Expression: fmt.Sprintf("NOT %d", s.ID()), State: segment.StateActive})
+
+		tests := []struct {
+			name     string
+			cmd      segments.AddMembers
+			expected error
+		}{
+			{"no members", segments.AddMembers{SegmentID: s.ID()}, segments.ErrEmptyMembers},
+			{"empty member ID", segments.AddMembers{SegmentID: s.ID(), MemberIDs: []string{""}}, segment.ErrInvalidMemberID},
+			{"composite segment", segments.AddMembers{SegmentID: composite.ID(), MemberIDs: []string{"a"}}, segment.ErrCompositeMembers},
+			{"missing segment", segments.AddMembers{SegmentID: 999, MemberIDs: []string{"a"}}, segment.ErrSegmentNotFound},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				if err := h.add.Handle(ctx, tt.cmd); !errors.Is(err, tt.expected) {
+					t.Errorf("expected %v, got %v", tt.expected, err)
+				}
+			})
+		}
+
+		err := h.remove.Handle(ctx, segments.RemoveMembers{SegmentID: composite.ID(), MemberIDs: []string{"a"}})
+		if !errors.Is(err, segment.ErrCompositeMembers) {
+			t.Errorf("expected %v, got %v", segment.ErrCompositeMembers, err)
+		}
+	})
+}
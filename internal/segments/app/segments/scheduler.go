@@ -0,0 +1,107 @@
+package segments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rickKoch/nexus/internal/segments/domain/segment"
+	"github.com/rickKoch/nexus/pkg/clock"
+)
+
+// WindowEventPublisher receives the events emitted by a WindowScheduler.
+type WindowEventPublisher interface {
+	Publish(ctx context.Context, event segment.WindowEvent) error
+}
+
+// WindowScheduler watches activation windows and publishes an event whenever
+// an active segment's window opens or closes.
+type WindowScheduler struct {
+	segmentRepo segment.Repository
+	publisher   WindowEventPublisher
+	clock       clock.Clock
+	interval    time.Duration
+
+	// last is the time up to which boundaries have been published.
+	last time.Time
+}
+
+// NewWindowScheduler creates a WindowScheduler that checks for crossed window
+// boundaries every interval. Boundaries crossed before it was created are not
+// reported.
+func NewWindowScheduler(segmentRepo segment.Repository, publisher WindowEventPublisher, clk clock.Clock, interval time.Duration) (*WindowScheduler, error) {
+	if segmentRepo == nil {
+		return nil, errors.New("segment repository is not provided")
+	}
+	if publisher == nil {
+		return nil, errors.New("window event publisher is not provided")
+	}
+	if clk == nil {
+		return nil, errors.New("clock is not provided")
+	}
+	if interval <= 0 {
+		return nil, errors.New("scheduler interval must be positive")
+	}
+
+	return &WindowScheduler{
+		segmentRepo: segmentRepo,
+		publisher:   publisher,
+		clock:       clk,
+		interval:    interval,
+		last:        clk.Now(),
+	}, nil
+}
+
+// Run calls Tick every interval until ctx is cancelled. Errors are passed to
+// onError and the failed boundaries are retried on the next tick.
+func (s *WindowScheduler) Run(ctx context.Context, onError func(err error)) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Tick(ctx); err != nil {
+				onError(err)
+			}
+		}
+	}
+}
+
+// Tick publishes, in order, the events for the boundaries crossed since the
+// previous successful tick. Only active segments produce events, since a
+// draft, paused or archived segment does not go live when its window opens.
+// Events are published at least once: if publishing fails, the whole range is
+// retried on the next tick.
+func (s *WindowScheduler) Tick(ctx context.Context) error {
+	now := s.clock.Now()
+	if !now.After(s.last) {
+		return nil
+	}
+
+	crossed, err := s.segmentRepo.ListWindowBoundaries(ctx, s.last, now)
+	if err != nil {
+		return fmt.Errorf("failed to list window boundaries: %w", err)
+	}
+
+	var events []segment.WindowEvent
+	for i := range crossed {
+		if crossed[i].State() != segment.StateActive {
+			continue
+		}
+		events = append(events, crossed[i].WindowEvents(s.last, now)...)
+	}
+	segment.SortWindowEvents(events)
+
+	for _, event := range events {
+		if err := s.publisher.Publish(ctx, event); err != nil {
+			return fmt.Errorf("failed to publish %s event for segment '%d': %w", event.Type, event.SegmentID, err)
+		}
+	}
+
+	s.last = now
+	return nil
+}
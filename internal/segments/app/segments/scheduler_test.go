@@ -0,0 +1,244 @@
+package segments_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rickKoch/nexus/internal/segments/adapters"
+	"github.com/rickKoch/nexus/internal/segments/app/segments"
+	"github.com/rickKoch/nexus/internal/segments/domain/segment"
+	"github.com/rickKoch/nexus/pkg/clock"
+)
+
+// recordingPublisher collects published events and fails while err is set.
+type recordingPublisher struct {
+	events []segment.WindowEvent
+	err    error
+}
+
+func (p *recordingPublisher) Publish(ctx context.Context, event segment.WindowEvent) error {
+	if p.err != nil {
+		return p.err
+	}
+	p.events = append(p.events, event)
+	return nil
+}
+
+func timePtr(t time.Time) *time.Time { return &t }
+
+func TestActivationWindow(t *testing.T) {
+	repo := adapters.NewInMemorySegmentRepository()
+	handler, _ := segments.NewCreateSegmentHandler(repo, clock.System, repo)
+	ctx := context.Background()
+	start := time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(24 * time.Hour)
+
+	t.Run("rejects windows that do not start before they end", func(t *testing.T) {
+		for _, until := range []time.Time{start, start.Add(-time.Second)} {
+			_, err := handler.Handle(ctx, segments.CreateSegment{Name: "x", ActiveFrom: timePtr(start), ActiveUntil: timePtr(until)})
+			if !errors.Is(err, segment.ErrInvalidActivationWindow) {
+				t.Errorf("expected %v, got %v", segment.ErrInvalidActivationWindow, err)
+			}
+		}
+	})
+
+	t.Run("matches only active segments inside the window", func(t *testing.T) {
+		seg, err := handler.Handle(ctx, segments.CreateSegment{
+			Name:        "black-friday",
+			ActiveFrom:  timePtr(start),
+			ActiveUntil: timePtr(end),
+			State:       segment.StateActive,
+		})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		tests := []struct {
+			at       time.Time
+			expected bool
+		}{
+			{start.Add(-time.Nanosecond), false},
+			{start, true},
+			{end.Add(-time.Nanosecond), true},
+			{end, false},
+		}
+		for _, tt := range tests {
+			if got := seg.IsMatchable(tt.at); got != tt.expected {
+				t.Errorf("expected IsMatchable(%s) to be %v, got %v", tt.at, tt.expected, got)
+			}
+		}
+
+		draft, _ := handler.Handle(ctx, segments.CreateSegment{Name: "draft", ActiveFrom: timePtr(start)})
+		if draft.IsMatchable(end) {
+			t.Error("expected draft segment not to match inside its window")
+		}
+	})
+
+	t.Run("open-ended windows", func(t *testing.T) {
+		seg, _ := handler.Handle(ctx, segments.CreateSegment{Name: "from", ActiveFrom: timePtr(start), State: segment.StateActive})
+		if seg.IsMatchable(start.Add(-time.Second)) || !seg.IsMatchable(start.AddDate(10, 0, 0)) {
+			t.Error("expected window without end to stay open")
+		}
+
+		seg, _ = handler.Handle(ctx, segments.CreateSegment{Name: "until", ActiveUntil: timePtr(end), State: segment.StateActive})
+		if !seg.IsMatchable(end.AddDate(-10, 0, 0)) || seg.IsMatchable(end) {
+			t.Error("expected window without start to be open until its end")
+		}
+	})
+}
+
+func TestWindowScheduler_Tick(t *testing.T) {
+	start := time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+
+	setup := func(t *testing.T) (*adapters.InMemorySegmentRepository, *clock.Fake, *recordingPublisher, *segments.WindowScheduler) {
+		t.Helper()
+
+		repo := adapters.NewInMemorySegmentRepository()
+		clk := clock.NewFake(start)
+		publisher := &recordingPublisher{}
+		scheduler, err := segments.NewWindowScheduler(repo, publisher, clk, time.Minute)
+		if err != nil {
+			t.Fatalf("failed to create scheduler: %v", err)
+		}
+		return repo, clk, publisher, scheduler
+	}
+
+	create := func(t *testing.T, repo *adapters.InMemorySegmentRepository, cmd segments.CreateSegment) *segment.Segment {
+		t.Helper()
+
+		handler, _ := segments.NewCreateSegmentHandler(repo, clock.System, repo)
+		seg, err := handler.Handle(ctx, cmd)
+		if err != nil {
+			t.Fatalf("failed to create segment: %v", err)
+		}
+		return seg
+	}
+
+	t.Run("emits activation and expiry events in order", func(t *testing.T) {
+		repo, clk, publisher, scheduler := setup(t)
+		a := create(t, repo, segments.CreateSegment{
+			Name:        "a",
+			ActiveFrom:  timePtr(start.Add(10 * time.Minute)),
+			ActiveUntil: timePtr(start.Add(30 * time.Minute)),
+			State:       segment.StateActive,
+		})
+		b := create(t, repo, segments.CreateSegment{
+			Name:       "b",
+			ActiveFrom: timePtr(start.Add(5 * time.Minute)),
+			State:      segment.StateActive,
+		})
+
+		clk.Advance(time.Hour)
+		if err := scheduler.Tick(ctx); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		expected := []segment.WindowEvent{
+			{Type: segment.WindowActivated, SegmentID: b.ID(), SegmentName: "b", At: start.Add(5 * time.Minute)},
+			{Type: segment.WindowActivated, SegmentID: a.ID(), SegmentName: "a", At: start.Add(10 * time.Minute)},
+			{Type: segment.WindowExpired, SegmentID: a.ID(), SegmentName: "a", At: start.Add(30 * time.Minute)},
+		}
+		if len(publisher.events) != len(expected) {
+			t.Fatalf("expected %d events, got %v", len(expected), publisher.events)
+		}
+		for i, e := range expected {
+			if publisher.events[i] != e {
+				t.Errorf("expected event %d to be %+v, got %+v", i, e, publisher.events[i])
+			}
+		}
+	})
+
+	t.Run("emits each boundary once", func(t *testing.T) {
+		repo, clk, publisher, scheduler := setup(t)
+		create(t, repo, segments.CreateSegment{Name: "a", ActiveFrom: timePtr(start.Add(time.Minute)), State: segment.StateActive})
+
+		for i := 0; i < 3; i++ {
+			clk.Advance(time.Minute)
+			if err := scheduler.Tick(ctx); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+		}
+
+		if len(publisher.events) != 1 {
+			t.Errorf("expected 1 event, got %v", publisher.events)
+		}
+	})
+
+	t.Run("ignores boundaries before the scheduler started", func(t *testing.T) {
+		repo, clk, publisher, scheduler := setup(t)
+		create(t, repo, segments.CreateSegment{Name: "a", ActiveFrom: timePtr(start.Add(-time.Minute)), State: segment.StateActive})
+
+		clk.Advance(time.Minute)
+		if err := scheduler.Tick(ctx); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if len(publisher.events) != 0 {
+			t.Errorf("expected no events, got %v", publisher.events)
+		}
+	})
+
+	t.Run("skips segments that are not active", func(t *testing.T) {
+		repo, clk, publisher, scheduler := setup(t)
+		create(t, repo, segments.CreateSegment{Name: "draft", ActiveFrom: timePtr(start.Add(time.Minute))})
+
+		clk.Advance(time.Hour)
+		if err := scheduler.Tick(ctx); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if len(publisher.events) != 0 {
+			t.Errorf("expected no events, got %v", publisher.events)
+		}
+	})
+
+	t.Run("retries events that failed to publish", func(t *testing.T) {
+		repo, clk, publisher, scheduler := setup(t)
+		create(t, repo, segments.CreateSegment{Name: "a", ActiveFrom: timePtr(start.Add(time.Minute)), State: segment.StateActive})
+
+		publisher.err = errors.New("broker unavailable")
+		clk.Advance(time.Hour)
+		if err := scheduler.Tick(ctx); err == nil {
+			t.Fatal("expected error when publishing fails")
+		}
+
+		publisher.err = nil
+		clk.Advance(time.Minute)
+		if err := scheduler.Tick(ctx); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if len(publisher.events) != 1 || publisher.events[0].Type != segment.WindowActivated {
+			t.Errorf("expected the activation to be published on retry, got %v", publisher.events)
+		}
+	})
+}
+
+func TestNewWindowScheduler_Validation(t *testing.T) {
+	repo := adapters.NewInMemorySegmentRepository()
+	publisher := &recordingPublisher{}
+
+	tests := []struct {
+		name      string
+		repo      segment.Repository
+		publisher segments.WindowEventPublisher
+		clock     clock.Clock
+		interval  time.Duration
+	}{
+		{"nil repository", nil, publisher, clock.System, time.Minute},
+		{"nil publisher", repo, nil, clock.System, time.Minute},
+		{"nil clock", repo, publisher, nil, time.Minute},
+		{"zero interval", repo, publisher, clock.System, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := segments.NewWindowScheduler(tt.repo, tt.publisher, tt.clock, tt.interval); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
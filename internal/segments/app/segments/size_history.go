@@ -0,0 +1,87 @@
+package segments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rickKoch/nexus/internal/segments/domain/segment"
+	"github.com/rickKoch/nexus/pkg/clock"
+)
+
+// SizeHistoryRecorder periodically purges expired memberships, rebuilds the
+// sketches discarded by removals and records the size of every regular
+// segment for the current day.
+type SizeHistoryRecorder struct {
+	segmentRepo segment.Repository
+	clock       clock.Clock
+	interval    time.Duration
+}
+
+// NewSizeHistoryRecorder creates a SizeHistoryRecorder running every
+// interval. Each run overwrites the day's earlier snapshot, so the last run of
+// a day determines its recorded size.
+func NewSizeHistoryRecorder(segmentRepo segment.Repository, clk clock.Clock, interval time.Duration) (*SizeHistoryRecorder, error) {
+	if segmentRepo == nil {
+		return nil, errors.New("segment repository is not provided")
+	}
+	if clk == nil {
+		return nil, errors.New("clock is not provided")
+	}
+	if interval <= 0 {
+		return nil, errors.New("size history interval must be positive")
+	}
+
+	return &SizeHistoryRecorder{
+		segmentRepo: segmentRepo,
+		clock:       clk,
+		interval:    interval,
+	}, nil
+}
+
+// Run calls Tick immediately and then every interval until ctx is cancelled.
+// Errors are passed to onError.
+func (r *SizeHistoryRecorder) Run(ctx context.Context, onError func(err error)) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := r.Tick(ctx); err != nil {
+			onError(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Tick purges the memberships expired by now, so that member counts only
+// include live members, rebuilds the sketches of the segments that lost
+// members and then records the counts as today's sizes.
+func (r *SizeHistoryRecorder) Tick(ctx context.Context) error {
+	now := r.clock.Now()
+
+	if _, err := r.segmentRepo.PurgeExpiredMembers(ctx, now); err != nil {
+		return fmt.Errorf("failed to purge expired members: %w", err)
+	}
+
+	ids, err := r.segmentRepo.ListUnsketchedSegments(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list segments without sketches: %w", err)
+	}
+	for _, id := range ids {
+		if err := rebuildSketch(ctx, r.segmentRepo, id, now); err != nil {
+			return err
+		}
+	}
+
+	if err := r.segmentRepo.RecordSizeSnapshots(ctx, now); err != nil {
+		return fmt.Errorf("failed to record segment sizes: %w", err)
+	}
+
+	return nil
+}
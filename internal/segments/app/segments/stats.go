@@ -0,0 +1,91 @@
+package segments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rickKoch/nexus/internal/segments/domain/segment"
+	"github.com/rickKoch/nexus/pkg/clock"
+)
+
+const (
+	// DefaultStatsDays is the number of days of size history returned when
+	// no range is given, ending today.
+	DefaultStatsDays = 30
+	// MaxStatsDays is the maximum number of days of size history returned at once.
+	MaxStatsDays = 366
+)
+
+// ErrInvalidStatsRange is returned for a size history range that ends before
+// it starts or spans more than MaxStatsDays days.
+var ErrInvalidStatsRange = fmt.Errorf("stats range must start before it ends and span at most %d days", MaxStatsDays)
+
+// GetSegmentStats holds the segment and the days of size history to return.
+// A missing end defaults to today and a missing start to DefaultStatsDays
+// days before the end.
+type GetSegmentStats struct {
+	ID   int
+	From *time.Time
+	To   *time.Time
+}
+
+// SegmentStats is the current size of a segment and its daily size history.
+type SegmentStats struct {
+	Segment *segment.Segment
+	// From and To are the first and last day of History.
+	From    time.Time
+	To      time.Time
+	History []segment.SizeSnapshot
+}
+
+// GetSegmentStatsHandler defines the interface for reading segment statistics.
+type GetSegmentStatsHandler interface {
+	Handle(ctx context.Context, query GetSegmentStats) (*SegmentStats, error)
+}
+
+type getSegmentStatsHandler struct {
+	segmentRepo segment.Repository
+	clock       clock.Clock
+}
+
+// NewGetSegmentStatsHandler creates a new GetSegmentStatsHandler.
+func NewGetSegmentStatsHandler(segmentRepo segment.Repository, clk clock.Clock) (GetSegmentStatsHandler, error) {
+	if segmentRepo == nil {
+		return getSegmentStatsHandler{}, errors.New("segment repository is not provided")
+	}
+	if clk == nil {
+		return getSegmentStatsHandler{}, errors.New("clock is not provided")
+	}
+
+	return getSegmentStatsHandler{segmentRepo, clk}, nil
+}
+
+// Handle returns the statistics of a segment. Days on which no snapshot was
+// taken are missing from the history.
+func (h getSegmentStatsHandler) Handle(ctx context.Context, query GetSegmentStats) (*SegmentStats, error) {
+	to := segment.Day(h.clock.Now())
+	if query.To != nil {
+		to = segment.Day(*query.To)
+	}
+	from := to.AddDate(0, 0, 1-DefaultStatsDays)
+	if query.From != nil {
+		from = segment.Day(*query.From)
+	}
+	if from.After(to) || to.Sub(from) >= MaxStatsDays*24*time.Hour {
+		return nil, fmt.Errorf("%w, got %s to %s", ErrInvalidStatsRange, from.Format(time.DateOnly), to.Format(time.DateOnly))
+	}
+
+	s, err := h.segmentRepo.Get(ctx, query.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get segment '%d': %w", query.ID, err)
+	}
+
+	history, err := h.segmentRepo.ListSizeHistory(ctx, query.ID, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list size history of segment '%d': %w", query.ID, err)
+	}
+
+	return &SegmentStats{Segment: s, From: from, To: to, History: history}, nil
+}
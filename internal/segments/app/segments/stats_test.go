@@ -0,0 +1,154 @@
+package segments_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/rickKoch/nexus/internal/segments/adapters"
+	"github.com/rickKoch/nexus/internal/segments/app/segments"
+	"github.com/rickKoch/nexus/pkg/clock"
+)
+
+func TestSegmentStats(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2026, 11, 1, 12, 0, 0, 0, time.UTC)
+
+	type fixture struct {
+		clk      *clock.Fake
+		create   segments.CreateSegmentHandler
+		add      segments.AddMembersHandler
+		remove   segments.RemoveMembersHandler
+		stats    segments.GetSegmentStatsHandler
+		recorder *segments.SizeHistoryRecorder
+	}
+	setup := func(t *testing.T) fixture {
+		t.Helper()
+
+		clk := clock.NewFake(start)
+		repo := adapters.NewInMemorySegmentRepository()
+		create, _ := segments.NewCreateSegmentHandler(repo, clk, repo)
+		add, _ := segments.NewAddMembersHandler(repo, clk)
+		remove, _ := segments.NewRemoveMembersHandler(repo)
+		stats, _ := segments.NewGetSegmentStatsHandler(repo, clk)
+		recorder, err := segments.NewSizeHistoryRecorder(repo, clk, time.Hour)
+		if err != nil {
+			t.Fatalf("failed to create recorder: %v", err)
+		}
+		return fixture{clk, create, add, remove, stats, recorder}
+	}
+
+	t.Run("maintains member counts", func(t *testing.T) {
+		f := setup(t)
+		s, _ := f.create.Handle(ctx, segments.CreateSegment{Name: "premium-users"})
+
+		_ = f.add.Handle(ctx, segments.AddMembers{SegmentID: s.ID(), MemberIDs: []string{"a", "b", "c"}})
+		// Re-adding a member does not count it twice.
+		_ = f.add.Handle(ctx, segments.AddMembers{SegmentID: s.ID(), MemberIDs: []string{"c", "d"}})
+		_ = f.remove.Handle(ctx, segments.RemoveMembers{SegmentID: s.ID(), MemberIDs: []string{"a", "z"}})
+
+		stats, err := f.stats.Handle(ctx, segments.GetSegmentStats{ID: s.ID()})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if got := stats.Segment.MemberCount(); got != 3 {
+			t.Errorf("expected 3 members, got %d", got)
+		}
+	})
+
+	t.Run("records daily size history", func(t *testing.T) {
+		f := setup(t)
+		ttl := int((36 * time.Hour).Seconds())
+		s, _ := f.create.Handle(ctx, segments.CreateSegment{Name: "recent-visitors", TTLSeconds: &ttl})
+		composite, _ := f.create.Handle(ctx, segments.CreateSegment{Name: "composite", Expression: fmt.Sprintf("NOT %d", s.ID())})
+
+		sizes := []int{2, 5, 3}
+		for day, size := range sizes {
+			ids := make([]string, size)
+			for i := range ids {
+				ids[i] = fmt.Sprintf("day-%d-user-%d", day, i)
+			}
+			_ = f.add.Handle(ctx, segments.AddMembers{SegmentID: s.ID(), MemberIDs: ids})
+			if err := f.recorder.Tick(ctx); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			f.clk.Advance(24 * time.Hour)
+		}
+
+		stats, err := f.stats.Handle(ctx, segments.GetSegmentStats{ID: s.ID()})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		// Members expire after a day and a half, so each day only counts the
+		// members added on it and the day before.
+		expected := []int{2, 7, 8}
+		if len(stats.History) != len(expected) {
+			t.Fatalf("expected %d snapshots, got %+v", len(expected), stats.History)
+		}
+		for i, snapshot := range stats.History {
+			day := time.Date(2026, 11, 1+i, 0, 0, 0, 0, time.UTC)
+			if !snapshot.Day.Equal(day) || snapshot.MemberCount != expected[i] {
+				t.Errorf("expected %d members on %s, got %d on %s", expected[i], day, snapshot.MemberCount, snapshot.Day)
+			}
+		}
+
+		// The default range ends today, which has no snapshot yet.
+		if stats.To.Format(time.DateOnly) != "2026-11-04" || stats.From.Format(time.DateOnly) != "2026-10-06" {
+			t.Errorf("expected the default range to end today, got %s to %s", stats.From, stats.To)
+		}
+
+		if err := f.recorder.Tick(ctx); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		stats, _ = f.stats.Handle(ctx, segments.GetSegmentStats{ID: s.ID()})
+		if got := stats.Segment.MemberCount(); got != 3 {
+			t.Errorf("expected expired members to be purged, got %d members", got)
+		}
+
+		compositeStats, _ := f.stats.Handle(ctx, segments.GetSegmentStats{ID: composite.ID()})
+		if len(compositeStats.History) != 0 {
+			t.Errorf("expected no history for composite segments, got %+v", compositeStats.History)
+		}
+	})
+
+	t.Run("limits the history to the range", func(t *testing.T) {
+		f := setup(t)
+		s, _ := f.create.Handle(ctx, segments.CreateSegment{Name: "premium-users"})
+		for range 5 {
+			_ = f.recorder.Tick(ctx)
+			f.clk.Advance(24 * time.Hour)
+		}
+
+		from, to := start.AddDate(0, 0, 1), start.AddDate(0, 0, 3)
+		stats, err := f.stats.Handle(ctx, segments.GetSegmentStats{ID: s.ID(), From: &from, To: &to})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if len(stats.History) != 3 {
+			t.Errorf("expected 3 snapshots, got %+v", stats.History)
+		}
+	})
+
+	t.Run("rejects invalid ranges", func(t *testing.T) {
+		f := setup(t)
+		s, _ := f.create.Handle(ctx, segments.CreateSegment{Name: "premium-users"})
+
+		ranges := [][2]time.Time{
+			{start, start.AddDate(0, 0, -1)},
+			{start, start.AddDate(0, 0, segments.MaxStatsDays)},
+		}
+		for _, r := range ranges {
+			_, err := f.stats.Handle(ctx, segments.GetSegmentStats{ID: s.ID(), From: &r[0], To: &r[1]})
+			if !errors.Is(err, segments.ErrInvalidStatsRange) {
+				t.Errorf("expected %v for %s to %s, got %v", segments.ErrInvalidStatsRange, r[0], r[1], err)
+			}
+		}
+
+		longest := start.AddDate(0, 0, segments.MaxStatsDays-1)
+		if _, err := f.stats.Handle(ctx, segments.GetSegmentStats{ID: s.ID(), From: &start, To: &longest}); err != nil {
+			t.Errorf("expected %d days to be allowed, got %v", segments.MaxStatsDays, err)
+		}
+	})
+}
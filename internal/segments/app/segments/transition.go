@@ -0,0 +1,65 @@
+package segments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/rickKoch/nexus/internal/segments/domain/segment"
+	"github.com/rickKoch/nexus/pkg/clock"
+)
+
+// TransitionSegment holds the segment to move and its target state.
+type TransitionSegment struct {
+	ID    int
+	State segment.State
+}
+
+// TransitionSegmentHandler defines the interface for changing the lifecycle
+// state of a segment.
+type TransitionSegmentHandler interface {
+	Handle(ctx context.Context, cmd TransitionSegment) (*segment.Segment, error)
+}
+
+type transitionSegmentHandler struct {
+	segmentRepo segment.Repository
+	clock       clock.Clock
+}
+
+// NewTransitionSegmentHandler creates a new TransitionSegmentHandler.
+func NewTransitionSegmentHandler(segmentRepo segment.Repository, clk clock.Clock) (TransitionSegmentHandler, error) {
+	if segmentRepo == nil {
+		return transitionSegmentHandler{}, errors.New("segment repository is not provided")
+	}
+	if clk == nil {
+		return transitionSegmentHandler{}, errors.New("clock is not provided")
+	}
+
+	return transitionSegmentHandler{segmentRepo, clk}, nil
+}
+
+// Handle moves a segment to the requested state if the transition is allowed.
+func (h transitionSegmentHandler) Handle(ctx context.Context, cmd TransitionSegment) (*segment.Segment, error) {
+	var updated *segment.Segment
+	err := h.segmentRepo.RunInTransaction(ctx, func(ctx context.Context, repo segment.Repository) error {
+		existing, err := repo.Get(ctx, cmd.ID)
+		if err != nil {
+			return fmt.Errorf("failed to get segment '%d': %w", cmd.ID, err)
+		}
+
+		if err := existing.TransitionTo(cmd.State, h.clock.Now()); err != nil {
+			return fmt.Errorf("failed to transition segment '%d': %w", cmd.ID, err)
+		}
+
+		updated, err = repo.Update(ctx, existing)
+		if err != nil {
+			return fmt.Errorf("failed to update segment '%d': %w", cmd.ID, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return updated, nil
+}
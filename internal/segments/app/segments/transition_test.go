@@ -0,0 +1,158 @@
+package segments_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rickKoch/nexus/internal/segments/adapters"
+	"github.com/rickKoch/nexus/internal/segments/app/segments"
+	"github.com/rickKoch/nexus/internal/segments/domain/segment"
+	"github.com/rickKoch/nexus/pkg/clock"
+)
+
+func TestTransitionSegmentHandler_Handle(t *testing.T) {
+	repo := adapters.NewInMemorySegmentRepository()
+	createHandler, _ := segments.NewCreateSegmentHandler(repo, clock.System, repo)
+	updateHandler, _ := segments.NewUpdateSegmentHandler(repo, clock.System)
+	deleteHandler, _ := segments.NewDeleteSegmentHandler(repo, clock.System)
+	handler, err := segments.NewTransitionSegmentHandler(repo, clock.System)
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+
+	ctx := context.Background()
+
+	create := func(t *testing.T, state segment.State) *segment.Segment {
+		t.Helper()
+
+		created, err := createHandler.Handle(ctx, segments.CreateSegment{Name: "lifecycle", State: state})
+		if err != nil {
+			t.Fatalf("failed to create segment: %v", err)
+		}
+		return created
+	}
+
+	t.Run("creates segments as draft by default", func(t *testing.T) {
+		if got := create(t, "").State(); got != segment.StateDraft {
+			t.Errorf("expected state draft, got %s", got)
+		}
+	})
+
+	t.Run("rejects invalid initial states", func(t *testing.T) {
+		for _, state := range []segment.State{segment.StatePaused, segment.StateArchived, "deleted"} {
+			_, err := createHandler.Handle(ctx, segments.CreateSegment{Name: "x", State: state})
+			if !errors.Is(err, segment.ErrInvalidInitialState) {
+				t.Errorf("expected %v for %s, got %v", segment.ErrInvalidInitialState, state, err)
+			}
+		}
+	})
+
+	t.Run("follows allowed transitions", func(t *testing.T) {
+		created := create(t, segment.StateDraft)
+
+		for _, state := range []segment.State{segment.StateActive, segment.StatePaused, segment.StateActive, segment.StateArchived} {
+			updated, err := handler.Handle(ctx, segments.TransitionSegment{ID: created.ID(), State: state})
+			if err != nil {
+				t.Fatalf("expected transition to %s to succeed, got %v", state, err)
+			}
+			if updated.State() != state {
+				t.Errorf("expected state %s, got %s", state, updated.State())
+			}
+		}
+
+		got, _ := repo.Get(ctx, created.ID())
+		if got.State() != segment.StateArchived {
+			t.Errorf("expected state to be persisted, got %s", got.State())
+		}
+	})
+
+	t.Run("rejects disallowed transitions", func(t *testing.T) {
+		tests := []struct {
+			from segment.State
+			path []segment.State
+			to   segment.State
+		}{
+			{segment.StateDraft, nil, segment.StatePaused},
+			{segment.StateActive, nil, segment.StateDraft},
+			{segment.StateActive, nil, segment.StateActive},
+			{segment.StateActive, []segment.State{segment.StatePaused}, segment.StatePaused},
+			{segment.StateActive, []segment.State{segment.StateArchived}, segment.StateActive},
+		}
+
+		for _, tt := range tests {
+			created := create(t, tt.from)
+			for _, state := range tt.path {
+				if _, err := handler.Handle(ctx, segments.TransitionSegment{ID: created.ID(), State: state}); err != nil {
+					t.Fatalf("failed to transition to %s: %v", state, err)
+				}
+			}
+
+			_, err := handler.Handle(ctx, segments.TransitionSegment{ID: created.ID(), State: tt.to})
+			if !errors.Is(err, segment.ErrInvalidTransition) {
+				t.Errorf("expected %v moving %s via %v to %s, got %v", segment.ErrInvalidTransition, tt.from, tt.path, tt.to, err)
+			}
+		}
+	})
+
+	t.Run("rejects unknown states", func(t *testing.T) {
+		created := create(t, segment.StateDraft)
+
+		_, err := handler.Handle(ctx, segments.TransitionSegment{ID: created.ID(), State: "deleted"})
+		if !errors.Is(err, segment.ErrInvalidState) {
+			t.Errorf("expected %v, got %v", segment.ErrInvalidState, err)
+		}
+	})
+
+	t.Run("fails for non-existent segment", func(t *testing.T) {
+		_, err := handler.Handle(ctx, segments.TransitionSegment{ID: 99999, State: segment.StateActive})
+		if !errors.Is(err, segment.ErrSegmentNotFound) {
+			t.Errorf("expected %v, got %v", segment.ErrSegmentNotFound, err)
+		}
+	})
+
+	t.Run("archived segments are read-only", func(t *testing.T) {
+		created := create(t, segment.StateActive)
+		if _, err := handler.Handle(ctx, segments.TransitionSegment{ID: created.ID(), State: segment.StateArchived}); err != nil {
+			t.Fatalf("failed to archive segment: %v", err)
+		}
+
+		_, err := updateHandler.Handle(ctx, segments.UpdateSegment{ID: created.ID(), Name: "renamed"})
+		if !errors.Is(err, segment.ErrSegmentArchived) {
+			t.Errorf("expected %v updating, got %v", segment.ErrSegmentArchived, err)
+		}
+
+		err = deleteHandler.Handle(ctx, segments.DeleteSegment{ID: created.ID()})
+		if !errors.Is(err, segment.ErrSegmentArchived) {
+			t.Errorf("expected %v deleting, got %v", segment.ErrSegmentArchived, err)
+		}
+		if _, err := repo.Get(ctx, created.ID()); err != nil {
+			t.Errorf("expected archived segment to be kept, got %v", err)
+		}
+	})
+
+	t.Run("only active segments match", func(t *testing.T) {
+		created := create(t, segment.StateDraft)
+		if created.IsMatchable(time.Now()) {
+			t.Error("expected draft segment not to match")
+		}
+
+		active, _ := handler.Handle(ctx, segments.TransitionSegment{ID: created.ID(), State: segment.StateActive})
+		if !active.IsMatchable(time.Now()) {
+			t.Error("expected active segment to match")
+		}
+
+		paused, _ := handler.Handle(ctx, segments.TransitionSegment{ID: created.ID(), State: segment.StatePaused})
+		if paused.IsMatchable(time.Now()) {
+			t.Error("expected paused segment not to match")
+		}
+	})
+}
+
+func TestNewTransitionSegmentHandler_NilRepository(t *testing.T) {
+	_, err := segments.NewTransitionSegmentHandler(nil, clock.System)
+	if err == nil {
+		t.Error("expected error for nil repository")
+	}
+}
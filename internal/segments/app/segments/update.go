@@ -7,13 +7,21 @@ import (
 	"time"
 
 	"github.com/rickKoch/nexus/internal/segments/domain/segment"
+	"github.com/rickKoch/nexus/pkg/clock"
 )
 
 // UpdateSegment holds the required parameters for updating a segment.
 type UpdateSegment struct {
-	ID         int
-	Name       string
-	TTLSeconds *int
+	ID          int
+	Name        string
+	Description string
+	Labels      segment.Labels
+	TTLSeconds  *int
+	ActiveFrom  *time.Time
+	ActiveUntil *time.Time
+	// Expression replaces the segment's expression. Empty makes it a
+	// regular segment.
+	Expression string
 }
 
 // UpdateSegmentHandler defines the interface for updating a segment.
@@ -23,41 +31,68 @@ type UpdateSegmentHandler interface {
 
 type updateSegmentHandler struct {
 	segmentRepo segment.Repository
+	clock       clock.Clock
 }
 
 // NewUpdateSegmentHandler creates a new UpdateSegmentHandler.
-func NewUpdateSegmentHandler(segmentRepo segment.Repository) (UpdateSegmentHandler, error) {
+func NewUpdateSegmentHandler(segmentRepo segment.Repository, clk clock.Clock) (UpdateSegmentHandler, error) {
 	if segmentRepo == nil {
 		return updateSegmentHandler{}, errors.New("segment repository is not provided")
 	}
+	if clk == nil {
+		return updateSegmentHandler{}, errors.New("clock is not provided")
+	}
 
-	return updateSegmentHandler{segmentRepo}, nil
+	return updateSegmentHandler{segmentRepo, clk}, nil
 }
 
 // Handle updates an existing segment.
 func (h updateSegmentHandler) Handle(ctx context.Context, props UpdateSegment) (*segment.Segment, error) {
 	// Validate input
+	expr, err := parseExpression(props.Expression)
+	if err != nil {
+		return nil, fmt.Errorf("invalid segment: %w", err)
+	}
+
 	config := segment.SegmentConfig{
-		Name:       props.Name,
-		TTLSeconds: props.TTLSeconds,
+		Name:        props.Name,
+		Description: props.Description,
+		Labels:      props.Labels,
+		TTLSeconds:  props.TTLSeconds,
+		ActiveFrom:  props.ActiveFrom,
+		ActiveUntil: props.ActiveUntil,
+		Expression:  expr,
 	}
 
 	if err := config.Validate(); err != nil {
 		return nil, fmt.Errorf("invalid segment: %w", err)
 	}
 
-	// Check if segment exists
-	existing, err := h.segmentRepo.Get(ctx, props.ID)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get segment '%d': %w", props.ID, err)
-	}
+	var updated *segment.Segment
+	err = h.segmentRepo.RunInTransaction(ctx, func(ctx context.Context, repo segment.Repository) error {
+		// Check if segment exists
+		existing, err := repo.Get(ctx, props.ID)
+		if err != nil {
+			return fmt.Errorf("failed to get segment '%d': %w", props.ID, err)
+		}
+
+		if err := checkReferences(ctx, repo, props.ID, expr); err != nil {
+			return err
+		}
 
-	// Update the segment
-	existing.Update(props.Name, props.TTLSeconds, time.Now())
+		// Update the segment
+		if err := existing.Update(config, h.clock.Now()); err != nil {
+			return fmt.Errorf("failed to update segment '%d': %w", props.ID, err)
+		}
 
-	updated, err := h.segmentRepo.Update(ctx, existing)
+		updated, err = repo.Update(ctx, existing)
+		if err != nil {
+			return fmt.Errorf("failed to update segment '%d': %w", props.ID, err)
+		}
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to update segment '%d': %w", props.ID, err)
+		return nil, err
 	}
 
 	return updated, nil
@@ -3,15 +3,18 @@ package segments_test
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/rickKoch/nexus/internal/segments/adapters"
 	"github.com/rickKoch/nexus/internal/segments/app/segments"
+	"github.com/rickKoch/nexus/internal/segments/domain/segment"
+	"github.com/rickKoch/nexus/pkg/clock"
 )
 
 func TestUpdateSegmentHandler_Handle(t *testing.T) {
 	repo := adapters.NewInMemorySegmentRepository()
-	createHandler, _ := segments.NewCreateSegmentHandler(repo)
-	updateHandler, err := segments.NewUpdateSegmentHandler(repo)
+	createHandler, _ := segments.NewCreateSegmentHandler(repo, clock.System, repo)
+	updateHandler, err := segments.NewUpdateSegmentHandler(repo, clock.System)
 	if err != nil {
 		t.Fatalf("failed to create handler: %v", err)
 	}
@@ -66,6 +69,33 @@ func TestUpdateSegmentHandler_Handle(t *testing.T) {
 		}
 	})
 
+	t.Run("replaces description and labels", func(t *testing.T) {
+		created, err := createHandler.Handle(ctx, segments.CreateSegment{
+			Name:        "metadata-update-test",
+			Description: "old",
+			Labels:      segment.Labels{"team": "growth", "tier": "gold"},
+		})
+		if err != nil {
+			t.Fatalf("failed to create segment: %v", err)
+		}
+
+		updated, err := updateHandler.Handle(ctx, segments.UpdateSegment{
+			ID:     created.ID(),
+			Name:   "metadata-update-test",
+			Labels: segment.Labels{"team": "core"},
+		})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if updated.Description() != "" {
+			t.Errorf("expected description to be cleared, got '%s'", updated.Description())
+		}
+		if !updated.Labels().Equal(segment.Labels{"team": "core"}) {
+			t.Errorf("expected labels to be replaced, got %v", updated.Labels())
+		}
+	})
+
 	t.Run("fails for non-existent segment", func(t *testing.T) {
 		_, err := updateHandler.Handle(ctx, segments.UpdateSegment{
 			ID:   99999,
@@ -108,8 +138,91 @@ func TestUpdateSegmentHandler_Handle(t *testing.T) {
 }
 
 func TestNewUpdateSegmentHandler_NilRepository(t *testing.T) {
-	_, err := segments.NewUpdateSegmentHandler(nil)
+	_, err := segments.NewUpdateSegmentHandler(nil, clock.System)
 	if err == nil {
 		t.Error("expected error for nil repository")
 	}
 }
+
+func TestUpdateSegmentHandler_UsesClock(t *testing.T) {
+	created := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	clk := clock.NewFake(created)
+	repo := adapters.NewInMemorySegmentRepository()
+	createHandler, _ := segments.NewCreateSegmentHandler(repo, clk, repo)
+	updateHandler, _ := segments.NewUpdateSegmentHandler(repo, clk)
+
+	ctx := context.Background()
+	s, _ := createHandler.Handle(ctx, segments.CreateSegment{Name: "before"})
+
+	clk.Advance(time.Hour)
+	updated, err := updateHandler.Handle(ctx, segments.UpdateSegment{ID: s.ID(), Name: "after"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if !updated.CreatedAt().Equal(created) {
+		t.Errorf("expected created_at %v, got %v", created, updated.CreatedAt())
+	}
+	if !updated.UpdatedAt().Equal(created.Add(time.Hour)) {
+		t.Errorf("expected updated_at %v, got %v", created.Add(time.Hour), updated.UpdatedAt())
+	}
+}
+
+// laggingRepository serves Get outside of transactions from a snapshot taken
+// by lag, like a read replica behind the primary.
+type laggingRepository struct {
+	segment.Repository
+	snapshot map[int]segment.Segment
+}
+
+func (r *laggingRepository) lag(ctx context.Context, id int) error {
+	s, err := r.Repository.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	r.snapshot[id] = *s
+	return nil
+}
+
+func (r *laggingRepository) Get(ctx context.Context, id int) (*segment.Segment, error) {
+	if s, ok := r.snapshot[id]; ok {
+		return &s, nil
+	}
+	return r.Repository.Get(ctx, id)
+}
+
+func TestUpdateSegmentHandler_LaggingReplica(t *testing.T) {
+	inMemory := adapters.NewInMemorySegmentRepository()
+	repo := &laggingRepository{Repository: inMemory, snapshot: make(map[int]segment.Segment)}
+	createHandler, _ := segments.NewCreateSegmentHandler(repo, clock.System, inMemory)
+	transitionHandler, _ := segments.NewTransitionSegmentHandler(repo, clock.System)
+	updateHandler, _ := segments.NewUpdateSegmentHandler(repo, clock.System)
+
+	ctx := context.Background()
+	s, err := createHandler.Handle(ctx, segments.CreateSegment{Name: "premium-users"})
+	if err != nil {
+		t.Fatalf("failed to create segment: %v", err)
+	}
+	if err := repo.lag(ctx, s.ID()); err != nil {
+		t.Fatalf("failed to take snapshot: %v", err)
+	}
+	if _, err := transitionHandler.Handle(ctx, segments.TransitionSegment{ID: s.ID(), State: segment.StateActive}); err != nil {
+		t.Fatalf("failed to activate segment: %v", err)
+	}
+
+	labels := segment.Labels{"tier": "gold"}
+	if _, err := updateHandler.Handle(ctx, segments.UpdateSegment{ID: s.ID(), Name: "premium-users", Labels: labels}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	got, err := inMemory.Get(ctx, s.ID())
+	if err != nil {
+		t.Fatalf("failed to get segment: %v", err)
+	}
+	if got.State() != segment.StateActive {
+		t.Errorf("expected state %q to be kept, got %q", segment.StateActive, got.State())
+	}
+	if got.Labels()["tier"] != "gold" {
+		t.Errorf("expected labels %v, got %v", labels, got.Labels())
+	}
+}
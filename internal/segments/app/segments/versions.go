@@ -0,0 +1,197 @@
+package segments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/rickKoch/nexus/internal/segments/domain/segment"
+)
+
+// ListSegmentVersions holds the segment whose revisions to list.
+type ListSegmentVersions struct {
+	ID int
+}
+
+// ListSegmentVersionsHandler defines the interface for listing the revisions
+// of a segment.
+type ListSegmentVersionsHandler interface {
+	Handle(ctx context.Context, query ListSegmentVersions) ([]segment.Revision, error)
+}
+
+type listSegmentVersionsHandler struct {
+	segmentRepo segment.Repository
+}
+
+// NewListSegmentVersionsHandler creates a new ListSegmentVersionsHandler.
+func NewListSegmentVersionsHandler(segmentRepo segment.Repository) (ListSegmentVersionsHandler, error) {
+	if segmentRepo == nil {
+		return listSegmentVersionsHandler{}, errors.New("segment repository is not provided")
+	}
+
+	return listSegmentVersionsHandler{segmentRepo}, nil
+}
+
+// Handle returns the revisions of a segment, oldest first.
+func (h listSegmentVersionsHandler) Handle(ctx context.Context, query ListSegmentVersions) ([]segment.Revision, error) {
+	revisions, err := h.segmentRepo.ListRevisions(ctx, query.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list versions of segment '%d': %w", query.ID, err)
+	}
+
+	return revisions, nil
+}
+
+// GetSegmentVersion holds the segment and the version of it to return.
+type GetSegmentVersion struct {
+	ID      int
+	Version int
+}
+
+// GetSegmentVersionHandler defines the interface for reading a revision of a
+// segment.
+type GetSegmentVersionHandler interface {
+	Handle(ctx context.Context, query GetSegmentVersion) (*segment.Revision, error)
+}
+
+type getSegmentVersionHandler struct {
+	segmentRepo segment.Repository
+}
+
+// NewGetSegmentVersionHandler creates a new GetSegmentVersionHandler.
+func NewGetSegmentVersionHandler(segmentRepo segment.Repository) (GetSegmentVersionHandler, error) {
+	if segmentRepo == nil {
+		return getSegmentVersionHandler{}, errors.New("segment repository is not provided")
+	}
+
+	return getSegmentVersionHandler{segmentRepo}, nil
+}
+
+// Handle returns a revision of a segment.
+func (h getSegmentVersionHandler) Handle(ctx context.Context, query GetSegmentVersion) (*segment.Revision, error) {
+	revision, err := h.segmentRepo.GetRevision(ctx, query.ID, query.Version)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get version %d of segment '%d': %w", query.Version, query.ID, err)
+	}
+
+	return revision, nil
+}
+
+// DiffSegmentVersions holds the segment and the two versions of it to
+// compare. A missing To compares From with the latest version.
+type DiffSegmentVersions struct {
+	ID   int
+	From int
+	To   *int
+}
+
+// SegmentVersionDiff lists the fields that differ between two versions of a
+// segment.
+type SegmentVersionDiff struct {
+	From    int
+	To      int
+	Changes []segment.FieldChange
+}
+
+// DiffSegmentVersionsHandler defines the interface for comparing two
+// revisions of a segment.
+type DiffSegmentVersionsHandler interface {
+	Handle(ctx context.Context, query DiffSegmentVersions) (*SegmentVersionDiff, error)
+}
+
+type diffSegmentVersionsHandler struct {
+	segmentRepo segment.Repository
+}
+
+// NewDiffSegmentVersionsHandler creates a new DiffSegmentVersionsHandler.
+func NewDiffSegmentVersionsHandler(segmentRepo segment.Repository) (DiffSegmentVersionsHandler, error) {
+	if segmentRepo == nil {
+		return diffSegmentVersionsHandler{}, errors.New("segment repository is not provided")
+	}
+
+	return diffSegmentVersionsHandler{segmentRepo}, nil
+}
+
+// Handle returns the fields changed from one version of a segment to the
+// other.
+func (h diffSegmentVersionsHandler) Handle(ctx context.Context, query DiffSegmentVersions) (*SegmentVersionDiff, error) {
+	from, err := h.segmentRepo.GetRevision(ctx, query.ID, query.From)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get version %d of segment '%d': %w", query.From, query.ID, err)
+	}
+
+	var to *segment.Revision
+	if query.To != nil {
+		if to, err = h.segmentRepo.GetRevision(ctx, query.ID, *query.To); err != nil {
+			return nil, fmt.Errorf("failed to get version %d of segment '%d': %w", *query.To, query.ID, err)
+		}
+	} else {
+		revisions, err := h.segmentRepo.ListRevisions(ctx, query.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list versions of segment '%d': %w", query.ID, err)
+		}
+		to = &revisions[len(revisions)-1]
+	}
+
+	return &SegmentVersionDiff{
+		From:    from.Version,
+		To:      to.Version,
+		Changes: segment.Diff(from.Segment, to.Segment),
+	}, nil
+}
+
+// RollbackSegment holds the segment and the version of it to restore.
+type RollbackSegment struct {
+	ID      int
+	Version int
+}
+
+// RollbackSegmentHandler defines the interface for restoring a previous
+// version of a segment.
+type RollbackSegmentHandler interface {
+	Handle(ctx context.Context, cmd RollbackSegment) (*segment.Segment, error)
+}
+
+type rollbackSegmentHandler struct {
+	segmentRepo segment.Repository
+	update      UpdateSegmentHandler
+}
+
+// NewRollbackSegmentHandler creates a new RollbackSegmentHandler saving the
+// restored version through update.
+func NewRollbackSegmentHandler(segmentRepo segment.Repository, update UpdateSegmentHandler) (RollbackSegmentHandler, error) {
+	if segmentRepo == nil {
+		return rollbackSegmentHandler{}, errors.New("segment repository is not provided")
+	}
+	if update == nil {
+		return rollbackSegmentHandler{}, errors.New("update segment handler is not provided")
+	}
+
+	return rollbackSegmentHandler{segmentRepo, update}, nil
+}
+
+// Handle updates a segment to the fields of one of its versions, which saves
+// them as a new revision. The state is not part of an update and stays as it
+// is; expressions are checked against the segments existing now.
+func (h rollbackSegmentHandler) Handle(ctx context.Context, cmd RollbackSegment) (*segment.Segment, error) {
+	revision, err := h.segmentRepo.GetRevision(ctx, cmd.ID, cmd.Version)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get version %d of segment '%d': %w", cmd.Version, cmd.ID, err)
+	}
+
+	s := revision.Segment
+	var expr string
+	if s.Expression() != nil {
+		expr = s.Expression().String()
+	}
+	return h.update.Handle(ctx, UpdateSegment{
+		ID:          cmd.ID,
+		Name:        s.Name(),
+		Description: s.Description(),
+		Labels:      s.Labels(),
+		TTLSeconds:  s.TTLSeconds(),
+		ActiveFrom:  s.ActiveFrom(),
+		ActiveUntil: s.ActiveUntil(),
+		Expression:  expr,
+	})
+}
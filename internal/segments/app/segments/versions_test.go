@@ -0,0 +1,187 @@
+package segments_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rickKoch/nexus/internal/segments/adapters"
+	"github.com/rickKoch/nexus/internal/segments/app/segments"
+	"github.com/rickKoch/nexus/internal/segments/domain/segment"
+	"github.com/rickKoch/nexus/pkg/clock"
+)
+
+func TestSegmentVersions(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2026, 11, 1, 12, 0, 0, 0, time.UTC)
+
+	type fixture struct {
+		clk      *clock.Fake
+		create   segments.CreateSegmentHandler
+		update   segments.UpdateSegmentHandler
+		list     segments.ListSegmentVersionsHandler
+		get      segments.GetSegmentVersionHandler
+		diff     segments.DiffSegmentVersionsHandler
+		rollback segments.RollbackSegmentHandler
+	}
+	setup := func(t *testing.T) fixture {
+		t.Helper()
+
+		clk := clock.NewFake(start)
+		repo := adapters.NewInMemorySegmentRepository()
+		create, _ := segments.NewCreateSegmentHandler(repo, clk, repo)
+		update, _ := segments.NewUpdateSegmentHandler(repo, clk)
+		list, _ := segments.NewListSegmentVersionsHandler(repo)
+		get, _ := segments.NewGetSegmentVersionHandler(repo)
+		diff, _ := segments.NewDiffSegmentVersionsHandler(repo)
+		rollback, err := segments.NewRollbackSegmentHandler(repo, update)
+		if err != nil {
+			t.Fatalf("failed to create handler: %v", err)
+		}
+		return fixture{clk, create, update, list, get, diff, rollback}
+	}
+	// history creates a segment and updates it twice, an hour apart.
+	history := func(t *testing.T, f fixture) *segment.Segment {
+		t.Helper()
+
+		ttl := 3600
+		s, err := f.create.Handle(ctx, segments.CreateSegment{Name: "premium-users", Labels: segment.Labels{"team": "growth"}, TTLSeconds: &ttl})
+		if err != nil {
+			t.Fatalf("failed to create segment: %v", err)
+		}
+		f.clk.Advance(time.Hour)
+		if _, err := f.update.Handle(ctx, segments.UpdateSegment{ID: s.ID(), Name: "paying-users", Labels: segment.Labels{"team": "growth"}}); err != nil {
+			t.Fatalf("failed to update segment: %v", err)
+		}
+		f.clk.Advance(time.Hour)
+		if _, err := f.update.Handle(ctx, segments.UpdateSegment{ID: s.ID(), Name: "paying-users", Description: "Paid plans"}); err != nil {
+			t.Fatalf("failed to update segment: %v", err)
+		}
+		return s
+	}
+
+	t.Run("lists and gets versions", func(t *testing.T) {
+		f := setup(t)
+		s := history(t, f)
+
+		revisions, err := f.list.Handle(ctx, segments.ListSegmentVersions{ID: s.ID()})
+		if err != nil || len(revisions) != 3 {
+			t.Fatalf("expected 3 versions, got %d (%v)", len(revisions), err)
+		}
+		if got := revisions[1].Segment; got.Name() != "paying-users" || !got.UpdatedAt().Equal(start.Add(time.Hour)) {
+			t.Errorf("expected paying-users saved at %v, got %s at %v", start.Add(time.Hour), got.Name(), got.UpdatedAt())
+		}
+
+		got, err := f.get.Handle(ctx, segments.GetSegmentVersion{ID: s.ID(), Version: 1})
+		if err != nil || got.Segment.Name() != "premium-users" {
+			t.Errorf("expected version 1 to be premium-users, got %+v (%v)", got, err)
+		}
+		if _, err := f.get.Handle(ctx, segments.GetSegmentVersion{ID: s.ID(), Version: 4}); !errors.Is(err, segment.ErrRevisionNotFound) {
+			t.Errorf("expected %v, got %v", segment.ErrRevisionNotFound, err)
+		}
+		if _, err := f.list.Handle(ctx, segments.ListSegmentVersions{ID: 999}); !errors.Is(err, segment.ErrSegmentNotFound) {
+			t.Errorf("expected %v, got %v", segment.ErrSegmentNotFound, err)
+		}
+	})
+
+	t.Run("diffs versions", func(t *testing.T) {
+		f := setup(t)
+		s := history(t, f)
+
+		diff, err := f.diff.Handle(ctx, segments.DiffSegmentVersions{ID: s.ID(), From: 1})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if diff.From != 1 || diff.To != 3 {
+			t.Errorf("expected versions 1 to 3, got %d to %d", diff.From, diff.To)
+		}
+		var fields []string
+		for _, c := range diff.Changes {
+			fields = append(fields, c.Field)
+		}
+		want := []string{segment.FieldName, segment.FieldDescription, segment.FieldLabels, segment.FieldTTLSeconds}
+		if len(fields) != len(want) {
+			t.Fatalf("expected changes to %v, got %+v", want, diff.Changes)
+		}
+		for i := range want {
+			if fields[i] != want[i] {
+				t.Errorf("expected changes to %v, got %v", want, fields)
+			}
+		}
+		if c := diff.Changes[3]; c.From != 3600 || c.To != nil {
+			t.Errorf("expected the TTL to change from 3600 to none, got %v to %v", c.From, c.To)
+		}
+
+		to := 2
+		diff, err = f.diff.Handle(ctx, segments.DiffSegmentVersions{ID: s.ID(), From: 3, To: &to})
+		if err != nil || len(diff.Changes) != 2 || diff.Changes[0].Field != segment.FieldDescription || diff.Changes[0].From != "Paid plans" {
+			t.Errorf("expected the description and labels to change back, got %+v (%v)", diff, err)
+		}
+
+		to = 7
+		if _, err := f.diff.Handle(ctx, segments.DiffSegmentVersions{ID: s.ID(), From: 1, To: &to}); !errors.Is(err, segment.ErrRevisionNotFound) {
+			t.Errorf("expected %v, got %v", segment.ErrRevisionNotFound, err)
+		}
+	})
+
+	t.Run("rolls back to a version", func(t *testing.T) {
+		f := setup(t)
+		s := history(t, f)
+		f.clk.Advance(time.Hour)
+
+		rolledBack, err := f.rollback.Handle(ctx, segments.RollbackSegment{ID: s.ID(), Version: 1})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if rolledBack.Name() != "premium-users" || rolledBack.Labels()["team"] != "growth" || !rolledBack.UpdatedAt().Equal(f.clk.Now()) {
+			t.Errorf("expected premium-users updated now, got %s %v updated at %v", rolledBack.Name(), rolledBack.Labels(), rolledBack.UpdatedAt())
+		}
+
+		to := 4
+		diff, err := f.diff.Handle(ctx, segments.DiffSegmentVersions{ID: s.ID(), From: 1, To: &to})
+		if err != nil || len(diff.Changes) != 0 {
+			t.Errorf("expected version 4 to equal version 1, got %+v (%v)", diff, err)
+		}
+	})
+
+	t.Run("keeps the state when rolling back", func(t *testing.T) {
+		clk := clock.NewFake(start)
+		repo := adapters.NewInMemorySegmentRepository()
+		create, _ := segments.NewCreateSegmentHandler(repo, clk, repo)
+		update, _ := segments.NewUpdateSegmentHandler(repo, clk)
+		transition, _ := segments.NewTransitionSegmentHandler(repo, clk)
+		rollback, _ := segments.NewRollbackSegmentHandler(repo, update)
+
+		s, _ := create.Handle(ctx, segments.CreateSegment{Name: "premium-users"})
+		if _, err := transition.Handle(ctx, segments.TransitionSegment{ID: s.ID(), State: segment.StateActive}); err != nil {
+			t.Fatalf("failed to activate segment: %v", err)
+		}
+
+		got, err := rollback.Handle(ctx, segments.RollbackSegment{ID: s.ID(), Version: 1})
+		if err != nil || got.State() != segment.StateActive {
+			t.Errorf("expected the segment to stay active, got %v (%v)", got, err)
+		}
+	})
+
+	t.Run("rejects missing versions", func(t *testing.T) {
+		f := setup(t)
+		s := history(t, f)
+
+		if _, err := f.rollback.Handle(ctx, segments.RollbackSegment{ID: s.ID(), Version: 9}); !errors.Is(err, segment.ErrRevisionNotFound) {
+			t.Errorf("expected %v, got %v", segment.ErrRevisionNotFound, err)
+		}
+		if _, err := f.rollback.Handle(ctx, segments.RollbackSegment{ID: 999, Version: 1}); !errors.Is(err, segment.ErrSegmentNotFound) {
+			t.Errorf("expected %v, got %v", segment.ErrSegmentNotFound, err)
+		}
+	})
+
+	t.Run("requires its dependencies", func(t *testing.T) {
+		if _, err := segments.NewRollbackSegmentHandler(adapters.NewInMemorySegmentRepository(), nil); err == nil {
+			t.Error("expected an error without an update handler")
+		}
+		if _, err := segments.NewListSegmentVersionsHandler(nil); err == nil {
+			t.Error("expected an error without a repository")
+		}
+	})
+}
@@ -0,0 +1,198 @@
+package segment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// EventType is the kind of change an Event records.
+type EventType string
+
+const (
+	// EventCreated starts the history of a segment.
+	EventCreated EventType = "created"
+	// EventUpdated replaces the mutable fields of a segment.
+	EventUpdated EventType = "updated"
+	// EventTransitioned moves a segment to another state.
+	EventTransitioned EventType = "transitioned"
+	// EventDeleted ends the history of a segment.
+	EventDeleted EventType = "deleted"
+)
+
+var (
+	// ErrVersionConflict is returned when appending events to a segment
+	// whose history moved on concurrently.
+	ErrVersionConflict = errors.New("segment was modified concurrently")
+	// ErrInvalidEvent is returned when events cannot be applied in order.
+	ErrInvalidEvent = errors.New("invalid segment event")
+)
+
+// Event records a change to a segment. The events of a segment are numbered
+// by Version from 1 without gaps.
+type Event struct {
+	SegmentID int
+	Version   int
+	Type      EventType
+	At        time.Time
+	// Config holds the fields of the segment after an EventCreated or
+	// EventUpdated. Its State is the initial state of a created segment.
+	Config SegmentConfig
+	// State is the state of the segment after an EventTransitioned.
+	State State
+}
+
+// Snapshot is a segment folded from its events up to and including Version.
+type Snapshot struct {
+	Version int
+	// At is when the event at Version happened.
+	At      time.Time
+	Segment *Segment
+}
+
+// EventStore persists the history of segments.
+type EventStore interface {
+	// AppendEvents stores events, which continue the history of the segment
+	// with the given ID after expectedVersion. It returns ErrVersionConflict
+	// if events after expectedVersion exist already.
+	AppendEvents(ctx context.Context, segmentID int, expectedVersion int, events []Event) error
+	// LoadEvents returns the events of a segment after afterVersion, in
+	// version order, up to the last one that happened at or before until.
+	// A nil until returns all of them.
+	LoadEvents(ctx context.Context, segmentID int, afterVersion int, until *time.Time) ([]Event, error)
+	// LoadSnapshot returns the latest snapshot of a segment among those
+	// LoadEvents would return events up to, or nil if there is none.
+	LoadSnapshot(ctx context.Context, segmentID int, until *time.Time) (*Snapshot, error)
+	// SaveSnapshot stores a snapshot, replacing one of the same version.
+	SaveSnapshot(ctx context.Context, segmentID int, snapshot Snapshot) error
+}
+
+// HistoryReader reads segments as they were in the past.
+type HistoryReader interface {
+	// GetAsOf returns the segment with the given ID as it was at at. It
+	// returns ErrSegmentNotFound if the segment did not exist or was
+	// deleted by then.
+	GetAsOf(ctx context.Context, id int, at time.Time) (*Segment, error)
+}
+
+// Changes returns the events turning before into after, unnumbered, or an
+// EventCreated of after if before is nil. The events happen when after was
+// last updated.
+func Changes(before, after *Segment) []Event {
+	at := after.updatedAt
+	if before == nil {
+		c := after.config()
+		c.State = after.state
+		return []Event{{SegmentID: after.id, Type: EventCreated, At: after.createdAt, Config: c}}
+	}
+
+	var events []Event
+	if !before.config().equal(after.config()) {
+		events = append(events, Event{SegmentID: after.id, Type: EventUpdated, At: at, Config: after.config()})
+	}
+	if before.state != after.state {
+		events = append(events, Event{SegmentID: after.id, Type: EventTransitioned, At: at, State: after.state})
+	}
+	if before.deletedAt == nil && after.deletedAt != nil {
+		events = append(events, Event{SegmentID: after.id, Type: EventDeleted, At: *after.deletedAt})
+	}
+	if len(events) == 0 && !before.updatedAt.Equal(at) {
+		// Saving an unchanged segment still touches it.
+		events = append(events, Event{SegmentID: after.id, Type: EventUpdated, At: at, Config: after.config()})
+	}
+	return events
+}
+
+// Replay folds events into the segment of from, or into a new segment if
+// from is nil, and returns the result. from is not modified. Member counts
+// are not part of the history and stay as in from.
+func Replay(from *Segment, events []Event) (*Segment, error) {
+	var s *Segment
+	if from != nil {
+		copied := *from
+		copied.labels = from.labels.Clone()
+		s = &copied
+	}
+
+	for _, e := range events {
+		if s == nil && e.Type != EventCreated {
+			return nil, fmt.Errorf("%w: %s event %d of segment '%d' before its creation", ErrInvalidEvent, e.Type, e.Version, e.SegmentID)
+		}
+		if s != nil && s.deletedAt != nil {
+			return nil, fmt.Errorf("%w: %s event %d of deleted segment '%d'", ErrInvalidEvent, e.Type, e.Version, e.SegmentID)
+		}
+
+		switch e.Type {
+		case EventCreated:
+			if s != nil {
+				return nil, fmt.Errorf("%w: segment '%d' created twice", ErrInvalidEvent, e.SegmentID)
+			}
+			s = &Segment{id: e.SegmentID, state: e.Config.State, createdAt: e.At}
+			s.setConfig(e.Config)
+		case EventUpdated:
+			s.setConfig(e.Config)
+		case EventTransitioned:
+			s.state = e.State
+		case EventDeleted:
+			at := e.At
+			s.deletedAt = &at
+		default:
+			return nil, fmt.Errorf("%w: unknown type '%s'", ErrInvalidEvent, e.Type)
+		}
+		s.updatedAt = e.At
+	}
+
+	if s == nil {
+		return nil, ErrSegmentNotFound
+	}
+	return s, nil
+}
+
+// config returns the mutable fields of the segment.
+func (s *Segment) config() SegmentConfig {
+	return SegmentConfig{
+		Name:        s.name,
+		Description: s.description,
+		Labels:      s.labels.Clone(),
+		TTLSeconds:  s.ttlSeconds,
+		ActiveFrom:  s.activeFrom,
+		ActiveUntil: s.activeUntil,
+		Expression:  s.expression,
+	}
+}
+
+func (s *Segment) setConfig(c SegmentConfig) {
+	s.name = c.Name
+	s.description = c.Description
+	s.labels = c.Labels.Clone()
+	s.ttlSeconds = c.TTLSeconds
+	s.activeFrom = c.ActiveFrom
+	s.activeUntil = c.ActiveUntil
+	s.expression = c.Expression
+}
+
+// equal reports whether c and other hold the same fields, ignoring State.
+func (c SegmentConfig) equal(other SegmentConfig) bool {
+	return c.Name == other.Name &&
+		c.Description == other.Description &&
+		c.Labels.Equal(other.Labels) &&
+		equalPtr(c.TTLSeconds, other.TTLSeconds) &&
+		equalTimePtr(c.ActiveFrom, other.ActiveFrom) &&
+		equalTimePtr(c.ActiveUntil, other.ActiveUntil) &&
+		c.Expression.Equal(other.Expression)
+}
+
+func equalPtr[T comparable](a, b *T) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func equalTimePtr(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
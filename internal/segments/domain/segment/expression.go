@@ -0,0 +1,310 @@
+package segment
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// MaxExpressionLength is the maximum length of a composite segment expression.
+const MaxExpressionLength = 1024
+
+var (
+	// ErrInvalidExpression is returned for a malformed composite segment expression.
+	ErrInvalidExpression = errors.New("invalid segment expression")
+	// ErrExpressionTooLong is returned when an expression exceeds MaxExpressionLength.
+	ErrExpressionTooLong = fmt.Errorf("segment expression must be %d characters or less", MaxExpressionLength)
+	// ErrUnknownReference is returned when an expression references a segment
+	// that does not exist.
+	ErrUnknownReference = errors.New("segment expression references a segment that does not exist")
+	// ErrReferenceCycle is returned when a composite segment would depend on itself.
+	ErrReferenceCycle = errors.New("segment expression forms a reference cycle")
+	// ErrSegmentReferenced is returned when deleting a segment that a
+	// composite segment still references.
+	ErrSegmentReferenced = errors.New("segment is referenced by a composite segment")
+)
+
+// Operator is the kind of an expression node.
+type Operator string
+
+const (
+	// OpSegment is a reference to the members of another segment.
+	OpSegment Operator = "segment"
+	// OpAnd is the intersection of its operands.
+	OpAnd Operator = "and"
+	// OpOr is the union of its operands.
+	OpOr Operator = "or"
+	// OpNot is the complement of its single operand. Combined with OpAnd it
+	// excludes one segment from another.
+	OpNot Operator = "not"
+)
+
+// Expression is a boolean expression over segment IDs that defines the
+// members of a composite segment, for example "1 AND NOT 2".
+type Expression struct {
+	Op Operator
+	// SegmentID is the referenced segment of an OpSegment node.
+	SegmentID int
+	// Operands are the children of OpAnd, OpOr and OpNot nodes.
+	Operands []*Expression
+}
+
+// Ref returns an expression referencing the segment with the given ID.
+func Ref(id int) *Expression { return &Expression{Op: OpSegment, SegmentID: id} }
+
+// And returns the intersection of operands.
+func And(operands ...*Expression) *Expression { return &Expression{Op: OpAnd, Operands: operands} }
+
+// Or returns the union of operands.
+func Or(operands ...*Expression) *Expression { return &Expression{Op: OpOr, Operands: operands} }
+
+// Not returns the complement of operand.
+func Not(operand *Expression) *Expression {
+	return &Expression{Op: OpNot, Operands: []*Expression{operand}}
+}
+
+// ParseExpression parses the textual form of an expression. Operands are
+// segment IDs combined with AND, OR and NOT (case-insensitive) and grouped
+// with parentheses. NOT binds tighter than AND, which binds tighter than OR.
+func ParseExpression(s string) (*Expression, error) {
+	if len(s) > MaxExpressionLength {
+		return nil, ErrExpressionTooLong
+	}
+
+	p := &expressionParser{tokens: tokenize(s)}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok != "" {
+		return nil, fmt.Errorf("%w: unexpected '%s'", ErrInvalidExpression, tok)
+	}
+
+	return expr, nil
+}
+
+// Validate checks the structure of the expression.
+func (e *Expression) Validate() error {
+	if e == nil {
+		return fmt.Errorf("%w: empty expression", ErrInvalidExpression)
+	}
+
+	switch e.Op {
+	case OpSegment:
+		if e.SegmentID <= 0 {
+			return fmt.Errorf("%w: segment ID must be positive, got %d", ErrInvalidExpression, e.SegmentID)
+		}
+		return nil
+	case OpAnd, OpOr:
+		if len(e.Operands) < 2 {
+			return fmt.Errorf("%w: %s needs at least two operands", ErrInvalidExpression, e.Op)
+		}
+	case OpNot:
+		if len(e.Operands) != 1 {
+			return fmt.Errorf("%w: not needs exactly one operand", ErrInvalidExpression)
+		}
+	default:
+		return fmt.Errorf("%w: unknown operator '%s'", ErrInvalidExpression, e.Op)
+	}
+
+	for _, operand := range e.Operands {
+		if err := operand.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SegmentIDs returns the IDs of the segments the expression references
+// directly, in ascending order and without duplicates.
+func (e *Expression) SegmentIDs() []int {
+	seen := make(map[int]bool)
+	var walk func(e *Expression)
+	walk = func(e *Expression) {
+		if e.Op == OpSegment {
+			seen[e.SegmentID] = true
+		}
+		for _, operand := range e.Operands {
+			walk(operand)
+		}
+	}
+	walk(e)
+
+	ids := make([]int, 0, len(seen))
+	for id := range seen {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// Evaluate resolves membership: it reports whether a subject belongs to the
+// composite segment, given whether it belongs to each referenced segment.
+func (e *Expression) Evaluate(isMember func(segmentID int) bool) bool {
+	switch e.Op {
+	case OpSegment:
+		return isMember(e.SegmentID)
+	case OpAnd:
+		for _, operand := range e.Operands {
+			if !operand.Evaluate(isMember) {
+				return false
+			}
+		}
+		return true
+	case OpOr:
+		for _, operand := range e.Operands {
+			if operand.Evaluate(isMember) {
+				return true
+			}
+		}
+		return false
+	case OpNot:
+		return !e.Operands[0].Evaluate(isMember)
+	}
+	return false
+}
+
+// String returns the canonical textual form of the expression, which
+// ParseExpression accepts.
+func (e *Expression) String() string {
+	switch e.Op {
+	case OpSegment:
+		return strconv.Itoa(e.SegmentID)
+	case OpNot:
+		return "NOT " + e.Operands[0].operandString(OpNot)
+	case OpAnd, OpOr:
+		parts := make([]string, 0, len(e.Operands))
+		for _, operand := range e.Operands {
+			parts = append(parts, operand.operandString(e.Op))
+		}
+		return strings.Join(parts, " "+strings.ToUpper(string(e.Op))+" ")
+	}
+	return ""
+}
+
+// Equal reports whether e and other have the same canonical form.
+func (e *Expression) Equal(other *Expression) bool {
+	if e == nil || other == nil {
+		return e == other
+	}
+	return e.String() == other.String()
+}
+
+// operandString formats e as an operand of parent, adding parentheses where
+// precedence requires them.
+func (e *Expression) operandString(parent Operator) string {
+	if e.Op == OpSegment || e.Op == OpNot || e.Op == parent || (parent == OpOr && e.Op == OpAnd) {
+		return e.String()
+	}
+	return "(" + e.String() + ")"
+}
+
+// tokenize splits s into parentheses, numbers and words.
+func tokenize(s string) []string {
+	var tokens []string
+	for i := 0; i < len(s); {
+		r := rune(s[i])
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(' || r == ')':
+			tokens = append(tokens, string(r))
+			i++
+		default:
+			j := i
+			for j < len(s) && !unicode.IsSpace(rune(s[j])) && s[j] != '(' && s[j] != ')' {
+				j++
+			}
+			tokens = append(tokens, s[i:j])
+			i = j
+		}
+	}
+	return tokens
+}
+
+type expressionParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *expressionParser) peek() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *expressionParser) next() string {
+	tok := p.peek()
+	p.pos++
+	return tok
+}
+
+func (p *expressionParser) parseOr() (*Expression, error) {
+	return p.parseBinary(OpOr, p.parseAnd)
+}
+
+func (p *expressionParser) parseAnd() (*Expression, error) {
+	return p.parseBinary(OpAnd, p.parseUnary)
+}
+
+// parseBinary parses operands separated by op into a single flat node.
+func (p *expressionParser) parseBinary(op Operator, operand func() (*Expression, error)) (*Expression, error) {
+	first, err := operand()
+	if err != nil {
+		return nil, err
+	}
+
+	operands := []*Expression{first}
+	for strings.EqualFold(p.peek(), string(op)) {
+		p.next()
+		next, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, next)
+	}
+
+	if len(operands) == 1 {
+		return first, nil
+	}
+	return &Expression{Op: op, Operands: operands}, nil
+}
+
+func (p *expressionParser) parseUnary() (*Expression, error) {
+	if strings.EqualFold(p.peek(), string(OpNot)) {
+		p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return Not(operand), nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *expressionParser) parsePrimary() (*Expression, error) {
+	tok := p.next()
+	switch tok {
+	case "":
+		return nil, fmt.Errorf("%w: unexpected end of expression", ErrInvalidExpression)
+	case "(":
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, fmt.Errorf("%w: missing ')'", ErrInvalidExpression)
+		}
+		return expr, nil
+	}
+
+	id, err := strconv.Atoi(tok)
+	if err != nil || id <= 0 {
+		return nil, fmt.Errorf("%w: expected a segment ID, got '%s'", ErrInvalidExpression, tok)
+	}
+	return Ref(id), nil
+}
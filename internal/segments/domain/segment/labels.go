@@ -0,0 +1,109 @@
+package segment
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// MaxDescriptionLength is the maximum length of a segment description.
+	MaxDescriptionLength = 1024
+	// MaxLabels is the maximum number of labels on a segment.
+	MaxLabels = 64
+	// MaxLabelKeyLength is the maximum length of a label key.
+	MaxLabelKeyLength = 63
+	// MaxLabelValueLength is the maximum length of a label value.
+	MaxLabelValueLength = 255
+)
+
+var (
+	// ErrDescriptionTooLong is returned when the description exceeds MaxDescriptionLength.
+	ErrDescriptionTooLong = fmt.Errorf("segment description must be %d characters or less", MaxDescriptionLength)
+	// ErrTooManyLabels is returned when a segment has more than MaxLabels labels.
+	ErrTooManyLabels = fmt.Errorf("segment must have %d labels or less", MaxLabels)
+	// ErrInvalidLabelKey is returned for a label key that does not match labelKeyPattern.
+	ErrInvalidLabelKey = errors.New("label key must be 1-63 lowercase alphanumeric characters, '-', '_', '.' or '/', starting and ending with an alphanumeric character")
+	// ErrLabelValueTooLong is returned when a label value exceeds MaxLabelValueLength.
+	ErrLabelValueTooLong = fmt.Errorf("label value must be %d characters or less", MaxLabelValueLength)
+	// ErrInvalidLabelSelector is returned for a malformed label selector.
+	ErrInvalidLabelSelector = errors.New("label selector must have the form key:value")
+)
+
+var labelKeyPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9._/-]*[a-z0-9])?$`)
+
+// Labels are key/value pairs used to organize segments.
+type Labels map[string]string
+
+// Validate checks the number of labels and every key and value.
+func (l Labels) Validate() error {
+	if len(l) > MaxLabels {
+		return ErrTooManyLabels
+	}
+
+	for key, value := range l {
+		if len(key) > MaxLabelKeyLength || !labelKeyPattern.MatchString(key) {
+			return fmt.Errorf("%w: '%s'", ErrInvalidLabelKey, key)
+		}
+		if len(value) > MaxLabelValueLength {
+			return fmt.Errorf("%w: '%s'", ErrLabelValueTooLong, key)
+		}
+	}
+
+	return nil
+}
+
+// Clone returns a copy of the labels. The copy of nil labels is empty.
+func (l Labels) Clone() Labels {
+	c := make(Labels, len(l))
+	for key, value := range l {
+		c[key] = value
+	}
+	return c
+}
+
+// Equal reports whether l and other contain the same labels.
+func (l Labels) Equal(other Labels) bool {
+	if len(l) != len(other) {
+		return false
+	}
+	for key, value := range l {
+		if v, ok := other[key]; !ok || v != value {
+			return false
+		}
+	}
+	return true
+}
+
+// LabelSelector selects segments carrying all of its labels.
+type LabelSelector Labels
+
+// ParseLabelSelector parses expressions of the form key:value. A segment
+// must match every expression to be selected.
+func ParseLabelSelector(exprs []string) (LabelSelector, error) {
+	selector := make(LabelSelector, len(exprs))
+	for _, expr := range exprs {
+		key, value, ok := strings.Cut(expr, ":")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("%w, got '%s'", ErrInvalidLabelSelector, expr)
+		}
+		if v, dup := selector[key]; dup && v != value {
+			// No segment can have two values for the same key.
+			return nil, fmt.Errorf("%w: conflicting values for '%s'", ErrInvalidLabelSelector, key)
+		}
+		selector[key] = value
+	}
+
+	return selector, nil
+}
+
+// Matches reports whether labels contain every label of the selector.
+func (s LabelSelector) Matches(labels Labels) bool {
+	for key, value := range s {
+		if v, ok := labels[key]; !ok || v != value {
+			return false
+		}
+	}
+	return true
+}
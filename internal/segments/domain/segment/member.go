@@ -0,0 +1,86 @@
+package segment
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// MaxMemberIDLength is the maximum length of a member ID.
+const MaxMemberIDLength = 255
+
+var (
+	// ErrInvalidMemberID is returned for an empty or overly long member ID.
+	ErrInvalidMemberID = fmt.Errorf("member ID must be 1-%d characters", MaxMemberIDLength)
+	// ErrCompositeMembers is returned when modifying the members of a
+	// composite segment, which are derived from its expression.
+	ErrCompositeMembers = errors.New("members of a composite segment cannot be modified")
+)
+
+// Member is a subject, such as a user, belonging to a segment.
+type Member struct {
+	ID      string
+	AddedAt time.Time
+	// ExpiresAt is when the membership lapses, derived from the segment's
+	// TTL. Nil memberships do not expire.
+	ExpiresAt *time.Time
+}
+
+// IsLive reports whether the membership has not expired at the given time.
+func (m Member) IsLive(at time.Time) bool {
+	return m.ExpiresAt == nil || at.Before(*m.ExpiresAt)
+}
+
+// MemberQuery selects the subjects whose memberships satisfy Expression at
+// At. A subject is a candidate when it is a live member of any segment the
+// expression references, so "NOT 2" alone selects nothing. Expression
+// references regular segments only; composite references are expanded
+// beforehand. Only subjects with an ID greater than After are selected.
+type MemberQuery struct {
+	Expression *Expression
+	At         time.Time
+	After      string
+}
+
+// NewMember creates a membership of the subject with the given ID, added at
+// now and expiring after the segment's TTL. Composite and archived segments
+// cannot gain members.
+func (s *Segment) NewMember(id string, now time.Time) (Member, error) {
+	if err := s.checkMembersModifiable(); err != nil {
+		return Member{}, err
+	}
+	if err := ValidateMemberID(id); err != nil {
+		return Member{}, err
+	}
+
+	m := Member{ID: id, AddedAt: now}
+	if s.ttlSeconds != nil {
+		expiresAt := now.Add(time.Duration(*s.ttlSeconds) * time.Second)
+		m.ExpiresAt = &expiresAt
+	}
+	return m, nil
+}
+
+// CanRemoveMembers reports why members cannot be removed from the segment,
+// or nil if they can.
+func (s *Segment) CanRemoveMembers() error {
+	return s.checkMembersModifiable()
+}
+
+func (s *Segment) checkMembersModifiable() error {
+	if s.IsComposite() {
+		return ErrCompositeMembers
+	}
+	if s.state == StateArchived {
+		return ErrSegmentArchived
+	}
+	return nil
+}
+
+// ValidateMemberID checks the length of a member ID.
+func ValidateMemberID(id string) error {
+	if id == "" || len(id) > MaxMemberIDLength {
+		return fmt.Errorf("%w, got '%s'", ErrInvalidMemberID, id)
+	}
+	return nil
+}
@@ -1,11 +1,27 @@
 package segment
 
-import "context"
+import (
+	"context"
+	"errors"
+	"time"
+)
 
-// ListParams contains pagination parameters for listing segments.
+// ErrUnavailable is returned when segments cannot be stored or read for the
+// time being, such as while the database fails over. The same call may
+// succeed when retried later.
+var ErrUnavailable = errors.New("segment storage is unavailable")
+
+// ListParams contains pagination parameters for listing segments. Only
+// segments matching Labels and in one of States are listed; an empty
+// selector or state list matches all. If Live is set, only the segments
+// whose IsMatchable at At equals it are listed.
 type ListParams struct {
 	Page     int
 	PageSize int
+	Labels   LabelSelector
+	States   []State
+	Live     *bool
+	At       time.Time
 }
 
 // ListResult contains the paginated list of segments and total count.
@@ -16,10 +32,90 @@ type ListResult struct {
 	PageSize   int
 }
 
+// IDGenerator hands out the identifiers of new segments.
+type IDGenerator interface {
+	NextID(ctx context.Context) (int, error)
+}
+
+// Repository persists segments. Implementations store the IDs and timestamps
+// set on the domain objects rather than assigning their own.
 type Repository interface {
 	List(ctx context.Context, params ListParams) (*ListResult, error)
+	// Get returns a non-deleted segment. Within a transaction the segment
+	// stays locked until the transaction ends.
 	Get(ctx context.Context, id int) (*Segment, error)
+	// GetShared returns a non-deleted segment like Get, but other
+	// transactions may read it with GetShared while it is locked. Use it for
+	// segments that are referenced rather than changed.
+	GetShared(ctx context.Context, id int) (*Segment, error)
+	// Create stores a new segment and its first revision.
 	Create(ctx context.Context, segment *Segment) (*Segment, error)
+	// Update stores the changes to a segment as its next revision.
 	Update(ctx context.Context, segment *Segment) (*Segment, error)
-	Delete(ctx context.Context, id int) error
+	// Delete persists the deletion of a segment marked with Segment.Delete.
+	Delete(ctx context.Context, segment *Segment) error
+
+	// ListRevisions returns the revisions of a non-deleted segment, oldest
+	// first.
+	ListRevisions(ctx context.Context, segmentID int) ([]Revision, error)
+	// GetRevision returns the revision of a non-deleted segment with the
+	// given version, or ErrRevisionNotFound if it has none.
+	GetRevision(ctx context.Context, segmentID, version int) (*Revision, error)
+
+	// ListReferencing returns the non-deleted composite segments whose
+	// expression references the segment with the given ID, ordered by ID.
+	ListReferencing(ctx context.Context, id int) ([]Segment, error)
+
+	// AddMembers stores memberships of the segment with the given ID,
+	// replacing existing memberships of the same subjects, and increments the
+	// segment's member count by the number of new subjects. Member IDs must
+	// be unique within members.
+	AddMembers(ctx context.Context, segmentID int, members []Member) error
+	// RemoveMembers removes the memberships of the given subjects and
+	// decrements the segment's member count accordingly. Removing any
+	// membership discards the segment's sketch. Subjects that are not
+	// members are ignored.
+	RemoveMembers(ctx context.Context, segmentID int, memberIDs []string) error
+	// StreamMembers calls fn with the ID of every subject selected by q, in
+	// ascending order, and stops at the first error fn returns.
+	StreamMembers(ctx context.Context, q MemberQuery, fn func(memberID string) error) error
+	// StreamMemberships calls fn with every membership of a segment,
+	// including expired ones not purged yet, in ascending member ID order,
+	// and stops at the first error fn returns.
+	StreamMemberships(ctx context.Context, segmentID int, fn func(m Member) error) error
+	// ListMemberships returns the IDs, among segmentIDs, of the segments the
+	// subject is a live member of at at, in ascending order.
+	ListMemberships(ctx context.Context, memberID string, segmentIDs []int, at time.Time) ([]int, error)
+
+	// PurgeExpiredMembers removes the memberships that expired at or before
+	// at, keeping member counts in step and discarding the sketches of the
+	// affected segments, and returns how many were removed.
+	PurgeExpiredMembers(ctx context.Context, at time.Time) (int, error)
+	// RecordSizeSnapshots stores the member count of every non-deleted
+	// regular segment as its size on day, replacing snapshots already taken
+	// that day.
+	RecordSizeSnapshots(ctx context.Context, day time.Time) error
+	// ListSizeHistory returns the size snapshots of a segment taken on the
+	// days from from to to inclusive, oldest first.
+	ListSizeHistory(ctx context.Context, segmentID int, from, to time.Time) ([]SizeSnapshot, error)
+
+	// GetSketch returns the sketch of the members of a segment, or nil if it
+	// was discarded and must be rebuilt from the members. Within a
+	// transaction the sketch stays locked until the transaction ends.
+	GetSketch(ctx context.Context, segmentID int) (*Sketch, error)
+	// SaveSketch stores the sketch of the members of a segment.
+	SaveSketch(ctx context.Context, segmentID int, sketch *Sketch) error
+	// ListUnsketchedSegments returns the IDs of the non-deleted regular
+	// segments without a sketch, in ascending order.
+	ListUnsketchedSegments(ctx context.Context) ([]int, error)
+
+	// ListWindowBoundaries returns the non-deleted segments whose activation
+	// window opens or closes after after and up to and including until.
+	ListWindowBoundaries(ctx context.Context, after, until time.Time) ([]Segment, error)
+
+	// RunInTransaction runs fn as a single unit of work. Changes made through
+	// the repository passed to fn are committed if fn returns nil and
+	// discarded otherwise. Calling RunInTransaction on that repository joins
+	// the enclosing unit of work.
+	RunInTransaction(ctx context.Context, fn func(ctx context.Context, repo Repository) error) error
 }
@@ -0,0 +1,74 @@
+package segment
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrRevisionNotFound is returned when a segment has no revision of the
+// requested version.
+var ErrRevisionNotFound = errors.New("segment revision not found")
+
+// Revision is a segment as saved by a create or an update. The revisions of
+// a segment are numbered by Version from 1, the segment as created.
+type Revision struct {
+	Version int
+	// Segment holds the fields and state of the revision. Its UpdatedAt is
+	// when the revision was saved. Member counts are not part of revisions.
+	Segment *Segment
+}
+
+// Diffable fields of a segment, in the order Diff reports them.
+const (
+	FieldName        = "name"
+	FieldDescription = "description"
+	FieldLabels      = "labels"
+	FieldTTLSeconds  = "ttl_seconds"
+	FieldActiveFrom  = "active_from"
+	FieldActiveUntil = "active_until"
+	FieldExpression  = "expression"
+	FieldState       = "state"
+)
+
+// FieldChange is a field whose value differs between two segments. Absent
+// optional values are nil; present ones are dereferenced, and expressions
+// are in their canonical textual form.
+type FieldChange struct {
+	Field string
+	From  any
+	To    any
+}
+
+// Diff returns the fields whose values differ from from to to.
+func Diff(from, to *Segment) []FieldChange {
+	var changes []FieldChange
+	add := func(field string, equal bool, fromValue, toValue any) {
+		if !equal {
+			changes = append(changes, FieldChange{Field: field, From: fromValue, To: toValue})
+		}
+	}
+
+	add(FieldName, from.name == to.name, from.name, to.name)
+	add(FieldDescription, from.description == to.description, from.description, to.description)
+	add(FieldLabels, from.labels.Equal(to.labels), from.labels.Clone(), to.labels.Clone())
+	add(FieldTTLSeconds, equalPtr(from.ttlSeconds, to.ttlSeconds), optional(from.ttlSeconds), optional(to.ttlSeconds))
+	add(FieldActiveFrom, equalTimePtr(from.activeFrom, to.activeFrom), optional(from.activeFrom), optional(to.activeFrom))
+	add(FieldActiveUntil, equalTimePtr(from.activeUntil, to.activeUntil), optional(from.activeUntil), optional(to.activeUntil))
+	add(FieldExpression, from.expression.Equal(to.expression), expressionValue(from.expression), expressionValue(to.expression))
+	add(FieldState, from.state == to.state, from.state, to.state)
+	return changes
+}
+
+func optional[T int | time.Time](v *T) any {
+	if v == nil {
+		return nil
+	}
+	return *v
+}
+
+func expressionValue(e *Expression) any {
+	if e == nil {
+		return nil
+	}
+	return e.String()
+}
@@ -13,14 +13,25 @@ var (
 	ErrNameTooLong = errors.New("segment name must be 255 characters or less")
 	// ErrInvalidTTL is returned when the TTL is not positive.
 	ErrInvalidTTL = errors.New("TTL must be a positive number")
+	// ErrSegmentNotFound is returned when a segment does not exist or has been deleted.
+	ErrSegmentNotFound = errors.New("segment not found")
 )
 
 // Segment represents a segment entity in the domain.
 type Segment struct {
 	id int
 
-	name       string
-	ttlSeconds *int
+	name        string
+	description string
+	labels      Labels
+	ttlSeconds  *int
+	activeFrom  *time.Time
+	activeUntil *time.Time
+	expression  *Expression
+	state       State
+	// memberCount is the number of stored memberships, maintained by the
+	// repository as members are added and removed.
+	memberCount int
 
 	createdAt time.Time
 	updatedAt time.Time
@@ -29,8 +40,20 @@ type Segment struct {
 
 // CreateSegment holds the required parameters for creating a new Segment.
 type SegmentConfig struct {
-	Name       string
-	TTLSeconds *int
+	Name        string
+	Description string
+	Labels      Labels
+	TTLSeconds  *int
+	// ActiveFrom and ActiveUntil bound the window in which the segment is
+	// live. Either may be nil for an open-ended window.
+	ActiveFrom  *time.Time
+	ActiveUntil *time.Time
+	// Expression makes the segment a composite of other segments. Nil
+	// for a regular segment.
+	Expression *Expression
+	// State is the initial state of a new segment and defaults to draft.
+	// It is ignored by Update; use TransitionTo instead.
+	State State
 }
 
 // Validate checks if the CreateSegment fields are valid.
@@ -43,10 +66,34 @@ func (c SegmentConfig) Validate() error {
 		return ErrNameTooLong
 	}
 
+	if len(c.Description) > MaxDescriptionLength {
+		return ErrDescriptionTooLong
+	}
+
+	if err := c.Labels.Validate(); err != nil {
+		return err
+	}
+
 	if c.TTLSeconds != nil && *c.TTLSeconds <= 0 {
 		return ErrInvalidTTL
 	}
 
+	if err := validateWindow(c.ActiveFrom, c.ActiveUntil); err != nil {
+		return err
+	}
+
+	if c.Expression != nil {
+		if err := c.Expression.Validate(); err != nil {
+			return err
+		}
+	}
+
+	switch c.State {
+	case "", StateDraft, StateActive:
+	default:
+		return ErrInvalidInitialState
+	}
+
 	return nil
 }
 
@@ -62,14 +109,26 @@ func NewFactory(sc SegmentConfig) (Factory, error) {
 	return Factory{sc}, nil
 }
 
-// NewSegment creates a new Segment with the factory's configuration.
-func (f Factory) NewSegment() *Segment {
-	now := time.Now()
+// NewSegment creates a new Segment with the factory's configuration, the
+// given ID and now as its creation time.
+func (f Factory) NewSegment(id int, now time.Time) *Segment {
+	state := f.sc.State
+	if state == "" {
+		state = StateDraft
+	}
+
 	return &Segment{
-		name:       f.sc.Name,
-		ttlSeconds: f.sc.TTLSeconds,
-		createdAt:  now,
-		updatedAt:  now,
+		id:          id,
+		name:        f.sc.Name,
+		description: f.sc.Description,
+		labels:      f.sc.Labels.Clone(),
+		ttlSeconds:  f.sc.TTLSeconds,
+		activeFrom:  f.sc.ActiveFrom,
+		activeUntil: f.sc.ActiveUntil,
+		expression:  f.sc.Expression,
+		state:       state,
+		createdAt:   now,
+		updatedAt:   now,
 	}
 }
 
@@ -77,18 +136,32 @@ func (f Factory) NewSegment() *Segment {
 func UnmarshalSegmentFromDatabase(
 	id int,
 	name string,
+	description string,
+	labels Labels,
 	ttlSeconds *int,
+	activeFrom *time.Time,
+	activeUntil *time.Time,
+	expression *Expression,
+	state State,
+	memberCount int,
 	createdAt time.Time,
 	updatedAt time.Time,
 	deletedAt *time.Time,
 ) *Segment {
 	return &Segment{
-		id:         id,
-		name:       name,
-		ttlSeconds: ttlSeconds,
-		createdAt:  createdAt,
-		updatedAt:  updatedAt,
-		deletedAt:  deletedAt,
+		id:          id,
+		name:        name,
+		description: description,
+		labels:      labels.Clone(),
+		ttlSeconds:  ttlSeconds,
+		activeFrom:  activeFrom,
+		activeUntil: activeUntil,
+		expression:  expression,
+		state:       state,
+		memberCount: memberCount,
+		createdAt:   createdAt,
+		updatedAt:   updatedAt,
+		deletedAt:   deletedAt,
 	}
 }
 
@@ -98,9 +171,43 @@ func (s *Segment) ID() int { return s.id }
 // Name returns the segment's name.
 func (s *Segment) Name() string { return s.name }
 
+// Description returns the segment's description.
+func (s *Segment) Description() string { return s.description }
+
+// Labels returns a copy of the segment's labels.
+func (s *Segment) Labels() Labels { return s.labels.Clone() }
+
 // TTLSeconds returns the segment's TTL in seconds.
 func (s *Segment) TTLSeconds() *int { return s.ttlSeconds }
 
+// Expression returns the expression defining a composite segment, or nil for
+// a regular segment.
+func (s *Segment) Expression() *Expression { return s.expression }
+
+// IsComposite reports whether the segment is defined by an expression over
+// other segments.
+func (s *Segment) IsComposite() bool { return s.expression != nil }
+
+// References reports whether the segment's expression references the segment
+// with the given ID.
+func (s *Segment) References(id int) bool {
+	if s.expression == nil {
+		return false
+	}
+	for _, ref := range s.expression.SegmentIDs() {
+		if ref == id {
+			return true
+		}
+	}
+	return false
+}
+
+// MemberCount returns the number of members of a regular segment, including
+// memberships that expired since expired memberships were last purged.
+// Composite segments derive their members from their expression, so the
+// count does not apply to them.
+func (s *Segment) MemberCount() int { return s.memberCount }
+
 // CreatedAt returns when the segment was created.
 func (s *Segment) CreatedAt() time.Time { return s.createdAt }
 
@@ -110,17 +217,34 @@ func (s *Segment) UpdatedAt() time.Time { return s.updatedAt }
 // DeletedAt returns when the segment was deleted, or nil if not deleted.
 func (s *Segment) DeletedAt() *time.Time { return s.deletedAt }
 
-// Delete marks the segment as deleted.
-func (s *Segment) Delete(deletedAt time.Time) {
+// Delete marks the segment as deleted. Archived segments are read-only and
+// cannot be deleted.
+func (s *Segment) Delete(deletedAt time.Time) error {
+	if s.state == StateArchived {
+		return ErrSegmentArchived
+	}
+
 	s.deletedAt = &deletedAt
 	s.updatedAt = deletedAt
+	return nil
 }
 
-// Update modifies the segment's mutable fields.
-func (s *Segment) Update(name string, ttlSeconds *int, updatedAt time.Time) {
-	s.name = name
-	s.ttlSeconds = ttlSeconds
+// Update replaces the segment's mutable fields with those of c. Archived
+// segments are read-only.
+func (s *Segment) Update(c SegmentConfig, updatedAt time.Time) error {
+	if s.state == StateArchived {
+		return ErrSegmentArchived
+	}
+
+	s.name = c.Name
+	s.description = c.Description
+	s.labels = c.Labels.Clone()
+	s.ttlSeconds = c.TTLSeconds
+	s.activeFrom = c.ActiveFrom
+	s.activeUntil = c.ActiveUntil
+	s.expression = c.Expression
 	s.updatedAt = updatedAt
+	return nil
 }
 
 // IsDeleted returns true if the segment has been deleted.
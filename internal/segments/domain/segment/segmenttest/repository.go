@@ -0,0 +1,944 @@
+// Package segmenttest provides a conformance suite for implementations of
+// segment.Repository.
+package segmenttest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/rickKoch/nexus/internal/segments/domain/segment"
+)
+
+// Repository is a segment.Repository that also hands out segment IDs, as
+// every adapter does.
+type Repository interface {
+	segment.Repository
+	segment.IDGenerator
+}
+
+// now is the time the suite's segments and memberships are created at. It
+// has no sub-microsecond part, which databases may not store.
+var now = time.Date(2026, 11, 1, 12, 30, 0, 123456000, time.UTC)
+
+// RunRepositoryTests runs the conformance suite against the repositories
+// newRepo returns. Every subtest gets a repository of its own, which must
+// not hold any segments.
+func RunRepositoryTests(t *testing.T, newRepo func(t *testing.T) Repository) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, h harness)
+	}{
+		{"creates and gets segments", testCreateGet},
+		{"rejects duplicate IDs", testDuplicateID},
+		{"reports missing segments", testNotFound},
+		{"returns independent segments", testIndependentCopies},
+		{"updates segments", testUpdate},
+		{"stores revisions", testRevisions},
+		{"soft deletes segments", testDelete},
+		{"lists pages filtered by labels and state", testList},
+		{"lists pages filtered by liveness", testListLive},
+		{"lists referencing segments", testListReferencing},
+		{"lists window boundaries", testListWindowBoundaries},
+		{"counts members", testCountMembers},
+		{"replaces memberships", testReplaceMemberships},
+		{"streams live members", testStreamMembers},
+		{"streams again after a failed stream in a transaction", testStreamAfterFailure},
+		{"lists memberships", testListMemberships},
+		{"purges expired members", testPurgeExpiredMembers},
+		{"records size history", testSizeHistory},
+		{"stores sketches", testSketches},
+		{"commits transactions", testCommit},
+		{"rolls back transactions", testRollback},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, harness{ctx: context.Background(), repo: newRepo(t)})
+		})
+	}
+}
+
+// harness holds the repository under test and helpers failing the test on
+// unexpected errors.
+type harness struct {
+	ctx  context.Context
+	repo Repository
+}
+
+func (h harness) create(t *testing.T, sc segment.SegmentConfig) *segment.Segment {
+	t.Helper()
+
+	f, err := segment.NewFactory(sc)
+	if err != nil {
+		t.Fatalf("invalid segment config: %v", err)
+	}
+	id, err := h.repo.NextID(h.ctx)
+	if err != nil {
+		t.Fatalf("failed to reserve ID: %v", err)
+	}
+	s, err := h.repo.Create(h.ctx, f.NewSegment(id, now))
+	if err != nil {
+		t.Fatalf("failed to create segment: %v", err)
+	}
+	return s
+}
+
+func (h harness) get(t *testing.T, repo segment.Repository, id int) *segment.Segment {
+	t.Helper()
+
+	s, err := repo.Get(h.ctx, id)
+	if err != nil {
+		t.Fatalf("failed to get segment '%d': %v", id, err)
+	}
+	return s
+}
+
+func (h harness) addMembers(t *testing.T, repo segment.Repository, s *segment.Segment, at time.Time, ids ...string) {
+	t.Helper()
+
+	members := make([]segment.Member, 0, len(ids))
+	for _, id := range ids {
+		m, err := s.NewMember(id, at)
+		if err != nil {
+			t.Fatalf("failed to create member: %v", err)
+		}
+		members = append(members, m)
+	}
+	if err := repo.AddMembers(h.ctx, s.ID(), members); err != nil {
+		t.Fatalf("failed to add members: %v", err)
+	}
+}
+
+func (h harness) removeMembers(t *testing.T, s *segment.Segment, ids ...string) {
+	t.Helper()
+
+	if err := h.repo.RemoveMembers(h.ctx, s.ID(), ids); err != nil {
+		t.Fatalf("failed to remove members: %v", err)
+	}
+}
+
+func (h harness) members(t *testing.T, q segment.MemberQuery) []string {
+	t.Helper()
+
+	ids := []string{}
+	err := h.repo.StreamMembers(h.ctx, q, func(id string) error {
+		ids = append(ids, id)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to stream members: %v", err)
+	}
+	return ids
+}
+
+func (h harness) memberships(t *testing.T, segmentID int) []segment.Member {
+	t.Helper()
+
+	members := []segment.Member{}
+	err := h.repo.StreamMemberships(h.ctx, segmentID, func(m segment.Member) error {
+		members = append(members, m)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to stream memberships: %v", err)
+	}
+	return members
+}
+
+func (h harness) list(t *testing.T, params segment.ListParams) ([]int, int) {
+	t.Helper()
+
+	result, err := h.repo.List(h.ctx, params)
+	if err != nil {
+		t.Fatalf("failed to list segments: %v", err)
+	}
+	return segmentIDs(result.Segments), result.TotalCount
+}
+
+func (h harness) parse(t *testing.T, format string, ids ...any) *segment.Expression {
+	t.Helper()
+
+	expr, err := segment.ParseExpression(fmt.Sprintf(format, ids...))
+	if err != nil {
+		t.Fatalf("failed to parse expression: %v", err)
+	}
+	return expr
+}
+
+func segmentIDs(segments []segment.Segment) []int {
+	ids := []int{}
+	for _, s := range segments {
+		ids = append(ids, s.ID())
+	}
+	return ids
+}
+
+func single(id int) *segment.Expression {
+	return &segment.Expression{Op: segment.OpSegment, SegmentID: id}
+}
+
+func timeEqual(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+func testCreateGet(t *testing.T, h harness) {
+	ttl := 3600
+	from, until := now.Add(time.Hour), now.Add(48*time.Hour)
+	regular := h.create(t, segment.SegmentConfig{
+		Name:        "premium-users",
+		Description: "Users on a paid plan",
+		Labels:      segment.Labels{"team": "growth", "tier": "gold"},
+		TTLSeconds:  &ttl,
+		ActiveFrom:  &from,
+		ActiveUntil: &until,
+		State:       segment.StateActive,
+	})
+	composite := h.create(t, segment.SegmentConfig{Name: "non-trial", Expression: h.parse(t, "NOT %d", regular.ID())})
+
+	for _, created := range []*segment.Segment{regular, composite} {
+		got := h.get(t, h.repo, created.ID())
+		if got.ID() != created.ID() || got.Name() != created.Name() || got.Description() != created.Description() {
+			t.Errorf("expected segment %d %q %q, got %d %q %q",
+				created.ID(), created.Name(), created.Description(), got.ID(), got.Name(), got.Description())
+		}
+		if !got.Labels().Equal(created.Labels()) {
+			t.Errorf("expected labels %v, got %v", created.Labels(), got.Labels())
+		}
+		if (got.TTLSeconds() == nil) != (created.TTLSeconds() == nil) || got.TTLSeconds() != nil && *got.TTLSeconds() != *created.TTLSeconds() {
+			t.Errorf("expected TTL %v, got %v", created.TTLSeconds(), got.TTLSeconds())
+		}
+		if !timeEqual(got.ActiveFrom(), created.ActiveFrom()) || !timeEqual(got.ActiveUntil(), created.ActiveUntil()) {
+			t.Errorf("expected window %v to %v, got %v to %v", created.ActiveFrom(), created.ActiveUntil(), got.ActiveFrom(), got.ActiveUntil())
+		}
+		if got.State() != created.State() || got.MemberCount() != 0 || got.IsDeleted() {
+			t.Errorf("expected live %s segment without members, got %s with %d members", created.State(), got.State(), got.MemberCount())
+		}
+		if !got.CreatedAt().Equal(now) || !got.UpdatedAt().Equal(now) {
+			t.Errorf("expected timestamps %v, got %v and %v", now, got.CreatedAt(), got.UpdatedAt())
+		}
+	}
+
+	if got := h.get(t, h.repo, composite.ID()); got.Expression() == nil || got.Expression().String() != composite.Expression().String() {
+		t.Errorf("expected expression %q, got %v", composite.Expression(), got.Expression())
+	}
+	if got := h.get(t, h.repo, regular.ID()); got.Expression() != nil {
+		t.Errorf("expected no expression, got %q", got.Expression())
+	}
+}
+
+func testDuplicateID(t *testing.T, h harness) {
+	s := h.create(t, segment.SegmentConfig{Name: "premium-users"})
+
+	f, _ := segment.NewFactory(segment.SegmentConfig{Name: "other"})
+	if created, err := h.repo.Create(h.ctx, f.NewSegment(s.ID(), now)); err == nil {
+		t.Errorf("expected an error creating a second segment '%d', got %v", s.ID(), created)
+	}
+	if got := h.get(t, h.repo, s.ID()); got.Name() != "premium-users" {
+		t.Errorf("expected the existing segment to be kept, got %q", got.Name())
+	}
+}
+
+func testNotFound(t *testing.T, h harness) {
+	f, _ := segment.NewFactory(segment.SegmentConfig{Name: "missing"})
+	missing := f.NewSegment(999999, now)
+
+	if _, err := h.repo.Get(h.ctx, missing.ID()); !errors.Is(err, segment.ErrSegmentNotFound) {
+		t.Errorf("expected %v getting, got %v", segment.ErrSegmentNotFound, err)
+	}
+	if _, err := h.repo.Update(h.ctx, missing); !errors.Is(err, segment.ErrSegmentNotFound) {
+		t.Errorf("expected %v updating, got %v", segment.ErrSegmentNotFound, err)
+	}
+	if err := missing.Delete(now); err != nil {
+		t.Fatalf("failed to mark segment as deleted: %v", err)
+	}
+	if err := h.repo.Delete(h.ctx, missing); !errors.Is(err, segment.ErrSegmentNotFound) {
+		t.Errorf("expected %v deleting, got %v", segment.ErrSegmentNotFound, err)
+	}
+	if _, err := h.repo.GetSketch(h.ctx, missing.ID()); !errors.Is(err, segment.ErrSegmentNotFound) {
+		t.Errorf("expected %v getting the sketch, got %v", segment.ErrSegmentNotFound, err)
+	}
+	if _, err := h.repo.ListRevisions(h.ctx, missing.ID()); !errors.Is(err, segment.ErrSegmentNotFound) {
+		t.Errorf("expected %v listing revisions, got %v", segment.ErrSegmentNotFound, err)
+	}
+	if _, err := h.repo.GetRevision(h.ctx, missing.ID(), 1); !errors.Is(err, segment.ErrSegmentNotFound) {
+		t.Errorf("expected %v getting a revision, got %v", segment.ErrSegmentNotFound, err)
+	}
+	if err := h.repo.SaveSketch(h.ctx, missing.ID(), segment.NewSketch()); !errors.Is(err, segment.ErrSegmentNotFound) {
+		t.Errorf("expected %v saving the sketch, got %v", segment.ErrSegmentNotFound, err)
+	}
+}
+
+func testIndependentCopies(t *testing.T, h harness) {
+	created := h.create(t, segment.SegmentConfig{Name: "premium-users"})
+
+	// Changing the segments handed in and out must not change the stored one.
+	if err := created.Update(segment.SegmentConfig{Name: "changed"}, now); err != nil {
+		t.Fatalf("failed to update segment: %v", err)
+	}
+	held := h.get(t, h.repo, created.ID())
+	if held.Name() != "premium-users" {
+		t.Errorf("expected the stored name to be kept, got %q", held.Name())
+	}
+
+	deleting := h.get(t, h.repo, created.ID())
+	if err := deleting.Delete(now.Add(time.Minute)); err != nil {
+		t.Fatalf("failed to mark segment as deleted: %v", err)
+	}
+	if err := h.repo.Delete(h.ctx, deleting); err != nil {
+		t.Fatalf("failed to delete segment: %v", err)
+	}
+	if held.IsDeleted() {
+		t.Error("expected deleting not to change segments read before")
+	}
+}
+
+func testUpdate(t *testing.T, h harness) {
+	s := h.create(t, segment.SegmentConfig{Name: "premium-users", Labels: segment.Labels{"team": "growth"}})
+	h.addMembers(t, h.repo, s, now, "user-1")
+
+	later := now.Add(time.Hour)
+	from := now.Add(2 * time.Hour)
+	s = h.get(t, h.repo, s.ID())
+	err := s.Update(segment.SegmentConfig{Name: "paying-users", Description: "Paid plans", ActiveFrom: &from}, later)
+	if err != nil {
+		t.Fatalf("failed to update segment: %v", err)
+	}
+	updated, err := h.repo.Update(h.ctx, s)
+	if err != nil {
+		t.Fatalf("failed to store update: %v", err)
+	}
+
+	for _, got := range []*segment.Segment{updated, h.get(t, h.repo, s.ID())} {
+		if got.Name() != "paying-users" || got.Description() != "Paid plans" || len(got.Labels()) != 0 || !timeEqual(got.ActiveFrom(), &from) {
+			t.Errorf("expected the update to be stored, got %q %q %v from %v", got.Name(), got.Description(), got.Labels(), got.ActiveFrom())
+		}
+		if !got.CreatedAt().Equal(now) || !got.UpdatedAt().Equal(later) {
+			t.Errorf("expected created at %v and updated at %v, got %v and %v", now, later, got.CreatedAt(), got.UpdatedAt())
+		}
+		if got.MemberCount() != 1 {
+			t.Errorf("expected the member count to be kept, got %d", got.MemberCount())
+		}
+	}
+}
+
+func testRevisions(t *testing.T, h harness) {
+	s := h.create(t, segment.SegmentConfig{Name: "premium-users", Labels: segment.Labels{"team": "growth"}})
+	h.addMembers(t, h.repo, s, now, "user-1")
+
+	renamed := now.Add(time.Hour)
+	if err := s.Update(segment.SegmentConfig{Name: "paying-users", Description: "Paid plans"}, renamed); err != nil {
+		t.Fatalf("failed to update segment: %v", err)
+	}
+	if _, err := h.repo.Update(h.ctx, s); err != nil {
+		t.Fatalf("failed to store update: %v", err)
+	}
+	activated := now.Add(2 * time.Hour)
+	if err := s.TransitionTo(segment.StateActive, activated); err != nil {
+		t.Fatalf("failed to activate segment: %v", err)
+	}
+	if _, err := h.repo.Update(h.ctx, s); err != nil {
+		t.Fatalf("failed to store update: %v", err)
+	}
+
+	revisions, err := h.repo.ListRevisions(h.ctx, s.ID())
+	if err != nil {
+		t.Fatalf("failed to list revisions: %v", err)
+	}
+	want := []struct {
+		name  string
+		state segment.State
+		saved time.Time
+	}{
+		{"premium-users", segment.StateDraft, now},
+		{"paying-users", segment.StateDraft, renamed},
+		{"paying-users", segment.StateActive, activated},
+	}
+	if len(revisions) != len(want) {
+		t.Fatalf("expected %d revisions, got %d", len(want), len(revisions))
+	}
+	for i, w := range want {
+		got := revisions[i]
+		if got.Version != i+1 || got.Segment.ID() != s.ID() || got.Segment.Name() != w.name || got.Segment.State() != w.state {
+			t.Errorf("expected revision %d to be %s %s, got revision %d %s %s", i+1, w.state, w.name, got.Version, got.Segment.State(), got.Segment.Name())
+		}
+		if !got.Segment.CreatedAt().Equal(now) || !got.Segment.UpdatedAt().Equal(w.saved) {
+			t.Errorf("expected revision %d created at %v and saved at %v, got %v and %v", i+1, now, w.saved, got.Segment.CreatedAt(), got.Segment.UpdatedAt())
+		}
+		if got.Segment.MemberCount() != 0 {
+			t.Errorf("expected revisions without member counts, got %d", got.Segment.MemberCount())
+		}
+	}
+	if got := revisions[0].Segment.Labels(); got["team"] != "growth" {
+		t.Errorf("expected the labels of the first revision, got %v", got)
+	}
+
+	got, err := h.repo.GetRevision(h.ctx, s.ID(), 2)
+	if err != nil || got.Version != 2 || got.Segment.Description() != "Paid plans" {
+		t.Errorf("expected revision 2, got %+v (%v)", got, err)
+	}
+	for _, version := range []int{0, 4} {
+		if _, err := h.repo.GetRevision(h.ctx, s.ID(), version); !errors.Is(err, segment.ErrRevisionNotFound) {
+			t.Errorf("expected %v getting revision %d, got %v", segment.ErrRevisionNotFound, version, err)
+		}
+	}
+
+	if err := s.Delete(now.Add(3 * time.Hour)); err != nil {
+		t.Fatalf("failed to mark segment as deleted: %v", err)
+	}
+	if err := h.repo.Delete(h.ctx, s); err != nil {
+		t.Fatalf("failed to delete segment: %v", err)
+	}
+	if _, err := h.repo.ListRevisions(h.ctx, s.ID()); !errors.Is(err, segment.ErrSegmentNotFound) {
+		t.Errorf("expected %v listing the revisions of a deleted segment, got %v", segment.ErrSegmentNotFound, err)
+	}
+	if _, err := h.repo.GetRevision(h.ctx, s.ID(), 1); !errors.Is(err, segment.ErrSegmentNotFound) {
+		t.Errorf("expected %v getting a revision of a deleted segment, got %v", segment.ErrSegmentNotFound, err)
+	}
+}
+
+func testDelete(t *testing.T, h harness) {
+	kept := h.create(t, segment.SegmentConfig{Name: "kept"})
+	from := now.Add(time.Hour)
+	s := h.create(t, segment.SegmentConfig{Name: "deleted", ActiveFrom: &from})
+	h.create(t, segment.SegmentConfig{Name: "referencing", Expression: h.parse(t, "%d OR %d", kept.ID(), s.ID())})
+	h.addMembers(t, h.repo, s, now, "user-1")
+
+	if err := h.repo.Delete(h.ctx, s); err == nil || errors.Is(err, segment.ErrSegmentNotFound) {
+		t.Errorf("expected an error deleting a segment not marked as deleted, got %v", err)
+	}
+
+	referencing := h.create(t, segment.SegmentConfig{Name: "deleted-composite", Expression: h.parse(t, "%d", s.ID())})
+	for _, d := range []*segment.Segment{s, referencing} {
+		if err := d.Delete(now.Add(time.Minute)); err != nil {
+			t.Fatalf("failed to mark segment as deleted: %v", err)
+		}
+		if err := h.repo.Delete(h.ctx, d); err != nil {
+			t.Fatalf("failed to delete segment: %v", err)
+		}
+	}
+
+	if _, err := h.repo.Get(h.ctx, s.ID()); !errors.Is(err, segment.ErrSegmentNotFound) {
+		t.Errorf("expected %v getting a deleted segment, got %v", segment.ErrSegmentNotFound, err)
+	}
+	if err := h.repo.Delete(h.ctx, s); !errors.Is(err, segment.ErrSegmentNotFound) {
+		t.Errorf("expected %v deleting twice, got %v", segment.ErrSegmentNotFound, err)
+	}
+	if _, err := h.repo.Update(h.ctx, s); !errors.Is(err, segment.ErrSegmentNotFound) {
+		t.Errorf("expected %v updating a deleted segment, got %v", segment.ErrSegmentNotFound, err)
+	}
+
+	if ids, total := h.list(t, segment.ListParams{Page: 1, PageSize: 10}); total != 2 || slices.Contains(ids, s.ID()) {
+		t.Errorf("expected deleted segments not to be listed, got %v of %d", ids, total)
+	}
+	if got, err := h.repo.ListReferencing(h.ctx, s.ID()); err != nil || len(got) != 1 {
+		t.Errorf("expected only the live composite segment to reference '%d', got %v (%v)", s.ID(), segmentIDs(got), err)
+	}
+	if got, err := h.repo.ListWindowBoundaries(h.ctx, now, from); err != nil || len(got) != 0 {
+		t.Errorf("expected no window boundaries of deleted segments, got %v (%v)", segmentIDs(got), err)
+	}
+	if got, err := h.repo.ListUnsketchedSegments(h.ctx); err != nil || slices.Contains(got, s.ID()) {
+		t.Errorf("expected deleted segments not to need sketches, got %v (%v)", got, err)
+	}
+	if err := h.repo.RecordSizeSnapshots(h.ctx, now); err != nil {
+		t.Fatalf("failed to record sizes: %v", err)
+	}
+	if got, err := h.repo.ListSizeHistory(h.ctx, s.ID(), now, now); err != nil || len(got) != 0 {
+		t.Errorf("expected no size history of deleted segments, got %v (%v)", got, err)
+	}
+}
+
+func testList(t *testing.T, h harness) {
+	var ids []int
+	for i := range 5 {
+		s := h.create(t, segment.SegmentConfig{Name: fmt.Sprintf("growth-%d", i), Labels: segment.Labels{"team": "growth"}})
+		ids = append(ids, s.ID())
+	}
+	core := h.create(t, segment.SegmentConfig{Name: "core", Labels: segment.Labels{"team": "core", "tier": "gold"}, State: segment.StateActive})
+	ids = append(ids, core.ID())
+
+	tests := []struct {
+		name      string
+		params    segment.ListParams
+		wantIDs   []int
+		wantTotal int
+	}{
+		{"first page", segment.ListParams{Page: 1, PageSize: 4}, ids[:4], 6},
+		{"last page", segment.ListParams{Page: 2, PageSize: 4}, ids[4:], 6},
+		{"past the last page", segment.ListParams{Page: 3, PageSize: 4}, []int{}, 6},
+		{"labels", segment.ListParams{Page: 2, PageSize: 2, Labels: segment.LabelSelector{"team": "growth"}}, ids[2:4], 5},
+		{"all labels", segment.ListParams{Page: 1, PageSize: 10, Labels: segment.LabelSelector{"team": "core", "tier": "gold"}}, []int{core.ID()}, 1},
+		{"unmatched labels", segment.ListParams{Page: 1, PageSize: 10, Labels: segment.LabelSelector{"team": "core", "tier": "silver"}}, []int{}, 0},
+		{"states", segment.ListParams{Page: 1, PageSize: 10, States: []segment.State{segment.StatePaused, segment.StateActive}}, []int{core.ID()}, 1},
+		{"labels and states", segment.ListParams{Page: 1, PageSize: 10, Labels: segment.LabelSelector{"team": "core"}, States: []segment.State{segment.StateDraft}}, []int{}, 0},
+	}
+
+	for _, tt := range tests {
+		gotIDs, gotTotal := h.list(t, tt.params)
+		if !slices.Equal(gotIDs, tt.wantIDs) || gotTotal != tt.wantTotal {
+			t.Errorf("%s: expected %v of %d, got %v of %d", tt.name, tt.wantIDs, tt.wantTotal, gotIDs, gotTotal)
+		}
+	}
+}
+
+func testListLive(t *testing.T, h harness) {
+	later := now.Add(time.Hour)
+	draft := h.create(t, segment.SegmentConfig{Name: "draft"})
+	always := h.create(t, segment.SegmentConfig{Name: "always", State: segment.StateActive})
+	starting := h.create(t, segment.SegmentConfig{Name: "starting", State: segment.StateActive, ActiveFrom: &later})
+	ending := h.create(t, segment.SegmentConfig{Name: "ending", State: segment.StateActive, ActiveUntil: &later})
+	live, notLive := true, false
+
+	tests := []struct {
+		name    string
+		params  segment.ListParams
+		wantIDs []int
+	}{
+		{"live", segment.ListParams{Live: &live, At: now}, []int{always.ID(), ending.ID()}},
+		{"not live", segment.ListParams{Live: &notLive, At: now}, []int{draft.ID(), starting.ID()}},
+		{"live at a window boundary", segment.ListParams{Live: &live, At: later}, []int{always.ID(), starting.ID()}},
+		{"live with states", segment.ListParams{Live: &notLive, At: later, States: []segment.State{segment.StateActive}}, []int{ending.ID()}},
+	}
+
+	for _, tt := range tests {
+		tt.params.Page, tt.params.PageSize = 1, 10
+		gotIDs, gotTotal := h.list(t, tt.params)
+		if !slices.Equal(gotIDs, tt.wantIDs) || gotTotal != len(tt.wantIDs) {
+			t.Errorf("%s: expected %v, got %v of %d", tt.name, tt.wantIDs, gotIDs, gotTotal)
+		}
+	}
+}
+
+func testListReferencing(t *testing.T, h harness) {
+	a := h.create(t, segment.SegmentConfig{Name: "a"})
+	b := h.create(t, segment.SegmentConfig{Name: "b"})
+	both := h.create(t, segment.SegmentConfig{Name: "both", Expression: h.parse(t, "%d AND NOT %d", a.ID(), b.ID())})
+	onlyB := h.create(t, segment.SegmentConfig{Name: "only-b", Expression: h.parse(t, "(%d OR %d) AND %d", b.ID(), b.ID(), b.ID())})
+
+	tests := []struct {
+		id   int
+		want []int
+	}{
+		{a.ID(), []int{both.ID()}},
+		{b.ID(), []int{both.ID(), onlyB.ID()}},
+		{both.ID(), []int{}},
+	}
+	for _, tt := range tests {
+		got, err := h.repo.ListReferencing(h.ctx, tt.id)
+		if err != nil {
+			t.Fatalf("failed to list referencing segments: %v", err)
+		}
+		if ids := segmentIDs(got); !slices.Equal(ids, tt.want) {
+			t.Errorf("expected %v to reference '%d', got %v", tt.want, tt.id, ids)
+		}
+	}
+}
+
+func testListWindowBoundaries(t *testing.T, h harness) {
+	from, until := now.Add(time.Hour), now.Add(2*time.Hour)
+	opening := h.create(t, segment.SegmentConfig{Name: "opening", ActiveFrom: &from})
+	window := h.create(t, segment.SegmentConfig{Name: "window", ActiveFrom: &from, ActiveUntil: &until})
+	closing := h.create(t, segment.SegmentConfig{Name: "closing", ActiveUntil: &until})
+	h.create(t, segment.SegmentConfig{Name: "always"})
+
+	tests := []struct {
+		name         string
+		after, until time.Time
+		want         []int
+	}{
+		{"opening at until", now, from, []int{opening.ID(), window.ID()}},
+		{"opening at after", from, from.Add(time.Minute), []int{}},
+		{"closing", from, until, []int{window.ID(), closing.ID()}},
+		{"both", now, until, []int{opening.ID(), window.ID(), closing.ID()}},
+	}
+	for _, tt := range tests {
+		got, err := h.repo.ListWindowBoundaries(h.ctx, tt.after, tt.until)
+		if err != nil {
+			t.Fatalf("failed to list window boundaries: %v", err)
+		}
+		if ids := segmentIDs(got); !slices.Equal(ids, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, ids)
+		}
+	}
+}
+
+func testCountMembers(t *testing.T, h harness) {
+	s := h.create(t, segment.SegmentConfig{Name: "premium-users"})
+
+	h.addMembers(t, h.repo, s, now, "user-1", "user-2")
+	h.addMembers(t, h.repo, s, now, "user-2", "user-3")
+	if n := h.get(t, h.repo, s.ID()).MemberCount(); n != 3 {
+		t.Errorf("expected re-added members to be counted once, got %d members", n)
+	}
+
+	h.removeMembers(t, s, "user-1", "user-9")
+	if n := h.get(t, h.repo, s.ID()).MemberCount(); n != 2 {
+		t.Errorf("expected unknown members to be ignored, got %d members", n)
+	}
+	got := h.memberships(t, s.ID())
+	if len(got) != 2 || got[0].ID != "user-2" || got[1].ID != "user-3" {
+		t.Errorf("expected memberships of user-2 and user-3, got %+v", got)
+	}
+}
+
+func testReplaceMemberships(t *testing.T, h harness) {
+	ttl := 3600
+	s := h.create(t, segment.SegmentConfig{Name: "trial-users", TTLSeconds: &ttl})
+
+	h.addMembers(t, h.repo, s, now, "user-1", "user-2")
+	later := now.Add(30 * time.Minute)
+	h.addMembers(t, h.repo, s, later, "user-2")
+
+	got := h.memberships(t, s.ID())
+	if len(got) != 2 {
+		t.Fatalf("expected 2 memberships, got %+v", got)
+	}
+	wantExpiry := []time.Time{now.Add(time.Hour), later.Add(time.Hour)}
+	for i, wantAdded := range []time.Time{now, later} {
+		if !got[i].AddedAt.Equal(wantAdded) || !timeEqual(got[i].ExpiresAt, &wantExpiry[i]) {
+			t.Errorf("expected %s added at %v expiring at %v, got %v expiring at %v",
+				got[i].ID, wantAdded, wantExpiry[i], got[i].AddedAt, got[i].ExpiresAt)
+		}
+	}
+}
+
+func testStreamMembers(t *testing.T, h harness) {
+	ttl := 3600
+	a := h.create(t, segment.SegmentConfig{Name: "a"})
+	b := h.create(t, segment.SegmentConfig{Name: "b", TTLSeconds: &ttl})
+	h.addMembers(t, h.repo, a, now, "user-3", "user-1", "user-2", "user-10")
+	h.addMembers(t, h.repo, b, now, "user-2", "user-4")
+	expiry := now.Add(time.Hour)
+
+	tests := []struct {
+		name string
+		q    segment.MemberQuery
+		want []string
+	}{
+		{"segment", segment.MemberQuery{Expression: single(a.ID()), At: now}, []string{"user-1", "user-10", "user-2", "user-3"}},
+		{"after", segment.MemberQuery{Expression: single(a.ID()), At: now, After: "user-10"}, []string{"user-2", "user-3"}},
+		{"before expiry", segment.MemberQuery{Expression: single(b.ID()), At: expiry.Add(-time.Microsecond)}, []string{"user-2", "user-4"}},
+		{"at expiry", segment.MemberQuery{Expression: single(b.ID()), At: expiry}, []string{}},
+		{"and not", segment.MemberQuery{Expression: h.parse(t, "%d AND NOT %d", a.ID(), b.ID()), At: now}, []string{"user-1", "user-10", "user-3"}},
+		{"and not after expiry", segment.MemberQuery{Expression: h.parse(t, "%d AND NOT %d", a.ID(), b.ID()), At: expiry}, []string{"user-1", "user-10", "user-2", "user-3"}},
+		{"or", segment.MemberQuery{Expression: h.parse(t, "%d OR %d", a.ID(), b.ID()), At: now, After: "user-2"}, []string{"user-3", "user-4"}},
+		{"not alone", segment.MemberQuery{Expression: h.parse(t, "NOT %d", a.ID()), At: now}, []string{}},
+	}
+	for _, tt := range tests {
+		if got := h.members(t, tt.q); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+
+	errStop := errors.New("stop")
+	calls := 0
+	err := h.repo.StreamMembers(h.ctx, segment.MemberQuery{Expression: single(a.ID()), At: now}, func(string) error {
+		calls++
+		return errStop
+	})
+	if !errors.Is(err, errStop) || calls != 1 {
+		t.Errorf("expected streaming to stop at the first error, got %v after %d calls", err, calls)
+	}
+}
+
+func testStreamAfterFailure(t *testing.T, h harness) {
+	s := h.create(t, segment.SegmentConfig{Name: "premium-users"})
+	h.addMembers(t, h.repo, s, now, "user-1", "user-2")
+	errStop := errors.New("stop")
+
+	err := h.repo.RunInTransaction(h.ctx, func(ctx context.Context, tx segment.Repository) error {
+		q := segment.MemberQuery{Expression: single(s.ID()), At: now}
+		stop := func(string) error { return errStop }
+		if err := tx.StreamMembers(ctx, q, stop); !errors.Is(err, errStop) {
+			t.Errorf("expected %v, got %v", errStop, err)
+		}
+		var ids []string
+		if err := tx.StreamMembers(ctx, q, func(id string) error {
+			ids = append(ids, id)
+			return nil
+		}); err != nil {
+			return err
+		}
+		if len(ids) != 2 {
+			t.Errorf("expected 2 members, got %v", ids)
+		}
+
+		stopMember := func(segment.Member) error { return errStop }
+		if err := tx.StreamMemberships(ctx, s.ID(), stopMember); !errors.Is(err, errStop) {
+			t.Errorf("expected %v, got %v", errStop, err)
+		}
+		var members []segment.Member
+		if err := tx.StreamMemberships(ctx, s.ID(), func(m segment.Member) error {
+			members = append(members, m)
+			return nil
+		}); err != nil {
+			return err
+		}
+		if len(members) != 2 {
+			t.Errorf("expected 2 memberships, got %+v", members)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+}
+
+func testListMemberships(t *testing.T, h harness) {
+	ttl := 3600
+	a := h.create(t, segment.SegmentConfig{Name: "a"})
+	b := h.create(t, segment.SegmentConfig{Name: "b", TTLSeconds: &ttl})
+	c := h.create(t, segment.SegmentConfig{Name: "c"})
+	h.addMembers(t, h.repo, a, now, "user-1")
+	h.addMembers(t, h.repo, b, now, "user-1")
+	h.addMembers(t, h.repo, c, now, "user-2")
+
+	tests := []struct {
+		name string
+		at   time.Time
+		ids  []int
+		want []int
+	}{
+		{"live", now, []int{c.ID(), b.ID(), a.ID(), b.ID()}, []int{a.ID(), b.ID()}},
+		{"expired", now.Add(time.Hour), []int{a.ID(), b.ID(), c.ID()}, []int{a.ID()}},
+		{"none", now, []int{}, []int{}},
+	}
+	for _, tt := range tests {
+		got, err := h.repo.ListMemberships(h.ctx, "user-1", tt.ids, tt.at)
+		if err != nil {
+			t.Fatalf("failed to list memberships: %v", err)
+		}
+		if !slices.Equal(got, tt.want) && !(len(got) == 0 && len(tt.want) == 0) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func testPurgeExpiredMembers(t *testing.T, h harness) {
+	short, long := 60, 3600
+	a := h.create(t, segment.SegmentConfig{Name: "a", TTLSeconds: &short})
+	b := h.create(t, segment.SegmentConfig{Name: "b", TTLSeconds: &long})
+	c := h.create(t, segment.SegmentConfig{Name: "c"})
+	h.addMembers(t, h.repo, a, now, "user-1", "user-2")
+	h.addMembers(t, h.repo, b, now, "user-1")
+	h.addMembers(t, h.repo, c, now, "user-1")
+	for _, s := range []*segment.Segment{a, b, c} {
+		if err := h.repo.SaveSketch(h.ctx, s.ID(), segment.NewSketch()); err != nil {
+			t.Fatalf("failed to save sketch: %v", err)
+		}
+	}
+
+	n, err := h.repo.PurgeExpiredMembers(h.ctx, now.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("failed to purge: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("expected the memberships expiring at the purge time to be purged, got %d", n)
+	}
+
+	for _, tt := range []struct {
+		s          *segment.Segment
+		wantCount  int
+		wantSketch bool
+	}{
+		{a, 0, false},
+		{b, 1, true},
+		{c, 1, true},
+	} {
+		if got := h.get(t, h.repo, tt.s.ID()).MemberCount(); got != tt.wantCount {
+			t.Errorf("expected segment '%s' to have %d members, got %d", tt.s.Name(), tt.wantCount, got)
+		}
+		sketch, err := h.repo.GetSketch(h.ctx, tt.s.ID())
+		if err != nil {
+			t.Fatalf("failed to get sketch: %v", err)
+		}
+		if (sketch != nil) != tt.wantSketch {
+			t.Errorf("expected segment '%s' to keep its sketch: %t, got %v", tt.s.Name(), tt.wantSketch, sketch)
+		}
+	}
+	if got := h.memberships(t, a.ID()); len(got) != 0 {
+		t.Errorf("expected purged memberships to be removed, got %+v", got)
+	}
+
+	if n, err := h.repo.PurgeExpiredMembers(h.ctx, now.Add(time.Minute)); err != nil || n != 0 {
+		t.Errorf("expected nothing left to purge, got %d (%v)", n, err)
+	}
+}
+
+func testSizeHistory(t *testing.T, h harness) {
+	s := h.create(t, segment.SegmentConfig{Name: "a"})
+	composite := h.create(t, segment.SegmentConfig{Name: "b", Expression: h.parse(t, "%d", s.ID())})
+	yesterday, today := now.AddDate(0, 0, -1), now
+
+	h.addMembers(t, h.repo, s, now, "user-1")
+	if err := h.repo.RecordSizeSnapshots(h.ctx, yesterday); err != nil {
+		t.Fatalf("failed to record sizes: %v", err)
+	}
+	h.addMembers(t, h.repo, s, now, "user-2")
+	if err := h.repo.RecordSizeSnapshots(h.ctx, today); err != nil {
+		t.Fatalf("failed to record sizes: %v", err)
+	}
+	h.addMembers(t, h.repo, s, now, "user-3")
+	if err := h.repo.RecordSizeSnapshots(h.ctx, today.Add(time.Hour)); err != nil {
+		t.Fatalf("failed to record sizes: %v", err)
+	}
+
+	got, err := h.repo.ListSizeHistory(h.ctx, s.ID(), yesterday.Add(time.Hour), today)
+	if err != nil {
+		t.Fatalf("failed to list size history: %v", err)
+	}
+	want := []segment.SizeSnapshot{
+		{SegmentID: s.ID(), Day: segment.Day(yesterday), MemberCount: 1},
+		{SegmentID: s.ID(), Day: segment.Day(today), MemberCount: 3},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i].SegmentID != want[i].SegmentID || !got[i].Day.Equal(want[i].Day) || got[i].MemberCount != want[i].MemberCount {
+			t.Errorf("expected %v, got %v", want[i], got[i])
+		}
+	}
+
+	if got, err := h.repo.ListSizeHistory(h.ctx, s.ID(), today.AddDate(0, 0, 1), today.AddDate(0, 0, 2)); err != nil || len(got) != 0 {
+		t.Errorf("expected no snapshots outside the range, got %v (%v)", got, err)
+	}
+	if got, err := h.repo.ListSizeHistory(h.ctx, composite.ID(), yesterday, today); err != nil || len(got) != 0 {
+		t.Errorf("expected no snapshots of composite segments, got %v (%v)", got, err)
+	}
+}
+
+func testSketches(t *testing.T, h harness) {
+	s := h.create(t, segment.SegmentConfig{Name: "a"})
+	h.create(t, segment.SegmentConfig{Name: "b", Expression: h.parse(t, "%d", s.ID())})
+
+	if sketch, err := h.repo.GetSketch(h.ctx, s.ID()); err != nil || sketch != nil {
+		t.Errorf("expected no sketch, got %v (%v)", sketch, err)
+	}
+	if got, err := h.repo.ListUnsketchedSegments(h.ctx); err != nil || !slices.Equal(got, []int{s.ID()}) {
+		t.Errorf("expected [%d] to need a sketch, got %v (%v)", s.ID(), got, err)
+	}
+
+	want := segment.NewSketch()
+	want.Add("user-1")
+	want.Add("user-2")
+	if err := h.repo.SaveSketch(h.ctx, s.ID(), want); err != nil {
+		t.Fatalf("failed to save sketch: %v", err)
+	}
+	saved, _ := want.MarshalBinary()
+	want.Add("user-3")
+
+	got, err := h.repo.GetSketch(h.ctx, s.ID())
+	if err != nil || got == nil {
+		t.Fatalf("expected the sketch, got %v (%v)", got, err)
+	}
+	if data, _ := got.MarshalBinary(); !slices.Equal(data, saved) {
+		t.Error("expected the sketch as saved")
+	}
+	if got, err := h.repo.ListUnsketchedSegments(h.ctx); err != nil || len(got) != 0 {
+		t.Errorf("expected no segments to need a sketch, got %v (%v)", got, err)
+	}
+
+	h.addMembers(t, h.repo, s, now, "user-1")
+	h.removeMembers(t, s, "user-9")
+	if sketch, err := h.repo.GetSketch(h.ctx, s.ID()); err != nil || sketch == nil {
+		t.Errorf("expected removing no members to keep the sketch, got %v (%v)", sketch, err)
+	}
+	h.removeMembers(t, s, "user-1")
+	if sketch, err := h.repo.GetSketch(h.ctx, s.ID()); err != nil || sketch != nil {
+		t.Errorf("expected removing members to discard the sketch, got %v (%v)", sketch, err)
+	}
+}
+
+func testCommit(t *testing.T, h harness) {
+	var created *segment.Segment
+	err := h.repo.RunInTransaction(h.ctx, func(ctx context.Context, tx segment.Repository) error {
+		f, _ := segment.NewFactory(segment.SegmentConfig{Name: "premium-users"})
+		id, err := h.repo.NextID(ctx)
+		if err != nil {
+			return err
+		}
+		if created, err = tx.Create(ctx, f.NewSegment(id, now)); err != nil {
+			return err
+		}
+		// Joining the transaction sees its writes.
+		return tx.RunInTransaction(ctx, func(ctx context.Context, tx segment.Repository) error {
+			h.addMembers(t, tx, h.get(t, tx, id), now, "user-1")
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if n := h.get(t, h.repo, created.ID()).MemberCount(); n != 1 {
+		t.Errorf("expected the committed member, got %d members", n)
+	}
+}
+
+func testRollback(t *testing.T, h harness) {
+	s := h.create(t, segment.SegmentConfig{Name: "premium-users"})
+	h.addMembers(t, h.repo, s, now, "user-1")
+	if err := h.repo.SaveSketch(h.ctx, s.ID(), segment.NewSketch()); err != nil {
+		t.Fatalf("failed to save sketch: %v", err)
+	}
+	errRollback := errors.New("rollback")
+
+	var createdID int
+	err := h.repo.RunInTransaction(h.ctx, func(ctx context.Context, tx segment.Repository) error {
+		f, _ := segment.NewFactory(segment.SegmentConfig{Name: "trial-users"})
+		id, err := h.repo.NextID(ctx)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Create(ctx, f.NewSegment(id, now)); err != nil {
+			return err
+		}
+		createdID = id
+
+		updated := h.get(t, tx, s.ID())
+		if err := updated.Update(segment.SegmentConfig{Name: "renamed"}, now.Add(time.Minute)); err != nil {
+			return err
+		}
+		if _, err := tx.Update(ctx, updated); err != nil {
+			return err
+		}
+		h.addMembers(t, tx, s, now, "user-2")
+		if err := tx.RemoveMembers(ctx, s.ID(), []string{"user-1"}); err != nil {
+			return err
+		}
+		if n := h.get(t, tx, s.ID()).MemberCount(); n != 1 {
+			t.Errorf("expected the transaction to see its writes, got %d members", n)
+		}
+
+		return tx.RunInTransaction(ctx, func(ctx context.Context, tx segment.Repository) error {
+			return errRollback
+		})
+	})
+	if !errors.Is(err, errRollback) {
+		t.Fatalf("expected %v, got %v", errRollback, err)
+	}
+
+	if _, err := h.repo.Get(h.ctx, createdID); !errors.Is(err, segment.ErrSegmentNotFound) {
+		t.Errorf("expected the created segment to be rolled back, got %v", err)
+	}
+	got := h.get(t, h.repo, s.ID())
+	if got.Name() != "premium-users" || got.MemberCount() != 1 {
+		t.Errorf("expected the update to be rolled back, got %q with %d members", got.Name(), got.MemberCount())
+	}
+	if members := h.memberships(t, s.ID()); len(members) != 1 || members[0].ID != "user-1" {
+		t.Errorf("expected the memberships to be rolled back, got %+v", members)
+	}
+	if sketch, err := h.repo.GetSketch(h.ctx, s.ID()); err != nil || sketch == nil {
+		t.Errorf("expected the discarded sketch to be restored, got %v (%v)", sketch, err)
+	}
+	if revisions, err := h.repo.ListRevisions(h.ctx, s.ID()); err != nil || len(revisions) != 1 {
+		t.Errorf("expected the revision to be rolled back, got %d revisions (%v)", len(revisions), err)
+	}
+}
@@ -0,0 +1,83 @@
+package segment
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// State is the lifecycle state of a segment.
+type State string
+
+const (
+	// StateDraft segments are being set up and do not match anything yet.
+	StateDraft State = "draft"
+	// StateActive segments are live.
+	StateActive State = "active"
+	// StatePaused segments are temporarily switched off and do not match.
+	StatePaused State = "paused"
+	// StateArchived segments are retired. They are read-only and do not match.
+	StateArchived State = "archived"
+)
+
+var (
+	// ErrInvalidState is returned for an unknown state.
+	ErrInvalidState = errors.New("state must be one of draft, active, paused or archived")
+	// ErrInvalidInitialState is returned when a segment is created in a state
+	// other than draft or active.
+	ErrInvalidInitialState = errors.New("segment must be created as draft or active")
+	// ErrInvalidTransition is returned for a transition the state machine does not allow.
+	ErrInvalidTransition = errors.New("invalid state transition")
+	// ErrSegmentArchived is returned when modifying an archived segment.
+	ErrSegmentArchived = errors.New("segment is archived and read-only")
+)
+
+// transitions lists the states each state may move to.
+var transitions = map[State][]State{
+	StateDraft:    {StateActive, StateArchived},
+	StateActive:   {StatePaused, StateArchived},
+	StatePaused:   {StateActive, StateArchived},
+	StateArchived: {},
+}
+
+// Validate checks that s is a known state.
+func (s State) Validate() error {
+	if _, ok := transitions[s]; !ok {
+		return fmt.Errorf("%w, got '%s'", ErrInvalidState, s)
+	}
+	return nil
+}
+
+// CanTransitionTo reports whether a segment in state s may move to target.
+func (s State) CanTransitionTo(target State) bool {
+	for _, allowed := range transitions[s] {
+		if allowed == target {
+			return true
+		}
+	}
+	return false
+}
+
+// State returns the segment's lifecycle state.
+func (s *Segment) State() State { return s.state }
+
+// IsMatchable reports whether the segment takes part in membership
+// evaluation at the given time. Only active segments inside their activation
+// window match.
+func (s *Segment) IsMatchable(at time.Time) bool {
+	return s.state == StateActive && s.InWindow(at)
+}
+
+// TransitionTo moves the segment to target if the state machine allows it.
+func (s *Segment) TransitionTo(target State, at time.Time) error {
+	if err := target.Validate(); err != nil {
+		return err
+	}
+	if !s.state.CanTransitionTo(target) {
+		return fmt.Errorf("%w from %s to %s", ErrInvalidTransition, s.state, target)
+	}
+
+	s.state = target
+	s.updatedAt = at
+	return nil
+}
@@ -0,0 +1,17 @@
+package segment
+
+import "time"
+
+// SizeSnapshot is the number of members a segment had on a day.
+type SizeSnapshot struct {
+	SegmentID   int
+	Day         time.Time
+	MemberCount int
+}
+
+// Day returns the UTC calendar day of t, as midnight UTC. Size history is
+// kept per UTC day.
+func Day(t time.Time) time.Time {
+	y, m, d := t.UTC().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
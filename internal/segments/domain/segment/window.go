@@ -0,0 +1,82 @@
+package segment
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// ErrInvalidActivationWindow is returned when a window does not start before it ends.
+var ErrInvalidActivationWindow = errors.New("active_from must be before active_until")
+
+// WindowEventType identifies which boundary of an activation window was crossed.
+type WindowEventType string
+
+const (
+	// WindowActivated is emitted when a segment's window opens.
+	WindowActivated WindowEventType = "activated"
+	// WindowExpired is emitted when a segment's window closes.
+	WindowExpired WindowEventType = "expired"
+)
+
+// WindowEvent records that a segment's activation window opened or closed.
+type WindowEvent struct {
+	Type        WindowEventType
+	SegmentID   int
+	SegmentName string
+	// At is the boundary that was crossed, not the time it was noticed.
+	At time.Time
+}
+
+// ActiveFrom returns when the segment's activation window opens, or nil if it
+// has always been open.
+func (s *Segment) ActiveFrom() *time.Time { return s.activeFrom }
+
+// ActiveUntil returns when the segment's activation window closes, or nil if
+// it never closes.
+func (s *Segment) ActiveUntil() *time.Time { return s.activeUntil }
+
+// InWindow reports whether at falls inside the segment's activation window.
+// The window includes ActiveFrom and excludes ActiveUntil.
+func (s *Segment) InWindow(at time.Time) bool {
+	if s.activeFrom != nil && at.Before(*s.activeFrom) {
+		return false
+	}
+	if s.activeUntil != nil && !at.Before(*s.activeUntil) {
+		return false
+	}
+	return true
+}
+
+// WindowEvents returns the events for the window boundaries crossed after
+// after and up to and including until, in the order they happened.
+func (s *Segment) WindowEvents(after, until time.Time) []WindowEvent {
+	var events []WindowEvent
+	if crossed(s.activeFrom, after, until) {
+		events = append(events, s.windowEvent(WindowActivated, *s.activeFrom))
+	}
+	if crossed(s.activeUntil, after, until) {
+		events = append(events, s.windowEvent(WindowExpired, *s.activeUntil))
+	}
+	return events
+}
+
+func (s *Segment) windowEvent(typ WindowEventType, at time.Time) WindowEvent {
+	return WindowEvent{Type: typ, SegmentID: s.id, SegmentName: s.name, At: at}
+}
+
+// SortWindowEvents orders events by the time their boundary was crossed.
+func SortWindowEvents(events []WindowEvent) {
+	sort.SliceStable(events, func(i, j int) bool { return events[i].At.Before(events[j].At) })
+}
+
+func crossed(boundary *time.Time, after, until time.Time) bool {
+	return boundary != nil && boundary.After(after) && !boundary.After(until)
+}
+
+func validateWindow(from, until *time.Time) error {
+	if from != nil && until != nil && !from.Before(*until) {
+		return ErrInvalidActivationWindow
+	}
+	return nil
+}
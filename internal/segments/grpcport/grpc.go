@@ -0,0 +1,272 @@
+package grpcport
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/rickKoch/nexus/internal/segments/app"
+	"github.com/rickKoch/nexus/internal/segments/app/segments"
+	"github.com/rickKoch/nexus/internal/segments/domain/segment"
+	"github.com/rickKoch/nexus/internal/segments/grpcport/segmentspb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type GrpcServer struct {
+	segmentspb.UnimplementedSegmentServiceServer
+
+	app app.Application
+}
+
+func NewGrpcServer(application app.Application) GrpcServer {
+	return GrpcServer{
+		app: application,
+	}
+}
+
+// GetSegment handles SegmentService.GetSegment
+func (g GrpcServer) GetSegment(ctx context.Context, req *segmentspb.GetSegmentRequest) (*segmentspb.Segment, error) {
+	seg, err := g.app.Segments.GetSegment.Handle(ctx, segments.GetSegment{ID: int(req.GetId())})
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	return g.toProtoSegment(seg), nil
+}
+
+// ListSegments handles SegmentService.ListSegments
+func (g GrpcServer) ListSegments(ctx context.Context, req *segmentspb.ListSegmentsRequest) (*segmentspb.ListSegmentsResponse, error) {
+	result, err := g.app.Segments.ListSegments.Handle(ctx, segments.ListSegments{
+		Page:     int(req.GetPage()),
+		PageSize: int(req.GetPageSize()),
+		Labels:   req.GetLabels(),
+		States:   fromProtoStates(req.GetStates()),
+		Live:     req.Live,
+	})
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	items := make([]*segmentspb.Segment, 0, len(result.Segments))
+	for i := range result.Segments {
+		items = append(items, g.toProtoSegment(&result.Segments[i]))
+	}
+
+	return &segmentspb.ListSegmentsResponse{
+		Items:      items,
+		TotalCount: int32(result.TotalCount),
+		Page:       int32(result.Page),
+		PageSize:   int32(result.PageSize),
+		TotalPages: int32(result.TotalPages),
+	}, nil
+}
+
+// CreateSegment handles SegmentService.CreateSegment
+func (g GrpcServer) CreateSegment(ctx context.Context, req *segmentspb.CreateSegmentRequest) (*segmentspb.Segment, error) {
+	seg, err := g.app.Segments.CreateSegment.Handle(ctx, segments.CreateSegment{
+		Name:        req.GetName(),
+		Description: req.GetDescription(),
+		Labels:      req.GetLabels(),
+		TTLSeconds:  fromProtoTTL(req.TtlSeconds),
+		ActiveFrom:  fromProtoTime(req.GetActiveFrom()),
+		ActiveUntil: fromProtoTime(req.GetActiveUntil()),
+		Expression:  req.GetExpression(),
+		State:       fromProtoState(req.GetState()),
+	})
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	return g.toProtoSegment(seg), nil
+}
+
+// UpdateSegment handles SegmentService.UpdateSegment
+func (g GrpcServer) UpdateSegment(ctx context.Context, req *segmentspb.UpdateSegmentRequest) (*segmentspb.Segment, error) {
+	seg, err := g.app.Segments.UpdateSegment.Handle(ctx, segments.UpdateSegment{
+		ID:          int(req.GetId()),
+		Name:        req.GetName(),
+		Description: req.GetDescription(),
+		Labels:      req.GetLabels(),
+		TTLSeconds:  fromProtoTTL(req.TtlSeconds),
+		ActiveFrom:  fromProtoTime(req.GetActiveFrom()),
+		ActiveUntil: fromProtoTime(req.GetActiveUntil()),
+		Expression:  req.GetExpression(),
+	})
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	return g.toProtoSegment(seg), nil
+}
+
+// DeleteSegment handles SegmentService.DeleteSegment
+func (g GrpcServer) DeleteSegment(ctx context.Context, req *segmentspb.DeleteSegmentRequest) (*emptypb.Empty, error) {
+	if err := g.app.Segments.DeleteSegment.Handle(ctx, segments.DeleteSegment{ID: int(req.GetId())}); err != nil {
+		return nil, toStatusError(err)
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+// ActivateSegment handles SegmentService.ActivateSegment
+func (g GrpcServer) ActivateSegment(ctx context.Context, req *segmentspb.TransitionSegmentRequest) (*segmentspb.Segment, error) {
+	return g.transitionSegment(ctx, req.GetId(), segment.StateActive)
+}
+
+// PauseSegment handles SegmentService.PauseSegment
+func (g GrpcServer) PauseSegment(ctx context.Context, req *segmentspb.TransitionSegmentRequest) (*segmentspb.Segment, error) {
+	return g.transitionSegment(ctx, req.GetId(), segment.StatePaused)
+}
+
+// ArchiveSegment handles SegmentService.ArchiveSegment
+func (g GrpcServer) ArchiveSegment(ctx context.Context, req *segmentspb.TransitionSegmentRequest) (*segmentspb.Segment, error) {
+	return g.transitionSegment(ctx, req.GetId(), segment.StateArchived)
+}
+
+func (g GrpcServer) transitionSegment(ctx context.Context, id int64, state segment.State) (*segmentspb.Segment, error) {
+	seg, err := g.app.Segments.TransitionSegment.Handle(ctx, segments.TransitionSegment{ID: int(id), State: state})
+	if err != nil {
+		return nil, toStatusError(err)
+	}
+
+	return g.toProtoSegment(seg), nil
+}
+
+// toStatusError maps domain and context errors to gRPC status codes.
+func toStatusError(err error) error {
+	var code codes.Code
+	switch {
+	case errors.Is(err, segment.ErrSegmentNotFound):
+		code = codes.NotFound
+	case errors.Is(err, segment.ErrNameRequired),
+		errors.Is(err, segment.ErrNameTooLong),
+		errors.Is(err, segment.ErrInvalidTTL),
+		errors.Is(err, segment.ErrInvalidActivationWindow),
+		errors.Is(err, segment.ErrDescriptionTooLong),
+		errors.Is(err, segment.ErrTooManyLabels),
+		errors.Is(err, segment.ErrInvalidLabelKey),
+		errors.Is(err, segment.ErrLabelValueTooLong),
+		errors.Is(err, segment.ErrInvalidLabelSelector),
+		errors.Is(err, segment.ErrInvalidState),
+		errors.Is(err, segment.ErrInvalidInitialState),
+		errors.Is(err, segment.ErrInvalidExpression),
+		errors.Is(err, segment.ErrExpressionTooLong),
+		errors.Is(err, segment.ErrUnknownReference),
+		errors.Is(err, segment.ErrReferenceCycle):
+		code = codes.InvalidArgument
+	case errors.Is(err, segment.ErrInvalidTransition),
+		errors.Is(err, segment.ErrSegmentArchived),
+		errors.Is(err, segment.ErrSegmentReferenced):
+		code = codes.FailedPrecondition
+	case errors.Is(err, segment.ErrVersionConflict):
+		code = codes.Aborted
+	case errors.Is(err, segment.ErrUnavailable):
+		code = codes.Unavailable
+	case errors.Is(err, context.Canceled):
+		code = codes.Canceled
+	case errors.Is(err, context.DeadlineExceeded):
+		code = codes.DeadlineExceeded
+	default:
+		code = codes.Internal
+	}
+
+	return status.Error(code, err.Error())
+}
+
+// toProtoSegment converts s, reporting whether it is live at the
+// application clock's current time.
+func (g GrpcServer) toProtoSegment(s *segment.Segment) *segmentspb.Segment {
+	var ttl *int32
+	if s.TTLSeconds() != nil {
+		v := int32(*s.TTLSeconds())
+		ttl = &v
+	}
+
+	var memberCount *int64
+	if !s.IsComposite() {
+		v := int64(s.MemberCount())
+		memberCount = &v
+	}
+
+	return &segmentspb.Segment{
+		Id:          int64(s.ID()),
+		Name:        s.Name(),
+		Description: s.Description(),
+		Labels:      s.Labels(),
+		TtlSeconds:  ttl,
+		ActiveFrom:  toProtoTime(s.ActiveFrom()),
+		ActiveUntil: toProtoTime(s.ActiveUntil()),
+		State:       toProtoState(s.State()),
+		Live:        s.IsMatchable(g.app.Segments.Clock.Now()),
+		Expression:  formatExpression(s.Expression()),
+		MemberCount: memberCount,
+		CreatedAt:   timestamppb.New(s.CreatedAt()),
+		UpdatedAt:   timestamppb.New(s.UpdatedAt()),
+	}
+}
+
+func formatExpression(e *segment.Expression) string {
+	if e == nil {
+		return ""
+	}
+	return e.String()
+}
+
+func toProtoTime(t *time.Time) *timestamppb.Timestamp {
+	if t == nil {
+		return nil
+	}
+	return timestamppb.New(*t)
+}
+
+func fromProtoTime(ts *timestamppb.Timestamp) *time.Time {
+	if ts == nil {
+		return nil
+	}
+	t := ts.AsTime()
+	return &t
+}
+
+func fromProtoTTL(ttl *int32) *int {
+	if ttl == nil {
+		return nil
+	}
+	v := int(*ttl)
+	return &v
+}
+
+var protoStates = map[segment.State]segmentspb.SegmentState{
+	segment.StateDraft:    segmentspb.SegmentState_SEGMENT_STATE_DRAFT,
+	segment.StateActive:   segmentspb.SegmentState_SEGMENT_STATE_ACTIVE,
+	segment.StatePaused:   segmentspb.SegmentState_SEGMENT_STATE_PAUSED,
+	segment.StateArchived: segmentspb.SegmentState_SEGMENT_STATE_ARCHIVED,
+}
+
+func toProtoState(state segment.State) segmentspb.SegmentState {
+	return protoStates[state]
+}
+
+// fromProtoState maps an unspecified state to "" so the use case applies
+// its default, and unknown values to a state that fails validation.
+func fromProtoState(state segmentspb.SegmentState) segment.State {
+	if state == segmentspb.SegmentState_SEGMENT_STATE_UNSPECIFIED {
+		return ""
+	}
+	for domainState, protoState := range protoStates {
+		if protoState == state {
+			return domainState
+		}
+	}
+	return segment.State(state.String())
+}
+
+func fromProtoStates(states []segmentspb.SegmentState) []segment.State {
+	result := make([]segment.State, 0, len(states))
+	for _, state := range states {
+		result = append(result, fromProtoState(state))
+	}
+	return result
+}
@@ -0,0 +1,252 @@
+package grpcport_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/rickKoch/nexus/internal/segments/adapters"
+	"github.com/rickKoch/nexus/internal/segments/app"
+	"github.com/rickKoch/nexus/internal/segments/app/segments"
+	"github.com/rickKoch/nexus/internal/segments/grpcport"
+	"github.com/rickKoch/nexus/internal/segments/grpcport/segmentspb"
+	"github.com/rickKoch/nexus/pkg/clock"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func newTestClient(t *testing.T) segmentspb.SegmentServiceClient {
+	t.Helper()
+
+	repo := adapters.NewInMemorySegmentRepository()
+	seg, err := app.NewSegments(repo, segments.DefaultPagination(), clock.System, repo)
+	if err != nil {
+		t.Fatalf("failed to create application: %v", err)
+	}
+
+	listener := bufconn.Listen(1024 * 1024)
+	srv := grpc.NewServer()
+	segmentspb.RegisterSegmentServiceServer(srv, grpcport.NewGrpcServer(app.Application{Segments: seg}))
+	go func() { _ = srv.Serve(listener) }()
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return segmentspb.NewSegmentServiceClient(conn)
+}
+
+func TestGrpcServer(t *testing.T) {
+	client := newTestClient(t)
+	ctx := context.Background()
+
+	created, err := client.CreateSegment(ctx, &segmentspb.CreateSegmentRequest{
+		Name:       "premium-users",
+		TtlSeconds: proto.Int32(3600),
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	t.Run("gets created segment", func(t *testing.T) {
+		got, err := client.GetSegment(ctx, &segmentspb.GetSegmentRequest{Id: created.GetId()})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if got.GetName() != "premium-users" || got.GetTtlSeconds() != 3600 {
+			t.Errorf("unexpected segment %v", got)
+		}
+	})
+
+	t.Run("updates segment", func(t *testing.T) {
+		got, err := client.UpdateSegment(ctx, &segmentspb.UpdateSegmentRequest{
+			Id:   created.GetId(),
+			Name: "vip-users",
+		})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if got.GetName() != "vip-users" || got.TtlSeconds != nil {
+			t.Errorf("unexpected segment %v", got)
+		}
+	})
+
+	t.Run("lists segments", func(t *testing.T) {
+		got, err := client.ListSegments(ctx, &segmentspb.ListSegmentsRequest{})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if got.GetTotalCount() != 1 || len(got.GetItems()) != 1 {
+			t.Errorf("expected 1 segment, got %v", got)
+		}
+		if got.GetPageSize() != segments.DefaultPageSize {
+			t.Errorf("expected default page size, got %d", got.GetPageSize())
+		}
+	})
+
+	t.Run("filters segments by label", func(t *testing.T) {
+		_, err := client.CreateSegment(ctx, &segmentspb.CreateSegmentRequest{
+			Name:        "growth",
+			Description: "Growth team",
+			Labels:      map[string]string{"team": "growth"},
+		})
+		if err != nil {
+			t.Fatalf("failed to create segment: %v", err)
+		}
+
+		got, err := client.ListSegments(ctx, &segmentspb.ListSegmentsRequest{Labels: []string{"team:growth"}})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if len(got.GetItems()) != 1 || got.GetItems()[0].GetLabels()["team"] != "growth" || got.GetItems()[0].GetDescription() != "Growth team" {
+			t.Errorf("unexpected items %v", got.GetItems())
+		}
+
+		_, err = client.ListSegments(ctx, &segmentspb.ListSegmentsRequest{Labels: []string{"team"}})
+		if status.Code(err) != codes.InvalidArgument {
+			t.Errorf("expected InvalidArgument, got %v", err)
+		}
+	})
+
+	t.Run("moves segments through their lifecycle", func(t *testing.T) {
+		seg, err := client.CreateSegment(ctx, &segmentspb.CreateSegmentRequest{Name: "lifecycle"})
+		if err != nil {
+			t.Fatalf("failed to create segment: %v", err)
+		}
+		if seg.GetState() != segmentspb.SegmentState_SEGMENT_STATE_DRAFT {
+			t.Errorf("expected draft segment, got %v", seg.GetState())
+		}
+
+		_, err = client.PauseSegment(ctx, &segmentspb.TransitionSegmentRequest{Id: seg.GetId()})
+		if status.Code(err) != codes.FailedPrecondition {
+			t.Errorf("expected FailedPrecondition, got %v", err)
+		}
+
+		seg, err = client.ActivateSegment(ctx, &segmentspb.TransitionSegmentRequest{Id: seg.GetId()})
+		if err != nil || seg.GetState() != segmentspb.SegmentState_SEGMENT_STATE_ACTIVE {
+			t.Fatalf("expected active segment, got %v, %v", seg.GetState(), err)
+		}
+
+		got, err := client.ListSegments(ctx, &segmentspb.ListSegmentsRequest{States: []segmentspb.SegmentState{segmentspb.SegmentState_SEGMENT_STATE_ACTIVE}})
+		if err != nil || len(got.GetItems()) != 1 || got.GetItems()[0].GetId() != seg.GetId() {
+			t.Errorf("expected only the active segment, got %v, %v", got.GetItems(), err)
+		}
+
+		seg, err = client.ArchiveSegment(ctx, &segmentspb.TransitionSegmentRequest{Id: seg.GetId()})
+		if err != nil || seg.GetState() != segmentspb.SegmentState_SEGMENT_STATE_ARCHIVED {
+			t.Fatalf("expected archived segment, got %v, %v", seg.GetState(), err)
+		}
+
+		_, err = client.UpdateSegment(ctx, &segmentspb.UpdateSegmentRequest{Id: seg.GetId(), Name: "renamed"})
+		if status.Code(err) != codes.FailedPrecondition {
+			t.Errorf("expected FailedPrecondition, got %v", err)
+		}
+	})
+
+	t.Run("creates composite segments", func(t *testing.T) {
+		base, err := client.CreateSegment(ctx, &segmentspb.CreateSegmentRequest{Name: "base"})
+		if err != nil {
+			t.Fatalf("failed to create segment: %v", err)
+		}
+
+		composite, err := client.CreateSegment(ctx, &segmentspb.CreateSegmentRequest{
+			Name:       "composite",
+			Expression: fmt.Sprintf("not %d", base.GetId()),
+		})
+		if err != nil {
+			t.Fatalf("failed to create composite segment: %v", err)
+		}
+		if expected := fmt.Sprintf("NOT %d", base.GetId()); composite.GetExpression() != expected {
+			t.Errorf("expected expression '%s', got '%s'", expected, composite.GetExpression())
+		}
+
+		_, err = client.DeleteSegment(ctx, &segmentspb.DeleteSegmentRequest{Id: base.GetId()})
+		if status.Code(err) != codes.FailedPrecondition {
+			t.Errorf("expected FailedPrecondition, got %v", err)
+		}
+
+		_, err = client.CreateSegment(ctx, &segmentspb.CreateSegmentRequest{Name: "broken", Expression: "1 AND"})
+		if status.Code(err) != codes.InvalidArgument {
+			t.Errorf("expected InvalidArgument, got %v", err)
+		}
+	})
+
+	t.Run("reports whether segments are live", func(t *testing.T) {
+		until := timestamppb.New(time.Now().Add(-time.Hour))
+		_, err := client.CreateSegment(ctx, &segmentspb.CreateSegmentRequest{
+			Name:        "expired",
+			ActiveFrom:  timestamppb.New(time.Now().Add(-2 * time.Hour)),
+			ActiveUntil: until,
+			State:       segmentspb.SegmentState_SEGMENT_STATE_ACTIVE,
+		})
+		if err != nil {
+			t.Fatalf("failed to create segment: %v", err)
+		}
+
+		seg, err := client.CreateSegment(ctx, &segmentspb.CreateSegmentRequest{Name: "open", State: segmentspb.SegmentState_SEGMENT_STATE_ACTIVE})
+		if err != nil {
+			t.Fatalf("failed to create segment: %v", err)
+		}
+		if !seg.GetLive() {
+			t.Error("expected active segment without window to be live")
+		}
+
+		got, err := client.ListSegments(ctx, &segmentspb.ListSegmentsRequest{PageSize: 100})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		for _, item := range got.GetItems() {
+			if item.GetName() == "expired" && (item.GetLive() || !item.GetActiveUntil().AsTime().Equal(until.AsTime())) {
+				t.Errorf("expected expired segment not to be live, got %v", item)
+			}
+		}
+
+		_, err = client.CreateSegment(ctx, &segmentspb.CreateSegmentRequest{Name: "inverted", ActiveFrom: until, ActiveUntil: until})
+		if status.Code(err) != codes.InvalidArgument {
+			t.Errorf("expected InvalidArgument, got %v", err)
+		}
+	})
+
+	t.Run("maps validation errors to InvalidArgument", func(t *testing.T) {
+		_, err := client.CreateSegment(ctx, &segmentspb.CreateSegmentRequest{Name: ""})
+		if status.Code(err) != codes.InvalidArgument {
+			t.Errorf("expected InvalidArgument, got %v", err)
+		}
+	})
+
+	t.Run("deletes segment", func(t *testing.T) {
+		if _, err := client.DeleteSegment(ctx, &segmentspb.DeleteSegmentRequest{Id: created.GetId()}); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	})
+
+	t.Run("maps missing segment to NotFound", func(t *testing.T) {
+		_, err := client.GetSegment(ctx, &segmentspb.GetSegmentRequest{Id: created.GetId()})
+		if status.Code(err) != codes.NotFound {
+			t.Errorf("expected NotFound, got %v", err)
+		}
+
+		_, err = client.DeleteSegment(ctx, &segmentspb.DeleteSegmentRequest{Id: created.GetId()})
+		if status.Code(err) != codes.NotFound {
+			t.Errorf("expected NotFound, got %v", err)
+		}
+	})
+}
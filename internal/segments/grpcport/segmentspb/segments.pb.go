@@ -0,0 +1,917 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.12
+// 	protoc        (unknown)
+// source: segments.proto
+
+package segmentspb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// SegmentState is the lifecycle state of a segment. Only active segments
+// match; archived segments are read-only.
+type SegmentState int32
+
+const (
+	SegmentState_SEGMENT_STATE_UNSPECIFIED SegmentState = 0
+	SegmentState_SEGMENT_STATE_DRAFT       SegmentState = 1
+	SegmentState_SEGMENT_STATE_ACTIVE      SegmentState = 2
+	SegmentState_SEGMENT_STATE_PAUSED      SegmentState = 3
+	SegmentState_SEGMENT_STATE_ARCHIVED    SegmentState = 4
+)
+
+// Enum value maps for SegmentState.
+var (
+	SegmentState_name = map[int32]string{
+		0: "SEGMENT_STATE_UNSPECIFIED",
+		1: "SEGMENT_STATE_DRAFT",
+		2: "SEGMENT_STATE_ACTIVE",
+		3: "SEGMENT_STATE_PAUSED",
+		4: "SEGMENT_STATE_ARCHIVED",
+	}
+	SegmentState_value = map[string]int32{
+		"SEGMENT_STATE_UNSPECIFIED": 0,
+		"SEGMENT_STATE_DRAFT":       1,
+		"SEGMENT_STATE_ACTIVE":      2,
+		"SEGMENT_STATE_PAUSED":      3,
+		"SEGMENT_STATE_ARCHIVED":    4,
+	}
+)
+
+func (x SegmentState) Enum() *SegmentState {
+	p := new(SegmentState)
+	*p = x
+	return p
+}
+
+func (x SegmentState) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (SegmentState) Descriptor() protoreflect.EnumDescriptor {
+	return file_segments_proto_enumTypes[0].Descriptor()
+}
+
+func (SegmentState) Type() protoreflect.EnumType {
+	return &file_segments_proto_enumTypes[0]
+}
+
+func (x SegmentState) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use SegmentState.Descriptor instead.
+func (SegmentState) EnumDescriptor() ([]byte, []int) {
+	return file_segments_proto_rawDescGZIP(), []int{0}
+}
+
+type Segment struct {
+	state       protoimpl.MessageState `protogen:"open.v1"`
+	Id          int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Name        string                 `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	TtlSeconds  *int32                 `protobuf:"varint,3,opt,name=ttl_seconds,json=ttlSeconds,proto3,oneof" json:"ttl_seconds,omitempty"`
+	CreatedAt   *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	UpdatedAt   *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=updated_at,json=updatedAt,proto3" json:"updated_at,omitempty"`
+	Description string                 `protobuf:"bytes,6,opt,name=description,proto3" json:"description,omitempty"`
+	Labels      map[string]string      `protobuf:"bytes,7,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	State       SegmentState           `protobuf:"varint,8,opt,name=state,proto3,enum=nexus.segments.v1.SegmentState" json:"state,omitempty"`
+	// Bounds of the activation window; unset means open-ended.
+	ActiveFrom  *timestamppb.Timestamp `protobuf:"bytes,9,opt,name=active_from,json=activeFrom,proto3" json:"active_from,omitempty"`
+	ActiveUntil *timestamppb.Timestamp `protobuf:"bytes,10,opt,name=active_until,json=activeUntil,proto3" json:"active_until,omitempty"`
+	// True when the segment is active and inside its activation window.
+	Live bool `protobuf:"varint,11,opt,name=live,proto3" json:"live,omitempty"`
+	// Expression over segment IDs defining a composite segment, e.g.
+	// "1 AND NOT 2". Empty for regular segments.
+	Expression string `protobuf:"bytes,12,opt,name=expression,proto3" json:"expression,omitempty"`
+	// Number of members of a regular segment; unset for composite segments.
+	MemberCount   *int64 `protobuf:"varint,13,opt,name=member_count,json=memberCount,proto3,oneof" json:"member_count,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Segment) Reset() {
+	*x = Segment{}
+	mi := &file_segments_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Segment) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Segment) ProtoMessage() {}
+
+func (x *Segment) ProtoReflect() protoreflect.Message {
+	mi := &file_segments_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Segment.ProtoReflect.Descriptor instead.
+func (*Segment) Descriptor() ([]byte, []int) {
+	return file_segments_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Segment) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *Segment) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Segment) GetTtlSeconds() int32 {
+	if x != nil && x.TtlSeconds != nil {
+		return *x.TtlSeconds
+	}
+	return 0
+}
+
+func (x *Segment) GetCreatedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CreatedAt
+	}
+	return nil
+}
+
+func (x *Segment) GetUpdatedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.UpdatedAt
+	}
+	return nil
+}
+
+func (x *Segment) GetDescription() string {
+	if x != nil {
+		return x.Description
+	}
+	return ""
+}
+
+func (x *Segment) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+func (x *Segment) GetState() SegmentState {
+	if x != nil {
+		return x.State
+	}
+	return SegmentState_SEGMENT_STATE_UNSPECIFIED
+}
+
+func (x *Segment) GetActiveFrom() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ActiveFrom
+	}
+	return nil
+}
+
+func (x *Segment) GetActiveUntil() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ActiveUntil
+	}
+	return nil
+}
+
+func (x *Segment) GetLive() bool {
+	if x != nil {
+		return x.Live
+	}
+	return false
+}
+
+func (x *Segment) GetExpression() string {
+	if x != nil {
+		return x.Expression
+	}
+	return ""
+}
+
+func (x *Segment) GetMemberCount() int64 {
+	if x != nil && x.MemberCount != nil {
+		return *x.MemberCount
+	}
+	return 0
+}
+
+type GetSegmentRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetSegmentRequest) Reset() {
+	*x = GetSegmentRequest{}
+	mi := &file_segments_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetSegmentRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetSegmentRequest) ProtoMessage() {}
+
+func (x *GetSegmentRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_segments_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetSegmentRequest.ProtoReflect.Descriptor instead.
+func (*GetSegmentRequest) Descriptor() ([]byte, []int) {
+	return file_segments_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *GetSegmentRequest) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+type ListSegmentsRequest struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	Page     int32                  `protobuf:"varint,1,opt,name=page,proto3" json:"page,omitempty"`
+	PageSize int32                  `protobuf:"varint,2,opt,name=page_size,json=pageSize,proto3" json:"page_size,omitempty"`
+	// Label selector expressions of the form key:value; all must match.
+	Labels []string `protobuf:"bytes,3,rep,name=labels,proto3" json:"labels,omitempty"`
+	// Only list segments in one of these states; empty lists all.
+	States []SegmentState `protobuf:"varint,4,rep,packed,name=states,proto3,enum=nexus.segments.v1.SegmentState" json:"states,omitempty"`
+	// Only list segments whose live flag has this value; unset lists all.
+	Live          *bool `protobuf:"varint,5,opt,name=live,proto3,oneof" json:"live,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListSegmentsRequest) Reset() {
+	*x = ListSegmentsRequest{}
+	mi := &file_segments_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListSegmentsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListSegmentsRequest) ProtoMessage() {}
+
+func (x *ListSegmentsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_segments_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListSegmentsRequest.ProtoReflect.Descriptor instead.
+func (*ListSegmentsRequest) Descriptor() ([]byte, []int) {
+	return file_segments_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ListSegmentsRequest) GetPage() int32 {
+	if x != nil {
+		return x.Page
+	}
+	return 0
+}
+
+func (x *ListSegmentsRequest) GetPageSize() int32 {
+	if x != nil {
+		return x.PageSize
+	}
+	return 0
+}
+
+func (x *ListSegmentsRequest) GetLabels() []string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+func (x *ListSegmentsRequest) GetStates() []SegmentState {
+	if x != nil {
+		return x.States
+	}
+	return nil
+}
+
+func (x *ListSegmentsRequest) GetLive() bool {
+	if x != nil && x.Live != nil {
+		return *x.Live
+	}
+	return false
+}
+
+type ListSegmentsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Items         []*Segment             `protobuf:"bytes,1,rep,name=items,proto3" json:"items,omitempty"`
+	TotalCount    int32                  `protobuf:"varint,2,opt,name=total_count,json=totalCount,proto3" json:"total_count,omitempty"`
+	Page          int32                  `protobuf:"varint,3,opt,name=page,proto3" json:"page,omitempty"`
+	PageSize      int32                  `protobuf:"varint,4,opt,name=page_size,json=pageSize,proto3" json:"page_size,omitempty"`
+	TotalPages    int32                  `protobuf:"varint,5,opt,name=total_pages,json=totalPages,proto3" json:"total_pages,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListSegmentsResponse) Reset() {
+	*x = ListSegmentsResponse{}
+	mi := &file_segments_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListSegmentsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListSegmentsResponse) ProtoMessage() {}
+
+func (x *ListSegmentsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_segments_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListSegmentsResponse.ProtoReflect.Descriptor instead.
+func (*ListSegmentsResponse) Descriptor() ([]byte, []int) {
+	return file_segments_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *ListSegmentsResponse) GetItems() []*Segment {
+	if x != nil {
+		return x.Items
+	}
+	return nil
+}
+
+func (x *ListSegmentsResponse) GetTotalCount() int32 {
+	if x != nil {
+		return x.TotalCount
+	}
+	return 0
+}
+
+func (x *ListSegmentsResponse) GetPage() int32 {
+	if x != nil {
+		return x.Page
+	}
+	return 0
+}
+
+func (x *ListSegmentsResponse) GetPageSize() int32 {
+	if x != nil {
+		return x.PageSize
+	}
+	return 0
+}
+
+func (x *ListSegmentsResponse) GetTotalPages() int32 {
+	if x != nil {
+		return x.TotalPages
+	}
+	return 0
+}
+
+type CreateSegmentRequest struct {
+	state       protoimpl.MessageState `protogen:"open.v1"`
+	Name        string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	TtlSeconds  *int32                 `protobuf:"varint,2,opt,name=ttl_seconds,json=ttlSeconds,proto3,oneof" json:"ttl_seconds,omitempty"`
+	Description string                 `protobuf:"bytes,3,opt,name=description,proto3" json:"description,omitempty"`
+	Labels      map[string]string      `protobuf:"bytes,4,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	// Initial state, draft or active. Unspecified creates a draft.
+	State       SegmentState           `protobuf:"varint,5,opt,name=state,proto3,enum=nexus.segments.v1.SegmentState" json:"state,omitempty"`
+	ActiveFrom  *timestamppb.Timestamp `protobuf:"bytes,6,opt,name=active_from,json=activeFrom,proto3" json:"active_from,omitempty"`
+	ActiveUntil *timestamppb.Timestamp `protobuf:"bytes,7,opt,name=active_until,json=activeUntil,proto3" json:"active_until,omitempty"`
+	// Makes the segment a composite of the segments it references.
+	Expression    string `protobuf:"bytes,8,opt,name=expression,proto3" json:"expression,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CreateSegmentRequest) Reset() {
+	*x = CreateSegmentRequest{}
+	mi := &file_segments_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CreateSegmentRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CreateSegmentRequest) ProtoMessage() {}
+
+func (x *CreateSegmentRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_segments_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CreateSegmentRequest.ProtoReflect.Descriptor instead.
+func (*CreateSegmentRequest) Descriptor() ([]byte, []int) {
+	return file_segments_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *CreateSegmentRequest) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *CreateSegmentRequest) GetTtlSeconds() int32 {
+	if x != nil && x.TtlSeconds != nil {
+		return *x.TtlSeconds
+	}
+	return 0
+}
+
+func (x *CreateSegmentRequest) GetDescription() string {
+	if x != nil {
+		return x.Description
+	}
+	return ""
+}
+
+func (x *CreateSegmentRequest) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+func (x *CreateSegmentRequest) GetState() SegmentState {
+	if x != nil {
+		return x.State
+	}
+	return SegmentState_SEGMENT_STATE_UNSPECIFIED
+}
+
+func (x *CreateSegmentRequest) GetActiveFrom() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ActiveFrom
+	}
+	return nil
+}
+
+func (x *CreateSegmentRequest) GetActiveUntil() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ActiveUntil
+	}
+	return nil
+}
+
+func (x *CreateSegmentRequest) GetExpression() string {
+	if x != nil {
+		return x.Expression
+	}
+	return ""
+}
+
+type UpdateSegmentRequest struct {
+	state       protoimpl.MessageState `protogen:"open.v1"`
+	Id          int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Name        string                 `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	TtlSeconds  *int32                 `protobuf:"varint,3,opt,name=ttl_seconds,json=ttlSeconds,proto3,oneof" json:"ttl_seconds,omitempty"`
+	Description string                 `protobuf:"bytes,4,opt,name=description,proto3" json:"description,omitempty"`
+	Labels      map[string]string      `protobuf:"bytes,5,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	ActiveFrom  *timestamppb.Timestamp `protobuf:"bytes,6,opt,name=active_from,json=activeFrom,proto3" json:"active_from,omitempty"`
+	ActiveUntil *timestamppb.Timestamp `protobuf:"bytes,7,opt,name=active_until,json=activeUntil,proto3" json:"active_until,omitempty"`
+	// Replaces the expression; empty makes the segment a regular segment.
+	Expression    string `protobuf:"bytes,8,opt,name=expression,proto3" json:"expression,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UpdateSegmentRequest) Reset() {
+	*x = UpdateSegmentRequest{}
+	mi := &file_segments_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UpdateSegmentRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UpdateSegmentRequest) ProtoMessage() {}
+
+func (x *UpdateSegmentRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_segments_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UpdateSegmentRequest.ProtoReflect.Descriptor instead.
+func (*UpdateSegmentRequest) Descriptor() ([]byte, []int) {
+	return file_segments_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *UpdateSegmentRequest) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *UpdateSegmentRequest) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *UpdateSegmentRequest) GetTtlSeconds() int32 {
+	if x != nil && x.TtlSeconds != nil {
+		return *x.TtlSeconds
+	}
+	return 0
+}
+
+func (x *UpdateSegmentRequest) GetDescription() string {
+	if x != nil {
+		return x.Description
+	}
+	return ""
+}
+
+func (x *UpdateSegmentRequest) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+func (x *UpdateSegmentRequest) GetActiveFrom() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ActiveFrom
+	}
+	return nil
+}
+
+func (x *UpdateSegmentRequest) GetActiveUntil() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ActiveUntil
+	}
+	return nil
+}
+
+func (x *UpdateSegmentRequest) GetExpression() string {
+	if x != nil {
+		return x.Expression
+	}
+	return ""
+}
+
+type DeleteSegmentRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteSegmentRequest) Reset() {
+	*x = DeleteSegmentRequest{}
+	mi := &file_segments_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteSegmentRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteSegmentRequest) ProtoMessage() {}
+
+func (x *DeleteSegmentRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_segments_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteSegmentRequest.ProtoReflect.Descriptor instead.
+func (*DeleteSegmentRequest) Descriptor() ([]byte, []int) {
+	return file_segments_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *DeleteSegmentRequest) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+type TransitionSegmentRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TransitionSegmentRequest) Reset() {
+	*x = TransitionSegmentRequest{}
+	mi := &file_segments_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TransitionSegmentRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TransitionSegmentRequest) ProtoMessage() {}
+
+func (x *TransitionSegmentRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_segments_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TransitionSegmentRequest.ProtoReflect.Descriptor instead.
+func (*TransitionSegmentRequest) Descriptor() ([]byte, []int) {
+	return file_segments_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *TransitionSegmentRequest) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+var File_segments_proto protoreflect.FileDescriptor
+
+const file_segments_proto_rawDesc = "" +
+	"\n" +
+	"\x0esegments.proto\x12\x11nexus.segments.v1\x1a\x1bgoogle/protobuf/empty.proto\x1a\x1fgoogle/protobuf/timestamp.proto\"\x96\x05\n" +
+	"\aSegment\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x03R\x02id\x12\x12\n" +
+	"\x04name\x18\x02 \x01(\tR\x04name\x12$\n" +
+	"\vttl_seconds\x18\x03 \x01(\x05H\x00R\n" +
+	"ttlSeconds\x88\x01\x01\x129\n" +
+	"\n" +
+	"created_at\x18\x04 \x01(\v2\x1a.google.protobuf.TimestampR\tcreatedAt\x129\n" +
+	"\n" +
+	"updated_at\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\tupdatedAt\x12 \n" +
+	"\vdescription\x18\x06 \x01(\tR\vdescription\x12>\n" +
+	"\x06labels\x18\a \x03(\v2&.nexus.segments.v1.Segment.LabelsEntryR\x06labels\x125\n" +
+	"\x05state\x18\b \x01(\x0e2\x1f.nexus.segments.v1.SegmentStateR\x05state\x12;\n" +
+	"\vactive_from\x18\t \x01(\v2\x1a.google.protobuf.TimestampR\n" +
+	"activeFrom\x12=\n" +
+	"\factive_until\x18\n" +
+	" \x01(\v2\x1a.google.protobuf.TimestampR\vactiveUntil\x12\x12\n" +
+	"\x04live\x18\v \x01(\bR\x04live\x12\x1e\n" +
+	"\n" +
+	"expression\x18\f \x01(\tR\n" +
+	"expression\x12&\n" +
+	"\fmember_count\x18\r \x01(\x03H\x01R\vmemberCount\x88\x01\x01\x1a9\n" +
+	"\vLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01B\x0e\n" +
+	"\f_ttl_secondsB\x0f\n" +
+	"\r_member_count\"#\n" +
+	"\x11GetSegmentRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x03R\x02id\"\xb9\x01\n" +
+	"\x13ListSegmentsRequest\x12\x12\n" +
+	"\x04page\x18\x01 \x01(\x05R\x04page\x12\x1b\n" +
+	"\tpage_size\x18\x02 \x01(\x05R\bpageSize\x12\x16\n" +
+	"\x06labels\x18\x03 \x03(\tR\x06labels\x127\n" +
+	"\x06states\x18\x04 \x03(\x0e2\x1f.nexus.segments.v1.SegmentStateR\x06states\x12\x17\n" +
+	"\x04live\x18\x05 \x01(\bH\x00R\x04live\x88\x01\x01B\a\n" +
+	"\x05_live\"\xbb\x01\n" +
+	"\x14ListSegmentsResponse\x120\n" +
+	"\x05items\x18\x01 \x03(\v2\x1a.nexus.segments.v1.SegmentR\x05items\x12\x1f\n" +
+	"\vtotal_count\x18\x02 \x01(\x05R\n" +
+	"totalCount\x12\x12\n" +
+	"\x04page\x18\x03 \x01(\x05R\x04page\x12\x1b\n" +
+	"\tpage_size\x18\x04 \x01(\x05R\bpageSize\x12\x1f\n" +
+	"\vtotal_pages\x18\x05 \x01(\x05R\n" +
+	"totalPages\"\xdd\x03\n" +
+	"\x14CreateSegmentRequest\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12$\n" +
+	"\vttl_seconds\x18\x02 \x01(\x05H\x00R\n" +
+	"ttlSeconds\x88\x01\x01\x12 \n" +
+	"\vdescription\x18\x03 \x01(\tR\vdescription\x12K\n" +
+	"\x06labels\x18\x04 \x03(\v23.nexus.segments.v1.CreateSegmentRequest.LabelsEntryR\x06labels\x125\n" +
+	"\x05state\x18\x05 \x01(\x0e2\x1f.nexus.segments.v1.SegmentStateR\x05state\x12;\n" +
+	"\vactive_from\x18\x06 \x01(\v2\x1a.google.protobuf.TimestampR\n" +
+	"activeFrom\x12=\n" +
+	"\factive_until\x18\a \x01(\v2\x1a.google.protobuf.TimestampR\vactiveUntil\x12\x1e\n" +
+	"\n" +
+	"expression\x18\b \x01(\tR\n" +
+	"expression\x1a9\n" +
+	"\vLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01B\x0e\n" +
+	"\f_ttl_seconds\"\xb6\x03\n" +
+	"\x14UpdateSegmentRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x03R\x02id\x12\x12\n" +
+	"\x04name\x18\x02 \x01(\tR\x04name\x12$\n" +
+	"\vttl_seconds\x18\x03 \x01(\x05H\x00R\n" +
+	"ttlSeconds\x88\x01\x01\x12 \n" +
+	"\vdescription\x18\x04 \x01(\tR\vdescription\x12K\n" +
+	"\x06labels\x18\x05 \x03(\v23.nexus.segments.v1.UpdateSegmentRequest.LabelsEntryR\x06labels\x12;\n" +
+	"\vactive_from\x18\x06 \x01(\v2\x1a.google.protobuf.TimestampR\n" +
+	"activeFrom\x12=\n" +
+	"\factive_until\x18\a \x01(\v2\x1a.google.protobuf.TimestampR\vactiveUntil\x12\x1e\n" +
+	"\n" +
+	"expression\x18\b \x01(\tR\n" +
+	"expression\x1a9\n" +
+	"\vLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01B\x0e\n" +
+	"\f_ttl_seconds\"&\n" +
+	"\x14DeleteSegmentRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x03R\x02id\"*\n" +
+	"\x18TransitionSegmentRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x03R\x02id*\x96\x01\n" +
+	"\fSegmentState\x12\x1d\n" +
+	"\x19SEGMENT_STATE_UNSPECIFIED\x10\x00\x12\x17\n" +
+	"\x13SEGMENT_STATE_DRAFT\x10\x01\x12\x18\n" +
+	"\x14SEGMENT_STATE_ACTIVE\x10\x02\x12\x18\n" +
+	"\x14SEGMENT_STATE_PAUSED\x10\x03\x12\x1a\n" +
+	"\x16SEGMENT_STATE_ARCHIVED\x10\x042\xcf\x05\n" +
+	"\x0eSegmentService\x12N\n" +
+	"\n" +
+	"GetSegment\x12$.nexus.segments.v1.GetSegmentRequest\x1a\x1a.nexus.segments.v1.Segment\x12_\n" +
+	"\fListSegments\x12&.nexus.segments.v1.ListSegmentsRequest\x1a'.nexus.segments.v1.ListSegmentsResponse\x12T\n" +
+	"\rCreateSegment\x12'.nexus.segments.v1.CreateSegmentRequest\x1a\x1a.nexus.segments.v1.Segment\x12T\n" +
+	"\rUpdateSegment\x12'.nexus.segments.v1.UpdateSegmentRequest\x1a\x1a.nexus.segments.v1.Segment\x12P\n" +
+	"\rDeleteSegment\x12'.nexus.segments.v1.DeleteSegmentRequest\x1a\x16.google.protobuf.Empty\x12Z\n" +
+	"\x0fActivateSegment\x12+.nexus.segments.v1.TransitionSegmentRequest\x1a\x1a.nexus.segments.v1.Segment\x12W\n" +
+	"\fPauseSegment\x12+.nexus.segments.v1.TransitionSegmentRequest\x1a\x1a.nexus.segments.v1.Segment\x12Y\n" +
+	"\x0eArchiveSegment\x12+.nexus.segments.v1.TransitionSegmentRequest\x1a\x1a.nexus.segments.v1.SegmentBAZ?github.com/rickKoch/nexus/internal/segments/grpcport/segmentspbb\x06proto3"
+
+var (
+	file_segments_proto_rawDescOnce sync.Once
+	file_segments_proto_rawDescData []byte
+)
+
+func file_segments_proto_rawDescGZIP() []byte {
+	file_segments_proto_rawDescOnce.Do(func() {
+		file_segments_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_segments_proto_rawDesc), len(file_segments_proto_rawDesc)))
+	})
+	return file_segments_proto_rawDescData
+}
+
+var file_segments_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_segments_proto_msgTypes = make([]protoimpl.MessageInfo, 11)
+var file_segments_proto_goTypes = []any{
+	(SegmentState)(0),                // 0: nexus.segments.v1.SegmentState
+	(*Segment)(nil),                  // 1: nexus.segments.v1.Segment
+	(*GetSegmentRequest)(nil),        // 2: nexus.segments.v1.GetSegmentRequest
+	(*ListSegmentsRequest)(nil),      // 3: nexus.segments.v1.ListSegmentsRequest
+	(*ListSegmentsResponse)(nil),     // 4: nexus.segments.v1.ListSegmentsResponse
+	(*CreateSegmentRequest)(nil),     // 5: nexus.segments.v1.CreateSegmentRequest
+	(*UpdateSegmentRequest)(nil),     // 6: nexus.segments.v1.UpdateSegmentRequest
+	(*DeleteSegmentRequest)(nil),     // 7: nexus.segments.v1.DeleteSegmentRequest
+	(*TransitionSegmentRequest)(nil), // 8: nexus.segments.v1.TransitionSegmentRequest
+	nil,                              // 9: nexus.segments.v1.Segment.LabelsEntry
+	nil,                              // 10: nexus.segments.v1.CreateSegmentRequest.LabelsEntry
+	nil,                              // 11: nexus.segments.v1.UpdateSegmentRequest.LabelsEntry
+	(*timestamppb.Timestamp)(nil),    // 12: google.protobuf.Timestamp
+	(*emptypb.Empty)(nil),            // 13: google.protobuf.Empty
+}
+var file_segments_proto_depIdxs = []int32{
+	12, // 0: nexus.segments.v1.Segment.created_at:type_name -> google.protobuf.Timestamp
+	12, // 1: nexus.segments.v1.Segment.updated_at:type_name -> google.protobuf.Timestamp
+	9,  // 2: nexus.segments.v1.Segment.labels:type_name -> nexus.segments.v1.Segment.LabelsEntry
+	0,  // 3: nexus.segments.v1.Segment.state:type_name -> nexus.segments.v1.SegmentState
+	12, // 4: nexus.segments.v1.Segment.active_from:type_name -> google.protobuf.Timestamp
+	12, // 5: nexus.segments.v1.Segment.active_until:type_name -> google.protobuf.Timestamp
+	0,  // 6: nexus.segments.v1.ListSegmentsRequest.states:type_name -> nexus.segments.v1.SegmentState
+	1,  // 7: nexus.segments.v1.ListSegmentsResponse.items:type_name -> nexus.segments.v1.Segment
+	10, // 8: nexus.segments.v1.CreateSegmentRequest.labels:type_name -> nexus.segments.v1.CreateSegmentRequest.LabelsEntry
+	0,  // 9: nexus.segments.v1.CreateSegmentRequest.state:type_name -> nexus.segments.v1.SegmentState
+	12, // 10: nexus.segments.v1.CreateSegmentRequest.active_from:type_name -> google.protobuf.Timestamp
+	12, // 11: nexus.segments.v1.CreateSegmentRequest.active_until:type_name -> google.protobuf.Timestamp
+	11, // 12: nexus.segments.v1.UpdateSegmentRequest.labels:type_name -> nexus.segments.v1.UpdateSegmentRequest.LabelsEntry
+	12, // 13: nexus.segments.v1.UpdateSegmentRequest.active_from:type_name -> google.protobuf.Timestamp
+	12, // 14: nexus.segments.v1.UpdateSegmentRequest.active_until:type_name -> google.protobuf.Timestamp
+	2,  // 15: nexus.segments.v1.SegmentService.GetSegment:input_type -> nexus.segments.v1.GetSegmentRequest
+	3,  // 16: nexus.segments.v1.SegmentService.ListSegments:input_type -> nexus.segments.v1.ListSegmentsRequest
+	5,  // 17: nexus.segments.v1.SegmentService.CreateSegment:input_type -> nexus.segments.v1.CreateSegmentRequest
+	6,  // 18: nexus.segments.v1.SegmentService.UpdateSegment:input_type -> nexus.segments.v1.UpdateSegmentRequest
+	7,  // 19: nexus.segments.v1.SegmentService.DeleteSegment:input_type -> nexus.segments.v1.DeleteSegmentRequest
+	8,  // 20: nexus.segments.v1.SegmentService.ActivateSegment:input_type -> nexus.segments.v1.TransitionSegmentRequest
+	8,  // 21: nexus.segments.v1.SegmentService.PauseSegment:input_type -> nexus.segments.v1.TransitionSegmentRequest
+	8,  // 22: nexus.segments.v1.SegmentService.ArchiveSegment:input_type -> nexus.segments.v1.TransitionSegmentRequest
+	1,  // 23: nexus.segments.v1.SegmentService.GetSegment:output_type -> nexus.segments.v1.Segment
+	4,  // 24: nexus.segments.v1.SegmentService.ListSegments:output_type -> nexus.segments.v1.ListSegmentsResponse
+	1,  // 25: nexus.segments.v1.SegmentService.CreateSegment:output_type -> nexus.segments.v1.Segment
+	1,  // 26: nexus.segments.v1.SegmentService.UpdateSegment:output_type -> nexus.segments.v1.Segment
+	13, // 27: nexus.segments.v1.SegmentService.DeleteSegment:output_type -> google.protobuf.Empty
+	1,  // 28: nexus.segments.v1.SegmentService.ActivateSegment:output_type -> nexus.segments.v1.Segment
+	1,  // 29: nexus.segments.v1.SegmentService.PauseSegment:output_type -> nexus.segments.v1.Segment
+	1,  // 30: nexus.segments.v1.SegmentService.ArchiveSegment:output_type -> nexus.segments.v1.Segment
+	23, // [23:31] is the sub-list for method output_type
+	15, // [15:23] is the sub-list for method input_type
+	15, // [15:15] is the sub-list for extension type_name
+	15, // [15:15] is the sub-list for extension extendee
+	0,  // [0:15] is the sub-list for field type_name
+}
+
+func init() { file_segments_proto_init() }
+func file_segments_proto_init() {
+	if File_segments_proto != nil {
+		return
+	}
+	file_segments_proto_msgTypes[0].OneofWrappers = []any{}
+	file_segments_proto_msgTypes[2].OneofWrappers = []any{}
+	file_segments_proto_msgTypes[4].OneofWrappers = []any{}
+	file_segments_proto_msgTypes[5].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_segments_proto_rawDesc), len(file_segments_proto_rawDesc)),
+			NumEnums:      1,
+			NumMessages:   11,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_segments_proto_goTypes,
+		DependencyIndexes: file_segments_proto_depIdxs,
+		EnumInfos:         file_segments_proto_enumTypes,
+		MessageInfos:      file_segments_proto_msgTypes,
+	}.Build()
+	File_segments_proto = out.File
+	file_segments_proto_goTypes = nil
+	file_segments_proto_depIdxs = nil
+}
@@ -1,24 +1,162 @@
 package main
 
 import (
+	"context"
 	"net/http"
+	"os"
+	"strings"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/rickKoch/nexus/internal/segments/grpcport"
+	"github.com/rickKoch/nexus/internal/segments/grpcport/segmentspb"
 	"github.com/rickKoch/nexus/internal/segments/port"
 	"github.com/rickKoch/nexus/internal/segments/service"
+	"github.com/rickKoch/nexus/pkg/config"
 	"github.com/rickKoch/nexus/pkg/server"
 	"github.com/rickKoch/nexus/pkg/signals"
 	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
 )
 
 func main() {
+	cfg, args, err := config.Load(os.Args[1:])
+	if err != nil {
+		logrus.WithError(err).Panic("Failed to load configuration")
+	}
+
+	if len(args) > 0 {
+		if args[0] != "migrate" {
+			logrus.Fatalf("Unknown command '%s'", args[0])
+		}
+		if err := runMigrate(signals.Context(), cfg, args[1:]); err != nil {
+			logrus.WithError(err).Fatal("Migration failed")
+		}
+		return
+	}
+
+	var dump strings.Builder
+	if err := cfg.Dump(&dump); err == nil {
+		logrus.Info("Loaded configuration:\n" + dump.String())
+	}
+
 	ctx := signals.Context()
-	application, err := service.NewApplication(ctx)
+	application, err := service.NewApplication(ctx, cfg)
 	if err != nil {
 		logrus.WithError(err).Panic("Failed to initialize application")
 	}
 
-	server.RunHTTPServer(func(router chi.Router) http.Handler {
-		return port.HandlerFromMux(port.NewHttpServer(application), router)
-	})
+	// Reads only lag behind writes with PostgreSQL read replicas.
+	readYourWrites := cfg.Storage.Driver == config.StorageDriverPostgres && len(cfg.Postgres.Replicas) > 0 && cfg.Postgres.ReadYourWrites
+
+	servers := []func(ctx context.Context) error{
+		func(ctx context.Context) error {
+			handler, err := port.NewHandler(application, server.OpenAPIValidatorOptions{
+				ValidateResponses: cfg.HTTP.ValidateResponses,
+			})
+			if err != nil {
+				return err
+			}
+
+			return server.RunHTTPServer(ctx, cfg.HTTP, func(router chi.Router) http.Handler {
+				if readYourWrites {
+					router.Use(service.ReadYourWritesMiddleware)
+				}
+				router.Mount("/", handler)
+				return router
+			})
+		},
+	}
+	if cfg.GRPC.Enabled() {
+		var interceptors []grpc.UnaryServerInterceptor
+		if readYourWrites {
+			interceptors = append(interceptors, service.ReadYourWritesInterceptor)
+		}
+		servers = append(servers, func(ctx context.Context) error {
+			return server.RunGRPCServer(ctx, cfg.GRPC, func(s *grpc.Server) {
+				segmentspb.RegisterSegmentServiceServer(s, grpcport.NewGrpcServer(application))
+			}, interceptors...)
+		})
+	}
+
+	if scheduler := application.WindowScheduler; scheduler != nil {
+		servers = append(servers, func(ctx context.Context) error {
+			scheduler.Run(ctx, func(err error) {
+				logrus.WithError(err).Warn("Failed to check segment activation windows")
+			})
+			return nil
+		})
+	}
+
+	if recorder := application.SizeHistoryRecorder; recorder != nil {
+		servers = append(servers, func(ctx context.Context) error {
+			recorder.Run(ctx, func(err error) {
+				logrus.WithError(err).Warn("Failed to record segment size history")
+			})
+			return nil
+		})
+	}
+
+	if runJournal := application.RunJournal; runJournal != nil {
+		servers = append(servers, func(ctx context.Context) error {
+			runJournal(ctx, func(err error) {
+				logrus.WithError(err).Error("Failed to maintain the segment journal")
+			})
+			return nil
+		})
+	}
+
+	if maintainPartitions := application.MaintainPartitions; maintainPartitions != nil {
+		servers = append(servers, func(ctx context.Context) error {
+			maintainPartitions(ctx, func(err error) {
+				logrus.WithError(err).Error("Failed to maintain size history partitions")
+			})
+			return nil
+		})
+	}
+
+	if syncMembershipStore := application.SyncMembershipStore; syncMembershipStore != nil {
+		servers = append(servers, func(ctx context.Context) error {
+			syncMembershipStore(ctx, func(err error) {
+				logrus.WithError(err).Error("Failed to sync the membership store")
+			})
+			return nil
+		})
+	}
+
+	err = runServers(ctx, servers...)
+	if cacheStats := application.SegmentCacheStats; cacheStats != nil {
+		stats := cacheStats()
+		logrus.WithFields(logrus.Fields{
+			"hits":      stats.Hits,
+			"misses":    stats.Misses,
+			"evictions": stats.Evictions,
+		}).Info("Segment cache statistics")
+	}
+	if err != nil {
+		logrus.WithError(err).Panic("Server failed")
+	}
+}
+
+// runServers runs every server until ctx is cancelled or one of them fails,
+// in which case the others are shut down as well.
+func runServers(ctx context.Context, servers ...func(ctx context.Context) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errCh := make(chan error, len(servers))
+	for _, run := range servers {
+		go func() {
+			errCh <- run(ctx)
+		}()
+	}
+
+	var firstErr error
+	for range servers {
+		if err := <-errCh; err != nil && firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+
+	return firstErr
 }
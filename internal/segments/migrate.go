@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/rickKoch/nexus/internal/segments/service"
+	"github.com/rickKoch/nexus/pkg/config"
+)
+
+const migrateUsage = "usage: segment-service migrate [status|up|down|redo]"
+
+// runMigrate executes the migrate subcommand.
+func runMigrate(ctx context.Context, cfg config.Config, args []string) error {
+	op := "up"
+	if len(args) > 0 {
+		op = args[0]
+	}
+	if len(args) > 1 {
+		return fmt.Errorf("too many arguments\n%s", migrateUsage)
+	}
+
+	m, db, err := service.NewMigrator(cfg)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = db.Close() }()
+
+	switch op {
+	case "status":
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		_, _ = fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, s := range statuses {
+			appliedAt := "pending"
+			if s.Applied() {
+				appliedAt = s.AppliedAt.Format("2006-01-02T15:04:05Z07:00")
+			}
+			_, _ = fmt.Fprintf(w, "%d\t%s\t%s\n", s.Version, s.Name, appliedAt)
+		}
+		return w.Flush()
+	case "up":
+		applied, err := m.Up(ctx)
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Println("no pending migrations")
+		}
+		for _, mig := range applied {
+			fmt.Printf("applied %d_%s\n", mig.Version, mig.Name)
+		}
+		return nil
+	case "down":
+		mig, err := m.Down(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("rolled back %d_%s\n", mig.Version, mig.Name)
+		return nil
+	case "redo":
+		mig, err := m.Redo(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("redone %d_%s\n", mig.Version, mig.Name)
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate operation '%s'\n%s", op, migrateUsage)
+	}
+}
@@ -0,0 +1,152 @@
+package port
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// exportFlushInterval is the number of members written between flushes, so
+// that clients receive an export in chunks while it is being produced.
+const exportFlushInterval = 1000
+
+type exportFormat string
+
+const (
+	exportFormatCSV    exportFormat = "csv"
+	exportFormatNDJSON exportFormat = "ndjson"
+)
+
+func (f exportFormat) contentType() string {
+	if f == exportFormatNDJSON {
+		return "application/x-ndjson"
+	}
+	return "text/csv"
+}
+
+// exportWriter encodes members onto a response as they are streamed. The
+// response headers are sent with the first member, so that errors occurring
+// before any member is read can still be answered with an error status.
+type exportWriter struct {
+	w       http.ResponseWriter
+	format  exportFormat
+	gzip    bool
+	started bool
+	written int
+
+	gz   *gzip.Writer
+	body io.Writer
+	csv  *csv.Writer
+	json *json.Encoder
+}
+
+func newExportWriter(w http.ResponseWriter, format exportFormat, gzip bool) *exportWriter {
+	return &exportWriter{w: w, format: format, gzip: gzip}
+}
+
+func (e *exportWriter) start() error {
+	e.started = true
+
+	header := e.w.Header()
+	header.Set("Content-Type", e.format.contentType())
+	header.Add("Vary", "Accept-Encoding")
+	e.body = e.w
+	if e.gzip {
+		header.Set("Content-Encoding", "gzip")
+		e.gz = gzip.NewWriter(e.w)
+		e.body = e.gz
+	}
+	e.w.WriteHeader(http.StatusOK)
+
+	if e.format == exportFormatNDJSON {
+		e.json = json.NewEncoder(e.body)
+		return nil
+	}
+	e.csv = csv.NewWriter(e.body)
+	return e.csv.Write([]string{"member_id"})
+}
+
+func (e *exportWriter) write(memberID string) error {
+	if !e.started {
+		if err := e.start(); err != nil {
+			return err
+		}
+	}
+
+	var err error
+	if e.format == exportFormatNDJSON {
+		err = e.json.Encode(struct {
+			MemberID string `json:"member_id"`
+		}{memberID})
+	} else {
+		err = e.csv.Write([]string{memberID})
+	}
+	if err != nil {
+		return err
+	}
+
+	e.written++
+	if e.written%exportFlushInterval == 0 {
+		return e.flush()
+	}
+	return nil
+}
+
+// flush pushes the buffered members to the client.
+func (e *exportWriter) flush() error {
+	if e.csv != nil {
+		e.csv.Flush()
+		if err := e.csv.Error(); err != nil {
+			return err
+		}
+	}
+	if e.gz != nil {
+		if err := e.gz.Flush(); err != nil {
+			return err
+		}
+	}
+	// Writers that cannot flush, such as buffering middleware, send the
+	// response once the handler returns.
+	_ = http.NewResponseController(e.w).Flush()
+	return nil
+}
+
+// close completes the export, sending the headers if no member was written.
+func (e *exportWriter) close() error {
+	if !e.started {
+		if err := e.start(); err != nil {
+			return err
+		}
+	}
+	if err := e.flush(); err != nil {
+		return err
+	}
+	if e.gz != nil {
+		return e.gz.Close()
+	}
+	return nil
+}
+
+// acceptsGzip reports whether the request's Accept-Encoding header allows a
+// gzip-encoded response.
+func acceptsGzip(r *http.Request) bool {
+	for _, value := range r.Header.Values("Accept-Encoding") {
+		for _, coding := range strings.Split(value, ",") {
+			name, params, _ := strings.Cut(strings.TrimSpace(coding), ";")
+			if !strings.EqualFold(strings.TrimSpace(name), "gzip") {
+				continue
+			}
+			q, ok := strings.CutPrefix(strings.ReplaceAll(params, " ", ""), "q=")
+			if !ok {
+				return true
+			}
+			weight, err := strconv.ParseFloat(q, 64)
+			return err == nil && weight > 0
+		}
+	}
+	return false
+}
@@ -2,11 +2,16 @@ package port
 
 import (
 	"encoding/json"
+	"errors"
+	"mime"
 	"net/http"
+	"time"
 
 	"github.com/rickKoch/nexus/internal/segments/app"
 	"github.com/rickKoch/nexus/internal/segments/app/segments"
 	"github.com/rickKoch/nexus/internal/segments/domain/segment"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
 )
 
 type HttpServer struct {
@@ -19,15 +24,25 @@ func NewHttpServer(application app.Application) HttpServer {
 	}
 }
 
+// now returns the time at which responses evaluate activation windows.
+func (h HttpServer) now() time.Time {
+	return h.app.Segments.Clock.Now()
+}
+
 // GetSegment handles GET /segment/:id
 func (h HttpServer) GetSegment(w http.ResponseWriter, r *http.Request, params GetSegmentParams) {
-	seg, err := h.app.Segments.GetSegment.Handle(r.Context(), segments.GetSegment{ID: params.ID})
+	seg, err := h.app.Segments.GetSegment.Handle(r.Context(), segments.GetSegment{ID: params.ID, AsOf: params.AsOf})
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		renderError(w, err)
 		return
 	}
 
-	render(w, http.StatusOK, toSegmentResponse(seg))
+	// Past segments report whether they were live back then.
+	at := h.now()
+	if params.AsOf != nil {
+		at = *params.AsOf
+	}
+	render(w, http.StatusOK, ToSegmentResponse(seg, at))
 }
 
 // ListSegments handles GET /segment
@@ -39,16 +54,21 @@ func (h HttpServer) ListSegments(w http.ResponseWriter, r *http.Request, params
 	if params.PageSize != nil {
 		cmd.PageSize = *params.PageSize
 	}
+	cmd.Labels = params.Label
+	for _, state := range params.State {
+		cmd.States = append(cmd.States, segment.State(state))
+	}
+	cmd.Live = params.Live
 
 	result, err := h.app.Segments.ListSegments.Handle(r.Context(), cmd)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		renderError(w, err)
 		return
 	}
 
 	items := make([]SegmentResponse, 0, len(result.Segments))
 	for i := range result.Segments {
-		items = append(items, toSegmentResponse(&result.Segments[i]))
+		items = append(items, ToSegmentResponse(&result.Segments[i], h.now()))
 	}
 
 	response := ListSegmentsResponse{
@@ -71,15 +91,21 @@ func (h HttpServer) CreateSegment(w http.ResponseWriter, r *http.Request) {
 	}
 
 	seg, err := h.app.Segments.CreateSegment.Handle(r.Context(), segments.CreateSegment{
-		Name:       req.Name,
-		TTLSeconds: req.TTLSeconds,
+		Name:        req.Name,
+		Description: req.Description,
+		Labels:      req.Labels,
+		TTLSeconds:  req.TTLSeconds,
+		ActiveFrom:  req.ActiveFrom,
+		ActiveUntil: req.ActiveUntil,
+		Expression:  req.Expression,
+		State:       segment.State(req.State),
 	})
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		renderError(w, err)
 		return
 	}
 
-	render(w, http.StatusCreated, toSegmentResponse(seg))
+	render(w, http.StatusCreated, ToSegmentResponse(seg, h.now()))
 }
 
 // UpdateSegment handles PUT /segment/:id
@@ -91,46 +117,507 @@ func (h HttpServer) UpdateSegment(w http.ResponseWriter, r *http.Request, params
 	}
 
 	seg, err := h.app.Segments.UpdateSegment.Handle(r.Context(), segments.UpdateSegment{
-		ID:         params.ID,
-		Name:       req.Name,
-		TTLSeconds: req.TTLSeconds,
+		ID:          params.ID,
+		Name:        req.Name,
+		Description: req.Description,
+		Labels:      req.Labels,
+		TTLSeconds:  req.TTLSeconds,
+		ActiveFrom:  req.ActiveFrom,
+		ActiveUntil: req.ActiveUntil,
+		Expression:  req.Expression,
 	})
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		renderError(w, err)
 		return
 	}
 
-	render(w, http.StatusOK, toSegmentResponse(seg))
+	render(w, http.StatusOK, ToSegmentResponse(seg, h.now()))
 }
 
 // DeleteSegment handles DELETE /segment/:id
 func (h HttpServer) DeleteSegment(w http.ResponseWriter, r *http.Request, params DeleteSegmentParams) {
 	err := h.app.Segments.DeleteSegment.Handle(r.Context(), segments.DeleteSegment{ID: params.ID})
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		renderError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// BatchSegments handles POST /segment:batch
+func (h HttpServer) BatchSegments(w http.ResponseWriter, r *http.Request) {
+	var req BatchSegmentsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	cmd := segments.BatchSegments{
+		Mode:       segments.BatchModeAtomic,
+		Operations: make([]segments.BatchOperation, 0, len(req.Operations)),
+	}
+	if req.Mode != "" {
+		cmd.Mode = segments.BatchMode(req.Mode)
+	}
+	for _, op := range req.Operations {
+		batchOp := segments.BatchOperation{
+			Type:        segments.BatchOperationType(op.Op),
+			Name:        op.Name,
+			Description: op.Description,
+			Labels:      op.Labels,
+			TTLSeconds:  op.TTLSeconds,
+			ActiveFrom:  op.ActiveFrom,
+			ActiveUntil: op.ActiveUntil,
+			Expression:  op.Expression,
+		}
+		if op.ID != nil {
+			batchOp.ID = *op.ID
+		}
+		cmd.Operations = append(cmd.Operations, batchOp)
+	}
+
+	result, err := h.app.Segments.BatchSegments.Handle(r.Context(), cmd)
+	if err != nil {
+		renderError(w, err)
+		return
+	}
+
+	render(w, http.StatusOK, toBatchSegmentsResponse(result, h.now()))
+}
+
+// ActivateSegment handles POST /segment/:id:activate
+func (h HttpServer) ActivateSegment(w http.ResponseWriter, r *http.Request, params TransitionSegmentParams) {
+	h.transitionSegment(w, r, params.ID, segment.StateActive)
+}
+
+// PauseSegment handles POST /segment/:id:pause
+func (h HttpServer) PauseSegment(w http.ResponseWriter, r *http.Request, params TransitionSegmentParams) {
+	h.transitionSegment(w, r, params.ID, segment.StatePaused)
+}
+
+// ArchiveSegment handles POST /segment/:id:archive
+func (h HttpServer) ArchiveSegment(w http.ResponseWriter, r *http.Request, params TransitionSegmentParams) {
+	h.transitionSegment(w, r, params.ID, segment.StateArchived)
+}
+
+func (h HttpServer) transitionSegment(w http.ResponseWriter, r *http.Request, id int, state segment.State) {
+	seg, err := h.app.Segments.TransitionSegment.Handle(r.Context(), segments.TransitionSegment{ID: id, State: state})
+	if err != nil {
+		renderError(w, err)
+		return
+	}
+
+	render(w, http.StatusOK, ToSegmentResponse(seg, h.now()))
+}
+
+// AddMembers handles POST /segment/:id/members:add
+func (h HttpServer) AddMembers(w http.ResponseWriter, r *http.Request, params MembersParams) {
+	var req MembersRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err := h.app.Segments.AddMembers.Handle(r.Context(), segments.AddMembers{SegmentID: params.ID, MemberIDs: req.Members})
+	if err != nil {
+		renderError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RemoveMembers handles POST /segment/:id/members:remove
+func (h HttpServer) RemoveMembers(w http.ResponseWriter, r *http.Request, params MembersParams) {
+	var req MembersRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err := h.app.Segments.RemoveMembers.Handle(r.Context(), segments.RemoveMembers{SegmentID: params.ID, MemberIDs: req.Members})
+	if err != nil {
+		renderError(w, err)
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// ExportMembers handles GET /segment/:id/members:export. Members are streamed
+// in ascending ID order as CSV or NDJSON, gzip-compressed when the client
+// accepts it. Passing the last received member ID as after resumes an
+// interrupted export.
+func (h HttpServer) ExportMembers(w http.ResponseWriter, r *http.Request, params ExportMembersParams) {
+	format := exportFormatCSV
+	if params.Format != nil {
+		format = exportFormat(*params.Format)
+	}
+	if format != exportFormatCSV && format != exportFormatNDJSON {
+		http.Error(w, "unknown export format '"+string(format)+"'", http.StatusBadRequest)
+		return
+	}
+
+	cmd := segments.ExportMembers{SegmentID: params.ID}
+	if params.After != nil {
+		cmd.After = *params.After
+	}
+
+	ew := newExportWriter(w, format, acceptsGzip(r))
+	err := h.app.Segments.ExportMembers.Handle(r.Context(), cmd, ew.write)
+	if err != nil && !ew.started {
+		renderError(w, err)
+		return
+	}
+	if err == nil {
+		err = ew.close()
+	}
+	if err != nil {
+		// The status line is already sent, so abort the connection to let
+		// the client see a truncated export rather than a complete one.
+		if r.Context().Err() == nil {
+			logrus.WithError(err).WithField("segment_id", params.ID).Error("Failed to export segment members")
+		}
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// CheckMembership handles GET /segment/:id/members/:member_id
+func (h HttpServer) CheckMembership(w http.ResponseWriter, r *http.Request, params CheckMembershipParams) {
+	membership, err := h.app.Segments.CheckMembership.Handle(r.Context(), segments.CheckMembership{
+		SegmentID: params.ID,
+		MemberID:  params.MemberID,
+	})
+	if err != nil {
+		renderError(w, err)
+		return
+	}
+
+	render(w, http.StatusOK, MembershipResponse{
+		SegmentID: membership.SegmentID,
+		MemberID:  membership.MemberID,
+		IsMember:  membership.IsMember,
+	})
+}
+
+// GetSegmentStats handles GET /segment/:id/stats
+func (h HttpServer) GetSegmentStats(w http.ResponseWriter, r *http.Request, params GetSegmentStatsParams) {
+	stats, err := h.app.Segments.GetSegmentStats.Handle(r.Context(), segments.GetSegmentStats{
+		ID:   params.ID,
+		From: params.From,
+		To:   params.To,
+	})
+	if err != nil {
+		renderError(w, err)
+		return
+	}
+
+	render(w, http.StatusOK, toSegmentStatsResponse(stats))
+}
+
+// EstimateSegment handles GET /segment/:id/estimate
+func (h HttpServer) EstimateSegment(w http.ResponseWriter, r *http.Request, params EstimateSegmentParams) {
+	estimate, err := h.app.Segments.EstimateSegment.Handle(r.Context(), segments.EstimateSegment{ID: params.ID})
+	if err != nil {
+		renderError(w, err)
+		return
+	}
+
+	render(w, http.StatusOK, toSegmentEstimateResponse(*estimate))
+}
+
+// EstimateOverlap handles GET /segment:overlap
+func (h HttpServer) EstimateOverlap(w http.ResponseWriter, r *http.Request, params EstimateOverlapParams) {
+	estimate, err := h.app.Segments.EstimateOverlap.Handle(r.Context(), segments.EstimateOverlap{IDs: params.ID})
+	if err != nil {
+		renderError(w, err)
+		return
+	}
+
+	render(w, http.StatusOK, toOverlapEstimateResponse(estimate))
+}
+
+// ListSegmentVersions handles GET /segment/:id/versions
+func (h HttpServer) ListSegmentVersions(w http.ResponseWriter, r *http.Request, params ListSegmentVersionsParams) {
+	revisions, err := h.app.Segments.ListVersions.Handle(r.Context(), segments.ListSegmentVersions{ID: params.ID})
+	if err != nil {
+		renderError(w, err)
+		return
+	}
+
+	items := make([]SegmentVersionResponse, 0, len(revisions))
+	for _, revision := range revisions {
+		items = append(items, toSegmentVersionResponse(revision))
+	}
+	render(w, http.StatusOK, ListSegmentVersionsResponse{SegmentID: params.ID, Items: items})
+}
+
+// GetSegmentVersion handles GET /segment/:id/versions/:version
+func (h HttpServer) GetSegmentVersion(w http.ResponseWriter, r *http.Request, params SegmentVersionParams) {
+	revision, err := h.app.Segments.GetVersion.Handle(r.Context(), segments.GetSegmentVersion{ID: params.ID, Version: params.Version})
+	if err != nil {
+		renderError(w, err)
+		return
+	}
+
+	render(w, http.StatusOK, toSegmentVersionResponse(*revision))
+}
+
+// DiffSegmentVersions handles GET /segment/:id/versions:diff
+func (h HttpServer) DiffSegmentVersions(w http.ResponseWriter, r *http.Request, params DiffSegmentVersionsParams) {
+	diff, err := h.app.Segments.DiffVersions.Handle(r.Context(), segments.DiffSegmentVersions{
+		ID:   params.ID,
+		From: params.From,
+		To:   params.To,
+	})
+	if err != nil {
+		renderError(w, err)
+		return
+	}
+
+	render(w, http.StatusOK, toSegmentVersionDiffResponse(params.ID, diff))
+}
+
+// RollbackSegment handles POST /segment/:id/versions/:version:rollback
+func (h HttpServer) RollbackSegment(w http.ResponseWriter, r *http.Request, params SegmentVersionParams) {
+	seg, err := h.app.Segments.RollbackSegment.Handle(r.Context(), segments.RollbackSegment{ID: params.ID, Version: params.Version})
+	if err != nil {
+		renderError(w, err)
+		return
+	}
+
+	render(w, http.StatusOK, ToSegmentResponse(seg, h.now()))
+}
+
+// ApplySegments handles POST /segment:apply. The manifest is accepted as
+// JSON or, with a YAML content type, as YAML.
+func (h HttpServer) ApplySegments(w http.ResponseWriter, r *http.Request) {
+	var req ApplySegmentsRequest
+	var err error
+	switch mediaType(r) {
+	case "application/yaml", "application/x-yaml":
+		err = yaml.NewDecoder(r.Body).Decode(&req)
+	default:
+		err = json.NewDecoder(r.Body).Decode(&req)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	cmd := segments.ApplySegments{
+		Segments: make([]segments.DesiredSegment, 0, len(req.Segments)),
+		Prune:    req.Prune,
+		DryRun:   req.DryRun,
+	}
+	for _, s := range req.Segments {
+		cmd.Segments = append(cmd.Segments, segments.DesiredSegment{
+			Name:        s.Name,
+			Description: s.Description,
+			Labels:      s.Labels,
+			TTLSeconds:  s.TTLSeconds,
+			ActiveFrom:  s.ActiveFrom,
+			ActiveUntil: s.ActiveUntil,
+			Expression:  s.Expression,
+		})
+	}
+
+	result, err := h.app.Segments.ApplySegments.Handle(r.Context(), cmd)
+	if err != nil {
+		renderError(w, err)
+		return
+	}
+
+	render(w, http.StatusOK, ToApplySegmentsResponse(result, h.now()))
+}
+
 // Request/Response types
 type CreateSegmentRequest struct {
-	Name       string `json:"name"`
-	TTLSeconds *int   `json:"ttl_seconds,omitempty"`
+	Name        string            `json:"name"`
+	Description string            `json:"description,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	TTLSeconds  *int              `json:"ttl_seconds,omitempty"`
+	ActiveFrom  *time.Time        `json:"active_from,omitempty"`
+	ActiveUntil *time.Time        `json:"active_until,omitempty"`
+	Expression  string            `json:"expression,omitempty"`
+	State       string            `json:"state,omitempty"`
 }
 
+// UpdateSegmentRequest replaces the segment's mutable fields; omitted
+// optional fields are cleared.
 type UpdateSegmentRequest struct {
-	Name       string `json:"name"`
-	TTLSeconds *int   `json:"ttl_seconds,omitempty"`
+	Name        string            `json:"name"`
+	Description string            `json:"description,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	TTLSeconds  *int              `json:"ttl_seconds,omitempty"`
+	ActiveFrom  *time.Time        `json:"active_from,omitempty"`
+	ActiveUntil *time.Time        `json:"active_until,omitempty"`
+	Expression  string            `json:"expression,omitempty"`
+}
+
+// MembersRequest lists the member IDs to add to or remove from a segment.
+type MembersRequest struct {
+	Members []string `json:"members"`
+}
+
+type BatchSegmentsRequest struct {
+	Mode       string                  `json:"mode,omitempty"`
+	Operations []BatchOperationRequest `json:"operations"`
+}
+
+type BatchOperationRequest struct {
+	Op          string            `json:"op"`
+	ID          *int              `json:"id,omitempty"`
+	Name        string            `json:"name,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	TTLSeconds  *int              `json:"ttl_seconds,omitempty"`
+	ActiveFrom  *time.Time        `json:"active_from,omitempty"`
+	ActiveUntil *time.Time        `json:"active_until,omitempty"`
+	Expression  string            `json:"expression,omitempty"`
+}
+
+type BatchSegmentsResponse struct {
+	Mode      string                   `json:"mode"`
+	Succeeded int                      `json:"succeeded"`
+	Failed    int                      `json:"failed"`
+	Results   []BatchOperationResponse `json:"results"`
+}
+
+type BatchOperationResponse struct {
+	Index   int              `json:"index"`
+	Op      string           `json:"op"`
+	Status  string           `json:"status"`
+	ID      *int             `json:"id,omitempty"`
+	Segment *SegmentResponse `json:"segment,omitempty"`
+	Error   string           `json:"error,omitempty"`
+}
+
+// ApplySegmentsRequest is the manifest describing the desired segments.
+type ApplySegmentsRequest struct {
+	Segments []ManifestSegment `json:"segments" yaml:"segments"`
+	Prune    bool              `json:"prune,omitempty" yaml:"prune,omitempty"`
+	DryRun   bool              `json:"dry_run,omitempty" yaml:"dry_run,omitempty"`
+}
+
+type ManifestSegment struct {
+	Name        string            `json:"name" yaml:"name"`
+	Description string            `json:"description,omitempty" yaml:"description,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+	TTLSeconds  *int              `json:"ttl_seconds,omitempty" yaml:"ttl_seconds,omitempty"`
+	ActiveFrom  *time.Time        `json:"active_from,omitempty" yaml:"active_from,omitempty"`
+	ActiveUntil *time.Time        `json:"active_until,omitempty" yaml:"active_until,omitempty"`
+	Expression  string            `json:"expression,omitempty" yaml:"expression,omitempty"`
+}
+
+type ApplySegmentsResponse struct {
+	DryRun  bool                `json:"dry_run"`
+	Summary ApplySummary        `json:"summary"`
+	Changes []PlannedChangeItem `json:"changes"`
+}
+
+type ApplySummary struct {
+	Create    int `json:"create"`
+	Update    int `json:"update"`
+	Delete    int `json:"delete"`
+	Unchanged int `json:"unchanged"`
+	Unmanaged int `json:"unmanaged"`
+}
+
+type PlannedChangeItem struct {
+	Action  string           `json:"action"`
+	Name    string           `json:"name"`
+	Desired *ManifestSegment `json:"desired,omitempty"`
+	Current *SegmentResponse `json:"current,omitempty"`
+	Segment *SegmentResponse `json:"segment,omitempty"`
 }
 
 type SegmentResponse struct {
-	ID         int    `json:"id"`
-	Name       string `json:"name"`
-	TTLSeconds *int   `json:"ttl_seconds,omitempty"`
-	CreatedAt  string `json:"created_at"`
-	UpdatedAt  string `json:"updated_at"`
+	ID          int               `json:"id"`
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	Labels      map[string]string `json:"labels"`
+	TTLSeconds  *int              `json:"ttl_seconds,omitempty"`
+	ActiveFrom  *string           `json:"active_from,omitempty"`
+	ActiveUntil *string           `json:"active_until,omitempty"`
+	// Expression is set for composite segments only.
+	Expression string `json:"expression,omitempty"`
+	// MemberCount is set for regular segments only.
+	MemberCount *int   `json:"member_count,omitempty"`
+	State       string `json:"state"`
+	// Live is true when the segment is active and inside its activation window.
+	Live      bool   `json:"live"`
+	CreatedAt string `json:"created_at"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+type SegmentStatsResponse struct {
+	SegmentID int `json:"segment_id"`
+	// MemberCount is set for regular segments only.
+	MemberCount *int                   `json:"member_count,omitempty"`
+	From        string                 `json:"from"`
+	To          string                 `json:"to"`
+	History     []SizeSnapshotResponse `json:"history"`
+}
+
+type SizeSnapshotResponse struct {
+	Date        string `json:"date"`
+	MemberCount int    `json:"member_count"`
+}
+
+type MembershipResponse struct {
+	SegmentID int    `json:"segment_id"`
+	MemberID  string `json:"member_id"`
+	IsMember  bool   `json:"is_member"`
+}
+
+type SegmentEstimateResponse struct {
+	SegmentID      int    `json:"segment_id"`
+	EstimatedCount uint64 `json:"estimated_count"`
+}
+
+type OverlapEstimateResponse struct {
+	SegmentIDs   []int                     `json:"segment_ids"`
+	Segments     []SegmentEstimateResponse `json:"segments"`
+	Union        uint64                    `json:"union"`
+	Intersection uint64                    `json:"intersection"`
+	Jaccard      float64                   `json:"jaccard"`
+}
+
+// SegmentVersionResponse is a revision of a segment. Member counts are not
+// part of revisions.
+type SegmentVersionResponse struct {
+	Version     int               `json:"version"`
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	Labels      map[string]string `json:"labels"`
+	TTLSeconds  *int              `json:"ttl_seconds,omitempty"`
+	ActiveFrom  *string           `json:"active_from,omitempty"`
+	ActiveUntil *string           `json:"active_until,omitempty"`
+	Expression  string            `json:"expression,omitempty"`
+	State       string            `json:"state"`
+	SavedAt     string            `json:"saved_at"`
+}
+
+type ListSegmentVersionsResponse struct {
+	SegmentID int                      `json:"segment_id"`
+	Items     []SegmentVersionResponse `json:"items"`
+}
+
+type SegmentVersionDiffResponse struct {
+	SegmentID int                   `json:"segment_id"`
+	From      int                   `json:"from"`
+	To        int                   `json:"to"`
+	Changes   []FieldChangeResponse `json:"changes"`
+}
+
+// FieldChangeResponse is a field that differs between two versions. Absent
+// values are null.
+type FieldChangeResponse struct {
+	Field string `json:"field"`
+	From  any    `json:"from"`
+	To    any    `json:"to"`
 }
 
 type ListSegmentsResponse struct {
@@ -147,12 +634,276 @@ func render(w http.ResponseWriter, status int, data interface{}) {
 	_ = json.NewEncoder(w).Encode(data)
 }
 
-func toSegmentResponse(s *segment.Segment) SegmentResponse {
+// renderError writes err as plain text with a status code derived from the
+// domain error it wraps.
+func renderError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, segment.ErrSegmentNotFound),
+		errors.Is(err, segment.ErrRevisionNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, segment.ErrNameRequired),
+		errors.Is(err, segment.ErrNameTooLong),
+		errors.Is(err, segment.ErrInvalidTTL),
+		errors.Is(err, segment.ErrInvalidActivationWindow),
+		errors.Is(err, segment.ErrDescriptionTooLong),
+		errors.Is(err, segment.ErrTooManyLabels),
+		errors.Is(err, segment.ErrInvalidLabelKey),
+		errors.Is(err, segment.ErrLabelValueTooLong),
+		errors.Is(err, segment.ErrInvalidLabelSelector),
+		errors.Is(err, segment.ErrInvalidState),
+		errors.Is(err, segment.ErrInvalidInitialState),
+		errors.Is(err, segment.ErrInvalidExpression),
+		errors.Is(err, segment.ErrExpressionTooLong),
+		errors.Is(err, segment.ErrUnknownReference),
+		errors.Is(err, segment.ErrReferenceCycle),
+		errors.Is(err, segment.ErrInvalidMemberID),
+		errors.Is(err, segments.ErrEmptyMembers),
+		errors.Is(err, segments.ErrTooManyMembers),
+		errors.Is(err, segments.ErrInvalidStatsRange),
+		errors.Is(err, segments.ErrInvalidOverlap),
+		errors.Is(err, segments.ErrEmptyBatch),
+		errors.Is(err, segments.ErrBatchTooLarge),
+		errors.Is(err, segments.ErrUnknownBatchMode),
+		errors.Is(err, segments.ErrUnknownBatchOperation),
+		errors.Is(err, segments.ErrDuplicateManifestName),
+		errors.Is(err, segments.ErrAmbiguousSegmentName),
+		errors.Is(err, segments.ErrHistoryNotRecorded):
+		status = http.StatusBadRequest
+	case errors.Is(err, segment.ErrInvalidTransition),
+		errors.Is(err, segment.ErrSegmentArchived),
+		errors.Is(err, segment.ErrSegmentReferenced),
+		errors.Is(err, segment.ErrCompositeMembers),
+		errors.Is(err, segment.ErrCompositeEstimate),
+		errors.Is(err, segment.ErrVersionConflict):
+		status = http.StatusConflict
+	case errors.Is(err, segment.ErrUnavailable):
+		status = http.StatusServiceUnavailable
+	}
+
+	http.Error(w, err.Error(), status)
+}
+
+// ToSegmentResponse converts a domain segment to its API representation.
+// Live reports whether the segment matches at now.
+func ToSegmentResponse(s *segment.Segment, now time.Time) SegmentResponse {
 	return SegmentResponse{
-		ID:         s.ID(),
-		Name:       s.Name(),
-		TTLSeconds: s.TTLSeconds(),
-		CreatedAt:  s.CreatedAt().Format("2006-01-02T15:04:05Z07:00"),
-		UpdatedAt:  s.UpdatedAt().Format("2006-01-02T15:04:05Z07:00"),
+		ID:          s.ID(),
+		Name:        s.Name(),
+		Description: s.Description(),
+		Labels:      s.Labels(),
+		TTLSeconds:  s.TTLSeconds(),
+		ActiveFrom:  formatTime(s.ActiveFrom()),
+		ActiveUntil: formatTime(s.ActiveUntil()),
+		Expression:  formatExpression(s.Expression()),
+		MemberCount: memberCount(s),
+		State:       string(s.State()),
+		Live:        s.IsMatchable(now),
+		CreatedAt:   s.CreatedAt().Format("2006-01-02T15:04:05Z07:00"),
+		UpdatedAt:   s.UpdatedAt().Format("2006-01-02T15:04:05Z07:00"),
+	}
+}
+
+func toSegmentStatsResponse(stats *segments.SegmentStats) SegmentStatsResponse {
+	history := make([]SizeSnapshotResponse, 0, len(stats.History))
+	for _, snapshot := range stats.History {
+		history = append(history, SizeSnapshotResponse{
+			Date:        snapshot.Day.Format(time.DateOnly),
+			MemberCount: snapshot.MemberCount,
+		})
+	}
+
+	return SegmentStatsResponse{
+		SegmentID:   stats.Segment.ID(),
+		MemberCount: memberCount(stats.Segment),
+		From:        stats.From.Format(time.DateOnly),
+		To:          stats.To.Format(time.DateOnly),
+		History:     history,
+	}
+}
+
+func toSegmentEstimateResponse(estimate segments.SegmentEstimate) SegmentEstimateResponse {
+	return SegmentEstimateResponse{
+		SegmentID:      estimate.SegmentID,
+		EstimatedCount: estimate.EstimatedCount,
+	}
+}
+
+func toOverlapEstimateResponse(estimate *segments.OverlapEstimate) OverlapEstimateResponse {
+	ids := make([]int, 0, len(estimate.Segments))
+	estimates := make([]SegmentEstimateResponse, 0, len(estimate.Segments))
+	for _, e := range estimate.Segments {
+		ids = append(ids, e.SegmentID)
+		estimates = append(estimates, toSegmentEstimateResponse(e))
+	}
+
+	return OverlapEstimateResponse{
+		SegmentIDs:   ids,
+		Segments:     estimates,
+		Union:        estimate.Union,
+		Intersection: estimate.Intersection,
+		Jaccard:      estimate.Jaccard,
+	}
+}
+
+func toSegmentVersionResponse(revision segment.Revision) SegmentVersionResponse {
+	s := revision.Segment
+	return SegmentVersionResponse{
+		Version:     revision.Version,
+		Name:        s.Name(),
+		Description: s.Description(),
+		Labels:      s.Labels(),
+		TTLSeconds:  s.TTLSeconds(),
+		ActiveFrom:  formatTime(s.ActiveFrom()),
+		ActiveUntil: formatTime(s.ActiveUntil()),
+		Expression:  formatExpression(s.Expression()),
+		State:       string(s.State()),
+		SavedAt:     s.UpdatedAt().Format("2006-01-02T15:04:05Z07:00"),
+	}
+}
+
+func toSegmentVersionDiffResponse(segmentID int, diff *segments.SegmentVersionDiff) SegmentVersionDiffResponse {
+	changes := make([]FieldChangeResponse, 0, len(diff.Changes))
+	for _, c := range diff.Changes {
+		changes = append(changes, FieldChangeResponse{
+			Field: c.Field,
+			From:  formatChangeValue(c.From),
+			To:    formatChangeValue(c.To),
+		})
+	}
+
+	return SegmentVersionDiffResponse{
+		SegmentID: segmentID,
+		From:      diff.From,
+		To:        diff.To,
+		Changes:   changes,
+	}
+}
+
+// formatChangeValue formats times like the segment responses do.
+func formatChangeValue(v any) any {
+	if t, ok := v.(time.Time); ok {
+		return formatTime(&t)
+	}
+	return v
+}
+
+// memberCount returns the member count of a regular segment, or nil for a
+// composite segment, whose members are not counted.
+func memberCount(s *segment.Segment) *int {
+	if s.IsComposite() {
+		return nil
+	}
+	count := s.MemberCount()
+	return &count
+}
+
+func formatExpression(e *segment.Expression) string {
+	if e == nil {
+		return ""
+	}
+	return e.String()
+}
+
+func formatTime(t *time.Time) *string {
+	if t == nil {
+		return nil
+	}
+	formatted := t.Format("2006-01-02T15:04:05Z07:00")
+	return &formatted
+}
+
+func toBatchSegmentsResponse(result *segments.BatchSegmentsResult, now time.Time) BatchSegmentsResponse {
+	items := make([]BatchOperationResponse, 0, len(result.Results))
+	for i, res := range result.Results {
+		item := BatchOperationResponse{
+			Index:  i,
+			Op:     string(res.Type),
+			Status: batchStatus(res.Err),
+		}
+		if res.ID != 0 {
+			id := res.ID
+			item.ID = &id
+		}
+		if res.Segment != nil {
+			seg := ToSegmentResponse(res.Segment, now)
+			item.Segment = &seg
+		}
+		if res.Err != nil {
+			item.Error = res.Err.Error()
+		}
+		items = append(items, item)
+	}
+
+	return BatchSegmentsResponse{
+		Mode:      string(result.Mode),
+		Succeeded: result.Succeeded(),
+		Failed:    result.Failed(),
+		Results:   items,
+	}
+}
+
+func batchStatus(err error) string {
+	switch {
+	case err == nil:
+		return "succeeded"
+	case errors.Is(err, segments.ErrBatchRolledBack):
+		return "rolled_back"
+	case errors.Is(err, segments.ErrBatchSkipped):
+		return "skipped"
+	default:
+		return "failed"
+	}
+}
+
+// ToApplySegmentsResponse converts an apply result to its API representation.
+func ToApplySegmentsResponse(result *segments.ApplySegmentsResult, now time.Time) ApplySegmentsResponse {
+	changes := make([]PlannedChangeItem, 0, len(result.Changes))
+	for _, c := range result.Changes {
+		item := PlannedChangeItem{
+			Action: string(c.Action),
+			Name:   c.Name,
+		}
+		if c.Action != segments.ApplyDelete {
+			item.Desired = &ManifestSegment{
+				Name:        c.Desired.Name,
+				Description: c.Desired.Description,
+				Labels:      c.Desired.Labels,
+				TTLSeconds:  c.Desired.TTLSeconds,
+				ActiveFrom:  c.Desired.ActiveFrom,
+				ActiveUntil: c.Desired.ActiveUntil,
+				Expression:  c.Desired.Expression,
+			}
+		}
+		if c.Current != nil {
+			current := ToSegmentResponse(c.Current, now)
+			item.Current = &current
+		}
+		if c.Segment != nil {
+			seg := ToSegmentResponse(c.Segment, now)
+			item.Segment = &seg
+		}
+		changes = append(changes, item)
+	}
+
+	return ApplySegmentsResponse{
+		DryRun: result.DryRun,
+		Summary: ApplySummary{
+			Create:    result.Count(segments.ApplyCreate),
+			Update:    result.Count(segments.ApplyUpdate),
+			Delete:    result.Count(segments.ApplyDelete),
+			Unchanged: result.Unchanged,
+			Unmanaged: result.Unmanaged,
+		},
+		Changes: changes,
+	}
+}
+
+// mediaType returns the request media type without parameters.
+func mediaType(r *http.Request) string {
+	mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return ""
 	}
+	return mt
 }
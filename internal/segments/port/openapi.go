@@ -0,0 +1,54 @@
+package port
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rickKoch/nexus/api/openapi"
+	"github.com/rickKoch/nexus/internal/segments/app"
+	"github.com/rickKoch/nexus/pkg/server"
+)
+
+// NewHandler builds the REST API handler serving the OpenAPI document and the
+// segment routes, with requests validated against the spec.
+func NewHandler(application app.Application, opts server.OpenAPIValidatorOptions) (http.Handler, error) {
+	doc, err := openapi.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	validator, err := server.OpenAPIValidator(doc, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	router := chi.NewRouter()
+	router.Use(validator)
+	router.Get("/openapi.json", GetOpenAPISpec)
+	router.Get("/readyz", GetReadiness(application.Ready))
+
+	return HandlerFromMux(NewHttpServer(application), router), nil
+}
+
+// GetOpenAPISpec handles GET /openapi.json
+func GetOpenAPISpec(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(openapi.Spec)
+}
+
+// GetReadiness handles GET /readyz, failing with 503 Service Unavailable while
+// ready returns an error. A nil ready is always ready.
+func GetReadiness(ready func() error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if ready != nil {
+			if err := ready(); err != nil {
+				http.Error(w, err.Error(), http.StatusServiceUnavailable)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = io.WriteString(w, "ok\n")
+	}
+}
@@ -0,0 +1,461 @@
+package port_test
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/rickKoch/nexus/api/openapi"
+	"github.com/rickKoch/nexus/internal/segments/adapters"
+	"github.com/rickKoch/nexus/internal/segments/app"
+	"github.com/rickKoch/nexus/internal/segments/app/segments"
+	"github.com/rickKoch/nexus/internal/segments/port"
+	"github.com/rickKoch/nexus/pkg/clock"
+	"github.com/rickKoch/nexus/pkg/server"
+)
+
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	return newTestServerWithClock(t, clock.System)
+}
+
+func newTestServerWithClock(t *testing.T, clk clock.Clock) *httptest.Server {
+	t.Helper()
+
+	repo := adapters.NewInMemorySegmentRepository()
+	seg, err := app.NewSegments(repo, segments.DefaultPagination(), clk, repo)
+	if err != nil {
+		t.Fatalf("failed to create application: %v", err)
+	}
+
+	// Responses are validated too, so any drift between the handlers and
+	// the spec surfaces as a 500.
+	handler, err := port.NewHandler(app.Application{Segments: seg}, server.OpenAPIValidatorOptions{ValidateResponses: true})
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+
+	root := chi.NewRouter()
+	root.Mount("/api", handler)
+
+	srv := httptest.NewServer(root)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func doRequest(t *testing.T, srv *httptest.Server, method, path, body string) (int, string) {
+	t.Helper()
+
+	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("failed to build request: %v", err)
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+
+	return resp.StatusCode, string(data)
+}
+
+func TestHandlersMatchOpenAPISpec(t *testing.T) {
+	srv := newTestServer(t)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"serves spec", http.MethodGet, "/api/openapi.json", "", http.StatusOK},
+		{"creates segment", http.MethodPost, "/api/segment", `{"name": "premium-users", "ttl_seconds": 3600}`, http.StatusCreated},
+		{"creates segment without TTL", http.MethodPost, "/api/segment", `{"name": "all-users"}`, http.StatusCreated},
+		{"creates segment with labels", http.MethodPost, "/api/segment", `{"name": "growth", "description": "Growth team", "labels": {"team": "growth"}}`, http.StatusCreated},
+		{"rejects invalid label key", http.MethodPost, "/api/segment", `{"name": "x", "labels": {"Team": "growth"}}`, http.StatusBadRequest},
+		{"rejects empty name", http.MethodPost, "/api/segment", `{"name": ""}`, http.StatusBadRequest},
+		{"rejects missing body", http.MethodPost, "/api/segment", "", http.StatusBadRequest},
+		{"rejects invalid TTL", http.MethodPost, "/api/segment", `{"name": "x", "ttl_seconds": 0}`, http.StatusBadRequest},
+		{"lists segments", http.MethodGet, "/api/segment", "", http.StatusOK},
+		{"lists segments page", http.MethodGet, "/api/segment?page=2&page_size=1", "", http.StatusOK},
+		{"rejects malformed page", http.MethodGet, "/api/segment?page=abc", "", http.StatusBadRequest},
+		{"lists segments by label", http.MethodGet, "/api/segment?label=team:growth&label=tier:gold", "", http.StatusOK},
+		{"rejects malformed label selector", http.MethodGet, "/api/segment?label=team", "", http.StatusBadRequest},
+		{"lists segments by state", http.MethodGet, "/api/segment?state=draft&state=active", "", http.StatusOK},
+		{"rejects unknown state filter", http.MethodGet, "/api/segment?state=deleted", "", http.StatusBadRequest},
+		{"lists live segments", http.MethodGet, "/api/segment?live=true", "", http.StatusOK},
+		{"rejects malformed live filter", http.MethodGet, "/api/segment?live=maybe", "", http.StatusBadRequest},
+		{"creates active segment", http.MethodPost, "/api/segment", `{"name": "live", "state": "active"}`, http.StatusCreated},
+		{"creates segment with activation window", http.MethodPost, "/api/segment", `{"name": "campaign", "active_from": "2026-11-01T00:00:00Z", "active_until": "2026-12-01T00:00:00Z"}`, http.StatusCreated},
+		{"rejects inverted activation window", http.MethodPost, "/api/segment", `{"name": "x", "active_from": "2026-12-01T00:00:00Z", "active_until": "2026-11-01T00:00:00Z"}`, http.StatusBadRequest},
+		{"rejects malformed activation window", http.MethodPost, "/api/segment", `{"name": "x", "active_from": "tomorrow"}`, http.StatusBadRequest},
+		{"rejects paused initial state", http.MethodPost, "/api/segment", `{"name": "x", "state": "paused"}`, http.StatusBadRequest},
+		{"creates composite segment", http.MethodPost, "/api/segment", `{"name": "all-but-growth", "expression": "2 AND NOT 3"}`, http.StatusCreated},
+		{"rejects malformed expression", http.MethodPost, "/api/segment", `{"name": "x", "expression": "2 AND"}`, http.StatusBadRequest},
+		{"rejects expression with unknown segment", http.MethodPost, "/api/segment", `{"name": "x", "expression": "2 OR 999"}`, http.StatusBadRequest},
+		{"rejects deleting referenced segment", http.MethodDelete, "/api/segment/2", "", http.StatusConflict},
+		{"adds members", http.MethodPost, "/api/segment/2/members:add", `{"members": ["user-1", "user-2"]}`, http.StatusNoContent},
+		{"rejects empty members", http.MethodPost, "/api/segment/2/members:add", `{"members": []}`, http.StatusBadRequest},
+		{"rejects adding members to composite segment", http.MethodPost, "/api/segment/6/members:add", `{"members": ["user-1"]}`, http.StatusConflict},
+		{"adds members to missing segment", http.MethodPost, "/api/segment/999/members:add", `{"members": ["user-1"]}`, http.StatusNotFound},
+		{"exports members", http.MethodGet, "/api/segment/2/members:export", "", http.StatusOK},
+		{"exports composite members as NDJSON", http.MethodGet, "/api/segment/6/members:export?format=ndjson&after=user-1", "", http.StatusOK},
+		{"rejects unknown export format", http.MethodGet, "/api/segment/2/members:export?format=xml", "", http.StatusBadRequest},
+		{"exports missing segment", http.MethodGet, "/api/segment/999/members:export", "", http.StatusNotFound},
+		{"checks membership", http.MethodGet, "/api/segment/2/members/user-1", "", http.StatusOK},
+		{"checks composite membership", http.MethodGet, "/api/segment/6/members/user-2", "", http.StatusOK},
+		{"checks membership of missing segment", http.MethodGet, "/api/segment/999/members/user-1", "", http.StatusNotFound},
+		{"removes members", http.MethodPost, "/api/segment/2/members:remove", `{"members": ["user-2", "user-3"]}`, http.StatusNoContent},
+		{"gets segment stats", http.MethodGet, "/api/segment/2/stats", "", http.StatusOK},
+		{"gets segment stats in range", http.MethodGet, "/api/segment/2/stats?from=2026-10-01&to=2026-10-31", "", http.StatusOK},
+		{"gets composite segment stats", http.MethodGet, "/api/segment/6/stats", "", http.StatusOK},
+		{"rejects inverted stats range", http.MethodGet, "/api/segment/2/stats?from=2026-10-31&to=2026-10-01", "", http.StatusBadRequest},
+		{"rejects malformed stats date", http.MethodGet, "/api/segment/2/stats?from=yesterday", "", http.StatusBadRequest},
+		{"gets missing segment stats", http.MethodGet, "/api/segment/999/stats", "", http.StatusNotFound},
+		{"estimates segment size", http.MethodGet, "/api/segment/2/estimate", "", http.StatusOK},
+		{"rejects estimating composite segment", http.MethodGet, "/api/segment/6/estimate", "", http.StatusConflict},
+		{"estimates missing segment", http.MethodGet, "/api/segment/999/estimate", "", http.StatusNotFound},
+		{"estimates segment overlap", http.MethodGet, "/api/segment:overlap?id=2&id=3", "", http.StatusOK},
+		{"rejects overlap of one segment", http.MethodGet, "/api/segment:overlap?id=2&id=2", "", http.StatusBadRequest},
+		{"rejects malformed overlap id", http.MethodGet, "/api/segment:overlap?id=2&id=x", "", http.StatusBadRequest},
+		{"estimates overlap with missing segment", http.MethodGet, "/api/segment:overlap?id=2&id=999", "", http.StatusNotFound},
+		{"gets segment", http.MethodGet, "/api/segment/1", "", http.StatusOK},
+		{"rejects malformed id", http.MethodGet, "/api/segment/abc", "", http.StatusBadRequest},
+		{"gets missing segment", http.MethodGet, "/api/segment/999", "", http.StatusNotFound},
+		{"rejects past segment without history", http.MethodGet, "/api/segment/1?as_of=2026-11-01T12:00:00Z", "", http.StatusBadRequest},
+		{"rejects malformed as_of", http.MethodGet, "/api/segment/1?as_of=yesterday", "", http.StatusBadRequest},
+		{"updates segment", http.MethodPut, "/api/segment/1", `{"name": "vip-users"}`, http.StatusOK},
+		{"updates missing segment", http.MethodPut, "/api/segment/999", `{"name": "vip-users"}`, http.StatusNotFound},
+		{"lists segment versions", http.MethodGet, "/api/segment/1/versions", "", http.StatusOK},
+		{"lists versions of missing segment", http.MethodGet, "/api/segment/999/versions", "", http.StatusNotFound},
+		{"gets segment version", http.MethodGet, "/api/segment/1/versions/1", "", http.StatusOK},
+		{"gets missing segment version", http.MethodGet, "/api/segment/1/versions/99", "", http.StatusNotFound},
+		{"rejects malformed version", http.MethodGet, "/api/segment/1/versions/abc", "", http.StatusBadRequest},
+		{"diffs segment version with latest", http.MethodGet, "/api/segment/1/versions:diff?from=1", "", http.StatusOK},
+		{"diffs segment versions", http.MethodGet, "/api/segment/1/versions:diff?from=2&to=1", "", http.StatusOK},
+		{"rejects diff without from", http.MethodGet, "/api/segment/1/versions:diff?to=1", "", http.StatusBadRequest},
+		{"diffs missing segment version", http.MethodGet, "/api/segment/1/versions:diff?from=1&to=99", "", http.StatusNotFound},
+		{"rolls back segment", http.MethodPost, "/api/segment/1/versions/1:rollback", "", http.StatusOK},
+		{"rolls back to missing version", http.MethodPost, "/api/segment/1/versions/99:rollback", "", http.StatusNotFound},
+		{"runs atomic batch", http.MethodPost, "/api/segment:batch", `{"operations": [{"op": "create", "name": "batched"}, {"op": "update", "id": 1, "name": "vip-users"}]}`, http.StatusOK},
+		{"reports failed batch", http.MethodPost, "/api/segment:batch", `{"mode": "atomic", "operations": [{"op": "create", "name": "batched"}, {"op": "delete", "id": 999}]}`, http.StatusOK},
+		{"runs best-effort batch", http.MethodPost, "/api/segment:batch", `{"mode": "best_effort", "operations": [{"op": "create", "name": ""}, {"op": "create", "name": "ok"}]}`, http.StatusOK},
+		{"rejects empty batch", http.MethodPost, "/api/segment:batch", `{"operations": []}`, http.StatusBadRequest},
+		{"rejects unknown batch operation", http.MethodPost, "/api/segment:batch", `{"operations": [{"op": "upsert"}]}`, http.StatusBadRequest},
+		{"plans apply", http.MethodPost, "/api/segment:apply", `{"segments": [{"name": "vip-users"}, {"name": "planned"}], "prune": true, "dry_run": true}`, http.StatusOK},
+		{"applies manifest", http.MethodPost, "/api/segment:apply", `{"segments": [{"name": "vip-users", "ttl_seconds": 60}, {"name": "applied"}]}`, http.StatusOK},
+		{"rejects duplicate manifest names", http.MethodPost, "/api/segment:apply", `{"segments": [{"name": "x"}, {"name": "x"}]}`, http.StatusBadRequest},
+		{"rejects manifest without segments", http.MethodPost, "/api/segment:apply", `{"prune": true}`, http.StatusBadRequest},
+		{"rejects pausing draft segment", http.MethodPost, "/api/segment/1:pause", "", http.StatusConflict},
+		{"activates segment", http.MethodPost, "/api/segment/1:activate", "", http.StatusOK},
+		{"pauses segment", http.MethodPost, "/api/segment/1:pause", "", http.StatusOK},
+		{"archives segment", http.MethodPost, "/api/segment/1:archive", "", http.StatusOK},
+		{"rejects updating archived segment", http.MethodPut, "/api/segment/1", `{"name": "vip-users"}`, http.StatusConflict},
+		{"rejects adding members to archived segment", http.MethodPost, "/api/segment/1/members:add", `{"members": ["user-1"]}`, http.StatusConflict},
+		{"activates missing segment", http.MethodPost, "/api/segment/999:activate", "", http.StatusNotFound},
+		{"rejects deleting archived segment", http.MethodDelete, "/api/segment/1", "", http.StatusConflict},
+		{"deletes segment", http.MethodDelete, "/api/segment/6", "", http.StatusNoContent},
+		{"deletes missing segment", http.MethodDelete, "/api/segment/6", "", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := doRequest(t, srv, tt.method, tt.path, tt.body)
+			if status != tt.status {
+				t.Errorf("expected status %d, got %d: %s", tt.status, status, body)
+			}
+		})
+	}
+}
+
+func TestListSegmentsResponseShape(t *testing.T) {
+	srv := newTestServer(t)
+
+	doRequest(t, srv, http.MethodPost, "/api/segment", `{"name": "premium-users"}`)
+
+	status, body := doRequest(t, srv, http.MethodGet, "/api/segment", "")
+	if status != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", status, body)
+	}
+
+	var resp port.ListSegmentsResponse
+	if err := json.Unmarshal([]byte(body), &resp); err != nil {
+		t.Fatalf("expected ListSegmentsResponse, got %s", body)
+	}
+	if len(resp.Items) != 1 || resp.TotalCount != 1 || resp.TotalPages != 1 {
+		t.Errorf("unexpected response %+v", resp)
+	}
+}
+
+func TestSegmentVersionsResponseShape(t *testing.T) {
+	srv := newTestServer(t)
+
+	doRequest(t, srv, http.MethodPost, "/api/segment", `{"name": "campaign", "ttl_seconds": 60}`)
+	doRequest(t, srv, http.MethodPut, "/api/segment/1", `{"name": "campaign", "active_from": "2026-11-01T00:00:00Z"}`)
+
+	status, body := doRequest(t, srv, http.MethodGet, "/api/segment/1/versions", "")
+	if status != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", status, body)
+	}
+	var versions port.ListSegmentVersionsResponse
+	if err := json.Unmarshal([]byte(body), &versions); err != nil {
+		t.Fatalf("expected ListSegmentVersionsResponse, got %s", body)
+	}
+	if len(versions.Items) != 2 || versions.Items[0].Version != 1 || versions.Items[1].ActiveFrom == nil {
+		t.Errorf("unexpected response %+v", versions)
+	}
+
+	status, body = doRequest(t, srv, http.MethodGet, "/api/segment/1/versions:diff?from=1", "")
+	if status != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", status, body)
+	}
+	want := `{"segment_id":1,"from":1,"to":2,"changes":[{"field":"ttl_seconds","from":60,"to":null},{"field":"active_from","from":null,"to":"2026-11-01T00:00:00Z"}]}`
+	if got := strings.TrimSpace(body); got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+}
+
+func TestListSegmentsByLabel(t *testing.T) {
+	srv := newTestServer(t)
+
+	doRequest(t, srv, http.MethodPost, "/api/segment", `{"name": "growth", "labels": {"team": "growth"}}`)
+	doRequest(t, srv, http.MethodPost, "/api/segment", `{"name": "core", "labels": {"team": "core"}}`)
+
+	status, body := doRequest(t, srv, http.MethodGet, "/api/segment?label=team:growth", "")
+	if status != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", status, body)
+	}
+
+	var resp port.ListSegmentsResponse
+	if err := json.Unmarshal([]byte(body), &resp); err != nil {
+		t.Fatalf("expected ListSegmentsResponse, got %s", body)
+	}
+	if len(resp.Items) != 1 || resp.Items[0].Name != "growth" || resp.Items[0].Labels["team"] != "growth" {
+		t.Errorf("unexpected response %+v", resp)
+	}
+}
+
+func TestSegmentLiveFollowsClock(t *testing.T) {
+	start := time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)
+	clk := clock.NewFake(start.Add(-time.Minute))
+	srv := newTestServerWithClock(t, clk)
+
+	status, body := doRequest(t, srv, http.MethodPost, "/api/segment",
+		`{"name": "campaign", "state": "active", "active_from": "2026-11-01T00:00:00Z", "active_until": "2026-11-02T00:00:00Z"}`)
+	if status != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", status, body)
+	}
+
+	tests := []struct {
+		at       time.Time
+		expected bool
+	}{
+		{start.Add(-time.Minute), false},
+		{start, true},
+		{start.Add(24 * time.Hour), false},
+	}
+	for _, tt := range tests {
+		clk.Set(tt.at)
+
+		_, body := doRequest(t, srv, http.MethodGet, "/api/segment/1", "")
+		var seg port.SegmentResponse
+		if err := json.Unmarshal([]byte(body), &seg); err != nil {
+			t.Fatalf("expected SegmentResponse, got %s", body)
+		}
+		if seg.Live != tt.expected {
+			t.Errorf("expected live=%v at %s, got %v", tt.expected, tt.at, seg.Live)
+		}
+		if seg.ActiveFrom == nil || *seg.ActiveFrom != "2026-11-01T00:00:00Z" {
+			t.Errorf("expected active_from to round-trip, got %v", seg.ActiveFrom)
+		}
+	}
+}
+
+func TestApplySegmentsAcceptsYAML(t *testing.T) {
+	srv := newTestServer(t)
+
+	manifest := "segments:\n  - name: premium-users\n    ttl_seconds: 3600\n  - name: all-users\n"
+	req, err := http.NewRequest(http.MethodPost, srv.URL+"/api/segment:apply", strings.NewReader(manifest))
+	if err != nil {
+		t.Fatalf("failed to build request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/yaml")
+
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("expected status 200, got %d: %s", resp.StatusCode, body)
+	}
+
+	var result port.ApplySegmentsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if result.Summary.Create != 2 {
+		t.Errorf("expected 2 creates, got %+v", result.Summary)
+	}
+}
+
+func TestRoutesAreDocumented(t *testing.T) {
+	doc, err := openapi.Load()
+	if err != nil {
+		t.Fatalf("failed to load spec: %v", err)
+	}
+
+	repo := adapters.NewInMemorySegmentRepository()
+	seg, _ := app.NewSegments(repo, segments.DefaultPagination(), clock.System, repo)
+	handler, err := port.NewHandler(app.Application{Segments: seg}, server.OpenAPIValidatorOptions{})
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+
+	routes := make(map[string]bool)
+	err = chi.Walk(handler.(chi.Routes), func(method, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		key := method + " " + route
+		routes[key] = true
+
+		item := doc.Paths.Find(route)
+		if item == nil || item.GetOperation(method) == nil {
+			t.Errorf("route %s is not documented in the OpenAPI spec", key)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to walk routes: %v", err)
+	}
+
+	for path, item := range doc.Paths.Map() {
+		for method := range item.Operations() {
+			if !routes[method+" "+path] {
+				t.Errorf("operation %s %s is documented but not routed", method, path)
+			}
+		}
+	}
+}
+
+func TestExportMembers(t *testing.T) {
+	srv := newTestServer(t)
+
+	doRequest(t, srv, http.MethodPost, "/api/segment", `{"name": "premium-users", "state": "active"}`)
+	status, body := doRequest(t, srv, http.MethodPost, "/api/segment/1/members:add", `{"members": ["user-3", "user-1", "user-2"]}`)
+	if status != http.StatusNoContent {
+		t.Fatalf("expected status 204, got %d: %s", status, body)
+	}
+
+	export := func(t *testing.T, query, acceptEncoding string) *http.Response {
+		t.Helper()
+
+		req, err := http.NewRequest(http.MethodGet, srv.URL+"/api/segment/1/members:export"+query, nil)
+		if err != nil {
+			t.Fatalf("failed to build request: %v", err)
+		}
+		// Setting the header explicitly stops the client from transparently
+		// decompressing the response.
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		t.Cleanup(func() { _ = resp.Body.Close() })
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", resp.StatusCode)
+		}
+		return resp
+	}
+
+	tests := []struct {
+		name        string
+		query       string
+		contentType string
+		expected    string
+	}{
+		{"csv by default", "", "text/csv", "member_id\nuser-1\nuser-2\nuser-3\n"},
+		{"ndjson", "?format=ndjson", "application/x-ndjson", "{\"member_id\":\"user-1\"}\n{\"member_id\":\"user-2\"}\n{\"member_id\":\"user-3\"}\n"},
+		{"resumes after cursor", "?after=user-1", "text/csv", "member_id\nuser-2\nuser-3\n"},
+		{"empty after last member", "?format=ndjson&after=user-3", "application/x-ndjson", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := export(t, tt.query, "identity")
+			if ct := resp.Header.Get("Content-Type"); ct != tt.contentType {
+				t.Errorf("expected content type %s, got %s", tt.contentType, ct)
+			}
+			data, _ := io.ReadAll(resp.Body)
+			if string(data) != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, data)
+			}
+		})
+	}
+
+	t.Run("gzip", func(t *testing.T) {
+		resp := export(t, "", "br, gzip;q=0.8")
+		if enc := resp.Header.Get("Content-Encoding"); enc != "gzip" {
+			t.Fatalf("expected gzip encoding, got '%s'", enc)
+		}
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			t.Fatalf("failed to read gzip body: %v", err)
+		}
+		data, _ := io.ReadAll(gz)
+		if string(data) != "member_id\nuser-1\nuser-2\nuser-3\n" {
+			t.Errorf("unexpected body %q", data)
+		}
+	})
+
+	t.Run("gzip refused", func(t *testing.T) {
+		resp := export(t, "", "gzip;q=0")
+		if enc := resp.Header.Get("Content-Encoding"); enc != "" {
+			t.Errorf("expected no encoding, got '%s'", enc)
+		}
+	})
+}
+
+func TestReadiness(t *testing.T) {
+	var ready error
+	repo := adapters.NewInMemorySegmentRepository()
+	seg, _ := app.NewSegments(repo, segments.DefaultPagination(), clock.System, repo)
+	handler, err := port.NewHandler(app.Application{
+		Segments: seg,
+		Ready:    func() error { return ready },
+	}, server.OpenAPIValidatorOptions{ValidateResponses: true})
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	if status, body := doRequest(t, srv, http.MethodGet, "/readyz", ""); status != http.StatusOK {
+		t.Errorf("expected 200, got %d: %s", status, body)
+	}
+
+	ready = adapters.ErrCircuitOpen
+	status, body := doRequest(t, srv, http.MethodGet, "/readyz", "")
+	if status != http.StatusServiceUnavailable || !strings.Contains(body, "circuit breaker is open") {
+		t.Errorf("expected 503 with the reason, got %d: %s", status, body)
+	}
+}
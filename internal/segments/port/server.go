@@ -1,8 +1,10 @@
 package port
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 )
@@ -23,6 +25,54 @@ type ServerInterface interface {
 
 	// (DELETE /segment/:id)
 	DeleteSegment(w http.ResponseWriter, r *http.Request, params DeleteSegmentParams)
+
+	// (POST /segment:batch)
+	BatchSegments(w http.ResponseWriter, r *http.Request)
+
+	// (POST /segment:apply)
+	ApplySegments(w http.ResponseWriter, r *http.Request)
+
+	// (POST /segment/:id:activate)
+	ActivateSegment(w http.ResponseWriter, r *http.Request, params TransitionSegmentParams)
+
+	// (POST /segment/:id:pause)
+	PauseSegment(w http.ResponseWriter, r *http.Request, params TransitionSegmentParams)
+
+	// (POST /segment/:id:archive)
+	ArchiveSegment(w http.ResponseWriter, r *http.Request, params TransitionSegmentParams)
+
+	// (POST /segment/:id/members:add)
+	AddMembers(w http.ResponseWriter, r *http.Request, params MembersParams)
+
+	// (POST /segment/:id/members:remove)
+	RemoveMembers(w http.ResponseWriter, r *http.Request, params MembersParams)
+
+	// (GET /segment/:id/members:export)
+	ExportMembers(w http.ResponseWriter, r *http.Request, params ExportMembersParams)
+
+	// (GET /segment/:id/members/:member_id)
+	CheckMembership(w http.ResponseWriter, r *http.Request, params CheckMembershipParams)
+
+	// (GET /segment/:id/stats)
+	GetSegmentStats(w http.ResponseWriter, r *http.Request, params GetSegmentStatsParams)
+
+	// (GET /segment/:id/estimate)
+	EstimateSegment(w http.ResponseWriter, r *http.Request, params EstimateSegmentParams)
+
+	// (GET /segment:overlap)
+	EstimateOverlap(w http.ResponseWriter, r *http.Request, params EstimateOverlapParams)
+
+	// (GET /segment/:id/versions)
+	ListSegmentVersions(w http.ResponseWriter, r *http.Request, params ListSegmentVersionsParams)
+
+	// (GET /segment/:id/versions/:version)
+	GetSegmentVersion(w http.ResponseWriter, r *http.Request, params SegmentVersionParams)
+
+	// (GET /segment/:id/versions:diff)
+	DiffSegmentVersions(w http.ResponseWriter, r *http.Request, params DiffSegmentVersionsParams)
+
+	// (POST /segment/:id/versions/:version:rollback)
+	RollbackSegment(w http.ResponseWriter, r *http.Request, params SegmentVersionParams)
 }
 
 func HandlerFromMux(si ServerInterface, r chi.Router) http.Handler {
@@ -42,6 +92,22 @@ func HandlerFromMux(si ServerInterface, r chi.Router) http.Handler {
 		r.Get("/segment/{id}", wrapper.GetSegment)
 		r.Put("/segment/{id}", wrapper.UpdateSegment)
 		r.Delete("/segment/{id}", wrapper.DeleteSegment)
+		r.Post("/segment:batch", wrapper.BatchSegments)
+		r.Post("/segment:apply", wrapper.ApplySegments)
+		r.Post("/segment/{id}:activate", wrapper.ActivateSegment)
+		r.Post("/segment/{id}:pause", wrapper.PauseSegment)
+		r.Post("/segment/{id}:archive", wrapper.ArchiveSegment)
+		r.Post("/segment/{id}/members:add", wrapper.AddMembers)
+		r.Post("/segment/{id}/members:remove", wrapper.RemoveMembers)
+		r.Get("/segment/{id}/members:export", wrapper.ExportMembers)
+		r.Get("/segment/{id}/members/{member_id}", wrapper.CheckMembership)
+		r.Get("/segment/{id}/stats", wrapper.GetSegmentStats)
+		r.Get("/segment/{id}/estimate", wrapper.EstimateSegment)
+		r.Get("/segment:overlap", wrapper.EstimateOverlap)
+		r.Get("/segment/{id}/versions", wrapper.ListSegmentVersions)
+		r.Get("/segment/{id}/versions/{version}", wrapper.GetSegmentVersion)
+		r.Get("/segment/{id}/versions:diff", wrapper.DiffSegmentVersions)
+		r.Post("/segment/{id}/versions/{version}:rollback", wrapper.RollbackSegment)
 	})
 
 	return r
@@ -64,6 +130,16 @@ func (siw *ServerInterfaceWrapper) GetSegment(w http.ResponseWriter, r *http.Req
 
 	params := GetSegmentParams{ID: id}
 
+	// Parse as_of parameter, an RFC 3339 timestamp
+	if value := r.URL.Query().Get("as_of"); value != "" {
+		asOf, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			siw.ErrorHandlerFunc(w, r, err)
+			return
+		}
+		params.AsOf = &asOf
+	}
+
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		siw.Handler.GetSegment(w, r, params)
 	})
@@ -96,6 +172,22 @@ func (siw *ServerInterfaceWrapper) ListSegments(w http.ResponseWriter, r *http.R
 		params.PageSize = &pageSize
 	}
 
+	// Parse label selector, which may be repeated
+	params.Label = r.URL.Query()["label"]
+
+	// Parse state filter, which may be repeated
+	params.State = r.URL.Query()["state"]
+
+	// Parse live filter
+	if liveStr := r.URL.Query().Get("live"); liveStr != "" {
+		live, err := strconv.ParseBool(liveStr)
+		if err != nil {
+			siw.ErrorHandlerFunc(w, r, err)
+			return
+		}
+		params.Live = &live
+	}
+
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		siw.Handler.ListSegments(w, r, params)
 	})
@@ -151,13 +243,315 @@ func (siw *ServerInterfaceWrapper) DeleteSegment(w http.ResponseWriter, r *http.
 	handler.ServeHTTP(w, r.WithContext(ctx))
 }
 
+func (siw *ServerInterfaceWrapper) BatchSegments(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.BatchSegments(w, r)
+	})
+
+	handler.ServeHTTP(w, r.WithContext(ctx))
+}
+
+func (siw *ServerInterfaceWrapper) ApplySegments(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.ApplySegments(w, r)
+	})
+
+	handler.ServeHTTP(w, r.WithContext(ctx))
+}
+
+func (siw *ServerInterfaceWrapper) ActivateSegment(w http.ResponseWriter, r *http.Request) {
+	siw.transitionSegment(w, r, siw.Handler.ActivateSegment)
+}
+
+func (siw *ServerInterfaceWrapper) PauseSegment(w http.ResponseWriter, r *http.Request) {
+	siw.transitionSegment(w, r, siw.Handler.PauseSegment)
+}
+
+func (siw *ServerInterfaceWrapper) ArchiveSegment(w http.ResponseWriter, r *http.Request) {
+	siw.transitionSegment(w, r, siw.Handler.ArchiveSegment)
+}
+
+func (siw *ServerInterfaceWrapper) transitionSegment(w http.ResponseWriter, r *http.Request, next func(http.ResponseWriter, *http.Request, TransitionSegmentParams)) {
+	ctx := r.Context()
+
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, err)
+		return
+	}
+
+	params := TransitionSegmentParams{ID: id}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next(w, r, params)
+	})
+
+	handler.ServeHTTP(w, r.WithContext(ctx))
+}
+
+func (siw *ServerInterfaceWrapper) AddMembers(w http.ResponseWriter, r *http.Request) {
+	siw.members(w, r, siw.Handler.AddMembers)
+}
+
+func (siw *ServerInterfaceWrapper) RemoveMembers(w http.ResponseWriter, r *http.Request) {
+	siw.members(w, r, siw.Handler.RemoveMembers)
+}
+
+func (siw *ServerInterfaceWrapper) members(w http.ResponseWriter, r *http.Request, next func(http.ResponseWriter, *http.Request, MembersParams)) {
+	ctx := r.Context()
+
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, err)
+		return
+	}
+
+	params := MembersParams{ID: id}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next(w, r, params)
+	})
+
+	handler.ServeHTTP(w, r.WithContext(ctx))
+}
+
+func (siw *ServerInterfaceWrapper) ExportMembers(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, err)
+		return
+	}
+
+	params := ExportMembersParams{ID: id}
+
+	// Parse format parameter
+	if format := r.URL.Query().Get("format"); format != "" {
+		params.Format = &format
+	}
+
+	// Parse after parameter, the cursor to resume from
+	if after := r.URL.Query().Get("after"); after != "" {
+		params.After = &after
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.ExportMembers(w, r, params)
+	})
+
+	handler.ServeHTTP(w, r.WithContext(ctx))
+}
+
+func (siw *ServerInterfaceWrapper) CheckMembership(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, err)
+		return
+	}
+
+	params := CheckMembershipParams{ID: id, MemberID: chi.URLParam(r, "member_id")}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.CheckMembership(w, r, params)
+	})
+
+	handler.ServeHTTP(w, r.WithContext(ctx))
+}
+
+func (siw *ServerInterfaceWrapper) GetSegmentStats(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, err)
+		return
+	}
+
+	params := GetSegmentStatsParams{ID: id}
+
+	// Parse from and to parameters, both dates
+	for _, p := range []struct {
+		name string
+		dst  **time.Time
+	}{{"from", &params.From}, {"to", &params.To}} {
+		value := r.URL.Query().Get(p.name)
+		if value == "" {
+			continue
+		}
+		day, err := time.Parse(time.DateOnly, value)
+		if err != nil {
+			siw.ErrorHandlerFunc(w, r, err)
+			return
+		}
+		*p.dst = &day
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.GetSegmentStats(w, r, params)
+	})
+
+	handler.ServeHTTP(w, r.WithContext(ctx))
+}
+
+func (siw *ServerInterfaceWrapper) EstimateSegment(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, err)
+		return
+	}
+
+	params := EstimateSegmentParams{ID: id}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.EstimateSegment(w, r, params)
+	})
+
+	handler.ServeHTTP(w, r.WithContext(ctx))
+}
+
+func (siw *ServerInterfaceWrapper) EstimateOverlap(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var params EstimateOverlapParams
+
+	// Parse id parameters, repeated once per segment
+	for _, idStr := range r.URL.Query()["id"] {
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			siw.ErrorHandlerFunc(w, r, err)
+			return
+		}
+		params.ID = append(params.ID, id)
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.EstimateOverlap(w, r, params)
+	})
+
+	handler.ServeHTTP(w, r.WithContext(ctx))
+}
+
+func (siw *ServerInterfaceWrapper) ListSegmentVersions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, err)
+		return
+	}
+
+	params := ListSegmentVersionsParams{ID: id}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.ListSegmentVersions(w, r, params)
+	})
+
+	handler.ServeHTTP(w, r.WithContext(ctx))
+}
+
+func (siw *ServerInterfaceWrapper) GetSegmentVersion(w http.ResponseWriter, r *http.Request) {
+	siw.segmentVersion(w, r, siw.Handler.GetSegmentVersion)
+}
+
+func (siw *ServerInterfaceWrapper) RollbackSegment(w http.ResponseWriter, r *http.Request) {
+	siw.segmentVersion(w, r, siw.Handler.RollbackSegment)
+}
+
+// segmentVersion parses the segment and version path parameters shared by
+// the routes of a single version.
+func (siw *ServerInterfaceWrapper) segmentVersion(w http.ResponseWriter, r *http.Request, next func(w http.ResponseWriter, r *http.Request, params SegmentVersionParams)) {
+	ctx := r.Context()
+
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, err)
+		return
+	}
+
+	versionStr := chi.URLParam(r, "version")
+	version, err := strconv.Atoi(versionStr)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, err)
+		return
+	}
+
+	params := SegmentVersionParams{ID: id, Version: version}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next(w, r, params)
+	})
+
+	handler.ServeHTTP(w, r.WithContext(ctx))
+}
+
+func (siw *ServerInterfaceWrapper) DiffSegmentVersions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		siw.ErrorHandlerFunc(w, r, err)
+		return
+	}
+
+	params := DiffSegmentVersionsParams{ID: id}
+
+	// Parse from parameter, which is required
+	fromStr := r.URL.Query().Get("from")
+	if fromStr == "" {
+		siw.ErrorHandlerFunc(w, r, errors.New("query parameter 'from' is required"))
+		return
+	}
+	if params.From, err = strconv.Atoi(fromStr); err != nil {
+		siw.ErrorHandlerFunc(w, r, err)
+		return
+	}
+
+	// Parse to parameter
+	if toStr := r.URL.Query().Get("to"); toStr != "" {
+		to, err := strconv.Atoi(toStr)
+		if err != nil {
+			siw.ErrorHandlerFunc(w, r, err)
+			return
+		}
+		params.To = &to
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		siw.Handler.DiffSegmentVersions(w, r, params)
+	})
+
+	handler.ServeHTTP(w, r.WithContext(ctx))
+}
+
 type GetSegmentParams struct {
-	ID int `json:"id"`
+	ID   int        `json:"id"`
+	AsOf *time.Time `json:"as_of,omitempty"`
 }
 
 type ListSegmentsParams struct {
-	Page     *int `json:"page,omitempty"`
-	PageSize *int `json:"page_size,omitempty"`
+	Page     *int     `json:"page,omitempty"`
+	PageSize *int     `json:"page_size,omitempty"`
+	Label    []string `json:"label,omitempty"`
+	State    []string `json:"state,omitempty"`
+	Live     *bool    `json:"live,omitempty"`
 }
 
 type UpdateSegmentParams struct {
@@ -167,3 +561,51 @@ type UpdateSegmentParams struct {
 type DeleteSegmentParams struct {
 	ID int `json:"id"`
 }
+
+type TransitionSegmentParams struct {
+	ID int `json:"id"`
+}
+
+type MembersParams struct {
+	ID int `json:"id"`
+}
+
+type ExportMembersParams struct {
+	ID     int     `json:"id"`
+	Format *string `json:"format,omitempty"`
+	After  *string `json:"after,omitempty"`
+}
+
+type CheckMembershipParams struct {
+	ID       int    `json:"id"`
+	MemberID string `json:"member_id"`
+}
+
+type GetSegmentStatsParams struct {
+	ID   int        `json:"id"`
+	From *time.Time `json:"from,omitempty"`
+	To   *time.Time `json:"to,omitempty"`
+}
+
+type EstimateSegmentParams struct {
+	ID int `json:"id"`
+}
+
+type EstimateOverlapParams struct {
+	ID []int `json:"id,omitempty"`
+}
+
+type ListSegmentVersionsParams struct {
+	ID int `json:"id"`
+}
+
+type SegmentVersionParams struct {
+	ID      int `json:"id"`
+	Version int `json:"version"`
+}
+
+type DiffSegmentVersionsParams struct {
+	ID   int  `json:"id"`
+	From int  `json:"from"`
+	To   *int `json:"to,omitempty"`
+}
@@ -2,25 +2,242 @@ package service
 
 import (
 	"context"
+	"errors"
+	"fmt"
 
+	"github.com/jmoiron/sqlx"
 	"github.com/rickKoch/nexus/internal/segments/adapters"
 	"github.com/rickKoch/nexus/internal/segments/app"
+	"github.com/rickKoch/nexus/internal/segments/app/segments"
+	"github.com/rickKoch/nexus/internal/segments/domain/segment"
+	"github.com/rickKoch/nexus/pkg/cache"
+	"github.com/rickKoch/nexus/pkg/clock"
+	"github.com/rickKoch/nexus/pkg/config"
+	"github.com/rickKoch/nexus/pkg/resp"
+	"github.com/sirupsen/logrus"
 )
 
-func NewApplication(ctx context.Context) (a app.Application, err error) {
-	db, err := adapters.NewPostgreSQLConnection(adapters.DefaultPostgreSQLConfig())
-	if err != nil {
-		return a, err
+// storageRepository is a repository of the configured storage driver.
+type storageRepository interface {
+	segment.Repository
+	segment.IDGenerator
+}
+
+// DatabaseRepository is a repository of a database storage driver, which can
+// store the history of segments too.
+type DatabaseRepository interface {
+	segment.Repository
+	segment.IDGenerator
+	segment.EventStore
+}
+
+func NewApplication(ctx context.Context, cfg config.Config) (a app.Application, err error) {
+	var baseRepo storageRepository
+	var runJournal func(ctx context.Context, onError func(err error))
+	var ready func() error
+	var maintainPartitions func(ctx context.Context, onError func(err error))
+	var history segment.HistoryReader
+	if cfg.Storage.Driver == config.StorageDriverMemory {
+		repo, err := newInMemoryRepository(cfg.Memory)
+		if err != nil {
+			return a, err
+		}
+		baseRepo = repo
+		if cfg.Memory.Durable() {
+			runJournal = repo.RunJournal
+		}
+	} else {
+		repo, db, err := newDatabaseRepository(ctx, cfg)
+		if err != nil {
+			return a, err
+		}
+		if cfg.Storage.Driver == config.StorageDriverPostgres && cfg.Postgres.PartitionInterval > 0 {
+			maintainer, err := adapters.NewPostgreSQLPartitionMaintainer(db, clock.System, adapters.PartitionConfig{
+				Interval:   cfg.Postgres.PartitionInterval,
+				Ahead:      cfg.Postgres.PartitionsAhead,
+				Retention:  cfg.Postgres.HistoryRetentionMonths,
+				ArchiveDir: cfg.Postgres.ArchiveDir,
+			})
+			if err != nil {
+				return a, err
+			}
+			maintainPartitions = maintainer.Run
+		}
+		projection, eventHistory := WithEventSourcing(cfg, repo)
+		history = eventHistory
+		resilient := adapters.NewResilientSegmentRepository(projection, repo, clock.System, adapters.ResilienceConfig{
+			MaxAttempts:      cfg.Resilience.RetryAttempts,
+			Backoff:          cfg.Resilience.RetryBackoff,
+			MaxBackoff:       cfg.Resilience.RetryMaxBackoff,
+			BreakerThreshold: cfg.Resilience.BreakerThreshold,
+			BreakerTimeout:   cfg.Resilience.BreakerTimeout,
+		})
+		baseRepo, ready = resilient, resilient.Ready
+	}
+
+	var segmentRepo segment.Repository = baseRepo
+	var syncMembershipStore func(ctx context.Context, onError func(err error))
+	if cfg.Segments.MembershipStore == config.MembershipStoreRedis {
+		client := resp.NewClient(resp.Config{
+			Addr:        cfg.Redis.Addr,
+			Password:    cfg.Redis.Password,
+			DB:          cfg.Redis.DB,
+			PoolSize:    cfg.Redis.PoolSize,
+			DialTimeout: cfg.Redis.DialTimeout,
+		})
+		store := adapters.NewRedisMembershipRepository(baseRepo, client, cfg.Redis.KeyPrefix)
+		if err := store.Sync(ctx); err != nil {
+			return a, fmt.Errorf("failed to sync membership store: %w", err)
+		}
+		if interval := cfg.Redis.ResyncInterval; interval > 0 {
+			syncMembershipStore = func(ctx context.Context, onError func(err error)) {
+				store.Run(ctx, interval, onError)
+			}
+		}
+		segmentRepo = store
 	}
 
-	segmentRepo := adapters.NewPostgreSQLSegmentRepository(db)
+	var cacheStats func() cache.Stats
+	if cfg.Segments.CacheSize > 0 {
+		cached := adapters.NewCachedSegmentRepository(segmentRepo, clock.System, adapters.CacheConfig{
+			Size: cfg.Segments.CacheSize,
+			TTL:  cfg.Segments.CacheTTL,
+		})
+		segmentRepo = cached
+		cacheStats = cached.Stats
+	}
 
-	seg, err := app.NewSegments(segmentRepo)
+	seg, err := app.NewSegments(segmentRepo, segments.Pagination{
+		DefaultPageSize: cfg.Segments.DefaultPageSize,
+		MaxPageSize:     cfg.Segments.MaxPageSize,
+	}, clock.System, baseRepo)
 	if err != nil {
 		return a, err
 	}
+	if history != nil {
+		seg.GetSegment, err = segments.NewGetSegmentHandlerWithHistory(segmentRepo, history)
+		if err != nil {
+			return a, err
+		}
+	}
+
+	var scheduler *segments.WindowScheduler
+	if cfg.Segments.SchedulerInterval > 0 {
+		publisher := adapters.NewLogWindowEventPublisher(logrus.StandardLogger())
+		scheduler, err = segments.NewWindowScheduler(segmentRepo, publisher, seg.Clock, cfg.Segments.SchedulerInterval)
+		if err != nil {
+			return a, err
+		}
+	}
+
+	var recorder *segments.SizeHistoryRecorder
+	if cfg.Segments.SizeHistoryInterval > 0 {
+		recorder, err = segments.NewSizeHistoryRecorder(segmentRepo, seg.Clock, cfg.Segments.SizeHistoryInterval)
+		if err != nil {
+			return a, err
+		}
+	}
 
 	return app.Application{
-		Segments: seg,
+		Segments:            seg,
+		WindowScheduler:     scheduler,
+		SizeHistoryRecorder: recorder,
+		SegmentCacheStats:   cacheStats,
+		RunJournal:          runJournal,
+		Ready:               ready,
+		MaintainPartitions:  maintainPartitions,
+		SyncMembershipStore: syncMembershipStore,
 	}, nil
 }
+
+// newDatabaseRepository connects to the database of the configured storage
+// driver and applies pending migrations if enabled. It also returns the
+// connection to the primary database.
+func newDatabaseRepository(ctx context.Context, cfg config.Config) (DatabaseRepository, *sqlx.DB, error) {
+	repo, db, err := OpenDatabaseRepository(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if autoMigrate(cfg) {
+		m, err := newMigrator(db, cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := runMigrations(ctx, m); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return repo, db, nil
+}
+
+// OpenDatabaseRepository connects to the database of the configured storage
+// driver and returns its repository, along with the connection to the primary
+// database. It fails for the memory driver, which has no database.
+func OpenDatabaseRepository(cfg config.Config) (DatabaseRepository, *sqlx.DB, error) {
+	switch cfg.Storage.Driver {
+	case config.StorageDriverMemory:
+		return nil, nil, errors.New("the memory storage driver has no database")
+	case config.StorageDriverSQLite:
+		db, err := adapters.NewSQLiteConnection(adapters.SQLiteConfig{Path: cfg.SQLite.Path})
+		if err != nil {
+			return nil, nil, err
+		}
+		return adapters.NewSQLiteSegmentRepository(db), db, nil
+	default:
+		db, err := adapters.NewPostgreSQLConnection(PostgreSQLConfig(cfg.Postgres))
+		if err != nil {
+			return nil, nil, err
+		}
+		return adapters.NewPostgreSQLSegmentRepository(db), db.DB, nil
+	}
+}
+
+// WithEventSourcing returns repo recording segment changes as events, along
+// with the reader of their history, if event sourcing is enabled. Otherwise
+// it returns repo itself and a nil history reader.
+func WithEventSourcing(cfg config.Config, repo DatabaseRepository) (segment.Repository, segment.HistoryReader) {
+	if !cfg.Segments.EventSourcing {
+		return repo, nil
+	}
+	eventSourced := adapters.NewEventSourcedSegmentRepository(repo, cfg.Segments.SnapshotInterval)
+	return eventSourced, eventSourced
+}
+
+// newInMemoryRepository returns an in-memory repository, recovered from its
+// journal when it is durable.
+func newInMemoryRepository(cfg config.MemoryConfig) (*adapters.InMemorySegmentRepository, error) {
+	if !cfg.Durable() {
+		return adapters.NewInMemorySegmentRepository(), nil
+	}
+
+	repo, err := adapters.OpenInMemorySegmentRepository(adapters.InMemoryJournalConfig{
+		Dir:              cfg.DataDir,
+		Fsync:            adapters.FsyncPolicy(cfg.Fsync),
+		FsyncInterval:    cfg.FsyncInterval,
+		SnapshotInterval: cfg.SnapshotInterval,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to recover in-memory storage: %w", err)
+	}
+	return repo, nil
+}
+
+// PostgreSQLConfig maps the service configuration to the adapter one.
+func PostgreSQLConfig(cfg config.PostgresConfig) adapters.PostgreSQLConfig {
+	return adapters.PostgreSQLConfig{
+		Host:                 cfg.Host,
+		Port:                 cfg.Port,
+		User:                 cfg.User,
+		Password:             cfg.Password,
+		Database:             cfg.Database,
+		SSLMode:              cfg.SSLMode,
+		MaxOpenConns:         cfg.MaxOpenConns,
+		MaxIdleConns:         cfg.MaxIdleConns,
+		ConnMaxLifetime:      cfg.ConnMaxLifetime,
+		ConnMaxIdleTime:      cfg.ConnMaxIdleTime,
+		Replicas:             cfg.Replicas,
+		ReplicaRetryInterval: cfg.ReplicaRetryInterval,
+	}
+}
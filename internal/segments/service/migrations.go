@@ -0,0 +1,79 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rickKoch/nexus/internal/segments/adapters"
+	"github.com/rickKoch/nexus/internal/segments/adapters/migrations"
+	"github.com/rickKoch/nexus/pkg/config"
+	"github.com/rickKoch/nexus/pkg/migrate"
+	"github.com/sirupsen/logrus"
+)
+
+// NewMigrator connects to the configured database and returns a migrator for
+// the segments schema, along with the database, which the caller closes once
+// done with the migrator.
+func NewMigrator(cfg config.Config) (*migrate.Migrator, *sqlx.DB, error) {
+	db, err := openDatabase(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	m, err := newMigrator(db, cfg)
+	if err != nil {
+		_ = db.Close()
+		return nil, nil, err
+	}
+	return m, db, nil
+}
+
+// openDatabase connects to the database of the configured storage driver.
+func openDatabase(cfg config.Config) (*sqlx.DB, error) {
+	if cfg.Storage.Driver == config.StorageDriverMemory {
+		return nil, errors.New("the memory storage driver has no database")
+	}
+	if cfg.Storage.Driver == config.StorageDriverSQLite {
+		return adapters.NewSQLiteConnection(adapters.SQLiteConfig{Path: cfg.SQLite.Path})
+	}
+	// Migrations only run on the primary.
+	pgCfg := PostgreSQLConfig(cfg.Postgres)
+	pgCfg.Replicas = nil
+	db, err := adapters.NewPostgreSQLConnection(pgCfg)
+	if err != nil {
+		return nil, err
+	}
+	return db.DB, nil
+}
+
+// newMigrator returns a migrator applying the schema of the configured
+// storage driver to db.
+func newMigrator(db *sqlx.DB, cfg config.Config) (*migrate.Migrator, error) {
+	if cfg.Storage.Driver == config.StorageDriverSQLite {
+		return migrate.New(db, migrations.SQLiteFS)
+	}
+	return migrate.New(db, migrations.FS)
+}
+
+// autoMigrate reports whether migrations are applied on startup.
+func autoMigrate(cfg config.Config) bool {
+	if cfg.Storage.Driver == config.StorageDriverSQLite {
+		return cfg.SQLite.AutoMigrate
+	}
+	return cfg.Postgres.AutoMigrate
+}
+
+func runMigrations(ctx context.Context, m *migrate.Migrator) error {
+	applied, err := m.Up(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to run migrations: %w", err)
+	}
+
+	for _, mig := range applied {
+		logrus.WithField("version", mig.Version).WithField("name", mig.Name).Info("Applied migration")
+	}
+
+	return nil
+}
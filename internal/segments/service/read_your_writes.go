@@ -0,0 +1,23 @@
+package service
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/rickKoch/nexus/internal/segments/adapters"
+	"google.golang.org/grpc"
+)
+
+// ReadYourWritesMiddleware lets every HTTP request read its own writes: once
+// it has written, its reads are served by the primary database rather than
+// a lagging replica.
+func ReadYourWritesMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(adapters.WithReadYourWrites(r.Context())))
+	})
+}
+
+// ReadYourWritesInterceptor is ReadYourWritesMiddleware for unary gRPC calls.
+func ReadYourWritesInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	return handler(adapters.WithReadYourWrites(ctx), req)
+}
@@ -0,0 +1,136 @@
+// Package cache provides a bounded in-memory cache whose entries expire after
+// a fixed time to live.
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/rickKoch/nexus/pkg/clock"
+)
+
+// Stats counts the lookups and evictions of a cache.
+type Stats struct {
+	Hits   uint64
+	Misses uint64
+	// Evictions counts entries dropped to make room for new ones. Expired
+	// and removed entries are not counted.
+	Evictions uint64
+	Entries   int
+}
+
+// Add returns the sum of s and other, for reporting several caches as one.
+func (s Stats) Add(other Stats) Stats {
+	return Stats{
+		Hits:      s.Hits + other.Hits,
+		Misses:    s.Misses + other.Misses,
+		Evictions: s.Evictions + other.Evictions,
+		Entries:   s.Entries + other.Entries,
+	}
+}
+
+// LRU is a cache of at most a fixed number of entries, evicting the least
+// recently used entry when full. Entries expire a fixed time after they were
+// added. It is safe for concurrent use.
+type LRU[K comparable, V any] struct {
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	clock    clock.Clock
+	order    *list.List
+	items    map[K]*list.Element
+	stats    Stats
+}
+
+type entry[K comparable, V any] struct {
+	key       K
+	value     V
+	expiresAt time.Time
+}
+
+// NewLRU creates an LRU holding up to capacity entries for ttl each. The
+// capacity must be positive.
+func NewLRU[K comparable, V any](capacity int, ttl time.Duration, clk clock.Clock) *LRU[K, V] {
+	return &LRU[K, V]{
+		capacity: capacity,
+		ttl:      ttl,
+		clock:    clk,
+		order:    list.New(),
+		items:    make(map[K]*list.Element, capacity),
+	}
+}
+
+// Get returns the value cached for key and whether it was found and has not
+// expired.
+func (c *LRU[K, V]) Get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if ok && c.clock.Now().Before(el.Value.(*entry[K, V]).expiresAt) {
+		c.stats.Hits++
+		c.order.MoveToFront(el)
+		return el.Value.(*entry[K, V]).value, true
+	}
+	if ok {
+		c.removeElement(el)
+	}
+
+	c.stats.Misses++
+	var zero V
+	return zero, false
+}
+
+// Add caches value for key, replacing any value cached before.
+func (c *LRU[K, V]) Add(key K, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e := &entry[K, V]{key: key, value: value, expiresAt: c.clock.Now().Add(c.ttl)}
+	if el, ok := c.items[key]; ok {
+		el.Value = e
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.items[key] = c.order.PushFront(e)
+	if c.order.Len() > c.capacity {
+		c.removeElement(c.order.Back())
+		c.stats.Evictions++
+	}
+}
+
+// Remove drops the value cached for key, if any.
+func (c *LRU[K, V]) Remove(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+}
+
+// Purge drops every cached value.
+func (c *LRU[K, V]) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.order.Init()
+	clear(c.items)
+}
+
+// Stats returns the cache's statistics.
+func (c *LRU[K, V]) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	stats.Entries = c.order.Len()
+	return stats
+}
+
+func (c *LRU[K, V]) removeElement(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.items, el.Value.(*entry[K, V]).key)
+}
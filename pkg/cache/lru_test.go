@@ -0,0 +1,69 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rickKoch/nexus/pkg/clock"
+)
+
+func TestLRU(t *testing.T) {
+	start := time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("evicts the least recently used entry", func(t *testing.T) {
+		c := NewLRU[string, int](2, time.Minute, clock.NewFake(start))
+		c.Add("a", 1)
+		c.Add("b", 2)
+		c.Get("a")
+		c.Add("c", 3)
+
+		if _, ok := c.Get("b"); ok {
+			t.Error("expected 'b' to be evicted")
+		}
+		for key, expected := range map[string]int{"a": 1, "c": 3} {
+			if v, ok := c.Get(key); !ok || v != expected {
+				t.Errorf("expected %d for '%s', got %d, %t", expected, key, v, ok)
+			}
+		}
+
+		expected := Stats{Hits: 3, Misses: 1, Evictions: 1, Entries: 2}
+		if stats := c.Stats(); stats != expected {
+			t.Errorf("expected %+v, got %+v", expected, stats)
+		}
+	})
+
+	t.Run("expires entries", func(t *testing.T) {
+		clk := clock.NewFake(start)
+		c := NewLRU[string, int](2, time.Minute, clk)
+		c.Add("a", 1)
+		clk.Advance(30 * time.Second)
+		// Replacing an entry restarts its time to live.
+		c.Add("a", 2)
+		clk.Advance(59 * time.Second)
+
+		if v, ok := c.Get("a"); !ok || v != 2 {
+			t.Errorf("expected 2, got %d, %t", v, ok)
+		}
+		clk.Advance(time.Second)
+		if _, ok := c.Get("a"); ok {
+			t.Error("expected 'a' to expire")
+		}
+		if stats := c.Stats(); stats.Entries != 0 || stats.Evictions != 0 {
+			t.Errorf("expected expired entry to be dropped without eviction, got %+v", stats)
+		}
+	})
+
+	t.Run("removes entries", func(t *testing.T) {
+		c := NewLRU[string, int](2, time.Minute, clock.NewFake(start))
+		c.Add("a", 1)
+		c.Add("b", 2)
+		c.Remove("a")
+		if _, ok := c.Get("a"); ok {
+			t.Error("expected 'a' to be removed")
+		}
+		c.Purge()
+		if _, ok := c.Get("b"); ok {
+			t.Error("expected 'b' to be purged")
+		}
+	})
+}
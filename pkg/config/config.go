@@ -0,0 +1,813 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// redacted replaces secret values in the output of Redacted.
+const redacted = "******"
+
+// Storage drivers, the databases segments are stored in.
+const (
+	// StorageDriverPostgres stores segments in PostgreSQL.
+	StorageDriverPostgres = "postgres"
+	// StorageDriverSQLite stores segments in an embedded SQLite database
+	// file, for single-node deployments and tests.
+	StorageDriverSQLite = "sqlite"
+	// StorageDriverMemory keeps segments in memory. They are lost on
+	// restart unless memory.data_dir is set.
+	StorageDriverMemory = "memory"
+)
+
+// Fsync policies of the in-memory storage journal.
+const (
+	// MemoryFsyncAlways flushes every write before it is acknowledged.
+	MemoryFsyncAlways = "always"
+	// MemoryFsyncInterval flushes the journal every memory.fsync_interval.
+	MemoryFsyncInterval = "interval"
+	// MemoryFsyncNever leaves flushing to the operating system.
+	MemoryFsyncNever = "never"
+)
+
+// Membership stores, where membership checks are served from.
+const (
+	// MembershipStoreDatabase serves membership checks from the database.
+	MembershipStoreDatabase = "database"
+	// MembershipStoreRedis serves membership checks from a copy of the
+	// memberships kept in a Redis-compatible server.
+	MembershipStoreRedis = "redis"
+)
+
+// Config holds the complete service configuration.
+//
+// Values are resolved with the following precedence (highest first):
+// command-line flags, environment variables, configuration file, defaults.
+type Config struct {
+	HTTP       HTTPConfig
+	GRPC       GRPCConfig
+	Storage    StorageConfig
+	Postgres   PostgresConfig
+	SQLite     SQLiteConfig
+	Memory     MemoryConfig
+	Resilience ResilienceConfig
+	Redis      RedisConfig
+	Segments   SegmentsConfig
+}
+
+// HTTPConfig holds the configuration of the HTTP server.
+type HTTPConfig struct {
+	Port               int
+	CORSAllowedOrigins []string
+	// ValidateResponses checks every response against the OpenAPI spec.
+	ValidateResponses bool
+}
+
+// Addr returns the address the HTTP server listens on.
+func (c HTTPConfig) Addr() string {
+	return ":" + strconv.Itoa(c.Port)
+}
+
+// GRPCConfig holds the configuration of the gRPC server.
+type GRPCConfig struct {
+	// Port is the gRPC listener port; 0 disables the gRPC server.
+	Port       int
+	Reflection bool
+}
+
+// Enabled returns true if the gRPC server should be started.
+func (c GRPCConfig) Enabled() bool { return c.Port != 0 }
+
+// Addr returns the address the gRPC server listens on.
+func (c GRPCConfig) Addr() string {
+	return ":" + strconv.Itoa(c.Port)
+}
+
+// StorageConfig holds the configuration of where segments are stored.
+type StorageConfig struct {
+	// Driver is StorageDriverPostgres, StorageDriverSQLite or
+	// StorageDriverMemory.
+	Driver string
+}
+
+// PostgresConfig holds the configuration of the PostgreSQL connection.
+type PostgresConfig struct {
+	Host            string
+	Port            int
+	User            string
+	Password        string
+	Database        string
+	SSLMode         string
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+	AutoMigrate     bool
+	// Replicas are the data source names of read replicas. Segment lists and
+	// lookups are served from them.
+	Replicas []string
+	// ReplicaRetryInterval is how long a failed replica is left out.
+	ReplicaRetryInterval time.Duration
+	// ReadYourWrites serves the reads of a request from the primary once it
+	// has written, so that it sees its own writes despite replication lag.
+	ReadYourWrites bool
+	// PartitionInterval is how often the monthly size history partitions
+	// are maintained. Zero disables maintenance.
+	PartitionInterval time.Duration
+	// PartitionsAhead is the number of months after the current one that
+	// have a size history partition.
+	PartitionsAhead int
+	// HistoryRetentionMonths is the number of months of size history kept
+	// in the database, the current one included. Older months are archived
+	// to ArchiveDir. Zero keeps every month.
+	HistoryRetentionMonths int
+	ArchiveDir             string
+}
+
+// SQLiteConfig holds the configuration of the SQLite database.
+type SQLiteConfig struct {
+	// Path is the database file, created if it does not exist.
+	Path        string
+	AutoMigrate bool
+}
+
+// MemoryConfig holds the configuration of the in-memory storage.
+type MemoryConfig struct {
+	// DataDir holds the journal and snapshots that make the in-memory
+	// storage durable. Empty keeps segments in memory only.
+	DataDir string
+	// Fsync is when the journal is flushed to disk: MemoryFsyncAlways,
+	// MemoryFsyncInterval or MemoryFsyncNever.
+	Fsync         string
+	FsyncInterval time.Duration
+	// SnapshotInterval is how often the journal is compacted into a
+	// snapshot. Zero only takes a snapshot on shutdown.
+	SnapshotInterval time.Duration
+}
+
+// Durable returns true if the in-memory storage survives restarts.
+func (c MemoryConfig) Durable() bool { return c.DataDir != "" }
+
+// ResilienceConfig holds the configuration of how database errors are
+// handled by the postgres and sqlite storage drivers.
+type ResilienceConfig struct {
+	// RetryAttempts is how often an idempotent operation failing with a
+	// transient error is attempted in total. One disables retries.
+	RetryAttempts   int
+	RetryBackoff    time.Duration
+	RetryMaxBackoff time.Duration
+	// BreakerThreshold is the number of consecutive transient errors that
+	// opens the circuit breaker. Zero disables the breaker.
+	BreakerThreshold int
+	// BreakerTimeout is how long the open breaker fails calls before it lets
+	// one through to probe the database.
+	BreakerTimeout time.Duration
+}
+
+// RedisConfig holds the configuration of the connection to a
+// Redis-compatible server.
+type RedisConfig struct {
+	Addr     string
+	Password string
+	DB       int
+	// PoolSize is the number of idle connections kept open.
+	PoolSize    int
+	DialTimeout time.Duration
+	// KeyPrefix is prepended to every key, so instances can share a server.
+	KeyPrefix string
+	// ResyncInterval is how often a membership store out of step with the
+	// database is checked and copied again. Zero disables resyncing.
+	ResyncInterval time.Duration
+}
+
+// SegmentsConfig holds the configuration of the segments application.
+type SegmentsConfig struct {
+	DefaultPageSize int
+	MaxPageSize     int
+	// SchedulerInterval is how often activation windows are checked. Zero
+	// disables the scheduler.
+	SchedulerInterval time.Duration
+	// SizeHistoryInterval is how often expired memberships are purged and
+	// segment sizes recorded. Zero disables size history.
+	SizeHistoryInterval time.Duration
+	// CacheSize is the number of segments, and separately of list pages,
+	// cached in memory. Zero disables the cache.
+	CacheSize int
+	// CacheTTL is how long a cached segment or list page is served. It bounds
+	// how stale reads get after writes made by other instances.
+	CacheTTL time.Duration
+	// MembershipStore is where membership checks are served from, either
+	// MembershipStoreDatabase or MembershipStoreRedis.
+	MembershipStore string
+	// EventSourcing records every change to a segment as events, which
+	// point-in-time reads replay. It requires a database storage driver.
+	EventSourcing bool
+	// SnapshotInterval is the number of events between the snapshots that
+	// replays of a segment start from.
+	SnapshotInterval int
+}
+
+// Default returns a Config with sensible defaults.
+func Default() Config {
+	return Config{
+		HTTP: HTTPConfig{
+			Port: 8080,
+		},
+		GRPC: GRPCConfig{
+			Port:       9090,
+			Reflection: true,
+		},
+		Storage: StorageConfig{
+			Driver: StorageDriverPostgres,
+		},
+		Postgres: PostgresConfig{
+			Host:                 "localhost",
+			Port:                 5432,
+			User:                 "nexus",
+			Password:             "nexus",
+			Database:             "nexus",
+			SSLMode:              "disable",
+			MaxOpenConns:         25,
+			MaxIdleConns:         5,
+			ConnMaxLifetime:      5 * time.Minute,
+			ConnMaxIdleTime:      1 * time.Minute,
+			AutoMigrate:          true,
+			ReplicaRetryInterval: 30 * time.Second,
+			ReadYourWrites:       true,
+			PartitionInterval:    time.Hour,
+			PartitionsAhead:      3,
+		},
+		SQLite: SQLiteConfig{
+			Path:        "nexus.db",
+			AutoMigrate: true,
+		},
+		Memory: MemoryConfig{
+			Fsync:            MemoryFsyncInterval,
+			FsyncInterval:    time.Second,
+			SnapshotInterval: 5 * time.Minute,
+		},
+		Resilience: ResilienceConfig{
+			RetryAttempts:    3,
+			RetryBackoff:     50 * time.Millisecond,
+			RetryMaxBackoff:  time.Second,
+			BreakerThreshold: 5,
+			BreakerTimeout:   10 * time.Second,
+		},
+		Redis: RedisConfig{
+			Addr:           "localhost:6379",
+			PoolSize:       10,
+			DialTimeout:    5 * time.Second,
+			KeyPrefix:      "nexus:",
+			ResyncInterval: 30 * time.Second,
+		},
+		Segments: SegmentsConfig{
+			DefaultPageSize:     20,
+			MaxPageSize:         100,
+			SchedulerInterval:   30 * time.Second,
+			SizeHistoryInterval: time.Hour,
+			CacheTTL:            30 * time.Second,
+			MembershipStore:     MembershipStoreDatabase,
+			SnapshotInterval:    100,
+		},
+	}
+}
+
+// Load resolves the configuration from the defaults, the configuration file,
+// the environment and the given command-line arguments, and validates it.
+//
+// The configuration file is taken from the -config flag or the CONFIG_FILE
+// environment variable. Arguments left after flag parsing are returned so
+// callers can interpret them as subcommands.
+func Load(args []string) (Config, []string, error) {
+	return load(args, os.LookupEnv)
+}
+
+func load(args []string, lookupEnv func(string) (string, bool)) (Config, []string, error) {
+	cfg := Default()
+
+	path, _ := lookupEnv("CONFIG_FILE")
+	if p := configFileFromArgs(args); p != "" {
+		path = p
+	}
+	if path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return cfg, nil, err
+		}
+	}
+
+	if err := cfg.loadEnv(lookupEnv); err != nil {
+		return cfg, nil, err
+	}
+
+	fs := flag.NewFlagSet("nexus", flag.ContinueOnError)
+	fs.String("config", path, "path to a YAML or JSON configuration file")
+	cfg.bindFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return cfg, nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return cfg, nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	return cfg, fs.Args(), nil
+}
+
+// configFileFromArgs extracts the -config flag ahead of the full parse, since
+// the file has to be loaded before the remaining flags are applied on top.
+func configFileFromArgs(args []string) string {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		name := strings.TrimLeft(arg, "-")
+		if name == arg {
+			continue
+		}
+		if value, ok := strings.CutPrefix(name, "config="); ok {
+			return value
+		}
+		if name == "config" && i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		var raw fileConfig
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return fmt.Errorf("failed to parse config file '%s': %w", path, err)
+		}
+		return raw.apply(c)
+	case ".yaml", ".yml":
+		var raw fileConfig
+		if err := yaml.Unmarshal(data, &raw); err != nil {
+			return fmt.Errorf("failed to parse config file '%s': %w", path, err)
+		}
+		return raw.apply(c)
+	default:
+		return fmt.Errorf("unsupported config file format '%s'", filepath.Ext(path))
+	}
+}
+
+func (c *Config) loadEnv(lookupEnv func(string) (string, bool)) error {
+	vars := []struct {
+		key string
+		set func(string) error
+	}{
+		{"PORT", intSetter(&c.HTTP.Port)},
+		{"CORS_ALLOWED_ORIGINS", listSetter(&c.HTTP.CORSAllowedOrigins)},
+		{"HTTP_VALIDATE_RESPONSES", boolSetter(&c.HTTP.ValidateResponses)},
+		{"GRPC_PORT", intSetter(&c.GRPC.Port)},
+		{"GRPC_REFLECTION", boolSetter(&c.GRPC.Reflection)},
+		{"STORAGE_DRIVER", stringSetter(&c.Storage.Driver)},
+		{"POSTGRES_HOST", stringSetter(&c.Postgres.Host)},
+		{"POSTGRES_PORT", intSetter(&c.Postgres.Port)},
+		{"POSTGRES_USER", stringSetter(&c.Postgres.User)},
+		{"POSTGRES_PASSWORD", stringSetter(&c.Postgres.Password)},
+		{"POSTGRES_DB", stringSetter(&c.Postgres.Database)},
+		{"POSTGRES_SSLMODE", stringSetter(&c.Postgres.SSLMode)},
+		{"POSTGRES_MAX_OPEN_CONNS", intSetter(&c.Postgres.MaxOpenConns)},
+		{"POSTGRES_MAX_IDLE_CONNS", intSetter(&c.Postgres.MaxIdleConns)},
+		{"POSTGRES_CONN_MAX_LIFETIME", durationSetter(&c.Postgres.ConnMaxLifetime)},
+		{"POSTGRES_CONN_MAX_IDLE_TIME", durationSetter(&c.Postgres.ConnMaxIdleTime)},
+		{"POSTGRES_AUTO_MIGRATE", boolSetter(&c.Postgres.AutoMigrate)},
+		{"POSTGRES_REPLICAS", listSetter(&c.Postgres.Replicas)},
+		{"POSTGRES_REPLICA_RETRY_INTERVAL", durationSetter(&c.Postgres.ReplicaRetryInterval)},
+		{"POSTGRES_READ_YOUR_WRITES", boolSetter(&c.Postgres.ReadYourWrites)},
+		{"POSTGRES_PARTITION_INTERVAL", durationSetter(&c.Postgres.PartitionInterval)},
+		{"POSTGRES_PARTITIONS_AHEAD", intSetter(&c.Postgres.PartitionsAhead)},
+		{"POSTGRES_HISTORY_RETENTION_MONTHS", intSetter(&c.Postgres.HistoryRetentionMonths)},
+		{"POSTGRES_ARCHIVE_DIR", stringSetter(&c.Postgres.ArchiveDir)},
+		{"SQLITE_PATH", stringSetter(&c.SQLite.Path)},
+		{"SQLITE_AUTO_MIGRATE", boolSetter(&c.SQLite.AutoMigrate)},
+		{"MEMORY_DATA_DIR", stringSetter(&c.Memory.DataDir)},
+		{"MEMORY_FSYNC", stringSetter(&c.Memory.Fsync)},
+		{"MEMORY_FSYNC_INTERVAL", durationSetter(&c.Memory.FsyncInterval)},
+		{"MEMORY_SNAPSHOT_INTERVAL", durationSetter(&c.Memory.SnapshotInterval)},
+		{"RESILIENCE_RETRY_ATTEMPTS", intSetter(&c.Resilience.RetryAttempts)},
+		{"RESILIENCE_RETRY_BACKOFF", durationSetter(&c.Resilience.RetryBackoff)},
+		{"RESILIENCE_RETRY_MAX_BACKOFF", durationSetter(&c.Resilience.RetryMaxBackoff)},
+		{"RESILIENCE_BREAKER_THRESHOLD", intSetter(&c.Resilience.BreakerThreshold)},
+		{"RESILIENCE_BREAKER_TIMEOUT", durationSetter(&c.Resilience.BreakerTimeout)},
+		{"REDIS_ADDR", stringSetter(&c.Redis.Addr)},
+		{"REDIS_PASSWORD", stringSetter(&c.Redis.Password)},
+		{"REDIS_DB", intSetter(&c.Redis.DB)},
+		{"REDIS_POOL_SIZE", intSetter(&c.Redis.PoolSize)},
+		{"REDIS_DIAL_TIMEOUT", durationSetter(&c.Redis.DialTimeout)},
+		{"REDIS_KEY_PREFIX", stringSetter(&c.Redis.KeyPrefix)},
+		{"REDIS_RESYNC_INTERVAL", durationSetter(&c.Redis.ResyncInterval)},
+		{"SEGMENTS_DEFAULT_PAGE_SIZE", intSetter(&c.Segments.DefaultPageSize)},
+		{"SEGMENTS_MAX_PAGE_SIZE", intSetter(&c.Segments.MaxPageSize)},
+		{"SEGMENTS_SCHEDULER_INTERVAL", durationSetter(&c.Segments.SchedulerInterval)},
+		{"SEGMENTS_SIZE_HISTORY_INTERVAL", durationSetter(&c.Segments.SizeHistoryInterval)},
+		{"SEGMENTS_CACHE_SIZE", intSetter(&c.Segments.CacheSize)},
+		{"SEGMENTS_CACHE_TTL", durationSetter(&c.Segments.CacheTTL)},
+		{"SEGMENTS_MEMBERSHIP_STORE", stringSetter(&c.Segments.MembershipStore)},
+		{"SEGMENTS_EVENT_SOURCING", boolSetter(&c.Segments.EventSourcing)},
+		{"SEGMENTS_SNAPSHOT_INTERVAL", intSetter(&c.Segments.SnapshotInterval)},
+	}
+
+	for _, v := range vars {
+		value, ok := lookupEnv(v.key)
+		if !ok || value == "" {
+			continue
+		}
+		if err := v.set(value); err != nil {
+			return fmt.Errorf("invalid value for %s: %w", v.key, err)
+		}
+	}
+
+	return nil
+}
+
+func (c *Config) bindFlags(fs *flag.FlagSet) {
+	fs.IntVar(&c.HTTP.Port, "port", c.HTTP.Port, "HTTP server port")
+	fs.Func("cors-allowed-origins", "semicolon separated list of CORS allowed origins", listSetter(&c.HTTP.CORSAllowedOrigins))
+	fs.BoolVar(&c.HTTP.ValidateResponses, "http-validate-responses", c.HTTP.ValidateResponses, "validate responses against the OpenAPI spec")
+	fs.IntVar(&c.GRPC.Port, "grpc-port", c.GRPC.Port, "gRPC server port, 0 disables the gRPC server")
+	fs.BoolVar(&c.GRPC.Reflection, "grpc-reflection", c.GRPC.Reflection, "enable gRPC server reflection")
+	fs.StringVar(&c.Storage.Driver, "storage-driver", c.Storage.Driver, "database segments are stored in: postgres, sqlite or memory")
+	fs.StringVar(&c.Postgres.Host, "postgres-host", c.Postgres.Host, "PostgreSQL host")
+	fs.IntVar(&c.Postgres.Port, "postgres-port", c.Postgres.Port, "PostgreSQL port")
+	fs.StringVar(&c.Postgres.User, "postgres-user", c.Postgres.User, "PostgreSQL username")
+	fs.StringVar(&c.Postgres.Password, "postgres-password", c.Postgres.Password, "PostgreSQL password")
+	fs.StringVar(&c.Postgres.Database, "postgres-db", c.Postgres.Database, "PostgreSQL database name")
+	fs.StringVar(&c.Postgres.SSLMode, "postgres-sslmode", c.Postgres.SSLMode, "PostgreSQL SSL mode")
+	fs.IntVar(&c.Postgres.MaxOpenConns, "postgres-max-open-conns", c.Postgres.MaxOpenConns, "maximum number of open PostgreSQL connections")
+	fs.IntVar(&c.Postgres.MaxIdleConns, "postgres-max-idle-conns", c.Postgres.MaxIdleConns, "maximum number of idle PostgreSQL connections")
+	fs.DurationVar(&c.Postgres.ConnMaxLifetime, "postgres-conn-max-lifetime", c.Postgres.ConnMaxLifetime, "maximum lifetime of a PostgreSQL connection")
+	fs.DurationVar(&c.Postgres.ConnMaxIdleTime, "postgres-conn-max-idle-time", c.Postgres.ConnMaxIdleTime, "maximum idle time of a PostgreSQL connection")
+	fs.BoolVar(&c.Postgres.AutoMigrate, "postgres-auto-migrate", c.Postgres.AutoMigrate, "apply pending migrations on startup")
+	fs.Func("postgres-replicas", "semicolon separated list of PostgreSQL read replica data source names", listSetter(&c.Postgres.Replicas))
+	fs.DurationVar(&c.Postgres.ReplicaRetryInterval, "postgres-replica-retry-interval", c.Postgres.ReplicaRetryInterval, "how long a failed PostgreSQL read replica is left out")
+	fs.BoolVar(&c.Postgres.ReadYourWrites, "postgres-read-your-writes", c.Postgres.ReadYourWrites, "serve the reads of a request from the primary once it has written")
+	fs.DurationVar(&c.Postgres.PartitionInterval, "postgres-partition-interval", c.Postgres.PartitionInterval, "how often size history partitions are maintained, 0 disables maintenance")
+	fs.IntVar(&c.Postgres.PartitionsAhead, "postgres-partitions-ahead", c.Postgres.PartitionsAhead, "number of months after the current one with a size history partition")
+	fs.IntVar(&c.Postgres.HistoryRetentionMonths, "postgres-history-retention-months", c.Postgres.HistoryRetentionMonths, "months of size history kept in the database, 0 keeps every month")
+	fs.StringVar(&c.Postgres.ArchiveDir, "postgres-archive-dir", c.Postgres.ArchiveDir, "directory size history beyond the retention is archived to")
+	fs.StringVar(&c.SQLite.Path, "sqlite-path", c.SQLite.Path, "SQLite database file")
+	fs.BoolVar(&c.SQLite.AutoMigrate, "sqlite-auto-migrate", c.SQLite.AutoMigrate, "apply pending SQLite migrations on startup")
+	fs.StringVar(&c.Memory.DataDir, "memory-data-dir", c.Memory.DataDir, "directory of the in-memory storage journal, empty keeps segments in memory only")
+	fs.StringVar(&c.Memory.Fsync, "memory-fsync", c.Memory.Fsync, "when the in-memory storage journal is flushed: always, interval or never")
+	fs.DurationVar(&c.Memory.FsyncInterval, "memory-fsync-interval", c.Memory.FsyncInterval, "how often the in-memory storage journal is flushed under the interval policy")
+	fs.DurationVar(&c.Memory.SnapshotInterval, "memory-snapshot-interval", c.Memory.SnapshotInterval, "how often the in-memory storage journal is compacted, 0 only compacts on shutdown")
+	fs.IntVar(&c.Resilience.RetryAttempts, "retry-attempts", c.Resilience.RetryAttempts, "how often idempotent database operations are attempted on transient errors, 1 disables retries")
+	fs.DurationVar(&c.Resilience.RetryBackoff, "retry-backoff", c.Resilience.RetryBackoff, "delay before the first retry of a database operation, doubled for every further retry")
+	fs.DurationVar(&c.Resilience.RetryMaxBackoff, "retry-max-backoff", c.Resilience.RetryMaxBackoff, "maximum delay between retries of a database operation")
+	fs.IntVar(&c.Resilience.BreakerThreshold, "breaker-threshold", c.Resilience.BreakerThreshold, "consecutive transient database errors that open the circuit breaker, 0 disables the breaker")
+	fs.DurationVar(&c.Resilience.BreakerTimeout, "breaker-timeout", c.Resilience.BreakerTimeout, "how long the open circuit breaker fails database calls before probing")
+	fs.StringVar(&c.Redis.Addr, "redis-addr", c.Redis.Addr, "Redis host:port")
+	fs.StringVar(&c.Redis.Password, "redis-password", c.Redis.Password, "Redis password")
+	fs.IntVar(&c.Redis.DB, "redis-db", c.Redis.DB, "Redis database number")
+	fs.IntVar(&c.Redis.PoolSize, "redis-pool-size", c.Redis.PoolSize, "number of idle Redis connections kept open")
+	fs.DurationVar(&c.Redis.DialTimeout, "redis-dial-timeout", c.Redis.DialTimeout, "timeout for opening a Redis connection")
+	fs.StringVar(&c.Redis.KeyPrefix, "redis-key-prefix", c.Redis.KeyPrefix, "prefix of the Redis keys")
+	fs.DurationVar(&c.Redis.ResyncInterval, "redis-resync-interval", c.Redis.ResyncInterval, "how often a membership store out of step is copied again, 0 disables resyncing")
+	fs.IntVar(&c.Segments.DefaultPageSize, "default-page-size", c.Segments.DefaultPageSize, "default page size when listing segments")
+	fs.IntVar(&c.Segments.MaxPageSize, "max-page-size", c.Segments.MaxPageSize, "maximum page size when listing segments")
+	fs.DurationVar(&c.Segments.SchedulerInterval, "scheduler-interval", c.Segments.SchedulerInterval, "how often activation windows are checked, 0 disables the scheduler")
+	fs.DurationVar(&c.Segments.SizeHistoryInterval, "size-history-interval", c.Segments.SizeHistoryInterval, "how often segment sizes are recorded, 0 disables size history")
+	fs.IntVar(&c.Segments.CacheSize, "cache-size", c.Segments.CacheSize, "number of segments and list pages cached in memory, 0 disables the cache")
+	fs.DurationVar(&c.Segments.CacheTTL, "cache-ttl", c.Segments.CacheTTL, "how long cached segments and list pages are served")
+	fs.StringVar(&c.Segments.MembershipStore, "membership-store", c.Segments.MembershipStore, "where membership checks are served from: database or redis")
+	fs.BoolVar(&c.Segments.EventSourcing, "event-sourcing", c.Segments.EventSourcing, "record segment changes as events for point-in-time reads")
+	fs.IntVar(&c.Segments.SnapshotInterval, "snapshot-interval", c.Segments.SnapshotInterval, "number of segment events between snapshots")
+}
+
+// Validate checks if the configuration is valid.
+func (c Config) Validate() error {
+	var errs []error
+
+	if c.HTTP.Port <= 0 || c.HTTP.Port > 65535 {
+		errs = append(errs, errors.New("http.port must be between 1 and 65535"))
+	}
+	if c.GRPC.Port < 0 || c.GRPC.Port > 65535 {
+		errs = append(errs, errors.New("grpc.port must be between 0 and 65535"))
+	}
+	if c.GRPC.Enabled() && c.GRPC.Port == c.HTTP.Port {
+		errs = append(errs, errors.New("grpc.port must differ from http.port"))
+	}
+	switch c.Storage.Driver {
+	case StorageDriverPostgres:
+		if c.Postgres.Host == "" {
+			errs = append(errs, errors.New("postgres.host is required"))
+		}
+		if c.Postgres.Port <= 0 || c.Postgres.Port > 65535 {
+			errs = append(errs, errors.New("postgres.port must be between 1 and 65535"))
+		}
+		if c.Postgres.User == "" {
+			errs = append(errs, errors.New("postgres.user is required"))
+		}
+		if c.Postgres.Database == "" {
+			errs = append(errs, errors.New("postgres.database is required"))
+		}
+		if c.Postgres.MaxOpenConns < 0 {
+			errs = append(errs, errors.New("postgres.max_open_conns must not be negative"))
+		}
+		if c.Postgres.MaxIdleConns < 0 {
+			errs = append(errs, errors.New("postgres.max_idle_conns must not be negative"))
+		}
+		if len(c.Postgres.Replicas) > 0 && c.Postgres.ReplicaRetryInterval <= 0 {
+			errs = append(errs, errors.New("postgres.replica_retry_interval must be positive when replicas are set"))
+		}
+		// The cache cannot tell reads from a lagging replica apart and would
+		// keep serving them after the write they miss.
+		if len(c.Postgres.Replicas) > 0 && c.Segments.CacheSize > 0 {
+			errs = append(errs, errors.New("segments.cache_size must be 0 when postgres.replicas are set"))
+		}
+		if c.Postgres.PartitionInterval < 0 {
+			errs = append(errs, errors.New("postgres.partition_interval must not be negative"))
+		}
+		if c.Postgres.PartitionInterval > 0 {
+			if c.Postgres.PartitionsAhead < 1 {
+				errs = append(errs, errors.New("postgres.partitions_ahead must be positive"))
+			}
+			if c.Postgres.HistoryRetentionMonths < 0 {
+				errs = append(errs, errors.New("postgres.history_retention_months must not be negative"))
+			}
+			if c.Postgres.HistoryRetentionMonths > 0 && c.Postgres.ArchiveDir == "" {
+				errs = append(errs, errors.New("postgres.archive_dir is required with postgres.history_retention_months"))
+			}
+		}
+	case StorageDriverSQLite:
+		if c.SQLite.Path == "" {
+			errs = append(errs, errors.New("sqlite.path is required"))
+		}
+	case StorageDriverMemory:
+		if c.Memory.Durable() {
+			switch c.Memory.Fsync {
+			case MemoryFsyncAlways, MemoryFsyncNever:
+			case MemoryFsyncInterval:
+				if c.Memory.FsyncInterval <= 0 {
+					errs = append(errs, errors.New("memory.fsync_interval must be positive"))
+				}
+			default:
+				errs = append(errs, fmt.Errorf("memory.fsync must be %s, %s or %s", MemoryFsyncAlways, MemoryFsyncInterval, MemoryFsyncNever))
+			}
+			if c.Memory.SnapshotInterval < 0 {
+				errs = append(errs, errors.New("memory.snapshot_interval must not be negative"))
+			}
+		}
+	default:
+		errs = append(errs, fmt.Errorf("storage.driver must be %s, %s or %s", StorageDriverPostgres, StorageDriverSQLite, StorageDriverMemory))
+	}
+	if c.Storage.Driver == StorageDriverPostgres || c.Storage.Driver == StorageDriverSQLite {
+		if c.Resilience.RetryAttempts <= 0 {
+			errs = append(errs, errors.New("resilience.retry_attempts must be positive"))
+		}
+		if c.Resilience.RetryAttempts > 1 && (c.Resilience.RetryBackoff <= 0 || c.Resilience.RetryMaxBackoff < c.Resilience.RetryBackoff) {
+			errs = append(errs, errors.New("resilience.retry_backoff must be positive and at most resilience.retry_max_backoff"))
+		}
+		if c.Resilience.BreakerThreshold < 0 {
+			errs = append(errs, errors.New("resilience.breaker_threshold must not be negative"))
+		}
+		if c.Resilience.BreakerThreshold > 0 && c.Resilience.BreakerTimeout <= 0 {
+			errs = append(errs, errors.New("resilience.breaker_timeout must be positive"))
+		}
+	}
+	if c.Segments.MembershipStore == MembershipStoreRedis {
+		if c.Redis.Addr == "" {
+			errs = append(errs, errors.New("redis.addr is required"))
+		}
+		if c.Redis.DB < 0 {
+			errs = append(errs, errors.New("redis.db must not be negative"))
+		}
+		if c.Redis.PoolSize < 0 {
+			errs = append(errs, errors.New("redis.pool_size must not be negative"))
+		}
+		if c.Redis.DialTimeout < 0 {
+			errs = append(errs, errors.New("redis.dial_timeout must not be negative"))
+		}
+		if c.Redis.ResyncInterval < 0 {
+			errs = append(errs, errors.New("redis.resync_interval must not be negative"))
+		}
+	}
+	if c.Segments.DefaultPageSize <= 0 {
+		errs = append(errs, errors.New("segments.default_page_size must be positive"))
+	}
+	if c.Segments.MaxPageSize < c.Segments.DefaultPageSize {
+		errs = append(errs, errors.New("segments.max_page_size must not be less than segments.default_page_size"))
+	}
+	if c.Segments.SchedulerInterval < 0 {
+		errs = append(errs, errors.New("segments.scheduler_interval must not be negative"))
+	}
+	if c.Segments.SizeHistoryInterval < 0 {
+		errs = append(errs, errors.New("segments.size_history_interval must not be negative"))
+	}
+	if c.Segments.CacheSize < 0 {
+		errs = append(errs, errors.New("segments.cache_size must not be negative"))
+	}
+	if c.Segments.CacheSize > 0 && c.Segments.CacheTTL <= 0 {
+		errs = append(errs, errors.New("segments.cache_ttl must be positive when the cache is enabled"))
+	}
+	if c.Segments.MembershipStore != MembershipStoreDatabase && c.Segments.MembershipStore != MembershipStoreRedis {
+		errs = append(errs, fmt.Errorf("segments.membership_store must be %s or %s", MembershipStoreDatabase, MembershipStoreRedis))
+	}
+	if c.Segments.EventSourcing {
+		if c.Storage.Driver == StorageDriverMemory {
+			errs = append(errs, fmt.Errorf("segments.event_sourcing requires the %s or %s storage driver", StorageDriverPostgres, StorageDriverSQLite))
+		}
+		if c.Segments.SnapshotInterval <= 0 {
+			errs = append(errs, errors.New("segments.snapshot_interval must be positive"))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Redacted returns a copy of the configuration with secrets masked.
+func (c Config) Redacted() Config {
+	if c.Postgres.Password != "" {
+		c.Postgres.Password = redacted
+	}
+	replicas := make([]string, 0, len(c.Postgres.Replicas))
+	for _, dsn := range c.Postgres.Replicas {
+		replicas = append(replicas, redactDSN(dsn))
+	}
+	c.Postgres.Replicas = replicas
+	if c.Redis.Password != "" {
+		c.Redis.Password = redacted
+	}
+	c.HTTP.CORSAllowedOrigins = append([]string(nil), c.HTTP.CORSAllowedOrigins...)
+	return c
+}
+
+// dsnPassword matches the password of a key/value data source name.
+var dsnPassword = regexp.MustCompile(`(password\s*=\s*)('(?:[^'\\]|\\.)*'|\S+)`)
+
+// redactDSN masks the password of a data source name, given either as a URL
+// or as key/value pairs.
+func redactDSN(dsn string) string {
+	if u, err := url.Parse(dsn); err == nil && u.Scheme != "" {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), redacted)
+		}
+		return u.String()
+	}
+	return dsnPassword.ReplaceAllString(dsn, "${1}"+redacted)
+}
+
+// Dump writes the redacted configuration to w as YAML.
+func (c Config) Dump(w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(c.Redacted().dumpable()); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// dumpable renders durations as strings so the dump can be fed back as a
+// configuration file.
+func (c Config) dumpable() fileConfig {
+	return fileConfig{
+		HTTP: &fileHTTPConfig{
+			Port:               &c.HTTP.Port,
+			CORSAllowedOrigins: c.HTTP.CORSAllowedOrigins,
+			ValidateResponses:  &c.HTTP.ValidateResponses,
+		},
+		GRPC: &fileGRPCConfig{
+			Port:       &c.GRPC.Port,
+			Reflection: &c.GRPC.Reflection,
+		},
+		Storage: &fileStorageConfig{
+			Driver: &c.Storage.Driver,
+		},
+		Postgres: &filePostgresConfig{
+			Host:                   &c.Postgres.Host,
+			Port:                   &c.Postgres.Port,
+			User:                   &c.Postgres.User,
+			Password:               &c.Postgres.Password,
+			Database:               &c.Postgres.Database,
+			SSLMode:                &c.Postgres.SSLMode,
+			MaxOpenConns:           &c.Postgres.MaxOpenConns,
+			MaxIdleConns:           &c.Postgres.MaxIdleConns,
+			ConnMaxLifetime:        durationString(c.Postgres.ConnMaxLifetime),
+			ConnMaxIdleTime:        durationString(c.Postgres.ConnMaxIdleTime),
+			AutoMigrate:            &c.Postgres.AutoMigrate,
+			Replicas:               c.Postgres.Replicas,
+			ReplicaRetryInterval:   durationString(c.Postgres.ReplicaRetryInterval),
+			ReadYourWrites:         &c.Postgres.ReadYourWrites,
+			PartitionInterval:      durationString(c.Postgres.PartitionInterval),
+			PartitionsAhead:        &c.Postgres.PartitionsAhead,
+			HistoryRetentionMonths: &c.Postgres.HistoryRetentionMonths,
+			ArchiveDir:             &c.Postgres.ArchiveDir,
+		},
+		SQLite: &fileSQLiteConfig{
+			Path:        &c.SQLite.Path,
+			AutoMigrate: &c.SQLite.AutoMigrate,
+		},
+		Memory: &fileMemoryConfig{
+			DataDir:          &c.Memory.DataDir,
+			Fsync:            &c.Memory.Fsync,
+			FsyncInterval:    durationString(c.Memory.FsyncInterval),
+			SnapshotInterval: durationString(c.Memory.SnapshotInterval),
+		},
+		Resilience: &fileResilienceConfig{
+			RetryAttempts:    &c.Resilience.RetryAttempts,
+			RetryBackoff:     durationString(c.Resilience.RetryBackoff),
+			RetryMaxBackoff:  durationString(c.Resilience.RetryMaxBackoff),
+			BreakerThreshold: &c.Resilience.BreakerThreshold,
+			BreakerTimeout:   durationString(c.Resilience.BreakerTimeout),
+		},
+		Redis: &fileRedisConfig{
+			Addr:           &c.Redis.Addr,
+			Password:       &c.Redis.Password,
+			DB:             &c.Redis.DB,
+			PoolSize:       &c.Redis.PoolSize,
+			DialTimeout:    durationString(c.Redis.DialTimeout),
+			KeyPrefix:      &c.Redis.KeyPrefix,
+			ResyncInterval: durationString(c.Redis.ResyncInterval),
+		},
+		Segments: &fileSegmentsConfig{
+			DefaultPageSize:     &c.Segments.DefaultPageSize,
+			MaxPageSize:         &c.Segments.MaxPageSize,
+			SchedulerInterval:   durationString(c.Segments.SchedulerInterval),
+			SizeHistoryInterval: durationString(c.Segments.SizeHistoryInterval),
+			CacheSize:           &c.Segments.CacheSize,
+			CacheTTL:            durationString(c.Segments.CacheTTL),
+			MembershipStore:     &c.Segments.MembershipStore,
+			EventSourcing:       &c.Segments.EventSourcing,
+			SnapshotInterval:    &c.Segments.SnapshotInterval,
+		},
+	}
+}
+
+func stringSetter(dst *string) func(string) error {
+	return func(value string) error {
+		*dst = value
+		return nil
+	}
+}
+
+func intSetter(dst *int) func(string) error {
+	return func(value string) error {
+		v, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		*dst = v
+		return nil
+	}
+}
+
+func boolSetter(dst *bool) func(string) error {
+	return func(value string) error {
+		v, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		*dst = v
+		return nil
+	}
+}
+
+func durationSetter(dst *time.Duration) func(string) error {
+	return func(value string) error {
+		v, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*dst = v
+		return nil
+	}
+}
+
+func listSetter(dst *[]string) func(string) error {
+	return func(value string) error {
+		var items []string
+		for _, item := range strings.Split(value, ";") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		*dst = items
+		return nil
+	}
+}
+
+func durationString(d time.Duration) *string {
+	s := d.String()
+	return &s
+}
@@ -0,0 +1,230 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// fileConfig mirrors Config with optional fields so that a configuration
+// file only overrides the values it actually sets.
+type fileConfig struct {
+	HTTP       *fileHTTPConfig       `json:"http,omitempty" yaml:"http,omitempty"`
+	GRPC       *fileGRPCConfig       `json:"grpc,omitempty" yaml:"grpc,omitempty"`
+	Storage    *fileStorageConfig    `json:"storage,omitempty" yaml:"storage,omitempty"`
+	Postgres   *filePostgresConfig   `json:"postgres,omitempty" yaml:"postgres,omitempty"`
+	SQLite     *fileSQLiteConfig     `json:"sqlite,omitempty" yaml:"sqlite,omitempty"`
+	Memory     *fileMemoryConfig     `json:"memory,omitempty" yaml:"memory,omitempty"`
+	Resilience *fileResilienceConfig `json:"resilience,omitempty" yaml:"resilience,omitempty"`
+	Redis      *fileRedisConfig      `json:"redis,omitempty" yaml:"redis,omitempty"`
+	Segments   *fileSegmentsConfig   `json:"segments,omitempty" yaml:"segments,omitempty"`
+}
+
+type fileHTTPConfig struct {
+	Port               *int     `json:"port,omitempty" yaml:"port,omitempty"`
+	CORSAllowedOrigins []string `json:"cors_allowed_origins,omitempty" yaml:"cors_allowed_origins,omitempty"`
+	ValidateResponses  *bool    `json:"validate_responses,omitempty" yaml:"validate_responses,omitempty"`
+}
+
+type fileGRPCConfig struct {
+	Port       *int  `json:"port,omitempty" yaml:"port,omitempty"`
+	Reflection *bool `json:"reflection,omitempty" yaml:"reflection,omitempty"`
+}
+
+type fileStorageConfig struct {
+	Driver *string `json:"driver,omitempty" yaml:"driver,omitempty"`
+}
+
+type filePostgresConfig struct {
+	Host                   *string  `json:"host,omitempty" yaml:"host,omitempty"`
+	Port                   *int     `json:"port,omitempty" yaml:"port,omitempty"`
+	User                   *string  `json:"user,omitempty" yaml:"user,omitempty"`
+	Password               *string  `json:"password,omitempty" yaml:"password,omitempty"`
+	Database               *string  `json:"database,omitempty" yaml:"database,omitempty"`
+	SSLMode                *string  `json:"sslmode,omitempty" yaml:"sslmode,omitempty"`
+	MaxOpenConns           *int     `json:"max_open_conns,omitempty" yaml:"max_open_conns,omitempty"`
+	MaxIdleConns           *int     `json:"max_idle_conns,omitempty" yaml:"max_idle_conns,omitempty"`
+	ConnMaxLifetime        *string  `json:"conn_max_lifetime,omitempty" yaml:"conn_max_lifetime,omitempty"`
+	ConnMaxIdleTime        *string  `json:"conn_max_idle_time,omitempty" yaml:"conn_max_idle_time,omitempty"`
+	AutoMigrate            *bool    `json:"auto_migrate,omitempty" yaml:"auto_migrate,omitempty"`
+	Replicas               []string `json:"replicas,omitempty" yaml:"replicas,omitempty"`
+	ReplicaRetryInterval   *string  `json:"replica_retry_interval,omitempty" yaml:"replica_retry_interval,omitempty"`
+	ReadYourWrites         *bool    `json:"read_your_writes,omitempty" yaml:"read_your_writes,omitempty"`
+	PartitionInterval      *string  `json:"partition_interval,omitempty" yaml:"partition_interval,omitempty"`
+	PartitionsAhead        *int     `json:"partitions_ahead,omitempty" yaml:"partitions_ahead,omitempty"`
+	HistoryRetentionMonths *int     `json:"history_retention_months,omitempty" yaml:"history_retention_months,omitempty"`
+	ArchiveDir             *string  `json:"archive_dir,omitempty" yaml:"archive_dir,omitempty"`
+}
+
+type fileSQLiteConfig struct {
+	Path        *string `json:"path,omitempty" yaml:"path,omitempty"`
+	AutoMigrate *bool   `json:"auto_migrate,omitempty" yaml:"auto_migrate,omitempty"`
+}
+
+type fileMemoryConfig struct {
+	DataDir          *string `json:"data_dir,omitempty" yaml:"data_dir,omitempty"`
+	Fsync            *string `json:"fsync,omitempty" yaml:"fsync,omitempty"`
+	FsyncInterval    *string `json:"fsync_interval,omitempty" yaml:"fsync_interval,omitempty"`
+	SnapshotInterval *string `json:"snapshot_interval,omitempty" yaml:"snapshot_interval,omitempty"`
+}
+
+type fileResilienceConfig struct {
+	RetryAttempts    *int    `json:"retry_attempts,omitempty" yaml:"retry_attempts,omitempty"`
+	RetryBackoff     *string `json:"retry_backoff,omitempty" yaml:"retry_backoff,omitempty"`
+	RetryMaxBackoff  *string `json:"retry_max_backoff,omitempty" yaml:"retry_max_backoff,omitempty"`
+	BreakerThreshold *int    `json:"breaker_threshold,omitempty" yaml:"breaker_threshold,omitempty"`
+	BreakerTimeout   *string `json:"breaker_timeout,omitempty" yaml:"breaker_timeout,omitempty"`
+}
+
+type fileRedisConfig struct {
+	Addr           *string `json:"addr,omitempty" yaml:"addr,omitempty"`
+	Password       *string `json:"password,omitempty" yaml:"password,omitempty"`
+	DB             *int    `json:"db,omitempty" yaml:"db,omitempty"`
+	PoolSize       *int    `json:"pool_size,omitempty" yaml:"pool_size,omitempty"`
+	DialTimeout    *string `json:"dial_timeout,omitempty" yaml:"dial_timeout,omitempty"`
+	KeyPrefix      *string `json:"key_prefix,omitempty" yaml:"key_prefix,omitempty"`
+	ResyncInterval *string `json:"resync_interval,omitempty" yaml:"resync_interval,omitempty"`
+}
+
+type fileSegmentsConfig struct {
+	DefaultPageSize     *int    `json:"default_page_size,omitempty" yaml:"default_page_size,omitempty"`
+	MaxPageSize         *int    `json:"max_page_size,omitempty" yaml:"max_page_size,omitempty"`
+	SchedulerInterval   *string `json:"scheduler_interval,omitempty" yaml:"scheduler_interval,omitempty"`
+	SizeHistoryInterval *string `json:"size_history_interval,omitempty" yaml:"size_history_interval,omitempty"`
+	CacheSize           *int    `json:"cache_size,omitempty" yaml:"cache_size,omitempty"`
+	CacheTTL            *string `json:"cache_ttl,omitempty" yaml:"cache_ttl,omitempty"`
+	MembershipStore     *string `json:"membership_store,omitempty" yaml:"membership_store,omitempty"`
+	EventSourcing       *bool   `json:"event_sourcing,omitempty" yaml:"event_sourcing,omitempty"`
+	SnapshotInterval    *int    `json:"snapshot_interval,omitempty" yaml:"snapshot_interval,omitempty"`
+}
+
+func (f fileConfig) apply(c *Config) error {
+	if h := f.HTTP; h != nil {
+		setIfPresent(&c.HTTP.Port, h.Port)
+		if h.CORSAllowedOrigins != nil {
+			c.HTTP.CORSAllowedOrigins = h.CORSAllowedOrigins
+		}
+		setIfPresent(&c.HTTP.ValidateResponses, h.ValidateResponses)
+	}
+
+	if g := f.GRPC; g != nil {
+		setIfPresent(&c.GRPC.Port, g.Port)
+		setIfPresent(&c.GRPC.Reflection, g.Reflection)
+	}
+
+	if s := f.Storage; s != nil {
+		setIfPresent(&c.Storage.Driver, s.Driver)
+	}
+
+	if p := f.Postgres; p != nil {
+		setIfPresent(&c.Postgres.Host, p.Host)
+		setIfPresent(&c.Postgres.Port, p.Port)
+		setIfPresent(&c.Postgres.User, p.User)
+		setIfPresent(&c.Postgres.Password, p.Password)
+		setIfPresent(&c.Postgres.Database, p.Database)
+		setIfPresent(&c.Postgres.SSLMode, p.SSLMode)
+		setIfPresent(&c.Postgres.MaxOpenConns, p.MaxOpenConns)
+		setIfPresent(&c.Postgres.MaxIdleConns, p.MaxIdleConns)
+		setIfPresent(&c.Postgres.AutoMigrate, p.AutoMigrate)
+		if err := setDurationIfPresent(&c.Postgres.ConnMaxLifetime, p.ConnMaxLifetime); err != nil {
+			return fmt.Errorf("invalid postgres.conn_max_lifetime: %w", err)
+		}
+		if err := setDurationIfPresent(&c.Postgres.ConnMaxIdleTime, p.ConnMaxIdleTime); err != nil {
+			return fmt.Errorf("invalid postgres.conn_max_idle_time: %w", err)
+		}
+		if p.Replicas != nil {
+			c.Postgres.Replicas = p.Replicas
+		}
+		if err := setDurationIfPresent(&c.Postgres.ReplicaRetryInterval, p.ReplicaRetryInterval); err != nil {
+			return fmt.Errorf("invalid postgres.replica_retry_interval: %w", err)
+		}
+		setIfPresent(&c.Postgres.ReadYourWrites, p.ReadYourWrites)
+		if err := setDurationIfPresent(&c.Postgres.PartitionInterval, p.PartitionInterval); err != nil {
+			return fmt.Errorf("invalid postgres.partition_interval: %w", err)
+		}
+		setIfPresent(&c.Postgres.PartitionsAhead, p.PartitionsAhead)
+		setIfPresent(&c.Postgres.HistoryRetentionMonths, p.HistoryRetentionMonths)
+		setIfPresent(&c.Postgres.ArchiveDir, p.ArchiveDir)
+	}
+
+	if s := f.SQLite; s != nil {
+		setIfPresent(&c.SQLite.Path, s.Path)
+		setIfPresent(&c.SQLite.AutoMigrate, s.AutoMigrate)
+	}
+
+	if m := f.Memory; m != nil {
+		setIfPresent(&c.Memory.DataDir, m.DataDir)
+		setIfPresent(&c.Memory.Fsync, m.Fsync)
+		if err := setDurationIfPresent(&c.Memory.FsyncInterval, m.FsyncInterval); err != nil {
+			return fmt.Errorf("invalid memory.fsync_interval: %w", err)
+		}
+		if err := setDurationIfPresent(&c.Memory.SnapshotInterval, m.SnapshotInterval); err != nil {
+			return fmt.Errorf("invalid memory.snapshot_interval: %w", err)
+		}
+	}
+
+	if r := f.Resilience; r != nil {
+		setIfPresent(&c.Resilience.RetryAttempts, r.RetryAttempts)
+		setIfPresent(&c.Resilience.BreakerThreshold, r.BreakerThreshold)
+		if err := setDurationIfPresent(&c.Resilience.RetryBackoff, r.RetryBackoff); err != nil {
+			return fmt.Errorf("invalid resilience.retry_backoff: %w", err)
+		}
+		if err := setDurationIfPresent(&c.Resilience.RetryMaxBackoff, r.RetryMaxBackoff); err != nil {
+			return fmt.Errorf("invalid resilience.retry_max_backoff: %w", err)
+		}
+		if err := setDurationIfPresent(&c.Resilience.BreakerTimeout, r.BreakerTimeout); err != nil {
+			return fmt.Errorf("invalid resilience.breaker_timeout: %w", err)
+		}
+	}
+
+	if r := f.Redis; r != nil {
+		setIfPresent(&c.Redis.Addr, r.Addr)
+		setIfPresent(&c.Redis.Password, r.Password)
+		setIfPresent(&c.Redis.DB, r.DB)
+		setIfPresent(&c.Redis.PoolSize, r.PoolSize)
+		setIfPresent(&c.Redis.KeyPrefix, r.KeyPrefix)
+		if err := setDurationIfPresent(&c.Redis.DialTimeout, r.DialTimeout); err != nil {
+			return fmt.Errorf("invalid redis.dial_timeout: %w", err)
+		}
+		if err := setDurationIfPresent(&c.Redis.ResyncInterval, r.ResyncInterval); err != nil {
+			return fmt.Errorf("invalid redis.resync_interval: %w", err)
+		}
+	}
+
+	if s := f.Segments; s != nil {
+		setIfPresent(&c.Segments.DefaultPageSize, s.DefaultPageSize)
+		setIfPresent(&c.Segments.MaxPageSize, s.MaxPageSize)
+		if err := setDurationIfPresent(&c.Segments.SchedulerInterval, s.SchedulerInterval); err != nil {
+			return fmt.Errorf("invalid segments.scheduler_interval: %w", err)
+		}
+		if err := setDurationIfPresent(&c.Segments.SizeHistoryInterval, s.SizeHistoryInterval); err != nil {
+			return fmt.Errorf("invalid segments.size_history_interval: %w", err)
+		}
+		setIfPresent(&c.Segments.CacheSize, s.CacheSize)
+		if err := setDurationIfPresent(&c.Segments.CacheTTL, s.CacheTTL); err != nil {
+			return fmt.Errorf("invalid segments.cache_ttl: %w", err)
+		}
+		setIfPresent(&c.Segments.MembershipStore, s.MembershipStore)
+		setIfPresent(&c.Segments.EventSourcing, s.EventSourcing)
+		setIfPresent(&c.Segments.SnapshotInterval, s.SnapshotInterval)
+	}
+
+	return nil
+}
+
+func setIfPresent[T any](dst *T, value *T) {
+	if value != nil {
+		*dst = *value
+	}
+}
+
+func setDurationIfPresent(dst *time.Duration, value *string) error {
+	if value == nil {
+		return nil
+	}
+	d, err := time.ParseDuration(*value)
+	if err != nil {
+		return err
+	}
+	*dst = d
+	return nil
+}
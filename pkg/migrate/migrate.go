@@ -0,0 +1,311 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"slices"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultLockID is the PostgreSQL advisory lock key held while migrating.
+const DefaultLockID int64 = 7_031_982_457
+
+var (
+	// ErrNoMigrationApplied is returned when rolling back with no applied migrations.
+	ErrNoMigrationApplied = errors.New("no migration has been applied")
+	// ErrIrreversible is returned when rolling back a migration without a down file.
+	ErrIrreversible = errors.New("migration cannot be rolled back")
+	// ErrUnknownVersion is returned when the database has a migration applied
+	// that is not known to the migrator, and Up returns it only for versions
+	// below the migrator's newest one.
+	ErrUnknownVersion = errors.New("database has an unknown migration applied")
+)
+
+// Status describes whether a migration has been applied.
+type Status struct {
+	Version   int64
+	Name      string
+	AppliedAt *time.Time
+}
+
+// Applied returns true if the migration has been applied.
+func (s Status) Applied() bool { return s.AppliedAt != nil }
+
+// Migrator applies and rolls back versioned migrations against PostgreSQL or
+// SQLite.
+//
+// On PostgreSQL, concurrent runners are serialized with a session-level
+// advisory lock, so several service instances may start at the same time
+// safely. SQLite has no such lock; each migration runs in a transaction of
+// its own, so a concurrent runner fails rather than applying it twice.
+type Migrator struct {
+	db         *sqlx.DB
+	migrations []Migration
+	lockID     int64
+}
+
+// New creates a Migrator for the migrations stored in fsys.
+func New(db *sqlx.DB, fsys fs.FS) (*Migrator, error) {
+	if db == nil {
+		return nil, errors.New("database is not provided")
+	}
+
+	migrations, err := Load(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{
+		db:         db,
+		migrations: migrations,
+		lockID:     DefaultLockID,
+	}, nil
+}
+
+// Status returns the state of every known migration.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	var statuses []Status
+	err := m.withLock(ctx, func(conn *sqlx.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		statuses = make([]Status, 0, len(m.migrations))
+		for _, mig := range m.migrations {
+			s := Status{Version: mig.Version, Name: mig.Name}
+			if appliedAt, ok := applied[mig.Version]; ok {
+				s.AppliedAt = &appliedAt
+			}
+			statuses = append(statuses, s)
+		}
+		return nil
+	})
+
+	return statuses, err
+}
+
+// Up applies all pending migrations and returns the ones that were applied.
+// Migrations newer than the migrator's newest one were applied by a newer
+// binary, such as during a rolling deploy, and are logged and left alone.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var done []Migration
+	err := m.withLock(ctx, func(conn *sqlx.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		newer, err := m.checkKnown(applied, true)
+		if err != nil {
+			return err
+		}
+		for _, version := range newer {
+			logrus.WithField("version", version).Warn("Database has a migration applied that is newer than this binary")
+		}
+
+		for _, mig := range m.migrations {
+			if _, ok := applied[mig.Version]; ok {
+				continue
+			}
+
+			if err := apply(ctx, conn, mig); err != nil {
+				return err
+			}
+			done = append(done, mig)
+		}
+		return nil
+	})
+
+	return done, err
+}
+
+// Down rolls back the most recently applied migration and returns it.
+func (m *Migrator) Down(ctx context.Context) (Migration, error) {
+	var done Migration
+	err := m.withLock(ctx, func(conn *sqlx.Conn) error {
+		mig, err := m.latestApplied(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		if err := rollback(ctx, conn, mig); err != nil {
+			return err
+		}
+		done = mig
+		return nil
+	})
+
+	return done, err
+}
+
+// Redo rolls back the most recently applied migration and applies it again.
+func (m *Migrator) Redo(ctx context.Context) (Migration, error) {
+	var done Migration
+	err := m.withLock(ctx, func(conn *sqlx.Conn) error {
+		mig, err := m.latestApplied(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		if err := rollback(ctx, conn, mig); err != nil {
+			return err
+		}
+		if err := apply(ctx, conn, mig); err != nil {
+			return err
+		}
+		done = mig
+		return nil
+	})
+
+	return done, err
+}
+
+func (m *Migrator) latestApplied(ctx context.Context, conn *sqlx.Conn) (Migration, error) {
+	applied, err := appliedVersions(ctx, conn)
+	if err != nil {
+		return Migration{}, err
+	}
+	// Rolling back under a newer migration could break it.
+	if _, err := m.checkKnown(applied, false); err != nil {
+		return Migration{}, err
+	}
+
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		if _, ok := applied[m.migrations[i].Version]; ok {
+			return m.migrations[i], nil
+		}
+	}
+
+	return Migration{}, ErrNoMigrationApplied
+}
+
+// checkKnown returns ErrUnknownVersion if a version in applied is unknown to
+// the migrator. With allowNewer, unknown versions above the newest migration
+// are returned in ascending order instead.
+func (m *Migrator) checkKnown(applied map[int64]time.Time, allowNewer bool) ([]int64, error) {
+	known := make(map[int64]struct{}, len(m.migrations))
+	for _, mig := range m.migrations {
+		known[mig.Version] = struct{}{}
+	}
+	var newest int64
+	if len(m.migrations) > 0 {
+		newest = m.migrations[len(m.migrations)-1].Version
+	}
+
+	var newer []int64
+	for version := range applied {
+		if _, ok := known[version]; ok {
+			continue
+		}
+		if !allowNewer || version < newest {
+			return nil, fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+		}
+		newer = append(newer, version)
+	}
+
+	slices.Sort(newer)
+	return newer, nil
+}
+
+// withLock runs fn on a dedicated connection holding the advisory lock.
+// Session-level advisory locks belong to a connection, so the lock, the
+// migrations and the unlock must all go through the same one.
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sqlx.Conn) error) (err error) {
+	conn, err := m.db.Connx(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire connection: %w", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	if m.db.DriverName() == "postgres" {
+		if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, m.lockID); err != nil {
+			return fmt.Errorf("failed to acquire migration lock: %w", err)
+		}
+		defer func() {
+			// Use a fresh context so the lock is released even if ctx is cancelled.
+			if _, unlockErr := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, m.lockID); unlockErr != nil && err == nil {
+				err = fmt.Errorf("failed to release migration lock: %w", unlockErr)
+			}
+		}()
+	}
+
+	if _, err := conn.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version BIGINT PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)
+	`); err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+
+	return fn(conn)
+}
+
+func appliedVersions(ctx context.Context, conn *sqlx.Conn) (map[int64]time.Time, error) {
+	var rows []struct {
+		Version   int64     `db:"version"`
+		AppliedAt time.Time `db:"applied_at"`
+	}
+	if err := conn.SelectContext(ctx, &rows, `SELECT version, applied_at FROM schema_migrations`); err != nil {
+		return nil, fmt.Errorf("failed to read applied migrations: %w", err)
+	}
+
+	applied := make(map[int64]time.Time, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = row.AppliedAt
+	}
+
+	return applied, nil
+}
+
+func apply(ctx context.Context, conn *sqlx.Conn, mig Migration) error {
+	return inTx(ctx, conn, func(tx *sqlx.Tx) error {
+		if _, err := tx.ExecContext(ctx, mig.Up); err != nil {
+			return fmt.Errorf("failed to apply migration %d_%s: %w", mig.Version, mig.Name, err)
+		}
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`,
+			mig.Version, mig.Name,
+		); err != nil {
+			return fmt.Errorf("failed to record migration %d_%s: %w", mig.Version, mig.Name, err)
+		}
+		return nil
+	})
+}
+
+func rollback(ctx context.Context, conn *sqlx.Conn, mig Migration) error {
+	if mig.Down == "" {
+		return fmt.Errorf("%w: %d_%s has no down file", ErrIrreversible, mig.Version, mig.Name)
+	}
+
+	return inTx(ctx, conn, func(tx *sqlx.Tx) error {
+		if _, err := tx.ExecContext(ctx, mig.Down); err != nil {
+			return fmt.Errorf("failed to roll back migration %d_%s: %w", mig.Version, mig.Name, err)
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = $1`, mig.Version); err != nil {
+			return fmt.Errorf("failed to unrecord migration %d_%s: %w", mig.Version, mig.Name, err)
+		}
+		return nil
+	})
+}
+
+func inTx(ctx context.Context, conn *sqlx.Conn, fn func(tx *sqlx.Tx) error) error {
+	tx, err := conn.BeginTxx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+
+	if err := fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
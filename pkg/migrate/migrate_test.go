@@ -0,0 +1,71 @@
+package migrate_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/rickKoch/nexus/pkg/migrate"
+	_ "modernc.org/sqlite" // SQLite driver
+)
+
+func TestMigratorUnknownVersions(t *testing.T) {
+	ctx := context.Background()
+	v1 := fstest.MapFS{
+		"0001_create_a.up.sql":   {Data: []byte("CREATE TABLE a (id INTEGER)")},
+		"0001_create_a.down.sql": {Data: []byte("DROP TABLE a")},
+		"0003_create_c.up.sql":   {Data: []byte("CREATE TABLE c (id INTEGER)")},
+		"0003_create_c.down.sql": {Data: []byte("DROP TABLE c")},
+	}
+
+	migrator := func(t *testing.T, db *sqlx.DB, fsys fstest.MapFS) *migrate.Migrator {
+		t.Helper()
+
+		m, err := migrate.New(db, fsys)
+		if err != nil {
+			t.Fatalf("failed to load migrations: %v", err)
+		}
+		return m
+	}
+	open := func(t *testing.T, applied fstest.MapFS) *sqlx.DB {
+		t.Helper()
+
+		db, err := sqlx.Open("sqlite", filepath.Join(t.TempDir(), "migrate.db"))
+		if err != nil {
+			t.Fatalf("failed to open database: %v", err)
+		}
+		t.Cleanup(func() { _ = db.Close() })
+		if _, err := migrator(t, db, applied).Up(ctx); err != nil {
+			t.Fatalf("failed to apply migrations: %v", err)
+		}
+		return db
+	}
+
+	t.Run("tolerates newer migrations", func(t *testing.T) {
+		newer := fstest.MapFS{"0004_create_d.up.sql": {Data: []byte("CREATE TABLE d (id INTEGER)")}}
+		for name, f := range v1 {
+			newer[name] = f
+		}
+		db := open(t, newer)
+
+		done, err := migrator(t, db, v1).Up(ctx)
+		if err != nil || len(done) != 0 {
+			t.Errorf("expected nothing to apply, got %v (%v)", done, err)
+		}
+		if _, err := migrator(t, db, v1).Down(ctx); !errors.Is(err, migrate.ErrUnknownVersion) {
+			t.Errorf("expected %v rolling back under a newer migration, got %v", migrate.ErrUnknownVersion, err)
+		}
+	})
+
+	t.Run("rejects unknown older migrations", func(t *testing.T) {
+		older := fstest.MapFS{"0002_create_b.up.sql": {Data: []byte("CREATE TABLE b (id INTEGER)")}}
+		db := open(t, older)
+
+		if _, err := migrator(t, db, v1).Up(ctx); !errors.Is(err, migrate.ErrUnknownVersion) {
+			t.Errorf("expected %v, got %v", migrate.ErrUnknownVersion, err)
+		}
+	})
+}
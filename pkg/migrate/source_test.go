@@ -0,0 +1,58 @@
+package migrate_test
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/rickKoch/nexus/pkg/migrate"
+)
+
+func TestLoad(t *testing.T) {
+	t.Run("orders migrations by version", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"0002_add_index.up.sql":    {Data: []byte("CREATE INDEX")},
+			"0002_add_index.down.sql":  {Data: []byte("DROP INDEX")},
+			"0001_create_table.up.sql": {Data: []byte("CREATE TABLE")},
+			"0010_irreversible.up.sql": {Data: []byte("UPDATE")},
+			"README.md":                {Data: []byte("ignored")},
+		}
+
+		got, err := migrate.Load(fsys)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if len(got) != 3 {
+			t.Fatalf("expected 3 migrations, got %d", len(got))
+		}
+		if got[0].Version != 1 || got[1].Version != 2 || got[2].Version != 10 {
+			t.Errorf("expected versions 1, 2, 10, got %d, %d, %d", got[0].Version, got[1].Version, got[2].Version)
+		}
+		if got[1].Name != "add_index" || got[1].Down != "DROP INDEX" {
+			t.Errorf("unexpected migration %+v", got[1])
+		}
+		if got[2].Down != "" {
+			t.Errorf("expected no down migration, got %q", got[2].Down)
+		}
+	})
+
+	t.Run("fails without up file", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"0001_create_table.down.sql": {Data: []byte("DROP TABLE")},
+		}
+
+		if _, err := migrate.Load(fsys); err == nil {
+			t.Error("expected error for missing up file")
+		}
+	})
+
+	t.Run("fails with invalid file name", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"create_table.sql": {Data: []byte("CREATE TABLE")},
+		}
+
+		if _, err := migrate.Load(fsys); err == nil {
+			t.Error("expected error for invalid file name")
+		}
+	})
+}
@@ -0,0 +1,298 @@
+// Package resp implements a minimal client for servers speaking the Redis
+// serialization protocol (RESP2), such as Redis, Valkey or KeyDB.
+package resp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// ErrClosed is returned when using a closed client.
+var ErrClosed = errors.New("resp: client closed")
+
+// Error is an error reply sent by the server.
+type Error string
+
+func (e Error) Error() string { return string(e) }
+
+// Config configures a Client.
+type Config struct {
+	Addr     string
+	Password string
+	// DB is the logical database selected on every connection.
+	DB int
+	// PoolSize is the number of idle connections kept for reuse.
+	PoolSize int
+	// DialTimeout bounds connecting and authenticating a new connection.
+	DialTimeout time.Duration
+}
+
+// Client sends commands over a pool of connections. It is safe for
+// concurrent use.
+//
+// Replies are decoded as string for simple and bulk strings, int64 for
+// integers, []any for arrays, Error for error replies and nil for null bulk
+// strings and arrays.
+type Client struct {
+	cfg    Config
+	idle   chan *conn
+	closed chan struct{}
+}
+
+// NewClient creates a client for the server at cfg.Addr. Connections are
+// opened on first use.
+func NewClient(cfg Config) *Client {
+	return &Client{
+		cfg:    cfg,
+		idle:   make(chan *conn, max(cfg.PoolSize, 1)),
+		closed: make(chan struct{}),
+	}
+}
+
+// Do sends a command and returns its reply. Error replies are returned as an
+// Error.
+func (c *Client) Do(ctx context.Context, args ...string) (any, error) {
+	replies, err := c.Pipeline(ctx, [][]string{args})
+	if err != nil {
+		return nil, err
+	}
+	if err, ok := replies[0].(Error); ok {
+		return nil, err
+	}
+	return replies[0], nil
+}
+
+// Pipeline sends commands in one round trip and returns their replies in
+// order. Error replies are returned as Error values among the replies rather
+// than failing the pipeline.
+func (c *Client) Pipeline(ctx context.Context, cmds [][]string) ([]any, error) {
+	cn, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	replies, err := cn.roundTrip(ctx, cmds)
+	if err != nil {
+		// The connection may hold a partial reply, so it is not reused.
+		_ = cn.Close()
+		return nil, err
+	}
+
+	c.put(cn)
+	return replies, nil
+}
+
+// Close closes the idle connections. Connections in use are closed when
+// released.
+func (c *Client) Close() error {
+	select {
+	case <-c.closed:
+		return nil
+	default:
+		close(c.closed)
+	}
+
+	for {
+		select {
+		case cn := <-c.idle:
+			_ = cn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+func (c *Client) get(ctx context.Context) (*conn, error) {
+	select {
+	case <-c.closed:
+		return nil, ErrClosed
+	case cn := <-c.idle:
+		return cn, nil
+	default:
+		return c.dial(ctx)
+	}
+}
+
+func (c *Client) put(cn *conn) {
+	select {
+	case <-c.closed:
+		_ = cn.Close()
+		return
+	default:
+	}
+
+	select {
+	case c.idle <- cn:
+	default:
+		_ = cn.Close()
+	}
+}
+
+func (c *Client) dial(ctx context.Context) (*conn, error) {
+	if c.cfg.DialTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.cfg.DialTimeout)
+		defer cancel()
+	}
+
+	var d net.Dialer
+	nc, err := d.DialContext(ctx, "tcp", c.cfg.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("resp: failed to connect to '%s': %w", c.cfg.Addr, err)
+	}
+	cn := &conn{Conn: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc)}
+
+	var setup [][]string
+	if c.cfg.Password != "" {
+		setup = append(setup, []string{"AUTH", c.cfg.Password})
+	}
+	if c.cfg.DB != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(c.cfg.DB)})
+	}
+	if len(setup) > 0 {
+		replies, err := cn.roundTrip(ctx, setup)
+		if err == nil {
+			err = firstError(replies)
+		}
+		if err != nil {
+			_ = cn.Close()
+			return nil, fmt.Errorf("resp: failed to set up connection: %w", err)
+		}
+	}
+
+	return cn, nil
+}
+
+// conn is a connection with buffered reads and writes.
+type conn struct {
+	net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+// roundTrip writes cmds and reads one reply for each. Cancelling ctx
+// interrupts blocked reads and writes.
+func (cn *conn) roundTrip(ctx context.Context, cmds [][]string) ([]any, error) {
+	deadline, _ := ctx.Deadline()
+	if err := cn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+	stop := context.AfterFunc(ctx, func() {
+		_ = cn.SetDeadline(time.Unix(1, 0))
+	})
+	defer stop()
+
+	for _, args := range cmds {
+		writeCommand(cn.w, args)
+	}
+	if err := cn.w.Flush(); err != nil {
+		return nil, contextError(ctx, err)
+	}
+
+	replies := make([]any, 0, len(cmds))
+	for range cmds {
+		reply, err := ReadReply(cn.r)
+		if err != nil {
+			return nil, contextError(ctx, err)
+		}
+		replies = append(replies, reply)
+	}
+	return replies, nil
+}
+
+// writeCommand encodes a command as an array of bulk strings.
+func writeCommand(w *bufio.Writer, args []string) {
+	w.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		w.WriteString("$" + strconv.Itoa(len(arg)) + "\r\n")
+		w.WriteString(arg)
+		w.WriteString("\r\n")
+	}
+}
+
+// ReadReply decodes one reply from r.
+func ReadReply(r *bufio.Reader) (any, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, errors.New("resp: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return Error(line[1:]), nil
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < -1 {
+			return nil, fmt.Errorf("resp: invalid bulk length '%s'", line[1:])
+		}
+		if n == -1 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < -1 {
+			return nil, fmt.Errorf("resp: invalid array length '%s'", line[1:])
+		}
+		if n == -1 {
+			return nil, nil
+		}
+		items := make([]any, 0, n)
+		for range n {
+			item, err := ReadReply(r)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("resp: unsupported reply type '%c'", line[0])
+	}
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", errors.New("resp: malformed line")
+	}
+	return line[:len(line)-2], nil
+}
+
+// firstError returns the first error reply among replies.
+func firstError(replies []any) error {
+	for _, reply := range replies {
+		if err, ok := reply.(Error); ok {
+			return err
+		}
+	}
+	return nil
+}
+
+// contextError prefers the context's error over the I/O error it caused.
+func contextError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
@@ -0,0 +1,122 @@
+package resp_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rickKoch/nexus/pkg/resp"
+	"github.com/rickKoch/nexus/pkg/resp/resptest"
+)
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+
+	setup := func(t *testing.T, password string, cfg resp.Config) (*resptest.Server, *resp.Client) {
+		t.Helper()
+
+		srv, err := resptest.NewServer(password)
+		if err != nil {
+			t.Fatalf("failed to start server: %v", err)
+		}
+		t.Cleanup(func() { _ = srv.Close() })
+
+		cfg.Addr = srv.Addr
+		client := resp.NewClient(cfg)
+		t.Cleanup(func() { _ = client.Close() })
+		return srv, client
+	}
+
+	t.Run("decodes replies", func(t *testing.T) {
+		_, client := setup(t, "", resp.Config{PoolSize: 1})
+
+		tests := []struct {
+			args     []string
+			expected any
+		}{
+			{[]string{"PING"}, "PONG"},
+			{[]string{"SADD", "set", "b", "a"}, int64(2)},
+			{[]string{"SMEMBERS", "set"}, []any{"a", "b"}},
+			{[]string{"ZADD", "zset", "+inf", "a", "1.5", "b"}, int64(2)},
+			{[]string{"ZSCORE", "zset", "a"}, "inf"},
+			{[]string{"ZSCORE", "zset", "missing"}, nil},
+		}
+		for _, tt := range tests {
+			reply, err := client.Do(ctx, tt.args...)
+			if err != nil {
+				t.Fatalf("expected no error for %v, got %v", tt.args, err)
+			}
+			if !reflect.DeepEqual(reply, tt.expected) {
+				t.Errorf("expected %#v for %v, got %#v", tt.expected, tt.args, reply)
+			}
+		}
+
+		var replyErr resp.Error
+		if _, err := client.Do(ctx, "ZSCORE", "set", "a"); !errors.As(err, &replyErr) {
+			t.Errorf("expected an error reply, got %v", err)
+		}
+	})
+
+	t.Run("pipelines commands", func(t *testing.T) {
+		srv, client := setup(t, "", resp.Config{PoolSize: 1})
+
+		replies, err := client.Pipeline(ctx, [][]string{
+			{"SET", "key", "value"},
+			{"UNKNOWN"},
+			{"GET", "key"},
+		})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if len(replies) != 3 || replies[0] != "OK" || replies[2] != "value" {
+			t.Errorf("expected [OK <error> value], got %#v", replies)
+		}
+		if _, ok := replies[1].(resp.Error); !ok {
+			t.Errorf("expected an error reply for the unknown command, got %#v", replies[1])
+		}
+
+		if n := srv.Commands("SET"); n != 1 {
+			t.Errorf("expected one SET, got %d", n)
+		}
+	})
+
+	t.Run("authenticates and selects the database", func(t *testing.T) {
+		srv, client := setup(t, "secret", resp.Config{Password: "secret", DB: 2, PoolSize: 1})
+		if _, err := client.Do(ctx, "SET", "key", "db2"); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		other := resp.NewClient(resp.Config{Addr: srv.Addr, Password: "secret"})
+		defer other.Close()
+		if reply, err := other.Do(ctx, "GET", "key"); err != nil || reply != nil {
+			t.Errorf("expected the default database not to hold the key, got %#v, %v", reply, err)
+		}
+
+		wrong := resp.NewClient(resp.Config{Addr: srv.Addr, Password: "wrong"})
+		defer wrong.Close()
+		if _, err := wrong.Do(ctx, "PING"); err == nil {
+			t.Error("expected the wrong password to be rejected")
+		}
+	})
+
+	t.Run("stops on cancellation", func(t *testing.T) {
+		_, client := setup(t, "", resp.Config{PoolSize: 1})
+
+		ctx, cancel := context.WithTimeout(ctx, time.Nanosecond)
+		defer cancel()
+		<-ctx.Done()
+		if _, err := client.Do(ctx, "PING"); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected %v, got %v", context.DeadlineExceeded, err)
+		}
+	})
+
+	t.Run("fails after close", func(t *testing.T) {
+		_, client := setup(t, "", resp.Config{})
+		_ = client.Close()
+		if _, err := client.Do(ctx, "PING"); !errors.Is(err, resp.ErrClosed) {
+			t.Errorf("expected %v, got %v", resp.ErrClosed, err)
+		}
+	})
+}
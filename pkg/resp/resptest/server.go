@@ -0,0 +1,456 @@
+// Package resptest provides an in-process RESP server for tests. It stores
+// data in memory and implements the subset of Redis commands the repository
+// uses, with Redis' reply types.
+package resptest
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rickKoch/nexus/pkg/resp"
+)
+
+// Server is an in-process RESP server listening on a loopback port.
+type Server struct {
+	// Addr is the host:port the server listens on.
+	Addr string
+
+	password string
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mu    sync.Mutex
+	dbs   map[int]map[string]any
+	conns map[net.Conn]bool
+	// commands counts the commands received, by name.
+	commands map[string]int
+	// failing holds the names of the commands answered with an error.
+	failing map[string]bool
+}
+
+// NewServer starts a server. Clients must authenticate with password unless
+// it is empty.
+func NewServer(password string) (*Server, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		Addr:     l.Addr().String(),
+		password: password,
+		listener: l,
+		dbs:      make(map[int]map[string]any),
+		conns:    make(map[net.Conn]bool),
+		commands: make(map[string]int),
+		failing:  make(map[string]bool),
+	}
+	s.wg.Go(s.serve)
+	return s, nil
+}
+
+// Close stops the server and closes its connections.
+func (s *Server) Close() error {
+	err := s.listener.Close()
+	s.mu.Lock()
+	for c := range s.conns {
+		_ = c.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+// Commands returns how many times the named command was received.
+func (s *Server) Commands(name string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.commands[strings.ToUpper(name)]
+}
+
+// Fail makes the server answer the named command with an error, or stops it
+// from doing so, to simulate a failing server.
+func (s *Server) Fail(name string, fail bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failing[strings.ToUpper(name)] = fail
+}
+
+func (s *Server) serve() {
+	for {
+		c, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		s.conns[c] = true
+		s.mu.Unlock()
+
+		s.wg.Go(func() {
+			defer func() {
+				s.mu.Lock()
+				delete(s.conns, c)
+				s.mu.Unlock()
+				_ = c.Close()
+			}()
+			s.handle(c)
+		})
+	}
+}
+
+// session is the state of one client connection.
+type session struct {
+	authenticated bool
+	db            int
+}
+
+func (s *Server) handle(c net.Conn) {
+	r := bufio.NewReader(c)
+	w := bufio.NewWriter(c)
+	sess := &session{authenticated: s.password == ""}
+
+	for {
+		req, err := resp.ReadReply(r)
+		if err != nil {
+			return
+		}
+		args, ok := commandArgs(req)
+		if !ok {
+			writeReply(w, resp.Error("ERR Protocol error: expected an array of bulk strings"))
+		} else {
+			writeReply(w, s.execute(sess, args))
+		}
+
+		// Flush once the pipelined commands already received are answered.
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func commandArgs(req any) ([]string, bool) {
+	items, ok := req.([]any)
+	if !ok || len(items) == 0 {
+		return nil, false
+	}
+	args := make([]string, 0, len(items))
+	for _, item := range items {
+		arg, ok := item.(string)
+		if !ok {
+			return nil, false
+		}
+		args = append(args, arg)
+	}
+	return args, true
+}
+
+// status is a simple string reply.
+type status string
+
+var errWrongType = resp.Error("WRONGTYPE Operation against a key holding the wrong kind of value")
+
+func (s *Server) execute(sess *session, args []string) any {
+	name := strings.ToUpper(args[0])
+	args = args[1:]
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.commands[name]++
+
+	if name == "AUTH" {
+		if len(args) != 1 || args[0] != s.password {
+			return resp.Error("WRONGPASS invalid username-password pair")
+		}
+		sess.authenticated = true
+		return status("OK")
+	}
+	if !sess.authenticated {
+		return resp.Error("NOAUTH Authentication required.")
+	}
+	if s.failing[name] {
+		return resp.Error("ERR simulated failure")
+	}
+
+	db := s.dbs[sess.db]
+	if db == nil {
+		db = make(map[string]any)
+		s.dbs[sess.db] = db
+	}
+
+	switch name {
+	case "PING":
+		return status("PONG")
+	case "SELECT":
+		if len(args) != 1 {
+			return arityError(name)
+		}
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 0 {
+			return resp.Error("ERR DB index is out of range")
+		}
+		sess.db = n
+		return status("OK")
+	case "DEL", "EXISTS":
+		if len(args) == 0 {
+			return arityError(name)
+		}
+		var n int64
+		for _, key := range args {
+			if _, ok := db[key]; ok {
+				n++
+				if name == "DEL" {
+					delete(db, key)
+				}
+			}
+		}
+		return n
+	case "GET":
+		if len(args) != 1 {
+			return arityError(name)
+		}
+		switch v := db[args[0]].(type) {
+		case nil:
+			return nil
+		case string:
+			return v
+		default:
+			return errWrongType
+		}
+	case "SET":
+		if len(args) != 2 {
+			return arityError(name)
+		}
+		db[args[0]] = args[1]
+		return status("OK")
+	case "SADD", "SREM":
+		if len(args) < 2 {
+			return arityError(name)
+		}
+		set, err := getSet(db, args[0])
+		if err != nil {
+			return err
+		}
+		var n int64
+		for _, m := range args[1:] {
+			if set[m] == (name == "SREM") {
+				n++
+			}
+			if name == "SADD" {
+				set[m] = true
+			} else {
+				delete(set, m)
+			}
+		}
+		storeOrDelete(db, args[0], set)
+		return n
+	case "SMEMBERS":
+		if len(args) != 1 {
+			return arityError(name)
+		}
+		set, err := getSet(db, args[0])
+		if err != nil {
+			return err
+		}
+		members := make([]any, 0, len(set))
+		for _, m := range slices.Sorted(maps.Keys(set)) {
+			members = append(members, m)
+		}
+		return members
+	case "ZADD":
+		if len(args) < 3 || len(args)%2 != 1 {
+			return arityError(name)
+		}
+		zset, err := getSortedSet(db, args[0])
+		if err != nil {
+			return err
+		}
+		var n int64
+		for i := 1; i < len(args); i += 2 {
+			score, err := strconv.ParseFloat(args[i], 64)
+			if err != nil || math.IsNaN(score) {
+				return resp.Error("ERR value is not a valid float")
+			}
+			if _, ok := zset[args[i+1]]; !ok {
+				n++
+			}
+			zset[args[i+1]] = score
+		}
+		storeOrDelete(db, args[0], zset)
+		return n
+	case "ZREM":
+		if len(args) < 2 {
+			return arityError(name)
+		}
+		zset, err := getSortedSet(db, args[0])
+		if err != nil {
+			return err
+		}
+		var n int64
+		for _, m := range args[1:] {
+			if _, ok := zset[m]; ok {
+				n++
+				delete(zset, m)
+			}
+		}
+		storeOrDelete(db, args[0], zset)
+		return n
+	case "ZSCORE":
+		if len(args) != 2 {
+			return arityError(name)
+		}
+		zset, err := getSortedSet(db, args[0])
+		if err != nil {
+			return err
+		}
+		score, ok := zset[args[1]]
+		if !ok {
+			return nil
+		}
+		return formatScore(score)
+	case "ZCARD":
+		if len(args) != 1 {
+			return arityError(name)
+		}
+		zset, err := getSortedSet(db, args[0])
+		if err != nil {
+			return err
+		}
+		return int64(len(zset))
+	case "ZREMRANGEBYSCORE":
+		if len(args) != 3 {
+			return arityError(name)
+		}
+		zset, err := getSortedSet(db, args[0])
+		if err != nil {
+			return err
+		}
+		inRange, ok := scoreRange(args[1], args[2])
+		if !ok {
+			return resp.Error("ERR min or max is not a float")
+		}
+		var n int64
+		for m, score := range zset {
+			if inRange(score) {
+				n++
+				delete(zset, m)
+			}
+		}
+		storeOrDelete(db, args[0], zset)
+		return n
+	case "FLUSHALL":
+		clear(s.dbs)
+		return status("OK")
+	default:
+		return resp.Error(fmt.Sprintf("ERR unknown command '%s'", name))
+	}
+}
+
+func arityError(name string) resp.Error {
+	return resp.Error(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(name)))
+}
+
+func getSet(db map[string]any, key string) (map[string]bool, error) {
+	switch v := db[key].(type) {
+	case nil:
+		return make(map[string]bool), nil
+	case map[string]bool:
+		return v, nil
+	default:
+		return nil, errWrongType
+	}
+}
+
+func getSortedSet(db map[string]any, key string) (map[string]float64, error) {
+	switch v := db[key].(type) {
+	case nil:
+		return make(map[string]float64), nil
+	case map[string]float64:
+		return v, nil
+	default:
+		return nil, errWrongType
+	}
+}
+
+// storeOrDelete stores a collection, deleting the key once it is empty like
+// Redis does.
+func storeOrDelete[V any](db map[string]any, key string, collection map[string]V) {
+	if len(collection) == 0 {
+		delete(db, key)
+		return
+	}
+	db[key] = collection
+}
+
+// scoreRange parses the bounds of a score range, where a "(" prefix makes a
+// bound exclusive.
+func scoreRange(minArg, maxArg string) (func(score float64) bool, bool) {
+	bound := func(arg string) (float64, bool, error) {
+		exclusive := strings.HasPrefix(arg, "(")
+		v, err := strconv.ParseFloat(strings.TrimPrefix(arg, "("), 64)
+		return v, exclusive, err
+	}
+	lo, loExclusive, err := bound(minArg)
+	if err != nil {
+		return nil, false
+	}
+	hi, hiExclusive, err := bound(maxArg)
+	if err != nil {
+		return nil, false
+	}
+
+	return func(score float64) bool {
+		if score < lo || (loExclusive && score == lo) {
+			return false
+		}
+		return score < hi || (!hiExclusive && score == hi)
+	}, true
+}
+
+// formatScore formats a score the way Redis replies with it.
+func formatScore(score float64) string {
+	switch {
+	case math.IsInf(score, 1):
+		return "inf"
+	case math.IsInf(score, -1):
+		return "-inf"
+	default:
+		return strconv.FormatFloat(score, 'g', 17, 64)
+	}
+}
+
+// writeReply encodes a status or a reply of one of the types decoded by
+// resp.ReadReply.
+func writeReply(w *bufio.Writer, reply any) {
+	switch v := reply.(type) {
+	case nil:
+		w.WriteString("$-1\r\n")
+	case status:
+		w.WriteString("+" + string(v) + "\r\n")
+	case resp.Error:
+		w.WriteString("-" + string(v) + "\r\n")
+	case int64:
+		w.WriteString(":" + strconv.FormatInt(v, 10) + "\r\n")
+	case string:
+		w.WriteString("$" + strconv.Itoa(len(v)) + "\r\n" + v + "\r\n")
+	case []any:
+		w.WriteString("*" + strconv.Itoa(len(v)) + "\r\n")
+		for _, item := range v {
+			writeReply(w, item)
+		}
+	default:
+		panic(errors.New("resptest: unsupported reply type"))
+	}
+}
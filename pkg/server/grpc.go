@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"runtime/debug"
+	"time"
+
+	"github.com/rickKoch/nexus/pkg/config"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+)
+
+// RunGRPCServer serves gRPC until ctx is cancelled, then stops gracefully.
+// Unary calls pass through interceptors after being logged and guarded
+// against panics.
+func RunGRPCServer(ctx context.Context, cfg config.GRPCConfig, registerServer func(server *grpc.Server), interceptors ...grpc.UnaryServerInterceptor) error {
+	return RunGRPCServerOnAddr(ctx, cfg.Addr(), cfg.Reflection, registerServer, interceptors...)
+}
+
+func RunGRPCServerOnAddr(ctx context.Context, addr string, enableReflection bool, registerServer func(server *grpc.Server), interceptors ...grpc.UnaryServerInterceptor) error {
+	grpcServer := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(append([]grpc.UnaryServerInterceptor{
+			unaryLoggingInterceptor,
+			unaryRecoveryInterceptor,
+		}, interceptors...)...),
+	)
+	registerServer(grpcServer)
+	if enableReflection {
+		reflection.Register(grpcServer)
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	logrus.WithField("addr", addr).Info("Starting gRPC server")
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- grpcServer.Serve(listener)
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	logrus.Info("Shutting down gRPC server")
+
+	stopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(shutdownTimeout):
+		grpcServer.Stop()
+	}
+
+	return <-errCh
+}
+
+func unaryLoggingInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+
+	logrus.WithFields(logrus.Fields{
+		"method":   info.FullMethod,
+		"code":     status.Code(err).String(),
+		"duration": time.Since(start),
+	}).Info("gRPC request")
+
+	return resp, err
+}
+
+func unaryRecoveryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			logrus.WithField("method", info.FullMethod).Errorf("panic: %v\n%s", r, debug.Stack())
+			err = status.Error(codes.Internal, "internal error")
+		}
+	}()
+
+	return handler(ctx, req)
+}
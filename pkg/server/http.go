@@ -1,45 +1,76 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"net/http"
-	"os"
-	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
+	"github.com/rickKoch/nexus/pkg/config"
 	"github.com/sirupsen/logrus"
 )
 
-func RunHTTPServer(createHandler func(router chi.Router) http.Handler) {
-	RunHTTPServerOnAddr(":"+os.Getenv("PORT"), createHandler)
+// shutdownTimeout bounds how long in-flight requests may take to finish
+// once shutdown has started.
+const shutdownTimeout = 15 * time.Second
+
+// RunHTTPServer serves the API until ctx is cancelled, then shuts down gracefully.
+func RunHTTPServer(ctx context.Context, cfg config.HTTPConfig, createHandler func(router chi.Router) http.Handler) error {
+	return RunHTTPServerOnAddr(ctx, cfg.Addr(), cfg.CORSAllowedOrigins, createHandler)
 }
 
-func RunHTTPServerOnAddr(addr string, createHandler func(router chi.Router) http.Handler) {
+func RunHTTPServerOnAddr(ctx context.Context, addr string, allowedOrigins []string, createHandler func(router chi.Router) http.Handler) error {
 	apiRouter := chi.NewRouter()
-	setMiddlewares(apiRouter)
+	setMiddlewares(apiRouter, allowedOrigins)
 
 	rootRouter := chi.NewRouter()
 	rootRouter.Mount("/api", createHandler(apiRouter))
 
-	logrus.Info("Starting HTTP server")
+	srv := &http.Server{
+		Addr:    addr,
+		Handler: rootRouter,
+	}
+
+	logrus.WithField("addr", addr).Info("Starting HTTP server")
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	logrus.Info("Shutting down HTTP server")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
 
-	err := http.ListenAndServe(addr, rootRouter)
-	if err != nil {
-		logrus.WithError(err).Panic("Unable to start HTTP server")
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return err
 	}
+	if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
 }
 
-func setMiddlewares(router *chi.Mux) {
+func setMiddlewares(router *chi.Mux, allowedOrigins []string) {
 	router.Use(middleware.RequestID)
 	router.Use(middleware.Logger)
 	router.Use(middleware.Recoverer)
-	addCorsMiddleware(router)
+	addCorsMiddleware(router, allowedOrigins)
 }
 
-func addCorsMiddleware(router *chi.Mux) {
-	allowedOrigins := strings.Split(os.Getenv("CORS_ALLOWED_ORIGINS"), ";")
-	if len(allowedOrigins) == 1 && allowedOrigins[0] == "" {
+func addCorsMiddleware(router *chi.Mux, allowedOrigins []string) {
+	if len(allowedOrigins) == 0 {
 		return
 	}
 
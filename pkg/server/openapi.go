@@ -0,0 +1,121 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+	"github.com/sirupsen/logrus"
+)
+
+// OpenAPIValidatorOptions configures the OpenAPI validation middleware.
+type OpenAPIValidatorOptions struct {
+	// ValidateResponses makes the middleware buffer every response and replace
+	// it with a 500 when it does not match the spec. It is meant for
+	// development and tests, where drift between handlers and the spec should
+	// fail loudly.
+	ValidateResponses bool
+}
+
+// OpenAPIValidator returns a middleware validating requests, and optionally
+// responses, against doc. Requests to paths missing from the spec pass through
+// untouched so that they are answered by the router as usual.
+func OpenAPIValidator(doc *openapi3.T, opts OpenAPIValidatorOptions) (func(http.Handler) http.Handler, error) {
+	router, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build OpenAPI router: %w", err)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route, pathParams, err := router.FindRoute(r)
+			if err != nil {
+				if errors.Is(err, routers.ErrPathNotFound) || errors.Is(err, routers.ErrMethodNotAllowed) {
+					next.ServeHTTP(w, r)
+					return
+				}
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			requestInput := &openapi3filter.RequestValidationInput{
+				Request:    r,
+				PathParams: pathParams,
+				Route:      route,
+				Options: &openapi3filter.Options{
+					MultiError: true,
+				},
+			}
+			if err := openapi3filter.ValidateRequest(r.Context(), requestInput); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			if !opts.ValidateResponses {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			rec := &responseRecorder{header: make(http.Header), status: http.StatusOK}
+			next.ServeHTTP(rec, r)
+
+			responseInput := &openapi3filter.ResponseValidationInput{
+				RequestValidationInput: requestInput,
+				Status:                 rec.status,
+				Header:                 rec.header,
+				Body:                   io.NopCloser(bytes.NewReader(rec.body.Bytes())),
+				Options: &openapi3filter.Options{
+					MultiError:            true,
+					IncludeResponseStatus: true,
+					// Encoded bodies, such as gzip-compressed exports, cannot
+					// be decoded against the schema.
+					ExcludeResponseBody: rec.header.Get("Content-Encoding") != "",
+				},
+			}
+			if err := openapi3filter.ValidateResponse(r.Context(), responseInput); err != nil {
+				logrus.WithError(err).WithField("path", r.URL.Path).Error("Response does not match the OpenAPI spec")
+				http.Error(w, "response does not match the OpenAPI spec: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			rec.flush(w)
+		})
+	}, nil
+}
+
+// responseRecorder buffers a response so it can be validated before being sent.
+type responseRecorder struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (r *responseRecorder) Header() http.Header { return r.header }
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.wroteHeader {
+		return
+	}
+	r.status = status
+	r.wroteHeader = true
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.WriteHeader(http.StatusOK)
+	return r.body.Write(b)
+}
+
+func (r *responseRecorder) flush(w http.ResponseWriter) {
+	for key, values := range r.header {
+		w.Header()[key] = values
+	}
+	w.WriteHeader(r.status)
+	_, _ = w.Write(r.body.Bytes())
+}